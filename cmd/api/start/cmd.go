@@ -11,9 +11,12 @@ var Cmd = &cli.Command{
 	Usage: "Start the API Server",
 	Flags: []cli.Flag{
 		&cli.StringFlag{
-			Name:     "key",
-			Usage:    "Server's secret key (hex-encoded, must match KDC database)",
-			Required: true,
+			Name:  "key",
+			Usage: "Server's secret key (hex-encoded, must match KDC database; mutually exclusive with --keytab)",
+		},
+		&cli.StringFlag{
+			Name:  "keytab",
+			Usage: "Keytab holding the server's keys, from `kadmin ktadd` (mutually exclusive with --key)",
 		},
 		&cli.StringFlag{
 			Name:  "port",
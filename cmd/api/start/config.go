@@ -9,6 +9,7 @@ import (
 type Config struct {
 	Port         string
 	ServerKeyHex string
+	KeytabPath   string
 	ReplayWindow time.Duration
 }
 
@@ -20,6 +21,7 @@ func newConfig(cmd *cli.Command) Config {
 	return Config{
 		Port:         port,
 		ServerKeyHex: cmd.String("key"),
+		KeytabPath:   cmd.String("keytab"),
 		ReplayWindow: 5 * time.Minute,
 	}
 }
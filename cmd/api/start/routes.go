@@ -2,6 +2,7 @@ package start
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/rizesql/kerberos/internal/ap"
 	"github.com/rizesql/kerberos/internal/server"
@@ -20,10 +21,18 @@ func (r *WhoAmIRoute) Handle() http.HandlerFunc {
 			return
 		}
 
-		if err := server.Encode(w, http.StatusOK, map[string]string{
+		res := map[string]string{
 			"authenticated_as": client.String(),
 			"message":          "Welcome to the protected resource!",
-		}); err != nil {
+		}
+		if claims, ok := ap.ClaimsFromContext(r.Context()); ok {
+			res["groups"] = strings.Join(claims.Groups(), ",")
+		}
+		if creds, ok := ap.DelegatedFromContext(r.Context()); ok {
+			res["delegated"] = creds[0].Info.Server().String()
+		}
+
+		if err := server.Encode(w, http.StatusOK, res); err != nil {
 			server.EncodeError(w, http.StatusInternalServerError, err)
 			return
 		}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/rizesql/kerberos/internal/ap"
 	"github.com/rizesql/kerberos/internal/clock"
+	"github.com/rizesql/kerberos/internal/keytab"
 	"github.com/rizesql/kerberos/internal/o11y/logging"
 	"github.com/rizesql/kerberos/internal/protocol"
 	"github.com/rizesql/kerberos/internal/replay"
@@ -30,27 +31,26 @@ func Run(ctx context.Context, cfg Config) error {
 		}
 	}()
 
-	// Server's secret key (must match what's in KDC database for http/api-server)
-	serverKeyBytes, err := hex.DecodeString(cfg.ServerKeyHex)
-	if err != nil {
-		return fmt.Errorf("invalid server key: %w", err)
-	}
-	serverKey, err := protocol.NewSessionKey(serverKeyBytes)
-	if err != nil {
-		return fmt.Errorf("failed to create session key: %w", err)
-	}
-
 	// Create replay cache and verifier
 	cache := replay.NewInMemoryCache(cfg.ReplayWindow, clk)
-	verifier := ap.NewVerifier(serverKey, clk, cache)
+	verifier, err := newVerifier(cfg, clk, cache)
+	if err != nil {
+		return err
+	}
 
 	// Create server
 	srv := server.New(logger)
 	shutdowns.RegisterCtx(srv.Shutdown)
 
-	// Register protected routes with Kerberos middleware
-	srv.Register(&WhoAmIRoute{}, ap.Middleware(verifier))
-	srv.Register(&SecretRoute{}, ap.Middleware(verifier))
+	addr, err := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	if err != nil {
+		return err
+	}
+
+	// Register protected routes with Kerberos middleware. Clients that accept
+	// KRB-PRIV get their responses sealed under the session key.
+	srv.Register(&WhoAmIRoute{}, ap.Middleware(verifier), ap.PrivMiddleware(verifier, addr))
+	srv.Register(&SecretRoute{}, ap.Middleware(verifier), ap.PrivMiddleware(verifier, addr))
 
 	// Start listening
 	ln, err := net.Listen("tcp", cfg.Port)
@@ -75,3 +75,30 @@ func Run(ctx context.Context, cfg Config) error {
 	logger.Info("Server shutdown complete")
 	return nil
 }
+
+// newVerifier verifies tickets with the keys of the keytab or, failing one,
+// the hex server key. Both must match the KDC database.
+func newVerifier(cfg Config, clk clock.Clock, cache replay.Cache) (*ap.Verifier, error) {
+	switch {
+	case cfg.KeytabPath != "" && cfg.ServerKeyHex != "":
+		return nil, fmt.Errorf("cannot specify both --key and --keytab")
+	case cfg.KeytabPath != "":
+		kt, err := keytab.Load(cfg.KeytabPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load keytab: %w", err)
+		}
+		return ap.NewKeytabVerifier(kt, clk, cache), nil
+	case cfg.ServerKeyHex != "":
+		serverKeyBytes, err := hex.DecodeString(cfg.ServerKeyHex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server key: %w", err)
+		}
+		serverKey, err := protocol.NewSessionKey(serverKeyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create session key: %w", err)
+		}
+		return ap.NewVerifier(serverKey, clk, cache), nil
+	default:
+		return nil, fmt.Errorf("must specify either --keytab or --key")
+	}
+}
@@ -0,0 +1,251 @@
+package kinit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/ccache"
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/keytab"
+	"github.com/rizesql/kerberos/internal/pkinit"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/rizesql/kerberos/internal/sdk"
+	"github.com/urfave/cli/v3"
+)
+
+var Cmd = &cli.Command{
+	Name:      "kinit",
+	Usage:     "Get a TGT for a principal with its keys from a keytab, such as a host's TGT to armor logins with, or with its certificate",
+	ArgsUsage: "principal",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "kdc",
+			Usage: "KDC Address (e.g. http://localhost:8080)",
+			Value: "http://localhost:8080",
+		},
+		&cli.StringFlag{
+			Name:  "keytab",
+			Usage: "Keytab holding the keys of the principal (mutually exclusive with --cert)",
+		},
+		&cli.StringFlag{
+			Name:  "cert",
+			Usage: "PEM certificate chain of the principal, to pre-authenticate with PKINIT",
+		},
+		&cli.StringFlag{
+			Name:  "key",
+			Usage: "PEM private key of the certificate",
+		},
+		&cli.StringFlag{
+			Name:  "kdc-ca",
+			Usage: "PEM bundle of the CAs the KDC certificate is trusted from",
+		},
+		&cli.StringFlag{
+			Name:     "ccache",
+			Usage:    "Credential cache file to write the TGT to (e.g. /tmp/krb5cc_host)",
+			Required: true,
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		if cmd.Args().Len() != 1 {
+			return fmt.Errorf("must specify exactly one principal")
+		}
+
+		primary, instance, realm, err := protocol.Parse(cmd.Args().First())
+		if err != nil {
+			return fmt.Errorf("invalid principal %q: %w", cmd.Args().First(), err)
+		}
+		if realm == "" {
+			return fmt.Errorf("principal %q must name its realm", cmd.Args().First())
+		}
+		client, err := protocol.NewPrincipal(primary, instance, realm)
+		if err != nil {
+			return err
+		}
+
+		s := sdk.New(sdk.WithServerUrl(cmd.String("kdc")))
+
+		var tgt protocol.EncryptedData
+		var repPart protocol.EncKDCRepPart
+		switch {
+		case cmd.String("keytab") != "" && cmd.String("cert") != "":
+			return fmt.Errorf("cannot specify both --keytab and --cert")
+		case cmd.String("keytab") != "":
+			kt, err := keytab.Load(cmd.String("keytab"))
+			if err != nil {
+				return fmt.Errorf("failed to read keytab: %w", err)
+			}
+
+			tgt, repPart, err = kinit(ctx, s, kt, client)
+			if err != nil {
+				return err
+			}
+		case cmd.String("cert") != "":
+			if cmd.String("key") == "" || cmd.String("kdc-ca") == "" {
+				return fmt.Errorf("--cert needs --key and --kdc-ca")
+			}
+
+			cert, err := pkinit.LoadCertificate(cmd.String("cert"), cmd.String("key"))
+			if err != nil {
+				return fmt.Errorf("failed to read certificate: %w", err)
+			}
+			roots, err := pkinit.LoadRoots(cmd.String("kdc-ca"))
+			if err != nil {
+				return fmt.Errorf("failed to read KDC CAs: %w", err)
+			}
+
+			tgt, repPart, err = kinitPKINIT(ctx, s, pkinit.Config{Certificate: cert, Roots: roots}, client)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("must specify either --keytab or --cert")
+		}
+
+		creds := ccache.New(client)
+		creds.Add(ccache.NewCredential(client, tgt, repPart))
+		path := strings.TrimPrefix(cmd.String("ccache"), "FILE:")
+		if err := creds.Write(path); err != nil {
+			return fmt.Errorf("failed to write credential cache: %w", err)
+		}
+
+		fmt.Printf("Got a TGT for %s, valid until %s, in %s\n", client, repPart.EndTime().Format(time.RFC3339), path)
+		return nil
+	},
+}
+
+// kinit runs the AS exchange for client, pre-authenticating with the key of
+// kt the KDC asks for.
+func kinit(ctx context.Context, s *sdk.Sdk, kt *keytab.Keytab, client protocol.Principal) (protocol.EncryptedData, protocol.EncKDCRepPart, error) {
+	req, err := newASReq(client)
+	if err != nil {
+		return protocol.EncryptedData{}, protocol.EncKDCRepPart{}, err
+	}
+
+	// The KDC answers the first request with the encryption type of the key
+	// it expects the timestamp under.
+	_, err = s.Kdc.PostAS(ctx, req)
+	var krbErr protocol.KRBError
+	if !errors.As(err, &krbErr) || !errors.Is(krbErr, protocol.KDCErrPreauthRequired) {
+		if err == nil {
+			return protocol.EncryptedData{}, protocol.EncKDCRepPart{}, fmt.Errorf("kdc issued a ticket without pre-authentication")
+		}
+		return protocol.EncryptedData{}, protocol.EncKDCRepPart{}, fmt.Errorf("invalid kdc response: %w", err)
+	}
+
+	methodData, err := krbErr.MethodData()
+	if err != nil {
+		return protocol.EncryptedData{}, protocol.EncKDCRepPart{}, fmt.Errorf("invalid pre-authentication hints: %w", err)
+	}
+
+	info, ok, err := methodData.ETypeInfo2()
+	if err != nil || !ok || len(info) == 0 {
+		return protocol.EncryptedData{}, protocol.EncKDCRepPart{}, fmt.Errorf("kdc did not say which key to use")
+	}
+
+	keys := kt.Keys(client, 0, false, info[0].EncType())
+	if len(keys) == 0 {
+		return protocol.EncryptedData{}, protocol.EncKDCRepPart{}, fmt.Errorf("keytab holds no %s key of %s", info[0].EncType(), client)
+	}
+	key := keys[0].Key
+
+	encTimestamp, err := shared.NewEncTimestamp(codec.JSON, key, time.Now())
+	if err != nil {
+		return protocol.EncryptedData{}, protocol.EncKDCRepPart{}, fmt.Errorf("failed to build pre-authentication: %w", err)
+	}
+
+	rep, err := s.Kdc.PostAS(ctx, req.WithPAData(encTimestamp))
+	if err != nil {
+		return protocol.EncryptedData{}, protocol.EncKDCRepPart{}, fmt.Errorf("pre-authentication rejected: %w", err)
+	}
+
+	return openReply(rep, key, req.Nonce())
+}
+
+// kinitPKINIT runs the AS exchange for client, pre-authenticating with its
+// certificate and agreeing on the reply key with the KDC.
+func kinitPKINIT(ctx context.Context, s *sdk.Sdk, cfg pkinit.Config, client protocol.Principal) (protocol.EncryptedData, protocol.EncKDCRepPart, error) {
+	req, err := newASReq(client)
+	if err != nil {
+		return protocol.EncryptedData{}, protocol.EncKDCRepPart{}, err
+	}
+
+	body, err := shared.ASReqBody(codec.JSON, req)
+	if err != nil {
+		return protocol.EncryptedData{}, protocol.EncKDCRepPart{}, err
+	}
+
+	pa, dhKey, err := pkinit.NewRequest(codec.JSON, cfg.Certificate, body, req.Nonce(), time.Now())
+	if err != nil {
+		return protocol.EncryptedData{}, protocol.EncKDCRepPart{}, fmt.Errorf("failed to build pre-authentication: %w", err)
+	}
+
+	rep, err := s.Kdc.PostAS(ctx, req.WithPAData(pa))
+	if err != nil {
+		return protocol.EncryptedData{}, protocol.EncKDCRepPart{}, fmt.Errorf("pre-authentication rejected: %w", err)
+	}
+
+	pkRep, ok := rep.PAData().Find(protocol.PATypePKASRep)
+	if !ok {
+		return protocol.EncryptedData{}, protocol.EncKDCRepPart{}, fmt.Errorf("kdc reply carries no PA-PK-AS-REP")
+	}
+
+	key, err := pkinit.OpenReply(pkRep, cfg.Roots, client.Realm(), dhKey, req.Nonce(), rep.SecretPart().EncType(), time.Now())
+	if err != nil {
+		return protocol.EncryptedData{}, protocol.EncKDCRepPart{}, fmt.Errorf("invalid kdc reply: %w", err)
+	}
+
+	return openReply(rep, key, req.Nonce())
+}
+
+// newASReq builds a request for a TGT of client, offering every encryption
+// type this client supports.
+func newASReq(client protocol.Principal) (protocol.ASReq, error) {
+	tgs, err := protocol.NewKrbtgt(client.Realm())
+	if err != nil {
+		return protocol.ASReq{}, err
+	}
+
+	nonce, err := protocol.NewNonce(rand.Int32N(math.MaxInt32) + 1)
+	if err != nil {
+		return protocol.ASReq{}, err
+	}
+
+	addr, err := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	if err != nil {
+		return protocol.ASReq{}, err
+	}
+
+	req, err := protocol.NewASReq(client, tgs, addr, nonce)
+	if err != nil {
+		return protocol.ASReq{}, err
+	}
+	return req.WithETypes(crypto.SupportedEncTypes...), nil
+}
+
+// openReply opens the reply part of rep under key and checks that it
+// answers the request with nonce.
+func openReply(rep *protocol.ASRep, key protocol.SessionKey, nonce protocol.Nonce) (protocol.EncryptedData, protocol.EncKDCRepPart, error) {
+	repBytes, err := crypto.Decrypt(key, crypto.KeyUsageASRepEncPart, rep.SecretPart().Ciphertext())
+	if err != nil {
+		return protocol.EncryptedData{}, protocol.EncKDCRepPart{}, fmt.Errorf("failed to decrypt the reply: %w", err)
+	}
+
+	var repPart protocol.EncKDCRepPart
+	if err := json.Unmarshal(repBytes, &repPart); err != nil {
+		return protocol.EncryptedData{}, protocol.EncKDCRepPart{}, fmt.Errorf("invalid reply part: %w", err)
+	}
+	if repPart.Nonce() != nonce {
+		return protocol.EncryptedData{}, protocol.EncKDCRepPart{}, sdk.ErrNonceMismatch
+	}
+
+	return rep.Ticket(), repPart, nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/rizesql/kerberos/cmd/client/kinit"
 	"github.com/rizesql/kerberos/cmd/client/start"
 	"github.com/urfave/cli/v3"
 )
@@ -15,6 +16,7 @@ func main() {
 		Usage: "Kerberos Client with Web UI",
 		Commands: []*cli.Command{
 			start.Cmd,
+			kinit.Cmd,
 		},
 	}
 
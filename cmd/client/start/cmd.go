@@ -20,11 +20,24 @@ var Cmd = &cli.Command{
 			Usage: "KDC Address (e.g. http://localhost:8080)",
 			Value: "http://localhost:8080",
 		},
+		&cli.StringSliceFlag{
+			Name:  "realm-kdc",
+			Usage: "KDC address of another realm, for following referrals (e.g. SALES.EXAMPLE.COM=http://localhost:8081)",
+		},
 		&cli.StringFlag{
 			Name:  "web",
 			Usage: "Web directory path",
 			Value: "./cmd/client/web",
 		},
+		&cli.StringFlag{
+			Name:    "ccache",
+			Usage:   "Credential cache file to keep tickets in across restarts (e.g. /tmp/krb5cc_1000)",
+			Sources: cli.EnvVars("KRB5CCNAME"),
+		},
+		&cli.StringFlag{
+			Name:  "armor-ccache",
+			Usage: "Credential cache with a host TGT to armor logins and ticket requests with FAST (e.g. /tmp/krb5cc_host)",
+		},
 	},
 	Action: func(ctx context.Context, cmd *cli.Command) error {
 		return Run(ctx, newConfig(cmd))
@@ -8,6 +8,14 @@ type Config struct {
 	Port    string
 	KDCAddr string
 	WebDir  string
+	// RealmKDCs lists the KDCs of other realms as REALM=URL.
+	RealmKDCs []string
+	// CCache is the credential cache file tickets are kept in; empty keeps
+	// them in memory only.
+	CCache string
+	// ArmorCCache is the credential cache holding a host TGT, from
+	// `client kinit`, to armor requests with FAST; empty sends them bare.
+	ArmorCCache string
 }
 
 func newConfig(cmd *cli.Command) Config {
@@ -27,5 +35,9 @@ func newConfig(cmd *cli.Command) Config {
 		Port:    port,
 		KDCAddr: kdcAddr,
 		WebDir:  webDir,
+
+		RealmKDCs:   cmd.StringSlice("realm-kdc"),
+		CCache:      cmd.String("ccache"),
+		ArmorCCache: cmd.String("armor-ccache"),
 	}
 }
@@ -7,11 +7,15 @@ import (
 type Platform struct {
 	Sdk   *sdk.Sdk
 	Cache *TicketCache
+	// ArmorCCache is the credential cache holding the host TGT that logins
+	// are armored with, or empty to log in without FAST.
+	ArmorCCache string
 }
 
-func NewPlatform(sdk *sdk.Sdk, ticketCache *TicketCache) *Platform {
+func NewPlatform(sdk *sdk.Sdk, ticketCache *TicketCache, armorCCache string) *Platform {
 	return &Platform{
-		Sdk:   sdk,
-		Cache: ticketCache,
+		Sdk:         sdk,
+		Cache:       ticketCache,
+		ArmorCCache: armorCCache,
 	}
 }
@@ -1,100 +1,189 @@
 package platform
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"sync"
 
+	"github.com/rizesql/kerberos/internal/ccache"
 	"github.com/rizesql/kerberos/internal/protocol"
 )
 
-// TicketCache stores the TGT and service tickets in memory
+// TicketCache stores the TGT and service tickets of the logged in client,
+// with their session keys. When it has a path, every change is written to
+// that credential cache file, so a restarted client stays logged in.
 type TicketCache struct {
 	mu sync.RWMutex
 
-	// TGT (Ticket Granting Ticket) - stored as EncryptedData
-	tgt *protocol.EncryptedData
+	// Credentials of the client principal (alice@ATHENA.MIT.EDU); nil
+	// until someone logs in.
+	creds *ccache.CCache
 
-	// Session key from AS-REP (for encrypting TGS authenticator)
-	tgtSessionKey *protocol.SessionKey
+	// path is the FILE: credential cache, or empty to keep tickets in
+	// memory only.
+	path string
+}
 
-	// Client principal (alice@ATHENA.MIT.EDU)
-	clientPrincipal protocol.Principal
+func NewTicketCache() *TicketCache {
+	return &TicketCache{}
+}
 
-	// Service tickets keyed by service name (e.g., "http/api-server")
-	serviceTickets map[string]*protocol.EncryptedData
+// NewFileTicketCache returns a cache persisted at path, starting with the
+// credentials already there.
+func NewFileTicketCache(path string) (*TicketCache, error) {
+	tc := &TicketCache{path: path}
 
-	// Service session keys keyed by service name
-	serviceSessionKeys map[string]*protocol.SessionKey
+	creds, err := ccache.Load(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return tc, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credential cache: %w", err)
+	}
+	tc.creds = creds
+	return tc, nil
 }
 
-func NewTicketCache() *TicketCache {
-	return &TicketCache{
-		serviceTickets:     make(map[string]*protocol.EncryptedData),
-		serviceSessionKeys: make(map[string]*protocol.SessionKey),
-	}
+// StoreTGT starts the cache over for client with the TGT from an AS
+// exchange, as logging in again replaces the credentials of whoever was
+// logged in.
+func (tc *TicketCache) StoreTGT(client protocol.Principal, tgt protocol.EncryptedData, repPart protocol.EncKDCRepPart) error {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	creds := ccache.New(client)
+	creds.Add(ccache.NewCredential(client, tgt, repPart))
+	return tc.save(creds)
 }
 
-// StoreTGT stores the TGT
-func (tc *TicketCache) StoreTGT(tgt protocol.EncryptedData) {
+// RenewTGT replaces the cached TGT with a renewed one.
+func (tc *TicketCache) RenewTGT(tgt protocol.EncryptedData, repPart protocol.EncKDCRepPart) error {
 	tc.mu.Lock()
 	defer tc.mu.Unlock()
-	tc.tgt = &tgt
+
+	if tc.creds == nil {
+		return fmt.Errorf("not logged in")
+	}
+	return tc.add(ccache.NewCredential(tc.creds.Principal(), tgt, repPart))
 }
 
-// StoreTGTWithSession stores the TGT along with session key and client principal
-func (tc *TicketCache) StoreTGTWithSession(tgt protocol.EncryptedData, sessionKey protocol.SessionKey, client protocol.Principal) {
+// StoreServiceTicket stores a ticket to service, which it is looked up by
+// afterwards even when referrals had it issued by another realm.
+func (tc *TicketCache) StoreServiceTicket(service protocol.Principal, ticket protocol.EncryptedData, repPart protocol.EncKDCRepPart) error {
 	tc.mu.Lock()
 	defer tc.mu.Unlock()
-	tc.tgt = &tgt
-	tc.tgtSessionKey = &sessionKey
-	tc.clientPrincipal = client
+
+	if tc.creds == nil {
+		return fmt.Errorf("not logged in")
+	}
+	cred := ccache.NewCredential(tc.creds.Principal(), ticket, repPart)
+	cred.Server = service
+	return tc.add(cred)
+}
+
+func (tc *TicketCache) add(cred ccache.Credential) error {
+	creds := ccache.New(tc.creds.Principal())
+	for _, c := range tc.creds.Credentials() {
+		creds.Add(c)
+	}
+	creds.Add(cred)
+	return tc.save(creds)
+}
+
+// save writes creds to the file, if any, before making them current, so
+// that the cache in memory never holds tickets the file lost.
+func (tc *TicketCache) save(creds *ccache.CCache) error {
+	if tc.path != "" {
+		if err := creds.Write(tc.path); err != nil {
+			return fmt.Errorf("failed to write credential cache: %w", err)
+		}
+	}
+	tc.creds = creds
+	return nil
+}
+
+func (tc *TicketCache) tgt() (ccache.Credential, bool) {
+	if tc.creds == nil {
+		return ccache.Credential{}, false
+	}
+	krbtgt, err := protocol.NewKrbtgt(tc.creds.Principal().Realm())
+	if err != nil {
+		return ccache.Credential{}, false
+	}
+	return tc.creds.Get(krbtgt)
 }
 
 // GetTGT retrieves the stored TGT
 func (tc *TicketCache) GetTGT() *protocol.EncryptedData {
 	tc.mu.RLock()
 	defer tc.mu.RUnlock()
-	return tc.tgt
+	cred, ok := tc.tgt()
+	if !ok {
+		return nil
+	}
+	return &cred.Ticket
 }
 
-// GetTGTSessionKey retrieves the session key from AS-REP
+// GetTGTSessionKey retrieves the session key of the TGT
 func (tc *TicketCache) GetTGTSessionKey() *protocol.SessionKey {
 	tc.mu.RLock()
 	defer tc.mu.RUnlock()
-	return tc.tgtSessionKey
+	cred, ok := tc.tgt()
+	if !ok {
+		return nil
+	}
+	return &cred.Key
 }
 
 // GetClientPrincipal retrieves the client principal
 func (tc *TicketCache) GetClientPrincipal() protocol.Principal {
 	tc.mu.RLock()
 	defer tc.mu.RUnlock()
-	return tc.clientPrincipal
-}
-
-// StoreServiceTicket stores a service ticket
-func (tc *TicketCache) StoreServiceTicket(service string, ticket protocol.EncryptedData) {
-	tc.mu.Lock()
-	defer tc.mu.Unlock()
-	tc.serviceTickets[service] = &ticket
+	if tc.creds == nil {
+		return protocol.Principal{}
+	}
+	return tc.creds.Principal()
 }
 
-// StoreServiceTicketWithSession stores a service ticket with its session key
-func (tc *TicketCache) StoreServiceTicketWithSession(service string, ticket protocol.EncryptedData, sessionKey protocol.SessionKey) {
-	tc.mu.Lock()
-	defer tc.mu.Unlock()
-	tc.serviceTickets[service] = &ticket
-	tc.serviceSessionKeys[service] = &sessionKey
+// service looks up the credential for a service name such as
+// "http/api-server", in the client's realm unless it names another.
+func (tc *TicketCache) service(name string) (ccache.Credential, bool) {
+	if tc.creds == nil {
+		return ccache.Credential{}, false
+	}
+	primary, instance, realm, err := protocol.Parse(name)
+	if err != nil {
+		return ccache.Credential{}, false
+	}
+	if realm == "" {
+		realm = tc.creds.Principal().Realm()
+	}
+	server, err := protocol.NewPrincipal(primary, instance, realm)
+	if err != nil {
+		return ccache.Credential{}, false
+	}
+	return tc.creds.Get(server)
 }
 
 // GetServiceTicket retrieves a service ticket
 func (tc *TicketCache) GetServiceTicket(service string) *protocol.EncryptedData {
 	tc.mu.RLock()
 	defer tc.mu.RUnlock()
-	return tc.serviceTickets[service]
+	cred, ok := tc.service(service)
+	if !ok {
+		return nil
+	}
+	return &cred.Ticket
 }
 
 // GetServiceSessionKey retrieves the session key for a service
 func (tc *TicketCache) GetServiceSessionKey(service string) *protocol.SessionKey {
 	tc.mu.RLock()
 	defer tc.mu.RUnlock()
-	return tc.serviceSessionKeys[service]
+	cred, ok := tc.service(service)
+	if !ok {
+		return nil
+	}
+	return &cred.Key
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -11,12 +12,18 @@ import (
 	"time"
 
 	"github.com/rizesql/kerberos/cmd/client/start/platform"
+	"github.com/rizesql/kerberos/internal/ap"
+	"github.com/rizesql/kerberos/internal/clock"
+	"github.com/rizesql/kerberos/internal/codec"
 	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
 	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/rizesql/kerberos/internal/sdk"
 	"github.com/rizesql/kerberos/internal/server"
 )
 
 type handler struct {
+	sdk   *sdk.Sdk
 	cache *platform.TicketCache
 }
 
@@ -24,6 +31,7 @@ type handler struct {
 // Calls protected Api Server endpoint
 func NewHandler(platform *platform.Platform) *handler {
 	return &handler{
+		sdk:   platform.Sdk,
 		cache: platform.Cache,
 	}
 }
@@ -32,7 +40,8 @@ func (*handler) Method() string { return http.MethodPost }
 func (*handler) Path() string   { return "/api/call" }
 
 type request struct {
-	URL string `json:"url"` // e.g., "http://localhost:9090/api/whoami"
+	URL      string `json:"url"`      // e.g., "http://localhost:9090/api/whoami"
+	Delegate bool   `json:"delegate"` // forward a TGT to the service
 }
 
 func (h *handler) Handle() http.HandlerFunc {
@@ -45,7 +54,12 @@ func (h *handler) Handle() http.HandlerFunc {
 
 		respBody, statusCode, err := h.callService(req.Context(), body)
 		if err != nil {
-			server.EncodeError(w, http.StatusInternalServerError, err)
+			// A KRB-ERROR means the service refused to authenticate us.
+			status := http.StatusInternalServerError
+			if errors.As(err, new(protocol.KRBError)) {
+				status = http.StatusUnauthorized
+			}
+			server.EncodeError(w, status, err)
 			return
 		}
 
@@ -90,7 +104,7 @@ func (h *handler) callService(ctx context.Context, req request) ([]byte, int, er
 		return nil, 0, err
 	}
 
-	encryptedAuthBytes, err := crypto.Encrypt(*serviceSessionKey, authBytes)
+	encryptedAuthBytes, err := crypto.Encrypt(*serviceSessionKey, crypto.KeyUsageAPReqAuth, authBytes)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to encrypt authenticator: %w", err)
 	}
@@ -104,6 +118,15 @@ func (h *handler) callService(ctx context.Context, req request) ([]byte, int, er
 	if err != nil {
 		return nil, 0, err
 	}
+	apReq = apReq.WithOptions(protocol.APOptMutualRequired)
+
+	if req.Delegate {
+		cred, err := h.forwardTGT(ctx, *serviceSessionKey)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to delegate credentials: %w", err)
+		}
+		apReq = apReq.WithCred(cred)
+	}
 
 	// 3. Serialize AP-REQ
 	apReqBytes, err := json.Marshal(apReq)
@@ -119,14 +142,33 @@ func (h *handler) callService(ctx context.Context, req request) ([]byte, int, er
 
 	httpReq.Header.Set("Authorization", "Kerberos "+base64.StdEncoding.EncodeToString(apReqBytes))
 
-	client := &http.Client{}
+	// Seal the exchange in KRB-PRIV so it stays confidential over plain HTTP.
+	client := &http.Client{
+		Transport: sdk.NewPrivTransport(http.DefaultTransport, *serviceSessionKey, addr, clock.New()),
+	}
 	resp, err := client.Do(httpReq)
 	if err != nil {
+		var krbErr protocol.KRBError
+		if errors.As(err, &krbErr) {
+			return nil, 0, fmt.Errorf("service rejected the AP-REQ: %w", krbErr)
+		}
 		return nil, 0, fmt.Errorf("service unreachable: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// 5. Return service response
+	// 5. Make sure we talked to the real service: only it can open the
+	// ticket and echo our authenticator back in the AP-REP. The service
+	// sends one with every response, errors included; the AP layer's own
+	// 401 KRB-ERROR, the only response without one, came back as err.
+	apRep, err := ap.DecodeReply(resp.Header.Get(ap.ReplyHeader))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", ap.ErrMutualAuthFailed, err)
+	}
+	if _, err := ap.VerifyReply(*serviceSessionKey, apRep, authenticator.IssuedAt()); err != nil {
+		return nil, 0, err
+	}
+
+	// 6. Return service response
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, 0, err
@@ -134,3 +176,77 @@ func (h *handler) callService(ctx context.Context, req request) ([]byte, int, er
 
 	return respBody, resp.StatusCode, nil
 }
+
+// forwardTGT asks the KDC for a FORWARDED copy of the cached TGT and wraps it
+// in a KRB-CRED sealed under the service session key.
+func (h *handler) forwardTGT(ctx context.Context, serviceSessionKey protocol.SessionKey) (protocol.KRBCred, error) {
+	tgt := h.cache.GetTGT()
+	if tgt == nil {
+		return protocol.KRBCred{}, fmt.Errorf("not logged in")
+	}
+
+	sessionKey := h.cache.GetTGTSessionKey()
+	if sessionKey == nil {
+		return protocol.KRBCred{}, fmt.Errorf("no session key")
+	}
+
+	clientPrincipal := h.cache.GetClientPrincipal()
+
+	tgsPrincipal, err := protocol.NewKrbtgt(clientPrincipal.Realm())
+	if err != nil {
+		return protocol.KRBCred{}, err
+	}
+
+	addr, err := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	if err != nil {
+		return protocol.KRBCred{}, err
+	}
+
+	nonce, err := protocol.NewNonce(int32(time.Now().UnixNano()%100000 + 1))
+	if err != nil {
+		return protocol.KRBCred{}, err
+	}
+
+	tgsReq, err := protocol.NewTGSReq(tgsPrincipal, *tgt, protocol.EncryptedData{}, nonce)
+	if err != nil {
+		return protocol.KRBCred{}, err
+	}
+	tgsReq = tgsReq.
+		WithOptions(protocol.OptForwarded | protocol.OptForwardable).
+		WithClientAddr(addr)
+
+	creds, err := h.sdk.Kdc.TGSExchange(ctx, sdk.Credentials{
+		Client:     clientPrincipal,
+		Server:     tgsPrincipal,
+		Ticket:     *tgt,
+		SessionKey: *sessionKey,
+	}, tgsReq, addr)
+	if err != nil {
+		return protocol.KRBCred{}, fmt.Errorf("invalid kdc response: %w", err)
+	}
+	repPart := creds.RepPart
+
+	info, err := protocol.NewKRBCredInfo(
+		repPart.SessionKey(),
+		clientPrincipal,
+		repPart.Server(),
+		repPart.IssuedAt(),
+		repPart.Lifetime(),
+	)
+	if err != nil {
+		return protocol.KRBCred{}, err
+	}
+	info = info.WithFlags(repPart.Flags()).WithRenewTill(repPart.RenewTill())
+
+	encPart, err := protocol.NewEncKRBCredPart(info)
+	if err != nil {
+		return protocol.KRBCred{}, err
+	}
+
+	encCredPart, err := shared.EncryptEntity(codec.JSON, serviceSessionKey, crypto.KeyUsageKRBCredEncPart, encPart)
+	if err != nil {
+		return protocol.KRBCred{}, err
+	}
+
+	return protocol.NewKRBCred([]protocol.EncryptedData{creds.Ticket}, encCredPart)
+}
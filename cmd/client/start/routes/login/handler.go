@@ -4,29 +4,35 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"time"
 
 	"github.com/rizesql/kerberos/cmd/client/start/platform"
+	"github.com/rizesql/kerberos/internal/ccache"
+	"github.com/rizesql/kerberos/internal/codec"
 	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
 	"github.com/rizesql/kerberos/internal/protocol"
 	"github.com/rizesql/kerberos/internal/sdk"
 	"github.com/rizesql/kerberos/internal/server"
 )
 
 type handler struct {
-	sdk   *sdk.Sdk
-	cache *platform.TicketCache
+	sdk         *sdk.Sdk
+	cache       *platform.TicketCache
+	armorCCache string
 }
 
 // LoginRoute - POST /api/login
 // Calls KDC AS Exchange, stores TGT
 func NewHandler(platform *platform.Platform) *handler {
 	return &handler{
-		sdk:   platform.Sdk,
-		cache: platform.Cache,
+		sdk:         platform.Sdk,
+		cache:       platform.Cache,
+		armorCCache: platform.ArmorCCache,
 	}
 }
 
@@ -92,21 +98,75 @@ func (h *handler) login(ctx context.Context, req request) (*response, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Ask for a renewable TGT so it can be refreshed without the password,
+	// and a forwardable one so it can be delegated to services.
+	asReq = asReq.
+		WithOptions(protocol.OptRenewable | protocol.OptForwardable).
+		WithETypes(crypto.SupportedEncTypes...)
+
+	// 2. Probe the KDC: it answers with the pre-authentication methods and
+	// how to derive the client key from the password.
+	_, _, err = h.postAS(ctx, asReq, addr)
+	var krbErr protocol.KRBError
+	if !errors.As(err, &krbErr) || !errors.Is(krbErr, protocol.KDCErrPreauthRequired) {
+		if err == nil {
+			return nil, fmt.Errorf("kdc issued a ticket without pre-authentication")
+		}
+		return nil, fmt.Errorf("invalid kdc response: %w", err)
+	}
 
-	asRep, err := h.sdk.Kdc.PostAS(ctx, asReq)
+	methodData, err := krbErr.MethodData()
 	if err != nil {
-		return nil, fmt.Errorf("invalid kdc response: %w", err)
+		return nil, fmt.Errorf("invalid pre-authentication hints: %w", err)
 	}
 
-	// 2. Derive key from password using correct salt (realm + primary + instance)
-	salt := "ATHENA.MIT.EDU" + req.Username // realm + primary + instance (empty for alice)
-	clientKey, err := crypto.DeriveKey(req.Password, salt)
+	info, ok, err := methodData.ETypeInfo2()
+	if err != nil {
+		return nil, fmt.Errorf("invalid pre-authentication hints: %w", err)
+	}
+	if !ok || len(info) == 0 {
+		return nil, fmt.Errorf("kdc did not say how to derive the client key")
+	}
+
+	// The KDC lists the key it expects first. Without a salt, the key was
+	// derived with the default one; its params pick the KDF, PBKDF2 or
+	// Argon2id, and how costly it is.
+	salt, ok := info[0].Salt()
+	if !ok {
+		salt = client.DefaultSalt()
+	}
+
+	// The hints are not authenticated without FAST, so their cost is held
+	// to the client's policy before anything is derived from the password.
+	if err := crypto.DefaultS2KPolicy.Check(info[0].S2KParams()); err != nil {
+		return nil, fmt.Errorf("refusing the kdc's key derivation: %w", err)
+	}
+
+	clientKey, err := crypto.StringToKey(info[0].EncType(), req.Password, salt, info[0].S2KParams())
 	if err != nil {
 		return nil, fmt.Errorf("key derivation failed: %w", err)
 	}
 
-	// 3. Decrypt SecretPart to get session key
-	secretPartBytes, err := crypto.Decrypt(clientKey, asRep.SecretPart().Ciphertext())
+	encTimestamp, err := shared.NewEncTimestamp(codec.JSON, clientKey, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to build pre-authentication: %w", err)
+	}
+
+	asRep, strengthenKey, err := h.postAS(ctx, asReq.WithPAData(encTimestamp), addr)
+	if err != nil {
+		return nil, fmt.Errorf("pre-authentication rejected (wrong password?): %w", err)
+	}
+
+	// 3. Decrypt SecretPart to get session key. Under FAST the KDC mixed a
+	// key of its own into the reply key.
+	replyKey := clientKey
+	if !strengthenKey.IsZero() {
+		if replyKey, err = shared.StrengthenReplyKey(strengthenKey, clientKey); err != nil {
+			return nil, err
+		}
+	}
+
+	secretPartBytes, err := crypto.Decrypt(replyKey, crypto.KeyUsageASRepEncPart, asRep.SecretPart().Ciphertext())
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt session key (wrong password?): %w", err)
 	}
@@ -119,7 +179,9 @@ func (h *handler) login(ctx context.Context, req request) (*response, error) {
 	sessionKey := encRepPart.SessionKey()
 
 	// 4. Store TGT with session key and client principal in cache
-	h.cache.StoreTGTWithSession(asRep.Ticket(), sessionKey, client)
+	if err := h.cache.StoreTGT(client, asRep.Ticket(), encRepPart); err != nil {
+		return nil, err
+	}
 
 	return &response{
 		Status:       "logged_in",
@@ -128,3 +190,42 @@ func (h *handler) login(ctx context.Context, req request) (*response, error) {
 		SessionKey:   base64.StdEncoding.EncodeToString(sessionKey.Expose()),
 	}, nil
 }
+
+// postAS sends req, armored with FAST when the client has a host TGT to
+// armor it with. It returns the strengthen key of an armored reply.
+func (h *handler) postAS(ctx context.Context, req protocol.ASReq, addr protocol.Address) (*protocol.ASRep, protocol.SessionKey, error) {
+	if h.armorCCache == "" {
+		rep, err := h.sdk.Kdc.PostAS(ctx, req)
+		return rep, protocol.SessionKey{}, err
+	}
+
+	creds, err := ccache.Load(h.armorCCache)
+	if err != nil {
+		return nil, protocol.SessionKey{}, fmt.Errorf("failed to read armor credential cache: %w", err)
+	}
+
+	krbtgt, err := protocol.NewKrbtgt(creds.Principal().Realm())
+	if err != nil {
+		return nil, protocol.SessionKey{}, err
+	}
+
+	tgt, ok := creds.Get(krbtgt)
+	if !ok {
+		return nil, protocol.SessionKey{}, fmt.Errorf("armor credential cache holds no TGT")
+	}
+
+	// Each request gets an armor of its own, as the KDC refuses a replayed
+	// authenticator.
+	armor, armorKey, err := shared.NewFastArmor(codec.JSON, tgt.Ticket, tgt.Key, tgt.Client, addr, time.Now().UTC())
+	if err != nil {
+		return nil, protocol.SessionKey{}, fmt.Errorf("failed to build FAST armor: %w", err)
+	}
+
+	rep, res, err := h.sdk.Kdc.PostFastAS(ctx, req, armor, armorKey)
+	if err != nil {
+		return nil, protocol.SessionKey{}, err
+	}
+
+	strengthenKey, _ := res.StrengthenKey()
+	return rep, strengthenKey, nil
+}
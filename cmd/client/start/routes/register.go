@@ -4,12 +4,14 @@ import (
 	"github.com/rizesql/kerberos/cmd/client/start/platform"
 	call_api "github.com/rizesql/kerberos/cmd/client/start/routes/call_api"
 	"github.com/rizesql/kerberos/cmd/client/start/routes/login"
+	"github.com/rizesql/kerberos/cmd/client/start/routes/renew"
 	"github.com/rizesql/kerberos/cmd/client/start/routes/ticket"
 	"github.com/rizesql/kerberos/internal/server"
 )
 
 func Register(srv *server.Server, platform *platform.Platform) {
 	srv.Register(login.NewHandler(platform))
+	srv.Register(renew.NewHandler(platform))
 	srv.Register(ticket.NewHandler(platform))
 	srv.Register(call_api.NewHandler(platform))
 }
@@ -0,0 +1,113 @@
+package renew
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rizesql/kerberos/cmd/client/start/platform"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/rizesql/kerberos/internal/sdk"
+	"github.com/rizesql/kerberos/internal/server"
+)
+
+type handler struct {
+	sdk   *sdk.Sdk
+	cache *platform.TicketCache
+}
+
+// RenewRoute - POST /api/renew
+// Calls KDC TGS Exchange with the RENEW option, replaces the cached TGT
+func NewHandler(platform *platform.Platform) *handler {
+	return &handler{
+		sdk:   platform.Sdk,
+		cache: platform.Cache,
+	}
+}
+
+func (*handler) Method() string { return http.MethodPost }
+func (*handler) Path() string   { return "/api/renew" }
+
+type response struct {
+	Status    string    `json:"status"`
+	User      string    `json:"user"`
+	EndTime   time.Time `json:"end_time"`
+	RenewTill time.Time `json:"renew_till"`
+}
+
+func (h *handler) Handle() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		res, err := h.renew(req.Context())
+		if err != nil {
+			server.EncodeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if err := server.Encode(w, http.StatusOK, res); err != nil {
+			server.EncodeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+}
+
+func (h *handler) renew(ctx context.Context) (*response, error) {
+	// 1. Get TGT and session key from cache
+	tgt := h.cache.GetTGT()
+	if tgt == nil {
+		return nil, fmt.Errorf("not logged in")
+	}
+
+	sessionKey := h.cache.GetTGTSessionKey()
+	if sessionKey == nil {
+		return nil, fmt.Errorf("no session key")
+	}
+
+	clientPrincipal := h.cache.GetClientPrincipal()
+
+	tgsPrincipal, err := protocol.NewKrbtgt(clientPrincipal.Realm())
+	if err != nil {
+		return nil, fmt.Errorf("invalid krbtgt: %w", err)
+	}
+
+	addr, err := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := protocol.NewNonce(int32(time.Now().UnixNano()%100000 + 1))
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. Ask the TGS to renew the TGT for itself, authenticating with the
+	// current TGT session key
+	tgsReq, err := protocol.NewTGSReq(tgsPrincipal, *tgt, protocol.EncryptedData{}, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := h.sdk.Kdc.TGSExchange(ctx, sdk.Credentials{
+		Client:     clientPrincipal,
+		Server:     tgsPrincipal,
+		Ticket:     *tgt,
+		SessionKey: *sessionKey,
+	}, tgsReq.WithOptions(protocol.OptRenew), addr)
+	if err != nil {
+		return nil, fmt.Errorf("renewal rejected: %w", err)
+	}
+	encRepPart := creds.RepPart
+
+	// 3. Replace the cached TGT
+	if err := h.cache.RenewTGT(creds.Ticket, encRepPart); err != nil {
+		return nil, err
+	}
+
+	return &response{
+		Status:    "renewed",
+		User:      clientPrincipal.String(),
+		EndTime:   encRepPart.IssuedAt().Add(encRepPart.Lifetime()),
+		RenewTill: encRepPart.RenewTill(),
+	}, nil
+}
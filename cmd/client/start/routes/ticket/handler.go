@@ -3,14 +3,11 @@ package ticket
 import (
 	"context"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
-	"time"
 
 	"github.com/rizesql/kerberos/cmd/client/start/platform"
-	"github.com/rizesql/kerberos/internal/crypto"
 	"github.com/rizesql/kerberos/internal/protocol"
 	"github.com/rizesql/kerberos/internal/sdk"
 	"github.com/rizesql/kerberos/internal/server"
@@ -78,80 +75,49 @@ func (h *handler) getTicket(ctx context.Context, req request) (*response, error)
 
 	clientPrincipal := h.cache.GetClientPrincipal()
 
-	// 2. Parse service principal
-	if req.Service != "http/api-server" {
-		return nil, fmt.Errorf("unsupported service: %s", req.Service)
-	}
-
-	servicePrincipal, err := protocol.NewPrincipal("http", "api-server", "ATHENA.MIT.EDU")
-	if err != nil {
-		return nil, fmt.Errorf("invalid service: %w", err)
-	}
-
-	// 3. Create authenticator with REAL client principal
-	addr, err := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
-	if err != nil {
-		return nil, err
-	}
-
-	timestamp := time.Now()
-
-	authenticator, err := protocol.NewAuthenticator(clientPrincipal, addr, timestamp)
-	if err != nil {
-		return nil, err
-	}
-
-	nonce, err := protocol.NewNonce(int32(time.Now().UnixNano()%100000 + 1))
+	tgsPrincipal, err := protocol.NewKrbtgt(clientPrincipal.Realm())
 	if err != nil {
 		return nil, err
 	}
 
-	// 4. ENCRYPT authenticator with session key from AS-REP
-	authBytes, err := json.Marshal(authenticator)
+	// 2. Parse service principal; it defaults to the client's realm.
+	primary, instance, realm, err := protocol.Parse(req.Service)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid service: %w", err)
 	}
-
-	encryptedAuthBytes, err := crypto.Encrypt(*sessionKey, authBytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encrypt authenticator: %w", err)
+	if realm == "" {
+		realm = clientPrincipal.Realm()
 	}
 
-	encAuth, err := protocol.NewEncryptedData(encryptedAuthBytes)
+	servicePrincipal, err := protocol.NewPrincipal(primary, instance, realm)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid service: %w", err)
 	}
 
-	// 5. Build TGS-REQ
-	tgsReq, err := protocol.NewTGSReq(servicePrincipal, *tgt, encAuth, nonce)
+	addr, err := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
 	if err != nil {
 		return nil, err
 	}
 
-	tgsRep, err := h.sdk.Kdc.PostTGS(ctx, tgsReq)
+	// 3-6. TGS exchange, following referral TGTs into other realms
+	creds, err := h.sdk.Kdc.ServiceTicket(ctx, sdk.Credentials{
+		Client:     clientPrincipal,
+		Server:     tgsPrincipal,
+		Ticket:     *tgt,
+		SessionKey: *sessionKey,
+	}, servicePrincipal, addr, 0)
 	if err != nil {
 		return nil, fmt.Errorf("invalid kdc response: %w", err)
 	}
 
-	// 6. Decrypt SecretPart to get service session key
-	serviceSecretBytes, err := crypto.Decrypt(*sessionKey, tgsRep.SecretPart().Ciphertext())
-	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt service session key: %w", err)
-	}
-
-	var serviceEncRepPart protocol.EncKDCRepPart
-	if err := json.Unmarshal(serviceSecretBytes, &serviceEncRepPart); err != nil {
-		return nil, fmt.Errorf("invalid service secret part format: %w", err)
-	}
-
-	serviceSessionKey := serviceEncRepPart.SessionKey()
-
 	// 7. Store service ticket WITH session key in cache
-	h.cache.StoreServiceTicketWithSession(req.Service, tgsRep.Ticket(), serviceSessionKey)
+	if err := h.cache.StoreServiceTicket(servicePrincipal, creds.Ticket, creds.RepPart); err != nil {
+		return nil, err
+	}
 
 	return &response{
 		Status:  "ticket_obtained",
 		Service: req.Service,
-		Ticket:  base64.StdEncoding.EncodeToString(tgsRep.Ticket().Ciphertext()),
+		Ticket:  base64.StdEncoding.EncodeToString(creds.Ticket.Ciphertext()),
 	}, nil
 }
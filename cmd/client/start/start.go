@@ -6,10 +6,12 @@ import (
 	"net"
 	"net/http"
 	"runtime/debug"
+	"strings"
 
 	"github.com/rizesql/kerberos/cmd/client/start/platform"
 	"github.com/rizesql/kerberos/cmd/client/start/routes"
 	"github.com/rizesql/kerberos/internal/o11y/logging"
+	"github.com/rizesql/kerberos/internal/protocol"
 	"github.com/rizesql/kerberos/internal/sdk"
 	"github.com/rizesql/kerberos/internal/server"
 	"github.com/rizesql/kerberos/internal/shutdown"
@@ -28,13 +30,32 @@ func Run(ctx context.Context, cfg Config) error {
 		}
 	}()
 
-	// Create ticket cache (stores TGT and service tickets in memory)
+	// Create ticket cache (stores TGT and service tickets, in a credential
+	// cache file if one is given)
 	ticketCache := platform.NewTicketCache()
+	if cfg.CCache != "" {
+		var err error
+		if ticketCache, err = platform.NewFileTicketCache(strings.TrimPrefix(cfg.CCache, "FILE:")); err != nil {
+			return err
+		}
+	}
 
 	// Initialize SDK
-	sdk := sdk.New(sdk.WithServerUrl(cfg.KDCAddr))
+	opts := []sdk.SdkOption{sdk.WithServerUrl(cfg.KDCAddr)}
+	for _, entry := range cfg.RealmKDCs {
+		realm, addr, ok := strings.Cut(entry, "=")
+		if !ok || realm == "" || addr == "" {
+			return fmt.Errorf("invalid --realm-kdc %q: expected REALM=URL", entry)
+		}
+		opts = append(opts, sdk.WithRealmServerUrl(protocol.Realm(realm), addr))
+	}
+	armorCCache := strings.TrimPrefix(cfg.ArmorCCache, "FILE:")
+	if armorCCache != "" {
+		opts = append(opts, sdk.WithFAST())
+	}
+	sdk := sdk.New(opts...)
 
-	plt := platform.NewPlatform(sdk, ticketCache)
+	plt := platform.NewPlatform(sdk, ticketCache, armorCCache)
 
 	// Create server
 	srv := server.New(logger)
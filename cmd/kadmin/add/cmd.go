@@ -4,7 +4,10 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
+	"math"
 
+	"github.com/rizesql/kerberos/cmd/kadmin/mkey"
+	"github.com/rizesql/kerberos/cmd/kadmin/modify"
 	"github.com/rizesql/kerberos/internal/crypto"
 	"github.com/rizesql/kerberos/internal/kdb"
 	"github.com/rizesql/kerberos/internal/o11y/logging"
@@ -15,7 +18,7 @@ import (
 var Cmd = &cli.Command{
 	Name:  "add",
 	Usage: "Add a new principal to the database",
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.StringFlag{
 			Name:     "db",
 			Usage:    "Path to the SQLite database",
@@ -41,9 +44,50 @@ var Cmd = &cli.Command{
 		},
 		&cli.StringFlag{
 			Name:  "key",
-			Usage: "Hex-encoded 32-byte key (mutually exclusive with --password)",
+			Usage: "Hex-encoded key (mutually exclusive with --password)",
 		},
-	},
+		&cli.StringSliceFlag{
+			Name:  "enctype",
+			Usage: "Encryption type to create a key of, by name or number (repeatable; only one with --key)",
+			Value: []string{protocol.EncTypeAES256GCM.String()},
+		},
+		&cli.StringFlag{
+			Name:  "salt",
+			Usage: "Salt to derive the keys with (defaults to the realm followed by the name components)",
+		},
+		&cli.StringFlag{
+			Name:  "kdf",
+			Usage: "Function to stretch the password with: pbkdf2 or argon2id",
+			Value: string(crypto.KDFPBKDF2),
+		},
+		&cli.UintFlag{
+			Name:  "iterations",
+			Usage: "PBKDF2 iteration count to derive the keys with (defaults to each encryption type's)",
+		},
+		&cli.UintFlag{
+			Name:  "argon2-time",
+			Usage: "Argon2id passes over memory",
+			Value: uint(crypto.DefaultArgon2idParams.Time),
+		},
+		&cli.UintFlag{
+			Name:  "argon2-memory",
+			Usage: "Argon2id memory in KiB",
+			Value: uint(crypto.DefaultArgon2idParams.Memory),
+		},
+		&cli.UintFlag{
+			Name:  "argon2-threads",
+			Usage: "Argon2id lanes",
+			Value: uint(crypto.DefaultArgon2idParams.Threads),
+		},
+		&cli.DurationFlag{
+			Name:  "max-life",
+			Usage: "Maximum ticket lifetime (defaults to the realm's)",
+		},
+		&cli.DurationFlag{
+			Name:  "max-renewable-life",
+			Usage: "Maximum renewable lifetime (defaults to the realm's)",
+		},
+	}, mkey.Flags()...),
 	Action: func(ctx context.Context, cmd *cli.Command) error {
 		dbPath := cmd.String("db")
 		principalStr := cmd.String("principal")
@@ -59,6 +103,28 @@ var Cmd = &cli.Command{
 			return fmt.Errorf("must specify either --password or --key")
 		}
 
+		var etypes []protocol.EncType
+		for _, name := range cmd.StringSlice("enctype") {
+			etype, err := crypto.ParseEncType(name)
+			if err != nil {
+				return fmt.Errorf("invalid --enctype: %w", err)
+			}
+			etypes = append(etypes, etype)
+		}
+		if keyHex != "" && len(etypes) != 1 {
+			return fmt.Errorf("--key takes exactly one --enctype")
+		}
+		if keyHex != "" && (cmd.IsSet("salt") || cmd.IsSet("kdf") || cmd.IsSet("iterations")) {
+			return fmt.Errorf("--salt, --kdf and --iterations only apply to --password")
+		}
+
+		// The salt and parameters are stored with each key, so that the KDC
+		// can tell the client how to derive it again.
+		params, err := s2kParams(cmd)
+		if err != nil {
+			return err
+		}
+
 		// Parse principal
 		primary, instance, realm, err := protocol.Parse(principalStr)
 		if err != nil {
@@ -83,6 +149,12 @@ var Cmd = &cli.Command{
 			return fmt.Errorf("realm cannot be empty (specify via --realm or in principal string)")
 		}
 
+		// Create Principal
+		p, err := protocol.NewPrincipal(primary, instance, realm)
+		if err != nil {
+			return fmt.Errorf("invalid principal data: %w", err)
+		}
+
 		logger := logging.Noop()
 		db, err := kdb.New(kdb.Config{DSN: dbPath, Logger: logger})
 		if err != nil {
@@ -90,48 +162,133 @@ var Cmd = &cli.Command{
 		}
 		defer db.Close()
 
-		var keyBytes []byte
+		if err := db.UnlockFrom(ctx, cmd.String("stash"), cmd.String("master-passphrase")); err != nil {
+			return err
+		}
+
+		var keys []protocol.SessionKey
+		var salt string
 		if password != "" {
-			// Salt = Realm + Primary + Instance (if any)
-			salt := string(realm) + string(primary) + string(instance)
-			sk, err := crypto.DeriveKey(password, salt)
-			if err != nil {
-				return fmt.Errorf("failed to derive key: %w", err)
+			salt = p.DefaultSalt()
+			if cmd.IsSet("salt") {
+				salt = cmd.String("salt")
+			}
+			for _, etype := range etypes {
+				sk, err := crypto.StringToKey(etype, password, salt, params)
+				if err != nil {
+					return fmt.Errorf("failed to derive %s key: %w", etype, err)
+				}
+				keys = append(keys, sk)
 			}
-			keyBytes = sk.Expose()
 		} else {
 			kb, err := hex.DecodeString(keyHex)
 			if err != nil {
 				return fmt.Errorf("failed to decode key hex: %w", err)
 			}
-			if len(kb) != 32 {
-				return fmt.Errorf("key must be 32 bytes (64 hex characters), got %d bytes", len(kb))
+			size, err := crypto.KeySize(etypes[0])
+			if err != nil {
+				return err
+			}
+			if len(kb) != size {
+				return fmt.Errorf("%s key must be %d bytes (%d hex characters), got %d bytes", etypes[0], size, 2*size, len(kb))
+			}
+			sk, err := protocol.NewSessionKey(kb)
+			if err != nil {
+				return fmt.Errorf("invalid key: %w", err)
 			}
-			keyBytes = kb
+			keys = append(keys, sk.WithEncType(etypes[0]))
 		}
 
-		// Create Principal
-		p, err := protocol.NewPrincipal(primary, instance, realm)
+		// The principal and its keys are created together or not at all
+		tx, err := db.BeginTx(ctx, nil)
 		if err != nil {
-			return fmt.Errorf("invalid principal data: %w", err)
+			return fmt.Errorf("failed to begin transaction: %w", err)
 		}
+		defer tx.Rollback()
 
-		created, err := kdb.Query.CreatePrincipal(ctx, db, kdb.CreatePrincipalParams{
-			PrimaryName: string(p.Primary()),
-			Instance:    string(p.Instance()),
-			Realm:       string(p.Realm()),
-			KeyBytes:    keyBytes,
-			Kvno:        1,
+		created, err := kdb.Query.CreatePrincipal(ctx, tx, kdb.CreatePrincipalParams{
+			PrimaryName:      string(p.Primary()),
+			Instance:         string(p.Instance()),
+			Realm:            string(p.Realm()),
+			Kvno:             1,
+			MaxLife:          modify.Seconds(cmd.Duration("max-life")),
+			MaxRenewableLife: modify.Seconds(cmd.Duration("max-renewable-life")),
 		})
 		if err != nil {
 			return fmt.Errorf("failed to create principal: %w", err)
 		}
 
+		for _, key := range keys {
+			mkvno, sealed, err := db.MasterKeys().Seal(key.Expose())
+			if err != nil {
+				return fmt.Errorf("failed to seal %s key: %w", key.EncType(), err)
+			}
+			err = kdb.Query.AddKey(ctx, tx, kdb.AddKeyParams{
+				PrincipalID: created.ID,
+				Kvno:        created.Kvno,
+				Enctype:     int64(key.EncType()),
+				Salt:        salt,
+				S2kparams:   params,
+				Mkvno:       mkvno,
+				KeyBytes:    sealed,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to add %s key: %w", key.EncType(), err)
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit: %w", err)
+		}
+
 		fmt.Printf("Created principal: %s\n", principalFromDB(created).String())
 		return nil
 	},
 }
 
+// s2kParams encodes the string-to-key parameters the flags ask for; nil
+// leaves each encryption type's default.
+func s2kParams(cmd *cli.Command) ([]byte, error) {
+	kdf, err := crypto.ParseKDF(cmd.String("kdf"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --kdf: %w", err)
+	}
+
+	switch kdf {
+	case crypto.KDFArgon2id:
+		if cmd.IsSet("iterations") {
+			return nil, fmt.Errorf("--iterations only applies to --kdf pbkdf2")
+		}
+		time, memory, threads := cmd.Uint("argon2-time"), cmd.Uint("argon2-memory"), cmd.Uint("argon2-threads")
+		if time > math.MaxUint32 || memory > math.MaxUint32 || threads > math.MaxUint8 {
+			return nil, fmt.Errorf("argon2id parameters out of range")
+		}
+		p := crypto.Argon2idParams{Time: uint32(time), Memory: uint32(memory), Threads: uint8(threads)}
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		return checkPolicy(p.Params())
+	default:
+		if !cmd.IsSet("iterations") {
+			return nil, nil
+		}
+		n := cmd.Uint("iterations")
+		if n == 0 || n > math.MaxUint32 {
+			return nil, fmt.Errorf("--iterations must be between 1 and %d", uint32(math.MaxUint32))
+		}
+		return checkPolicy(crypto.IterationParams(uint32(n)))
+	}
+}
+
+// checkPolicy refuses params that clients, holding them to
+// crypto.DefaultS2KPolicy, would not derive their key with.
+func checkPolicy(params []byte) ([]byte, error) {
+	if err := crypto.DefaultS2KPolicy.Check(params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
 func principalFromDB(p kdb.Principal) protocol.Principal {
 	// We assume DB data is valid as we just inserted it
 	pp, _ := protocol.NewPrincipal(protocol.Primary(p.PrimaryName), protocol.Instance(p.Instance), protocol.Realm(p.Realm))
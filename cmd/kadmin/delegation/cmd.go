@@ -0,0 +1,191 @@
+package delegation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rizesql/kerberos/internal/kdb"
+	"github.com/rizesql/kerberos/internal/o11y/logging"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/urfave/cli/v3"
+)
+
+var flags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "db",
+		Usage:    "Path to the SQLite database",
+		Required: true,
+	},
+	&cli.StringFlag{
+		Name:  "realm",
+		Usage: "Realm name (optional if provided in principal strings)",
+	},
+}
+
+var Cmd = &cli.Command{
+	Name:  "delegation",
+	Usage: "Manage the backends a service may obtain tickets to on behalf of users (S4U2Proxy)",
+	Commands: []*cli.Command{
+		{
+			Name:      "add",
+			Usage:     "Allow a service to delegate to a backend",
+			ArgsUsage: "<service> <backend>",
+			Flags:     flags,
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				service, backend, err := parseArgs(cmd)
+				if err != nil {
+					return err
+				}
+
+				db, err := open(cmd)
+				if err != nil {
+					return err
+				}
+				defer db.Close()
+
+				for _, p := range []protocol.Principal{service, backend} {
+					if _, err := kdb.Query.GetPrincipal(ctx, db, kdb.GetPrincipalParams{
+						PrimaryName: string(p.Primary()),
+						Instance:    string(p.Instance()),
+						Realm:       string(p.Realm()),
+					}); err != nil {
+						return fmt.Errorf("failed to get principal %s: %w", p, err)
+					}
+				}
+
+				if err := kdb.Query.AddDelegation(ctx, db, kdb.AddDelegationParams{
+					ServicePrimary:  string(service.Primary()),
+					ServiceInstance: string(service.Instance()),
+					ServiceRealm:    string(service.Realm()),
+					TargetPrimary:   string(backend.Primary()),
+					TargetInstance:  string(backend.Instance()),
+					TargetRealm:     string(backend.Realm()),
+				}); err != nil {
+					return fmt.Errorf("failed to add delegation: %w", err)
+				}
+
+				fmt.Printf("%s may now delegate to %s\n", service, backend)
+				return nil
+			},
+		},
+		{
+			Name:      "remove",
+			Usage:     "Stop a service from delegating to a backend",
+			ArgsUsage: "<service> <backend>",
+			Flags:     flags,
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				service, backend, err := parseArgs(cmd)
+				if err != nil {
+					return err
+				}
+
+				db, err := open(cmd)
+				if err != nil {
+					return err
+				}
+				defer db.Close()
+
+				removed, err := kdb.Query.RemoveDelegation(ctx, db, kdb.RemoveDelegationParams{
+					ServicePrimary:  string(service.Primary()),
+					ServiceInstance: string(service.Instance()),
+					ServiceRealm:    string(service.Realm()),
+					TargetPrimary:   string(backend.Primary()),
+					TargetInstance:  string(backend.Instance()),
+					TargetRealm:     string(backend.Realm()),
+				})
+				if err != nil {
+					return fmt.Errorf("failed to remove delegation: %w", err)
+				}
+				if removed == 0 {
+					return fmt.Errorf("%s may not delegate to %s", service, backend)
+				}
+
+				fmt.Printf("%s may no longer delegate to %s\n", service, backend)
+				return nil
+			},
+		},
+		{
+			Name:      "list",
+			Usage:     "List the backends a service may delegate to",
+			ArgsUsage: "<service>",
+			Flags:     flags,
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				service, err := parsePrincipal(cmd.Args().First(), cmd.String("realm"))
+				if err != nil {
+					return err
+				}
+
+				db, err := open(cmd)
+				if err != nil {
+					return err
+				}
+				defer db.Close()
+
+				rows, err := kdb.Query.ListDelegations(ctx, db, kdb.ListDelegationsParams{
+					ServicePrimary:  string(service.Primary()),
+					ServiceInstance: string(service.Instance()),
+					ServiceRealm:    string(service.Realm()),
+				})
+				if err != nil {
+					return fmt.Errorf("failed to list delegations: %w", err)
+				}
+
+				for _, row := range rows {
+					target, err := protocol.NewPrincipal(
+						protocol.Primary(row.TargetPrimary),
+						protocol.Instance(row.TargetInstance),
+						protocol.Realm(row.TargetRealm),
+					)
+					if err != nil {
+						return fmt.Errorf("invalid delegation target: %w", err)
+					}
+					fmt.Println(target)
+				}
+				return nil
+			},
+		},
+	},
+}
+
+func open(cmd *cli.Command) (kdb.Database, error) {
+	db, err := kdb.New(kdb.Config{DSN: cmd.String("db"), Logger: logging.Noop()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return db, nil
+}
+
+func parseArgs(cmd *cli.Command) (service, backend protocol.Principal, err error) {
+	if cmd.Args().Len() != 2 {
+		return protocol.Principal{}, protocol.Principal{}, fmt.Errorf("must specify a service and a backend principal")
+	}
+
+	realm := cmd.String("realm")
+	if service, err = parsePrincipal(cmd.Args().Get(0), realm); err != nil {
+		return protocol.Principal{}, protocol.Principal{}, err
+	}
+	if backend, err = parsePrincipal(cmd.Args().Get(1), realm); err != nil {
+		return protocol.Principal{}, protocol.Principal{}, err
+	}
+	return service, backend, nil
+}
+
+func parsePrincipal(s, defaultRealm string) (protocol.Principal, error) {
+	if s == "" {
+		return protocol.Principal{}, fmt.Errorf("must specify principal name as argument")
+	}
+
+	primary, instance, realm, err := protocol.Parse(s)
+	if err != nil {
+		return protocol.Principal{}, fmt.Errorf("invalid principal: %w", err)
+	}
+
+	if realm == "" {
+		realm = protocol.Realm(defaultRealm)
+	}
+	if realm == "" {
+		return protocol.Principal{}, fmt.Errorf("must specify realm either via --realm or in principal string (e.g. http/api@REALM)")
+	}
+
+	return protocol.NewPrincipal(primary, instance, realm)
+}
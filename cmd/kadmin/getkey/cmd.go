@@ -5,6 +5,8 @@ import (
 	"encoding/hex"
 	"fmt"
 
+	"github.com/rizesql/kerberos/cmd/kadmin/mkey"
+	"github.com/rizesql/kerberos/internal/crypto"
 	"github.com/rizesql/kerberos/internal/kdb"
 	"github.com/rizesql/kerberos/internal/o11y/logging"
 	"github.com/rizesql/kerberos/internal/protocol"
@@ -13,8 +15,8 @@ import (
 
 var Cmd = &cli.Command{
 	Name:  "get-key",
-	Usage: "Get the hex-encoded key of a principal",
-	Flags: []cli.Flag{
+	Usage: "Get the hex-encoded current key of a principal",
+	Flags: append([]cli.Flag{
 		&cli.StringFlag{
 			Name:     "db",
 			Usage:    "Path to the SQLite database",
@@ -24,7 +26,12 @@ var Cmd = &cli.Command{
 			Name:  "realm",
 			Usage: "Realm name (optional if provided in principal string)",
 		},
-	},
+		&cli.StringFlag{
+			Name:  "enctype",
+			Usage: "Encryption type of the key, by name or number",
+			Value: protocol.EncTypeAES256GCM.String(),
+		},
+	}, mkey.Flags()...),
 	Action: func(ctx context.Context, cmd *cli.Command) error {
 		dbPath := cmd.String("db")
 		realmFlag := cmd.String("realm")
@@ -47,6 +54,11 @@ var Cmd = &cli.Command{
 			return fmt.Errorf("must specify realm either via --realm or in principal string (e.g. alice@REALM)")
 		}
 
+		etype, err := crypto.ParseEncType(cmd.String("enctype"))
+		if err != nil {
+			return fmt.Errorf("invalid --enctype: %w", err)
+		}
+
 		logger := logging.Noop()
 		db, err := kdb.New(kdb.Config{DSN: dbPath, Logger: logger})
 		if err != nil {
@@ -54,6 +66,10 @@ var Cmd = &cli.Command{
 		}
 		defer db.Close()
 
+		if err := db.UnlockFrom(ctx, cmd.String("stash"), cmd.String("master-passphrase")); err != nil {
+			return err
+		}
+
 		row, err := kdb.Query.GetPrincipal(ctx, db, kdb.GetPrincipalParams{
 			PrimaryName: string(primary),
 			Instance:    string(instance),
@@ -63,7 +79,25 @@ var Cmd = &cli.Command{
 			return fmt.Errorf("failed to get principal: %w", err)
 		}
 
-		fmt.Println(hex.EncodeToString(row.KeyBytes))
-		return nil
+		keys, err := kdb.Query.ListKeys(ctx, db, kdb.ListKeysParams{
+			PrimaryName: string(primary),
+			Instance:    string(instance),
+			Realm:       string(realm),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list keys: %w", err)
+		}
+
+		for _, key := range keys {
+			if key.Kvno == row.Kvno && protocol.EncType(key.Enctype) == etype {
+				kb, err := db.MasterKeys().Open(key.Mkvno, key.KeyBytes)
+				if err != nil {
+					return fmt.Errorf("failed to open key: %w", err)
+				}
+				fmt.Println(hex.EncodeToString(kb))
+				return nil
+			}
+		}
+		return fmt.Errorf("principal has no %s key of version %d", etype, row.Kvno)
 	},
 }
@@ -0,0 +1,259 @@
+package group
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rizesql/kerberos/internal/kdb"
+	"github.com/rizesql/kerberos/internal/o11y/logging"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/urfave/cli/v3"
+)
+
+var dbFlag = &cli.StringFlag{
+	Name:     "db",
+	Usage:    "Path to the SQLite database",
+	Required: true,
+}
+
+var memberFlags = []cli.Flag{
+	dbFlag,
+	&cli.StringFlag{
+		Name:  "realm",
+		Usage: "Realm name (optional if provided in principal strings)",
+	},
+}
+
+var Cmd = &cli.Command{
+	Name:  "group",
+	Usage: "Manage the groups whose membership the KDC puts in tickets",
+	Commands: []*cli.Command{
+		{
+			Name:      "add",
+			Usage:     "Create a group",
+			ArgsUsage: "<group>",
+			Flags:     []cli.Flag{dbFlag},
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				name, err := parseGroup(cmd.Args().First())
+				if err != nil {
+					return err
+				}
+
+				db, err := open(cmd)
+				if err != nil {
+					return err
+				}
+				defer db.Close()
+
+				if err := kdb.Query.CreateGroup(ctx, db, name); err != nil {
+					return fmt.Errorf("failed to create group: %w", err)
+				}
+
+				fmt.Printf("Created group: %s\n", name)
+				return nil
+			},
+		},
+		{
+			Name:      "delete",
+			Usage:     "Delete a group and all of its memberships",
+			ArgsUsage: "<group>",
+			Flags:     []cli.Flag{dbFlag},
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				name, err := parseGroup(cmd.Args().First())
+				if err != nil {
+					return err
+				}
+
+				db, err := open(cmd)
+				if err != nil {
+					return err
+				}
+				defer db.Close()
+
+				if err := kdb.Query.RemoveGroupMembers(ctx, db, name); err != nil {
+					return fmt.Errorf("failed to remove group members: %w", err)
+				}
+
+				deleted, err := kdb.Query.DeleteGroup(ctx, db, name)
+				if err != nil {
+					return fmt.Errorf("failed to delete group: %w", err)
+				}
+				if deleted == 0 {
+					return fmt.Errorf("no such group: %s", name)
+				}
+
+				fmt.Printf("Deleted group: %s\n", name)
+				return nil
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "List all groups",
+			Flags: []cli.Flag{dbFlag},
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				db, err := open(cmd)
+				if err != nil {
+					return err
+				}
+				defer db.Close()
+
+				names, err := kdb.Query.ListGroups(ctx, db)
+				if err != nil {
+					return fmt.Errorf("failed to list groups: %w", err)
+				}
+
+				for _, name := range names {
+					fmt.Println(name)
+				}
+				return nil
+			},
+		},
+		{
+			Name:      "addmember",
+			Usage:     "Add a principal to a group",
+			ArgsUsage: "<group> <principal>",
+			Flags:     memberFlags,
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				name, member, err := parseArgs(cmd)
+				if err != nil {
+					return err
+				}
+
+				db, err := open(cmd)
+				if err != nil {
+					return err
+				}
+				defer db.Close()
+
+				added, err := kdb.Query.AddGroupMember(ctx, db, kdb.AddGroupMemberParams{
+					Name:        name,
+					PrimaryName: string(member.Primary()),
+					Instance:    string(member.Instance()),
+					Realm:       string(member.Realm()),
+				})
+				if err != nil {
+					return fmt.Errorf("failed to add member: %w", err)
+				}
+				if added == 0 {
+					return fmt.Errorf("no such group or principal: %s, %s", name, member)
+				}
+
+				fmt.Printf("%s is now a member of %s\n", member, name)
+				return nil
+			},
+		},
+		{
+			Name:      "removemember",
+			Usage:     "Remove a principal from a group",
+			ArgsUsage: "<group> <principal>",
+			Flags:     memberFlags,
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				name, member, err := parseArgs(cmd)
+				if err != nil {
+					return err
+				}
+
+				db, err := open(cmd)
+				if err != nil {
+					return err
+				}
+				defer db.Close()
+
+				removed, err := kdb.Query.RemoveGroupMember(ctx, db, kdb.RemoveGroupMemberParams{
+					Name:        name,
+					PrimaryName: string(member.Primary()),
+					Instance:    string(member.Instance()),
+					Realm:       string(member.Realm()),
+				})
+				if err != nil {
+					return fmt.Errorf("failed to remove member: %w", err)
+				}
+				if removed == 0 {
+					return fmt.Errorf("%s is not a member of %s", member, name)
+				}
+
+				fmt.Printf("%s is no longer a member of %s\n", member, name)
+				return nil
+			},
+		},
+		{
+			Name:      "members",
+			Usage:     "List the members of a group",
+			ArgsUsage: "<group>",
+			Flags:     []cli.Flag{dbFlag},
+			Action: func(ctx context.Context, cmd *cli.Command) error {
+				name, err := parseGroup(cmd.Args().First())
+				if err != nil {
+					return err
+				}
+
+				db, err := open(cmd)
+				if err != nil {
+					return err
+				}
+				defer db.Close()
+
+				rows, err := kdb.Query.ListGroupMembers(ctx, db, name)
+				if err != nil {
+					return fmt.Errorf("failed to list members: %w", err)
+				}
+
+				for _, row := range rows {
+					member, err := protocol.NewPrincipal(
+						protocol.Primary(row.PrimaryName),
+						protocol.Instance(row.Instance),
+						protocol.Realm(row.Realm),
+					)
+					if err != nil {
+						return fmt.Errorf("invalid group member: %w", err)
+					}
+					fmt.Println(member)
+				}
+				return nil
+			},
+		},
+	},
+}
+
+func open(cmd *cli.Command) (kdb.Database, error) {
+	db, err := kdb.New(kdb.Config{DSN: cmd.String("db"), Logger: logging.Noop()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return db, nil
+}
+
+func parseGroup(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("must specify group name as argument")
+	}
+	return name, nil
+}
+
+func parseArgs(cmd *cli.Command) (string, protocol.Principal, error) {
+	if cmd.Args().Len() != 2 {
+		return "", protocol.Principal{}, fmt.Errorf("must specify a group and a principal")
+	}
+
+	name, err := parseGroup(cmd.Args().Get(0))
+	if err != nil {
+		return "", protocol.Principal{}, err
+	}
+
+	primary, instance, realm, err := protocol.Parse(cmd.Args().Get(1))
+	if err != nil {
+		return "", protocol.Principal{}, fmt.Errorf("invalid principal: %w", err)
+	}
+	if realm == "" {
+		realm = protocol.Realm(cmd.String("realm"))
+	}
+	if realm == "" {
+		return "", protocol.Principal{}, fmt.Errorf("must specify realm either via --realm or in principal string (e.g. alice@REALM)")
+	}
+
+	member, err := protocol.NewPrincipal(primary, instance, realm)
+	if err != nil {
+		return "", protocol.Principal{}, err
+	}
+	return name, member, nil
+}
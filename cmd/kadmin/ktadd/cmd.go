@@ -0,0 +1,143 @@
+package ktadd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/rizesql/kerberos/cmd/kadmin/mkey"
+	"github.com/rizesql/kerberos/internal/kdb"
+	"github.com/rizesql/kerberos/internal/keytab"
+	"github.com/rizesql/kerberos/internal/o11y/logging"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/urfave/cli/v3"
+)
+
+var Cmd = &cli.Command{
+	Name:      "ktadd",
+	Usage:     "Export the current keys of principals to a keytab",
+	ArgsUsage: "principal...",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:     "db",
+			Usage:    "Path to the SQLite database",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "keytab",
+			Usage: "Path to the keytab, added to if it exists",
+			Value: "krb5.keytab",
+		},
+		&cli.StringFlag{
+			Name:  "realm",
+			Usage: "Realm name (optional if provided in principal strings)",
+		},
+	}, mkey.Flags()...),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		if cmd.Args().Len() == 0 {
+			return fmt.Errorf("must specify at least one principal")
+		}
+
+		db, err := kdb.New(kdb.Config{DSN: cmd.String("db"), Logger: logging.Noop()})
+		if err != nil {
+			return fmt.Errorf("failed to open database: %w", err)
+		}
+		defer db.Close()
+
+		if err := db.UnlockFrom(ctx, cmd.String("stash"), cmd.String("master-passphrase")); err != nil {
+			return err
+		}
+
+		path := cmd.String("keytab")
+		kt, err := keytab.Load(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			kt = keytab.New()
+		} else if err != nil {
+			return fmt.Errorf("failed to read keytab: %w", err)
+		}
+
+		now := time.Now()
+		for _, name := range cmd.Args().Slice() {
+			p, err := parsePrincipal(name, protocol.Realm(cmd.String("realm")))
+			if err != nil {
+				return err
+			}
+
+			n, err := addKeys(ctx, db, kt, p, now)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Added %d keys of %s to %s\n", n, p, path)
+		}
+
+		if err := kt.Write(path); err != nil {
+			return fmt.Errorf("failed to write keytab: %w", err)
+		}
+		return nil
+	},
+}
+
+func parsePrincipal(name string, defaultRealm protocol.Realm) (protocol.Principal, error) {
+	primary, instance, realm, err := protocol.Parse(name)
+	if err != nil {
+		return protocol.Principal{}, fmt.Errorf("invalid principal %q: %w", name, err)
+	}
+	if realm == "" {
+		realm = defaultRealm
+	}
+	if realm == "" {
+		return protocol.Principal{}, fmt.Errorf("must specify realm either via --realm or in principal %q", name)
+	}
+	return protocol.NewPrincipal(primary, instance, realm)
+}
+
+// addKeys adds the keys of the current version of p to kt, in every
+// encryption type it has.
+func addKeys(ctx context.Context, db kdb.Database, kt *keytab.Keytab, p protocol.Principal, now time.Time) (int, error) {
+	row, err := kdb.Query.GetPrincipal(ctx, db, kdb.GetPrincipalParams{
+		PrimaryName: string(p.Primary()),
+		Instance:    string(p.Instance()),
+		Realm:       string(p.Realm()),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get principal %s: %w", p, err)
+	}
+
+	keys, err := kdb.Query.ListKeys(ctx, db, kdb.ListKeysParams{
+		PrimaryName: string(p.Primary()),
+		Instance:    string(p.Instance()),
+		Realm:       string(p.Realm()),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list keys of %s: %w", p, err)
+	}
+
+	n := 0
+	for _, k := range keys {
+		if k.Kvno != row.Kvno {
+			continue
+		}
+		kb, err := db.MasterKeys().Open(k.Mkvno, k.KeyBytes)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open key of %s: %w", p, err)
+		}
+		key, err := protocol.NewSessionKey(kb)
+		if err != nil {
+			return 0, err
+		}
+
+		kt.Add(keytab.Entry{
+			Principal: p,
+			Timestamp: now,
+			Kvno:      uint32(k.Kvno),
+			Key:       key.WithEncType(protocol.EncType(k.Enctype)),
+		})
+		n++
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("principal %s has no key of version %d", p, row.Kvno)
+	}
+	return n, nil
+}
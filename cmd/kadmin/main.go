@@ -6,7 +6,12 @@ import (
 	"os"
 
 	"github.com/rizesql/kerberos/cmd/kadmin/add"
+	"github.com/rizesql/kerberos/cmd/kadmin/delegation"
 	"github.com/rizesql/kerberos/cmd/kadmin/getkey"
+	"github.com/rizesql/kerberos/cmd/kadmin/group"
+	"github.com/rizesql/kerberos/cmd/kadmin/ktadd"
+	"github.com/rizesql/kerberos/cmd/kadmin/mkey"
+	"github.com/rizesql/kerberos/cmd/kadmin/modify"
 	"github.com/urfave/cli/v3"
 )
 
@@ -16,7 +21,12 @@ func main() {
 		Usage: "Kerberos Administration Tool",
 		Commands: []*cli.Command{
 			add.Cmd,
+			modify.Cmd,
 			getkey.Cmd,
+			ktadd.Cmd,
+			delegation.Cmd,
+			group.Cmd,
+			mkey.Cmd,
 		},
 	}
 
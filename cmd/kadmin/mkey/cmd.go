@@ -0,0 +1,211 @@
+package mkey
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/kdb"
+	"github.com/rizesql/kerberos/internal/o11y/logging"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/urfave/cli/v3"
+)
+
+// Flags locate the realm master key, for the commands that read or write
+// principal keys.
+func Flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "stash",
+			Usage: "Path to the stash file holding the realm master key",
+			Value: "kdc.stash",
+		},
+		&cli.StringFlag{
+			Name:    "master-passphrase",
+			Usage:   "Derive the realm master key from a passphrase instead of reading the stash",
+			Sources: cli.EnvVars("KDC_MASTER_PASSPHRASE"),
+		},
+	}
+}
+
+var Cmd = &cli.Command{
+	Name:  "change-master-key",
+	Usage: "Reseal every key in the database under a new realm master key",
+	Description: "Run again after an interruption to resume where it stopped. " +
+		"A KDC reading the stash may keep running: it reads the stash again when it meets a key " +
+		"sealed under the new version. A KDC started with --master-passphrase cannot derive the new " +
+		"key, so it must be stopped first, confirmed with --kdc-stopped, and started again with the " +
+		"new passphrase.",
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:     "db",
+			Usage:    "Path to the SQLite database",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "realm",
+			Usage: "Realm name, to derive a master key from --new-master-passphrase with",
+		},
+		&cli.StringFlag{
+			Name:    "new-master-passphrase",
+			Usage:   "Passphrase to derive the new master key from (only with --master-passphrase)",
+			Sources: cli.EnvVars("KDC_NEW_MASTER_PASSPHRASE"),
+		},
+		&cli.BoolFlag{
+			Name:  "kdc-stopped",
+			Usage: "Confirm that no KDC is running on the database (required with --master-passphrase)",
+		},
+		&cli.IntFlag{
+			Name:  "batch",
+			Usage: "Keys to reseal per transaction",
+			Value: 100,
+		},
+	}, Flags()...),
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		passphrase := cmd.String("master-passphrase")
+		newPassphrase := cmd.String("new-master-passphrase")
+		batch := cmd.Int("batch")
+		if batch < 1 {
+			return fmt.Errorf("--batch must be at least 1")
+		}
+		if passphrase != "" && !cmd.Bool("kdc-stopped") {
+			return fmt.Errorf("stop the KDC first and confirm with --kdc-stopped: " +
+				"one unlocked with the old passphrase cannot open keys resealed under the new one")
+		}
+
+		db, err := kdb.New(kdb.Config{DSN: cmd.String("db"), Logger: logging.Noop()})
+		if err != nil {
+			return fmt.Errorf("failed to open database: %w", err)
+		}
+		defer db.Close()
+
+		rows, err := kdb.Query.ListMasterKeys(ctx, db)
+		if err != nil {
+			return fmt.Errorf("failed to list master keys: %w", err)
+		}
+		if len(rows) == 0 {
+			return fmt.Errorf("database has no master key; run `kdc setup` first")
+		}
+		newest := rows[len(rows)-1].Mkvno
+
+		// A change already under way has recorded its new version, which is
+		// then the newest; it is finished rather than started over.
+		var keys kdb.MasterKeys
+		if passphrase != "" {
+			keys, err = passphraseKeys(ctx, db, cmd, newest, passphrase, newPassphrase)
+		} else {
+			keys, err = stashKeys(ctx, db, cmd.String("stash"), newest, len(rows) > 1)
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := db.Unlock(ctx, keys); err != nil {
+			return err
+		}
+		target := db.MasterKeys().Current()
+
+		total := 0
+		for {
+			n, err := db.Reseal(ctx, int(batch))
+			if err != nil {
+				return fmt.Errorf("failed to reseal keys: %w", err)
+			}
+			if n == 0 {
+				break
+			}
+			total += n
+		}
+		fmt.Printf("Resealed %d keys under master key version %d\n", total, target)
+
+		// The stash drops the old version only once no key needs it.
+		if passphrase == "" {
+			key, _ := keys.Key(target)
+			if err := kdb.WriteStash(cmd.String("stash"), kdb.NewMasterKeys(target, key)); err != nil {
+				return fmt.Errorf("failed to write stash: %w", err)
+			}
+		}
+		if err := db.PurgeMasterKeys(ctx); err != nil {
+			return fmt.Errorf("failed to purge old master keys: %w", err)
+		}
+
+		if passphrase != "" {
+			fmt.Println("Master key changed; start the KDC with the new passphrase")
+		} else {
+			fmt.Println("Master key changed")
+		}
+		return nil
+	},
+}
+
+// stashKeys reads the master keys from the stash and, unless a change is
+// already under way, adds a new random version. The new version is written
+// to the stash before the database records it, so that keys are never
+// sealed under a version the stash does not hold.
+func stashKeys(ctx context.Context, db kdb.DBTX, stash string, newest int64, changing bool) (kdb.MasterKeys, error) {
+	keys, err := kdb.ReadStash(stash)
+	if err != nil {
+		return kdb.MasterKeys{}, fmt.Errorf("failed to read stash: %w", err)
+	}
+
+	switch {
+	case keys.Current() > newest:
+		// Interrupted after writing the stash.
+	case changing:
+		if keys.Current() != newest {
+			return kdb.MasterKeys{}, fmt.Errorf("stash does not hold master key version %d", newest)
+		}
+		return keys, nil
+	default:
+		key, err := crypto.NewKeyGenerator().Generate(kdb.MasterKeyEncType)
+		if err != nil {
+			return kdb.MasterKeys{}, fmt.Errorf("failed to generate master key: %w", err)
+		}
+		keys = keys.With(newest+1, key)
+		if err := kdb.WriteStash(stash, keys); err != nil {
+			return kdb.MasterKeys{}, fmt.Errorf("failed to write stash: %w", err)
+		}
+	}
+
+	key, _ := keys.Key(keys.Current())
+	if err := kdb.AddMasterKey(ctx, db, keys.Current(), key, "", nil); err != nil {
+		return kdb.MasterKeys{}, fmt.Errorf("failed to record master key: %w", err)
+	}
+	return keys, nil
+}
+
+// passphraseKeys derives the master keys from the old and new passphrases,
+// recording a version for the new one unless a change to it is already
+// under way.
+func passphraseKeys(ctx context.Context, db kdb.DBTX, cmd *cli.Command, newest int64, passphrase, newPassphrase string) (kdb.MasterKeys, error) {
+	if newPassphrase == "" {
+		return kdb.MasterKeys{}, fmt.Errorf("must specify --new-master-passphrase with --master-passphrase")
+	}
+	if newPassphrase == passphrase {
+		return kdb.MasterKeys{}, fmt.Errorf("new master passphrase is the current one")
+	}
+
+	keys, err := kdb.PassphraseMasterKeys(ctx, db, passphrase)
+	if err != nil {
+		return kdb.MasterKeys{}, err
+	}
+
+	// Interrupted after recording the version of the new passphrase.
+	if next, err := kdb.PassphraseMasterKeys(ctx, db, newPassphrase); err == nil && next.Current() == newest {
+		key, _ := next.Key(newest)
+		return keys.With(newest, key), nil
+	}
+
+	realm := cmd.String("realm")
+	if realm == "" {
+		return kdb.MasterKeys{}, fmt.Errorf("must specify --realm to derive the new master key")
+	}
+	key, salt, params, err := kdb.DeriveMasterKey(protocol.Realm(realm), newPassphrase)
+	if err != nil {
+		return kdb.MasterKeys{}, fmt.Errorf("failed to derive master key: %w", err)
+	}
+	if err := kdb.AddMasterKey(ctx, db, newest+1, key, salt, params); err != nil {
+		return kdb.MasterKeys{}, fmt.Errorf("failed to record master key: %w", err)
+	}
+	return keys.With(newest+1, key), nil
+}
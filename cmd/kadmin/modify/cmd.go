@@ -0,0 +1,143 @@
+package modify
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/kdb"
+	"github.com/rizesql/kerberos/internal/o11y/logging"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/urfave/cli/v3"
+)
+
+var Cmd = &cli.Command{
+	Name:      "modify",
+	Usage:     "Change the ticket limits and policy flags of a principal",
+	ArgsUsage: "<principal>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "db",
+			Usage:    "Path to the SQLite database",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:  "realm",
+			Usage: "Realm name (optional if provided in principal string)",
+		},
+		&cli.DurationFlag{
+			Name:  "max-life",
+			Usage: "Maximum ticket lifetime (0 falls back to the realm default)",
+		},
+		&cli.DurationFlag{
+			Name:  "max-renewable-life",
+			Usage: "Maximum renewable lifetime (0 falls back to the realm default)",
+		},
+		&cli.BoolFlag{
+			Name:  "require-fast",
+			Usage: "Refuse requests for the principal that are not armored with FAST (--require-fast=false lifts it)",
+		},
+	},
+	Action: func(ctx context.Context, cmd *cli.Command) error {
+		principalStr := cmd.Args().First()
+		if principalStr == "" {
+			return fmt.Errorf("must specify principal name as argument")
+		}
+		limits := cmd.IsSet("max-life") || cmd.IsSet("max-renewable-life")
+		if !limits && !cmd.IsSet("require-fast") {
+			return fmt.Errorf("nothing to modify (specify --max-life, --max-renewable-life or --require-fast)")
+		}
+
+		primary, instance, realm, err := protocol.Parse(principalStr)
+		if err != nil {
+			return fmt.Errorf("invalid principal: %w", err)
+		}
+		if realm == "" {
+			realm = protocol.Realm(cmd.String("realm"))
+		}
+		if realm == "" {
+			return fmt.Errorf("must specify realm either via --realm or in principal string (e.g. alice@REALM)")
+		}
+
+		p, err := protocol.NewPrincipal(primary, instance, realm)
+		if err != nil {
+			return fmt.Errorf("invalid principal data: %w", err)
+		}
+
+		db, err := kdb.New(kdb.Config{DSN: cmd.String("db"), Logger: logging.Noop()})
+		if err != nil {
+			return fmt.Errorf("failed to open database: %w", err)
+		}
+		defer db.Close()
+
+		current, err := kdb.Query.GetPrincipal(ctx, db, kdb.GetPrincipalParams{
+			PrimaryName: string(p.Primary()),
+			Instance:    string(p.Instance()),
+			Realm:       string(p.Realm()),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get principal: %w", err)
+		}
+
+		params := kdb.UpdatePrincipalLimitsParams{
+			MaxLife:          current.MaxLife,
+			MaxRenewableLife: current.MaxRenewableLife,
+			PrimaryName:      string(p.Primary()),
+			Instance:         string(p.Instance()),
+			Realm:            string(p.Realm()),
+		}
+		if cmd.IsSet("max-life") {
+			params.MaxLife = Seconds(cmd.Duration("max-life"))
+		}
+		if cmd.IsSet("max-renewable-life") {
+			params.MaxRenewableLife = Seconds(cmd.Duration("max-renewable-life"))
+		}
+
+		if limits {
+			if _, err := kdb.Query.UpdatePrincipalLimits(ctx, db, params); err != nil {
+				return fmt.Errorf("failed to update principal: %w", err)
+			}
+		}
+
+		flags := kdb.PrincipalFlag(current.Flags)
+		if cmd.IsSet("require-fast") {
+			flags = setFlag(flags, kdb.FlagRequiresFAST, cmd.Bool("require-fast"))
+			if _, err := kdb.Query.UpdatePrincipalFlags(ctx, db, kdb.UpdatePrincipalFlagsParams{
+				Flags:       int64(flags),
+				PrimaryName: string(p.Primary()),
+				Instance:    string(p.Instance()),
+				Realm:       string(p.Realm()),
+			}); err != nil {
+				return fmt.Errorf("failed to update principal: %w", err)
+			}
+		}
+
+		fmt.Printf("Modified principal: %s (max life: %s, max renewable life: %s, requires FAST: %t)\n",
+			p, describe(params.MaxLife), describe(params.MaxRenewableLife), flags.Has(kdb.FlagRequiresFAST))
+		return nil
+	},
+}
+
+// Seconds stores a limit the way the principals table does: whole seconds,
+// with NULL standing for the realm default.
+func Seconds(d time.Duration) sql.NullInt64 {
+	if d <= 0 {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(d / time.Second), Valid: true}
+}
+
+func setFlag(flags, flag kdb.PrincipalFlag, on bool) kdb.PrincipalFlag {
+	if on {
+		return flags | flag
+	}
+	return flags &^ flag
+}
+
+func describe(limit sql.NullInt64) string {
+	if !limit.Valid {
+		return "realm default"
+	}
+	return (time.Duration(limit.Int64) * time.Second).String()
+}
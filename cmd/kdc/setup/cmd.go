@@ -25,6 +25,16 @@ var Cmd = &cli.Command{
 			Usage:    "Master secret for krbtgt principal",
 			Required: true,
 		},
+		&cli.StringFlag{
+			Name:  "stash",
+			Usage: "Path to write the randomly generated realm master key to",
+			Value: "kdc.stash",
+		},
+		&cli.StringFlag{
+			Name:    "master-passphrase",
+			Usage:   "Derive the realm master key from a passphrase instead of stashing a random one",
+			Sources: cli.EnvVars("KDC_MASTER_PASSPHRASE"),
+		},
 	},
 	Action: func(ctx context.Context, cmd *cli.Command) error {
 		return Run(ctx, newConfig(cmd))
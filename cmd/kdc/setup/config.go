@@ -6,6 +6,10 @@ type Config struct {
 	DBPath string
 	Realm  string
 	Secret string
+	// StashPath is where a random master key is written, unless it is
+	// derived from MasterPassphrase.
+	StashPath        string
+	MasterPassphrase string
 }
 
 func newConfig(cmd *cli.Command) Config {
@@ -13,5 +17,8 @@ func newConfig(cmd *cli.Command) Config {
 		DBPath: cmd.String("db"),
 		Realm:  cmd.String("realm"),
 		Secret: cmd.String("secret"),
+
+		StashPath:        cmd.String("stash"),
+		MasterPassphrase: cmd.String("master-passphrase"),
 	}
 }
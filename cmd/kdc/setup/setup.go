@@ -3,6 +3,7 @@ package setup
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/rizesql/kerberos/internal/crypto"
 	"github.com/rizesql/kerberos/internal/kdb"
@@ -31,27 +32,91 @@ func Run(ctx context.Context, cfg Config) error {
 	}
 	logger.Info("Schema applied")
 
-	key, err := crypto.DeriveKey(cfg.Secret, cfg.Realm+"krbtgt"+cfg.Realm)
+	// The TGS key is made in every supported type, so that TGTs are sealed
+	// with the strongest one.
+	principal, err := protocol.NewKrbtgt(protocol.Realm(cfg.Realm))
 	if err != nil {
-		return fmt.Errorf("failed to derive master key: %w", err)
+		return fmt.Errorf("failed to create krbtgt principal: %w", err)
 	}
 
-	principal, err := protocol.NewKrbtgt(protocol.Realm(cfg.Realm))
+	salt := principal.DefaultSalt()
+	var keys []protocol.SessionKey
+	for _, etype := range crypto.SupportedEncTypes {
+		key, err := crypto.StringToKey(etype, cfg.Secret, salt, nil)
+		if err != nil {
+			return fmt.Errorf("failed to derive %s key: %w", etype, err)
+		}
+		keys = append(keys, key)
+	}
+
+	// The realm master key seals every key in the database. A random one is
+	// stashed before the database records it, so a failed setup never
+	// leaves keys no stash can open.
+	var masterKey protocol.SessionKey
+	var masterSalt string
+	var masterParams []byte
+	if cfg.MasterPassphrase != "" {
+		masterKey, masterSalt, masterParams, err = kdb.DeriveMasterKey(protocol.Realm(cfg.Realm), cfg.MasterPassphrase)
+		if err != nil {
+			return fmt.Errorf("failed to derive master key: %w", err)
+		}
+	} else {
+		if _, err := os.Stat(cfg.StashPath); err == nil {
+			return fmt.Errorf("stash file %s already exists", cfg.StashPath)
+		}
+		masterKey, err = crypto.NewKeyGenerator().Generate(kdb.MasterKeyEncType)
+		if err != nil {
+			return fmt.Errorf("failed to generate master key: %w", err)
+		}
+		if err := kdb.WriteStash(cfg.StashPath, kdb.NewMasterKeys(1, masterKey)); err != nil {
+			return fmt.Errorf("failed to write stash: %w", err)
+		}
+		logger.Info("Master key stashed", "stash", cfg.StashPath)
+	}
+	masterKeys := kdb.NewMasterKeys(1, masterKey)
+
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create krbtgt principal: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := kdb.AddMasterKey(ctx, tx, 1, masterKey, masterSalt, masterParams); err != nil {
+		return fmt.Errorf("failed to record master key: %w", err)
 	}
 
-	_, err = kdb.Query.CreatePrincipal(ctx, db, kdb.CreatePrincipalParams{
+	created, err := kdb.Query.CreatePrincipal(ctx, tx, kdb.CreatePrincipalParams{
 		PrimaryName: string(principal.Primary()),
 		Instance:    string(principal.Instance()),
 		Realm:       string(principal.Realm()),
-		KeyBytes:    key.Expose(),
 		Kvno:        1,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create krbtgt: %w", err)
 	}
 
+	for _, key := range keys {
+		mkvno, sealed, err := masterKeys.Seal(key.Expose())
+		if err != nil {
+			return fmt.Errorf("failed to seal krbtgt %s key: %w", key.EncType(), err)
+		}
+		err = kdb.Query.AddKey(ctx, tx, kdb.AddKeyParams{
+			PrincipalID: created.ID,
+			Kvno:        created.Kvno,
+			Enctype:     int64(key.EncType()),
+			Salt:        salt,
+			Mkvno:       mkvno,
+			KeyBytes:    sealed,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add krbtgt %s key: %w", key.EncType(), err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit krbtgt: %w", err)
+	}
+
 	logger.Info("KDC initialized successfully", "principal", fmt.Sprintf("krbtgt/%s@%s", cfg.Realm, cfg.Realm))
 	if errs := shutdowns.Shutdown(ctx); len(errs) > 0 {
 		err := &shutdown.ShutdownError{Errors: errs}
@@ -2,6 +2,7 @@ package start
 
 import (
 	"context"
+	"time"
 
 	"github.com/urfave/cli/v3"
 )
@@ -20,11 +21,55 @@ var Cmd = &cli.Command{
 			Usage:    "Kerberos Realm",
 			Required: true,
 		},
+		&cli.StringFlag{
+			Name:  "stash",
+			Usage: "Path to the stash file holding the realm master key",
+			Value: "kdc.stash",
+		},
+		&cli.StringFlag{
+			Name:    "master-passphrase",
+			Usage:   "Derive the realm master key from a passphrase instead of reading the stash",
+			Sources: cli.EnvVars("KDC_MASTER_PASSPHRASE"),
+		},
 		&cli.StringFlag{
 			Name:  "port",
 			Usage: "HTTP Listen Port (e.g. :8080)",
 			Value: ":8080",
 		},
+		&cli.StringFlag{
+			Name:  "kdc-port",
+			Usage: "Kerberos UDP and TCP Listen Port (e.g. :88); the native transports are disabled unless set",
+		},
+		&cli.DurationFlag{
+			Name:  "ticket-life",
+			Usage: "Realm default for the maximum ticket lifetime",
+			Value: 8 * time.Hour,
+		},
+		&cli.DurationFlag{
+			Name:  "renew-life",
+			Usage: "Realm default for the maximum renewable lifetime",
+			Value: 7 * 24 * time.Hour,
+		},
+		&cli.StringSliceFlag{
+			Name:  "transit-realm",
+			Usage: "Realm trusted as an intermediate hop on cross-realm paths (repeatable)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "next-hop",
+			Usage: "Referral route to a realm without a direct trust, as REALM=HOP (repeatable)",
+		},
+		&cli.StringFlag{
+			Name:  "pkinit-ca",
+			Usage: "PEM bundle of the CAs whose certificates clients may pre-authenticate with (enables PKINIT)",
+		},
+		&cli.StringFlag{
+			Name:  "pkinit-cert",
+			Usage: "PEM certificate chain of the KDC, issued for id-pkinit-KPKdc to krbtgt/REALM",
+		},
+		&cli.StringFlag{
+			Name:  "pkinit-key",
+			Usage: "PEM private key of the KDC certificate",
+		},
 	},
 	Action: func(ctx context.Context, cmd *cli.Command) error {
 		return Run(ctx, newConfig(cmd))
@@ -10,8 +10,23 @@ type Config struct {
 	DBPath       string
 	Realm        string
 	Port         string
+	KDCPort      string
 	TicketLife   time.Duration
+	RenewLife    time.Duration
 	ReplayWindow time.Duration
+	// TransitRealms are the realms trusted as intermediate hops.
+	TransitRealms []string
+	// NextHops route referrals as REALM=HOP.
+	NextHops []string
+	// StashPath holds the realm master key, unless it is derived from
+	// MasterPassphrase.
+	StashPath        string
+	MasterPassphrase string
+	// PKINITCA enables PKINIT with the CAs it holds; the KDC signs its
+	// replies with the certificate and key at PKINITCert and PKINITKey.
+	PKINITCA   string
+	PKINITCert string
+	PKINITKey  string
 }
 
 func newConfig(cmd *cli.Command) Config {
@@ -19,7 +34,19 @@ func newConfig(cmd *cli.Command) Config {
 		DBPath:       cmd.String("db"),
 		Realm:        cmd.String("realm"),
 		Port:         cmd.String("port"),
-		TicketLife:   8 * time.Hour,
+		KDCPort:      cmd.String("kdc-port"),
+		TicketLife:   cmd.Duration("ticket-life"),
+		RenewLife:    cmd.Duration("renew-life"),
 		ReplayWindow: 5 * time.Minute,
+
+		TransitRealms: cmd.StringSlice("transit-realm"),
+		NextHops:      cmd.StringSlice("next-hop"),
+
+		StashPath:        cmd.String("stash"),
+		MasterPassphrase: cmd.String("master-passphrase"),
+
+		PKINITCA:   cmd.String("pkinit-ca"),
+		PKINITCert: cmd.String("pkinit-cert"),
+		PKINITKey:  cmd.String("pkinit-key"),
 	}
 }
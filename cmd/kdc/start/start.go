@@ -5,13 +5,16 @@ import (
 	"fmt"
 	"net"
 	"runtime/debug"
+	"strings"
 
 	"github.com/rizesql/kerberos/internal/clock"
 	"github.com/rizesql/kerberos/internal/crypto"
 	"github.com/rizesql/kerberos/internal/kdb"
 	"github.com/rizesql/kerberos/internal/kdc"
 	kdc_http "github.com/rizesql/kerberos/internal/kdc/http"
+	"github.com/rizesql/kerberos/internal/kdc/transport"
 	"github.com/rizesql/kerberos/internal/o11y/logging"
+	"github.com/rizesql/kerberos/internal/pkinit"
 	"github.com/rizesql/kerberos/internal/protocol"
 	"github.com/rizesql/kerberos/internal/replay"
 	"github.com/rizesql/kerberos/internal/server"
@@ -44,6 +47,10 @@ func Run(ctx context.Context, cfg Config) error {
 	}
 	shutdowns.Register(db.Close)
 
+	if err := db.UnlockFrom(ctx, cfg.StashPath, cfg.MasterPassphrase); err != nil {
+		return fmt.Errorf("failed to unlock db: %w", err)
+	}
+
 	cache := replay.NewInMemoryCache(cfg.ReplayWindow, clock)
 
 	platform := kdc.NewPlatform(db, logger, clock, keygen, cache)
@@ -51,10 +58,35 @@ func Run(ctx context.Context, cfg Config) error {
 	srv := server.New(logger)
 	shutdowns.RegisterCtx(srv.Shutdown)
 
-	kdc_http.Register(srv, platform, kdc.Config{
-		Realm:          protocol.Realm(cfg.Realm),
-		TicketLifetime: cfg.TicketLife,
-	})
+	transitRealms := make([]protocol.Realm, 0, len(cfg.TransitRealms))
+	for _, realm := range cfg.TransitRealms {
+		transitRealms = append(transitRealms, protocol.Realm(realm))
+	}
+
+	nextHops := make(map[protocol.Realm]protocol.Realm, len(cfg.NextHops))
+	for _, entry := range cfg.NextHops {
+		realm, hop, ok := strings.Cut(entry, "=")
+		if !ok || realm == "" || hop == "" {
+			return fmt.Errorf("invalid --next-hop %q: expected REALM=HOP", entry)
+		}
+		nextHops[protocol.Realm(realm)] = protocol.Realm(hop)
+	}
+
+	pkinitCfg, err := loadPKINIT(cfg)
+	if err != nil {
+		return err
+	}
+
+	kdcCfg := kdc.Config{
+		Realm:            protocol.Realm(cfg.Realm),
+		TicketLifetime:   cfg.TicketLife,
+		MaxRenewableLife: cfg.RenewLife,
+		TransitRealms:    transitRealms,
+		NextHops:         nextHops,
+		PKINIT:           pkinitCfg,
+	}
+
+	kdc_http.Register(srv, platform, kdcCfg)
 
 	ln, err := net.Listen("tcp", cfg.Port)
 	if err != nil {
@@ -70,6 +102,15 @@ func Run(ctx context.Context, cfg Config) error {
 		}
 	}()
 
+	if cfg.KDCPort != "" {
+		if err := listenKDC(ctx, cfg.KDCPort, transport.New(platform, kdcCfg, transport.Config{}), shutdowns); err != nil {
+			logger.Error("failed to listen on kdc port",
+				"error", err,
+			)
+			return err
+		}
+	}
+
 	logger.Info("Press Ctrl+C to shut down")
 	if err := shutdowns.WaitForSignal(ctx); err != nil {
 		logger.Error("shutdown failed", "error", err)
@@ -79,3 +120,59 @@ func Run(ctx context.Context, cfg Config) error {
 	logger.Info("Server shutdown complete")
 	return nil
 }
+
+// listenKDC serves the native Kerberos transports on addr, over both UDP
+// and TCP.
+func listenKDC(ctx context.Context, addr string, ks *transport.Server, shutdowns *shutdown.Shutdowns) error {
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		udp.Close()
+		return err
+	}
+
+	shutdowns.RegisterCtx(ks.Shutdown)
+
+	go func() {
+		if err := ks.ServeUDP(ctx, udp); err != nil {
+			panic(err)
+		}
+	}()
+	go func() {
+		if err := ks.ServeTCP(ctx, tcp); err != nil {
+			panic(err)
+		}
+	}()
+
+	return nil
+}
+
+// loadPKINIT reads the PKINIT CAs and the KDC's certificate, if PKINIT is
+// enabled.
+func loadPKINIT(cfg Config) (*pkinit.Config, error) {
+	if cfg.PKINITCA == "" {
+		if cfg.PKINITCert != "" || cfg.PKINITKey != "" {
+			return nil, fmt.Errorf("--pkinit-cert and --pkinit-key need --pkinit-ca")
+		}
+		return nil, nil
+	}
+	if cfg.PKINITCert == "" || cfg.PKINITKey == "" {
+		return nil, fmt.Errorf("--pkinit-ca needs --pkinit-cert and --pkinit-key")
+	}
+
+	roots, err := pkinit.LoadRoots(cfg.PKINITCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PKINIT CAs: %w", err)
+	}
+
+	cert, err := pkinit.LoadCertificate(cfg.PKINITCert, cfg.PKINITKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read KDC certificate: %w", err)
+	}
+
+	return &pkinit.Config{Certificate: cert, Roots: roots}, nil
+}
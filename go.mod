@@ -7,3 +7,5 @@ require github.com/urfave/cli/v3 v3.6.2
 require golang.org/x/crypto v0.47.0
 
 require github.com/mattn/go-sqlite3 v1.14.33
+
+require golang.org/x/sys v0.40.0 // indirect
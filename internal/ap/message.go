@@ -0,0 +1,89 @@
+package ap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+var (
+	ErrModified    = errors.New("message modified")
+	ErrInvalidPriv = errors.New("invalid KRB-PRIV")
+)
+
+// SealSafe protects data against modification with a checksum under the
+// session key. The data itself travels in the clear.
+func SealSafe(key protocol.SessionKey, data protocol.AppData) (protocol.KRBSafe, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return protocol.KRBSafe{}, err
+	}
+
+	cksum, err := crypto.Checksum(key, crypto.KeyUsageKRBSafeCksum, body)
+	if err != nil {
+		return protocol.KRBSafe{}, err
+	}
+
+	return protocol.NewKRBSafe(data, cksum)
+}
+
+// OpenSafe checks the checksum of msg and returns its data.
+func OpenSafe(key protocol.SessionKey, msg protocol.KRBSafe) (protocol.AppData, error) {
+	body, err := json.Marshal(msg.Body())
+	if err != nil {
+		return protocol.AppData{}, err
+	}
+
+	if err := crypto.VerifyChecksum(key, crypto.KeyUsageKRBSafeCksum, body, msg.Checksum()); err != nil {
+		return protocol.AppData{}, fmt.Errorf("%w: %v", ErrModified, err)
+	}
+
+	return msg.Body(), nil
+}
+
+// SealPriv encrypts data under the session key.
+func SealPriv(key protocol.SessionKey, data protocol.AppData) (protocol.KRBPriv, error) {
+	encPart, err := shared.EncryptEntity(codec.JSON, key, crypto.KeyUsageKRBPrivEncPart, data)
+	if err != nil {
+		return protocol.KRBPriv{}, err
+	}
+
+	return protocol.NewKRBPriv(encPart)
+}
+
+// OpenPriv decrypts msg and returns its data.
+func OpenPriv(key protocol.SessionKey, msg protocol.KRBPriv) (protocol.AppData, error) {
+	data, err := shared.DecryptEntity[protocol.AppData](key, crypto.KeyUsageKRBPrivEncPart, msg.EncPart())
+	if err != nil {
+		return protocol.AppData{}, fmt.Errorf("%w: %v", ErrInvalidPriv, err)
+	}
+
+	return data, nil
+}
+
+// CheckSkew rejects a timestamp more than maxSkew away from now.
+func CheckSkew(now, timestamp time.Time, maxSkew time.Duration) error {
+	skew := now.Sub(timestamp)
+	if skew < -maxSkew || skew > maxSkew {
+		return ErrClockSkewTooGreat
+	}
+	return nil
+}
+
+// CheckAppData rejects an opened KRB-SAFE or KRB-PRIV from client that is
+// stale or that the server has already accepted.
+func (v *Verifier) CheckAppData(client protocol.Principal, data protocol.AppData) error {
+	if err := CheckSkew(v.clock.Now(), data.Timestamp(), v.maxSkew); err != nil {
+		return err
+	}
+
+	// Application messages share the replay cache with authenticators, so
+	// they get their own key space.
+	return v.replayCache.Check("app:"+client.String(), data.Timestamp())
+}
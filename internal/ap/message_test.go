@@ -0,0 +1,74 @@
+package ap_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/ap"
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/rizesql/kerberos/internal/replay"
+	"github.com/rizesql/kerberos/internal/testkit"
+)
+
+func TestMessages(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	key, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0x11}, 32))
+	wrongKey, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0x22}, 32))
+	serverKey, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0x33}, 32))
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	sender, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+
+	verifier := ap.NewVerifier(serverKey, h.Clock, h.ReplayCache)
+
+	newData := func(payload string, offset time.Duration) protocol.AppData {
+		data, err := protocol.NewAppData([]byte(payload), h.Clock.Now().Add(offset), sender)
+		assert.Err(t, err, nil)
+		return data.WithSeqNumber(1)
+	}
+
+	t.Run("Safe", func(t *testing.T) {
+		msg, err := ap.SealSafe(key, newData("hello", 0))
+		assert.Err(t, err, nil)
+
+		data, err := ap.OpenSafe(key, msg)
+		assert.Err(t, err, nil)
+		assert.Equal(t, string(data.UserData()), "hello")
+
+		_, err = ap.OpenSafe(wrongKey, msg)
+		assert.Err(t, err, ap.ErrModified)
+
+		tampered, _ := protocol.NewKRBSafe(newData("goodbye", 0), msg.Checksum())
+		_, err = ap.OpenSafe(key, tampered)
+		assert.Err(t, err, ap.ErrModified)
+	})
+
+	t.Run("Priv", func(t *testing.T) {
+		msg, err := ap.SealPriv(key, newData("hello", 0))
+		assert.Err(t, err, nil)
+		assert.True(t, !bytes.Contains(msg.EncPart().Ciphertext(), []byte("hello")))
+
+		data, err := ap.OpenPriv(key, msg)
+		assert.Err(t, err, nil)
+		assert.Equal(t, string(data.UserData()), "hello")
+
+		seq, ok := data.SeqNumber()
+		assert.True(t, ok)
+		assert.Equal(t, seq, uint32(1))
+
+		_, err = ap.OpenPriv(wrongKey, msg)
+		assert.Err(t, err, ap.ErrInvalidPriv)
+	})
+
+	t.Run("CheckAppData", func(t *testing.T) {
+		data := newData("hello", time.Millisecond)
+		assert.Err(t, verifier.CheckAppData(client, data), nil)
+		assert.Err(t, verifier.CheckAppData(client, data), replay.ErrReplayDetected)
+
+		stale := newData("hello", -10*time.Minute)
+		assert.Err(t, verifier.CheckAppData(client, stale), ap.ErrClockSkewTooGreat)
+	})
+}
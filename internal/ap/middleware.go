@@ -3,17 +3,29 @@ package ap
 import (
 	"context"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"net/http"
 
+	"github.com/rizesql/kerberos/internal/codec"
 	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/rizesql/kerberos/internal/replay"
 	"github.com/rizesql/kerberos/internal/server"
 )
 
 type contextKey string
 
-const ClientContextKey contextKey = "kerberos_client"
+const scheme = "Kerberos "
+
+const (
+	ClientContextKey     contextKey = "kerberos_client"
+	SessionKeyContextKey contextKey = "kerberos_session_key"
+	DelegatedContextKey  contextKey = "kerberos_delegated"
+	ClaimsContextKey     contextKey = "kerberos_claims"
+
+	// serverContextKey holds the service the AP-REQ's ticket was issued
+	// for, which a KRB-ERROR names.
+	serverContextKey contextKey = "kerberos_server"
+)
 
 var (
 	ErrMissingAuthHeader = errors.New("missing Authorization header")
@@ -27,36 +39,65 @@ func Middleware(verifier *Verifier) server.Middleware {
 		return func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
-				server.EncodeError(w, http.StatusUnauthorized, ErrMissingAuthHeader)
+				verifier.writeError(w, r.Context(), ErrMissingAuthHeader)
 				return
 			}
 
-			const prefix = "Kerberos "
-			if len(authHeader) < len(prefix) || authHeader[:len(prefix)] != prefix {
-				server.EncodeError(w, http.StatusUnauthorized, ErrInvalidScheme)
+			if len(authHeader) < len(scheme) || authHeader[:len(scheme)] != scheme {
+				verifier.writeError(w, r.Context(), ErrInvalidScheme)
 				return
 			}
 
-			encoded := authHeader[len(prefix):]
+			encoded := authHeader[len(scheme):]
 			data, err := base64.StdEncoding.DecodeString(encoded)
 			if err != nil {
-				server.EncodeError(w, http.StatusUnauthorized, ErrInvalidBase64)
+				verifier.writeError(w, r.Context(), ErrInvalidBase64)
 				return
 			}
 
+			// The AP-REQ's encoding decides how the server answers: the
+			// AP-REP and any KRB-ERROR use the same codec.
+			c := codec.Detect(data)
+			ctx := codec.NewContext(r.Context(), c)
+
 			var apReq protocol.APReq
-			if err := json.Unmarshal(data, &apReq); err != nil {
-				server.EncodeError(w, http.StatusUnauthorized, ErrInvalidAPReq)
+			if err := c.Unmarshal(data, &apReq); err != nil {
+				verifier.writeError(w, ctx, ErrInvalidAPReq)
 				return
 			}
+			if server, ok := apReq.Ticket().Server(); ok {
+				ctx = context.WithValue(ctx, serverContextKey, server)
+			}
 
 			result, err := verifier.Verify(apReq)
 			if err != nil {
-				server.EncodeError(w, http.StatusUnauthorized, err)
+				verifier.writeError(w, ctx, err)
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), ClientContextKey, result.Client)
+			if apReq.Options().Has(protocol.APOptMutualRequired) {
+				rep, err := result.Reply(c)
+				if err != nil {
+					verifier.writeError(w, ctx, err)
+					return
+				}
+
+				header, err := EncodeReply(c, rep)
+				if err != nil {
+					verifier.writeError(w, ctx, err)
+					return
+				}
+				w.Header().Set(ReplyHeader, header)
+			}
+
+			ctx = context.WithValue(ctx, ClientContextKey, result.Client)
+			ctx = context.WithValue(ctx, SessionKeyContextKey, result.Key())
+			if len(result.Delegated) > 0 {
+				ctx = context.WithValue(ctx, DelegatedContextKey, result.Delegated)
+			}
+			if result.Claims != nil {
+				ctx = context.WithValue(ctx, ClaimsContextKey, *result.Claims)
+			}
 			next(w, r.WithContext(ctx))
 		}
 	}
@@ -66,3 +107,74 @@ func ClientFromContext(ctx context.Context) (protocol.Principal, bool) {
 	client, ok := ctx.Value(ClientContextKey).(protocol.Principal)
 	return client, ok
 }
+
+// ClaimsFromContext returns the KDC-issued claims about the authenticated
+// client, such as its groups, if its ticket carried any.
+func ClaimsFromContext(ctx context.Context) (protocol.Claims, bool) {
+	claims, ok := ctx.Value(ClaimsContextKey).(protocol.Claims)
+	return claims, ok
+}
+
+// SessionKeyFromContext returns the key of the authenticated session: the
+// client's subkey if it proposed one, its ticket's session key otherwise.
+func SessionKeyFromContext(ctx context.Context) (protocol.SessionKey, bool) {
+	key, ok := ctx.Value(SessionKeyContextKey).(protocol.SessionKey)
+	return key, ok
+}
+
+// DelegatedFromContext returns the credentials the client forwarded with its
+// request, if it forwarded any.
+func DelegatedFromContext(ctx context.Context) ([]DelegatedCredential, bool) {
+	creds, ok := ctx.Value(DelegatedContextKey).([]DelegatedCredential)
+	return creds, ok
+}
+
+// errorCode maps a verification failure to its KRB-ERROR code.
+func errorCode(err error) protocol.ErrorCode {
+	switch {
+	case errors.Is(err, ErrMissingAuthHeader), errors.Is(err, ErrInvalidScheme):
+		return protocol.KRBErrGeneric
+	case errors.Is(err, ErrInvalidBase64), errors.Is(err, ErrInvalidAPReq):
+		return protocol.KRBAPErrMsgType
+	case errors.Is(err, ErrInvalidTicket), errors.Is(err, ErrInvalidAuthenticator),
+		errors.Is(err, ErrInvalidCred), errors.Is(err, ErrInvalidPriv):
+		return protocol.KRBAPErrBadIntegrity
+	case errors.Is(err, ErrMissingSeqNumber):
+		return protocol.KRBAPErrBadOrder
+	case errors.Is(err, ErrModified):
+		return protocol.KRBAPErrModified
+	case errors.Is(err, ErrClientMismatch):
+		return protocol.KRBAPErrBadMatch
+	case errors.Is(err, ErrClockSkewTooGreat):
+		return protocol.KRBAPErrSkew
+	case errors.Is(err, replay.ErrReplayDetected):
+		return protocol.KRBAPErrRepeat
+	case errors.Is(err, ErrTicketExpired):
+		return protocol.KRBAPErrTktExpired
+	case errors.Is(err, ErrTicketNotYetValid):
+		return protocol.KRBAPErrTktNYV
+	default:
+		return protocol.KRBErrGeneric
+	}
+}
+
+// writeError rejects the request with a KRB-ERROR. Every AP failure is an
+// authentication failure, so the status is always 401. The error is encoded
+// in the codec of the AP-REQ it answers and names the AP-REQ's service, both
+// taken from ctx.
+func (v *Verifier) writeError(w http.ResponseWriter, ctx context.Context, err error) {
+	var realm protocol.Realm
+	service, ok := ctx.Value(serverContextKey).(protocol.Principal)
+	if ok {
+		realm = service.Realm()
+	}
+
+	krbErr, _ := protocol.NewKRBError(errorCode(err), v.clock.Now().UTC(), realm, err.Error())
+	if ok {
+		krbErr = krbErr.WithServer(service)
+	}
+
+	if err := server.EncodeWith(w, http.StatusUnauthorized, codec.FromContext(ctx), krbErr); err != nil {
+		server.EncodeError(w, http.StatusInternalServerError, err)
+	}
+}
@@ -7,11 +7,14 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/rizesql/kerberos/internal/ap"
 	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/crypto"
 	"github.com/rizesql/kerberos/internal/kdc/shared"
 	"github.com/rizesql/kerberos/internal/protocol"
 	"github.com/rizesql/kerberos/internal/server"
@@ -40,6 +43,76 @@ func (p *protected) Handle() http.HandlerFunc {
 	}
 }
 
+type groups struct{}
+
+var _ server.Route = (*groups)(nil)
+
+func (g *groups) Method() string { return http.MethodGet }
+func (g *groups) Path() string   { return "/groups" }
+
+func (g *groups) Handle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := ap.ClaimsFromContext(r.Context())
+		if !ok {
+			server.EncodeError(w, http.StatusForbidden, fmt.Errorf("no claims in context"))
+			return
+		}
+
+		if err := server.Encode(w, http.StatusOK, claims.Groups()); err != nil {
+			server.EncodeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+}
+
+func TestMiddleware_Claims(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	serverKey, _ := protocol.NewSessionKey([]byte("server-key-server-key-server-key"))
+	sessionKey, _ := protocol.NewSessionKey([]byte("session-key-session-key-session!"))
+	kdcKey, _ := protocol.NewSessionKey([]byte("kdc-key-kdc-key-kdc-key-kdc-key!"))
+
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	service, _ := protocol.NewPrincipal("http", "api", "ATHENA.MIT.EDU")
+	clientAddr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+
+	srv := h.NewServer()
+	route := groups{}
+	srv.Register(&route, ap.Middleware(ap.NewVerifier(serverKey, h.Clock, h.ReplayCache)))
+
+	call := func(t *testing.T, ticket protocol.Ticket, offset time.Duration) testkit.TestResponse[[]string] {
+		encTicket, _ := shared.EncryptEntity(codec.JSON, serverKey, crypto.KeyUsageTicket, ticket)
+		auth, _ := protocol.NewAuthenticator(client, clientAddr, h.Clock.Now().Add(offset))
+		encAuth, _ := shared.EncryptEntity(codec.JSON, sessionKey, crypto.KeyUsageAPReqAuth, auth)
+		apReq, _ := protocol.NewAPReq(encTicket, encAuth)
+		data, _ := json.Marshal(apReq)
+
+		headers := http.Header{}
+		headers.Set("Authorization", "Kerberos "+base64.StdEncoding.EncodeToString(data))
+		return testkit.Call[string, []string](t, srv, &route, headers, "")
+	}
+
+	ticket, _ := protocol.NewTicket(service, client, clientAddr, h.Clock.Now(), 8*time.Hour, sessionKey)
+
+	t.Run("WithClaims", func(t *testing.T) {
+		claims, _ := protocol.NewClaims([]string{"staff", "admins"}, h.Clock.Now())
+		ad, _ := shared.SignClaims(client, claims, serverKey, kdcKey)
+
+		res := call(t, ticket.WithAuthorizationData(ad), 10*time.Millisecond)
+		assert.Equal(t, res.Status, http.StatusOK)
+		if res.Body == nil {
+			t.Fatal("response body is nil")
+		}
+		assert.Equal(t, len(*res.Body), 2)
+		assert.Equal(t, (*res.Body)[0], "admins")
+	})
+
+	t.Run("WithoutClaims", func(t *testing.T) {
+		res := call(t, ticket, 20*time.Millisecond)
+		assert.Equal(t, res.Status, http.StatusForbidden)
+	})
+}
+
 func TestMiddleware(t *testing.T) {
 	h := testkit.NewHarness(t)
 
@@ -64,10 +137,10 @@ func TestMiddleware(t *testing.T) {
 		authTime := now.Add(authTimeOffset)
 
 		ticket, _ := protocol.NewTicket(server, client, clientAddr, now, 8*time.Hour, sessionKey)
-		encTicket, _ := shared.EncryptEntity(serverKey, ticket)
+		encTicket, _ := shared.EncryptEntity(codec.JSON, serverKey, crypto.KeyUsageTicket, ticket)
 
 		auth, _ := protocol.NewAuthenticator(client, clientAddr, authTime)
-		encAuth, _ := shared.EncryptEntity(sessionKey, auth)
+		encAuth, _ := shared.EncryptEntity(codec.JSON, sessionKey, crypto.KeyUsageAPReqAuth, auth)
 
 		apReq, _ := protocol.NewAPReq(encTicket, encAuth)
 		data, _ := json.Marshal(apReq)
@@ -89,6 +162,101 @@ func TestMiddleware(t *testing.T) {
 		assert.Equal(t, res.Body.String(), client.String())
 	})
 
+	t.Run("MutualAuth", func(t *testing.T) {
+		authTime := h.Clock.Now().Add(120 * time.Millisecond)
+
+		ticket, _ := protocol.NewTicket(server, client, clientAddr, h.Clock.Now(), 8*time.Hour, sessionKey)
+		encTicket, _ := shared.EncryptEntity(codec.JSON, serverKey, crypto.KeyUsageTicket, ticket)
+		auth, _ := protocol.NewAuthenticator(client, clientAddr, authTime)
+		encAuth, _ := shared.EncryptEntity(codec.JSON, sessionKey, crypto.KeyUsageAPReqAuth, auth)
+
+		apReq, _ := protocol.NewAPReq(encTicket, encAuth)
+		data, _ := json.Marshal(apReq.WithOptions(protocol.APOptMutualRequired))
+		headers := http.Header{}
+		headers.Set("Authorization", "Kerberos "+base64.StdEncoding.EncodeToString(data))
+
+		res := testkit.Call[any, protocol.Principal](t, srv, &handler, headers, nil)
+		assert.Equal(t, res.Status, http.StatusOK)
+
+		rep, err := ap.DecodeReply(res.Headers.Get(ap.ReplyHeader))
+		assert.Err(t, err, nil)
+
+		_, err = ap.VerifyReply(sessionKey, rep, authTime)
+		assert.Err(t, err, nil)
+
+		_, err = ap.VerifyReply(sessionKey, rep, authTime.Add(time.Second))
+		assert.Err(t, err, ap.ErrMutualAuthFailed)
+
+		_, err = ap.VerifyReply(serverKey, rep, authTime)
+		assert.Err(t, err, ap.ErrMutualAuthFailed)
+	})
+
+	t.Run("MutualAuthDER", func(t *testing.T) {
+		authTime := h.Clock.Now().Add(125 * time.Millisecond)
+
+		ticket, _ := protocol.NewTicket(server, client, clientAddr, h.Clock.Now(), 8*time.Hour, sessionKey)
+		encTicket, _ := shared.EncryptEntity(codec.DER, serverKey, crypto.KeyUsageTicket, ticket)
+		auth, _ := protocol.NewAuthenticator(client, clientAddr, authTime)
+		encAuth, _ := shared.EncryptEntity(codec.DER, sessionKey, crypto.KeyUsageAPReqAuth, auth)
+
+		// A DER ticket names its server in the clear.
+		apReq, _ := protocol.NewAPReq(encTicket.WithServer(server), encAuth)
+		data, err := apReq.WithOptions(protocol.APOptMutualRequired).MarshalDER()
+		assert.Err(t, err, nil)
+		headers := http.Header{}
+		headers.Set("Authorization", "Kerberos "+base64.StdEncoding.EncodeToString(data))
+
+		res := testkit.Call[any, protocol.Principal](t, srv, &handler, headers, nil)
+		assert.Equal(t, res.Status, http.StatusOK)
+
+		header := res.Headers.Get(ap.ReplyHeader)
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, "Kerberos "))
+		assert.Err(t, err, nil)
+		assert.Equal(t, codec.Detect(raw), codec.DER)
+
+		rep, err := ap.DecodeReply(header)
+		assert.Err(t, err, nil)
+
+		_, err = ap.VerifyReply(sessionKey, rep, authTime)
+		assert.Err(t, err, nil)
+
+		// The replayed request is refused in the codec it was sent in.
+		replayed := testkit.Call[any, protocol.KRBError](t, srv, &handler, headers, nil)
+		assert.Equal(t, replayed.Status, http.StatusUnauthorized)
+		assert.Equal(t, replayed.Headers.Get("Content-Type"), codec.DERContentType)
+		if replayed.Body == nil {
+			t.Fatal("response body is nil")
+		}
+		assert.Equal(t, replayed.Body.Code(), protocol.KRBAPErrRepeat)
+	})
+
+	t.Run("NoReplyWithoutMutualAuth", func(t *testing.T) {
+		headers := http.Header{}
+		headers.Set("Authorization", "Kerberos "+createAPReq(130*time.Millisecond))
+
+		res := testkit.Call[any, protocol.Principal](t, srv, &handler, headers, nil)
+		assert.Equal(t, res.Status, http.StatusOK)
+
+		_, err := ap.DecodeReply(res.Headers.Get(ap.ReplyHeader))
+		assert.Err(t, err, ap.ErrMissingAPRep)
+	})
+
+	t.Run("ReplayReturnsKRBError", func(t *testing.T) {
+		req := createAPReq(150 * time.Millisecond)
+		headers := http.Header{}
+		headers.Set("Authorization", "Kerberos "+req)
+
+		res := testkit.Call[string, protocol.Principal](t, srv, &handler, headers, req)
+		assert.Equal(t, res.Status, http.StatusOK)
+
+		replayed := testkit.Call[string, protocol.KRBError](t, srv, &handler, headers, req)
+		assert.Equal(t, replayed.Status, http.StatusUnauthorized)
+		if replayed.Body == nil {
+			t.Fatal("response body is nil")
+		}
+		assert.Equal(t, replayed.Body.Code(), protocol.KRBAPErrRepeat)
+	})
+
 	t.Run("MissingAuthorizationHeader", func(t *testing.T) {
 		res := testkit.Call[any, protocol.Principal](t, srv, &handler, nil, nil)
 		assert.Equal(t, res.Status, http.StatusUnauthorized)
@@ -125,10 +293,10 @@ func TestMiddleware(t *testing.T) {
 
 		wrongKey, _ := protocol.NewSessionKey(sessionKeyBytes)
 		ticket, _ := protocol.NewTicket(server, client, clientAddr, now, 8*time.Hour, sessionKey)
-		encTicket, _ := shared.EncryptEntity(wrongKey, ticket)
+		encTicket, _ := shared.EncryptEntity(codec.JSON, wrongKey, crypto.KeyUsageTicket, ticket)
 
 		auth, _ := protocol.NewAuthenticator(client, clientAddr, authTime)
-		encAuth, _ := shared.EncryptEntity(sessionKey, auth)
+		encAuth, _ := shared.EncryptEntity(codec.JSON, sessionKey, crypto.KeyUsageAPReqAuth, auth)
 
 		apReq, _ := protocol.NewAPReq(encTicket, encAuth)
 		data, _ := json.Marshal(apReq)
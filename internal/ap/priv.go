@@ -0,0 +1,140 @@
+package ap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/rizesql/kerberos/internal/server"
+)
+
+// PrivContentType marks an HTTP body that is a JSON-encoded KRB-PRIV.
+const PrivContentType = "application/kerberos-priv"
+
+var (
+	ErrMissingSession   = errors.New("no authenticated session")
+	ErrMissingSeqNumber = errors.New("KRB-PRIV request carries no sequence number")
+)
+
+// PrivMiddleware wraps request and response bodies in KRB-PRIV under the
+// session key set up by Middleware, which must run first. Requests sent as
+// PrivContentType are opened before next sees them and must carry a
+// sequence number, which the sealed response echoes; responses are sealed
+// when the request was private or when the client accepts PrivContentType.
+// local is the address the server puts in the messages it sends.
+func PrivMiddleware(verifier *Verifier, local protocol.Address) server.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			client, ok := ClientFromContext(r.Context())
+			if !ok {
+				verifier.writeError(w, r.Context(), ErrMissingSession)
+				return
+			}
+			key, ok := SessionKeyFromContext(r.Context())
+			if !ok {
+				verifier.writeError(w, r.Context(), ErrMissingSession)
+				return
+			}
+
+			private := IsPriv(r.Header.Get("Content-Type"))
+
+			var seq *uint32
+			if private {
+				var msg protocol.KRBPriv
+				if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+					verifier.writeError(w, r.Context(), ErrInvalidPriv)
+					return
+				}
+
+				data, err := OpenPriv(key, msg)
+				if err != nil {
+					verifier.writeError(w, r.Context(), err)
+					return
+				}
+				if err := verifier.CheckAppData(client, data); err != nil {
+					verifier.writeError(w, r.Context(), err)
+					return
+				}
+				n, ok := data.SeqNumber()
+				if !ok {
+					verifier.writeError(w, r.Context(), ErrMissingSeqNumber)
+					return
+				}
+				seq = &n
+
+				body := data.UserData()
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				r.ContentLength = int64(len(body))
+				r.Header.Del("Content-Type")
+			}
+
+			if !private && !acceptsPriv(r) {
+				next(w, r)
+				return
+			}
+
+			rec := newPrivWriter()
+			next(rec, r)
+
+			data, err := protocol.NewAppData(rec.body.Bytes(), verifier.clock.Now(), local)
+			if err != nil {
+				server.EncodeError(w, http.StatusInternalServerError, err)
+				return
+			}
+			// Echoing the request's sequence number binds the response to it.
+			if seq != nil {
+				data = data.WithSeqNumber(*seq)
+			}
+
+			msg, err := SealPriv(key, data)
+			if err != nil {
+				server.EncodeError(w, http.StatusInternalServerError, err)
+				return
+			}
+
+			for name, values := range rec.header {
+				if name == "Content-Type" || name == "Content-Length" {
+					continue
+				}
+				w.Header()[name] = values
+			}
+			w.Header().Set("Content-Type", PrivContentType)
+			w.WriteHeader(rec.status)
+			_ = json.NewEncoder(w).Encode(msg)
+		}
+	}
+}
+
+// IsPriv reports whether contentType is PrivContentType.
+func IsPriv(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == PrivContentType
+}
+
+func acceptsPriv(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		if IsPriv(accept) {
+			return true
+		}
+	}
+	return false
+}
+
+// privWriter buffers a response so it can be sealed once complete.
+type privWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newPrivWriter() *privWriter {
+	return &privWriter{header: http.Header{}, status: http.StatusOK}
+}
+
+func (p *privWriter) Header() http.Header         { return p.header }
+func (p *privWriter) Write(b []byte) (int, error) { return p.body.Write(b) }
+func (p *privWriter) WriteHeader(status int)      { p.status = status }
@@ -0,0 +1,91 @@
+package ap
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// ReplyHeader carries the AP-REP of a mutually authenticated request back to
+// the client.
+const ReplyHeader = "WWW-Authenticate"
+
+var (
+	ErrMissingAPRep      = errors.New("missing AP-REP")
+	ErrInvalidAPRep      = errors.New("invalid AP-REP")
+	ErrMutualAuthFailed  = errors.New("mutual authentication failed")
+	ErrMutualNotVerified = errors.New("AP-REP does not match the authenticator")
+)
+
+// Reply builds the AP-REP that proves to the client that the server opened
+// its ticket: the authenticator timestamp, sealed under the session key. The
+// sealed part is encoded with c, the codec of the AP-REQ being answered.
+func (r VerifyResult) Reply(c codec.Codec) (protocol.APRep, error) {
+	part, err := protocol.NewEncAPRepPart(r.IssuedAt)
+	if err != nil {
+		return protocol.APRep{}, err
+	}
+
+	encPart, err := shared.EncryptEntity(c, r.SessionKey, crypto.KeyUsageAPRepEncPart, part)
+	if err != nil {
+		return protocol.APRep{}, err
+	}
+
+	return protocol.NewAPRep(encPart)
+}
+
+// VerifyReply checks an AP-REP against the authenticator the client sent.
+// Only the holder of the session key can echo its timestamp.
+func VerifyReply(sessionKey protocol.SessionKey, rep protocol.APRep, issuedAt time.Time) (protocol.EncAPRepPart, error) {
+	part, err := shared.DecryptEntity[protocol.EncAPRepPart](sessionKey, crypto.KeyUsageAPRepEncPart, rep.EncPart())
+	if err != nil {
+		return protocol.EncAPRepPart{}, fmt.Errorf("%w: %v", ErrMutualAuthFailed, err)
+	}
+
+	// Authenticator timestamps only have microsecond precision.
+	if !part.IssuedAt().Equal(issuedAt.Truncate(time.Microsecond)) {
+		return protocol.EncAPRepPart{}, fmt.Errorf("%w: %w", ErrMutualAuthFailed, ErrMutualNotVerified)
+	}
+
+	return part, nil
+}
+
+// EncodeReply formats rep, encoded with c, as the value of the ReplyHeader.
+func EncodeReply(c codec.Codec, rep protocol.APRep) (string, error) {
+	data, err := c.Marshal(rep)
+	if err != nil {
+		return "", err
+	}
+	return scheme + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// DecodeReply parses the value of the ReplyHeader, in whichever codec the
+// server answered with.
+func DecodeReply(header string) (protocol.APRep, error) {
+	if header == "" {
+		return protocol.APRep{}, ErrMissingAPRep
+	}
+
+	encoded, ok := strings.CutPrefix(header, scheme)
+	if !ok {
+		return protocol.APRep{}, ErrInvalidScheme
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return protocol.APRep{}, ErrInvalidBase64
+	}
+
+	var rep protocol.APRep
+	if err := codec.Detect(data).Unmarshal(data, &rep); err != nil {
+		return protocol.APRep{}, fmt.Errorf("%w: %v", ErrInvalidAPRep, err)
+	}
+	return rep, nil
+}
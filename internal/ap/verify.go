@@ -6,7 +6,9 @@ import (
 	"time"
 
 	"github.com/rizesql/kerberos/internal/clock"
+	"github.com/rizesql/kerberos/internal/crypto"
 	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/keytab"
 	"github.com/rizesql/kerberos/internal/protocol"
 	"github.com/rizesql/kerberos/internal/replay"
 )
@@ -17,15 +19,49 @@ var (
 	ErrClientMismatch       = errors.New("client mismatch between ticket and authenticator")
 	ErrClockSkewTooGreat    = errors.New("clock skew too great")
 	ErrTicketExpired        = errors.New("ticket expired")
+	ErrTicketNotYetValid    = errors.New("ticket not yet valid")
+	ErrInvalidCred          = errors.New("invalid delegated credentials")
 )
 
+// DelegatedCredential is a ticket the client forwarded to the service,
+// together with what the service needs to use it on the client's behalf.
+type DelegatedCredential struct {
+	Ticket protocol.EncryptedData
+	Info   protocol.KRBCredInfo
+}
+
 type VerifyResult struct {
 	Client     protocol.Principal
 	SessionKey protocol.SessionKey
+	// IssuedAt is the timestamp of the authenticator, echoed by Reply.
+	IssuedAt time.Time
+	// Subkey is the session subkey the client proposed in its
+	// authenticator, zero if it proposed none.
+	Subkey protocol.SessionKey
+	// SeqNumber is the initial sequence number of the client's messages,
+	// nil if it set none.
+	SeqNumber *uint32
+	Flags     protocol.TicketFlags
+	// Claims are the KDC-issued claims about the client, nil if the ticket
+	// carried none.
+	Claims    *protocol.Claims
+	Delegated []DelegatedCredential
+}
+
+// Key is the key the rest of the session is protected with: the client's
+// subkey if it proposed one, the ticket's session key otherwise.
+func (r VerifyResult) Key() protocol.SessionKey {
+	if !r.Subkey.IsZero() {
+		return r.Subkey
+	}
+	return r.SessionKey
 }
 
 type Verifier struct {
-	serverKey   protocol.SessionKey
+	serverKey protocol.SessionKey
+	// keytab, if set, holds the keys of the services the verifier accepts
+	// tickets for, in place of serverKey.
+	keytab      *keytab.Keytab
 	clock       clock.Clock
 	replayCache replay.Cache
 	maxSkew     time.Duration
@@ -44,13 +80,28 @@ func NewVerifier(
 	}
 }
 
+// NewKeytabVerifier verifies tickets for any service whose keys kt holds,
+// each opened with the key of its server, key version and encryption type.
+func NewKeytabVerifier(
+	kt *keytab.Keytab,
+	clock clock.Clock,
+	replayCache replay.Cache,
+) *Verifier {
+	return &Verifier{
+		keytab:      kt,
+		clock:       clock,
+		replayCache: replayCache,
+		maxSkew:     5 * time.Minute,
+	}
+}
+
 func (v *Verifier) Verify(req protocol.APReq) (VerifyResult, error) {
-	ticket, err := shared.DecryptEntity[protocol.Ticket](v.serverKey, req.Ticket())
+	ticket, serverKey, err := v.openTicket(req.Ticket())
 	if err != nil {
 		return VerifyResult{}, ErrInvalidTicket
 	}
 
-	auth, err := shared.DecryptEntity[protocol.Authenticator](ticket.SessionKey(), req.Authenticator())
+	auth, err := shared.DecryptEntity[protocol.Authenticator](ticket.SessionKey(), crypto.KeyUsageAPReqAuth, req.Authenticator())
 	if err != nil {
 		return VerifyResult{}, ErrInvalidAuthenticator
 	}
@@ -61,9 +112,8 @@ func (v *Verifier) Verify(req protocol.APReq) (VerifyResult, error) {
 	}
 
 	now := v.clock.Now()
-	skew := now.Sub(auth.IssuedAt())
-	if skew < -v.maxSkew || skew > v.maxSkew {
-		return VerifyResult{}, ErrClockSkewTooGreat
+	if err := CheckSkew(now, auth.IssuedAt(), v.maxSkew); err != nil {
+		return VerifyResult{}, err
 	}
 
 	if err := v.replayCache.Check(auth.Client().String(), auth.IssuedAt()); err != nil {
@@ -74,8 +124,104 @@ func (v *Verifier) Verify(req protocol.APReq) (VerifyResult, error) {
 		return VerifyResult{}, ErrTicketExpired
 	}
 
-	return VerifyResult{
+	if ticket.IsNotYetValid(now.Add(v.maxSkew)) {
+		return VerifyResult{}, ErrTicketNotYetValid
+	}
+
+	claims, err := ticketClaims(ticket, serverKey)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+
+	delegated, err := delegatedCredentials(req, ticket)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+
+	result := VerifyResult{
 		Client:     ticket.Client(),
 		SessionKey: ticket.SessionKey(),
-	}, nil
+		IssuedAt:   auth.IssuedAt(),
+		Flags:      ticket.Flags(),
+		Claims:     claims,
+		Delegated:  delegated,
+	}
+	if subkey, ok := auth.Subkey(); ok {
+		result.Subkey = subkey
+	}
+	if seq, ok := auth.SeqNumber(); ok {
+		result.SeqNumber = &seq
+	}
+
+	return result, nil
+}
+
+// openTicket opens enc with the key it was sealed under. Without a keytab
+// that is the server key; with one, it is the key of the ticket's server
+// of the version and encryption type enc records. The server of a JSON
+// ticket is only known once it is open, so any service's key may do.
+func (v *Verifier) openTicket(enc protocol.EncryptedData) (protocol.Ticket, protocol.SessionKey, error) {
+	if v.keytab == nil {
+		ticket, err := shared.DecryptTicket(v.serverKey, enc)
+		return ticket, v.serverKey, err
+	}
+
+	server, _ := enc.Server()
+	kvno, hasKvno := enc.Kvno()
+	entries := v.keytab.Keys(server, kvno, hasKvno, enc.EncType())
+	for _, e := range entries {
+		ticket, err := shared.DecryptTicket(e.Key, enc)
+		if err != nil || ticket.Server() != e.Principal {
+			continue
+		}
+		return ticket, e.Key, nil
+	}
+	return protocol.Ticket{}, protocol.SessionKey{}, fmt.Errorf("no key for %s ticket of version %d", enc.EncType(), kvno)
+}
+
+// ticketClaims returns the claims the ticket carries, once the server checksum
+// shows the KDC issued them for this service.
+func ticketClaims(ticket protocol.Ticket, serverKey protocol.SessionKey) (*protocol.Claims, error) {
+	ad, ok := ticket.AuthorizationData()
+	if !ok {
+		return nil, nil
+	}
+
+	if err := shared.VerifyServerChecksum(ad, ticket.Client(), serverKey); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrModified, err)
+	}
+
+	claims := ad.Claims()
+	return &claims, nil
+}
+
+// delegatedCredentials opens the KRB-CRED carried by req, if any. It is
+// sealed under the ticket's session key and may only forward tickets of the
+// authenticated client.
+func delegatedCredentials(req protocol.APReq, ticket protocol.Ticket) ([]DelegatedCredential, error) {
+	cred, ok := req.Cred()
+	if !ok {
+		return nil, nil
+	}
+
+	encPart, err := shared.DecryptEntity[protocol.EncKRBCredPart](ticket.SessionKey(), crypto.KeyUsageKRBCredEncPart, cred.EncPart())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCred, err)
+	}
+
+	tickets, infos := cred.Tickets(), encPart.TicketInfo()
+	if len(tickets) != len(infos) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCred, protocol.ErrKRBCredInfoMismatch)
+	}
+
+	delegated := make([]DelegatedCredential, len(tickets))
+	for i := range tickets {
+		if infos[i].Client() != ticket.Client() {
+			return nil, fmt.Errorf("%w: forwarded ticket is for %s, not %s",
+				ErrInvalidCred, infos[i].Client(), ticket.Client())
+		}
+		delegated[i] = DelegatedCredential{Ticket: tickets[i], Info: infos[i]}
+	}
+
+	return delegated, nil
 }
@@ -1,6 +1,7 @@
 package ap_test
 
 import (
+	"bytes"
 	"encoding/hex"
 	"net"
 	"testing"
@@ -9,7 +10,10 @@ import (
 	"github.com/rizesql/kerberos/internal/ap"
 	"github.com/rizesql/kerberos/internal/assert"
 	"github.com/rizesql/kerberos/internal/clock"
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/crypto"
 	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/keytab"
 	"github.com/rizesql/kerberos/internal/protocol"
 	"github.com/rizesql/kerberos/internal/replay"
 )
@@ -39,13 +43,14 @@ func TestVerifier(t *testing.T) {
 			8*time.Hour,
 			sessionKey,
 		)
-		enc, _ := shared.EncryptEntity(serverKey, ticket)
+		ticket = ticket.WithFlags(protocol.FlagForwardable | protocol.FlagPreAuthent)
+		enc, _ := shared.EncryptEntity(codec.JSON, serverKey, crypto.KeyUsageTicket, ticket)
 		return enc
 	}
 
 	createAuthenticator := func(c protocol.Principal, issuedAt time.Time) protocol.EncryptedData {
 		auth, _ := protocol.NewAuthenticator(c, clientAddr, issuedAt)
-		enc, _ := shared.EncryptEntity(sessionKey, auth)
+		enc, _ := shared.EncryptEntity(codec.JSON, sessionKey, crypto.KeyUsageAPReqAuth, auth)
 		return enc
 	}
 
@@ -60,6 +65,8 @@ func TestVerifier(t *testing.T) {
 		result, err := verifier.Verify(req)
 		assert.Err(t, err, nil)
 		assert.Equal(t, result.Client.String(), client.String())
+		assert.True(t, result.Flags.Has(protocol.FlagForwardable|protocol.FlagPreAuthent))
+		assert.True(t, !result.Flags.Has(protocol.FlagInitial))
 	})
 
 	t.Run("InvalidTicket", func(t *testing.T) {
@@ -69,7 +76,7 @@ func TestVerifier(t *testing.T) {
 		// Encrypt ticket with wrong key
 		wrongKey, _ := protocol.NewSessionKey(sessionKeyBytes)
 		wrongTicket, _ := protocol.NewTicket(server, client, clientAddr, now, 8*time.Hour, sessionKey)
-		badEnc, _ := shared.EncryptEntity(wrongKey, wrongTicket)
+		badEnc, _ := shared.EncryptEntity(codec.JSON, wrongKey, crypto.KeyUsageTicket, wrongTicket)
 
 		auth := createAuthenticator(client, authTime)
 		req, _ := protocol.NewAPReq(badEnc, auth)
@@ -132,4 +139,191 @@ func TestVerifier(t *testing.T) {
 		_, err = verifier.Verify(req)
 		assert.Err(t, err, replay.ErrReplayDetected)
 	})
+
+	t.Run("TicketNotYetValid", func(t *testing.T) {
+		now := testClock.Now()
+		encrypt := func(ticket protocol.Ticket) protocol.EncryptedData {
+			enc, _ := shared.EncryptEntity(codec.JSON, serverKey, crypto.KeyUsageTicket, ticket)
+			return enc
+		}
+		ticket, _ := protocol.NewTicket(server, client, clientAddr, now, 8*time.Hour, sessionKey)
+
+		postdated := ticket.WithStartTime(now.Add(time.Hour))
+		req, _ := protocol.NewAPReq(encrypt(postdated), createAuthenticator(client, now.Add(90*time.Millisecond)))
+		_, err := verifier.Verify(req)
+		assert.Err(t, err, ap.ErrTicketNotYetValid)
+
+		invalid := ticket.WithFlags(protocol.FlagPostdated | protocol.FlagInvalid)
+		req, _ = protocol.NewAPReq(encrypt(invalid), createAuthenticator(client, now.Add(91*time.Millisecond)))
+		_, err = verifier.Verify(req)
+		assert.Err(t, err, ap.ErrTicketNotYetValid)
+	})
+
+	createCred := func(c protocol.Principal, key protocol.SessionKey) protocol.KRBCred {
+		tgs, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+		tgtKey, _ := protocol.NewSessionKey(make([]byte, 32))
+		info, _ := protocol.NewKRBCredInfo(tgtKey, c, tgs, testClock.Now(), 8*time.Hour)
+		encPart, _ := protocol.NewEncKRBCredPart(info.WithFlags(protocol.FlagForwarded))
+		encCredPart, _ := shared.EncryptEntity(codec.JSON, key, crypto.KeyUsageKRBCredEncPart, encPart)
+		tgt, _ := protocol.NewEncryptedData([]byte("forwarded-tgt"))
+		cred, _ := protocol.NewKRBCred([]protocol.EncryptedData{tgt}, encCredPart)
+		return cred
+	}
+
+	t.Run("Delegated", func(t *testing.T) {
+		now := testClock.Now()
+		req, _ := protocol.NewAPReq(createValidTicket(now), createAuthenticator(client, now.Add(60*time.Millisecond)))
+		req = req.WithCred(createCred(client, sessionKey))
+
+		result, err := verifier.Verify(req)
+		assert.Err(t, err, nil)
+		assert.Equal(t, len(result.Delegated), 1)
+		assert.Equal(t, result.Delegated[0].Info.Client(), client)
+		assert.True(t, result.Delegated[0].Info.Flags().Has(protocol.FlagForwarded))
+		assert.Equal(t, string(result.Delegated[0].Ticket.Ciphertext()), "forwarded-tgt")
+	})
+
+	t.Run("DelegatedWrongKey", func(t *testing.T) {
+		now := testClock.Now()
+		req, _ := protocol.NewAPReq(createValidTicket(now), createAuthenticator(client, now.Add(70*time.Millisecond)))
+		req = req.WithCred(createCred(client, serverKey))
+
+		_, err := verifier.Verify(req)
+		assert.Err(t, err, ap.ErrInvalidCred)
+	})
+
+	t.Run("DelegatedOtherClient", func(t *testing.T) {
+		now := testClock.Now()
+		bob, _ := protocol.NewPrincipal("bob", "", "ATHENA.MIT.EDU")
+		req, _ := protocol.NewAPReq(createValidTicket(now), createAuthenticator(client, now.Add(80*time.Millisecond)))
+		req = req.WithCred(createCred(bob, sessionKey))
+
+		_, err := verifier.Verify(req)
+		assert.Err(t, err, ap.ErrInvalidCred)
+	})
+
+	t.Run("SubkeyAndSeqNumber", func(t *testing.T) {
+		now := testClock.Now()
+		subkey, _ := protocol.NewSessionKey([]byte("subkey-subkey-subkey-subkey-1234"))
+		auth, _ := protocol.NewAuthenticator(client, clientAddr, now.Add(95*time.Millisecond))
+		enc, _ := shared.EncryptEntity(codec.JSON, sessionKey, crypto.KeyUsageAPReqAuth, auth.WithSubkey(subkey).WithSeqNumber(42))
+		req, _ := protocol.NewAPReq(createValidTicket(now), enc)
+
+		result, err := verifier.Verify(req)
+		assert.Err(t, err, nil)
+		assert.Equal(t, result.Subkey.Expose(), subkey.Expose())
+		assert.Equal(t, *result.SeqNumber, uint32(42))
+		assert.Equal(t, result.Key().Expose(), subkey.Expose())
+	})
+
+	claimsTicket := func(issuedAt time.Time, ad protocol.AuthorizationData) protocol.EncryptedData {
+		ticket, _ := protocol.NewTicket(server, client, clientAddr, issuedAt, 8*time.Hour, sessionKey)
+		enc, _ := shared.EncryptEntity(codec.JSON, serverKey, crypto.KeyUsageTicket, ticket.WithAuthorizationData(ad))
+		return enc
+	}
+
+	t.Run("Claims", func(t *testing.T) {
+		now := testClock.Now()
+		claims, _ := protocol.NewClaims([]string{"staff"}, now)
+		ad, _ := shared.SignClaims(client, claims, serverKey, sessionKey)
+		req, _ := protocol.NewAPReq(claimsTicket(now, ad), createAuthenticator(client, now.Add(96*time.Millisecond)))
+
+		result, err := verifier.Verify(req)
+		assert.Err(t, err, nil)
+		if result.Claims == nil {
+			t.Fatal("expected claims, got nil")
+		}
+		assert.True(t, result.Claims.InGroup("staff"))
+	})
+
+	t.Run("NoClaims", func(t *testing.T) {
+		now := testClock.Now()
+		req, _ := protocol.NewAPReq(createValidTicket(now), createAuthenticator(client, now.Add(97*time.Millisecond)))
+
+		result, err := verifier.Verify(req)
+		assert.Err(t, err, nil)
+		if result.Claims != nil {
+			t.Fatal("expected no claims")
+		}
+	})
+
+	t.Run("ClaimsForOtherService", func(t *testing.T) {
+		now := testClock.Now()
+		claims, _ := protocol.NewClaims([]string{"admins"}, now)
+		ad, _ := shared.SignClaims(client, claims, sessionKey, sessionKey)
+		req, _ := protocol.NewAPReq(claimsTicket(now, ad), createAuthenticator(client, now.Add(98*time.Millisecond)))
+
+		_, err := verifier.Verify(req)
+		assert.Err(t, err, ap.ErrModified)
+	})
+
+	t.Run("ClaimsForOtherClient", func(t *testing.T) {
+		// Claims signed for another client cannot be moved onto this
+		// client's ticket.
+		now := testClock.Now()
+		admin, _ := protocol.NewPrincipal("admin", "", client.Realm())
+		claims, _ := protocol.NewClaims([]string{"admins"}, now)
+		ad, _ := shared.SignClaims(admin, claims, serverKey, sessionKey)
+		req, _ := protocol.NewAPReq(claimsTicket(now, ad), createAuthenticator(client, now.Add(99*time.Millisecond)))
+
+		_, err := verifier.Verify(req)
+		assert.Err(t, err, ap.ErrModified)
+	})
+}
+
+func TestKeytabVerifier(t *testing.T) {
+	oldKey, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0x01}, 32))
+	newKey, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0x02}, 32))
+	otherKey, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0x03}, 32))
+	sessionKey, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0x04}, 32))
+
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	server, _ := protocol.NewPrincipal("http", "server.athena.mit.edu", "ATHENA.MIT.EDU")
+	other, _ := protocol.NewPrincipal("http", "other.athena.mit.edu", "ATHENA.MIT.EDU")
+	unknown, _ := protocol.NewPrincipal("http", "unknown.athena.mit.edu", "ATHENA.MIT.EDU")
+	clientAddr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+
+	testClock := clock.NewTestClock()
+	kt := keytab.New(
+		keytab.Entry{Principal: server, Kvno: 1, Key: oldKey},
+		keytab.Entry{Principal: server, Kvno: 2, Key: newKey},
+		keytab.Entry{Principal: other, Kvno: 2, Key: otherKey},
+	)
+	verifier := ap.NewKeytabVerifier(kt, testClock, replay.NewTestCache(testClock))
+
+	offset := time.Duration(0)
+	request := func(t *testing.T, c codec.Codec, service protocol.Principal, key shared.PrincipalKey) protocol.APReq {
+		t.Helper()
+		now := testClock.Now()
+		ticket, _ := protocol.NewTicket(service, client, clientAddr, now, 8*time.Hour, sessionKey)
+		enc, err := shared.EncryptTicket(c, key, ticket)
+		assert.Err(t, err, nil)
+
+		offset += time.Millisecond
+		auth, _ := protocol.NewAuthenticator(client, clientAddr, now.Add(offset))
+		encAuth, _ := shared.EncryptEntity(c, sessionKey, crypto.KeyUsageAPReqAuth, auth)
+		req, _ := protocol.NewAPReq(enc, encAuth)
+		return req
+	}
+
+	for _, c := range []codec.Codec{codec.JSON, codec.DER} {
+		t.Run(c.ContentType(), func(t *testing.T) {
+			for name, tc := range map[string]struct {
+				service protocol.Principal
+				key     shared.PrincipalKey
+				want    error
+			}{
+				"CurrentKey":   {server, shared.PrincipalKey{Key: newKey, Kvno: 2}, nil},
+				"OlderKey":     {server, shared.PrincipalKey{Key: oldKey, Kvno: 1}, nil},
+				"OtherService": {other, shared.PrincipalKey{Key: otherKey, Kvno: 2}, nil},
+				"WrongKvno":    {server, shared.PrincipalKey{Key: oldKey, Kvno: 2}, ap.ErrInvalidTicket},
+				"NotInKeytab":  {unknown, shared.PrincipalKey{Key: newKey, Kvno: 2}, ap.ErrInvalidTicket},
+			} {
+				t.Run(name, func(t *testing.T) {
+					_, err := verifier.Verify(request(t, c, tc.service, tc.key))
+					assert.Err(t, err, tc.want)
+				})
+			}
+		})
+	}
 }
@@ -0,0 +1,419 @@
+// Package ccache reads and writes credential caches in the MIT FILE: format,
+// version 4, so that tickets outlive the process that got them and other
+// Kerberos tools can use them.
+package ccache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+var (
+	ErrMalformed   = errors.New("malformed credential cache")
+	ErrUnsupported = errors.New("unsupported credential cache")
+)
+
+const version = 0x0504
+
+const (
+	nameTypePrincipal = 1
+	nameTypeSrvInst   = 2
+)
+
+// Credential is a ticket together with what its client needs to use it.
+type Credential struct {
+	Client protocol.Principal
+	Server protocol.Principal
+	// Key is the session key of the ticket.
+	Key       protocol.SessionKey
+	AuthTime  time.Time
+	StartTime time.Time
+	EndTime   time.Time
+	RenewTill time.Time
+	Flags     protocol.TicketFlags
+	Ticket    protocol.EncryptedData
+}
+
+// NewCredential is the credential for ticket, issued to client with repPart.
+func NewCredential(client protocol.Principal, ticket protocol.EncryptedData, repPart protocol.EncKDCRepPart) Credential {
+	return Credential{
+		Client:    client,
+		Server:    repPart.Server(),
+		Key:       repPart.SessionKey(),
+		AuthTime:  repPart.IssuedAt(),
+		StartTime: repPart.StartTime(),
+		EndTime:   repPart.EndTime(),
+		RenewTill: repPart.RenewTill(),
+		Flags:     repPart.Flags(),
+		Ticket:    ticket.WithServer(repPart.Server()),
+	}
+}
+
+// CCache holds the credentials of one client principal.
+type CCache struct {
+	principal   protocol.Principal
+	credentials []Credential
+}
+
+func New(principal protocol.Principal) *CCache {
+	return &CCache{principal: principal}
+}
+
+// Principal is the client the cache holds credentials of.
+func (c *CCache) Principal() protocol.Principal { return c.principal }
+
+func (c *CCache) Credentials() []Credential {
+	return slices.Clone(c.credentials)
+}
+
+// Add adds cred, replacing the credential for the same server if c holds
+// one.
+func (c *CCache) Add(cred Credential) {
+	c.credentials = slices.DeleteFunc(c.credentials, func(old Credential) bool {
+		return old.Server == cred.Server
+	})
+	c.credentials = append(c.credentials, cred)
+}
+
+// Get returns the credential for server.
+func (c *CCache) Get(server protocol.Principal) (Credential, bool) {
+	for _, cred := range c.credentials {
+		if cred.Server == server {
+			return cred, true
+		}
+	}
+	return Credential{}, false
+}
+
+// Load reads the credential cache at path.
+func Load(path string) (*CCache, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &CCache{}
+	if err := c.UnmarshalBinary(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Write writes c to path, readable only by its owner. The file is replaced
+// whole, through a temporary file renamed over it, so that readers never
+// see it half written.
+func (c *CCache) Write(path string) error {
+	data, err := c.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := tmp.Chmod(0o600); err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// MarshalBinary encodes c in the version 4 format: the version, an empty
+// header, the default principal and then each credential.
+func (c *CCache) MarshalBinary() ([]byte, error) {
+	b := binary.BigEndian.AppendUint16(nil, version)
+	b = binary.BigEndian.AppendUint16(b, 0)
+
+	b, err := appendPrincipal(b, c.principal)
+	if err != nil {
+		return nil, err
+	}
+	for _, cred := range c.credentials {
+		if b, err = cred.append(b); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (cred Credential) append(b []byte) ([]byte, error) {
+	b, err := appendPrincipal(b, cred.Client)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = appendPrincipal(b, cred.Server); err != nil {
+		return nil, err
+	}
+
+	b = binary.BigEndian.AppendUint16(b, uint16(int16(cred.Key.EncType())))
+	b = appendData(b, cred.Key.Expose())
+
+	for _, t := range []time.Time{cred.AuthTime, cred.StartTime, cred.EndTime, cred.RenewTill} {
+		b = binary.BigEndian.AppendUint32(b, timestamp(t))
+	}
+
+	b = append(b, 0) // is_skey: not a user-to-user ticket
+	b = binary.BigEndian.AppendUint32(b, uint32(cred.Flags))
+	b = binary.BigEndian.AppendUint32(b, 0) // addresses
+	b = binary.BigEndian.AppendUint32(b, 0) // authorization data
+
+	ticket, err := cred.Ticket.MarshalTicketDER()
+	if err != nil {
+		return nil, err
+	}
+	b = appendData(b, ticket)
+	return appendData(b, nil), nil // second ticket
+}
+
+func timestamp(t time.Time) uint32 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint32(t.Unix())
+}
+
+func appendPrincipal(b []byte, p protocol.Principal) ([]byte, error) {
+	if p == (protocol.Principal{}) {
+		return nil, fmt.Errorf("%w: empty principal", ErrUnsupported)
+	}
+
+	components := []string{string(p.Primary())}
+	if p.Instance() != "" {
+		components = append(components, string(p.Instance()))
+	}
+	nameType := uint32(nameTypePrincipal)
+	if p.IsKrbtgt() {
+		nameType = nameTypeSrvInst
+	}
+
+	b = binary.BigEndian.AppendUint32(b, nameType)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(components)))
+	b = appendData(b, []byte(p.Realm()))
+	for _, c := range components {
+		b = appendData(b, []byte(c))
+	}
+	return b, nil
+}
+
+func appendData(b, data []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+// UnmarshalBinary decodes a version 4 credential cache. Credentials whose
+// server does not fit a Principal, such as the configuration entries MIT
+// tools store, are skipped.
+func (c *CCache) UnmarshalBinary(data []byte) error {
+	r := reader(data)
+	v, ok := r.uint16()
+	if !ok {
+		return ErrMalformed
+	}
+	if v != version {
+		return fmt.Errorf("%w: version %#x", ErrUnsupported, v)
+	}
+
+	headerLen, ok := r.uint16()
+	if !ok {
+		return ErrMalformed
+	}
+	if _, ok := r.bytes(int(headerLen)); !ok {
+		return ErrMalformed
+	}
+
+	principal, supported, err := r.principal()
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return fmt.Errorf("%w: default principal", ErrUnsupported)
+	}
+
+	var credentials []Credential
+	for len(r) > 0 {
+		cred, supported, err := r.credential()
+		if err != nil {
+			return err
+		}
+		if supported {
+			credentials = append(credentials, cred)
+		}
+	}
+
+	c.principal, c.credentials = principal, credentials
+	return nil
+}
+
+// reader consumes big-endian fields from the front of a credential cache.
+type reader []byte
+
+func (r *reader) credential() (Credential, bool, error) {
+	client, clientOK, err := r.principal()
+	if err != nil {
+		return Credential{}, false, err
+	}
+	server, serverOK, err := r.principal()
+	if err != nil {
+		return Credential{}, false, err
+	}
+
+	etype, ok1 := r.uint16()
+	key, ok2 := r.data()
+	var times [4]uint32
+	for i := range times {
+		if times[i], ok1 = r.uint32(); !ok1 {
+			return Credential{}, false, ErrMalformed
+		}
+	}
+	_, ok3 := r.uint8() // is_skey
+	flags, ok4 := r.uint32()
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return Credential{}, false, ErrMalformed
+	}
+
+	// Addresses and authorization data are counted lists of typed values.
+	for range 2 {
+		n, ok := r.uint32()
+		if !ok {
+			return Credential{}, false, ErrMalformed
+		}
+		for range n {
+			_, ok1 := r.uint16()
+			_, ok2 := r.data()
+			if !ok1 || !ok2 {
+				return Credential{}, false, ErrMalformed
+			}
+		}
+	}
+
+	ticket, ok1 := r.data()
+	_, ok2 = r.data() // second ticket
+	if !ok1 || !ok2 {
+		return Credential{}, false, ErrMalformed
+	}
+	if !clientOK || !serverOK {
+		return Credential{}, false, nil
+	}
+
+	sk, err := protocol.NewSessionKey(key)
+	if err != nil {
+		return Credential{}, false, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	var enc protocol.EncryptedData
+	if err := enc.UnmarshalTicketDER(ticket); err != nil {
+		return Credential{}, false, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	return Credential{
+		Client:    client,
+		Server:    server,
+		Key:       sk.WithEncType(protocol.EncType(int16(etype))),
+		AuthTime:  fromTimestamp(times[0]),
+		StartTime: fromTimestamp(times[1]),
+		EndTime:   fromTimestamp(times[2]),
+		RenewTill: fromTimestamp(times[3]),
+		Flags:     protocol.TicketFlags(flags),
+		Ticket:    enc,
+	}, true, nil
+}
+
+func fromTimestamp(t uint32) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(t), 0).UTC()
+}
+
+// principal reads a principal, reporting whether it fits a Principal: a
+// realm and one or two components.
+func (r *reader) principal() (protocol.Principal, bool, error) {
+	_, ok1 := r.uint32() // name type
+	count, ok2 := r.uint32()
+	realm, ok3 := r.data()
+	if !ok1 || !ok2 || !ok3 || count > math.MaxUint16 {
+		return protocol.Principal{}, false, ErrMalformed
+	}
+
+	components := make([]string, count)
+	for i := range components {
+		c, ok := r.data()
+		if !ok {
+			return protocol.Principal{}, false, ErrMalformed
+		}
+		components[i] = string(c)
+	}
+	if count < 1 || count > 2 {
+		return protocol.Principal{}, false, nil
+	}
+
+	var instance protocol.Instance
+	if count == 2 {
+		instance = protocol.Instance(components[1])
+	}
+	p, err := protocol.NewPrincipal(protocol.Primary(components[0]), instance, protocol.Realm(realm))
+	if err != nil {
+		return protocol.Principal{}, false, nil
+	}
+	return p, true, nil
+}
+
+func (r *reader) bytes(n int) ([]byte, bool) {
+	if n < 0 || len(*r) < n {
+		return nil, false
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b, true
+}
+
+func (r *reader) uint8() (uint8, bool) {
+	b, ok := r.bytes(1)
+	if !ok {
+		return 0, false
+	}
+	return b[0], true
+}
+
+func (r *reader) uint16() (uint16, bool) {
+	b, ok := r.bytes(2)
+	if !ok {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(b), true
+}
+
+func (r *reader) uint32() (uint32, bool) {
+	b, ok := r.bytes(4)
+	if !ok {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(b), true
+}
+
+func (r *reader) data() ([]byte, bool) {
+	n, ok := r.uint32()
+	if !ok || n > math.MaxInt32 {
+		return nil, false
+	}
+	return r.bytes(int(n))
+}
@@ -0,0 +1,172 @@
+package ccache_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/ccache"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+func credential(t *testing.T, client, server protocol.Principal) ccache.Credential {
+	t.Helper()
+	key, err := protocol.NewSessionKey(bytes.Repeat([]byte{0xaa}, 16))
+	assert.Err(t, err, nil)
+	ticket, err := protocol.NewEncryptedData([]byte("sealed ticket"))
+	assert.Err(t, err, nil)
+
+	return ccache.Credential{
+		Client:    client,
+		Server:    server,
+		Key:       key.WithEncType(protocol.EncTypeAES128CTSHMACSHA196),
+		AuthTime:  time.Unix(0x01020304, 0).UTC(),
+		StartTime: time.Unix(0x01020305, 0).UTC(),
+		EndTime:   time.Unix(0x01020306, 0).UTC(),
+		Flags:     protocol.FlagForwardable,
+		Ticket:    ticket.WithKvno(2).WithServer(server),
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	client, _ := protocol.NewPrincipal("alice", "", "R")
+	krbtgt, _ := protocol.NewKrbtgt("R")
+	cred := credential(t, client, krbtgt)
+
+	c := ccache.New(client)
+	c.Add(cred)
+	got, err := c.MarshalBinary()
+	assert.Err(t, err, nil)
+
+	// The layout MIT krb5 writes: version 0x0504 and an empty header, the
+	// default principal, then the credential's principals, keyblock, times,
+	// is_skey, flags and empty address and authorization data lists.
+	ticket, err := cred.Ticket.MarshalTicketDER()
+	assert.Err(t, err, nil)
+	alice := "00000001" + "00000001" + "0000000152" + "00000005616c696365"
+	want, _ := hex.DecodeString("0504" + "0000" + alice +
+		alice +
+		"00000002" + "00000002" + "0000000152" + "000000066b72627467" + "74" + "0000000152" +
+		"0011" + "00000010" + "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa" +
+		"01020304" + "01020305" + "01020306" + "00000000" +
+		"00" + "40000000" + "00000000" + "00000000")
+	want = append(want, 0, 0, 0, byte(len(ticket)))
+	want = append(want, ticket...)
+	want = append(want, 0, 0, 0, 0)
+	assert.Equal(t, got, want)
+
+	var back ccache.CCache
+	assert.Err(t, back.UnmarshalBinary(got), nil)
+	assert.Equal(t, back.Principal(), client)
+	creds := back.Credentials()
+	assert.Equal(t, len(creds), 1)
+	assert.Equal(t, creds[0].Client, client)
+	assert.Equal(t, creds[0].Server, krbtgt)
+	assert.Equal(t, creds[0].Key.EncType(), protocol.EncTypeAES128CTSHMACSHA196)
+	assert.Equal(t, creds[0].Key.Expose(), cred.Key.Expose())
+	assert.Equal(t, creds[0].AuthTime, cred.AuthTime)
+	assert.Equal(t, creds[0].EndTime, cred.EndTime)
+	assert.True(t, creds[0].RenewTill.IsZero())
+	assert.Equal(t, creds[0].Flags, protocol.FlagForwardable)
+	assert.Equal(t, creds[0].Ticket.Ciphertext(), []byte("sealed ticket"))
+	kvno, _ := creds[0].Ticket.Kvno()
+	assert.Equal(t, kvno, uint32(2))
+	server, _ := creds[0].Ticket.Server()
+	assert.Equal(t, server, krbtgt)
+}
+
+func TestUnmarshalBinary(t *testing.T) {
+	client, _ := protocol.NewPrincipal("alice", "", "R")
+	service, _ := protocol.NewPrincipal("http", "api", "R")
+	c := ccache.New(client)
+	c.Add(credential(t, client, service))
+	data, err := c.MarshalBinary()
+	assert.Err(t, err, nil)
+
+	// A header tag and a configuration entry, whose server has more
+	// components than a Principal, are skipped.
+	conf := "00000001" + "00000003" + "0000000c582d4341434845434f4e463a" +
+		"00000001" + "61" + "00000001" + "62" + "00000001" + "63"
+	entry := "00000001" + "00000001" + "0000000152" + "00000005616c696365" + conf +
+		"0000" + "00000000" + "00000000" + "00000000" + "00000000" + "00000000" +
+		"00" + "00000000" + "00000000" + "00000000" + "00000001" + "79" + "00000000"
+	extra, _ := hex.DecodeString(entry)
+	data = append(data, extra...)
+	data = append(append([]byte{0x05, 0x04, 0x00, 0x08}, bytes.Repeat([]byte{0}, 8)...), data[4:]...)
+
+	var back ccache.CCache
+	assert.Err(t, back.UnmarshalBinary(data), nil)
+	assert.Equal(t, len(back.Credentials()), 1)
+	_, ok := back.Get(service)
+	assert.True(t, ok)
+
+	for name, tc := range map[string]struct {
+		input string
+		want  error
+	}{
+		"Empty":     {"", ccache.ErrMalformed},
+		"Version":   {"0503" + "0000", ccache.ErrUnsupported},
+		"Header":    {"0504" + "0008" + "0000", ccache.ErrMalformed},
+		"Truncated": {"0504" + "0000" + "00000001" + "00000001" + "0000000152", ccache.ErrMalformed},
+		"Principal": {"0504" + "0000" + conf, ccache.ErrUnsupported},
+		"Credential": {"0504" + "0000" + "00000001" + "00000001" + "0000000152" + "00000005616c696365" +
+			entry[:40], ccache.ErrMalformed},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, _ := hex.DecodeString(tc.input)
+			assert.Err(t, back.UnmarshalBinary(data), tc.want)
+		})
+	}
+}
+
+func TestAdd(t *testing.T) {
+	client, _ := protocol.NewPrincipal("alice", "", "R")
+	service, _ := protocol.NewPrincipal("http", "api", "R")
+	krbtgt, _ := protocol.NewKrbtgt("R")
+
+	c := ccache.New(client)
+	c.Add(credential(t, client, krbtgt))
+	c.Add(credential(t, client, service))
+
+	// A new ticket to the same server replaces the old one.
+	renewed := credential(t, client, krbtgt)
+	renewed.EndTime = renewed.EndTime.Add(time.Hour)
+	c.Add(renewed)
+
+	assert.Equal(t, len(c.Credentials()), 2)
+	got, ok := c.Get(krbtgt)
+	assert.True(t, ok)
+	assert.Equal(t, got.EndTime, renewed.EndTime)
+
+	other, _ := protocol.NewPrincipal("host", "api", "R")
+	_, ok = c.Get(other)
+	assert.True(t, !ok)
+}
+
+func TestWrite(t *testing.T) {
+	client, _ := protocol.NewPrincipal("alice", "", "R")
+	krbtgt, _ := protocol.NewKrbtgt("R")
+	path := filepath.Join(t.TempDir(), "krb5cc")
+
+	c := ccache.New(client)
+	c.Add(credential(t, client, krbtgt))
+	assert.Err(t, c.Write(path), nil)
+	info, err := os.Stat(path)
+	assert.Err(t, err, nil)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0o600))
+
+	// Rewriting replaces the file whole, leaving no temporary files.
+	assert.Err(t, ccache.New(client).Write(path), nil)
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.Err(t, err, nil)
+	assert.Equal(t, len(entries), 1)
+
+	got, err := ccache.Load(path)
+	assert.Err(t, err, nil)
+	assert.Equal(t, got.Principal(), client)
+	assert.Equal(t, len(got.Credentials()), 0)
+}
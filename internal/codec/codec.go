@@ -0,0 +1,104 @@
+// Package codec selects the wire encoding of Kerberos messages: the JSON the
+// project started with, or the ASN.1 DER of RFC 4120 that standard Kerberos
+// implementations speak.
+package codec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+)
+
+var ErrNoDER = errors.New("type has no DER encoding")
+
+// DERContentType is the media type of a DER-encoded Kerberos message.
+const DERContentType = "application/kerberos"
+
+// Codec encodes messages to and decodes them from one wire encoding.
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// DERMarshaler is implemented by messages with an RFC 4120 DER encoding.
+type DERMarshaler interface {
+	MarshalDER() ([]byte, error)
+}
+
+// DERUnmarshaler is implemented by messages that can decode their RFC 4120
+// DER encoding.
+type DERUnmarshaler interface {
+	UnmarshalDER(data []byte) error
+}
+
+var (
+	JSON Codec = jsonCodec{}
+	DER  Codec = derCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type derCodec struct{}
+
+func (derCodec) ContentType() string { return DERContentType }
+
+func (derCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(DERMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrNoDER, v)
+	}
+	return m.MarshalDER()
+}
+
+func (derCodec) Unmarshal(data []byte, v any) error {
+	u, ok := v.(DERUnmarshaler)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrNoDER, v)
+	}
+	return u.UnmarshalDER(data)
+}
+
+// ForContentType returns the codec of a Content-Type or Accept header value.
+// Anything but DERContentType is taken to be JSON.
+func ForContentType(contentType string) Codec {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && mediaType == DERContentType {
+		return DER
+	}
+	return JSON
+}
+
+// Detect returns the codec data was encoded with. A DER message can start
+// with the same byte as a JSON object (EncAPRepPart's tag is '{'), so the
+// whole of data is checked.
+func Detect(data []byte) Codec {
+	if json.Valid(data) {
+		return JSON
+	}
+	return DER
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying c, the codec the request being
+// served was encoded with.
+func NewContext(ctx context.Context, c Codec) context.Context {
+	return context.WithValue(ctx, ctxKey{}, c)
+}
+
+// FromContext returns the codec carried by ctx, or JSON if there is none.
+func FromContext(ctx context.Context) Codec {
+	if c, ok := ctx.Value(ctxKey{}).(Codec); ok {
+		return c
+	}
+	return JSON
+}
@@ -0,0 +1,70 @@
+package codec_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+func TestForContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        codec.Codec
+	}{
+		{"application/kerberos", codec.DER},
+		{"application/kerberos; charset=binary", codec.DER},
+		{"application/json", codec.JSON},
+		{"application/json; charset=utf-8", codec.JSON},
+		{"", codec.JSON},
+		{"not a media type", codec.JSON},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			assert.Equal(t, codec.ForContentType(tt.contentType), tt.want)
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	enc, err := protocol.NewEncryptedData([]byte("{ciphertext}"))
+	assert.Err(t, err, nil)
+
+	for _, c := range []codec.Codec{codec.JSON, codec.DER} {
+		t.Run(c.ContentType(), func(t *testing.T) {
+			data, err := c.Marshal(enc)
+			assert.Err(t, err, nil)
+			assert.Equal(t, codec.Detect(data), c)
+
+			var loaded protocol.EncryptedData
+			assert.Err(t, codec.Detect(data).Unmarshal(data, &loaded), nil)
+			assert.Equal(t, string(loaded.Ciphertext()), "{ciphertext}")
+		})
+	}
+
+	// DER that starts with '{', the tag of EncAPRepPart, is still DER.
+	assert.Equal(t, codec.Detect([]byte{'{', 0x03, 0x30, 0x01, 0x00}), codec.DER)
+}
+
+func TestDERRequiresMarshaler(t *testing.T) {
+	_, err := codec.DER.Marshal(struct{}{})
+	if !errors.Is(err, codec.ErrNoDER) {
+		t.Fatalf("got %v, want %v", err, codec.ErrNoDER)
+	}
+
+	var v struct{}
+	err = codec.DER.Unmarshal([]byte{0x30, 0x00}, &v)
+	if !errors.Is(err, codec.ErrNoDER) {
+		t.Fatalf("got %v, want %v", err, codec.ErrNoDER)
+	}
+}
+
+func TestContext(t *testing.T) {
+	assert.Equal(t, codec.FromContext(t.Context()), codec.JSON)
+
+	ctx := codec.NewContext(t.Context(), codec.DER)
+	assert.Equal(t, codec.FromContext(ctx), codec.DER)
+}
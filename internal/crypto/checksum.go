@@ -0,0 +1,48 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"errors"
+
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Checksum computes the keyed checksum of data under key for usage, of the
+// type that goes with the key's encryption type.
+func Checksum(key protocol.SessionKey, usage KeyUsage, data []byte) (protocol.Checksum, error) {
+	if key.IsZero() {
+		return protocol.Checksum{}, ErrInvalidKey
+	}
+
+	e, err := Lookup(key.EncType())
+	if err != nil {
+		return protocol.Checksum{}, err
+	}
+
+	sum, err := e.Checksum(key.Expose(), usage, data)
+	if err != nil {
+		return protocol.Checksum{}, err
+	}
+	return protocol.NewChecksum(e.ChecksumType(), sum)
+}
+
+// VerifyChecksum checks, in constant time, that sum is the checksum of data
+// under key for usage. The checksum type must be the one that goes with the
+// key.
+func VerifyChecksum(key protocol.SessionKey, usage KeyUsage, data []byte, sum protocol.Checksum) error {
+	e, err := lookupChecksum(sum.Type())
+	if err != nil || e.ID() != key.EncType() {
+		return ErrChecksumMismatch
+	}
+
+	want, err := Checksum(key, usage, data)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(want.Value(), sum.Value()) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
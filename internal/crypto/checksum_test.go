@@ -0,0 +1,25 @@
+package crypto_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+func TestChecksum(t *testing.T) {
+	key, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0x01}, 32))
+	other, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0x02}, 32))
+	data := []byte("checksummed message")
+
+	sum, err := crypto.Checksum(key, crypto.KeyUsageKRBSafeCksum, data)
+	assert.Err(t, err, nil)
+	assert.Equal(t, sum.Type(), protocol.ChecksumHMACSHA256)
+
+	assert.Err(t, crypto.VerifyChecksum(key, crypto.KeyUsageKRBSafeCksum, data, sum), nil)
+	assert.Err(t, crypto.VerifyChecksum(key, crypto.KeyUsageKRBSafeCksum, []byte("tampered message"), sum), crypto.ErrChecksumMismatch)
+	assert.Err(t, crypto.VerifyChecksum(other, crypto.KeyUsageKRBSafeCksum, data, sum), crypto.ErrChecksumMismatch)
+	assert.Err(t, crypto.VerifyChecksum(key, crypto.KeyUsageAPReqAuthCksum, data, sum), crypto.ErrChecksumMismatch)
+}
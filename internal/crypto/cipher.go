@@ -1,12 +1,7 @@
 package crypto
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
 	"errors"
-	"fmt"
-	"io"
 
 	"github.com/rizesql/kerberos/internal/protocol"
 )
@@ -18,48 +13,21 @@ var (
 	ErrAuthFailed          = errors.New("authentication failed (integrity check)")
 )
 
-func Encrypt(key protocol.SessionKey, plaintext []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key.Expose())
+// Encrypt seals plaintext under key for usage, with the key's encryption
+// type.
+func Encrypt(key protocol.SessionKey, usage KeyUsage, plaintext []byte) ([]byte, error) {
+	e, err := Lookup(key.EncType())
 	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+		return nil, err
 	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
-	}
-
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrNonceGeneration, err)
-	}
-
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
-	return ciphertext, nil
+	return e.Encrypt(key.Expose(), usage, plaintext)
 }
 
-func Decrypt(key protocol.SessionKey, data []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key.Expose())
-	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
-	}
-
-	nonceSize := gcm.NonceSize()
-	if len(data) < nonceSize {
-		return nil, fmt.Errorf("%w: data too short", ErrMalformedCiphertext)
-	}
-
-	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+// Decrypt opens data sealed by Encrypt under key for usage.
+func Decrypt(key protocol.SessionKey, usage KeyUsage, data []byte) ([]byte, error) {
+	e, err := Lookup(key.EncType())
 	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrAuthFailed, err)
+		return nil, err
 	}
-
-	return plaintext, nil
+	return e.Decrypt(key.Expose(), usage, data)
 }
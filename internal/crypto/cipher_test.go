@@ -15,12 +15,12 @@ func TestEncryptDecrypt(t *testing.T) {
 
 	plaintext := []byte("secret message")
 
-	ciphertext, err := crypto.Encrypt(key, plaintext)
+	ciphertext, err := crypto.Encrypt(key, crypto.KeyUsageKRBPrivEncPart, plaintext)
 	assert.Err(t, err, nil)
 
 	assert.True(t, string(ciphertext) != string(plaintext))
 
-	decrypted, err := crypto.Decrypt(key, ciphertext)
+	decrypted, err := crypto.Decrypt(key, crypto.KeyUsageKRBPrivEncPart, ciphertext)
 	assert.Err(t, err, nil)
 
 	assert.Equal(t, decrypted, plaintext)
@@ -32,12 +32,12 @@ func TestIntegrityCheck(t *testing.T) {
 	assert.Err(t, err, nil)
 
 	plaintext := []byte("integrity check")
-	ciphertext, err := crypto.Encrypt(key, plaintext)
+	ciphertext, err := crypto.Encrypt(key, crypto.KeyUsageKRBPrivEncPart, plaintext)
 	assert.Err(t, err, nil)
 
 	ciphertext[len(ciphertext)-1] ^= 0xFF
 
-	_, err = crypto.Decrypt(key, ciphertext)
+	_, err = crypto.Decrypt(key, crypto.KeyUsageKRBPrivEncPart, ciphertext)
 	assert.Err(t, err, crypto.ErrAuthFailed)
 }
 
@@ -46,6 +46,14 @@ func TestInvalidKeySize(t *testing.T) {
 	key, err := protocol.NewSessionKey(badKeyBytes)
 	assert.Err(t, err, nil)
 
-	_, err = crypto.Encrypt(key, []byte("data"))
+	_, err = crypto.Encrypt(key, crypto.KeyUsageKRBPrivEncPart, []byte("data"))
 	assert.Err(t, err, crypto.ErrInvalidKey)
 }
+
+func TestEncrypt_UnsupportedEncType(t *testing.T) {
+	key, err := protocol.NewSessionKey(make([]byte, 32))
+	assert.Err(t, err, nil)
+
+	_, err = crypto.Encrypt(key.WithEncType(23), crypto.KeyUsageKRBPrivEncPart, []byte("secret message"))
+	assert.Err(t, err, crypto.ErrUnsupportedEncType)
+}
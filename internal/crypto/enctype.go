@@ -0,0 +1,138 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+var (
+	ErrUnsupportedEncType = errors.New("unsupported encryption type")
+	ErrInvalidParams      = errors.New("invalid string-to-key parameters")
+	ErrParamsRefused      = errors.New("string-to-key parameters outside policy")
+)
+
+// Purpose selects which of the keys RFC 3961 §5.3 derives for each key usage
+// DeriveKey returns.
+type Purpose byte
+
+const (
+	PurposeChecksum   Purpose = 0x99 // Kc, keys checksums
+	PurposeEncryption Purpose = 0xAA // Ke, keys the cipher
+	PurposeIntegrity  Purpose = 0x55 // Ki, keys the integrity check of ciphertext
+)
+
+// EncType is an encryption type (RFC 3961): how keys of the type are made
+// from passwords and used to seal and checksum data.
+type EncType interface {
+	// ID is the number that names the type in messages and in the database.
+	ID() protocol.EncType
+	// ChecksumType is the keyed checksum the type computes.
+	ChecksumType() protocol.ChecksumType
+	// KeySize is the length of a key in bytes.
+	KeySize() int
+	// StringToKey derives a key from a password and salt. Nil params select
+	// the type's default; params may also select another KDF.
+	StringToKey(password, salt string, params []byte) ([]byte, error)
+	// DeriveKey derives the key used for purpose under usage from a base key.
+	DeriveKey(key []byte, usage KeyUsage, purpose Purpose) ([]byte, error)
+	// Encrypt seals plaintext under key for usage.
+	Encrypt(key []byte, usage KeyUsage, plaintext []byte) ([]byte, error)
+	// Decrypt opens ciphertext sealed by Encrypt.
+	Decrypt(key []byte, usage KeyUsage, ciphertext []byte) ([]byte, error)
+	// Checksum computes the keyed checksum of data under key for usage.
+	Checksum(key []byte, usage KeyUsage, data []byte) ([]byte, error)
+	// PRF is the type's pseudo-random function of input under key (RFC
+	// 3961 §3), which KRB-FX-CF2 combines keys with.
+	PRF(key, input []byte) ([]byte, error)
+}
+
+// SupportedEncTypes lists the encryption types this package implements,
+// strongest first. The KDC prefers them in this order.
+var SupportedEncTypes = []protocol.EncType{
+	protocol.EncTypeAES256CTSHMACSHA384192,
+	protocol.EncTypeAES256CTSHMACSHA196,
+	protocol.EncTypeAES256GCM,
+	protocol.EncTypeAES128CTSHMACSHA256128,
+	protocol.EncTypeAES128CTSHMACSHA196,
+}
+
+var encTypes = map[protocol.EncType]EncType{}
+
+func register(e EncType) { encTypes[e.ID()] = e }
+
+func init() {
+	register(aesGCM{})
+	register(aesSHA1{etype: protocol.EncTypeAES128CTSHMACSHA196, ctype: protocol.ChecksumHMACSHA196AES128, keySize: 16})
+	register(aesSHA1{etype: protocol.EncTypeAES256CTSHMACSHA196, ctype: protocol.ChecksumHMACSHA196AES256, keySize: 32})
+	register(aesSHA2{etype: protocol.EncTypeAES128CTSHMACSHA256128, ctype: protocol.ChecksumHMACSHA256128AES128, keySize: 16})
+	register(aesSHA2{etype: protocol.EncTypeAES256CTSHMACSHA384192, ctype: protocol.ChecksumHMACSHA384192AES256, keySize: 32})
+}
+
+// Lookup returns the implementation of etype.
+func Lookup(etype protocol.EncType) (EncType, error) {
+	e, ok := encTypes[etype]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncType, etype)
+	}
+	return e, nil
+}
+
+// lookupChecksum returns the encryption type whose checksum is ctype.
+func lookupChecksum(ctype protocol.ChecksumType) (EncType, error) {
+	for _, e := range encTypes {
+		if e.ChecksumType() == ctype {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: checksum type %d", ErrUnsupportedEncType, ctype)
+}
+
+// ParseEncType reads an encryption type given by name, such as
+// "aes256-cts-hmac-sha1-96", or by number.
+func ParseEncType(s string) (protocol.EncType, error) {
+	if n, err := strconv.ParseInt(s, 10, 32); err == nil {
+		if _, err := Lookup(protocol.EncType(n)); err != nil {
+			return 0, err
+		}
+		return protocol.EncType(n), nil
+	}
+
+	for etype := range encTypes {
+		if etype.String() == s {
+			return etype, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnsupportedEncType, s)
+}
+
+// KeySize is the length in bytes of a key of etype.
+func KeySize(etype protocol.EncType) (int, error) {
+	e, err := Lookup(etype)
+	if err != nil {
+		return 0, err
+	}
+	return e.KeySize(), nil
+}
+
+// StringToKey derives the key of etype for password and salt. Nil params
+// select the type's default.
+func StringToKey(etype protocol.EncType, password, salt string, params []byte) (protocol.SessionKey, error) {
+	e, err := Lookup(etype)
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+
+	key, err := e.StringToKey(password, salt, params)
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+
+	sk, err := protocol.NewSessionKey(key)
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+	return sk.WithEncType(etype), nil
+}
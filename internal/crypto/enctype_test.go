@@ -0,0 +1,305 @@
+package crypto_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	assert.Err(t, err, nil)
+	return b
+}
+
+// RFC 3962 Appendix B and RFC 8009 Appendix A.
+func TestStringToKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		etype    protocol.EncType
+		password string
+		salt     string
+		params   []byte
+		want     string
+	}{
+		{"aes128 1 iteration", protocol.EncTypeAES128CTSHMACSHA196, "password", "ATHENA.MIT.EDUraeburn", crypto.IterationParams(1),
+			"42263c6e89f4fc28b8df68ee09799f15"},
+		{"aes256 1 iteration", protocol.EncTypeAES256CTSHMACSHA196, "password", "ATHENA.MIT.EDUraeburn", crypto.IterationParams(1),
+			"fe697b52bc0d3ce14432ba036a92e65bbb52280990a2fa27883998d72af30161"},
+		{"aes128 2 iterations", protocol.EncTypeAES128CTSHMACSHA196, "password", "ATHENA.MIT.EDUraeburn", crypto.IterationParams(2),
+			"c651bf29e2300ac27fa469d693bdda13"},
+		{"aes256 2 iterations", protocol.EncTypeAES256CTSHMACSHA196, "password", "ATHENA.MIT.EDUraeburn", crypto.IterationParams(2),
+			"a2e16d16b36069c135d5e9d2e25f896102685618b95914b467c67622225824ff"},
+		{"aes128 1200 iterations", protocol.EncTypeAES128CTSHMACSHA196, "password", "ATHENA.MIT.EDUraeburn", crypto.IterationParams(1200),
+			"4c01cd46d632d01e6dbe230a01ed642a"},
+		{"aes256 1200 iterations", protocol.EncTypeAES256CTSHMACSHA196, "password", "ATHENA.MIT.EDUraeburn", crypto.IterationParams(1200),
+			"55a6ac740ad17b4846941051e1e8b0a7548d93b0ab30a8bc3ff16280382b8c2a"},
+		{"aes128-sha256", protocol.EncTypeAES128CTSHMACSHA256128, "password", "\x10\xdf\x9d\xd7\x83\xe5\xbc\x8a\xce\xa1\x73\x0e\x74\x35\x5f\x61ATHENA.MIT.EDUraeburn", crypto.IterationParams(32768),
+			"089bca48b105ea6ea77ca5d2f39dc5e7"},
+		{"aes256-sha384", protocol.EncTypeAES256CTSHMACSHA384192, "password", "\x10\xdf\x9d\xd7\x83\xe5\xbc\x8a\xce\xa1\x73\x0e\x74\x35\x5f\x61ATHENA.MIT.EDUraeburn", crypto.IterationParams(32768),
+			"45bd806dbf6a833a9cffc1c94589a222367a79bc21c413718906e9f578a78467"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := crypto.StringToKey(tt.etype, tt.password, tt.salt, tt.params)
+			assert.Err(t, err, nil)
+			assert.Equal(t, hex.EncodeToString(key.Expose()), tt.want)
+			assert.Equal(t, key.EncType(), tt.etype)
+		})
+	}
+}
+
+// RFC 8009 Appendix A.
+func TestDeriveKey_RFC8009(t *testing.T) {
+	tests := []struct {
+		etype      protocol.EncType
+		key        string
+		kc, ke, ki string
+	}{
+		{
+			protocol.EncTypeAES128CTSHMACSHA256128,
+			"3705d96080c17728a0e800eab6e0d23c",
+			"b31a018a48f54776f403e9a396325dc3",
+			"9b197dd1e8c5609d6e67c3e37c62c72e",
+			"9fda0e56ab2d85e1569a688696c26a6c",
+		},
+		{
+			protocol.EncTypeAES256CTSHMACSHA384192,
+			"6d404d37faf79f9df0d33568d320669800eb4836472ea8a026d16b7182460c52",
+			"ef5718be86cc84963d8bbb5031e9f5c4ba41f28faf69e73d",
+			"56ab22bee63d82d7bc5227f6773f8ea7a5eb1c825160c38312980c442e5c7e49",
+			"69b16514e3cd8e56b82010d5c73012b622c4d00ffc23ed1f",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.etype.String(), func(t *testing.T) {
+			e, err := crypto.Lookup(tt.etype)
+			assert.Err(t, err, nil)
+			key := unhex(t, tt.key)
+
+			for _, k := range []struct {
+				purpose crypto.Purpose
+				want    string
+			}{
+				{crypto.PurposeChecksum, tt.kc},
+				{crypto.PurposeEncryption, tt.ke},
+				{crypto.PurposeIntegrity, tt.ki},
+			} {
+				got, err := e.DeriveKey(key, 2, k.purpose)
+				assert.Err(t, err, nil)
+				assert.Equal(t, hex.EncodeToString(got), k.want)
+			}
+		})
+	}
+}
+
+// RFC 3962 Appendix B: AES-128 in CBC mode with ciphertext stealing and a
+// zero IV, over prefixes of one sentence.
+func TestCTS_RFC3962(t *testing.T) {
+	key := unhex(t, "636869636b656e207465726979616b69")
+	input := []byte("I would like the General Gau's Chicken, please, and wonton soup.")
+
+	tests := []struct {
+		length     int
+		ciphertext string
+	}{
+		{17, "c6353568f2bf8cb4d8a580362da7ff7f97"},
+		{31, "fc00783e0efdb2c1d445d4c8eff7ed2297687268d6ecccc0c07b25e25ecfe5"},
+		{32, "39312523a78662d5be7fcbcc98ebf5a897687268d6ecccc0c07b25e25ecfe584"},
+		{47, "97687268d6ecccc0c07b25e25ecfe584b3fffd940c16a18c1b5549d2f838029e" +
+			"39312523a78662d5be7fcbcc98ebf5"},
+		{48, "97687268d6ecccc0c07b25e25ecfe5849dad8bbb96c4cdc03bc103e1a194bbd8" +
+			"39312523a78662d5be7fcbcc98ebf5a8"},
+		{64, "97687268d6ecccc0c07b25e25ecfe58439312523a78662d5be7fcbcc98ebf5a8" +
+			"4807efe836ee89a526730dbc2f7bc8409dad8bbb96c4cdc03bc103e1a194bbd8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.length), func(t *testing.T) {
+			plaintext := input[:tt.length]
+
+			ciphertext, err := crypto.CTSEncrypt(key, plaintext)
+			assert.Err(t, err, nil)
+			assert.Equal(t, hex.EncodeToString(ciphertext), tt.ciphertext)
+
+			decrypted, err := crypto.CTSDecrypt(key, unhex(t, tt.ciphertext))
+			assert.Err(t, err, nil)
+			assert.Equal(t, string(decrypted), string(plaintext))
+		})
+	}
+}
+
+// RFC 8009 Appendix A: ciphertexts sealed with a known confounder under
+// key usage 2.
+func TestDecrypt_RFC8009(t *testing.T) {
+	tests := []struct {
+		name       string
+		etype      protocol.EncType
+		key        string
+		plaintext  string
+		ciphertext string
+	}{
+		{
+			"aes128 empty", protocol.EncTypeAES128CTSHMACSHA256128,
+			"3705d96080c17728a0e800eab6e0d23c", "",
+			"ef85fb890bb8472f4dab20394dca781dad877eda39d50c870c0d5a0a8e48c718",
+		},
+		{
+			"aes128 short", protocol.EncTypeAES128CTSHMACSHA256128,
+			"3705d96080c17728a0e800eab6e0d23c", "000102030405",
+			"84d7f30754ed987bab0bf3506beb09cfb55402cef7e6877ce99e247e52d16ed4421dfdf8976c",
+		},
+		{
+			"aes256 empty", protocol.EncTypeAES256CTSHMACSHA384192,
+			"6d404d37faf79f9df0d33568d320669800eb4836472ea8a026d16b7182460c52", "",
+			"41f53fa5bfe7026d91faf9be959195a058707273a96a40f0a01960621ac612748b9bbfbe7eb4ce3c",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := crypto.Lookup(tt.etype)
+			assert.Err(t, err, nil)
+
+			plaintext, err := e.Decrypt(unhex(t, tt.key), 2, unhex(t, tt.ciphertext))
+			assert.Err(t, err, nil)
+			assert.Equal(t, hex.EncodeToString(plaintext), tt.plaintext)
+		})
+	}
+}
+
+// RFC 8009 Appendix A.
+func TestChecksum_RFC8009(t *testing.T) {
+	data := unhex(t, "000102030405060708090a0b0c0d0e0f1011121314")
+
+	tests := []struct {
+		etype protocol.EncType
+		key   string
+		want  string
+	}{
+		{
+			protocol.EncTypeAES128CTSHMACSHA256128,
+			"3705d96080c17728a0e800eab6e0d23c",
+			"d78367186643d67b411cba9139fc1dee",
+		},
+		{
+			protocol.EncTypeAES256CTSHMACSHA384192,
+			"6d404d37faf79f9df0d33568d320669800eb4836472ea8a026d16b7182460c52",
+			"45ee791567eefca37f4ac1e0222de80d43c3bfa06699672a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.etype.String(), func(t *testing.T) {
+			e, err := crypto.Lookup(tt.etype)
+			assert.Err(t, err, nil)
+
+			sum, err := e.Checksum(unhex(t, tt.key), 2, data)
+			assert.Err(t, err, nil)
+			assert.Equal(t, hex.EncodeToString(sum), tt.want)
+		})
+	}
+}
+
+func TestEncTypes(t *testing.T) {
+	for _, etype := range crypto.SupportedEncTypes {
+		t.Run(etype.String(), func(t *testing.T) {
+			e, err := crypto.Lookup(etype)
+			assert.Err(t, err, nil)
+			assert.Equal(t, e.ID(), etype)
+
+			key := bytes.Repeat([]byte{0x42}, e.KeySize())
+
+			// Every plaintext length, from empty to several blocks
+			for n := range 50 {
+				plaintext := bytes.Repeat([]byte{byte(n)}, n)
+				ciphertext, err := e.Encrypt(key, 3, plaintext)
+				assert.Err(t, err, nil)
+
+				got, err := e.Decrypt(key, 3, ciphertext)
+				assert.Err(t, err, nil)
+				assert.True(t, bytes.Equal(got, plaintext))
+
+				ciphertext[len(ciphertext)/2] ^= 0x01
+				_, err = e.Decrypt(key, 3, ciphertext)
+				assert.Err(t, err, crypto.ErrAuthFailed)
+			}
+
+			// A ciphertext made for one usage is refused for any other
+			ciphertext, err := e.Encrypt(key, crypto.KeyUsageAPReqAuth, []byte("authenticator"))
+			assert.Err(t, err, nil)
+			_, err = e.Decrypt(key, crypto.KeyUsageASRepEncPart, ciphertext)
+			assert.Err(t, err, crypto.ErrAuthFailed)
+
+			sum, err := e.Checksum(key, crypto.KeyUsageTGSReqAuthCksum, []byte("body"))
+			assert.Err(t, err, nil)
+			other, err := e.Checksum(key, crypto.KeyUsageAPReqAuthCksum, []byte("body"))
+			assert.Err(t, err, nil)
+			assert.True(t, !bytes.Equal(sum, other))
+
+			_, err = e.Encrypt(key[1:], 3, []byte("data"))
+			assert.Err(t, err, crypto.ErrInvalidKey)
+		})
+	}
+}
+
+func TestParseEncType(t *testing.T) {
+	etype, err := crypto.ParseEncType("aes256-cts-hmac-sha384-192")
+	assert.Err(t, err, nil)
+	assert.Equal(t, etype, protocol.EncTypeAES256CTSHMACSHA384192)
+
+	etype, err = crypto.ParseEncType("17")
+	assert.Err(t, err, nil)
+	assert.Equal(t, etype, protocol.EncTypeAES128CTSHMACSHA196)
+
+	_, err = crypto.ParseEncType("des-cbc-crc")
+	assert.Err(t, err, crypto.ErrUnsupportedEncType)
+	_, err = crypto.ParseEncType("23")
+	assert.Err(t, err, crypto.ErrUnsupportedEncType)
+}
+
+func TestStringToKey_InvalidParams(t *testing.T) {
+	_, err := crypto.StringToKey(protocol.EncTypeAES256CTSHMACSHA196, "password", "salt", []byte{1, 2})
+	assert.Err(t, err, crypto.ErrInvalidParams)
+	_, err = crypto.StringToKey(protocol.EncTypeAES256CTSHMACSHA196, "password", "salt", crypto.IterationParams(0))
+	assert.Err(t, err, crypto.ErrInvalidParams)
+}
+
+// RFC 8009 Appendix A.
+func TestPRF_RFC8009(t *testing.T) {
+	tests := []struct {
+		etype protocol.EncType
+		key   string
+		want  string
+	}{
+		{
+			protocol.EncTypeAES128CTSHMACSHA256128,
+			"3705d96080c17728a0e800eab6e0d23c",
+			"9d188616f63852fe86915bb840b4a886ff3e6bb0f819b49b893393d393854295",
+		},
+		{
+			protocol.EncTypeAES256CTSHMACSHA384192,
+			"6d404d37faf79f9df0d33568d320669800eb4836472ea8a026d16b7182460c52",
+			"9801f69a368c2bf675e59521e177d9a07f67efe1cfde8d3c8d6f6a0256e3b17db3c1b62ad1b8553360d17367eb1514d2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.etype.String(), func(t *testing.T) {
+			e, err := crypto.Lookup(tt.etype)
+			assert.Err(t, err, nil)
+
+			out, err := e.PRF(unhex(t, tt.key), []byte("test"))
+			assert.Err(t, err, nil)
+			assert.Equal(t, hex.EncodeToString(out), tt.want)
+		})
+	}
+}
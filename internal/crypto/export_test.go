@@ -0,0 +1,8 @@
+package crypto
+
+// The raw ciphertext-stealing mode, without the confounder and checksum of
+// the aes-sha1 enctypes, for the RFC 3962 Appendix B vectors.
+var (
+	CTSEncrypt = ctsEncrypt
+	CTSDecrypt = ctsDecrypt
+)
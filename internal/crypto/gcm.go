@@ -0,0 +1,102 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// aesGCM is aes256-gcm: AES-256 in GCM mode with a random nonce prepended
+// to each ciphertext, and HMAC-SHA256 checksums. Keys are used as they are;
+// the key usage is bound as additional data instead, and string-to-key is
+// the password stretched as it is, by DeriveKey's PBKDF2-HMAC-SHA256 or by
+// Argon2id.
+type aesGCM struct{}
+
+func (aesGCM) ID() protocol.EncType                { return protocol.EncTypeAES256GCM }
+func (aesGCM) ChecksumType() protocol.ChecksumType { return protocol.ChecksumHMACSHA256 }
+func (aesGCM) KeySize() int                        { return defaultKeySize }
+
+func (aesGCM) StringToKey(password, salt string, params []byte) ([]byte, error) {
+	return stretch(password, []byte(salt), params, defaultIterations, defaultKeySize, sha256.New)
+}
+
+func (aesGCM) DeriveKey(key []byte, _ KeyUsage, _ Purpose) ([]byte, error) {
+	return append([]byte(nil), key...), nil
+}
+
+func (aesGCM) Encrypt(key []byte, usage KeyUsage, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNonceGeneration, err)
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, usageAD(usage)), nil
+}
+
+func (aesGCM) Decrypt(key []byte, usage KeyUsage, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("%w: data too short", ErrMalformedCiphertext)
+	}
+
+	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, usageAD(usage))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAuthFailed, err)
+	}
+
+	return plaintext, nil
+}
+
+// Checksum is the HMAC-SHA256 of the usage followed by data.
+func (aesGCM) Checksum(key []byte, usage KeyUsage, data []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(usageAD(usage))
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+// PRF is the HMAC-SHA256 of "prf" followed by input.
+func (aesGCM) PRF(key, input []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("prf"))
+	mac.Write(input)
+	return mac.Sum(nil), nil
+}
+
+// usageAD encodes usage as 4 big-endian bytes.
+func usageAD(usage KeyUsage) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(usage))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
@@ -2,12 +2,15 @@ package crypto
 
 import (
 	"encoding/hex"
+	"fmt"
 
 	"github.com/rizesql/kerberos/internal/protocol"
 )
 
+// KeyGenerator makes new session keys.
 type KeyGenerator interface {
-	Generate(size int) (protocol.SessionKey, error)
+	// Generate makes a key of etype.
+	Generate(etype protocol.EncType) (protocol.SessionKey, error)
 }
 
 type RandomKeyGenerator struct{}
@@ -18,8 +21,17 @@ func NewKeyGenerator() *RandomKeyGenerator {
 
 var _ KeyGenerator = &RandomKeyGenerator{}
 
-func (RandomKeyGenerator) Generate(size int) (protocol.SessionKey, error) {
-	return GenerateRandomKey(size)
+func (RandomKeyGenerator) Generate(etype protocol.EncType) (protocol.SessionKey, error) {
+	size, err := KeySize(etype)
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+
+	key, err := GenerateRandomKey(size)
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+	return key.WithEncType(etype), nil
 }
 
 type TestKeyGenerator struct {
@@ -38,6 +50,19 @@ func NewTestKeyGenerator(key ...protocol.SessionKey) *TestKeyGenerator {
 	return &TestKeyGenerator{Key: key[0]}
 }
 
-func (m TestKeyGenerator) Generate(size int) (protocol.SessionKey, error) {
-	return m.Key, nil
+// Generate returns the fixed key, cut to the size of etype.
+func (m TestKeyGenerator) Generate(etype protocol.EncType) (protocol.SessionKey, error) {
+	size, err := KeySize(etype)
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+	if len(m.Key.Expose()) < size {
+		return protocol.SessionKey{}, fmt.Errorf("%w: test key shorter than %d bytes", ErrInvalidKey, size)
+	}
+
+	key, err := protocol.NewSessionKey(m.Key.Expose()[:size])
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+	return key.WithEncType(etype), nil
 }
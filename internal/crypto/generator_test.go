@@ -0,0 +1,26 @@
+package crypto_test
+
+import (
+	"testing"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+func TestGenerate(t *testing.T) {
+	for _, etype := range crypto.SupportedEncTypes {
+		t.Run(etype.String(), func(t *testing.T) {
+			key, err := crypto.NewKeyGenerator().Generate(etype)
+			assert.Err(t, err, nil)
+			assert.Equal(t, key.EncType(), etype)
+
+			size, err := crypto.KeySize(etype)
+			assert.Err(t, err, nil)
+			assert.Equal(t, len(key.Expose()), size)
+		})
+	}
+
+	_, err := crypto.NewKeyGenerator().Generate(protocol.EncType(23))
+	assert.Err(t, err, crypto.ErrUnsupportedEncType)
+}
@@ -0,0 +1,33 @@
+package crypto
+
+import (
+	"crypto/sha1"
+
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// OctetStringToKey is the octetstring2key of RFC 4556 §3.2.3.1, which turns
+// the secret of a PKINIT key agreement into a key of etype: the SHA-1 of a
+// one-byte counter followed by x, for counters 0, 1 and so on, concatenated
+// and truncated to the key size. Random-to-key is the identity for every
+// type here.
+func OctetStringToKey(etype protocol.EncType, x []byte) (protocol.SessionKey, error) {
+	size, err := KeySize(etype)
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+
+	var out []byte
+	for i := 0; len(out) < size; i++ {
+		h := sha1.New()
+		h.Write([]byte{byte(i)})
+		h.Write(x)
+		out = h.Sum(out)
+	}
+
+	key, err := protocol.NewSessionKey(out[:size])
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+	return key.WithEncType(etype), nil
+}
@@ -0,0 +1,36 @@
+package crypto_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"testing"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+func TestOctetStringToKey(t *testing.T) {
+	x := []byte("shared secret of a key agreement")
+	first := sha1.Sum(append([]byte{0}, x...))
+	second := sha1.Sum(append([]byte{1}, x...))
+
+	t.Run("AES128", func(t *testing.T) {
+		key, err := crypto.OctetStringToKey(protocol.EncTypeAES128CTSHMACSHA196, x)
+		assert.Err(t, err, nil)
+		assert.Equal(t, key.EncType(), protocol.EncTypeAES128CTSHMACSHA196)
+		assert.True(t, bytes.Equal(key.Expose(), first[:16]))
+	})
+
+	t.Run("AES256", func(t *testing.T) {
+		key, err := crypto.OctetStringToKey(protocol.EncTypeAES256CTSHMACSHA196, x)
+		assert.Err(t, err, nil)
+		want := append(first[:], second[:12]...)
+		assert.True(t, bytes.Equal(key.Expose(), want))
+	})
+
+	t.Run("UnsupportedType", func(t *testing.T) {
+		_, err := crypto.OctetStringToKey(protocol.EncType(1), x)
+		assert.Err(t, err, crypto.ErrUnsupportedEncType)
+	})
+}
@@ -0,0 +1,61 @@
+package crypto
+
+import (
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// PRF is the pseudo-random function of input under key, of the key's
+// encryption type.
+func PRF(key protocol.SessionKey, input []byte) ([]byte, error) {
+	e, err := Lookup(key.EncType())
+	if err != nil {
+		return nil, err
+	}
+	return e.PRF(key.Expose(), input)
+}
+
+// prfPlus stretches the PRF of pepper under key to size bytes, by
+// concatenating its outputs for the inputs 1||pepper, 2||pepper and so on
+// (RFC 6113 §5.1).
+func prfPlus(key protocol.SessionKey, pepper string, size int) ([]byte, error) {
+	var out []byte
+	for i := 1; len(out) < size; i++ {
+		if i > 255 {
+			return nil, ErrInvalidKey
+		}
+		next, err := PRF(key, append([]byte{byte(i)}, pepper...))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, next...)
+	}
+	return out[:size], nil
+}
+
+// CF2 is KRB-FX-CF2 (RFC 6113 §5.1): a key of k1's type that depends on
+// both keys, the XOR of PRF+ of each under its pepper. Random-to-key is the
+// identity for every type here, so the XOR is the key.
+func CF2(k1, k2 protocol.SessionKey, pepper1, pepper2 string) (protocol.SessionKey, error) {
+	size, err := KeySize(k1.EncType())
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+
+	a, err := prfPlus(k1, pepper1, size)
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+	b, err := prfPlus(k2, pepper2, size)
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+	for i := range a {
+		a[i] ^= b[i]
+	}
+
+	key, err := protocol.NewSessionKey(a)
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+	return key.WithEncType(k1.EncType()), nil
+}
@@ -0,0 +1,57 @@
+package crypto_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// The vectors of MIT krb5's t_cf2, whose keys are the passwords "key1" and
+// "key2" salted with themselves.
+func TestCF2(t *testing.T) {
+	tests := []struct {
+		etype protocol.EncType
+		want  string
+	}{
+		{protocol.EncTypeAES128CTSHMACSHA196, "97df97e4b798b29eb31ed7280287a92a"},
+		{protocol.EncTypeAES256CTSHMACSHA196, "4d6ca4e629785c1f01baf55e2e548566b9617ae3a96868c337cb93b5e72b1c7b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.etype.String(), func(t *testing.T) {
+			k1, err := crypto.StringToKey(tt.etype, "key1", "key1", nil)
+			assert.Err(t, err, nil)
+			k2, err := crypto.StringToKey(tt.etype, "key2", "key2", nil)
+			assert.Err(t, err, nil)
+
+			key, err := crypto.CF2(k1, k2, "a", "b")
+			assert.Err(t, err, nil)
+			assert.Equal(t, hex.EncodeToString(key.Expose()), tt.want)
+			assert.Equal(t, key.EncType(), tt.etype)
+		})
+	}
+}
+
+func TestCF2_MixedTypes(t *testing.T) {
+	k1, err := crypto.NewKeyGenerator().Generate(protocol.EncTypeAES256GCM)
+	assert.Err(t, err, nil)
+	k2, err := crypto.NewKeyGenerator().Generate(protocol.EncTypeAES128CTSHMACSHA256128)
+	assert.Err(t, err, nil)
+
+	// The result takes the first key's type, and swapping the keys or the
+	// peppers gives another key.
+	key, err := crypto.CF2(k1, k2, "subkeyarmor", "ticketarmor")
+	assert.Err(t, err, nil)
+	assert.Equal(t, key.EncType(), protocol.EncTypeAES256GCM)
+	assert.Equal(t, len(key.Expose()), 32)
+
+	other, err := crypto.CF2(k1, k2, "ticketarmor", "subkeyarmor")
+	assert.Err(t, err, nil)
+	assert.True(t, hex.EncodeToString(key.Expose()) != hex.EncodeToString(other.Expose()))
+
+	_, err = crypto.CF2(protocol.SessionKey{}, k2, "a", "b")
+	assert.Err(t, err, crypto.ErrUnsupportedEncType)
+}
@@ -0,0 +1,179 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// This file holds the pieces of the RFC 3961 simplified profile that the
+// AES encryption types share.
+
+// nfold stretches or shrinks in to n bytes (RFC 3961 §5.1). Copies of in,
+// each rotated right by 13 bits more than the last, are laid end to end
+// until their length is a multiple of n, then added up in n-byte blocks
+// with one's-complement addition.
+func nfold(in []byte, n int) []byte {
+	l := lcm(len(in), n)
+
+	buf := make([]byte, 0, l)
+	for i := 0; len(buf) < l; i++ {
+		buf = append(buf, rotateRight(in, 13*i)...)
+	}
+
+	out := make([]byte, n)
+	for i := 0; i < l; i += n {
+		onesAdd(out, buf[i:i+n])
+	}
+	return out
+}
+
+// rotateRight rotates the bit string in right by bits.
+func rotateRight(in []byte, bits int) []byte {
+	size := len(in) * 8
+	bits %= size
+
+	out := make([]byte, len(in))
+	for i := range size {
+		src := (i - bits + size) % size
+		if in[src/8]&(0x80>>(src%8)) != 0 {
+			out[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return out
+}
+
+// onesAdd adds b to a, both big-endian, with the carry out of the top byte
+// folded back into the bottom one.
+func onesAdd(a, b []byte) {
+	carry := 0
+	for i := len(a) - 1; i >= 0; i-- {
+		sum := int(a[i]) + int(b[i]) + carry
+		a[i], carry = byte(sum), sum>>8
+	}
+	for carry != 0 {
+		for i := len(a) - 1; i >= 0 && carry != 0; i-- {
+			sum := int(a[i]) + carry
+			a[i], carry = byte(sum), sum>>8
+		}
+	}
+}
+
+func lcm(a, b int) int {
+	x, y := a, b
+	for y != 0 {
+		x, y = y, x%y
+	}
+	return a / x * b
+}
+
+// usageConstant is the well-known constant from which the key for purpose
+// under usage is derived (RFC 3961 §5.3).
+func usageConstant(usage KeyUsage, purpose Purpose) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(usage)), byte(purpose))
+}
+
+// dk derives a key of the same size as key from constant (RFC 3961 §5.1).
+// For AES, random-to-key is the identity, so DK is DR.
+func dk(key, constant []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	in := nfold(constant, aes.BlockSize)
+	out := make([]byte, 0, len(key)+aes.BlockSize)
+	for len(out) < len(key) {
+		next := make([]byte, aes.BlockSize)
+		block.Encrypt(next, in)
+		out = append(out, next...)
+		in = next
+	}
+	return out[:len(key)], nil
+}
+
+// ctsEncrypt encrypts plaintext, at least one block long, with AES in CBC
+// mode with ciphertext stealing and a zero IV (RFC 3962 §5). The last two
+// blocks are always swapped.
+func ctsEncrypt(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	if len(plaintext) < aes.BlockSize {
+		return nil, fmt.Errorf("%w: plaintext shorter than a block", ErrMalformedCiphertext)
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if len(plaintext) == aes.BlockSize {
+		out := make([]byte, aes.BlockSize)
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, plaintext)
+		return out, nil
+	}
+
+	// Encrypt the plaintext zero-padded to whole blocks, then swap the last
+	// two blocks and cut the result back to the length of the plaintext.
+	padded := make([]byte, (len(plaintext)+aes.BlockSize-1)/aes.BlockSize*aes.BlockSize)
+	copy(padded, plaintext)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+
+	n := len(padded)
+	last := append([]byte(nil), padded[n-aes.BlockSize:]...)
+	copy(padded[n-aes.BlockSize:], padded[n-2*aes.BlockSize:n-aes.BlockSize])
+	copy(padded[n-2*aes.BlockSize:], last)
+	return padded[:len(plaintext)], nil
+}
+
+// ctsDecrypt reverses ctsEncrypt.
+func ctsDecrypt(key, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	if len(ciphertext) < aes.BlockSize {
+		return nil, fmt.Errorf("%w: ciphertext shorter than a block", ErrMalformedCiphertext)
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if len(ciphertext) == aes.BlockSize {
+		out := make([]byte, aes.BlockSize)
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, ciphertext)
+		return out, nil
+	}
+
+	// The block before the partial last one is the encryption of the last
+	// plaintext block. Decrypting it yields the last plaintext block XOR
+	// the stolen ciphertext, whose tail completes the partial block.
+	tail := len(ciphertext) - (len(ciphertext)-1)/aes.BlockSize*aes.BlockSize
+	head := len(ciphertext) - tail - aes.BlockSize
+
+	d := make([]byte, aes.BlockSize)
+	block.Decrypt(d, ciphertext[head:head+aes.BlockSize])
+
+	stolen := make([]byte, aes.BlockSize)
+	copy(stolen, ciphertext[head+aes.BlockSize:])
+	copy(stolen[tail:], d[tail:])
+
+	out := make([]byte, len(ciphertext))
+	for i := range tail {
+		out[head+aes.BlockSize+i] = d[i] ^ stolen[i]
+	}
+
+	// The rest is plain CBC, with the completed block back in its place.
+	rest := append(append([]byte(nil), ciphertext[:head]...), stolen...)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out[:head+aes.BlockSize], rest)
+	return out, nil
+}
+
+// confounder is the random block that the simplified profile prepends to
+// each plaintext so that equal plaintexts seal differently.
+func confounder() ([]byte, error) {
+	c := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, c); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNonceGeneration, err)
+	}
+	return c, nil
+}
@@ -0,0 +1,133 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"fmt"
+
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// aesSHA1 is aes128-cts-hmac-sha1-96 or aes256-cts-hmac-sha1-96 (RFC 3962):
+// AES in CBC mode with ciphertext stealing, checked with HMAC-SHA1
+// truncated to 96 bits, under keys derived with the RFC 3961 DK function.
+type aesSHA1 struct {
+	etype   protocol.EncType
+	ctype   protocol.ChecksumType
+	keySize int
+}
+
+const (
+	aesSHA1Iterations = 4096
+	aesSHA1MACSize    = 12
+)
+
+func (e aesSHA1) ID() protocol.EncType                { return e.etype }
+func (e aesSHA1) ChecksumType() protocol.ChecksumType { return e.ctype }
+func (e aesSHA1) KeySize() int                        { return e.keySize }
+
+// StringToKey is PBKDF2-HMAC-SHA1 over the password and salt, passed
+// through DK with the constant "kerberos" (RFC 3962 §4). Params may put
+// Argon2id in place of PBKDF2.
+func (e aesSHA1) StringToKey(password, salt string, params []byte) ([]byte, error) {
+	tkey, err := stretch(password, []byte(salt), params, aesSHA1Iterations, e.keySize, sha1.New)
+	if err != nil {
+		return nil, err
+	}
+	return dk(tkey, []byte("kerberos"))
+}
+
+func (e aesSHA1) DeriveKey(key []byte, usage KeyUsage, purpose Purpose) ([]byte, error) {
+	if len(key) != e.keySize {
+		return nil, fmt.Errorf("%w: %s wants %d bytes, got %d", ErrInvalidKey, e.etype, e.keySize, len(key))
+	}
+	return dk(key, usageConstant(usage, purpose))
+}
+
+// Encrypt seals a random confounder and plaintext under Ke, followed by
+// their HMAC under Ki (RFC 3961 §5.3).
+func (e aesSHA1) Encrypt(key []byte, usage KeyUsage, plaintext []byte) ([]byte, error) {
+	ke, err := e.DeriveKey(key, usage, PurposeEncryption)
+	if err != nil {
+		return nil, err
+	}
+	ki, err := e.DeriveKey(key, usage, PurposeIntegrity)
+	if err != nil {
+		return nil, err
+	}
+
+	conf, err := confounder()
+	if err != nil {
+		return nil, err
+	}
+	data := append(conf, plaintext...)
+
+	ciphertext, err := ctsEncrypt(ke, data)
+	if err != nil {
+		return nil, err
+	}
+	return append(ciphertext, hmacSHA1(ki, data)...), nil
+}
+
+func (e aesSHA1) Decrypt(key []byte, usage KeyUsage, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 16+aesSHA1MACSize {
+		return nil, fmt.Errorf("%w: data too short", ErrMalformedCiphertext)
+	}
+
+	ke, err := e.DeriveKey(key, usage, PurposeEncryption)
+	if err != nil {
+		return nil, err
+	}
+	ki, err := e.DeriveKey(key, usage, PurposeIntegrity)
+	if err != nil {
+		return nil, err
+	}
+
+	body, mac := ciphertext[:len(ciphertext)-aesSHA1MACSize], ciphertext[len(ciphertext)-aesSHA1MACSize:]
+	data, err := ctsDecrypt(ke, body)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(hmacSHA1(ki, data), mac) {
+		return nil, ErrAuthFailed
+	}
+	return data[16:], nil
+}
+
+// Checksum is the HMAC-SHA1-96 of data under Kc.
+func (e aesSHA1) Checksum(key []byte, usage KeyUsage, data []byte) ([]byte, error) {
+	kc, err := e.DeriveKey(key, usage, PurposeChecksum)
+	if err != nil {
+		return nil, err
+	}
+	return hmacSHA1(kc, data), nil
+}
+
+// PRF is the SHA-1 of input, cut to one block and encrypted in CBC mode
+// with a zero IV under DK(key, "prf") (RFC 3962 §6).
+func (e aesSHA1) PRF(key, input []byte) ([]byte, error) {
+	if len(key) != e.keySize {
+		return nil, fmt.Errorf("%w: %s wants %d bytes, got %d", ErrInvalidKey, e.etype, e.keySize, len(key))
+	}
+	kp, err := dk(key, []byte("prf"))
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(kp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	sum := sha1.Sum(input)
+	out := make([]byte, aes.BlockSize)
+	cipher.NewCBCEncrypter(block, make([]byte, aes.BlockSize)).CryptBlocks(out, sum[:aes.BlockSize])
+	return out, nil
+}
+
+func hmacSHA1(key, data []byte) []byte {
+	mac := hmac.New(sha1.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)[:aesSHA1MACSize]
+}
@@ -0,0 +1,162 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// aesSHA2 is aes128-cts-hmac-sha256-128 or aes256-cts-hmac-sha384-192
+// (RFC 8009): AES in CBC mode with ciphertext stealing, with keys derived
+// and ciphertext checked by HMAC-SHA256 or HMAC-SHA384.
+type aesSHA2 struct {
+	etype   protocol.EncType
+	ctype   protocol.ChecksumType
+	keySize int
+}
+
+const aesSHA2Iterations = 32768
+
+func (e aesSHA2) ID() protocol.EncType                { return e.etype }
+func (e aesSHA2) ChecksumType() protocol.ChecksumType { return e.ctype }
+func (e aesSHA2) KeySize() int                        { return e.keySize }
+
+// hash is SHA-256 for the 128-bit type and SHA-384 for the 256-bit one.
+func (e aesSHA2) hash() func() hash.Hash {
+	if e.keySize == 16 {
+		return sha256.New
+	}
+	return sha512.New384
+}
+
+// macSize is the length of the truncated HMAC, which is also the size of
+// Kc and Ki.
+func (e aesSHA2) macSize() int {
+	if e.keySize == 16 {
+		return 16
+	}
+	return 24
+}
+
+// kdf is KDF-HMAC-SHA2 (RFC 8009 §3): the first size bytes of the HMAC of
+// a counter of 1, the label, a zero byte, the context and the output size
+// in bits.
+func (e aesSHA2) kdf(key, label, context []byte, size int) []byte {
+	mac := hmac.New(e.hash(), key)
+	mac.Write([]byte{0, 0, 0, 1})
+	mac.Write(label)
+	mac.Write([]byte{0})
+	mac.Write(context)
+	mac.Write(binary.BigEndian.AppendUint32(nil, uint32(size*8)))
+	return mac.Sum(nil)[:size]
+}
+
+// StringToKey is PBKDF2 with the type's hash over the password and the
+// salt prefixed by the type's name and a zero byte, passed through the KDF
+// with the label "kerberos" (RFC 8009 §4). Params may put Argon2id in place
+// of PBKDF2.
+func (e aesSHA2) StringToKey(password, salt string, params []byte) ([]byte, error) {
+	saltp := append(append([]byte(e.etype.String()), 0), salt...)
+	tkey, err := stretch(password, saltp, params, aesSHA2Iterations, e.keySize, e.hash())
+	if err != nil {
+		return nil, err
+	}
+	return e.kdf(tkey, []byte("kerberos"), nil, e.keySize), nil
+}
+
+func (e aesSHA2) DeriveKey(key []byte, usage KeyUsage, purpose Purpose) ([]byte, error) {
+	if len(key) != e.keySize {
+		return nil, fmt.Errorf("%w: %s wants %d bytes, got %d", ErrInvalidKey, e.etype, e.keySize, len(key))
+	}
+
+	size := e.macSize()
+	if purpose == PurposeEncryption {
+		size = e.keySize
+	}
+	return e.kdf(key, usageConstant(usage, purpose), nil, size), nil
+}
+
+// Encrypt seals a random confounder and plaintext under Ke, followed by
+// the HMAC under Ki of the zero IV and the ciphertext (RFC 8009 §5).
+func (e aesSHA2) Encrypt(key []byte, usage KeyUsage, plaintext []byte) ([]byte, error) {
+	ke, err := e.DeriveKey(key, usage, PurposeEncryption)
+	if err != nil {
+		return nil, err
+	}
+	ki, err := e.DeriveKey(key, usage, PurposeIntegrity)
+	if err != nil {
+		return nil, err
+	}
+
+	conf, err := confounder()
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := ctsEncrypt(ke, append(conf, plaintext...))
+	if err != nil {
+		return nil, err
+	}
+	return append(ciphertext, e.mac(ki, ciphertext)...), nil
+}
+
+func (e aesSHA2) Decrypt(key []byte, usage KeyUsage, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 16+e.macSize() {
+		return nil, fmt.Errorf("%w: data too short", ErrMalformedCiphertext)
+	}
+
+	ke, err := e.DeriveKey(key, usage, PurposeEncryption)
+	if err != nil {
+		return nil, err
+	}
+	ki, err := e.DeriveKey(key, usage, PurposeIntegrity)
+	if err != nil {
+		return nil, err
+	}
+
+	body, mac := ciphertext[:len(ciphertext)-e.macSize()], ciphertext[len(ciphertext)-e.macSize():]
+	if !hmac.Equal(e.mac(ki, body), mac) {
+		return nil, ErrAuthFailed
+	}
+
+	data, err := ctsDecrypt(ke, body)
+	if err != nil {
+		return nil, err
+	}
+	return data[16:], nil
+}
+
+// Checksum is the truncated HMAC of data under Kc.
+func (e aesSHA2) Checksum(key []byte, usage KeyUsage, data []byte) ([]byte, error) {
+	kc, err := e.DeriveKey(key, usage, PurposeChecksum)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(e.hash(), kc)
+	mac.Write(data)
+	return mac.Sum(nil)[:e.macSize()], nil
+}
+
+// PRF is the KDF under key with the label "prf" and input as the context,
+// as long as the type's hash (RFC 8009 §5).
+func (e aesSHA2) PRF(key, input []byte) ([]byte, error) {
+	if len(key) != e.keySize {
+		return nil, fmt.Errorf("%w: %s wants %d bytes, got %d", ErrInvalidKey, e.etype, e.keySize, len(key))
+	}
+	return e.kdf(key, []byte("prf"), input, e.hash()().Size()), nil
+}
+
+// mac is the integrity check of ciphertext: the truncated HMAC under ki of
+// the cipher state, a zero IV, followed by the ciphertext.
+func (e aesSHA2) mac(ki, ciphertext []byte) []byte {
+	mac := hmac.New(e.hash(), ki)
+	mac.Write(make([]byte, 16))
+	mac.Write(ciphertext)
+	return mac.Sum(nil)[:e.macSize()]
+}
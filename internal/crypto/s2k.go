@@ -0,0 +1,196 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// KDF is the function that stretches a password into key material, which
+// an encryption type's string-to-key then turns into a key. Which one a key
+// was made with is recorded in its string-to-key params.
+type KDF string
+
+const (
+	KDFPBKDF2   KDF = "pbkdf2"
+	KDFArgon2id KDF = "argon2id"
+)
+
+// ParseKDF looks up a KDF by name.
+func ParseKDF(name string) (KDF, error) {
+	switch kdf := KDF(name); kdf {
+	case KDFPBKDF2, KDFArgon2id:
+		return kdf, nil
+	default:
+		return "", fmt.Errorf("%w: unknown kdf %q", ErrInvalidParams, name)
+	}
+}
+
+// KDFOf reports which KDF string-to-key params select.
+func KDFOf(params []byte) KDF {
+	if bytes.HasPrefix(params, []byte(argon2idTag)) {
+		return KDFArgon2id
+	}
+	return KDFPBKDF2
+}
+
+// IterationParams encodes n as the string-to-key params of the PBKDF2-based
+// encryption types.
+func IterationParams(n uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, n)
+}
+
+// iterations reads the 4-byte big-endian iteration count that the PBKDF2
+// string-to-key functions take as params.
+func iterations(params []byte, def int) (int, error) {
+	if params == nil {
+		return def, nil
+	}
+	if len(params) != 4 {
+		return 0, fmt.Errorf("%w: want a 4-byte iteration count, got %d bytes", ErrInvalidParams, len(params))
+	}
+
+	n := int(binary.BigEndian.Uint32(params))
+	if n == 0 {
+		return 0, fmt.Errorf("%w: zero iteration count", ErrInvalidParams)
+	}
+	return n, nil
+}
+
+// Argon2idParams tune Argon2id (RFC 9106): Time passes over Memory KiB of
+// memory, filled by Threads lanes.
+type Argon2idParams struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// DefaultArgon2idParams is the second recommended option of RFC 9106 §4:
+// three passes over 64 MiB with four lanes.
+var DefaultArgon2idParams = Argon2idParams{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+// argon2idTag starts the params of a key stretched with Argon2id. PBKDF2
+// params are a bare 4-byte iteration count, so the two cannot be confused.
+const argon2idTag = "argon2id"
+
+// maxArgon2idMemory bounds the memory params may ask for, 4 GiB, since a
+// client derives its key with whatever the KDC advertises.
+const maxArgon2idMemory = 4 << 20
+
+// Params encodes p as string-to-key params: the tag followed by the time
+// and memory, 4 bytes big-endian each, and the number of threads.
+func (p Argon2idParams) Params() []byte {
+	b := append([]byte(argon2idTag), 0, 0, 0, 0, 0, 0, 0, 0, p.Threads)
+	binary.BigEndian.PutUint32(b[len(argon2idTag):], p.Time)
+	binary.BigEndian.PutUint32(b[len(argon2idTag)+4:], p.Memory)
+	return b
+}
+
+// Validate checks that Argon2id can run with p.
+func (p Argon2idParams) Validate() error {
+	switch {
+	case p.Time == 0:
+		return fmt.Errorf("%w: argon2id time must be at least 1", ErrInvalidParams)
+	case p.Threads == 0:
+		return fmt.Errorf("%w: argon2id threads must be at least 1", ErrInvalidParams)
+	case p.Memory < 8*uint32(p.Threads):
+		return fmt.Errorf("%w: argon2id memory must be at least 8 KiB per thread", ErrInvalidParams)
+	case p.Memory > maxArgon2idMemory:
+		return fmt.Errorf("%w: argon2id memory above %d KiB", ErrInvalidParams, maxArgon2idMemory)
+	}
+	return nil
+}
+
+// ParseArgon2idParams decodes params made by Argon2idParams.Params.
+func ParseArgon2idParams(params []byte) (Argon2idParams, error) {
+	rest, ok := bytes.CutPrefix(params, []byte(argon2idTag))
+	if !ok || len(rest) != 9 {
+		return Argon2idParams{}, fmt.Errorf("%w: malformed argon2id params", ErrInvalidParams)
+	}
+
+	p := Argon2idParams{
+		Time:    binary.BigEndian.Uint32(rest),
+		Memory:  binary.BigEndian.Uint32(rest[4:]),
+		Threads: rest[8],
+	}
+	if err := p.Validate(); err != nil {
+		return Argon2idParams{}, err
+	}
+	return p, nil
+}
+
+// S2KPolicy bounds the cost of the string-to-key params a client derives
+// its key with. The KDC advertises them in the clear unless FAST protects
+// the exchange: below the floor, a party in the middle could have the
+// client derive a cheap key and guess the password offline from its
+// pre-authentication; above the ceiling, it could hang the client.
+type S2KPolicy struct {
+	MinIterations uint32
+	MaxIterations uint32
+	// MinArgon2id and MaxArgon2id bound the Time and Memory of Argon2id.
+	MinArgon2id Argon2idParams
+	MaxArgon2id Argon2idParams
+}
+
+// DefaultS2KPolicy accepts PBKDF2 from its usual 4096 iterations and
+// Argon2id from OWASP's lowest recommended cost, two passes over 19 MiB.
+var DefaultS2KPolicy = S2KPolicy{
+	MinIterations: defaultIterations,
+	MaxIterations: 1 << 21,
+	MinArgon2id:   Argon2idParams{Time: 2, Memory: 19 * 1024},
+	MaxArgon2id:   Argon2idParams{Time: 16, Memory: maxArgon2idMemory},
+}
+
+// Check refuses params whose cost falls outside p. Nil params select the
+// encryption type's default, which is always accepted.
+func (p S2KPolicy) Check(params []byte) error {
+	if params == nil {
+		return nil
+	}
+
+	if KDFOf(params) == KDFArgon2id {
+		a, err := ParseArgon2idParams(params)
+		if err != nil {
+			return err
+		}
+		switch {
+		case a.Time < p.MinArgon2id.Time || a.Memory < p.MinArgon2id.Memory:
+			return fmt.Errorf("%w: argon2id below %d passes over %d KiB", ErrParamsRefused, p.MinArgon2id.Time, p.MinArgon2id.Memory)
+		case a.Time > p.MaxArgon2id.Time || a.Memory > p.MaxArgon2id.Memory:
+			return fmt.Errorf("%w: argon2id above %d passes over %d KiB", ErrParamsRefused, p.MaxArgon2id.Time, p.MaxArgon2id.Memory)
+		}
+		return nil
+	}
+
+	n, err := iterations(params, 0)
+	if err != nil {
+		return err
+	}
+	if n < int(p.MinIterations) || n > int(p.MaxIterations) {
+		return fmt.Errorf("%w: pbkdf2 iterations outside %d to %d", ErrParamsRefused, p.MinIterations, p.MaxIterations)
+	}
+	return nil
+}
+
+// stretch is the first step of string-to-key: Argon2id when params select
+// it, otherwise PBKDF2 with prf and the iteration count in params, or def
+// iterations without params. It returns size bytes.
+func stretch(password string, salt []byte, params []byte, def, size int, prf func() hash.Hash) ([]byte, error) {
+	if KDFOf(params) == KDFArgon2id {
+		p, err := ParseArgon2idParams(params)
+		if err != nil {
+			return nil, err
+		}
+		return argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(size)), nil
+	}
+
+	iter, err := iterations(params, def)
+	if err != nil {
+		return nil, err
+	}
+	return pbkdf2.Key([]byte(password), salt, iter, size, prf), nil
+}
@@ -0,0 +1,115 @@
+package crypto_test
+
+import (
+	"testing"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"golang.org/x/crypto/argon2"
+)
+
+// cheap keeps Argon2id fast enough for tests.
+var cheap = crypto.Argon2idParams{Time: 1, Memory: 64, Threads: 1}
+
+func TestArgon2idParams(t *testing.T) {
+	params := crypto.DefaultArgon2idParams.Params()
+	assert.Equal(t, crypto.KDFOf(params), crypto.KDFArgon2id)
+	assert.Equal(t, crypto.KDFOf(crypto.IterationParams(4096)), crypto.KDFPBKDF2)
+	assert.Equal(t, crypto.KDFOf(nil), crypto.KDFPBKDF2)
+
+	got, err := crypto.ParseArgon2idParams(params)
+	assert.Err(t, err, nil)
+	assert.Equal(t, got, crypto.DefaultArgon2idParams)
+
+	_, err = crypto.ParseArgon2idParams(params[:len(params)-1])
+	assert.Err(t, err, crypto.ErrInvalidParams)
+
+	for name, p := range map[string]crypto.Argon2idParams{
+		"no time":       {Time: 0, Memory: 64, Threads: 1},
+		"no threads":    {Time: 1, Memory: 64, Threads: 0},
+		"little memory": {Time: 1, Memory: 15, Threads: 2},
+		"much memory":   {Time: 1, Memory: 1 << 30, Threads: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Err(t, p.Validate(), crypto.ErrInvalidParams)
+			_, err := crypto.StringToKey(protocol.EncTypeAES256GCM, "password", "salt", p.Params())
+			assert.Err(t, err, crypto.ErrInvalidParams)
+		})
+	}
+
+	kdf, err := crypto.ParseKDF("argon2id")
+	assert.Err(t, err, nil)
+	assert.Equal(t, kdf, crypto.KDFArgon2id)
+	_, err = crypto.ParseKDF("scrypt")
+	assert.Err(t, err, crypto.ErrInvalidParams)
+}
+
+func TestStringToKey_Argon2id(t *testing.T) {
+	// AES-256-GCM uses the stretched password as its key.
+	key, err := crypto.StringToKey(protocol.EncTypeAES256GCM, "password", "ATHENA.MIT.EDUalice", cheap.Params())
+	assert.Err(t, err, nil)
+	want := argon2.IDKey([]byte("password"), []byte("ATHENA.MIT.EDUalice"), cheap.Time, cheap.Memory, cheap.Threads, 32)
+	assert.Equal(t, key.Expose(), want)
+
+	for _, etype := range crypto.SupportedEncTypes {
+		t.Run(etype.String(), func(t *testing.T) {
+			key, err := crypto.StringToKey(etype, "password", "ATHENA.MIT.EDUalice", cheap.Params())
+			assert.Err(t, err, nil)
+			assert.Equal(t, key.EncType(), etype)
+
+			again, err := crypto.StringToKey(etype, "password", "ATHENA.MIT.EDUalice", cheap.Params())
+			assert.Err(t, err, nil)
+			assert.Equal(t, again.Expose(), key.Expose())
+
+			// Other parameters, or PBKDF2, make another key.
+			stronger := cheap
+			stronger.Time++
+			other, err := crypto.StringToKey(etype, "password", "ATHENA.MIT.EDUalice", stronger.Params())
+			assert.Err(t, err, nil)
+			assert.True(t, string(other.Expose()) != string(key.Expose()))
+
+			pbkdf2, err := crypto.StringToKey(etype, "password", "ATHENA.MIT.EDUalice", crypto.IterationParams(1))
+			assert.Err(t, err, nil)
+			assert.True(t, string(pbkdf2.Expose()) != string(key.Expose()))
+		})
+	}
+}
+
+func TestS2KPolicy(t *testing.T) {
+	policy := crypto.DefaultS2KPolicy
+
+	accepted := map[string][]byte{
+		"default":              nil,
+		"pbkdf2 floor":         crypto.IterationParams(policy.MinIterations),
+		"pbkdf2 ceiling":       crypto.IterationParams(policy.MaxIterations),
+		"argon2id default":     crypto.DefaultArgon2idParams.Params(),
+		"argon2id floor":       crypto.Argon2idParams{Time: policy.MinArgon2id.Time, Memory: policy.MinArgon2id.Memory, Threads: 1}.Params(),
+		"argon2id ceiling":     crypto.Argon2idParams{Time: policy.MaxArgon2id.Time, Memory: policy.MaxArgon2id.Memory, Threads: 4}.Params(),
+		"argon2id many passes": crypto.Argon2idParams{Time: policy.MaxArgon2id.Time, Memory: policy.MinArgon2id.Memory, Threads: 1}.Params(),
+	}
+	for name, params := range accepted {
+		t.Run(name, func(t *testing.T) {
+			assert.Err(t, policy.Check(params), nil)
+		})
+	}
+
+	refused := map[string][]byte{
+		"pbkdf2 one iteration":   crypto.IterationParams(1),
+		"pbkdf2 below floor":     crypto.IterationParams(policy.MinIterations - 1),
+		"pbkdf2 above ceiling":   crypto.IterationParams(policy.MaxIterations + 1),
+		"argon2id cheapest":      cheap.Params(),
+		"argon2id little memory": crypto.Argon2idParams{Time: 3, Memory: policy.MinArgon2id.Memory - 1, Threads: 1}.Params(),
+		"argon2id one pass":      crypto.Argon2idParams{Time: policy.MinArgon2id.Time - 1, Memory: 64 * 1024, Threads: 1}.Params(),
+		"argon2id many passes":   crypto.Argon2idParams{Time: policy.MaxArgon2id.Time + 1, Memory: 64 * 1024, Threads: 1}.Params(),
+		"argon2id max time":      crypto.Argon2idParams{Time: 1<<32 - 1, Memory: 64 * 1024, Threads: 1}.Params(),
+	}
+	for name, params := range refused {
+		t.Run(name, func(t *testing.T) {
+			assert.Err(t, policy.Check(params), crypto.ErrParamsRefused)
+		})
+	}
+
+	// Params that cannot be read at all are invalid rather than refused.
+	assert.Err(t, policy.Check([]byte{1, 2}), crypto.ErrInvalidParams)
+}
@@ -0,0 +1,35 @@
+package crypto
+
+// KeyUsage names what a key is being used for (RFC 4120 §7.5.1). Every
+// encryption and checksum binds its usage, so a ciphertext or checksum made
+// for one message is rejected as any other, even under the same key.
+type KeyUsage uint32
+
+const (
+	KeyUsageASReqTimestamp   KeyUsage = 1  // PA-ENC-TIMESTAMP, under the client key
+	KeyUsageTicket           KeyUsage = 2  // Ticket, under the service key
+	KeyUsageASRepEncPart     KeyUsage = 3  // AS-REP encrypted part, under the client key
+	KeyUsageTGSReqAuthCksum  KeyUsage = 6  // TGS-REQ authenticator checksum, under the TGT session key
+	KeyUsageTGSReqAuth       KeyUsage = 7  // TGS-REQ authenticator, under the TGT session key
+	KeyUsageTGSRepEncPart    KeyUsage = 8  // TGS-REP encrypted part, under the TGT session key
+	KeyUsageTGSRepEncPartSub KeyUsage = 9  // TGS-REP encrypted part, under the authenticator subkey
+	KeyUsageAPReqAuthCksum   KeyUsage = 10 // AP-REQ authenticator checksum, under the session key
+	KeyUsageAPReqAuth        KeyUsage = 11 // AP-REQ authenticator, under the session key
+	KeyUsageAPRepEncPart     KeyUsage = 12 // AP-REP encrypted part, under the session key
+	KeyUsageKRBPrivEncPart   KeyUsage = 13 // KRB-PRIV encrypted part
+	KeyUsageKRBCredEncPart   KeyUsage = 14 // KRB-CRED encrypted part
+	KeyUsageKRBSafeCksum     KeyUsage = 15 // KRB-SAFE checksum
+	KeyUsagePAForUser        KeyUsage = 17 // PA-FOR-USER, the usage MS-SFU gives its checksum
+	KeyUsageADKDCIssuedCksum KeyUsage = 19 // checksums over KDC-issued authorization data
+	KeyUsageFastReqChksum    KeyUsage = 50 // FAST request checksum, under the armor key
+	KeyUsageFastEnc          KeyUsage = 51 // FAST request encrypted part, under the armor key
+	KeyUsageFastRep          KeyUsage = 52 // FAST response encrypted part, under the armor key
+	KeyUsageFastFinished     KeyUsage = 53 // FAST finished ticket checksum, under the armor key
+)
+
+// Key usages of our own, from the range RFC 4120 §7.5.1 reserves for
+// applications.
+const (
+	KeyUsageKDBKey         KeyUsage = 1024 // long-term key in the KDC database, under the master key
+	KeyUsageKDBMasterCheck KeyUsage = 1025 // known plaintext that tells a wrong master key apart
+)
@@ -6,6 +6,7 @@ import (
 
 	"github.com/rizesql/kerberos/internal/assert"
 	"github.com/rizesql/kerberos/internal/kdb"
+	"github.com/rizesql/kerberos/internal/protocol"
 	"github.com/rizesql/kerberos/internal/testkit"
 )
 
@@ -13,7 +14,7 @@ func TestCreatePrincipal(t *testing.T) {
 	h := testkit.NewHarness(t)
 
 	// 1. Create a valid principal
-	p := h.CreatePrincipal(t.Context(), kdb.CreatePrincipalParams{
+	p := h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
 		PrimaryName: "testuser",
 		Instance:    "",
 		Realm:       "ATHENA.MIT.EDU",
@@ -28,7 +29,6 @@ func TestCreatePrincipal(t *testing.T) {
 		PrimaryName: "testuser",
 		Instance:    "",
 		Realm:       "ATHENA.MIT.EDU",
-		KeyBytes:    []byte("other_key"),
 		Kvno:        1,
 	})
 	// Should fail with a constraint error (sqlite3 returns non-nil)
@@ -41,7 +41,6 @@ func TestCreatePrincipal(t *testing.T) {
 		PrimaryName: "",
 		Instance:    "",
 		Realm:       "REALM",
-		KeyBytes:    []byte("key"),
 		Kvno:        1,
 	})
 	if err == nil {
@@ -53,7 +52,7 @@ func TestGetPrincipal(t *testing.T) {
 	h := testkit.NewHarness(t)
 
 	// Insert
-	h.CreatePrincipal(t.Context(), kdb.CreatePrincipalParams{
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
 		PrimaryName: "service",
 		Instance:    "http",
 		Realm:       "REALM",
@@ -68,7 +67,6 @@ func TestGetPrincipal(t *testing.T) {
 		Realm:       "REALM",
 	})
 	assert.Err(t, err, nil)
-	assert.Equal(t, string(row.KeyBytes), "my_key")
 	assert.Equal(t, row.Kvno, int64(2))
 
 	// Get - Not Found
@@ -80,17 +78,84 @@ func TestGetPrincipal(t *testing.T) {
 	assert.Err(t, err, sql.ErrNoRows)
 }
 
+func TestKeys(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	p := h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "service",
+		Instance:    "http",
+		Realm:       "REALM",
+		KeyBytes:    []byte("old_key"),
+		Kvno:        1,
+	})
+	h.AddKey(t.Context(), p, 2, protocol.EncTypeAES256GCM, []byte("new_key"))
+
+	// Newest version first
+	keys, err := kdb.Query.ListKeys(t.Context(), h.DB, kdb.ListKeysParams{
+		PrimaryName: "service",
+		Instance:    "http",
+		Realm:       "REALM",
+	})
+	assert.Err(t, err, nil)
+	assert.Equal(t, len(keys), 2)
+	assert.Equal(t, keys[0].Kvno, int64(2))
+	assert.Equal(t, keys[1].Kvno, int64(1))
+
+	// Keys are stored sealed under the master key
+	for i, want := range []string{"new_key", "old_key"} {
+		assert.True(t, string(keys[i].KeyBytes) != want)
+		key, err := h.DB.MasterKeys().Open(keys[i].Mkvno, keys[i].KeyBytes)
+		assert.Err(t, err, nil)
+		assert.Equal(t, string(key), want)
+	}
+
+	// One key per version and encryption type
+	err = kdb.Query.AddKey(t.Context(), h.DB, kdb.AddKeyParams{
+		PrincipalID: p.ID,
+		Kvno:        2,
+		Enctype:     keys[0].Enctype,
+		Mkvno:       1,
+		KeyBytes:    []byte("duplicate"),
+	})
+	if err == nil {
+		t.Fatal("expected error on duplicate key, got nil")
+	}
+
+	// The salt and string-to-key parameters are kept with each key
+	err = kdb.Query.AddKey(t.Context(), h.DB, kdb.AddKeyParams{
+		PrincipalID: p.ID,
+		Kvno:        2,
+		Enctype:     int64(protocol.EncTypeAES256CTSHMACSHA196),
+		Salt:        "REALMservicehttp",
+		S2kparams:   []byte{0x00, 0x00, 0x10, 0x00},
+		Mkvno:       1,
+		KeyBytes:    []byte("aes_key"),
+	})
+	assert.Err(t, err, nil)
+
+	keys, err = kdb.Query.ListKeys(t.Context(), h.DB, kdb.ListKeysParams{
+		PrimaryName: "service",
+		Instance:    "http",
+		Realm:       "REALM",
+	})
+	assert.Err(t, err, nil)
+	assert.Equal(t, len(keys), 3)
+	assert.Equal(t, keys[0].S2kparams, []byte(nil))
+	assert.Equal(t, keys[1].Salt, "REALMservicehttp")
+	assert.Equal(t, keys[1].S2kparams, []byte{0x00, 0x00, 0x10, 0x00})
+}
+
 func TestListPrincipals(t *testing.T) {
 	h := testkit.NewHarness(t)
 
-	h.CreatePrincipal(t.Context(), kdb.CreatePrincipalParams{
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
 		PrimaryName: "alice",
 		Instance:    "",
 		Realm:       "R",
 		KeyBytes:    []byte("k"),
 		Kvno:        1,
 	})
-	h.CreatePrincipal(t.Context(), kdb.CreatePrincipalParams{
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
 		PrimaryName: "bob",
 		Instance:    "",
 		Realm:       "R",
@@ -105,3 +170,225 @@ func TestListPrincipals(t *testing.T) {
 	assert.Equal(t, list[0].PrimaryName, "alice")
 	assert.Equal(t, list[1].PrimaryName, "bob")
 }
+
+func TestDelegations(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	add := func(target string) error {
+		return kdb.Query.AddDelegation(t.Context(), h.DB, kdb.AddDelegationParams{
+			ServicePrimary:  "http",
+			ServiceInstance: "frontend",
+			ServiceRealm:    "R",
+			TargetPrimary:   target,
+			TargetInstance:  "backend",
+			TargetRealm:     "R",
+		})
+	}
+	list := func() []kdb.ListDelegationsRow {
+		rows, err := kdb.Query.ListDelegations(t.Context(), h.DB, kdb.ListDelegationsParams{
+			ServicePrimary:  "http",
+			ServiceInstance: "frontend",
+			ServiceRealm:    "R",
+		})
+		assert.Err(t, err, nil)
+		return rows
+	}
+
+	assert.Err(t, add("postgres"), nil)
+	assert.Err(t, add("ldap"), nil)
+
+	// Duplicate constraint violation
+	if err := add("ldap"); err == nil {
+		t.Fatal("expected error on duplicate delegation, got nil")
+	}
+
+	rows := list()
+	assert.Equal(t, len(rows), 2)
+	assert.Equal(t, rows[0].TargetPrimary, "ldap")
+	assert.Equal(t, rows[1].TargetPrimary, "postgres")
+	assert.Equal(t, rows[1].TargetInstance, "backend")
+
+	removed, err := kdb.Query.RemoveDelegation(t.Context(), h.DB, kdb.RemoveDelegationParams{
+		ServicePrimary:  "http",
+		ServiceInstance: "frontend",
+		ServiceRealm:    "R",
+		TargetPrimary:   "ldap",
+		TargetInstance:  "backend",
+		TargetRealm:     "R",
+	})
+	assert.Err(t, err, nil)
+	assert.Equal(t, removed, int64(1))
+	assert.Equal(t, len(list()), 1)
+}
+
+func TestUpdatePrincipalLimits(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "alice",
+		Instance:    "",
+		Realm:       "R",
+		KeyBytes:    []byte("k"),
+		Kvno:        1,
+		MaxLife:     sql.NullInt64{Int64: 3600, Valid: true},
+	})
+
+	get := func() kdb.GetPrincipalRow {
+		row, err := kdb.Query.GetPrincipal(t.Context(), h.DB, kdb.GetPrincipalParams{
+			PrimaryName: "alice",
+			Instance:    "",
+			Realm:       "R",
+		})
+		assert.Err(t, err, nil)
+		return row
+	}
+
+	row := get()
+	assert.Equal(t, row.MaxLife, sql.NullInt64{Int64: 3600, Valid: true})
+	assert.Equal(t, row.MaxRenewableLife.Valid, false)
+
+	updated, err := kdb.Query.UpdatePrincipalLimits(t.Context(), h.DB, kdb.UpdatePrincipalLimitsParams{
+		MaxLife:          sql.NullInt64{},
+		MaxRenewableLife: sql.NullInt64{Int64: 86400, Valid: true},
+		PrimaryName:      "alice",
+		Instance:         "",
+		Realm:            "R",
+	})
+	assert.Err(t, err, nil)
+	assert.Equal(t, updated, int64(1))
+
+	row = get()
+	assert.Equal(t, row.MaxLife.Valid, false)
+	assert.Equal(t, row.MaxRenewableLife, sql.NullInt64{Int64: 86400, Valid: true})
+
+	// CHECK constraint violation (non-positive limit)
+	_, err = kdb.Query.UpdatePrincipalLimits(t.Context(), h.DB, kdb.UpdatePrincipalLimitsParams{
+		MaxLife:     sql.NullInt64{Int64: 0, Valid: true},
+		PrimaryName: "alice",
+		Instance:    "",
+		Realm:       "R",
+	})
+	if err == nil {
+		t.Fatal("expected error on zero max_life, got nil")
+	}
+}
+
+func TestUpdatePrincipalFlags(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "alice",
+		Instance:    "",
+		Realm:       "R",
+		KeyBytes:    []byte("k"),
+		Kvno:        1,
+	})
+
+	params := kdb.GetPrincipalParams{PrimaryName: "alice", Instance: "", Realm: "R"}
+	row, err := kdb.Query.GetPrincipal(t.Context(), h.DB, params)
+	assert.Err(t, err, nil)
+	assert.Equal(t, row.Flags, int64(0))
+
+	updated, err := kdb.Query.UpdatePrincipalFlags(t.Context(), h.DB, kdb.UpdatePrincipalFlagsParams{
+		Flags:       int64(kdb.FlagRequiresFAST),
+		PrimaryName: "alice",
+		Instance:    "",
+		Realm:       "R",
+	})
+	assert.Err(t, err, nil)
+	assert.Equal(t, updated, int64(1))
+
+	row, err = kdb.Query.GetPrincipal(t.Context(), h.DB, params)
+	assert.Err(t, err, nil)
+	assert.True(t, kdb.PrincipalFlag(row.Flags).Has(kdb.FlagRequiresFAST))
+
+	updated, err = kdb.Query.UpdatePrincipalFlags(t.Context(), h.DB, kdb.UpdatePrincipalFlagsParams{
+		PrimaryName: "bob",
+		Instance:    "",
+		Realm:       "R",
+	})
+	assert.Err(t, err, nil)
+	assert.Equal(t, updated, int64(0))
+}
+
+func TestGroups(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	for _, name := range []string{"alice", "bob"} {
+		h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+			PrimaryName: name,
+			Instance:    "",
+			Realm:       "R",
+			KeyBytes:    []byte("key"),
+			Kvno:        1,
+		})
+	}
+
+	assert.Err(t, kdb.Query.CreateGroup(t.Context(), h.DB, "staff"), nil)
+	assert.Err(t, kdb.Query.CreateGroup(t.Context(), h.DB, "admins"), nil)
+
+	// Duplicate constraint violation
+	if err := kdb.Query.CreateGroup(t.Context(), h.DB, "staff"); err == nil {
+		t.Fatal("expected error on duplicate group, got nil")
+	}
+
+	groups, err := kdb.Query.ListGroups(t.Context(), h.DB)
+	assert.Err(t, err, nil)
+	assert.Equal(t, len(groups), 2)
+	assert.Equal(t, groups[0], "admins")
+
+	addMember := func(group, member string) int64 {
+		added, err := kdb.Query.AddGroupMember(t.Context(), h.DB, kdb.AddGroupMemberParams{
+			Name:        group,
+			PrimaryName: member,
+			Instance:    "",
+			Realm:       "R",
+		})
+		assert.Err(t, err, nil)
+		return added
+	}
+
+	assert.Equal(t, addMember("staff", "alice"), int64(1))
+	assert.Equal(t, addMember("admins", "alice"), int64(1))
+	assert.Equal(t, addMember("staff", "bob"), int64(1))
+	assert.Equal(t, addMember("staff", "carol"), int64(0))
+	assert.Equal(t, addMember("ops", "alice"), int64(0))
+
+	memberOf, err := kdb.Query.ListPrincipalGroups(t.Context(), h.DB, kdb.ListPrincipalGroupsParams{
+		PrimaryName: "alice",
+		Instance:    "",
+		Realm:       "R",
+	})
+	assert.Err(t, err, nil)
+	assert.Equal(t, len(memberOf), 2)
+	assert.Equal(t, memberOf[0], "admins")
+	assert.Equal(t, memberOf[1], "staff")
+
+	members, err := kdb.Query.ListGroupMembers(t.Context(), h.DB, "staff")
+	assert.Err(t, err, nil)
+	assert.Equal(t, len(members), 2)
+	assert.Equal(t, members[1].PrimaryName, "bob")
+
+	removed, err := kdb.Query.RemoveGroupMember(t.Context(), h.DB, kdb.RemoveGroupMemberParams{
+		Name:        "staff",
+		PrimaryName: "bob",
+		Instance:    "",
+		Realm:       "R",
+	})
+	assert.Err(t, err, nil)
+	assert.Equal(t, removed, int64(1))
+
+	assert.Err(t, kdb.Query.RemoveGroupMembers(t.Context(), h.DB, "admins"), nil)
+	deleted, err := kdb.Query.DeleteGroup(t.Context(), h.DB, "admins")
+	assert.Err(t, err, nil)
+	assert.Equal(t, deleted, int64(1))
+
+	memberOf, err = kdb.Query.ListPrincipalGroups(t.Context(), h.DB, kdb.ListPrincipalGroupsParams{
+		PrimaryName: "alice",
+		Instance:    "",
+		Realm:       "R",
+	})
+	assert.Err(t, err, nil)
+	assert.Equal(t, len(memberOf), 1)
+	assert.Equal(t, memberOf[0], "staff")
+}
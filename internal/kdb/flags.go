@@ -0,0 +1,13 @@
+package kdb
+
+// PrincipalFlag is a policy flag of a principal, one bit of
+// principals.flags.
+type PrincipalFlag int64
+
+const (
+	// FlagRequiresFAST refuses the principal's requests that are not armored
+	// with FAST (RFC 6113).
+	FlagRequiresFAST PrincipalFlag = 1 << 0
+)
+
+func (f PrincipalFlag) Has(flag PrincipalFlag) bool { return f&flag == flag }
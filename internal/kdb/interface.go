@@ -8,6 +8,8 @@ import (
 type Database interface {
 	DBTX
 	Close() error
+	// MasterKeys seal and open the long-term keys in the database.
+	MasterKeys() MasterKeys
 }
 
 type DBTX interface {
@@ -1,13 +1,20 @@
 package kdb
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/rizesql/kerberos/internal/o11y/logging"
 )
 
+var ErrSchemaOutdated = errors.New("database schema is out of date")
+
 type Config struct {
 	DSN    string
 	Logger *logging.Logger
@@ -16,6 +23,10 @@ type Config struct {
 type database struct {
 	*sql.DB
 	logger *logging.Logger
+
+	mu sync.RWMutex
+	// masterKeys seal and open the long-term keys, once Unlock sets them.
+	masterKeys MasterKeys
 }
 
 var _ DBTX = (*database)(nil)
@@ -56,3 +67,90 @@ func (d *database) Migrate() error {
 	d.logger.Info("database schema applied successfully")
 	return nil
 }
+
+// CheckSchema checks that every table and column of the current schema is
+// in the database. There is no migration from older schemas, such as the
+// one keeping unsealed keys in principals, so a database made with one is
+// refused here rather than failing on its first query.
+func (d *database) CheckSchema(ctx context.Context) error {
+	want, err := schemaColumns(ctx, SchemaSQL())
+	if err != nil {
+		return err
+	}
+
+	for table, columns := range want {
+		have, err := tableColumns(ctx, d, table)
+		if err != nil {
+			return err
+		}
+		if len(have) == 0 {
+			return fmt.Errorf("%w: no table %s; create the database again with `kdc setup`", ErrSchemaOutdated, table)
+		}
+		for _, column := range columns {
+			if !slices.Contains(have, column) {
+				return fmt.Errorf("%w: table %s has no column %s; create the database again with `kdc setup`", ErrSchemaOutdated, table, column)
+			}
+		}
+	}
+	return nil
+}
+
+// schemaColumns lists the columns of each table schema creates, by applying
+// it to an empty in-memory database.
+func schemaColumns(ctx context.Context, schema string) (map[string][]string, error) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	// Each connection to :memory: is a database of its own.
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		return nil, err
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string][]string, len(tables))
+	for _, table := range tables {
+		if columns[table], err = tableColumns(ctx, db, table); err != nil {
+			return nil, err
+		}
+	}
+	return columns, nil
+}
+
+func tableColumns(ctx context.Context, db DBTX, table string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
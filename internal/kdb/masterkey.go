@@ -0,0 +1,296 @@
+package kdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+var (
+	ErrWrongMasterKey   = errors.New("master key does not match the database")
+	ErrNoMasterKey      = errors.New("database is not unlocked with a master key")
+	ErrMasterKeyVersion = errors.New("key is sealed under a master key version not at hand")
+)
+
+// MasterKeyEncType is the encryption type of the master keys made here.
+const MasterKeyEncType = protocol.EncTypeAES256CTSHMACSHA384192
+
+// masterCheck is the known plaintext each master key version seals into
+// its verifier.
+var masterCheck = []byte("kdb master key")
+
+// MasterKeys holds versions of the realm master key, which seals every
+// long-term key in the database. Keys are sealed under the newest version
+// and opened under the one they record, so that keys sealed under an older
+// version stay readable while the master key is being changed.
+type MasterKeys struct {
+	keys    map[int64]protocol.SessionKey
+	current int64
+	// reload loads the master keys afresh, if they can be, for a key sealed
+	// under a version that was added after m was loaded.
+	reload func() (MasterKeys, error)
+}
+
+func NewMasterKeys(mkvno int64, key protocol.SessionKey) MasterKeys {
+	return MasterKeys{}.With(mkvno, key)
+}
+
+// With returns a copy of m that also holds key as version mkvno.
+func (m MasterKeys) With(mkvno int64, key protocol.SessionKey) MasterKeys {
+	keys := maps.Clone(m.keys)
+	if keys == nil {
+		keys = make(map[int64]protocol.SessionKey)
+	}
+	keys[mkvno] = key
+	return MasterKeys{keys: keys, current: max(m.current, mkvno), reload: m.reload}
+}
+
+// Current is the version new keys are sealed under.
+func (m MasterKeys) Current() int64 { return m.current }
+
+func (m MasterKeys) IsZero() bool { return len(m.keys) == 0 }
+
+// Versions lists the versions m holds, oldest first.
+func (m MasterKeys) Versions() []int64 {
+	return slices.Sorted(maps.Keys(m.keys))
+}
+
+func (m MasterKeys) Key(mkvno int64) (protocol.SessionKey, bool) {
+	key, ok := m.keys[mkvno]
+	return key, ok
+}
+
+// Seal encrypts a long-term key under the current master key version,
+// which it returns alongside.
+func (m MasterKeys) Seal(key []byte) (int64, []byte, error) {
+	mk, ok := m.keys[m.current]
+	if !ok {
+		return 0, nil, ErrNoMasterKey
+	}
+
+	sealed, err := crypto.Encrypt(mk, crypto.KeyUsageKDBKey, key)
+	if err != nil {
+		return 0, nil, err
+	}
+	return m.current, sealed, nil
+}
+
+// Open decrypts a long-term key sealed under master key version mkvno. A
+// version m lacks is looked for again where m was loaded from, in case the
+// master key was changed since.
+func (m MasterKeys) Open(mkvno int64, sealed []byte) ([]byte, error) {
+	if m.IsZero() {
+		return nil, ErrNoMasterKey
+	}
+
+	mk, ok := m.keys[mkvno]
+	if !ok && m.reload != nil {
+		fresh, err := m.reload()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %d: %w", ErrMasterKeyVersion, mkvno, err)
+		}
+		mk, ok = fresh.keys[mkvno]
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrMasterKeyVersion, mkvno)
+	}
+	return crypto.Decrypt(mk, crypto.KeyUsageKDBKey, sealed)
+}
+
+// AddMasterKey records key as version mkvno of the master key. A key derived
+// from a passphrase records the salt and string-to-key parameters it was
+// derived with.
+func AddMasterKey(ctx context.Context, db DBTX, mkvno int64, key protocol.SessionKey, salt string, params []byte) error {
+	verifier, err := crypto.Encrypt(key, crypto.KeyUsageKDBMasterCheck, masterCheck)
+	if err != nil {
+		return err
+	}
+
+	return Query.AddMasterKey(ctx, db, AddMasterKeyParams{
+		Mkvno:     mkvno,
+		Enctype:   int64(key.EncType()),
+		Salt:      salt,
+		S2kparams: params,
+		Verifier:  verifier,
+	})
+}
+
+func verify(row ListMasterKeysRow, key protocol.SessionKey) bool {
+	if key.EncType() != protocol.EncType(row.Enctype) {
+		return false
+	}
+	check, err := crypto.Decrypt(key, crypto.KeyUsageKDBMasterCheck, row.Verifier)
+	return err == nil && bytes.Equal(check, masterCheck)
+}
+
+// MasterKeySalt is the salt a master key is derived from a passphrase with,
+// the default salt of K/M@realm.
+func MasterKeySalt(realm protocol.Realm) string {
+	return string(realm) + "KM"
+}
+
+// DeriveMasterKey derives a master key from a passphrase, with Argon2id.
+// It returns the salt and parameters to record with it.
+func DeriveMasterKey(realm protocol.Realm, passphrase string) (protocol.SessionKey, string, []byte, error) {
+	salt, params := MasterKeySalt(realm), crypto.DefaultArgon2idParams.Params()
+	key, err := crypto.StringToKey(MasterKeyEncType, passphrase, salt, params)
+	if err != nil {
+		return protocol.SessionKey{}, "", nil, err
+	}
+	return key, salt, params, nil
+}
+
+// PassphraseMasterKeys derives, from each of passphrases, every master key
+// version the database records, and keeps those that match.
+func PassphraseMasterKeys(ctx context.Context, db DBTX, passphrases ...string) (MasterKeys, error) {
+	rows, err := Query.ListMasterKeys(ctx, db)
+	if err != nil {
+		return MasterKeys{}, err
+	}
+
+	var keys MasterKeys
+	for _, row := range rows {
+		if row.S2kparams == nil {
+			continue
+		}
+		for _, passphrase := range passphrases {
+			key, err := crypto.StringToKey(protocol.EncType(row.Enctype), passphrase, row.Salt, row.S2kparams)
+			if err != nil {
+				return MasterKeys{}, err
+			}
+			if verify(row, key) {
+				keys = keys.With(row.Mkvno, key)
+				break
+			}
+		}
+	}
+
+	if keys.IsZero() {
+		return MasterKeys{}, ErrWrongMasterKey
+	}
+	return keys, nil
+}
+
+// Unlock checks keys against the master key versions the database records
+// and seals and opens long-term keys with those that match from then on.
+// Versions the database does not record are left out; a version it
+// records that does not match is refused.
+func (d *database) Unlock(ctx context.Context, keys MasterKeys) error {
+	return d.unlock(ctx, keys, nil)
+}
+
+// unlock is Unlock, with the keys loading afresh through reload when they
+// lack a version.
+func (d *database) unlock(ctx context.Context, keys MasterKeys, reload func() (MasterKeys, error)) error {
+	rows, err := Query.ListMasterKeys(ctx, d)
+	if err != nil {
+		return fmt.Errorf("failed to list master keys: %w", err)
+	}
+
+	var unlocked MasterKeys
+	for _, row := range rows {
+		key, ok := keys.Key(row.Mkvno)
+		if !ok {
+			continue
+		}
+		if !verify(row, key) {
+			return fmt.Errorf("%w: version %d", ErrWrongMasterKey, row.Mkvno)
+		}
+		unlocked = unlocked.With(row.Mkvno, key)
+	}
+
+	if unlocked.IsZero() {
+		return ErrWrongMasterKey
+	}
+	unlocked.reload = reload
+
+	d.mu.Lock()
+	d.masterKeys = unlocked
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *database) MasterKeys() MasterKeys {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.masterKeys
+}
+
+// Reseal moves up to batch long-term keys sealed under an older master key
+// version to the current one, in one transaction, and reports how many it
+// moved. Calling it until it moves none reseals the whole database; each
+// key records its version, so an interrupted pass resumes where it stopped.
+func (d *database) Reseal(ctx context.Context, batch int) (int, error) {
+	masterKeys := d.MasterKeys()
+	tx, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := Query.ListKeysToReseal(ctx, tx, ListKeysToResealParams{
+		Mkvno: masterKeys.Current(),
+		Limit: int64(batch),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, row := range rows {
+		key, err := masterKeys.Open(row.Mkvno, row.KeyBytes)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open key %d/%d of principal %d: %w", row.Kvno, row.Enctype, row.PrincipalID, err)
+		}
+		mkvno, sealed, err := masterKeys.Seal(key)
+		if err != nil {
+			return 0, err
+		}
+
+		err = Query.ResealKey(ctx, tx, ResealKeyParams{
+			Mkvno:       mkvno,
+			KeyBytes:    sealed,
+			PrincipalID: row.PrincipalID,
+			Kvno:        row.Kvno,
+			Enctype:     row.Enctype,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(rows), tx.Commit()
+}
+
+// PurgeMasterKeys forgets every master key version but the current one,
+// once no key is sealed under them.
+func (d *database) PurgeMasterKeys(ctx context.Context) error {
+	masterKeys := d.MasterKeys()
+	current := masterKeys.Current()
+	rows, err := Query.ListKeysToReseal(ctx, d, ListKeysToResealParams{
+		Mkvno: current,
+		Limit: 1,
+	})
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		return fmt.Errorf("keys are still sealed under master key version %d", rows[0].Mkvno)
+	}
+
+	if _, err := Query.DeleteMasterKeysExcept(ctx, d, current); err != nil {
+		return err
+	}
+
+	purged := NewMasterKeys(current, masterKeys.keys[current])
+	purged.reload = masterKeys.reload
+	d.mu.Lock()
+	d.masterKeys = purged
+	d.mu.Unlock()
+	return nil
+}
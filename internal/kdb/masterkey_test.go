@@ -0,0 +1,238 @@
+package kdb_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/kdb"
+	"github.com/rizesql/kerberos/internal/o11y/logging"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/rizesql/kerberos/internal/testkit"
+)
+
+func masterKey(t *testing.T, b byte) protocol.SessionKey {
+	t.Helper()
+	key, err := protocol.NewSessionKey(bytes.Repeat([]byte{b}, 32))
+	assert.Err(t, err, nil)
+	return key.WithEncType(kdb.MasterKeyEncType)
+}
+
+func TestMasterKeys(t *testing.T) {
+	keys := kdb.NewMasterKeys(1, masterKey(t, 1))
+
+	mkvno, sealed, err := keys.Seal([]byte("long-term key"))
+	assert.Err(t, err, nil)
+	assert.Equal(t, mkvno, int64(1))
+	assert.True(t, !bytes.Contains(sealed, []byte("long-term key")))
+
+	// Sealing moves to the newest version; older ones still open.
+	keys = keys.With(2, masterKey(t, 2))
+	assert.Equal(t, keys.Current(), int64(2))
+	assert.Equal(t, keys.Versions(), []int64{1, 2})
+
+	opened, err := keys.Open(1, sealed)
+	assert.Err(t, err, nil)
+	assert.Equal(t, string(opened), "long-term key")
+
+	mkvno, _, err = keys.Seal([]byte("long-term key"))
+	assert.Err(t, err, nil)
+	assert.Equal(t, mkvno, int64(2))
+
+	_, err = keys.Open(3, sealed)
+	assert.Err(t, err, kdb.ErrMasterKeyVersion)
+	_, err = keys.Open(2, sealed)
+	assert.Err(t, err, crypto.ErrAuthFailed)
+
+	_, _, err = kdb.MasterKeys{}.Seal([]byte("long-term key"))
+	assert.Err(t, err, kdb.ErrNoMasterKey)
+	_, err = kdb.MasterKeys{}.Open(1, sealed)
+	assert.Err(t, err, kdb.ErrNoMasterKey)
+}
+
+func TestStash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kdc.stash")
+	keys := kdb.NewMasterKeys(1, masterKey(t, 1)).With(2, masterKey(t, 2))
+
+	assert.Err(t, kdb.WriteStash(path, keys), nil)
+	info, err := os.Stat(path)
+	assert.Err(t, err, nil)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0o600))
+
+	got, err := kdb.ReadStash(path)
+	assert.Err(t, err, nil)
+	assert.Equal(t, got.Versions(), []int64{1, 2})
+	key, _ := got.Key(2)
+	assert.Equal(t, key.Expose(), masterKey(t, 2).Expose())
+	assert.Equal(t, key.EncType(), kdb.MasterKeyEncType)
+
+	// A stash others can read is refused.
+	assert.Err(t, os.Chmod(path, 0o640), nil)
+	_, err = kdb.ReadStash(path)
+	assert.Err(t, err, kdb.ErrStashPermissions)
+
+	// Rewriting it replaces it whole, with safe permissions again.
+	assert.Err(t, kdb.WriteStash(path, kdb.NewMasterKeys(2, masterKey(t, 2))), nil)
+	got, err = kdb.ReadStash(path)
+	assert.Err(t, err, nil)
+	assert.Equal(t, got.Versions(), []int64{2})
+}
+
+func TestChangeMasterKey(t *testing.T) {
+	ctx := t.Context()
+	db, err := kdb.New(kdb.Config{
+		DSN:    filepath.Join(t.TempDir(), "kdc.db"),
+		Logger: logging.Noop(),
+	})
+	assert.Err(t, err, nil)
+	t.Cleanup(func() { db.Close() })
+	assert.Err(t, db.Migrate(), nil)
+
+	old, next := masterKey(t, 1), masterKey(t, 2)
+	assert.Err(t, kdb.AddMasterKey(ctx, db, 1, old, "", nil), nil)
+
+	// A key that does not match the database is refused.
+	assert.Err(t, db.Unlock(ctx, kdb.NewMasterKeys(1, next)), kdb.ErrWrongMasterKey)
+	assert.Err(t, db.Unlock(ctx, kdb.NewMasterKeys(1, old)), nil)
+
+	p, err := kdb.Query.CreatePrincipal(ctx, db, kdb.CreatePrincipalParams{
+		PrimaryName: "alice",
+		Realm:       "REALM",
+		Kvno:        1,
+	})
+	assert.Err(t, err, nil)
+	for _, etype := range crypto.SupportedEncTypes {
+		mkvno, sealed, err := db.MasterKeys().Seal([]byte("key"))
+		assert.Err(t, err, nil)
+		err = kdb.Query.AddKey(ctx, db, kdb.AddKeyParams{
+			PrincipalID: p.ID,
+			Kvno:        1,
+			Enctype:     int64(etype),
+			Mkvno:       mkvno,
+			KeyBytes:    sealed,
+		})
+		assert.Err(t, err, nil)
+	}
+
+	// Start the change and stop after one batch, as if interrupted.
+	assert.Err(t, kdb.AddMasterKey(ctx, db, 2, next, "", nil), nil)
+	assert.Err(t, db.Unlock(ctx, kdb.NewMasterKeys(1, old).With(2, next)), nil)
+	n, err := db.Reseal(ctx, 1)
+	assert.Err(t, err, nil)
+	assert.Equal(t, n, 1)
+	assert.True(t, db.PurgeMasterKeys(ctx) != nil)
+
+	// Resuming finishes the pass without touching resealed keys.
+	total := 0
+	for {
+		n, err := db.Reseal(ctx, 1)
+		assert.Err(t, err, nil)
+		if n == 0 {
+			break
+		}
+		total += n
+	}
+	assert.Equal(t, total, len(crypto.SupportedEncTypes)-1)
+	assert.Err(t, db.PurgeMasterKeys(ctx), nil)
+
+	// Only the new version is left, and it opens every key.
+	assert.Err(t, db.Unlock(ctx, kdb.NewMasterKeys(1, old)), kdb.ErrWrongMasterKey)
+	assert.Err(t, db.Unlock(ctx, kdb.NewMasterKeys(2, next)), nil)
+	keys, err := kdb.Query.ListKeys(ctx, db, kdb.ListKeysParams{PrimaryName: "alice", Realm: "REALM"})
+	assert.Err(t, err, nil)
+	assert.Equal(t, len(keys), len(crypto.SupportedEncTypes))
+	for _, k := range keys {
+		assert.Equal(t, k.Mkvno, int64(2))
+		key, err := db.MasterKeys().Open(k.Mkvno, k.KeyBytes)
+		assert.Err(t, err, nil)
+		assert.Equal(t, string(key), "key")
+	}
+}
+
+func TestUnlockFrom_Reload(t *testing.T) {
+	ctx := t.Context()
+	db, err := kdb.New(kdb.Config{
+		DSN:    filepath.Join(t.TempDir(), "kdc.db"),
+		Logger: logging.Noop(),
+	})
+	assert.Err(t, err, nil)
+	t.Cleanup(func() { db.Close() })
+	assert.Err(t, db.Migrate(), nil)
+
+	stash := filepath.Join(t.TempDir(), "kdc.stash")
+	old, next := masterKey(t, 1), masterKey(t, 2)
+	assert.Err(t, kdb.AddMasterKey(ctx, db, 1, old, "", nil), nil)
+	assert.Err(t, kdb.WriteStash(stash, kdb.NewMasterKeys(1, old)), nil)
+	assert.Err(t, db.UnlockFrom(ctx, stash, ""), nil)
+
+	// Another process changes the master key under the running one.
+	assert.Err(t, kdb.AddMasterKey(ctx, db, 2, next, "", nil), nil)
+	assert.Err(t, kdb.WriteStash(stash, kdb.NewMasterKeys(1, old).With(2, next)), nil)
+	mkvno, sealed, err := kdb.NewMasterKeys(2, next).Seal([]byte("key"))
+	assert.Err(t, err, nil)
+
+	key, err := db.MasterKeys().Open(mkvno, sealed)
+	assert.Err(t, err, nil)
+	assert.Equal(t, string(key), "key")
+	assert.Equal(t, db.MasterKeys().Versions(), []int64{1, 2})
+
+	// A version neither held nor stashed is still refused.
+	_, err = db.MasterKeys().Open(3, sealed)
+	assert.Err(t, err, kdb.ErrMasterKeyVersion)
+}
+
+func TestCheckSchema(t *testing.T) {
+	ctx := t.Context()
+	db, err := kdb.New(kdb.Config{
+		DSN:    filepath.Join(t.TempDir(), "kdc.db"),
+		Logger: logging.Noop(),
+	})
+	assert.Err(t, err, nil)
+	t.Cleanup(func() { db.Close() })
+
+	// The first schema kept unsealed keys in principals.
+	_, err = db.ExecContext(ctx, `CREATE TABLE principals (
+		id            INTEGER   PRIMARY KEY AUTOINCREMENT,
+		primary_name  TEXT      NOT NULL,
+		instance      TEXT      NOT NULL,
+		realm         TEXT      NOT NULL,
+		key_bytes     BLOB      NOT NULL,
+		kvno          INTEGER   NOT NULL  DEFAULT 1,
+		created_at    DATETIME            DEFAULT CURRENT_TIMESTAMP
+	)`)
+	assert.Err(t, err, nil)
+	assert.Err(t, db.CheckSchema(ctx), kdb.ErrSchemaOutdated)
+	assert.Err(t, db.UnlockFrom(ctx, filepath.Join(t.TempDir(), "kdc.stash"), ""), kdb.ErrSchemaOutdated)
+
+	current, err := kdb.New(kdb.Config{
+		DSN:    filepath.Join(t.TempDir(), "kdc.db"),
+		Logger: logging.Noop(),
+	})
+	assert.Err(t, err, nil)
+	t.Cleanup(func() { current.Close() })
+	assert.Err(t, current.Migrate(), nil)
+	assert.Err(t, current.CheckSchema(ctx), nil)
+}
+
+func TestPassphraseMasterKeys(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	// Cheap parameters keep the test fast; the salt and parameters are
+	// read back from the database.
+	params := crypto.Argon2idParams{Time: 1, Memory: 64, Threads: 1}.Params()
+	key, err := crypto.StringToKey(kdb.MasterKeyEncType, "passphrase", kdb.MasterKeySalt("REALM"), params)
+	assert.Err(t, err, nil)
+	assert.Err(t, kdb.AddMasterKey(t.Context(), h.DB, 2, key, kdb.MasterKeySalt("REALM"), params), nil)
+
+	keys, err := kdb.PassphraseMasterKeys(t.Context(), h.DB, "wrong", "passphrase")
+	assert.Err(t, err, nil)
+	assert.Equal(t, keys.Versions(), []int64{2})
+	got, _ := keys.Key(2)
+	assert.Equal(t, got.Expose(), key.Expose())
+
+	_, err = kdb.PassphraseMasterKeys(t.Context(), h.DB, "wrong")
+	assert.Err(t, err, kdb.ErrWrongMasterKey)
+}
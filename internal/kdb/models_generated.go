@@ -8,12 +8,57 @@ import (
 	"database/sql"
 )
 
-type Principal struct {
-	ID          int64        `db:"id"`
-	PrimaryName string       `db:"primary_name"`
-	Instance    string       `db:"instance"`
-	Realm       string       `db:"realm"`
-	KeyBytes    []byte       `db:"key_bytes"`
+type Delegation struct {
+	ID              int64        `db:"id"`
+	ServicePrimary  string       `db:"service_primary"`
+	ServiceInstance string       `db:"service_instance"`
+	ServiceRealm    string       `db:"service_realm"`
+	TargetPrimary   string       `db:"target_primary"`
+	TargetInstance  string       `db:"target_instance"`
+	TargetRealm     string       `db:"target_realm"`
+	CreatedAt       sql.NullTime `db:"created_at"`
+}
+
+type Group struct {
+	ID        int64        `db:"id"`
+	Name      string       `db:"name"`
+	CreatedAt sql.NullTime `db:"created_at"`
+}
+
+type GroupMember struct {
+	GroupID     int64        `db:"group_id"`
+	PrincipalID int64        `db:"principal_id"`
+	CreatedAt   sql.NullTime `db:"created_at"`
+}
+
+type Key struct {
+	PrincipalID int64        `db:"principal_id"`
 	Kvno        int64        `db:"kvno"`
+	Enctype     int64        `db:"enctype"`
+	Salt        string       `db:"salt"`
+	S2kparams   []byte       `db:"s2kparams"`
+	Mkvno       int64        `db:"mkvno"`
+	KeyBytes    []byte       `db:"key_bytes"`
 	CreatedAt   sql.NullTime `db:"created_at"`
 }
+
+type MasterKey struct {
+	Mkvno     int64        `db:"mkvno"`
+	Enctype   int64        `db:"enctype"`
+	Salt      string       `db:"salt"`
+	S2kparams []byte       `db:"s2kparams"`
+	Verifier  []byte       `db:"verifier"`
+	CreatedAt sql.NullTime `db:"created_at"`
+}
+
+type Principal struct {
+	ID               int64         `db:"id"`
+	PrimaryName      string        `db:"primary_name"`
+	Instance         string        `db:"instance"`
+	Realm            string        `db:"realm"`
+	Kvno             int64         `db:"kvno"`
+	MaxLife          sql.NullInt64 `db:"max_life"`
+	MaxRenewableLife sql.NullInt64 `db:"max_renewable_life"`
+	Flags            int64         `db:"flags"`
+	CreatedAt        sql.NullTime  `db:"created_at"`
+}
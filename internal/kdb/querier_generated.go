@@ -9,32 +9,185 @@ import (
 )
 
 type Querier interface {
+	//AddDelegation
+	//
+	//  INSERT INTO delegations (
+	//      service_primary,
+	//      service_instance,
+	//      service_realm,
+	//      target_primary,
+	//      target_instance,
+	//      target_realm
+	//  ) VALUES (
+	//      ?, ?, ?, ?, ?, ?
+	//  )
+	AddDelegation(ctx context.Context, db DBTX, arg AddDelegationParams) error
+	//AddGroupMember
+	//
+	//  INSERT INTO group_members (group_id, principal_id)
+	//  SELECT groups.id, principals.id
+	//  FROM groups, principals
+	//  WHERE groups.name = ?
+	//    AND principals.primary_name = ? AND principals.instance = ? AND principals.realm = ?
+	AddGroupMember(ctx context.Context, db DBTX, arg AddGroupMemberParams) (int64, error)
+	//AddKey
+	//
+	//  INSERT INTO keys (
+	//      principal_id,
+	//      kvno,
+	//      enctype,
+	//      salt,
+	//      s2kparams,
+	//      mkvno,
+	//      key_bytes
+	//  ) VALUES (
+	//      ?, ?, ?, ?, ?, ?, ?
+	//  )
+	AddKey(ctx context.Context, db DBTX, arg AddKeyParams) error
+	//AddMasterKey
+	//
+	//  INSERT INTO master_keys (
+	//      mkvno,
+	//      enctype,
+	//      salt,
+	//      s2kparams,
+	//      verifier
+	//  ) VALUES (
+	//      ?, ?, ?, ?, ?
+	//  )
+	AddMasterKey(ctx context.Context, db DBTX, arg AddMasterKeyParams) error
+	//CreateGroup
+	//
+	//  INSERT INTO groups (name) VALUES (?)
+	CreateGroup(ctx context.Context, db DBTX, name string) error
 	//CreatePrincipal
 	//
 	//  INSERT INTO principals (
 	//      primary_name,
 	//      instance,
 	//      realm,
-	//      key_bytes,
-	//      kvno
+	//      kvno,
+	//      max_life,
+	//      max_renewable_life
 	//  ) VALUES (
-	//      ?, ?, ?, ?, ?
+	//      ?, ?, ?, ?, ?, ?
 	//  )
-	//  RETURNING id, primary_name, instance, realm, key_bytes, kvno, created_at
+	//  RETURNING id, primary_name, instance, realm, kvno, max_life, max_renewable_life, flags, created_at
 	CreatePrincipal(ctx context.Context, db DBTX, arg CreatePrincipalParams) (Principal, error)
+	//DeleteGroup
+	//
+	//  DELETE FROM groups
+	//  WHERE name = ?
+	DeleteGroup(ctx context.Context, db DBTX, name string) (int64, error)
+	//DeleteMasterKeysExcept
+	//
+	//  DELETE FROM master_keys
+	//  WHERE mkvno != ?
+	DeleteMasterKeysExcept(ctx context.Context, db DBTX, mkvno int64) (int64, error)
 	//GetPrincipal
 	//
-	//  SELECT key_bytes, kvno
+	//  SELECT kvno, max_life, max_renewable_life, flags
 	//  FROM principals
 	//  WHERE primary_name = ? AND instance = ? AND realm = ?
 	//  LIMIT 1
 	GetPrincipal(ctx context.Context, db DBTX, arg GetPrincipalParams) (GetPrincipalRow, error)
+	//ListDelegations
+	//
+	//  SELECT target_primary, target_instance, target_realm
+	//  FROM delegations
+	//  WHERE service_primary = ? AND service_instance = ? AND service_realm = ?
+	//  ORDER BY target_primary, target_instance, target_realm
+	ListDelegations(ctx context.Context, db DBTX, arg ListDelegationsParams) ([]ListDelegationsRow, error)
+	//ListGroupMembers
+	//
+	//  SELECT principals.primary_name, principals.instance, principals.realm
+	//  FROM group_members
+	//  JOIN groups ON groups.id = group_members.group_id
+	//  JOIN principals ON principals.id = group_members.principal_id
+	//  WHERE groups.name = ?
+	//  ORDER BY principals.primary_name, principals.instance, principals.realm
+	ListGroupMembers(ctx context.Context, db DBTX, name string) ([]ListGroupMembersRow, error)
+	//ListGroups
+	//
+	//  SELECT name
+	//  FROM groups
+	//  ORDER BY name
+	ListGroups(ctx context.Context, db DBTX) ([]string, error)
+	//ListKeys
+	//
+	//  SELECT keys.kvno, keys.enctype, keys.salt, keys.s2kparams, keys.mkvno, keys.key_bytes
+	//  FROM keys
+	//  JOIN principals ON principals.id = keys.principal_id
+	//  WHERE principals.primary_name = ? AND principals.instance = ? AND principals.realm = ?
+	//  ORDER BY keys.kvno DESC, keys.enctype
+	ListKeys(ctx context.Context, db DBTX, arg ListKeysParams) ([]ListKeysRow, error)
+	//ListKeysToReseal
+	//
+	//  SELECT principal_id, kvno, enctype, mkvno, key_bytes
+	//  FROM keys
+	//  WHERE mkvno != ?
+	//  ORDER BY principal_id, kvno, enctype
+	//  LIMIT ?
+	ListKeysToReseal(ctx context.Context, db DBTX, arg ListKeysToResealParams) ([]ListKeysToResealRow, error)
+	//ListMasterKeys
+	//
+	//  SELECT mkvno, enctype, salt, s2kparams, verifier
+	//  FROM master_keys
+	//  ORDER BY mkvno
+	ListMasterKeys(ctx context.Context, db DBTX) ([]ListMasterKeysRow, error)
+	//ListPrincipalGroups
+	//
+	//  SELECT groups.name
+	//  FROM group_members
+	//  JOIN groups ON groups.id = group_members.group_id
+	//  JOIN principals ON principals.id = group_members.principal_id
+	//  WHERE principals.primary_name = ? AND principals.instance = ? AND principals.realm = ?
+	//  ORDER BY groups.name
+	ListPrincipalGroups(ctx context.Context, db DBTX, arg ListPrincipalGroupsParams) ([]string, error)
 	//ListPrincipals
 	//
 	//  SELECT primary_name, instance, realm
 	//  FROM principals
 	//  ORDER BY primary_name, instance
 	ListPrincipals(ctx context.Context, db DBTX) ([]ListPrincipalsRow, error)
+	//RemoveDelegation
+	//
+	//  DELETE FROM delegations
+	//  WHERE service_primary = ? AND service_instance = ? AND service_realm = ?
+	//    AND target_primary = ? AND target_instance = ? AND target_realm = ?
+	RemoveDelegation(ctx context.Context, db DBTX, arg RemoveDelegationParams) (int64, error)
+	//RemoveGroupMember
+	//
+	//  DELETE FROM group_members
+	//  WHERE group_id = (SELECT id FROM groups WHERE name = ?)
+	//    AND principal_id = (
+	//      SELECT id FROM principals
+	//      WHERE primary_name = ? AND instance = ? AND realm = ?
+	//    )
+	RemoveGroupMember(ctx context.Context, db DBTX, arg RemoveGroupMemberParams) (int64, error)
+	//RemoveGroupMembers
+	//
+	//  DELETE FROM group_members
+	//  WHERE group_id = (SELECT id FROM groups WHERE name = ?)
+	RemoveGroupMembers(ctx context.Context, db DBTX, name string) error
+	//ResealKey
+	//
+	//  UPDATE keys
+	//  SET mkvno = ?, key_bytes = ?
+	//  WHERE principal_id = ? AND kvno = ? AND enctype = ?
+	ResealKey(ctx context.Context, db DBTX, arg ResealKeyParams) error
+	//UpdatePrincipalFlags
+	//
+	//  UPDATE principals
+	//  SET flags = ?
+	//  WHERE primary_name = ? AND instance = ? AND realm = ?
+	UpdatePrincipalFlags(ctx context.Context, db DBTX, arg UpdatePrincipalFlagsParams) (int64, error)
+	//UpdatePrincipalLimits
+	//
+	//  UPDATE principals
+	//  SET max_life = ?, max_renewable_life = ?
+	//  WHERE primary_name = ? AND instance = ? AND realm = ?
+	UpdatePrincipalLimits(ctx context.Context, db DBTX, arg UpdatePrincipalLimitsParams) (int64, error)
 }
 
 var _ Querier = (*Queries)(nil)
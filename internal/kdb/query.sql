@@ -3,20 +3,153 @@ INSERT INTO principals (
     primary_name,
     instance,
     realm,
-    key_bytes,
-    kvno
+    kvno,
+    max_life,
+    max_renewable_life
 ) VALUES (
-    ?, ?, ?, ?, ?
+    ?, ?, ?, ?, ?, ?
 )
 RETURNING *;
 
 -- name: GetPrincipal :one
-SELECT key_bytes, kvno
+SELECT kvno, max_life, max_renewable_life, flags
 FROM principals
 WHERE primary_name = ? AND instance = ? AND realm = ?
 LIMIT 1;
 
+-- name: AddKey :exec
+INSERT INTO keys (
+    principal_id,
+    kvno,
+    enctype,
+    salt,
+    s2kparams,
+    mkvno,
+    key_bytes
+) VALUES (
+    ?, ?, ?, ?, ?, ?, ?
+);
+
+-- name: ListKeys :many
+SELECT keys.kvno, keys.enctype, keys.salt, keys.s2kparams, keys.mkvno, keys.key_bytes
+FROM keys
+JOIN principals ON principals.id = keys.principal_id
+WHERE principals.primary_name = ? AND principals.instance = ? AND principals.realm = ?
+ORDER BY keys.kvno DESC, keys.enctype;
+
+-- name: ListKeysToReseal :many
+SELECT principal_id, kvno, enctype, mkvno, key_bytes
+FROM keys
+WHERE mkvno != ?
+ORDER BY principal_id, kvno, enctype
+LIMIT ?;
+
+-- name: ResealKey :exec
+UPDATE keys
+SET mkvno = ?, key_bytes = ?
+WHERE principal_id = ? AND kvno = ? AND enctype = ?;
+
+-- name: AddMasterKey :exec
+INSERT INTO master_keys (
+    mkvno,
+    enctype,
+    salt,
+    s2kparams,
+    verifier
+) VALUES (
+    ?, ?, ?, ?, ?
+);
+
+-- name: ListMasterKeys :many
+SELECT mkvno, enctype, salt, s2kparams, verifier
+FROM master_keys
+ORDER BY mkvno;
+
+-- name: DeleteMasterKeysExcept :execrows
+DELETE FROM master_keys
+WHERE mkvno != ?;
+
+-- name: UpdatePrincipalFlags :execrows
+UPDATE principals
+SET flags = ?
+WHERE primary_name = ? AND instance = ? AND realm = ?;
+
+-- name: UpdatePrincipalLimits :execrows
+UPDATE principals
+SET max_life = ?, max_renewable_life = ?
+WHERE primary_name = ? AND instance = ? AND realm = ?;
+
 -- name: ListPrincipals :many
 SELECT primary_name, instance, realm
 FROM principals
 ORDER BY primary_name, instance;
+
+-- name: AddDelegation :exec
+INSERT INTO delegations (
+    service_primary,
+    service_instance,
+    service_realm,
+    target_primary,
+    target_instance,
+    target_realm
+) VALUES (
+    ?, ?, ?, ?, ?, ?
+);
+
+-- name: RemoveDelegation :execrows
+DELETE FROM delegations
+WHERE service_primary = ? AND service_instance = ? AND service_realm = ?
+  AND target_primary = ? AND target_instance = ? AND target_realm = ?;
+
+-- name: ListDelegations :many
+SELECT target_primary, target_instance, target_realm
+FROM delegations
+WHERE service_primary = ? AND service_instance = ? AND service_realm = ?
+ORDER BY target_primary, target_instance, target_realm;
+
+-- name: CreateGroup :exec
+INSERT INTO groups (name) VALUES (?);
+
+-- name: DeleteGroup :execrows
+DELETE FROM groups
+WHERE name = ?;
+
+-- name: ListGroups :many
+SELECT name
+FROM groups
+ORDER BY name;
+
+-- name: AddGroupMember :execrows
+INSERT INTO group_members (group_id, principal_id)
+SELECT groups.id, principals.id
+FROM groups, principals
+WHERE groups.name = ?
+  AND principals.primary_name = ? AND principals.instance = ? AND principals.realm = ?;
+
+-- name: RemoveGroupMember :execrows
+DELETE FROM group_members
+WHERE group_id = (SELECT id FROM groups WHERE name = ?)
+  AND principal_id = (
+    SELECT id FROM principals
+    WHERE primary_name = ? AND instance = ? AND realm = ?
+  );
+
+-- name: RemoveGroupMembers :exec
+DELETE FROM group_members
+WHERE group_id = (SELECT id FROM groups WHERE name = ?);
+
+-- name: ListGroupMembers :many
+SELECT principals.primary_name, principals.instance, principals.realm
+FROM group_members
+JOIN groups ON groups.id = group_members.group_id
+JOIN principals ON principals.id = group_members.principal_id
+WHERE groups.name = ?
+ORDER BY principals.primary_name, principals.instance, principals.realm;
+
+-- name: ListPrincipalGroups :many
+SELECT groups.name
+FROM group_members
+JOIN groups ON groups.id = group_members.group_id
+JOIN principals ON principals.id = group_members.principal_id
+WHERE principals.primary_name = ? AND principals.instance = ? AND principals.realm = ?
+ORDER BY groups.name;
@@ -7,27 +7,215 @@ package kdb
 
 import (
 	"context"
+	"database/sql"
 )
 
+const addDelegation = `-- name: AddDelegation :exec
+INSERT INTO delegations (
+    service_primary,
+    service_instance,
+    service_realm,
+    target_primary,
+    target_instance,
+    target_realm
+) VALUES (
+    ?, ?, ?, ?, ?, ?
+)
+`
+
+type AddDelegationParams struct {
+	ServicePrimary  string `db:"service_primary"`
+	ServiceInstance string `db:"service_instance"`
+	ServiceRealm    string `db:"service_realm"`
+	TargetPrimary   string `db:"target_primary"`
+	TargetInstance  string `db:"target_instance"`
+	TargetRealm     string `db:"target_realm"`
+}
+
+// AddDelegation
+//
+//	INSERT INTO delegations (
+//	    service_primary,
+//	    service_instance,
+//	    service_realm,
+//	    target_primary,
+//	    target_instance,
+//	    target_realm
+//	) VALUES (
+//	    ?, ?, ?, ?, ?, ?
+//	)
+func (q *Queries) AddDelegation(ctx context.Context, db DBTX, arg AddDelegationParams) error {
+	_, err := db.ExecContext(ctx, addDelegation,
+		arg.ServicePrimary,
+		arg.ServiceInstance,
+		arg.ServiceRealm,
+		arg.TargetPrimary,
+		arg.TargetInstance,
+		arg.TargetRealm,
+	)
+	return err
+}
+
+const addGroupMember = `-- name: AddGroupMember :execrows
+INSERT INTO group_members (group_id, principal_id)
+SELECT groups.id, principals.id
+FROM groups, principals
+WHERE groups.name = ?
+  AND principals.primary_name = ? AND principals.instance = ? AND principals.realm = ?
+`
+
+type AddGroupMemberParams struct {
+	Name        string `db:"name"`
+	PrimaryName string `db:"primary_name"`
+	Instance    string `db:"instance"`
+	Realm       string `db:"realm"`
+}
+
+// AddGroupMember
+//
+//	INSERT INTO group_members (group_id, principal_id)
+//	SELECT groups.id, principals.id
+//	FROM groups, principals
+//	WHERE groups.name = ?
+//	  AND principals.primary_name = ? AND principals.instance = ? AND principals.realm = ?
+func (q *Queries) AddGroupMember(ctx context.Context, db DBTX, arg AddGroupMemberParams) (int64, error) {
+	result, err := db.ExecContext(ctx, addGroupMember,
+		arg.Name,
+		arg.PrimaryName,
+		arg.Instance,
+		arg.Realm,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const addKey = `-- name: AddKey :exec
+INSERT INTO keys (
+    principal_id,
+    kvno,
+    enctype,
+    salt,
+    s2kparams,
+    mkvno,
+    key_bytes
+) VALUES (
+    ?, ?, ?, ?, ?, ?, ?
+)
+`
+
+type AddKeyParams struct {
+	PrincipalID int64  `db:"principal_id"`
+	Kvno        int64  `db:"kvno"`
+	Enctype     int64  `db:"enctype"`
+	Salt        string `db:"salt"`
+	S2kparams   []byte `db:"s2kparams"`
+	Mkvno       int64  `db:"mkvno"`
+	KeyBytes    []byte `db:"key_bytes"`
+}
+
+// AddKey
+//
+//	INSERT INTO keys (
+//	    principal_id,
+//	    kvno,
+//	    enctype,
+//	    salt,
+//	    s2kparams,
+//	    mkvno,
+//	    key_bytes
+//	) VALUES (
+//	    ?, ?, ?, ?, ?, ?, ?
+//	)
+func (q *Queries) AddKey(ctx context.Context, db DBTX, arg AddKeyParams) error {
+	_, err := db.ExecContext(ctx, addKey,
+		arg.PrincipalID,
+		arg.Kvno,
+		arg.Enctype,
+		arg.Salt,
+		arg.S2kparams,
+		arg.Mkvno,
+		arg.KeyBytes,
+	)
+	return err
+}
+
+const addMasterKey = `-- name: AddMasterKey :exec
+INSERT INTO master_keys (
+    mkvno,
+    enctype,
+    salt,
+    s2kparams,
+    verifier
+) VALUES (
+    ?, ?, ?, ?, ?
+)
+`
+
+type AddMasterKeyParams struct {
+	Mkvno     int64  `db:"mkvno"`
+	Enctype   int64  `db:"enctype"`
+	Salt      string `db:"salt"`
+	S2kparams []byte `db:"s2kparams"`
+	Verifier  []byte `db:"verifier"`
+}
+
+// AddMasterKey
+//
+//	INSERT INTO master_keys (
+//	    mkvno,
+//	    enctype,
+//	    salt,
+//	    s2kparams,
+//	    verifier
+//	) VALUES (
+//	    ?, ?, ?, ?, ?
+//	)
+func (q *Queries) AddMasterKey(ctx context.Context, db DBTX, arg AddMasterKeyParams) error {
+	_, err := db.ExecContext(ctx, addMasterKey,
+		arg.Mkvno,
+		arg.Enctype,
+		arg.Salt,
+		arg.S2kparams,
+		arg.Verifier,
+	)
+	return err
+}
+
+const createGroup = `-- name: CreateGroup :exec
+INSERT INTO groups (name) VALUES (?)
+`
+
+// CreateGroup
+//
+//	INSERT INTO groups (name) VALUES (?)
+func (q *Queries) CreateGroup(ctx context.Context, db DBTX, name string) error {
+	_, err := db.ExecContext(ctx, createGroup, name)
+	return err
+}
+
 const createPrincipal = `-- name: CreatePrincipal :one
 INSERT INTO principals (
     primary_name,
     instance,
     realm,
-    key_bytes,
-    kvno
+    kvno,
+    max_life,
+    max_renewable_life
 ) VALUES (
-    ?, ?, ?, ?, ?
+    ?, ?, ?, ?, ?, ?
 )
-RETURNING id, primary_name, instance, realm, key_bytes, kvno, created_at
+RETURNING id, primary_name, instance, realm, kvno, max_life, max_renewable_life, flags, created_at
 `
 
 type CreatePrincipalParams struct {
-	PrimaryName string `db:"primary_name"`
-	Instance    string `db:"instance"`
-	Realm       string `db:"realm"`
-	KeyBytes    []byte `db:"key_bytes"`
-	Kvno        int64  `db:"kvno"`
+	PrimaryName      string        `db:"primary_name"`
+	Instance         string        `db:"instance"`
+	Realm            string        `db:"realm"`
+	Kvno             int64         `db:"kvno"`
+	MaxLife          sql.NullInt64 `db:"max_life"`
+	MaxRenewableLife sql.NullInt64 `db:"max_renewable_life"`
 }
 
 // CreatePrincipal
@@ -36,19 +224,21 @@ type CreatePrincipalParams struct {
 //	    primary_name,
 //	    instance,
 //	    realm,
-//	    key_bytes,
-//	    kvno
+//	    kvno,
+//	    max_life,
+//	    max_renewable_life
 //	) VALUES (
-//	    ?, ?, ?, ?, ?
+//	    ?, ?, ?, ?, ?, ?
 //	)
-//	RETURNING id, primary_name, instance, realm, key_bytes, kvno, created_at
+//	RETURNING id, primary_name, instance, realm, kvno, max_life, max_renewable_life, flags, created_at
 func (q *Queries) CreatePrincipal(ctx context.Context, db DBTX, arg CreatePrincipalParams) (Principal, error) {
 	row := db.QueryRowContext(ctx, createPrincipal,
 		arg.PrimaryName,
 		arg.Instance,
 		arg.Realm,
-		arg.KeyBytes,
 		arg.Kvno,
+		arg.MaxLife,
+		arg.MaxRenewableLife,
 	)
 	var i Principal
 	err := row.Scan(
@@ -56,15 +246,51 @@ func (q *Queries) CreatePrincipal(ctx context.Context, db DBTX, arg CreatePrinci
 		&i.PrimaryName,
 		&i.Instance,
 		&i.Realm,
-		&i.KeyBytes,
 		&i.Kvno,
+		&i.MaxLife,
+		&i.MaxRenewableLife,
+		&i.Flags,
 		&i.CreatedAt,
 	)
 	return i, err
 }
 
+const deleteGroup = `-- name: DeleteGroup :execrows
+DELETE FROM groups
+WHERE name = ?
+`
+
+// DeleteGroup
+//
+//	DELETE FROM groups
+//	WHERE name = ?
+func (q *Queries) DeleteGroup(ctx context.Context, db DBTX, name string) (int64, error) {
+	result, err := db.ExecContext(ctx, deleteGroup, name)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteMasterKeysExcept = `-- name: DeleteMasterKeysExcept :execrows
+DELETE FROM master_keys
+WHERE mkvno != ?
+`
+
+// DeleteMasterKeysExcept
+//
+//	DELETE FROM master_keys
+//	WHERE mkvno != ?
+func (q *Queries) DeleteMasterKeysExcept(ctx context.Context, db DBTX, mkvno int64) (int64, error) {
+	result, err := db.ExecContext(ctx, deleteMasterKeysExcept, mkvno)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const getPrincipal = `-- name: GetPrincipal :one
-SELECT key_bytes, kvno
+SELECT kvno, max_life, max_renewable_life, flags
 FROM principals
 WHERE primary_name = ? AND instance = ? AND realm = ?
 LIMIT 1
@@ -77,23 +303,369 @@ type GetPrincipalParams struct {
 }
 
 type GetPrincipalRow struct {
-	KeyBytes []byte `db:"key_bytes"`
-	Kvno     int64  `db:"kvno"`
+	Kvno             int64         `db:"kvno"`
+	MaxLife          sql.NullInt64 `db:"max_life"`
+	MaxRenewableLife sql.NullInt64 `db:"max_renewable_life"`
+	Flags            int64         `db:"flags"`
 }
 
 // GetPrincipal
 //
-//	SELECT key_bytes, kvno
+//	SELECT kvno, max_life, max_renewable_life, flags
 //	FROM principals
 //	WHERE primary_name = ? AND instance = ? AND realm = ?
 //	LIMIT 1
 func (q *Queries) GetPrincipal(ctx context.Context, db DBTX, arg GetPrincipalParams) (GetPrincipalRow, error) {
 	row := db.QueryRowContext(ctx, getPrincipal, arg.PrimaryName, arg.Instance, arg.Realm)
 	var i GetPrincipalRow
-	err := row.Scan(&i.KeyBytes, &i.Kvno)
+	err := row.Scan(
+		&i.Kvno,
+		&i.MaxLife,
+		&i.MaxRenewableLife,
+		&i.Flags,
+	)
 	return i, err
 }
 
+const listDelegations = `-- name: ListDelegations :many
+SELECT target_primary, target_instance, target_realm
+FROM delegations
+WHERE service_primary = ? AND service_instance = ? AND service_realm = ?
+ORDER BY target_primary, target_instance, target_realm
+`
+
+type ListDelegationsParams struct {
+	ServicePrimary  string `db:"service_primary"`
+	ServiceInstance string `db:"service_instance"`
+	ServiceRealm    string `db:"service_realm"`
+}
+
+type ListDelegationsRow struct {
+	TargetPrimary  string `db:"target_primary"`
+	TargetInstance string `db:"target_instance"`
+	TargetRealm    string `db:"target_realm"`
+}
+
+// ListDelegations
+//
+//	SELECT target_primary, target_instance, target_realm
+//	FROM delegations
+//	WHERE service_primary = ? AND service_instance = ? AND service_realm = ?
+//	ORDER BY target_primary, target_instance, target_realm
+func (q *Queries) ListDelegations(ctx context.Context, db DBTX, arg ListDelegationsParams) ([]ListDelegationsRow, error) {
+	rows, err := db.QueryContext(ctx, listDelegations, arg.ServicePrimary, arg.ServiceInstance, arg.ServiceRealm)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListDelegationsRow
+	for rows.Next() {
+		var i ListDelegationsRow
+		if err := rows.Scan(&i.TargetPrimary, &i.TargetInstance, &i.TargetRealm); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listGroupMembers = `-- name: ListGroupMembers :many
+SELECT principals.primary_name, principals.instance, principals.realm
+FROM group_members
+JOIN groups ON groups.id = group_members.group_id
+JOIN principals ON principals.id = group_members.principal_id
+WHERE groups.name = ?
+ORDER BY principals.primary_name, principals.instance, principals.realm
+`
+
+type ListGroupMembersRow struct {
+	PrimaryName string `db:"primary_name"`
+	Instance    string `db:"instance"`
+	Realm       string `db:"realm"`
+}
+
+// ListGroupMembers
+//
+//	SELECT principals.primary_name, principals.instance, principals.realm
+//	FROM group_members
+//	JOIN groups ON groups.id = group_members.group_id
+//	JOIN principals ON principals.id = group_members.principal_id
+//	WHERE groups.name = ?
+//	ORDER BY principals.primary_name, principals.instance, principals.realm
+func (q *Queries) ListGroupMembers(ctx context.Context, db DBTX, name string) ([]ListGroupMembersRow, error) {
+	rows, err := db.QueryContext(ctx, listGroupMembers, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListGroupMembersRow
+	for rows.Next() {
+		var i ListGroupMembersRow
+		if err := rows.Scan(&i.PrimaryName, &i.Instance, &i.Realm); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listGroups = `-- name: ListGroups :many
+SELECT name
+FROM groups
+ORDER BY name
+`
+
+// ListGroups
+//
+//	SELECT name
+//	FROM groups
+//	ORDER BY name
+func (q *Queries) ListGroups(ctx context.Context, db DBTX) ([]string, error) {
+	rows, err := db.QueryContext(ctx, listGroups)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		items = append(items, name)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listKeys = `-- name: ListKeys :many
+SELECT keys.kvno, keys.enctype, keys.salt, keys.s2kparams, keys.mkvno, keys.key_bytes
+FROM keys
+JOIN principals ON principals.id = keys.principal_id
+WHERE principals.primary_name = ? AND principals.instance = ? AND principals.realm = ?
+ORDER BY keys.kvno DESC, keys.enctype
+`
+
+type ListKeysParams struct {
+	PrimaryName string `db:"primary_name"`
+	Instance    string `db:"instance"`
+	Realm       string `db:"realm"`
+}
+
+type ListKeysRow struct {
+	Kvno      int64  `db:"kvno"`
+	Enctype   int64  `db:"enctype"`
+	Salt      string `db:"salt"`
+	S2kparams []byte `db:"s2kparams"`
+	Mkvno     int64  `db:"mkvno"`
+	KeyBytes  []byte `db:"key_bytes"`
+}
+
+// ListKeys
+//
+//	SELECT keys.kvno, keys.enctype, keys.salt, keys.s2kparams, keys.mkvno, keys.key_bytes
+//	FROM keys
+//	JOIN principals ON principals.id = keys.principal_id
+//	WHERE principals.primary_name = ? AND principals.instance = ? AND principals.realm = ?
+//	ORDER BY keys.kvno DESC, keys.enctype
+func (q *Queries) ListKeys(ctx context.Context, db DBTX, arg ListKeysParams) ([]ListKeysRow, error) {
+	rows, err := db.QueryContext(ctx, listKeys, arg.PrimaryName, arg.Instance, arg.Realm)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListKeysRow
+	for rows.Next() {
+		var i ListKeysRow
+		if err := rows.Scan(
+			&i.Kvno,
+			&i.Enctype,
+			&i.Salt,
+			&i.S2kparams,
+			&i.Mkvno,
+			&i.KeyBytes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listKeysToReseal = `-- name: ListKeysToReseal :many
+SELECT principal_id, kvno, enctype, mkvno, key_bytes
+FROM keys
+WHERE mkvno != ?
+ORDER BY principal_id, kvno, enctype
+LIMIT ?
+`
+
+type ListKeysToResealParams struct {
+	Mkvno int64 `db:"mkvno"`
+	Limit int64 `db:"limit"`
+}
+
+type ListKeysToResealRow struct {
+	PrincipalID int64  `db:"principal_id"`
+	Kvno        int64  `db:"kvno"`
+	Enctype     int64  `db:"enctype"`
+	Mkvno       int64  `db:"mkvno"`
+	KeyBytes    []byte `db:"key_bytes"`
+}
+
+// ListKeysToReseal
+//
+//	SELECT principal_id, kvno, enctype, mkvno, key_bytes
+//	FROM keys
+//	WHERE mkvno != ?
+//	ORDER BY principal_id, kvno, enctype
+//	LIMIT ?
+func (q *Queries) ListKeysToReseal(ctx context.Context, db DBTX, arg ListKeysToResealParams) ([]ListKeysToResealRow, error) {
+	rows, err := db.QueryContext(ctx, listKeysToReseal, arg.Mkvno, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListKeysToResealRow
+	for rows.Next() {
+		var i ListKeysToResealRow
+		if err := rows.Scan(
+			&i.PrincipalID,
+			&i.Kvno,
+			&i.Enctype,
+			&i.Mkvno,
+			&i.KeyBytes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMasterKeys = `-- name: ListMasterKeys :many
+SELECT mkvno, enctype, salt, s2kparams, verifier
+FROM master_keys
+ORDER BY mkvno
+`
+
+type ListMasterKeysRow struct {
+	Mkvno     int64  `db:"mkvno"`
+	Enctype   int64  `db:"enctype"`
+	Salt      string `db:"salt"`
+	S2kparams []byte `db:"s2kparams"`
+	Verifier  []byte `db:"verifier"`
+}
+
+// ListMasterKeys
+//
+//	SELECT mkvno, enctype, salt, s2kparams, verifier
+//	FROM master_keys
+//	ORDER BY mkvno
+func (q *Queries) ListMasterKeys(ctx context.Context, db DBTX) ([]ListMasterKeysRow, error) {
+	rows, err := db.QueryContext(ctx, listMasterKeys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListMasterKeysRow
+	for rows.Next() {
+		var i ListMasterKeysRow
+		if err := rows.Scan(
+			&i.Mkvno,
+			&i.Enctype,
+			&i.Salt,
+			&i.S2kparams,
+			&i.Verifier,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPrincipalGroups = `-- name: ListPrincipalGroups :many
+SELECT groups.name
+FROM group_members
+JOIN groups ON groups.id = group_members.group_id
+JOIN principals ON principals.id = group_members.principal_id
+WHERE principals.primary_name = ? AND principals.instance = ? AND principals.realm = ?
+ORDER BY groups.name
+`
+
+type ListPrincipalGroupsParams struct {
+	PrimaryName string `db:"primary_name"`
+	Instance    string `db:"instance"`
+	Realm       string `db:"realm"`
+}
+
+// ListPrincipalGroups
+//
+//	SELECT groups.name
+//	FROM group_members
+//	JOIN groups ON groups.id = group_members.group_id
+//	JOIN principals ON principals.id = group_members.principal_id
+//	WHERE principals.primary_name = ? AND principals.instance = ? AND principals.realm = ?
+//	ORDER BY groups.name
+func (q *Queries) ListPrincipalGroups(ctx context.Context, db DBTX, arg ListPrincipalGroupsParams) ([]string, error) {
+	rows, err := db.QueryContext(ctx, listPrincipalGroups, arg.PrimaryName, arg.Instance, arg.Realm)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		items = append(items, name)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listPrincipals = `-- name: ListPrincipals :many
 SELECT primary_name, instance, realm
 FROM principals
@@ -133,3 +705,183 @@ func (q *Queries) ListPrincipals(ctx context.Context, db DBTX) ([]ListPrincipals
 	}
 	return items, nil
 }
+
+const removeDelegation = `-- name: RemoveDelegation :execrows
+DELETE FROM delegations
+WHERE service_primary = ? AND service_instance = ? AND service_realm = ?
+  AND target_primary = ? AND target_instance = ? AND target_realm = ?
+`
+
+type RemoveDelegationParams struct {
+	ServicePrimary  string `db:"service_primary"`
+	ServiceInstance string `db:"service_instance"`
+	ServiceRealm    string `db:"service_realm"`
+	TargetPrimary   string `db:"target_primary"`
+	TargetInstance  string `db:"target_instance"`
+	TargetRealm     string `db:"target_realm"`
+}
+
+// RemoveDelegation
+//
+//	DELETE FROM delegations
+//	WHERE service_primary = ? AND service_instance = ? AND service_realm = ?
+//	  AND target_primary = ? AND target_instance = ? AND target_realm = ?
+func (q *Queries) RemoveDelegation(ctx context.Context, db DBTX, arg RemoveDelegationParams) (int64, error) {
+	result, err := db.ExecContext(ctx, removeDelegation,
+		arg.ServicePrimary,
+		arg.ServiceInstance,
+		arg.ServiceRealm,
+		arg.TargetPrimary,
+		arg.TargetInstance,
+		arg.TargetRealm,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const removeGroupMember = `-- name: RemoveGroupMember :execrows
+DELETE FROM group_members
+WHERE group_id = (SELECT id FROM groups WHERE name = ?)
+  AND principal_id = (
+    SELECT id FROM principals
+    WHERE primary_name = ? AND instance = ? AND realm = ?
+  )
+`
+
+type RemoveGroupMemberParams struct {
+	Name        string `db:"name"`
+	PrimaryName string `db:"primary_name"`
+	Instance    string `db:"instance"`
+	Realm       string `db:"realm"`
+}
+
+// RemoveGroupMember
+//
+//	DELETE FROM group_members
+//	WHERE group_id = (SELECT id FROM groups WHERE name = ?)
+//	  AND principal_id = (
+//	    SELECT id FROM principals
+//	    WHERE primary_name = ? AND instance = ? AND realm = ?
+//	  )
+func (q *Queries) RemoveGroupMember(ctx context.Context, db DBTX, arg RemoveGroupMemberParams) (int64, error) {
+	result, err := db.ExecContext(ctx, removeGroupMember,
+		arg.Name,
+		arg.PrimaryName,
+		arg.Instance,
+		arg.Realm,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const removeGroupMembers = `-- name: RemoveGroupMembers :exec
+DELETE FROM group_members
+WHERE group_id = (SELECT id FROM groups WHERE name = ?)
+`
+
+// RemoveGroupMembers
+//
+//	DELETE FROM group_members
+//	WHERE group_id = (SELECT id FROM groups WHERE name = ?)
+func (q *Queries) RemoveGroupMembers(ctx context.Context, db DBTX, name string) error {
+	_, err := db.ExecContext(ctx, removeGroupMembers, name)
+	return err
+}
+
+const resealKey = `-- name: ResealKey :exec
+UPDATE keys
+SET mkvno = ?, key_bytes = ?
+WHERE principal_id = ? AND kvno = ? AND enctype = ?
+`
+
+type ResealKeyParams struct {
+	Mkvno       int64  `db:"mkvno"`
+	KeyBytes    []byte `db:"key_bytes"`
+	PrincipalID int64  `db:"principal_id"`
+	Kvno        int64  `db:"kvno"`
+	Enctype     int64  `db:"enctype"`
+}
+
+// ResealKey
+//
+//	UPDATE keys
+//	SET mkvno = ?, key_bytes = ?
+//	WHERE principal_id = ? AND kvno = ? AND enctype = ?
+func (q *Queries) ResealKey(ctx context.Context, db DBTX, arg ResealKeyParams) error {
+	_, err := db.ExecContext(ctx, resealKey,
+		arg.Mkvno,
+		arg.KeyBytes,
+		arg.PrincipalID,
+		arg.Kvno,
+		arg.Enctype,
+	)
+	return err
+}
+
+const updatePrincipalFlags = `-- name: UpdatePrincipalFlags :execrows
+UPDATE principals
+SET flags = ?
+WHERE primary_name = ? AND instance = ? AND realm = ?
+`
+
+type UpdatePrincipalFlagsParams struct {
+	Flags       int64  `db:"flags"`
+	PrimaryName string `db:"primary_name"`
+	Instance    string `db:"instance"`
+	Realm       string `db:"realm"`
+}
+
+// UpdatePrincipalFlags
+//
+//	UPDATE principals
+//	SET flags = ?
+//	WHERE primary_name = ? AND instance = ? AND realm = ?
+func (q *Queries) UpdatePrincipalFlags(ctx context.Context, db DBTX, arg UpdatePrincipalFlagsParams) (int64, error) {
+	result, err := db.ExecContext(ctx, updatePrincipalFlags,
+		arg.Flags,
+		arg.PrimaryName,
+		arg.Instance,
+		arg.Realm,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updatePrincipalLimits = `-- name: UpdatePrincipalLimits :execrows
+UPDATE principals
+SET max_life = ?, max_renewable_life = ?
+WHERE primary_name = ? AND instance = ? AND realm = ?
+`
+
+type UpdatePrincipalLimitsParams struct {
+	MaxLife          sql.NullInt64 `db:"max_life"`
+	MaxRenewableLife sql.NullInt64 `db:"max_renewable_life"`
+	PrimaryName      string        `db:"primary_name"`
+	Instance         string        `db:"instance"`
+	Realm            string        `db:"realm"`
+}
+
+// UpdatePrincipalLimits
+//
+//	UPDATE principals
+//	SET max_life = ?, max_renewable_life = ?
+//	WHERE primary_name = ? AND instance = ? AND realm = ?
+func (q *Queries) UpdatePrincipalLimits(ctx context.Context, db DBTX, arg UpdatePrincipalLimitsParams) (int64, error) {
+	result, err := db.ExecContext(ctx, updatePrincipalLimits,
+		arg.MaxLife,
+		arg.MaxRenewableLife,
+		arg.PrimaryName,
+		arg.Instance,
+		arg.Realm,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -1,13 +1,79 @@
 CREATE TABLE principals (
-    id            INTEGER             PRIMARY KEY AUTOINCREMENT,
-    primary_name  TEXT      NOT NULL  CHECK(length(primary_name) > 0),
-    instance      TEXT      NOT NULL,
-    realm         TEXT      NOT NULL  CHECK(length(realm) > 0),
-    key_bytes     BLOB      NOT NULL  CHECK(length(key_bytes) > 0),
-    kvno          INTEGER   NOT NULL  DEFAULT 1,
-    created_at    DATETIME            DEFAULT CURRENT_TIMESTAMP,
+    id                  INTEGER             PRIMARY KEY AUTOINCREMENT,
+    primary_name        TEXT      NOT NULL  CHECK(length(primary_name) > 0),
+    instance            TEXT      NOT NULL,
+    realm               TEXT      NOT NULL  CHECK(length(realm) > 0),
+    -- Current key version; older versions may still be in keys.
+    kvno                INTEGER   NOT NULL  DEFAULT 1,
+    -- Ticket limits in seconds; NULL leaves the realm default.
+    max_life            INTEGER             CHECK(max_life > 0),
+    max_renewable_life  INTEGER             CHECK(max_renewable_life > 0),
+    -- Policy flags, a bit set of PrincipalFlag.
+    flags               INTEGER   NOT NULL  DEFAULT 0,
+    created_at          DATETIME            DEFAULT CURRENT_TIMESTAMP,
 
     UNIQUE(primary_name, instance, realm)
 );
 
 CREATE INDEX idx_principals_lookup ON principals(primary_name, instance, realm);
+
+-- Versions of the realm master key, which seals every key in keys. Each
+-- holds a known plaintext sealed under it, so that a wrong master key is
+-- refused; one derived from a passphrase also keeps its salt and
+-- string-to-key parameters. There is more than one version only while the
+-- master key is being changed.
+CREATE TABLE master_keys (
+    mkvno       INTEGER             PRIMARY KEY,
+    enctype     INTEGER   NOT NULL,
+    salt        TEXT      NOT NULL  DEFAULT '',
+    s2kparams   BLOB,
+    verifier    BLOB      NOT NULL  CHECK(length(verifier) > 0),
+    created_at  DATETIME            DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Long-term keys of a principal, one per version and encryption type.
+CREATE TABLE keys (
+    principal_id  INTEGER   NOT NULL  REFERENCES principals(id),
+    kvno          INTEGER   NOT NULL,
+    enctype       INTEGER   NOT NULL,
+    salt          TEXT      NOT NULL  DEFAULT '',
+    -- String-to-key parameters of the encryption type; NULL for its default.
+    s2kparams     BLOB,
+    -- The key is sealed under this version of the master key.
+    mkvno         INTEGER   NOT NULL  REFERENCES master_keys(mkvno),
+    key_bytes     BLOB      NOT NULL  CHECK(length(key_bytes) > 0),
+    created_at    DATETIME            DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY(principal_id, kvno, enctype)
+);
+
+CREATE TABLE delegations (
+    id                INTEGER             PRIMARY KEY AUTOINCREMENT,
+    service_primary   TEXT      NOT NULL  CHECK(length(service_primary) > 0),
+    service_instance  TEXT      NOT NULL,
+    service_realm     TEXT      NOT NULL  CHECK(length(service_realm) > 0),
+    target_primary    TEXT      NOT NULL  CHECK(length(target_primary) > 0),
+    target_instance   TEXT      NOT NULL,
+    target_realm      TEXT      NOT NULL  CHECK(length(target_realm) > 0),
+    created_at        DATETIME            DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE(service_primary, service_instance, service_realm, target_primary, target_instance, target_realm)
+);
+
+CREATE INDEX idx_delegations_service ON delegations(service_primary, service_instance, service_realm);
+
+CREATE TABLE groups (
+    id          INTEGER             PRIMARY KEY AUTOINCREMENT,
+    name        TEXT      NOT NULL  UNIQUE CHECK(length(name) > 0),
+    created_at  DATETIME            DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE group_members (
+    group_id      INTEGER   NOT NULL  REFERENCES groups(id),
+    principal_id  INTEGER   NOT NULL  REFERENCES principals(id),
+    created_at    DATETIME            DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY(group_id, principal_id)
+);
+
+CREATE INDEX idx_group_members_principal ON group_members(principal_id);
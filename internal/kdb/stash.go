@@ -0,0 +1,154 @@
+package kdb
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+var ErrStashPermissions = errors.New("stash file is readable by others than its owner")
+
+// ReadStash reads the master keys kept in a stash file, one version per
+// line as "mkvno enctype hexkey". A stash anyone but its owner can read is
+// refused, since it unlocks every key in the database.
+func ReadStash(path string) (MasterKeys, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return MasterKeys{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return MasterKeys{}, err
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return MasterKeys{}, fmt.Errorf("%w: %s is %s, want 0600", ErrStashPermissions, path, info.Mode().Perm())
+	}
+
+	var keys MasterKeys
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		mkvno, key, err := parseStashLine(line)
+		if err != nil {
+			return MasterKeys{}, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		keys = keys.With(mkvno, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return MasterKeys{}, err
+	}
+
+	if keys.IsZero() {
+		return MasterKeys{}, fmt.Errorf("%s holds no master key", path)
+	}
+	return keys, nil
+}
+
+func parseStashLine(line string) (int64, protocol.SessionKey, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return 0, protocol.SessionKey{}, errors.New("want mkvno, enctype and hex key")
+	}
+
+	mkvno, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || mkvno < 1 {
+		return 0, protocol.SessionKey{}, fmt.Errorf("invalid mkvno %q", fields[0])
+	}
+	etype, err := strconv.ParseInt(fields[1], 10, 32)
+	if err != nil {
+		return 0, protocol.SessionKey{}, fmt.Errorf("invalid enctype %q", fields[1])
+	}
+	kb, err := hex.DecodeString(fields[2])
+	if err != nil {
+		return 0, protocol.SessionKey{}, fmt.Errorf("invalid key: %w", err)
+	}
+
+	key, err := protocol.NewSessionKey(kb)
+	if err != nil {
+		return 0, protocol.SessionKey{}, err
+	}
+	return mkvno, key.WithEncType(protocol.EncType(etype)), nil
+}
+
+// WriteStash writes keys to a stash file readable only by its owner. The
+// file is replaced whole, through a temporary file renamed over it, so that
+// a crash leaves either the old stash or the new one.
+func WriteStash(path string, keys MasterKeys) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := tmp.Chmod(0o600); err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	for _, mkvno := range keys.Versions() {
+		key, _ := keys.Key(mkvno)
+		fmt.Fprintf(w, "%d %d %s\n", mkvno, key.EncType(), hex.EncodeToString(key.Expose()))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// UnlockFrom checks the schema of d and unlocks it with the master keys
+// derived from passphrase or, without one, read from the stash file at
+// stash.
+func (d *database) UnlockFrom(ctx context.Context, stash, passphrase string) error {
+	if err := d.CheckSchema(ctx); err != nil {
+		return err
+	}
+
+	if passphrase != "" {
+		keys, err := PassphraseMasterKeys(ctx, d, passphrase)
+		if err != nil {
+			return fmt.Errorf("failed to load master key: %w", err)
+		}
+		return d.Unlock(ctx, keys)
+	}
+
+	// The stash is read again for a key sealed under a version it did not
+	// hold yet, so that a running KDC follows a change of the master key.
+	var reload func() (MasterKeys, error)
+	reload = func() (MasterKeys, error) {
+		keys, err := ReadStash(stash)
+		if err != nil {
+			return MasterKeys{}, err
+		}
+		if err := d.unlock(context.Background(), keys, reload); err != nil {
+			return MasterKeys{}, err
+		}
+		return d.MasterKeys(), nil
+	}
+
+	keys, err := ReadStash(stash)
+	if err != nil {
+		return fmt.Errorf("failed to load master key: %w", err)
+	}
+	return d.unlock(ctx, keys, reload)
+}
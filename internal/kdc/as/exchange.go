@@ -6,105 +6,210 @@ import (
 	"time"
 
 	"github.com/rizesql/kerberos/internal/clock"
+	"github.com/rizesql/kerberos/internal/codec"
 	"github.com/rizesql/kerberos/internal/crypto"
 	"github.com/rizesql/kerberos/internal/kdb"
 	"github.com/rizesql/kerberos/internal/kdc"
 	"github.com/rizesql/kerberos/internal/kdc/shared"
 	"github.com/rizesql/kerberos/internal/o11y/logging"
 	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/rizesql/kerberos/internal/replay"
 )
 
 type Exchange struct {
-	db     kdb.Database
-	logger *logging.Logger
-	clock  clock.Clock
-	keygen crypto.KeyGenerator
-	cfg    kdc.Config
+	db          kdb.Database
+	logger      *logging.Logger
+	clock       clock.Clock
+	keygen      crypto.KeyGenerator
+	replayCache replay.Cache
+	cfg         kdc.Config
+	maxSkew     time.Duration
 }
 
 func NewExchange(platform *kdc.Platform, cfg kdc.Config) *Exchange {
 	return &Exchange{
-		db:     platform.Database,
-		logger: platform.Logger,
-		clock:  platform.Clock,
-		keygen: platform.KeyGenerator,
-		cfg:    cfg,
+		db:          platform.Database,
+		logger:      platform.Logger,
+		clock:       platform.Clock,
+		keygen:      platform.KeyGenerator,
+		replayCache: platform.ReplayCache,
+		cfg:         cfg,
+		maxSkew:     5 * time.Minute,
 	}
 }
 
 func (e *Exchange) Handle(ctx context.Context, req protocol.ASReq) (protocol.ASRep, error) {
+	if pa, ok := req.PAData().Find(protocol.PATypeFXFast); ok {
+		return e.handleFAST(ctx, req, pa)
+	}
+	return e.exchange(ctx, req, nil)
+}
+
+// exchange issues the ticket req asks for. armor is the armor of the FAST
+// request req arrived in, or nil if it came in the clear.
+func (e *Exchange) exchange(ctx context.Context, req protocol.ASReq, armor *shared.Armor) (protocol.ASRep, error) {
 	if req.Client().Realm() != e.cfg.Realm {
 		return protocol.ASRep{}, fmt.Errorf("%w: client realm %s != kdc realm %s",
 			shared.ErrWrongRealm, req.Client().Realm(), e.cfg.Realm)
 	}
 
 	now := e.clock.Now().UTC()
+	c := codec.FromContext(ctx)
+
+	client, err := shared.FetchPrincipal(ctx, e.db, e.logger, req.Client())
+	if err != nil {
+		return protocol.ASRep{}, fmt.Errorf("%w: %w", protocol.KDCErrCPrincipalUnknown, err)
+	}
+
+	if armor == nil && client.Flags.Has(kdb.FlagRequiresFAST) {
+		return protocol.ASRep{}, fmt.Errorf("%w: %s must use FAST", protocol.KDCErrPolicy, req.Client())
+	}
+
+	replyKey, replyPAData, err := e.preauthenticate(c, req, client)
+	if err != nil {
+		return protocol.ASRep{}, err
+	}
+
+	service, err := shared.FetchPrincipal(ctx, e.db, e.logger, req.Service())
+	if err != nil {
+		return protocol.ASRep{}, fmt.Errorf("%w: %w", protocol.KDCErrSPrincipalUnknown, err)
+	}
+
+	serviceKey, err := service.Key()
+	if err != nil {
+		return protocol.ASRep{}, err
+	}
+
+	limits := shared.NewLimits(e.cfg.TicketLifetime, e.cfg.MaxRenewableLife, client, service)
+	issue, err := e.issuance(req, now, limits)
+	if err != nil {
+		return protocol.ASRep{}, err
+	}
 
-	clientKey, err := shared.FetchPrincipalKey(ctx, e.db, e.logger, req.Client())
+	etype, err := shared.SessionEncType(req.ETypes(), service)
 	if err != nil {
 		return protocol.ASRep{}, err
 	}
 
-	serviceKey, err := shared.FetchPrincipalKey(ctx, e.db, e.logger, req.Service())
+	sessionKey, err := e.keygen.Generate(etype)
 	if err != nil {
 		return protocol.ASRep{}, err
 	}
 
-	sessionKey, err := e.keygen.Generate(32)
+	authz, err := e.authorizationData(ctx, req, now, serviceKey.Key)
 	if err != nil {
 		return protocol.ASRep{}, err
 	}
 
-	encTicket, err := e.encryptTicket(req, now, sessionKey, serviceKey)
+	encTicket, err := e.encryptTicket(c, req, now, issue, sessionKey, serviceKey, authz)
 	if err != nil {
 		return protocol.ASRep{}, err
 	}
 
-	encRepPart, err := e.encryptRepPart(req, now, sessionKey, clientKey)
+	// Under FAST the reply key is strengthened with a key of the KDC's, so
+	// that the reply is no weaker than the armor.
+	var strengthenKey protocol.SessionKey
+	if armor != nil {
+		strengthenKey, err = e.keygen.Generate(replyKey.Key.EncType())
+		if err != nil {
+			return protocol.ASRep{}, err
+		}
+
+		replyKey.Key, err = shared.StrengthenReplyKey(strengthenKey, replyKey.Key)
+		if err != nil {
+			return protocol.ASRep{}, err
+		}
+	}
+
+	encRepPart, err := e.encryptRepPart(c, req, now, issue, sessionKey, replyKey)
 	if err != nil {
 		return protocol.ASRep{}, err
 	}
 
-	return protocol.NewASRep(encTicket, encRepPart)
+	rep, err := protocol.NewASRep(encTicket, encRepPart)
+	if err != nil {
+		return protocol.ASRep{}, err
+	}
+
+	rep = rep.WithClient(req.Client())
+	if armor == nil {
+		return rep.WithPAData(replyPAData), nil
+	}
+	return shared.ArmorReply(c, *armor, rep, protocol.MethodData{replyPAData}, strengthenKey, now)
+}
+
+// authorizationData issues the client's claims for the ticket, signed for
+// the requested service.
+func (e *Exchange) authorizationData(
+	ctx context.Context,
+	req protocol.ASReq,
+	now time.Time,
+	serviceKey protocol.SessionKey,
+) (protocol.AuthorizationData, error) {
+	claims, err := shared.FetchClaims(ctx, e.db, req.Client(), now)
+	if err != nil {
+		return protocol.AuthorizationData{}, fmt.Errorf("%w: %w", protocol.KRBErrGeneric, err)
+	}
+
+	kdcKey, err := shared.KDCKey(ctx, e.db, e.logger, e.cfg.Realm, req.Service(), serviceKey)
+	if err != nil {
+		return protocol.AuthorizationData{}, fmt.Errorf("%w: failed to fetch KDC key: %w", protocol.KRBErrGeneric, err)
+	}
+
+	return shared.SignClaims(req.Client(), claims, serviceKey, kdcKey)
 }
 
 func (e *Exchange) encryptTicket(
+	c codec.Codec,
 	req protocol.ASReq,
 	now time.Time,
+	issue issuance,
 	sessionKey protocol.SessionKey,
-	serviceKey protocol.SessionKey,
+	serviceKey shared.PrincipalKey,
+	authz protocol.AuthorizationData,
 ) (protocol.EncryptedData, error) {
 	ticket, err := protocol.NewTicket(
 		req.Service(),
 		req.Client(),
 		req.ClientAddr(),
 		now,
-		e.cfg.TicketLifetime,
+		issue.lifetime,
 		sessionKey,
 	)
 	if err != nil {
 		return protocol.EncryptedData{}, err
 	}
 
-	return shared.EncryptEntity(serviceKey, ticket)
+	ticket = ticket.
+		WithFlags(issue.flags).
+		WithStartTime(issue.startTime).
+		WithRenewTill(issue.renewTill).
+		WithAuthorizationData(authz)
+	return shared.EncryptTicket(c, serviceKey, ticket)
 }
 
 func (e *Exchange) encryptRepPart(
+	c codec.Codec,
 	req protocol.ASReq,
 	now time.Time,
+	issue issuance,
 	sessionKey protocol.SessionKey,
-	clientKey protocol.SessionKey,
+	clientKey shared.PrincipalKey,
 ) (protocol.EncryptedData, error) {
 	repPart, err := protocol.NewEncKDCRepPart(
 		sessionKey,
 		req.Nonce(),
 		now,
-		e.cfg.TicketLifetime,
+		issue.lifetime,
 		req.Service(),
 	)
 	if err != nil {
 		return protocol.EncryptedData{}, err
 	}
 
-	return shared.EncryptEntity(clientKey, repPart)
+	repPart = repPart.
+		WithFlags(issue.flags).
+		WithStartTime(issue.startTime).
+		WithRenewTill(issue.renewTill)
+	return shared.EncryptForPrincipal(c, clientKey, crypto.KeyUsageASRepEncPart, repPart)
 }
@@ -1,18 +1,27 @@
 package as_test
 
 import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/tls"
+	"database/sql"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"net"
 	"testing"
 	"time"
 
 	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/codec"
 	"github.com/rizesql/kerberos/internal/crypto"
 	"github.com/rizesql/kerberos/internal/kdb"
 	"github.com/rizesql/kerberos/internal/kdc"
 	"github.com/rizesql/kerberos/internal/kdc/as"
 	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/pkinit"
 	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/rizesql/kerberos/internal/replay"
 	"github.com/rizesql/kerberos/internal/testkit"
 )
 
@@ -26,14 +35,14 @@ func TestExchange(t *testing.T) {
 	expectedSessionKey, _ := protocol.NewSessionKey(expectedSessionKeyBytes)
 
 	// Seed DB
-	h.CreatePrincipal(t.Context(), kdb.CreatePrincipalParams{
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
 		PrimaryName: "alice",
 		Instance:    "",
 		Realm:       "ATHENA.MIT.EDU",
 		KeyBytes:    clientKeyBytes,
 		Kvno:        1,
 	})
-	h.CreatePrincipal(t.Context(), kdb.CreatePrincipalParams{
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
 		PrimaryName: "krbtgt",
 		Instance:    "ATHENA.MIT.EDU",
 		Realm:       "ATHENA.MIT.EDU",
@@ -51,15 +60,23 @@ func TestExchange(t *testing.T) {
 	service, _ := protocol.NewPrincipal("krbtgt", "ATHENA.MIT.EDU", "ATHENA.MIT.EDU")
 	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
 	nonce, _ := protocol.NewNonce(999)
+	clientKey, _ := protocol.NewSessionKey(clientKeyBytes)
+
+	encTimestamp := func(key protocol.SessionKey, ts time.Time) protocol.PAData {
+		pa, err := shared.NewEncTimestamp(codec.JSON, key, ts)
+		assert.Err(t, err, nil)
+		return pa
+	}
+
 	req, _ := protocol.NewASReq(client, service, addr, nonce)
+	req = req.WithPAData(encTimestamp(clientKey, h.Clock.Now()))
 
 	// --- 1. Success Case ---
 	rep, err := exchange.Handle(t.Context(), req)
 	assert.Err(t, err, nil)
 
 	// Verify Secret Part (encrypted with Client Key)
-	clientKey, _ := protocol.NewSessionKey(clientKeyBytes)
-	secretPartBytes, err := crypto.Decrypt(clientKey, rep.SecretPart().Ciphertext())
+	secretPartBytes, err := crypto.Decrypt(clientKey, crypto.KeyUsageASRepEncPart, rep.SecretPart().Ciphertext())
 	assert.Err(t, err, nil)
 
 	var encPart protocol.EncKDCRepPart
@@ -70,10 +87,11 @@ func TestExchange(t *testing.T) {
 	assert.Equal(t, encPart.Server().String(), service.String())
 	assert.Equal(t, encPart.IssuedAt().Equal(h.Clock.Now()), true)
 	assert.Equal(t, string(encPart.SessionKey().Expose()), string(expectedSessionKey.Expose()))
+	assert.Equal(t, encPart.Flags(), protocol.FlagInitial|protocol.FlagPreAuthent)
 
 	// Verify Ticket (encrypted with Service Key)
 	serviceKey, _ := protocol.NewSessionKey(serviceKeyBytes)
-	ticketBytes, err := crypto.Decrypt(serviceKey, rep.Ticket().Ciphertext())
+	ticketBytes, err := crypto.Decrypt(serviceKey, crypto.KeyUsageTicket, rep.Ticket().Ciphertext())
 	assert.Err(t, err, nil)
 
 	var ticket protocol.Ticket
@@ -84,6 +102,7 @@ func TestExchange(t *testing.T) {
 	assert.Equal(t, ticket.Server().String(), service.String())
 	assert.Equal(t, ticket.IssuedAt().Equal(h.Clock.Now()), true)
 	assert.Equal(t, string(ticket.SessionKey().Expose()), string(expectedSessionKey.Expose()))
+	assert.Equal(t, ticket.Flags(), protocol.FlagInitial|protocol.FlagPreAuthent)
 
 	// --- 2. Wrong Realm ---
 	wrongClient, _ := protocol.NewPrincipal("alice", "", "OTHER.REALM")
@@ -103,6 +122,962 @@ func TestExchange(t *testing.T) {
 	unknownService, _ := protocol.NewPrincipal("http", "unknown", "ATHENA.MIT.EDU")
 	svcNotFoundNonce, _ := protocol.NewNonce(999)
 	svcNotFoundReq, _ := protocol.NewASReq(client, unknownService, addr, svcNotFoundNonce)
+	svcNotFoundReq = svcNotFoundReq.WithPAData(encTimestamp(clientKey, h.Clock.Now().Add(time.Millisecond)))
 	_, err = exchange.Handle(t.Context(), svcNotFoundReq)
 	assert.Err(t, err, shared.ErrPrincipalNotFound)
 }
+
+func TestExchange_Preauth(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	clientKeyBytes, _ := hex.DecodeString("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	serviceKeyBytes, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+	clientKey, _ := protocol.NewSessionKey(clientKeyBytes)
+	wrongKey, _ := protocol.NewSessionKey(serviceKeyBytes)
+
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "alice",
+		Instance:    "",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    clientKeyBytes,
+		Kvno:        1,
+	})
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "krbtgt",
+		Instance:    "ATHENA.MIT.EDU",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    serviceKeyBytes,
+		Kvno:        1,
+	})
+
+	exchange := as.NewExchange(h.NewKDCPlatform(), kdc.Config{
+		Realm:          "ATHENA.MIT.EDU",
+		TicketLifetime: 8 * time.Hour,
+	})
+
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	service, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	nonce, _ := protocol.NewNonce(999)
+	req, _ := protocol.NewASReq(client, service, addr, nonce)
+
+	withTimestamp := func(key protocol.SessionKey, ts time.Time) protocol.ASReq {
+		pa, err := shared.NewEncTimestamp(codec.JSON, key, ts)
+		assert.Err(t, err, nil)
+		return req.WithPAData(pa)
+	}
+
+	t.Run("Required", func(t *testing.T) {
+		_, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, protocol.ErrPreauthRequired)
+
+		var preauthErr *protocol.PreauthRequiredError
+		assert.True(t, errors.As(err, &preauthErr))
+
+		_, ok := preauthErr.MethodData().Find(protocol.PATypeEncTimestamp)
+		assert.True(t, ok)
+
+		info, ok, err := preauthErr.MethodData().ETypeInfo2()
+		assert.Err(t, err, nil)
+		assert.True(t, ok)
+		assert.Equal(t, len(info), 1)
+		assert.Equal(t, info[0].EncType(), protocol.EncTypeAES256GCM)
+		salt, ok := info[0].Salt()
+		assert.True(t, ok)
+		assert.Equal(t, salt, "ATHENA.MIT.EDUalice")
+		assert.Equal(t, len(info[0].S2KParams()), 0)
+	})
+
+	// A key derived with its own salt and parameters, PBKDF2 or Argon2id, is
+	// described well enough for the client to derive it again.
+	argon2id := crypto.Argon2idParams{Time: 1, Memory: 64, Threads: 1}
+	for i, params := range [][]byte{crypto.IterationParams(4096), argon2id.Params()} {
+		t.Run("ETypeInfo2/"+string(crypto.KDFOf(params)), func(t *testing.T) {
+			aes := protocol.EncTypeAES256CTSHMACSHA196
+			name := fmt.Sprintf("bob%d", i)
+			saltStr := "ATHENA.MIT.EDUsalty" + name
+			bobKey, err := crypto.StringToKey(aes, "hunter2", saltStr, params)
+			assert.Err(t, err, nil)
+
+			bob := h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+				PrimaryName: name,
+				Realm:       "ATHENA.MIT.EDU",
+				KeyBytes:    serviceKeyBytes,
+				Kvno:        1,
+			})
+			mkvno, sealed, err := h.DB.MasterKeys().Seal(bobKey.Expose())
+			assert.Err(t, err, nil)
+			err = kdb.Query.AddKey(t.Context(), h.DB, kdb.AddKeyParams{
+				PrincipalID: bob.ID,
+				Kvno:        1,
+				Enctype:     int64(aes),
+				Salt:        saltStr,
+				S2kparams:   params,
+				Mkvno:       mkvno,
+				KeyBytes:    sealed,
+			})
+			assert.Err(t, err, nil)
+
+			bobPrincipal, _ := protocol.NewPrincipal(protocol.Primary(name), "", "ATHENA.MIT.EDU")
+			bobReq, _ := protocol.NewASReq(bobPrincipal, service, addr, nonce)
+			bobReq = bobReq.WithETypes(aes, protocol.EncTypeAES256GCM)
+
+			_, err = exchange.Handle(t.Context(), bobReq)
+			var preauthErr *protocol.PreauthRequiredError
+			assert.True(t, errors.As(err, &preauthErr))
+
+			info, ok, err := preauthErr.MethodData().ETypeInfo2()
+			assert.Err(t, err, nil)
+			assert.True(t, ok)
+			assert.Equal(t, info[0].EncType(), aes)
+			assert.Equal(t, info[0].S2KParams(), params)
+			salt, _ := info[0].Salt()
+
+			derived, err := crypto.StringToKey(info[0].EncType(), "hunter2", salt, info[0].S2KParams())
+			assert.Err(t, err, nil)
+			pa, err := shared.NewEncTimestamp(codec.JSON, derived, h.Clock.Now().Add(time.Millisecond))
+			assert.Err(t, err, nil)
+
+			rep, err := exchange.Handle(t.Context(), bobReq.WithPAData(pa))
+			assert.Err(t, err, nil)
+
+			info, ok, err = rep.PAData().ETypeInfo2()
+			assert.Err(t, err, nil)
+			assert.True(t, ok)
+			salt, _ = info[0].Salt()
+			assert.Equal(t, salt, saltStr)
+
+			_, err = shared.DecryptEntity[protocol.EncKDCRepPart](derived, crypto.KeyUsageASRepEncPart, rep.SecretPart())
+			assert.Err(t, err, nil)
+		})
+	}
+
+	t.Run("WrongKey", func(t *testing.T) {
+		_, err := exchange.Handle(t.Context(), withTimestamp(wrongKey, h.Clock.Now()))
+		assert.Err(t, err, protocol.ErrPreauthFailed)
+	})
+
+	t.Run("ClockSkew", func(t *testing.T) {
+		_, err := exchange.Handle(t.Context(), withTimestamp(clientKey, h.Clock.Now().Add(-10*time.Minute)))
+		assert.Err(t, err, shared.ErrClockSkew)
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		replayed := withTimestamp(clientKey, h.Clock.Now().Add(10*time.Millisecond))
+
+		_, err := exchange.Handle(t.Context(), replayed)
+		assert.Err(t, err, nil)
+
+		_, err = exchange.Handle(t.Context(), replayed)
+		assert.Err(t, err, replay.ErrReplayDetected)
+	})
+}
+
+func TestExchange_Flags(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	clientKeyBytes, _ := hex.DecodeString("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	serviceKeyBytes, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+	clientKey, _ := protocol.NewSessionKey(clientKeyBytes)
+	serviceKey, _ := protocol.NewSessionKey(serviceKeyBytes)
+
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "alice",
+		Instance:    "",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    clientKeyBytes,
+		Kvno:        1,
+	})
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "krbtgt",
+		Instance:    "ATHENA.MIT.EDU",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    serviceKeyBytes,
+		Kvno:        1,
+	})
+
+	exchange := as.NewExchange(h.NewKDCPlatform(), kdc.Config{
+		Realm:            "ATHENA.MIT.EDU",
+		TicketLifetime:   8 * time.Hour,
+		MaxRenewableLife: 7 * 24 * time.Hour,
+	})
+
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	service, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	nonce, _ := protocol.NewNonce(999)
+
+	pa, err := shared.NewEncTimestamp(codec.JSON, clientKey, h.Clock.Now())
+	assert.Err(t, err, nil)
+
+	req, _ := protocol.NewASReq(client, service, addr, nonce)
+	req = req.WithPAData(pa).WithOptions(protocol.OptForwardable | protocol.OptRenewable)
+
+	rep, err := exchange.Handle(t.Context(), req)
+	assert.Err(t, err, nil)
+
+	ticket, err := shared.DecryptEntity[protocol.Ticket](serviceKey, crypto.KeyUsageTicket, rep.Ticket())
+	assert.Err(t, err, nil)
+
+	want := protocol.FlagInitial | protocol.FlagPreAuthent | protocol.FlagForwardable | protocol.FlagRenewable
+	assert.Equal(t, ticket.Flags(), want)
+	assert.True(t, !ticket.Flags().Has(protocol.FlagProxiable))
+	assert.True(t, ticket.RenewTill().Equal(h.Clock.Now().Add(7*24*time.Hour)))
+
+	repPart, err := shared.DecryptEntity[protocol.EncKDCRepPart](clientKey, crypto.KeyUsageASRepEncPart, rep.SecretPart())
+	assert.Err(t, err, nil)
+	assert.Equal(t, repPart.Flags(), want)
+	assert.True(t, repPart.RenewTill().Equal(ticket.RenewTill()))
+}
+
+func TestExchange_Postdated(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	clientKeyBytes, _ := hex.DecodeString("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	serviceKeyBytes, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+	clientKey, _ := protocol.NewSessionKey(clientKeyBytes)
+	serviceKey, _ := protocol.NewSessionKey(serviceKeyBytes)
+
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "alice",
+		Instance:    "",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    clientKeyBytes,
+		Kvno:        1,
+	})
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "krbtgt",
+		Instance:    "ATHENA.MIT.EDU",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    serviceKeyBytes,
+		Kvno:        1,
+	})
+
+	exchange := as.NewExchange(h.NewKDCPlatform(), kdc.Config{
+		Realm:          "ATHENA.MIT.EDU",
+		TicketLifetime: 8 * time.Hour,
+	})
+
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	service, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	nonce, _ := protocol.NewNonce(999)
+
+	newReq := func(offset time.Duration) protocol.ASReq {
+		pa, err := shared.NewEncTimestamp(codec.JSON, clientKey, h.Clock.Now().Add(offset))
+		assert.Err(t, err, nil)
+
+		req, _ := protocol.NewASReq(client, service, addr, nonce)
+		return req.WithPAData(pa)
+	}
+
+	t.Run("Postdated", func(t *testing.T) {
+		from := h.Clock.Now().Add(6 * time.Hour)
+		req := newReq(time.Millisecond).
+			WithOptions(protocol.OptPostdated|protocol.OptAllowPostdate).
+			WithTimes(from, time.Time{})
+
+		rep, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, nil)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](serviceKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.True(t, ticket.Flags().Has(protocol.FlagPostdated|protocol.FlagInvalid|protocol.FlagMayPostdate))
+		assert.True(t, ticket.IssuedAt().Equal(h.Clock.Now()))
+		assert.True(t, ticket.StartTime().Equal(from))
+		assert.True(t, ticket.EndTime().Equal(from.Add(8*time.Hour)))
+		assert.True(t, ticket.IsNotYetValid(from.Add(time.Hour)))
+
+		repPart, err := shared.DecryptEntity[protocol.EncKDCRepPart](clientKey, crypto.KeyUsageASRepEncPart, rep.SecretPart())
+		assert.Err(t, err, nil)
+		assert.True(t, repPart.StartTime().Equal(from))
+	})
+
+	t.Run("Till", func(t *testing.T) {
+		till := h.Clock.Now().Add(2 * time.Hour)
+		rep, err := exchange.Handle(t.Context(), newReq(2*time.Millisecond).WithTimes(time.Time{}, till))
+		assert.Err(t, err, nil)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](serviceKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.True(t, ticket.StartTime().Equal(h.Clock.Now()))
+		assert.True(t, ticket.EndTime().Equal(till))
+		assert.True(t, !ticket.Flags().Has(protocol.FlagPostdated))
+	})
+
+	t.Run("FutureStartWithoutPostdated", func(t *testing.T) {
+		req := newReq(3*time.Millisecond).WithTimes(h.Clock.Now().Add(time.Hour), time.Time{})
+		_, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, protocol.KDCErrCannotPostdate)
+	})
+
+	t.Run("PostdatedWithoutStart", func(t *testing.T) {
+		req := newReq(4 * time.Millisecond).WithOptions(protocol.OptPostdated)
+		_, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, protocol.KDCErrBadOption)
+	})
+
+	t.Run("NeverValid", func(t *testing.T) {
+		from := h.Clock.Now().Add(6 * time.Hour)
+		req := newReq(5*time.Millisecond).
+			WithOptions(protocol.OptPostdated).
+			WithTimes(from, from.Add(-time.Hour))
+		_, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, protocol.KDCErrNeverValid)
+	})
+}
+
+func TestExchange_Limits(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	clientKeyBytes, _ := hex.DecodeString("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	serviceKeyBytes, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+	clientKey, _ := protocol.NewSessionKey(clientKeyBytes)
+	serviceKey, _ := protocol.NewSessionKey(serviceKeyBytes)
+
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "alice",
+		Instance:    "",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    clientKeyBytes,
+		Kvno:        1,
+		MaxLife:     sql.NullInt64{Int64: int64((4 * time.Hour).Seconds()), Valid: true},
+	})
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName:      "krbtgt",
+		Instance:         "ATHENA.MIT.EDU",
+		Realm:            "ATHENA.MIT.EDU",
+		KeyBytes:         serviceKeyBytes,
+		Kvno:             1,
+		MaxRenewableLife: sql.NullInt64{Int64: int64((24 * time.Hour).Seconds()), Valid: true},
+	})
+
+	exchange := as.NewExchange(h.NewKDCPlatform(), kdc.Config{
+		Realm:            "ATHENA.MIT.EDU",
+		TicketLifetime:   8 * time.Hour,
+		MaxRenewableLife: 7 * 24 * time.Hour,
+	})
+
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	service, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	nonce, _ := protocol.NewNonce(999)
+
+	newReq := func(offset time.Duration) protocol.ASReq {
+		pa, err := shared.NewEncTimestamp(codec.JSON, clientKey, h.Clock.Now().Add(offset))
+		assert.Err(t, err, nil)
+
+		req, _ := protocol.NewASReq(client, service, addr, nonce)
+		return req.WithPAData(pa).WithOptions(protocol.OptRenewable)
+	}
+
+	t.Run("PrincipalLimits", func(t *testing.T) {
+		now := h.Clock.Now()
+		rep, err := exchange.Handle(t.Context(), newReq(time.Millisecond))
+		assert.Err(t, err, nil)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](serviceKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.True(t, ticket.EndTime().Equal(now.Add(4*time.Hour)))
+		assert.True(t, ticket.RenewTill().Equal(now.Add(24*time.Hour)))
+	})
+
+	t.Run("RequestedTillIsShorter", func(t *testing.T) {
+		now := h.Clock.Now()
+		till := now.Add(time.Hour)
+		rep, err := exchange.Handle(t.Context(), newReq(2*time.Millisecond).WithTimes(time.Time{}, till))
+		assert.Err(t, err, nil)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](serviceKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.True(t, ticket.EndTime().Equal(till))
+	})
+
+	t.Run("RequestedTillIsLonger", func(t *testing.T) {
+		now := h.Clock.Now()
+		rep, err := exchange.Handle(t.Context(), newReq(3*time.Millisecond).WithTimes(time.Time{}, now.Add(48*time.Hour)))
+		assert.Err(t, err, nil)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](serviceKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.True(t, ticket.EndTime().Equal(now.Add(4*time.Hour)))
+	})
+}
+
+func TestExchange_Claims(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	clientKeyBytes, _ := hex.DecodeString("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	krbtgtKeyBytes, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+	serviceKeyBytes, _ := hex.DecodeString("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	clientKey, _ := protocol.NewSessionKey(clientKeyBytes)
+	krbtgtKey, _ := protocol.NewSessionKey(krbtgtKeyBytes)
+	serviceKey, _ := protocol.NewSessionKey(serviceKeyBytes)
+
+	alice := h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "alice",
+		Instance:    "",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    clientKeyBytes,
+		Kvno:        1,
+	})
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "krbtgt",
+		Instance:    "ATHENA.MIT.EDU",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    krbtgtKeyBytes,
+		Kvno:        1,
+	})
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "http",
+		Instance:    "api",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    serviceKeyBytes,
+		Kvno:        1,
+	})
+	h.AddToGroup(t.Context(), "staff", alice)
+	h.AddToGroup(t.Context(), "admins", alice)
+
+	exchange := as.NewExchange(h.NewKDCPlatform(), kdc.Config{
+		Realm:          "ATHENA.MIT.EDU",
+		TicketLifetime: 8 * time.Hour,
+	})
+
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	nonce, _ := protocol.NewNonce(999)
+
+	newReq := func(service protocol.Principal, offset time.Duration) protocol.ASReq {
+		pa, err := shared.NewEncTimestamp(codec.JSON, clientKey, h.Clock.Now().Add(offset))
+		assert.Err(t, err, nil)
+
+		req, _ := protocol.NewASReq(client, service, addr, nonce)
+		return req.WithPAData(pa)
+	}
+
+	t.Run("TGT", func(t *testing.T) {
+		tgs, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+		rep, err := exchange.Handle(t.Context(), newReq(tgs, time.Millisecond))
+		assert.Err(t, err, nil)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](krbtgtKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+
+		ad, ok := ticket.AuthorizationData()
+		assert.True(t, ok)
+		assert.Equal(t, len(ad.Claims().Groups()), 2)
+		assert.True(t, ad.Claims().InGroup("admins"))
+		assert.True(t, ad.Claims().InGroup("staff"))
+		assert.True(t, ad.Claims().AuthTime().Equal(h.Clock.Now().Truncate(time.Second)))
+		assert.Err(t, shared.VerifyKDCChecksum(ad, client, krbtgtKey, krbtgtKey), nil)
+	})
+
+	t.Run("ServiceTicket", func(t *testing.T) {
+		service, _ := protocol.NewPrincipal("http", "api", "ATHENA.MIT.EDU")
+		rep, err := exchange.Handle(t.Context(), newReq(service, 2*time.Millisecond))
+		assert.Err(t, err, nil)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](serviceKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+
+		ad, ok := ticket.AuthorizationData()
+		assert.True(t, ok)
+		assert.True(t, ad.Claims().InGroup("admins"))
+		assert.Err(t, shared.VerifyServerChecksum(ad, client, serviceKey), nil)
+
+		// The KDC checksum is under the krbtgt key, which the service
+		// does not hold.
+		assert.Err(t, shared.VerifyKDCChecksum(ad, client, serviceKey, serviceKey), shared.ErrInvalidAuthzData)
+		assert.Err(t, shared.VerifyKDCChecksum(ad, client, serviceKey, krbtgtKey), nil)
+
+		// The claims are bound to the client they were issued to.
+		bob, _ := protocol.NewPrincipal("bob", "", "ATHENA.MIT.EDU")
+		assert.Err(t, shared.VerifyServerChecksum(ad, bob, serviceKey), shared.ErrInvalidAuthzData)
+	})
+}
+
+func TestExchange_ETypes(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	clientKeyBytes, _ := hex.DecodeString("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	serviceKeyBytes, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+	clientKey, _ := protocol.NewSessionKey(clientKeyBytes)
+
+	alice := h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "alice",
+		Instance:    "",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    clientKeyBytes,
+		Kvno:        3,
+	})
+	krbtgt := h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "krbtgt",
+		Instance:    "ATHENA.MIT.EDU",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    serviceKeyBytes,
+		Kvno:        5,
+	})
+
+	exchange := as.NewExchange(h.NewKDCPlatform(), kdc.Config{
+		Realm:          "ATHENA.MIT.EDU",
+		TicketLifetime: 8 * time.Hour,
+	})
+
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	service, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	nonce, _ := protocol.NewNonce(999)
+	req, _ := protocol.NewASReq(client, service, addr, nonce)
+
+	t.Run("Recorded", func(t *testing.T) {
+		pa, err := shared.NewEncTimestamp(codec.JSON, clientKey, h.Clock.Now())
+		assert.Err(t, err, nil)
+
+		rep, err := exchange.Handle(t.Context(), req.WithPAData(pa))
+		assert.Err(t, err, nil)
+
+		// Each part names the key it was sealed under
+		assert.Equal(t, rep.Ticket().EncType(), protocol.EncTypeAES256GCM)
+		kvno, ok := rep.Ticket().Kvno()
+		assert.True(t, ok)
+		assert.Equal(t, kvno, uint32(5))
+
+		assert.Equal(t, rep.SecretPart().EncType(), protocol.EncTypeAES256GCM)
+		kvno, ok = rep.SecretPart().Kvno()
+		assert.True(t, ok)
+		assert.Equal(t, kvno, uint32(3))
+
+		repPart, err := shared.DecryptEntity[protocol.EncKDCRepPart](clientKey, crypto.KeyUsageASRepEncPart, rep.SecretPart())
+		assert.Err(t, err, nil)
+		assert.Equal(t, repPart.SessionKey().EncType(), protocol.EncTypeAES256GCM)
+	})
+
+	t.Run("NoneShared", func(t *testing.T) {
+		// aes256-cts-hmac-sha1-96, which the KDC has no key of
+		_, err := exchange.Handle(t.Context(), req.WithETypes(18))
+		assert.Err(t, err, protocol.KDCErrETypeNoSupp)
+	})
+
+	t.Run("Standard", func(t *testing.T) {
+		aes := protocol.EncTypeAES256CTSHMACSHA384192
+		aesKey, err := crypto.StringToKey(aes, "alice's password", "ATHENA.MIT.EDUalice", nil)
+		assert.Err(t, err, nil)
+		h.AddKey(t.Context(), alice, 3, aes, aesKey.Expose())
+		h.AddKey(t.Context(), krbtgt, 5, aes, serviceKeyBytes)
+
+		pa, err := shared.NewEncTimestamp(codec.JSON, aesKey, h.Clock.Tick(time.Second))
+		assert.Err(t, err, nil)
+
+		rep, err := exchange.Handle(t.Context(), req.WithETypes(aes, protocol.EncTypeAES256GCM).WithPAData(pa))
+		assert.Err(t, err, nil)
+
+		// The type the client prefers wins wherever there is a key of it
+		assert.Equal(t, rep.Ticket().EncType(), aes)
+		assert.Equal(t, rep.SecretPart().EncType(), aes)
+
+		repPart, err := shared.DecryptEntity[protocol.EncKDCRepPart](aesKey, crypto.KeyUsageASRepEncPart, rep.SecretPart())
+		assert.Err(t, err, nil)
+		assert.Equal(t, repPart.SessionKey().EncType(), aes)
+	})
+}
+
+func TestExchange_FAST(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	clientKeyBytes, _ := hex.DecodeString("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	serviceKeyBytes, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+	clientKey, _ := protocol.NewSessionKey(clientKeyBytes)
+	krbtgtKey, _ := protocol.NewSessionKey(serviceKeyBytes)
+	tgtSessionKey, _ := protocol.NewSessionKey(bytes.Repeat([]byte{7}, 32))
+
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "alice",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    clientKeyBytes,
+		Kvno:        1,
+	})
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "bob",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    clientKeyBytes,
+		Kvno:        1,
+		Flags:       kdb.FlagRequiresFAST,
+	})
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "krbtgt",
+		Instance:    "ATHENA.MIT.EDU",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    serviceKeyBytes,
+		Kvno:        1,
+	})
+
+	exchange := as.NewExchange(h.NewKDCPlatform(), kdc.Config{
+		Realm:          "ATHENA.MIT.EDU",
+		TicketLifetime: 8 * time.Hour,
+	})
+
+	alice, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	bob, _ := protocol.NewPrincipal("bob", "", "ATHENA.MIT.EDU")
+	host, _ := protocol.NewPrincipal("host", "client.athena.mit.edu", "ATHENA.MIT.EDU")
+	service, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	nonce, _ := protocol.NewNonce(999)
+
+	// Every armor and timestamp gets a time of its own, as the KDC refuses
+	// replayed authenticators and the test clock stands still.
+	tick := 0
+	now := func() time.Time {
+		tick++
+		return h.Clock.Now().Add(time.Duration(tick) * time.Millisecond)
+	}
+
+	// armor builds the armor of a request from the host's TGT, sealed under
+	// key, and returns the armor key with it.
+	armor := func(t *testing.T, c codec.Codec, key protocol.SessionKey) (protocol.FastArmor, protocol.SessionKey) {
+		t.Helper()
+		ticket, _ := protocol.NewTicket(service, host, addr, h.Clock.Now(), time.Hour, tgtSessionKey)
+		tgt, err := shared.EncryptTicket(c, shared.PrincipalKey{Key: key, Kvno: 1}, ticket)
+		assert.Err(t, err, nil)
+
+		fastArmor, armorKey, err := shared.NewFastArmor(c, tgt, tgtSessionKey, host, addr, now())
+		assert.Err(t, err, nil)
+		return fastArmor, armorKey
+	}
+
+	request := func(t *testing.T, c codec.Codec, client protocol.Principal, preauth bool) protocol.ASReq {
+		t.Helper()
+		req, _ := protocol.NewASReq(client, service, addr, nonce)
+		if preauth {
+			pa, err := shared.NewEncTimestamp(c, clientKey, now())
+			assert.Err(t, err, nil)
+			req = req.WithPAData(pa)
+		}
+		return req
+	}
+
+	for name, c := range map[string]codec.Codec{"JSON": codec.JSON, "DER": codec.DER} {
+		t.Run("Success/"+name, func(t *testing.T) {
+			fastArmor, armorKey := armor(t, c, krbtgtKey)
+			req, err := shared.ArmorASReq(c, request(t, c, alice, true), fastArmor, armorKey)
+			assert.Err(t, err, nil)
+
+			rep, err := exchange.Handle(codec.NewContext(t.Context(), c), req)
+			assert.Err(t, err, nil)
+
+			// Only the PA-FX-FAST travels in the clear.
+			assert.Equal(t, len(rep.PAData()), 1)
+
+			res, err := shared.OpenFastReply(armorKey, rep, nonce)
+			assert.Err(t, err, nil)
+
+			_, ok := res.PAData().Find(protocol.PATypeETypeInfo2)
+			assert.True(t, ok)
+
+			finished, ok := res.Finished()
+			assert.True(t, ok)
+			assert.Equal(t, finished.Client(), alice)
+
+			// The reply is sealed under the client key strengthened with the
+			// KDC's, which the client key alone does not open.
+			strengthenKey, ok := res.StrengthenKey()
+			assert.True(t, ok)
+			replyKey, err := shared.StrengthenReplyKey(strengthenKey, clientKey)
+			assert.Err(t, err, nil)
+
+			encPart, err := shared.DecryptEntity[protocol.EncKDCRepPart](replyKey, crypto.KeyUsageASRepEncPart, rep.SecretPart())
+			assert.Err(t, err, nil)
+			assert.Equal(t, encPart.Nonce(), nonce)
+
+			_, err = shared.DecryptEntity[protocol.EncKDCRepPart](clientKey, crypto.KeyUsageASRepEncPart, rep.SecretPart())
+			assert.True(t, err != nil)
+		})
+	}
+
+	t.Run("ProtectedError", func(t *testing.T) {
+		fastArmor, armorKey := armor(t, codec.JSON, krbtgtKey)
+		req, err := shared.ArmorASReq(codec.JSON, request(t, codec.JSON, alice, false), fastArmor, armorKey)
+		assert.Err(t, err, nil)
+
+		_, err = exchange.Handle(t.Context(), req)
+		assert.Err(t, err, protocol.ErrPreauthRequired)
+
+		var fastErr *shared.FastError
+		assert.True(t, errors.As(err, &fastErr))
+
+		// The error in the clear carries nothing but its code; the hints
+		// are sealed under the armor.
+		krbErr := shared.NewKRBError(codec.JSON, err, "ATHENA.MIT.EDU", h.Clock.Now())
+		assert.Equal(t, krbErr.Code(), protocol.KDCErrPreauthRequired)
+		assert.Equal(t, krbErr.Text(), "")
+
+		md, err := krbErr.MethodData()
+		assert.Err(t, err, nil)
+		_, ok, _ := md.ETypeInfo2()
+		assert.True(t, !ok)
+
+		inner, err := shared.OpenFastError(armorKey, krbErr, nonce)
+		assert.Err(t, err, nil)
+		assert.Equal(t, inner.Code(), protocol.KDCErrPreauthRequired)
+
+		md, err = inner.MethodData()
+		assert.Err(t, err, nil)
+		_, ok = md.Find(protocol.PATypeEncTimestamp)
+		assert.True(t, ok)
+		_, ok, err = md.ETypeInfo2()
+		assert.Err(t, err, nil)
+		assert.True(t, ok)
+	})
+
+	t.Run("Advertised", func(t *testing.T) {
+		_, err := exchange.Handle(t.Context(), request(t, codec.JSON, alice, false))
+
+		var preauthErr *protocol.PreauthRequiredError
+		assert.True(t, errors.As(err, &preauthErr))
+		_, ok := preauthErr.MethodData().Find(protocol.PATypeFXFast)
+		assert.True(t, ok)
+	})
+
+	t.Run("RequiresFAST", func(t *testing.T) {
+		_, err := exchange.Handle(t.Context(), request(t, codec.JSON, bob, true))
+		assert.Err(t, err, protocol.KDCErrPolicy)
+
+		fastArmor, armorKey := armor(t, codec.JSON, krbtgtKey)
+		req, err := shared.ArmorASReq(codec.JSON, request(t, codec.JSON, bob, true), fastArmor, armorKey)
+		assert.Err(t, err, nil)
+
+		_, err = exchange.Handle(t.Context(), req)
+		assert.Err(t, err, nil)
+	})
+
+	t.Run("Modified", func(t *testing.T) {
+		fastArmor, armorKey := armor(t, codec.JSON, krbtgtKey)
+		req, err := shared.ArmorASReq(codec.JSON, request(t, codec.JSON, alice, true), fastArmor, armorKey)
+		assert.Err(t, err, nil)
+
+		_, err = exchange.Handle(t.Context(), req.WithOptions(protocol.OptForwardable))
+		assert.Err(t, err, shared.ErrModified)
+
+		var fastErr *shared.FastError
+		assert.True(t, errors.As(err, &fastErr))
+	})
+
+	t.Run("ForgedArmor", func(t *testing.T) {
+		fastArmor, armorKey := armor(t, codec.JSON, clientKey)
+		req, err := shared.ArmorASReq(codec.JSON, request(t, codec.JSON, alice, true), fastArmor, armorKey)
+		assert.Err(t, err, nil)
+
+		_, err = exchange.Handle(t.Context(), req)
+		assert.Err(t, err, shared.ErrInvalidTicket)
+	})
+
+	t.Run("PostdatedArmor", func(t *testing.T) {
+		postdated, _ := protocol.NewTicket(service, host, addr, h.Clock.Now(), time.Hour, tgtSessionKey)
+		postdated = postdated.WithFlags(protocol.FlagPostdated | protocol.FlagInvalid)
+
+		for name, ticket := range map[string]protocol.Ticket{
+			"NotStarted":  postdated.WithStartTime(h.Clock.Now().Add(time.Hour)),
+			"Invalidated": postdated.WithStartTime(h.Clock.Now().Add(-time.Minute)),
+		} {
+			t.Run(name, func(t *testing.T) {
+				tgt, err := shared.EncryptTicket(codec.JSON, shared.PrincipalKey{Key: krbtgtKey, Kvno: 1}, ticket)
+				assert.Err(t, err, nil)
+				fastArmor, armorKey, err := shared.NewFastArmor(codec.JSON, tgt, tgtSessionKey, host, addr, now())
+				assert.Err(t, err, nil)
+
+				req, err := shared.ArmorASReq(codec.JSON, request(t, codec.JSON, alice, true), fastArmor, armorKey)
+				assert.Err(t, err, nil)
+
+				_, err = exchange.Handle(t.Context(), req)
+				assert.Err(t, err, protocol.KRBAPErrTktNYV)
+			})
+		}
+	})
+
+	t.Run("ReplayedArmor", func(t *testing.T) {
+		fastArmor, armorKey := armor(t, codec.JSON, krbtgtKey)
+		req, err := shared.ArmorASReq(codec.JSON, request(t, codec.JSON, alice, true), fastArmor, armorKey)
+		assert.Err(t, err, nil)
+
+		_, err = exchange.Handle(t.Context(), req)
+		assert.Err(t, err, nil)
+
+		req, err = shared.ArmorASReq(codec.JSON, request(t, codec.JSON, alice, true), fastArmor, armorKey)
+		assert.Err(t, err, nil)
+
+		_, err = exchange.Handle(t.Context(), req)
+		assert.Err(t, err, replay.ErrReplayDetected)
+	})
+}
+
+func TestExchange_PKINIT(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	serviceKeyBytes, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+	krbtgtKey, _ := protocol.NewSessionKey(serviceKeyBytes)
+	tgtSessionKey, _ := protocol.NewSessionKey(bytes.Repeat([]byte{7}, 32))
+
+	// Principals that pre-authenticate with a certificate need no key.
+	for _, name := range []string{"alice", "bob"} {
+		_, err := kdb.Query.CreatePrincipal(t.Context(), h.DB, kdb.CreatePrincipalParams{
+			PrimaryName: name,
+			Realm:       "ATHENA.MIT.EDU",
+			Kvno:        1,
+		})
+		assert.Err(t, err, nil)
+	}
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "krbtgt",
+		Instance:    "ATHENA.MIT.EDU",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    serviceKeyBytes,
+		Kvno:        1,
+	})
+
+	alice, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	bob, _ := protocol.NewPrincipal("bob", "", "ATHENA.MIT.EDU")
+	host, _ := protocol.NewPrincipal("host", "client.athena.mit.edu", "ATHENA.MIT.EDU")
+	service, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	nonce, _ := protocol.NewNonce(999)
+
+	pki := testkit.NewPKI(t, "Athena CA", h.Clock.Now())
+	kdcCert := pki.Issue("kdc.athena.mit.edu", service, pkinit.OIDKPKdc)
+	aliceCert := pki.Issue("alice", alice, pkinit.OIDKPClientAuth)
+
+	cfg := kdc.Config{
+		Realm:          "ATHENA.MIT.EDU",
+		TicketLifetime: 8 * time.Hour,
+		PKINIT:         &pkinit.Config{Certificate: kdcCert, Roots: pki.Roots},
+	}
+	exchange := as.NewExchange(h.NewKDCPlatform(), cfg)
+
+	// Every PKAuthenticator gets a time of its own, as the KDC refuses
+	// replayed ones and the test clock stands still.
+	tick := 0
+	now := func() time.Time {
+		tick++
+		return h.Clock.Now().Add(time.Duration(tick) * time.Millisecond)
+	}
+
+	// request builds a request of client signed with cert, and returns the
+	// key that opens the reply with it.
+	request := func(t *testing.T, c codec.Codec, client protocol.Principal, cert tls.Certificate) (protocol.ASReq, *ecdh.PrivateKey) {
+		t.Helper()
+		req, _ := protocol.NewASReq(client, service, addr, nonce)
+		req = req.WithETypes(crypto.SupportedEncTypes...)
+
+		body, err := shared.ASReqBody(c, req)
+		assert.Err(t, err, nil)
+
+		pa, dhKey, err := pkinit.NewRequest(c, cert, body, nonce, now())
+		assert.Err(t, err, nil)
+		return req.WithPAData(pa), dhKey
+	}
+
+	for name, c := range map[string]codec.Codec{"JSON": codec.JSON, "DER": codec.DER} {
+		t.Run("Success/"+name, func(t *testing.T) {
+			req, dhKey := request(t, c, alice, aliceCert)
+
+			rep, err := exchange.Handle(codec.NewContext(t.Context(), c), req)
+			assert.Err(t, err, nil)
+
+			pa, ok := rep.PAData().Find(protocol.PATypePKASRep)
+			assert.True(t, ok)
+
+			replyKey, err := pkinit.OpenReply(pa, pki.Roots, "ATHENA.MIT.EDU", dhKey, nonce, rep.SecretPart().EncType(), h.Clock.Now())
+			assert.Err(t, err, nil)
+
+			encPart, err := shared.DecryptEntity[protocol.EncKDCRepPart](replyKey, crypto.KeyUsageASRepEncPart, rep.SecretPart())
+			assert.Err(t, err, nil)
+			assert.Equal(t, encPart.Nonce(), nonce)
+			assert.Equal(t, encPart.Flags(), protocol.FlagInitial|protocol.FlagPreAuthent)
+
+			// No key version goes with a key that was never stored.
+			_, ok = rep.SecretPart().Kvno()
+			assert.True(t, !ok)
+		})
+	}
+
+	t.Run("FAST", func(t *testing.T) {
+		ticket, _ := protocol.NewTicket(service, host, addr, h.Clock.Now(), time.Hour, tgtSessionKey)
+		tgt, err := shared.EncryptTicket(codec.JSON, shared.PrincipalKey{Key: krbtgtKey, Kvno: 1}, ticket)
+		assert.Err(t, err, nil)
+		fastArmor, armorKey, err := shared.NewFastArmor(codec.JSON, tgt, tgtSessionKey, host, addr, now())
+		assert.Err(t, err, nil)
+
+		inner, dhKey := request(t, codec.JSON, alice, aliceCert)
+		req, err := shared.ArmorASReq(codec.JSON, inner, fastArmor, armorKey)
+		assert.Err(t, err, nil)
+
+		rep, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, nil)
+
+		res, err := shared.OpenFastReply(armorKey, rep, nonce)
+		assert.Err(t, err, nil)
+		pa, ok := res.PAData().Find(protocol.PATypePKASRep)
+		assert.True(t, ok)
+
+		replyKey, err := pkinit.OpenReply(pa, pki.Roots, "ATHENA.MIT.EDU", dhKey, nonce, rep.SecretPart().EncType(), h.Clock.Now())
+		assert.Err(t, err, nil)
+		strengthenKey, ok := res.StrengthenKey()
+		assert.True(t, ok)
+		replyKey, err = shared.StrengthenReplyKey(strengthenKey, replyKey)
+		assert.Err(t, err, nil)
+
+		_, err = shared.DecryptEntity[protocol.EncKDCRepPart](replyKey, crypto.KeyUsageASRepEncPart, rep.SecretPart())
+		assert.Err(t, err, nil)
+	})
+
+	t.Run("NameMismatch", func(t *testing.T) {
+		req, _ := request(t, codec.JSON, bob, aliceCert)
+
+		_, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, protocol.KDCErrClientNameMismatch)
+	})
+
+	t.Run("UntrustedCA", func(t *testing.T) {
+		other := testkit.NewPKI(t, "Other CA", h.Clock.Now())
+		req, _ := request(t, codec.JSON, alice, other.Issue("alice", alice, pkinit.OIDKPClientAuth))
+
+		_, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, pkinit.ErrUntrusted)
+		assert.Equal(t, shared.ErrorCode(err), protocol.KDCErrCantVerifyCertificate)
+	})
+
+	t.Run("KDCCertificate", func(t *testing.T) {
+		// A certificate not issued for clients does not log one in, even if
+		// it names the client.
+		req, _ := request(t, codec.JSON, alice, pki.Issue("alice", alice, pkinit.OIDKPKdc))
+
+		_, err := exchange.Handle(t.Context(), req)
+		assert.Equal(t, shared.ErrorCode(err), protocol.KDCErrInvalidCertificate)
+	})
+
+	t.Run("Modified", func(t *testing.T) {
+		req, _ := request(t, codec.JSON, alice, aliceCert)
+
+		_, err := exchange.Handle(t.Context(), req.WithOptions(protocol.OptForwardable))
+		assert.Err(t, err, pkinit.ErrModified)
+		assert.Equal(t, shared.ErrorCode(err), protocol.KRBAPErrModified)
+	})
+
+	t.Run("Replayed", func(t *testing.T) {
+		req, _ := request(t, codec.JSON, alice, aliceCert)
+
+		_, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, nil)
+		_, err = exchange.Handle(t.Context(), req)
+		assert.Err(t, err, replay.ErrReplayDetected)
+	})
+
+	t.Run("NotEnabled", func(t *testing.T) {
+		exchange := as.NewExchange(h.NewKDCPlatform(), kdc.Config{
+			Realm:          "ATHENA.MIT.EDU",
+			TicketLifetime: 8 * time.Hour,
+		})
+		req, _ := request(t, codec.JSON, alice, aliceCert)
+
+		_, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, protocol.KDCErrPADataTypeNoSupp)
+	})
+}
@@ -0,0 +1,132 @@
+package as
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// handleFAST serves an AS-REQ armored with FAST (RFC 6113). The armor is an
+// AP-REQ for a TGT of this realm; once its key is known, every error goes
+// back sealed under it.
+func (e *Exchange) handleFAST(ctx context.Context, req protocol.ASReq, pa protocol.PAData) (protocol.ASRep, error) {
+	c := codec.FromContext(ctx)
+
+	var armored protocol.FastArmoredReq
+	if err := shared.DecodePAData(pa, &armored); err != nil {
+		return protocol.ASRep{}, fmt.Errorf("%w: malformed PA-FX-FAST: %w", shared.ErrInvalidArmor, err)
+	}
+
+	armorKey, err := e.armorKey(ctx, armored)
+	if err != nil {
+		return protocol.ASRep{}, err
+	}
+	armor := shared.Armor{Key: armorKey, Nonce: req.Nonce()}
+
+	body, err := shared.ASReqBody(c, req)
+	if err != nil {
+		return protocol.ASRep{}, shared.NewFastError(armor, fmt.Errorf("%w: %w", protocol.KRBErrGeneric, err))
+	}
+
+	fast, err := shared.OpenFastReq(armorKey, armored, body)
+	if err != nil {
+		return protocol.ASRep{}, shared.NewFastError(armor, err)
+	}
+
+	inner, err := fast.ASReq()
+	if err != nil {
+		return protocol.ASRep{}, shared.NewFastError(armor, fmt.Errorf("%w: %w", shared.ErrInvalidArmor, err))
+	}
+	armor.Nonce = inner.Nonce()
+
+	rep, err := e.exchange(ctx, inner, &armor)
+	if err != nil {
+		return protocol.ASRep{}, shared.NewFastError(armor, err)
+	}
+	return rep, nil
+}
+
+// armorKey verifies the AP-REQ armor of armored and derives the armor key
+// from it. The armor ticket must be a live TGT of this realm, presented by
+// its client with a subkey.
+func (e *Exchange) armorKey(ctx context.Context, armored protocol.FastArmoredReq) (protocol.SessionKey, error) {
+	armor, ok := armored.Armor()
+	if !ok {
+		return protocol.SessionKey{}, fmt.Errorf("%w: AS-REQ carries no armor", shared.ErrInvalidArmor)
+	}
+	if armor.Type() != protocol.FastArmorAPRequest {
+		return protocol.SessionKey{}, fmt.Errorf("%w: unsupported armor type %d", shared.ErrInvalidArmor, armor.Type())
+	}
+
+	value := armor.Value()
+	var apReq protocol.APReq
+	if err := codec.Detect(value).Unmarshal(value, &apReq); err != nil {
+		return protocol.SessionKey{}, fmt.Errorf("%w: malformed AP-REQ: %w", shared.ErrInvalidArmor, err)
+	}
+
+	tgsPrincipal, err := protocol.NewInterRealmKrbtgt(e.cfg.Realm, e.cfg.Realm)
+	if err != nil {
+		return protocol.SessionKey{}, fmt.Errorf("%w: %w", protocol.KRBErrGeneric, err)
+	}
+
+	tgsEntry, err := shared.FetchPrincipal(ctx, e.db, e.logger, tgsPrincipal)
+	if err != nil {
+		return protocol.SessionKey{}, fmt.Errorf("%w: failed to fetch TGS key: %w", protocol.KRBErrGeneric, err)
+	}
+
+	tgsKey, err := tgsEntry.KeyFor(apReq.Ticket())
+	if err != nil {
+		return protocol.SessionKey{}, fmt.Errorf("%w: %w", shared.ErrInvalidArmor, err)
+	}
+
+	tgt, err := shared.DecryptTicket(tgsKey.Key, apReq.Ticket())
+	if err != nil {
+		e.logger.Warn("failed to decrypt armor ticket", "err", err)
+		return protocol.SessionKey{}, fmt.Errorf("%w: armor ticket", shared.ErrInvalidTicket)
+	}
+	if tgt.Server() != tgsPrincipal {
+		return protocol.SessionKey{}, fmt.Errorf("%w: armor ticket is for %s", shared.ErrInvalidTicket, tgt.Server())
+	}
+	if tgt.IsExpired(e.clock.Now()) {
+		return protocol.SessionKey{}, fmt.Errorf("%w: armor ticket expired", shared.ErrTicketExpired)
+	}
+	// A postdated TGT vouches for nothing until it starts and is validated.
+	if tgt.IsNotYetValid(e.clock.Now()) {
+		return protocol.SessionKey{}, fmt.Errorf("%w: armor ticket is not yet valid", protocol.KRBAPErrTktNYV)
+	}
+
+	auth, err := shared.DecryptEntity[protocol.Authenticator](tgt.SessionKey(), crypto.KeyUsageAPReqAuth, apReq.Authenticator())
+	if err != nil {
+		e.logger.Warn("failed to decrypt armor authenticator", "err", err)
+		return protocol.SessionKey{}, shared.ErrInvalidAuthenticator
+	}
+
+	if auth.Client() != tgt.Client() {
+		return protocol.SessionKey{}, fmt.Errorf("%w: ticket=%s, auth=%s", shared.ErrClientMismatch, tgt.Client(), auth.Client())
+	}
+
+	skew := e.clock.Now().Sub(auth.IssuedAt())
+	if skew < -e.maxSkew || skew > e.maxSkew {
+		return protocol.SessionKey{}, fmt.Errorf("%w: armor authenticator", shared.ErrClockSkew)
+	}
+
+	if err := e.replayCache.Check(auth.Client().String(), auth.IssuedAt()); err != nil {
+		e.logger.Warn("replayed armor authenticator", "client", auth.Client(), "timestamp", auth.IssuedAt())
+		return protocol.SessionKey{}, err
+	}
+
+	subkey, ok := auth.Subkey()
+	if !ok {
+		return protocol.SessionKey{}, fmt.Errorf("%w: armor authenticator carries no subkey", shared.ErrInvalidArmor)
+	}
+
+	key, err := shared.ArmorKey(subkey, tgt.SessionKey())
+	if err != nil {
+		return protocol.SessionKey{}, fmt.Errorf("%w: %w", protocol.KRBErrGeneric, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,28 @@
+package as
+
+import "github.com/rizesql/kerberos/internal/protocol"
+
+// ticketFlags decides the flags of a ticket issued by the AS. Only the AS
+// sets INITIAL, and PRE-AUTHENT only when the client proved knowledge of its
+// key before the reply was issued.
+func ticketFlags(options protocol.KDCOptions, preauthenticated bool) protocol.TicketFlags {
+	flags := protocol.FlagInitial
+	if preauthenticated {
+		flags = flags.With(protocol.FlagPreAuthent)
+	}
+
+	if options.Has(protocol.OptForwardable) {
+		flags = flags.With(protocol.FlagForwardable)
+	}
+	if options.Has(protocol.OptProxiable) {
+		flags = flags.With(protocol.FlagProxiable)
+	}
+	if options.Has(protocol.OptAllowPostdate) {
+		flags = flags.With(protocol.FlagMayPostdate)
+	}
+	if options.Has(protocol.OptRenewable) {
+		flags = flags.With(protocol.FlagRenewable)
+	}
+
+	return flags
+}
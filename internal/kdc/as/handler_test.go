@@ -1,6 +1,7 @@
 package as_test
 
 import (
+	"bytes"
 	"encoding/hex"
 	"net"
 	"net/http"
@@ -8,10 +9,11 @@ import (
 	"time"
 
 	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/codec"
 	"github.com/rizesql/kerberos/internal/crypto"
-	"github.com/rizesql/kerberos/internal/kdb"
 	"github.com/rizesql/kerberos/internal/kdc"
 	"github.com/rizesql/kerberos/internal/kdc/as"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
 	"github.com/rizesql/kerberos/internal/protocol"
 	"github.com/rizesql/kerberos/internal/testkit"
 )
@@ -22,11 +24,12 @@ func TestHandler(t *testing.T) {
 	// Setup keys
 	clientKeyBytes, _ := hex.DecodeString("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
 	serviceKeyBytes, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+	krbtgtKeyBytes, _ := hex.DecodeString("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
 
 	clientKey, _ := protocol.NewSessionKey(clientKeyBytes)
 
 	// Seed DB
-	h.CreatePrincipal(t.Context(), kdb.CreatePrincipalParams{
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
 		PrimaryName: "client",
 		Instance:    "user",
 		Realm:       "TEST.REALM",
@@ -34,7 +37,7 @@ func TestHandler(t *testing.T) {
 		Kvno:        1,
 	})
 
-	h.CreatePrincipal(t.Context(), kdb.CreatePrincipalParams{
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
 		PrimaryName: "service",
 		Instance:    "http",
 		Realm:       "TEST.REALM",
@@ -42,6 +45,15 @@ func TestHandler(t *testing.T) {
 		Kvno:        1,
 	})
 
+	// Every realm has a krbtgt; it signs the claims of service tickets
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "krbtgt",
+		Instance:    "TEST.REALM",
+		Realm:       "TEST.REALM",
+		KeyBytes:    krbtgtKeyBytes,
+		Kvno:        1,
+	})
+
 	srv := h.NewServer()
 	as := as.NewHandler(h.NewKDCPlatform(), kdc.Config{
 		Realm:          "TEST.REALM",
@@ -55,8 +67,28 @@ func TestHandler(t *testing.T) {
 	nonce, _ := protocol.NewNonce(123456)
 	req, _ := protocol.NewASReq(client, service, addr, nonce)
 
+	// Without pre-authentication the KDC asks for it
+	preauthResp := testkit.Call[protocol.ASReq, protocol.KRBError](t, srv, as, nil, req)
+	assert.Equal(t, preauthResp.Status, http.StatusUnauthorized)
+	if preauthResp.Body == nil {
+		t.Fatal("preauth response body is nil")
+	}
+	assert.Equal(t, preauthResp.Body.Code(), protocol.KDCErrPreauthRequired)
+	assert.Equal(t, preauthResp.Body.Realm(), protocol.Realm("TEST.REALM"))
+
+	methodData, err := preauthResp.Body.MethodData()
+	assert.Err(t, err, nil)
+	info, ok, err := methodData.ETypeInfo2()
+	assert.Err(t, err, nil)
+	assert.True(t, ok)
+	salt, _ := info[0].Salt()
+	assert.Equal(t, salt, "TEST.REALMclientuser")
+
+	encTimestamp, err := shared.NewEncTimestamp(codec.JSON, clientKey, h.Clock.Now())
+	assert.Err(t, err, nil)
+
 	// Call
-	resp := testkit.Call[protocol.ASReq, protocol.ASRep](t, srv, as, nil, req)
+	resp := testkit.Call[protocol.ASReq, protocol.ASRep](t, srv, as, nil, req.WithPAData(encTimestamp))
 
 	assert.Equal(t, resp.Status, http.StatusOK)
 	if resp.Body == nil {
@@ -65,7 +97,7 @@ func TestHandler(t *testing.T) {
 
 	// Decrypt SecretPart using ClientKey
 	encSecretPart := resp.Body.SecretPart()
-	secretPartBytes, err := crypto.Decrypt(clientKey, encSecretPart.Ciphertext())
+	secretPartBytes, err := crypto.Decrypt(clientKey, crypto.KeyUsageASRepEncPart, encSecretPart.Ciphertext())
 	assert.Err(t, err, nil)
 
 	var repPart protocol.EncKDCRepPart
@@ -92,8 +124,12 @@ func TestHandler_WrongRealm(t *testing.T) {
 	nonce, _ := protocol.NewNonce(123)
 	req, _ := protocol.NewASReq(client, service, addr, nonce)
 
-	resp := testkit.Call[protocol.ASReq, any](t, srv, as, nil, req)
+	resp := testkit.Call[protocol.ASReq, protocol.KRBError](t, srv, as, nil, req)
 	assert.Equal(t, resp.Status, http.StatusBadRequest)
+	if resp.Body == nil {
+		t.Fatal("response body is nil")
+	}
+	assert.Equal(t, resp.Body.Code(), protocol.KDCErrWrongRealm)
 }
 
 func TestHandler_PrincipalNotFound(t *testing.T) {
@@ -101,7 +137,7 @@ func TestHandler_PrincipalNotFound(t *testing.T) {
 
 	// Seed only service, so client lookup fails
 	serviceKeyBytes, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
-	h.CreatePrincipal(t.Context(), kdb.CreatePrincipalParams{
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
 		PrimaryName: "service",
 		Instance:    "http",
 		Realm:       "TEST.REALM",
@@ -122,6 +158,93 @@ func TestHandler_PrincipalNotFound(t *testing.T) {
 	nonce, _ := protocol.NewNonce(123)
 	req, _ := protocol.NewASReq(client, service, addr, nonce)
 
-	resp := testkit.Call[protocol.ASReq, any](t, srv, as, nil, req)
+	resp := testkit.Call[protocol.ASReq, protocol.KRBError](t, srv, as, nil, req)
 	assert.Equal(t, resp.Status, http.StatusNotFound)
+	if resp.Body == nil {
+		t.Fatal("response body is nil")
+	}
+	assert.Equal(t, resp.Body.Code(), protocol.KDCErrCPrincipalUnknown)
+}
+
+func TestHandler_DER(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	clientKeyBytes, _ := hex.DecodeString("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	krbtgtKeyBytes, _ := hex.DecodeString("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+
+	clientKey, _ := protocol.NewSessionKey(clientKeyBytes)
+	krbtgtKey, _ := protocol.NewSessionKey(krbtgtKeyBytes)
+
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "client",
+		Instance:    "user",
+		Realm:       "TEST.REALM",
+		KeyBytes:    clientKeyBytes,
+		Kvno:        1,
+	})
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "krbtgt",
+		Instance:    "TEST.REALM",
+		Realm:       "TEST.REALM",
+		KeyBytes:    krbtgtKeyBytes,
+		Kvno:        1,
+	})
+
+	srv := h.NewServer()
+	as := as.NewHandler(h.NewKDCPlatform(), kdc.Config{
+		Realm:          "TEST.REALM",
+		TicketLifetime: 1 * time.Hour,
+	})
+	srv.Register(as)
+
+	client, _ := protocol.NewPrincipal("client", "user", "TEST.REALM")
+	krbtgt, _ := protocol.NewKrbtgt("TEST.REALM")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	nonce, _ := protocol.NewNonce(123456)
+	req, _ := protocol.NewASReq(client, krbtgt, addr, nonce)
+
+	headers := http.Header{"Content-Type": {codec.DERContentType}}
+
+	// The KDC answers in the encoding it was asked in, errors included
+	preauthResp := testkit.Call[protocol.ASReq, protocol.KRBError](t, srv, as, headers, req)
+	assert.Equal(t, preauthResp.Status, http.StatusUnauthorized)
+	if preauthResp.Body == nil {
+		t.Fatal("preauth response body is nil")
+	}
+	assert.Equal(t, preauthResp.Body.Code(), protocol.KDCErrPreauthRequired)
+
+	methodData, err := preauthResp.Body.MethodData()
+	assert.Err(t, err, nil)
+	info, ok, err := methodData.ETypeInfo2()
+	assert.Err(t, err, nil)
+	assert.True(t, ok)
+	salt, _ := info[0].Salt()
+	assert.Equal(t, salt, "TEST.REALMclientuser")
+
+	encTimestamp, err := shared.NewEncTimestamp(codec.DER, clientKey, h.Clock.Now())
+	assert.Err(t, err, nil)
+
+	resp := testkit.Call[protocol.ASReq, protocol.ASRep](t, srv, as, headers, req.WithPAData(encTimestamp))
+	assert.Equal(t, resp.Status, http.StatusOK)
+	if resp.Body == nil {
+		t.Fatal("response body is nil")
+	}
+	assert.Equal(t, resp.Body.Client(), client)
+
+	// The reply repeats how the client key was derived
+	info, ok, err = resp.Body.PAData().ETypeInfo2()
+	assert.Err(t, err, nil)
+	assert.True(t, ok)
+	assert.Equal(t, info[0].EncType(), protocol.EncTypeAES256GCM)
+
+	repPart, err := shared.DecryptEntity[protocol.EncKDCRepPart](clientKey, crypto.KeyUsageASRepEncPart, resp.Body.SecretPart())
+	assert.Err(t, err, nil)
+	assert.Equal(t, repPart.Nonce(), nonce)
+	assert.Equal(t, repPart.Server(), krbtgt)
+
+	tgt, err := shared.DecryptTicket(krbtgtKey, resp.Body.Ticket())
+	assert.Err(t, err, nil)
+	assert.Equal(t, tgt.Server(), krbtgt)
+	assert.Equal(t, tgt.Client(), client)
+	assert.True(t, bytes.Equal(tgt.SessionKey().Expose(), repPart.SessionKey().Expose()))
 }
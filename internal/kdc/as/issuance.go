@@ -0,0 +1,64 @@
+package as
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// issuance holds the properties of the ticket an AS request is answered with.
+type issuance struct {
+	flags     protocol.TicketFlags
+	startTime time.Time
+	lifetime  time.Duration
+	renewTill time.Time
+}
+
+// issuance decides the flags and validity of the ticket issued for req.
+// A POSTDATED ticket starts at the requested from and is issued INVALID until
+// the TGS validates it. The end time is the earliest of the requested till,
+// the lifetime limits of the realm, client and service and, for renewable
+// tickets, the renew-till.
+func (e *Exchange) issuance(req protocol.ASReq, now time.Time, limits shared.Limits) (issuance, error) {
+	// Pre-authentication is mandatory, so every AS ticket is PRE-AUTHENT.
+	issue := issuance{flags: ticketFlags(req.Options(), true)}
+
+	start := now
+	switch {
+	case req.Options().Has(protocol.OptPostdated):
+		if req.From().IsZero() {
+			return issuance{}, fmt.Errorf("%w: POSTDATED requires a start time", protocol.KDCErrBadOption)
+		}
+		if req.From().After(now) {
+			start = req.From()
+			issue.startTime = start
+			issue.flags = issue.flags.With(protocol.FlagPostdated | protocol.FlagInvalid)
+		}
+	case req.From().After(now.Add(e.maxSkew)):
+		return issuance{}, fmt.Errorf("%w: start time is in the future", protocol.KDCErrCannotPostdate)
+	}
+
+	end := start.Add(limits.MaxLife)
+	if till := req.Till(); !till.IsZero() && till.Before(end) {
+		end = till
+	}
+	if !end.After(start) {
+		return issuance{}, fmt.Errorf("%w: requested end time is before the start time", protocol.KDCErrNeverValid)
+	}
+
+	if issue.flags.Has(protocol.FlagRenewable) {
+		if limits.MaxRenewableLife <= 0 {
+			issue.flags = issue.flags.Without(protocol.FlagRenewable)
+		} else {
+			issue.renewTill = start.Add(limits.MaxRenewableLife)
+			if issue.renewTill.Before(end) {
+				end = issue.renewTill
+			}
+		}
+	}
+
+	issue.lifetime = end.Sub(start)
+	return issue, nil
+}
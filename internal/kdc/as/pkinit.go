@@ -0,0 +1,76 @@
+package as
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/pkinit"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// verifyPKINIT checks the PA-PK-AS-REQ pa carried by req (RFC 4556). The
+// client's certificate must chain to one of the realm's PKINIT CAs and be
+// issued to the client, and the PKAuthenticator it signed must bind req,
+// fall within the allowed clock skew and not have been seen before. The
+// reply key is then agreed on with the client's ephemeral key, of the
+// strongest encryption type both ends support.
+func (e *Exchange) verifyPKINIT(
+	c codec.Codec,
+	req protocol.ASReq,
+	pa protocol.PAData,
+) (shared.PrincipalKey, protocol.PAData, error) {
+	if e.cfg.PKINIT == nil {
+		return shared.PrincipalKey{}, protocol.PAData{}, fmt.Errorf("%w: PKINIT is not enabled", protocol.KDCErrPADataTypeNoSupp)
+	}
+
+	body, err := shared.ASReqBody(c, req)
+	if err != nil {
+		return shared.PrincipalKey{}, protocol.PAData{}, fmt.Errorf("%w: %w", protocol.KRBErrGeneric, err)
+	}
+
+	pack, cert, err := pkinit.OpenRequest(pa, e.cfg.PKINIT.Roots, body, e.clock.Now())
+	if err != nil {
+		e.logger.Warn("PKINIT failed", "client", req.Client(), "err", err)
+		return shared.PrincipalKey{}, protocol.PAData{}, err
+	}
+
+	owner, err := pkinit.PrincipalOf(cert, e.cfg.Realm)
+	if err != nil {
+		return shared.PrincipalKey{}, protocol.PAData{}, err
+	}
+	if owner != req.Client() {
+		e.logger.Warn("PKINIT certificate issued to another principal", "client", req.Client(), "owner", owner)
+		return shared.PrincipalKey{}, protocol.PAData{}, fmt.Errorf("%w: certificate is issued to %s", protocol.KDCErrClientNameMismatch, owner)
+	}
+
+	auth := pack.PKAuthenticator()
+	if auth.Nonce() != req.Nonce() {
+		return shared.PrincipalKey{}, protocol.PAData{}, fmt.Errorf("%w: nonce", pkinit.ErrModified)
+	}
+
+	skew := e.clock.Now().Sub(auth.IssuedAt())
+	if skew < -e.maxSkew || skew > e.maxSkew {
+		return shared.PrincipalKey{}, protocol.PAData{}, fmt.Errorf("%w: pk-authenticator", shared.ErrClockSkew)
+	}
+
+	if err := e.replayCache.Check(req.Client().String(), auth.IssuedAt()); err != nil {
+		e.logger.Warn("replayed pk-authenticator", "client", req.Client(), "timestamp", auth.IssuedAt())
+		return shared.PrincipalKey{}, protocol.PAData{}, err
+	}
+
+	i := slices.IndexFunc(crypto.SupportedEncTypes, func(etype protocol.EncType) bool {
+		return slices.Contains(req.ETypes(), etype)
+	})
+	if i < 0 {
+		return shared.PrincipalKey{}, protocol.PAData{}, fmt.Errorf("%w: no reply key of %v", protocol.KDCErrETypeNoSupp, req.ETypes())
+	}
+
+	rep, replyKey, err := pkinit.NewReply(c, e.cfg.PKINIT.Certificate, pack, crypto.SupportedEncTypes[i])
+	if err != nil {
+		return shared.PrincipalKey{}, protocol.PAData{}, err
+	}
+	return shared.PrincipalKey{Key: replyKey}, rep, nil
+}
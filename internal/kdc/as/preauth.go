@@ -0,0 +1,117 @@
+package as
+
+import (
+	"fmt"
+
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// preauthenticate verifies the pre-authentication of req and picks the key
+// its reply is sealed under. It returns the key with the padata that tells
+// the client how to get it: the KDC's half of the key agreement if the
+// client used its certificate, how its key was derived from its password
+// otherwise, so that a client which guessed can check its guess.
+func (e *Exchange) preauthenticate(
+	c codec.Codec,
+	req protocol.ASReq,
+	client shared.PrincipalEntry,
+) (shared.PrincipalKey, protocol.PAData, error) {
+	if pa, ok := req.PAData().Find(protocol.PATypePKASReq); ok {
+		return e.verifyPKINIT(c, req, pa)
+	}
+
+	// The reply is sealed under the strongest client key the client can use.
+	replyKey, err := client.Negotiate(req.ETypes())
+	if err != nil {
+		return shared.PrincipalKey{}, protocol.PAData{}, err
+	}
+
+	if err := e.verifyPreauth(c, req, client, replyKey); err != nil {
+		return shared.PrincipalKey{}, protocol.PAData{}, err
+	}
+
+	etypeInfo, err := shared.NewETypeInfo2(c, req.Client(), replyKey)
+	if err != nil {
+		return shared.PrincipalKey{}, protocol.PAData{}, err
+	}
+	return replyKey, etypeInfo, nil
+}
+
+// verifyPreauth checks the PA-ENC-TIMESTAMP carried by req. The timestamp
+// must decrypt under one of the client's long-term keys, fall within the
+// allowed clock skew and not have been seen before. replyKey is the key the
+// client is asked to use when it sent none, described in c.
+func (e *Exchange) verifyPreauth(
+	c codec.Codec,
+	req protocol.ASReq,
+	client shared.PrincipalEntry,
+	replyKey shared.PrincipalKey,
+) error {
+	pa, ok := req.PAData().Find(protocol.PATypeEncTimestamp)
+	if !ok {
+		return e.preauthRequired(c, req.Client(), replyKey)
+	}
+
+	var enc protocol.EncryptedData
+	if err := shared.DecodePAData(pa, &enc); err != nil {
+		return fmt.Errorf("%w: malformed encrypted timestamp: %v", protocol.ErrPreauthFailed, err)
+	}
+
+	clientKey, err := client.KeyFor(enc)
+	if err != nil {
+		e.logger.Warn("pre-authentication failed", "client", req.Client(), "err", err)
+		return fmt.Errorf("%w: %v", protocol.ErrPreauthFailed, err)
+	}
+
+	ts, err := shared.DecryptEntity[protocol.PAEncTSEnc](clientKey.Key, crypto.KeyUsageASReqTimestamp, enc)
+	if err != nil {
+		e.logger.Warn("pre-authentication failed", "client", req.Client(), "err", err)
+		return protocol.ErrPreauthFailed
+	}
+
+	skew := e.clock.Now().Sub(ts.Timestamp())
+	if skew < -e.maxSkew || skew > e.maxSkew {
+		return fmt.Errorf("%w: pre-authentication timestamp", shared.ErrClockSkew)
+	}
+
+	if err := e.replayCache.Check(req.Client().String(), ts.Timestamp()); err != nil {
+		e.logger.Warn("replayed pre-authentication", "client", req.Client(), "timestamp", ts.Timestamp())
+		return err
+	}
+
+	return nil
+}
+
+// preauthRequired asks the client for a PA-ENC-TIMESTAMP under key, and
+// tells it how to derive that key from its password, that it may armor its
+// request with FAST and, if the realm has PKINIT, that it may use its
+// certificate instead.
+func (e *Exchange) preauthRequired(c codec.Codec, client protocol.Principal, key shared.PrincipalKey) error {
+	encTS, err := protocol.NewPAData(protocol.PATypeEncTimestamp, nil)
+	if err != nil {
+		return err
+	}
+
+	fast, err := protocol.NewPAData(protocol.PATypeFXFast, nil)
+	if err != nil {
+		return err
+	}
+
+	etypeInfo, err := shared.NewETypeInfo2(c, client, key)
+	if err != nil {
+		return err
+	}
+
+	methodData := protocol.MethodData{encTS, etypeInfo, fast}
+	if e.cfg.PKINIT != nil {
+		pkASReq, err := protocol.NewPAData(protocol.PATypePKASReq, nil)
+		if err != nil {
+			return err
+		}
+		methodData = append(methodData, pkASReq)
+	}
+	return protocol.NewPreauthRequiredError(methodData)
+}
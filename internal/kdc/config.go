@@ -3,10 +3,24 @@ package kdc
 import (
 	"time"
 
+	"github.com/rizesql/kerberos/internal/pkinit"
 	"github.com/rizesql/kerberos/internal/protocol"
 )
 
 type Config struct {
 	Realm          protocol.Realm
 	TicketLifetime time.Duration
+	// MaxRenewableLife bounds how far past issue a renewable ticket may be
+	// renewed. Zero disables renewable tickets.
+	MaxRenewableLife time.Duration
+	// TransitRealms are the realms trusted as intermediate hops on a
+	// cross-realm path. Tickets that transited any other realm are refused.
+	TransitRealms []protocol.Realm
+	// NextHops routes referrals toward realms this KDC shares no key with:
+	// a request for a service in realm R is referred to NextHops[R].
+	NextHops map[protocol.Realm]protocol.Realm
+	// PKINIT lets clients pre-authenticate with certificates issued by its
+	// CAs, and holds the certificate the KDC signs its replies with. Nil
+	// disables PKINIT.
+	PKINIT *pkinit.Config
 }
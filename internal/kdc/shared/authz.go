@@ -0,0 +1,120 @@
+package shared
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/kdb"
+	"github.com/rizesql/kerberos/internal/o11y/logging"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+var ErrInvalidAuthzData = errors.New("authorization data signature invalid")
+
+// FetchClaims builds the claims of p, a principal of this realm, from its
+// group memberships.
+func FetchClaims(
+	ctx context.Context,
+	db kdb.Database,
+	p protocol.Principal,
+	authTime time.Time,
+) (protocol.Claims, error) {
+	groups, err := kdb.Query.ListPrincipalGroups(ctx, db, kdb.ListPrincipalGroupsParams{
+		PrimaryName: string(p.Primary()),
+		Instance:    string(p.Instance()),
+		Realm:       string(p.Realm()),
+	})
+	if err != nil {
+		return protocol.Claims{}, fmt.Errorf("failed to list groups of %s: %w", p, err)
+	}
+
+	return protocol.NewClaims(groups, authTime)
+}
+
+// KDCKey is the key the KDC checksum of a ticket for server is made with. A
+// TGT is only ever read back by a KDC holding its key, so that key is used;
+// any other ticket is signed with the krbtgt key of realm.
+func KDCKey(
+	ctx context.Context,
+	db kdb.Database,
+	logger *logging.Logger,
+	realm protocol.Realm,
+	server protocol.Principal,
+	serverKey protocol.SessionKey,
+) (protocol.SessionKey, error) {
+	if server.IsKrbtgt() {
+		return serverKey, nil
+	}
+
+	krbtgt, err := protocol.NewKrbtgt(realm)
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+	key, err := FetchPrincipalKey(ctx, db, logger, krbtgt)
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+	return key.Key, nil
+}
+
+// SignClaims issues claims as authorization data for a ticket of client
+// whose server holds serverKey.
+func SignClaims(client protocol.Principal, claims protocol.Claims, serverKey, kdcKey protocol.SessionKey) (protocol.AuthorizationData, error) {
+	data, err := signedClaims(client, claims)
+	if err != nil {
+		return protocol.AuthorizationData{}, err
+	}
+
+	serverCksum, err := crypto.Checksum(serverKey, crypto.KeyUsageADKDCIssuedCksum, data)
+	if err != nil {
+		return protocol.AuthorizationData{}, err
+	}
+
+	kdcCksum, err := crypto.Checksum(kdcKey, crypto.KeyUsageADKDCIssuedCksum, serverCksum.Value())
+	if err != nil {
+		return protocol.AuthorizationData{}, err
+	}
+
+	return protocol.NewAuthorizationData(claims, serverCksum, kdcCksum)
+}
+
+// VerifyServerChecksum checks that the claims of ad were issued for a ticket
+// of client whose server holds serverKey.
+func VerifyServerChecksum(ad protocol.AuthorizationData, client protocol.Principal, serverKey protocol.SessionKey) error {
+	data, err := signedClaims(client, ad.Claims())
+	if err != nil {
+		return err
+	}
+
+	if err := crypto.VerifyChecksum(serverKey, crypto.KeyUsageADKDCIssuedCksum, data, ad.ServerChecksum()); err != nil {
+		return fmt.Errorf("%w: server checksum: %w", ErrInvalidAuthzData, err)
+	}
+	return nil
+}
+
+// VerifyKDCChecksum checks both checksums of ad, so that claims the KDC is
+// about to copy into a new ticket of client are known to be its own.
+func VerifyKDCChecksum(ad protocol.AuthorizationData, client protocol.Principal, serverKey, kdcKey protocol.SessionKey) error {
+	if err := VerifyServerChecksum(ad, client, serverKey); err != nil {
+		return err
+	}
+
+	if err := crypto.VerifyChecksum(kdcKey, crypto.KeyUsageADKDCIssuedCksum, ad.ServerChecksum().Value(), ad.KDCChecksum()); err != nil {
+		return fmt.Errorf("%w: KDC checksum: %w", ErrInvalidAuthzData, err)
+	}
+	return nil
+}
+
+// signedClaims is what the checksums of claims cover: the claims together
+// with the client they are issued to, so that they cannot be moved onto a
+// ticket of another client.
+func signedClaims(client protocol.Principal, claims protocol.Claims) ([]byte, error) {
+	return json.Marshal(struct {
+		Client protocol.Principal `json:"client"`
+		Claims protocol.Claims    `json:"claims"`
+	}{client, claims})
+}
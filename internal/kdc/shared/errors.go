@@ -0,0 +1,118 @@
+package shared
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/pkinit"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/rizesql/kerberos/internal/replay"
+)
+
+var (
+	ErrInvalidTicket        = errors.New("invalid ticket")
+	ErrInvalidAuthenticator = errors.New("invalid authenticator")
+	ErrClientMismatch       = errors.New("client mismatch")
+	ErrTicketExpired        = errors.New("ticket expired")
+	ErrMissingChecksum      = errors.New("authenticator carries no checksum of the request")
+	ErrModified             = errors.New("request modified")
+)
+
+// ErrorCode maps an exchange error to the KRB-ERROR code reported to the
+// client. Codes wrapped into err take precedence over the sentinel table.
+func ErrorCode(err error) protocol.ErrorCode {
+	var code protocol.ErrorCode
+	if errors.As(err, &code) {
+		return code
+	}
+
+	switch {
+	case errors.Is(err, ErrPrincipalNotFound):
+		return protocol.KDCErrCPrincipalUnknown
+	case errors.Is(err, ErrWrongRealm):
+		return protocol.KDCErrWrongRealm
+	case errors.Is(err, protocol.ErrPreauthRequired):
+		return protocol.KDCErrPreauthRequired
+	case errors.Is(err, protocol.ErrPreauthFailed), errors.Is(err, pkinit.ErrMalformed):
+		return protocol.KDCErrPreauthFailed
+	case errors.Is(err, ErrClockSkew):
+		return protocol.KRBAPErrSkew
+	case errors.Is(err, replay.ErrReplayDetected):
+		return protocol.KRBAPErrRepeat
+	case errors.Is(err, ErrInvalidTicket), errors.Is(err, ErrInvalidAuthenticator),
+		errors.Is(err, ErrInvalidArmor):
+		return protocol.KRBAPErrBadIntegrity
+	case errors.Is(err, ErrClientMismatch):
+		return protocol.KRBAPErrBadMatch
+	case errors.Is(err, ErrMissingChecksum):
+		return protocol.KRBAPErrInappCksum
+	case errors.Is(err, pkinit.ErrUntrusted):
+		return protocol.KDCErrCantVerifyCertificate
+	case errors.Is(err, pkinit.ErrInvalidCertificate), errors.Is(err, pkinit.ErrUnsupportedKey):
+		return protocol.KDCErrInvalidCertificate
+	case errors.Is(err, pkinit.ErrInvalidSignature):
+		return protocol.KDCErrInvalidSig
+	case errors.Is(err, pkinit.ErrNoPrincipal):
+		return protocol.KDCErrClientNameMismatch
+	case errors.Is(err, pkinit.ErrKeyParameters):
+		return protocol.KDCErrDHKeyParametersNotAccepted
+	case errors.Is(err, ErrModified), errors.Is(err, ErrInvalidAuthzData),
+		errors.Is(err, pkinit.ErrModified):
+		return protocol.KRBAPErrModified
+	case errors.Is(err, ErrTicketExpired):
+		return protocol.KRBAPErrTktExpired
+	default:
+		return protocol.KRBErrGeneric
+	}
+}
+
+// NewKRBError builds the KRB-ERROR reply for err. Pre-authentication hints
+// are carried to the client as e-data, encoded with c. The error of a FAST
+// exchange travels sealed under its armor, with only the code in the clear.
+func NewKRBError(c codec.Codec, err error, realm protocol.Realm, now time.Time) protocol.KRBError {
+	code := ErrorCode(err)
+
+	krbErr, _ := protocol.NewKRBError(code, now.UTC(), realm, err.Error())
+
+	var methodData protocol.MethodData
+	var preauthErr *protocol.PreauthRequiredError
+	if errors.As(err, &preauthErr) {
+		methodData = preauthErr.MethodData()
+	}
+
+	var fastErr *FastError
+	if errors.As(err, &fastErr) {
+		protected, pErr := protectError(c, fastErr.Armor, krbErr, methodData)
+		if pErr != nil {
+			// Never fall back to the error in the clear.
+			generic, _ := protocol.NewKRBError(protocol.KRBErrGeneric, now.UTC(), realm, "")
+			return generic
+		}
+		return protected
+	}
+
+	if preauthErr != nil {
+		if eData, mErr := c.Marshal(methodData); mErr == nil {
+			krbErr = krbErr.WithEData(eData)
+		}
+	}
+
+	return krbErr
+}
+
+// HTTPStatus is the status a KRB-ERROR is sent with over HTTP.
+func HTTPStatus(code protocol.ErrorCode) int {
+	switch code {
+	case protocol.KDCErrCPrincipalUnknown, protocol.KDCErrSPrincipalUnknown:
+		return http.StatusNotFound
+	case protocol.KDCErrWrongRealm, protocol.KDCErrBadOption, protocol.KDCErrETypeNoSupp,
+		protocol.KDCErrCannotPostdate, protocol.KDCErrPolicy, protocol.KRBAPErrMsgType:
+		return http.StatusBadRequest
+	case protocol.KRBErrGeneric:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusUnauthorized
+	}
+}
@@ -0,0 +1,404 @@
+package shared
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+var (
+	ErrInvalidArmor = errors.New("invalid FAST armor")
+	ErrFastReply    = errors.New("invalid FAST reply")
+)
+
+// Armor is what a FAST exchange is protected with: the armor key both ends
+// derive, and the nonce of the request, which every protected reply echoes.
+type Armor struct {
+	Key   protocol.SessionKey
+	Nonce protocol.Nonce
+}
+
+// ArmorKey derives the armor key from the subkey of an armor authenticator
+// and the session key of the ticket it was sealed under (RFC 6113 §5.4.1.1).
+func ArmorKey(subkey, ticketKey protocol.SessionKey) (protocol.SessionKey, error) {
+	return crypto.CF2(subkey, ticketKey, "subkeyarmor", "ticketarmor")
+}
+
+// StrengthenReplyKey mixes the strengthen key the KDC chose into the reply
+// key of an AS exchange (RFC 6113 §5.4.3).
+func StrengthenReplyKey(strengthenKey, replyKey protocol.SessionKey) (protocol.SessionKey, error) {
+	return crypto.CF2(strengthenKey, replyKey, "strengthenkey", "replykey")
+}
+
+// FastError is an error of an exchange armored with FAST. The KDC returns it
+// sealed under the armor, with only its code in the clear.
+type FastError struct {
+	Armor Armor
+	Err   error
+}
+
+func NewFastError(armor Armor, err error) error {
+	return &FastError{Armor: armor, Err: err}
+}
+
+func (e *FastError) Error() string { return e.Err.Error() }
+func (e *FastError) Unwrap() error { return e.Err }
+
+// ASReqBody encodes the body of req as c encodes the request, which is what
+// the checksum of a FAST AS-REQ covers.
+func ASReqBody(c codec.Codec, req protocol.ASReq) ([]byte, error) {
+	if c == codec.DER {
+		return req.BodyDER()
+	}
+	return req.Body()
+}
+
+// TGSReqAPReq encodes the AP-REQ of req with c. A FAST TGS-REQ checksums it
+// rather than the body, which the authenticator already binds.
+func TGSReqAPReq(c codec.Codec, req protocol.TGSReq) ([]byte, error) {
+	apReq, err := protocol.NewAPReq(req.TGT(), req.Authenticator())
+	if err != nil {
+		return nil, err
+	}
+	return c.Marshal(apReq)
+}
+
+// OpenFastReq checks that armored binds checksummed, the clear part of the
+// request it arrived in, and opens the FastReq it seals.
+func OpenFastReq(armorKey protocol.SessionKey, armored protocol.FastArmoredReq, checksummed []byte) (protocol.FastReq, error) {
+	if err := crypto.VerifyChecksum(armorKey, crypto.KeyUsageFastReqChksum, checksummed, armored.Checksum()); err != nil {
+		return protocol.FastReq{}, fmt.Errorf("%w: %w", ErrModified, err)
+	}
+
+	req, err := DecryptEntity[protocol.FastReq](armorKey, crypto.KeyUsageFastEnc, armored.EncFastReq())
+	if err != nil {
+		return protocol.FastReq{}, fmt.Errorf("%w: %w", ErrInvalidArmor, err)
+	}
+
+	if critical := req.Options().Critical(); critical != 0 {
+		return protocol.FastReq{}, fmt.Errorf("%w: %#x", protocol.KDCErrUnknownCriticalFastOptions, uint32(critical))
+	}
+	return req, nil
+}
+
+// ArmorReply protects rep, a reply to a FAST request: padata moves into a
+// FastResponse sealed under the armor key, together with strengthenKey, if
+// the reply key was strengthened, and a checksum binding the ticket in the
+// clear. rep is left carrying only the PA-FX-FAST.
+func ArmorReply(
+	c codec.Codec,
+	armor Armor,
+	rep protocol.ASRep,
+	padata protocol.MethodData,
+	strengthenKey protocol.SessionKey,
+	now time.Time,
+) (protocol.ASRep, error) {
+	ticket, err := ticketBytes(c, rep.Ticket())
+	if err != nil {
+		return protocol.ASRep{}, err
+	}
+
+	cksum, err := crypto.Checksum(armor.Key, crypto.KeyUsageFastFinished, ticket)
+	if err != nil {
+		return protocol.ASRep{}, err
+	}
+
+	finished, err := protocol.NewFastFinished(now, rep.Client(), cksum)
+	if err != nil {
+		return protocol.ASRep{}, err
+	}
+
+	res, err := protocol.NewFastResponse(armor.Nonce)
+	if err != nil {
+		return protocol.ASRep{}, err
+	}
+
+	res = res.WithPAData(padata...).WithFinished(finished)
+	if !strengthenKey.IsZero() {
+		res = res.WithStrengthenKey(strengthenKey)
+	}
+
+	pa, err := sealFastResponse(c, armor.Key, res)
+	if err != nil {
+		return protocol.ASRep{}, err
+	}
+	return rep.WithPAData(pa), nil
+}
+
+// protectError seals krbErr and methodData, its pre-authentication hints,
+// under the armor. The error returned in their place keeps only the code.
+func protectError(c codec.Codec, armor Armor, krbErr protocol.KRBError, methodData protocol.MethodData) (protocol.KRBError, error) {
+	inner, err := c.Marshal(krbErr)
+	if err != nil {
+		return protocol.KRBError{}, err
+	}
+
+	fxError, err := protocol.NewPAData(protocol.PATypeFXError, inner)
+	if err != nil {
+		return protocol.KRBError{}, err
+	}
+
+	res, err := protocol.NewFastResponse(armor.Nonce)
+	if err != nil {
+		return protocol.KRBError{}, err
+	}
+
+	padata := append(protocol.MethodData{fxError}, methodData...)
+	pa, err := sealFastResponse(c, armor.Key, res.WithPAData(padata...))
+	if err != nil {
+		return protocol.KRBError{}, err
+	}
+
+	eData, err := c.Marshal(protocol.MethodData{pa})
+	if err != nil {
+		return protocol.KRBError{}, err
+	}
+
+	outer, err := protocol.NewKRBError(krbErr.Code(), krbErr.ServerTime(), krbErr.Realm(), "")
+	if err != nil {
+		return protocol.KRBError{}, err
+	}
+	return outer.WithEData(eData), nil
+}
+
+func sealFastResponse(c codec.Codec, armorKey protocol.SessionKey, res protocol.FastResponse) (protocol.PAData, error) {
+	enc, err := EncryptEntity(c, armorKey, crypto.KeyUsageFastRep, res)
+	if err != nil {
+		return protocol.PAData{}, err
+	}
+
+	value, err := c.Marshal(protocol.NewFastArmoredRep(enc))
+	if err != nil {
+		return protocol.PAData{}, err
+	}
+	return protocol.NewPAData(protocol.PATypeFXFast, value)
+}
+
+// ticketBytes is the encoding of ticket that the finished checksum covers:
+// the Ticket of a DER reply, or the ticket field of a JSON one.
+func ticketBytes(c codec.Codec, ticket protocol.EncryptedData) ([]byte, error) {
+	if c == codec.DER {
+		return ticket.MarshalTicketDER()
+	}
+	return c.Marshal(ticket)
+}
+
+// NewFastArmor builds the armor of an AS-REQ from a TGT the client holds,
+// such as a host's: an AP-REQ for the TGT, encoded with c, whose
+// authenticator carries a fresh subkey. It returns the armor key with it.
+func NewFastArmor(
+	c codec.Codec,
+	tgt protocol.EncryptedData,
+	tgtSessionKey protocol.SessionKey,
+	client protocol.Principal,
+	addr protocol.Address,
+	now time.Time,
+) (protocol.FastArmor, protocol.SessionKey, error) {
+	subkey, err := crypto.NewKeyGenerator().Generate(tgtSessionKey.EncType())
+	if err != nil {
+		return protocol.FastArmor{}, protocol.SessionKey{}, err
+	}
+
+	auth, err := protocol.NewAuthenticator(client, addr, now)
+	if err != nil {
+		return protocol.FastArmor{}, protocol.SessionKey{}, err
+	}
+
+	enc, err := EncryptEntity(c, tgtSessionKey, crypto.KeyUsageAPReqAuth, auth.WithSubkey(subkey))
+	if err != nil {
+		return protocol.FastArmor{}, protocol.SessionKey{}, err
+	}
+
+	apReq, err := protocol.NewAPReq(tgt, enc)
+	if err != nil {
+		return protocol.FastArmor{}, protocol.SessionKey{}, err
+	}
+
+	value, err := c.Marshal(apReq)
+	if err != nil {
+		return protocol.FastArmor{}, protocol.SessionKey{}, err
+	}
+
+	armor, err := protocol.NewFastArmor(protocol.FastArmorAPRequest, value)
+	if err != nil {
+		return protocol.FastArmor{}, protocol.SessionKey{}, err
+	}
+
+	armorKey, err := ArmorKey(subkey, tgtSessionKey)
+	if err != nil {
+		return protocol.FastArmor{}, protocol.SessionKey{}, err
+	}
+	return armor, armorKey, nil
+}
+
+// ArmorASReq wraps req in a FAST request armored with armor. Its
+// pre-authentication data and body travel sealed under armorKey; the request
+// in the clear carries only the PA-FX-FAST. c is the codec the request will
+// be sent with.
+func ArmorASReq(c codec.Codec, req protocol.ASReq, armor protocol.FastArmor, armorKey protocol.SessionKey) (protocol.ASReq, error) {
+	enc, err := EncryptEntity(c, armorKey, crypto.KeyUsageFastEnc, protocol.NewASFastReq(req))
+	if err != nil {
+		return protocol.ASReq{}, err
+	}
+
+	outer := req.WithPAData()
+	body, err := ASReqBody(c, outer)
+	if err != nil {
+		return protocol.ASReq{}, err
+	}
+
+	cksum, err := crypto.Checksum(armorKey, crypto.KeyUsageFastReqChksum, body)
+	if err != nil {
+		return protocol.ASReq{}, err
+	}
+
+	pa, err := fastPAData(c, cksum, enc, &armor)
+	if err != nil {
+		return protocol.ASReq{}, err
+	}
+	return outer.WithPAData(pa), nil
+}
+
+// ArmorTGSReq wraps req, whose authenticator is sealed and carries the
+// subkey armorKey was derived from, in a FAST request. The TGT is its own
+// armor, so none is sent.
+func ArmorTGSReq(c codec.Codec, req protocol.TGSReq, armorKey protocol.SessionKey) (protocol.TGSReq, error) {
+	enc, err := EncryptEntity(c, armorKey, crypto.KeyUsageFastEnc, protocol.NewTGSFastReq(req))
+	if err != nil {
+		return protocol.TGSReq{}, err
+	}
+
+	apReq, err := TGSReqAPReq(c, req)
+	if err != nil {
+		return protocol.TGSReq{}, err
+	}
+
+	cksum, err := crypto.Checksum(armorKey, crypto.KeyUsageFastReqChksum, apReq)
+	if err != nil {
+		return protocol.TGSReq{}, err
+	}
+
+	pa, err := fastPAData(c, cksum, enc, nil)
+	if err != nil {
+		return protocol.TGSReq{}, err
+	}
+	return req.WithPAData(pa), nil
+}
+
+func fastPAData(c codec.Codec, cksum protocol.Checksum, enc protocol.EncryptedData, armor *protocol.FastArmor) (protocol.PAData, error) {
+	armored, err := protocol.NewFastArmoredReq(cksum, enc)
+	if err != nil {
+		return protocol.PAData{}, err
+	}
+	if armor != nil {
+		armored = armored.WithArmor(*armor)
+	}
+
+	value, err := c.Marshal(armored)
+	if err != nil {
+		return protocol.PAData{}, err
+	}
+	return protocol.NewPAData(protocol.PATypeFXFast, value)
+}
+
+// OpenFastReply opens the FastResponse protecting rep, the reply to a FAST
+// request with nonce, and checks that it vouches for the ticket in the
+// clear.
+func OpenFastReply(armorKey protocol.SessionKey, rep protocol.ASRep, nonce protocol.Nonce) (protocol.FastResponse, error) {
+	res, c, err := openFastResponse(armorKey, rep.PAData(), nonce)
+	if err != nil {
+		return protocol.FastResponse{}, err
+	}
+
+	finished, ok := res.Finished()
+	if !ok {
+		return protocol.FastResponse{}, fmt.Errorf("%w: no finished", ErrFastReply)
+	}
+
+	ticket, err := ticketBytes(c, rep.Ticket())
+	if err != nil {
+		return protocol.FastResponse{}, err
+	}
+
+	if err := crypto.VerifyChecksum(armorKey, crypto.KeyUsageFastFinished, ticket, finished.TicketChecksum()); err != nil {
+		return protocol.FastResponse{}, fmt.Errorf("%w: ticket checksum: %w", ErrFastReply, err)
+	}
+
+	if client := rep.Client(); client != (protocol.Principal{}) && client != finished.Client() {
+		return protocol.FastResponse{}, fmt.Errorf("%w: reply names %s, KDC issued to %s", ErrFastReply, client, finished.Client())
+	}
+	return res, nil
+}
+
+// OpenFastError opens the KRB-ERROR that krbErr, the reply to a FAST request
+// with nonce, protects. The hints the KDC sealed with it become its e-data,
+// as they are of an unprotected error.
+func OpenFastError(armorKey protocol.SessionKey, krbErr protocol.KRBError, nonce protocol.Nonce) (protocol.KRBError, error) {
+	md, err := krbErr.MethodData()
+	if err != nil {
+		return protocol.KRBError{}, fmt.Errorf("%w: %w", ErrFastReply, err)
+	}
+
+	res, c, err := openFastResponse(armorKey, md, nonce)
+	if err != nil {
+		return protocol.KRBError{}, err
+	}
+
+	var inner protocol.KRBError
+	var hints protocol.MethodData
+	found := false
+	for _, pa := range res.PAData() {
+		if pa.Type() != protocol.PATypeFXError {
+			hints = append(hints, pa)
+			continue
+		}
+		if err := DecodePAData(pa, &inner); err != nil {
+			return protocol.KRBError{}, fmt.Errorf("%w: %w", ErrFastReply, err)
+		}
+		found = true
+	}
+	if !found {
+		return protocol.KRBError{}, fmt.Errorf("%w: no PA-FX-ERROR", ErrFastReply)
+	}
+
+	if len(hints) > 0 {
+		eData, err := c.Marshal(hints)
+		if err != nil {
+			return protocol.KRBError{}, err
+		}
+		inner = inner.WithEData(eData)
+	}
+	return inner, nil
+}
+
+// openFastResponse opens the FastResponse in the PA-FX-FAST of padata and
+// reports the codec it was encoded with.
+func openFastResponse(
+	armorKey protocol.SessionKey,
+	padata protocol.MethodData,
+	nonce protocol.Nonce,
+) (protocol.FastResponse, codec.Codec, error) {
+	pa, ok := padata.Find(protocol.PATypeFXFast)
+	if !ok {
+		return protocol.FastResponse{}, nil, fmt.Errorf("%w: no PA-FX-FAST", ErrFastReply)
+	}
+
+	var armored protocol.FastArmoredRep
+	if err := DecodePAData(pa, &armored); err != nil {
+		return protocol.FastResponse{}, nil, fmt.Errorf("%w: %w", ErrFastReply, err)
+	}
+
+	res, err := DecryptEntity[protocol.FastResponse](armorKey, crypto.KeyUsageFastRep, armored.EncFastRep())
+	if err != nil {
+		return protocol.FastResponse{}, nil, fmt.Errorf("%w: %w", ErrFastReply, err)
+	}
+
+	if res.Nonce() != nonce {
+		return protocol.FastResponse{}, nil, fmt.Errorf("%w: nonce mismatch", ErrFastReply)
+	}
+	return res, codec.Detect(pa.Value()), nil
+}
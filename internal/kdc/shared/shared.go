@@ -1,10 +1,15 @@
 package shared
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
+	"time"
 
+	"github.com/rizesql/kerberos/internal/codec"
 	"github.com/rizesql/kerberos/internal/crypto"
 	"github.com/rizesql/kerberos/internal/kdb"
 	"github.com/rizesql/kerberos/internal/o11y/logging"
@@ -14,14 +19,43 @@ import (
 var (
 	ErrPrincipalNotFound = errors.New("principal not found")
 	ErrWrongRealm        = errors.New("request for wrong realm")
+	ErrClockSkew         = errors.New("clock skew too great")
+	ErrEncTypeMismatch   = errors.New("data was sealed with another encryption type than the key's")
 )
 
-func FetchPrincipalKey(
+// PrincipalKey is one of the long-term keys of a principal.
+type PrincipalKey struct {
+	Key  protocol.SessionKey
+	Kvno uint32
+	// Salt is what the key was derived from a password with, if it was.
+	Salt string
+	// Params are the string-to-key parameters it was derived with; nil for
+	// the defaults of its encryption type.
+	Params []byte
+}
+
+// PrincipalEntry is what the KDC database holds about a principal.
+type PrincipalEntry struct {
+	// Kvno is the current version of the principal's key.
+	Kvno uint32
+	// Keys holds the keys of the encryption types the KDC supports, newest
+	// version first and, within a version, strongest first. Older versions
+	// still open tickets issued before the key was changed.
+	Keys []PrincipalKey
+	// MaxLife and MaxRenewableLife bound the tickets issued to or for the
+	// principal. Zero leaves the realm default.
+	MaxLife          time.Duration
+	MaxRenewableLife time.Duration
+	// Flags are the principal's policy flags.
+	Flags kdb.PrincipalFlag
+}
+
+func FetchPrincipal(
 	ctx context.Context,
 	db kdb.Database,
 	logger *logging.Logger,
 	p protocol.Principal,
-) (protocol.SessionKey, error) {
+) (PrincipalEntry, error) {
 	row, err := kdb.Query.GetPrincipal(ctx, db, kdb.GetPrincipalParams{
 		PrimaryName: string(p.Primary()),
 		Instance:    string(p.Instance()),
@@ -29,38 +63,338 @@ func FetchPrincipalKey(
 	})
 	if err != nil {
 		logger.Warn("lookup failed", "principal", p, "err", err)
-		return protocol.SessionKey{}, ErrPrincipalNotFound
+		return PrincipalEntry{}, ErrPrincipalNotFound
+	}
+
+	rows, err := kdb.Query.ListKeys(ctx, db, kdb.ListKeysParams{
+		PrimaryName: string(p.Primary()),
+		Instance:    string(p.Instance()),
+		Realm:       string(p.Realm()),
+	})
+	if err != nil {
+		return PrincipalEntry{}, fmt.Errorf("failed to list keys of %s: %w", p, err)
+	}
+
+	keys := make([]PrincipalKey, 0, len(rows))
+	for _, k := range rows {
+		etype := protocol.EncType(k.Enctype)
+		if !slices.Contains(crypto.SupportedEncTypes, etype) {
+			continue
+		}
+
+		// Keys are stored sealed under the master key.
+		keyBytes, err := db.MasterKeys().Open(k.Mkvno, k.KeyBytes)
+		if err != nil {
+			return PrincipalEntry{}, fmt.Errorf("failed to open key of %s: %w", p, err)
+		}
+		key, err := protocol.NewSessionKey(keyBytes)
+		if err != nil {
+			return PrincipalEntry{}, err
+		}
+		keys = append(keys, PrincipalKey{
+			Key:    key.WithEncType(etype),
+			Kvno:   uint32(k.Kvno),
+			Salt:   k.Salt,
+			Params: k.S2kparams,
+		})
+	}
+	slices.SortStableFunc(keys, func(a, b PrincipalKey) int {
+		if a.Kvno != b.Kvno {
+			return cmp.Compare(b.Kvno, a.Kvno)
+		}
+		return cmp.Compare(
+			slices.Index(crypto.SupportedEncTypes, a.Key.EncType()),
+			slices.Index(crypto.SupportedEncTypes, b.Key.EncType()),
+		)
+	})
+
+	return PrincipalEntry{
+		Kvno:             uint32(row.Kvno),
+		Keys:             keys,
+		MaxLife:          time.Duration(row.MaxLife.Int64) * time.Second,
+		MaxRenewableLife: time.Duration(row.MaxRenewableLife.Int64) * time.Second,
+		Flags:            kdb.PrincipalFlag(row.Flags),
+	}, nil
+}
+
+// FetchPrincipalKey fetches the strongest current key of p.
+func FetchPrincipalKey(
+	ctx context.Context,
+	db kdb.Database,
+	logger *logging.Logger,
+	p protocol.Principal,
+) (PrincipalKey, error) {
+	entry, err := FetchPrincipal(ctx, db, logger, p)
+	if err != nil {
+		return PrincipalKey{}, err
+	}
+
+	return entry.Key()
+}
+
+// Key is the strongest key of the current version, which the KDC seals
+// tickets for the principal under.
+func (p PrincipalEntry) Key() (PrincipalKey, error) {
+	for _, key := range p.Keys {
+		if key.Kvno == p.Kvno {
+			return key, nil
+		}
+	}
+	return PrincipalKey{}, fmt.Errorf("%w: no key of version %d", protocol.KDCErrNullKey, p.Kvno)
+}
+
+// Negotiate picks the strongest current key of one of etypes, the
+// encryption types the other end supports.
+func (p PrincipalEntry) Negotiate(etypes []protocol.EncType) (PrincipalKey, error) {
+	for _, key := range p.Keys {
+		if key.Kvno == p.Kvno && slices.Contains(etypes, key.Key.EncType()) {
+			return key, nil
+		}
+	}
+	return PrincipalKey{}, fmt.Errorf("%w: no key of %v", protocol.KDCErrETypeNoSupp, etypes)
+}
+
+// KeyFor picks the key enc was sealed under, by its encryption type and, if
+// enc records one, its key version.
+func (p PrincipalEntry) KeyFor(enc protocol.EncryptedData) (PrincipalKey, error) {
+	kvno, hasKvno := enc.Kvno()
+	for _, key := range p.Keys {
+		if key.Key.EncType() == enc.EncType() && (!hasKvno || key.Kvno == kvno) {
+			return key, nil
+		}
+	}
+
+	if hasKvno {
+		return PrincipalKey{}, fmt.Errorf("%w: no %s key of version %d", protocol.KRBAPErrBadKeyVer, enc.EncType(), kvno)
 	}
+	return PrincipalKey{}, fmt.Errorf("%w: no %s key", protocol.KDCErrETypeNoSupp, enc.EncType())
+}
+
+// SessionEncType picks the encryption type of a new session key: the
+// strongest the KDC supports that is among etypes, those the client
+// supports, and that service has a current key of, so that both ends of the
+// ticket can use it.
+func SessionEncType(etypes []protocol.EncType, service PrincipalEntry) (protocol.EncType, error) {
+	for _, etype := range crypto.SupportedEncTypes {
+		if !slices.Contains(etypes, etype) {
+			continue
+		}
+		if _, err := service.Negotiate([]protocol.EncType{etype}); err == nil {
+			return etype, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: no session key type shared by %v and the service", protocol.KDCErrETypeNoSupp, etypes)
+}
 
-	return protocol.NewSessionKey(row.KeyBytes)
+// Limits bounds the validity of an issued ticket: its end time and, if
+// renewable, its renew-till, both counted from its start time.
+type Limits struct {
+	MaxLife          time.Duration
+	MaxRenewableLife time.Duration
+	// Flags are the principal's policy flags.
+	Flags kdb.PrincipalFlag
 }
 
-func EncryptEntity(key protocol.SessionKey, v json.Marshaler) (protocol.EncryptedData, error) {
-	b, err := v.MarshalJSON()
+// NewLimits starts from the realm defaults and tightens them with the limits
+// of each principal taking part in the exchange.
+func NewLimits(maxLife, maxRenewableLife time.Duration, entries ...PrincipalEntry) Limits {
+	limits := Limits{MaxLife: maxLife, MaxRenewableLife: maxRenewableLife}
+	for _, entry := range entries {
+		if entry.MaxLife > 0 && entry.MaxLife < limits.MaxLife {
+			limits.MaxLife = entry.MaxLife
+		}
+		if entry.MaxRenewableLife > 0 && entry.MaxRenewableLife < limits.MaxRenewableLife {
+			limits.MaxRenewableLife = entry.MaxRenewableLife
+		}
+	}
+	return limits
+}
+
+// EncryptEntity encodes v with c and seals it under key for usage.
+func EncryptEntity(c codec.Codec, key protocol.SessionKey, usage crypto.KeyUsage, v any) (protocol.EncryptedData, error) {
+	b, err := c.Marshal(v)
+	if err != nil {
+		return protocol.EncryptedData{}, err
+	}
+
+	enc, err := crypto.Encrypt(key, usage, b)
 	if err != nil {
 		return protocol.EncryptedData{}, err
 	}
 
-	enc, err := crypto.Encrypt(key, b)
+	data, err := protocol.NewEncryptedData(enc)
 	if err != nil {
 		return protocol.EncryptedData{}, err
 	}
+	return data.WithEncType(key.EncType()), nil
+}
 
-	return protocol.NewEncryptedData(enc)
+// EncryptForPrincipal seals v under a long-term key, recording its version so
+// that the principal knows which of its keys opens it. A key of no version,
+// such as a reply key agreed with PKINIT, records none.
+func EncryptForPrincipal(c codec.Codec, key PrincipalKey, usage crypto.KeyUsage, v any) (protocol.EncryptedData, error) {
+	enc, err := EncryptEntity(c, key.Key, usage, v)
+	if err != nil {
+		return protocol.EncryptedData{}, err
+	}
+	if key.Kvno == 0 {
+		return enc, nil
+	}
+	return enc.WithKvno(key.Kvno), nil
 }
 
-func DecryptEntity[T any](key protocol.SessionKey, enc protocol.EncryptedData) (T, error) {
+// DecryptEntity opens enc, sealed under key for usage, and decodes it, in
+// whichever encoding it was sealed.
+func DecryptEntity[T any](key protocol.SessionKey, usage crypto.KeyUsage, enc protocol.EncryptedData) (T, error) {
 	var zero T
 
-	bytes, err := crypto.Decrypt(key, enc.Ciphertext())
+	if enc.EncType() != key.EncType() {
+		return zero, fmt.Errorf("%w: %s, key is %s", ErrEncTypeMismatch, enc.EncType(), key.EncType())
+	}
+
+	bytes, err := crypto.Decrypt(key, usage, enc.Ciphertext())
 	if err != nil {
 		return zero, err
 	}
 
 	var v T
-	if err := json.Unmarshal(bytes, &v); err != nil {
+	if err := codec.Detect(bytes).Unmarshal(bytes, &v); err != nil {
 		return zero, err
 	}
 
 	return v, nil
 }
+
+// EncryptTicket seals ticket under the key of its server. The result names
+// the server, which a DER Ticket carries in the clear.
+func EncryptTicket(c codec.Codec, key PrincipalKey, ticket protocol.Ticket) (protocol.EncryptedData, error) {
+	enc, err := EncryptForPrincipal(c, key, crypto.KeyUsageTicket, ticket)
+	if err != nil {
+		return protocol.EncryptedData{}, err
+	}
+	return enc.WithServer(ticket.Server()), nil
+}
+
+// DecryptTicket opens a ticket sealed under key. The encrypted part of a DER
+// ticket does not name its server, so it is taken from the clear part.
+func DecryptTicket(key protocol.SessionKey, enc protocol.EncryptedData) (protocol.Ticket, error) {
+	ticket, err := DecryptEntity[protocol.Ticket](key, crypto.KeyUsageTicket, enc)
+	if err != nil {
+		return protocol.Ticket{}, err
+	}
+
+	if ticket.Server() == (protocol.Principal{}) {
+		server, ok := enc.Server()
+		if !ok {
+			return protocol.Ticket{}, protocol.ErrTicketInvalidServer
+		}
+		ticket = ticket.WithServer(server)
+	}
+	return ticket, nil
+}
+
+// DecodePAData decodes the value of a pre-authentication element, in
+// whichever encoding the client used.
+func DecodePAData(pa protocol.PAData, v any) error {
+	value := pa.Value()
+	return codec.Detect(value).Unmarshal(value, v)
+}
+
+// TGSReqBody encodes the body of req as c encodes the request, which is what
+// its authenticator checksums.
+func TGSReqBody(c codec.Codec, req protocol.TGSReq) ([]byte, error) {
+	if c == codec.DER {
+		return req.BodyDER()
+	}
+	return req.Body()
+}
+
+// NewEncTimestamp builds a PA-ENC-TIMESTAMP proving knowledge of key at ts,
+// encoded with c.
+func NewEncTimestamp(c codec.Codec, key protocol.SessionKey, ts time.Time) (protocol.PAData, error) {
+	plain, err := protocol.NewPAEncTSEnc(ts)
+	if err != nil {
+		return protocol.PAData{}, err
+	}
+
+	enc, err := EncryptEntity(c, key, crypto.KeyUsageASReqTimestamp, plain)
+	if err != nil {
+		return protocol.PAData{}, err
+	}
+
+	value, err := c.Marshal(enc)
+	if err != nil {
+		return protocol.PAData{}, err
+	}
+
+	return protocol.NewPAData(protocol.PATypeEncTimestamp, value)
+}
+
+// NewETypeInfo2 builds a PA-ETYPE-INFO2, encoded with c, telling client how
+// to derive key from its password. A key kept without its salt was derived
+// with the principal's default one.
+func NewETypeInfo2(c codec.Codec, client protocol.Principal, key PrincipalKey) (protocol.PAData, error) {
+	salt := key.Salt
+	if salt == "" {
+		salt = client.DefaultSalt()
+	}
+
+	entry := protocol.NewETypeInfo2Entry(key.Key.EncType()).
+		WithSalt(salt).
+		WithS2KParams(key.Params)
+	value, err := c.Marshal(protocol.ETypeInfo2{entry})
+	if err != nil {
+		return protocol.PAData{}, err
+	}
+
+	return protocol.NewPAData(protocol.PATypeETypeInfo2, value)
+}
+
+// NewForUser builds a PA-FOR-USER naming user, sealed under the session key
+// of the requesting service's TGT.
+func NewForUser(tgtSessionKey protocol.SessionKey, user protocol.Principal) (protocol.PAData, error) {
+	plain, err := protocol.NewPAForUser(user)
+	if err != nil {
+		return protocol.PAData{}, err
+	}
+
+	enc, err := EncryptEntity(codec.JSON, tgtSessionKey, crypto.KeyUsagePAForUser, plain)
+	if err != nil {
+		return protocol.PAData{}, err
+	}
+
+	value, err := json.Marshal(enc)
+	if err != nil {
+		return protocol.PAData{}, err
+	}
+
+	return protocol.NewPAData(protocol.PATypeForUser, value)
+}
+
+// SealTGSAuthenticator checksums the body of req under the TGT session key,
+// binds the checksum into auth and attaches the sealed authenticator to req.
+// c is the codec the request will be sent with. Call it once every other
+// field of the request is set.
+func SealTGSAuthenticator(
+	c codec.Codec,
+	req protocol.TGSReq,
+	tgtSessionKey protocol.SessionKey,
+	auth protocol.Authenticator,
+) (protocol.TGSReq, error) {
+	body, err := TGSReqBody(c, req)
+	if err != nil {
+		return protocol.TGSReq{}, err
+	}
+
+	cksum, err := crypto.Checksum(tgtSessionKey, crypto.KeyUsageTGSReqAuthCksum, body)
+	if err != nil {
+		return protocol.TGSReq{}, err
+	}
+
+	enc, err := EncryptEntity(c, tgtSessionKey, crypto.KeyUsageTGSReqAuth, auth.WithChecksum(cksum))
+	if err != nil {
+		return protocol.TGSReq{}, err
+	}
+
+	return req.WithAuthenticator(enc), nil
+}
@@ -0,0 +1,48 @@
+package tgs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// carryClaims copies the claims of tgt into issue once both of its
+// checksums are known to be the KDC's. A TGT is signed with the key it is
+// sealed under, which for a cross-realm TGT is the inter-realm key. The
+// claims of a client of another realm name groups of that realm, not of
+// this one, so they are dropped rather than signed as this KDC's own.
+func (e *Exchange) carryClaims(tgt protocol.Ticket, tgsKey protocol.SessionKey, issue issuance) (issuance, error) {
+	ad, ok := tgt.AuthorizationData()
+	if !ok || tgt.Client().Realm() != e.cfg.Realm {
+		return issue, nil
+	}
+
+	if err := shared.VerifyKDCChecksum(ad, tgt.Client(), tgsKey, tgsKey); err != nil {
+		return issuance{}, err
+	}
+
+	claims := ad.Claims()
+	issue.claims = &claims
+	return issue, nil
+}
+
+// authorizationData signs the claims of issue for the ticket to server.
+func (e *Exchange) authorizationData(
+	ctx context.Context,
+	server protocol.Principal,
+	serverKey protocol.SessionKey,
+	issue issuance,
+) (protocol.AuthorizationData, error) {
+	if issue.claims == nil {
+		return protocol.AuthorizationData{}, nil
+	}
+
+	kdcKey, err := shared.KDCKey(ctx, e.db, e.logger, e.cfg.Realm, server, serverKey)
+	if err != nil {
+		return protocol.AuthorizationData{}, fmt.Errorf("%w: failed to fetch KDC key: %w", protocol.KRBErrGeneric, err)
+	}
+
+	return shared.SignClaims(issue.client, *issue.claims, serverKey, kdcKey)
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/rizesql/kerberos/internal/clock"
+	"github.com/rizesql/kerberos/internal/codec"
 	"github.com/rizesql/kerberos/internal/crypto"
 	"github.com/rizesql/kerberos/internal/kdb"
 	"github.com/rizesql/kerberos/internal/kdc"
@@ -22,6 +23,7 @@ type Exchange struct {
 	keygen      crypto.KeyGenerator
 	replayCache replay.Cache
 	cfg         kdc.Config
+	maxSkew     time.Duration
 }
 
 func NewExchange(platform *kdc.Platform, cfg kdc.Config) *Exchange {
@@ -32,81 +34,214 @@ func NewExchange(platform *kdc.Platform, cfg kdc.Config) *Exchange {
 		keygen:      platform.KeyGenerator,
 		replayCache: platform.ReplayCache,
 		cfg:         cfg,
+		maxSkew:     5 * time.Minute,
 	}
 }
 
 func (e *Exchange) Handle(ctx context.Context, req protocol.TGSReq) (protocol.TGSRep, error) {
 	now := e.clock.Now().UTC()
 
-	tgsPrincipal, err := protocol.NewKrbtgt(e.cfg.Realm)
+	tgsPrincipal, err := e.tgsPrincipal(req)
 	if err != nil {
 		return protocol.TGSRep{}, fmt.Errorf("failed to create TGS principal: %w", err)
 	}
 
-	tgsKey, err := shared.FetchPrincipalKey(ctx, e.db, e.logger, tgsPrincipal)
+	tgsEntry, err := shared.FetchPrincipal(ctx, e.db, e.logger, tgsPrincipal)
+	switch {
+	case err != nil && tgsPrincipal.Realm() != e.cfg.Realm:
+		return protocol.TGSRep{}, fmt.Errorf("%w: no trust with realm %s", protocol.KDCErrPolicy, tgsPrincipal.Realm())
+	case err != nil:
+		return protocol.TGSRep{}, fmt.Errorf("%w: failed to fetch TGS key: %w", protocol.KRBErrGeneric, err)
+	}
+
+	// The TGT may have been issued under an older version of the key.
+	tgsKey, err := tgsEntry.KeyFor(req.TGT())
 	if err != nil {
-		return protocol.TGSRep{}, fmt.Errorf("failed to fetch TGS key: %w", err)
+		return protocol.TGSRep{}, err
 	}
 
-	tgt, err := shared.DecryptEntity[protocol.Ticket](tgsKey, req.TGT())
+	tgt, err := shared.DecryptTicket(tgsKey.Key, req.TGT())
 	if err != nil {
 		e.logger.Warn("failed to decrypt TGT", "err", err)
-		return protocol.TGSRep{}, fmt.Errorf("invalid TGT")
+		return protocol.TGSRep{}, fmt.Errorf("%w: invalid TGT", shared.ErrInvalidTicket)
+	}
+	if tgt.Server() != tgsPrincipal {
+		return protocol.TGSRep{}, fmt.Errorf("%w: TGT is for %s, not %s", shared.ErrInvalidTicket, tgt.Server(), tgsPrincipal)
+	}
+	if err := e.checkIssuer(req, tgt); err != nil {
+		return protocol.TGSRep{}, err
 	}
 
-	auth, err := shared.DecryptEntity[protocol.Authenticator](tgt.SessionKey(), req.Authenticator())
+	auth, err := shared.DecryptEntity[protocol.Authenticator](tgt.SessionKey(), crypto.KeyUsageTGSReqAuth, req.Authenticator())
 	if err != nil {
 		e.logger.Warn("failed to decrypt authenticator", "err", err)
-		return protocol.TGSRep{}, fmt.Errorf("invalid authenticator")
+		return protocol.TGSRep{}, shared.ErrInvalidAuthenticator
 	}
 
 	if err := e.validateAuthenticator(tgt, auth); err != nil {
 		return protocol.TGSRep{}, err
 	}
 
-	serviceKey, err := shared.FetchPrincipalKey(ctx, e.db, e.logger, req.Server())
+	c := codec.FromContext(ctx)
+	if err := checkBody(c, req, tgt.SessionKey(), auth); err != nil {
+		return protocol.TGSRep{}, err
+	}
+
+	pa, ok := req.PAData().Find(protocol.PATypeFXFast)
+	if !ok {
+		if err := e.checkFASTPolicy(ctx, tgt.Client()); err != nil {
+			return protocol.TGSRep{}, err
+		}
+		return e.issue(ctx, c, req, tgt, tgsKey, auth, now)
+	}
+
+	armor, err := e.armor(req, tgt, auth)
 	if err != nil {
 		return protocol.TGSRep{}, err
 	}
 
-	newSessionKey, err := e.keygen.Generate(32)
+	req, err = e.openFastReq(c, req, pa, armor)
+	if err != nil {
+		return protocol.TGSRep{}, shared.NewFastError(armor, err)
+	}
+	armor.Nonce = req.Nonce()
+
+	rep, err := e.issue(ctx, c, req, tgt, tgsKey, auth, now)
+	if err != nil {
+		return protocol.TGSRep{}, shared.NewFastError(armor, err)
+	}
+
+	rep, err = shared.ArmorReply(c, armor, rep, nil, protocol.SessionKey{}, now)
+	if err != nil {
+		return protocol.TGSRep{}, shared.NewFastError(armor, err)
+	}
+	return rep, nil
+}
+
+// issue issues the ticket req asks for on the strength of tgt, which the
+// authenticator auth presented.
+func (e *Exchange) issue(
+	ctx context.Context,
+	c codec.Codec,
+	req protocol.TGSReq,
+	tgt protocol.Ticket,
+	tgsKey shared.PrincipalKey,
+	auth protocol.Authenticator,
+	now time.Time,
+) (protocol.TGSRep, error) {
+	server, err := e.route(req.Server())
+	if err != nil {
+		return protocol.TGSRep{}, fmt.Errorf("%w: %w", protocol.KDCErrSPrincipalUnknown, err)
+	}
+
+	service, err := shared.FetchPrincipal(ctx, e.db, e.logger, server)
+	if err != nil {
+		return protocol.TGSRep{}, fmt.Errorf("%w: %w", protocol.KDCErrSPrincipalUnknown, err)
+	}
+
+	issue, err := e.issuance(req, tgt, now, e.limits(ctx, tgt.Client(), service))
+	if err != nil {
+		return protocol.TGSRep{}, err
+	}
+
+	issue, err = e.carryClaims(tgt, tgsKey.Key, issue)
+	if err != nil {
+		return protocol.TGSRep{}, err
+	}
+
+	issue, err = e.s4u(ctx, req, tgt, now, issue)
+	if err != nil {
+		return protocol.TGSRep{}, err
+	}
+
+	issue, err = e.transit(req, tgt, issue)
+	if err != nil {
+		return protocol.TGSRep{}, err
+	}
+
+	serviceKey, err := service.Key()
+	if err != nil {
+		return protocol.TGSRep{}, err
+	}
+
+	etype, err := shared.SessionEncType(req.ETypes(), service)
+	if err != nil {
+		return protocol.TGSRep{}, err
+	}
+
+	newSessionKey, err := e.keygen.Generate(etype)
+	if err != nil {
+		return protocol.TGSRep{}, err
+	}
+
+	authz, err := e.authorizationData(ctx, server, serviceKey.Key, issue)
 	if err != nil {
 		return protocol.TGSRep{}, err
 	}
 
 	encTicket, err := e.encryptTicket(
-		req.Server(),
-		tgt,
+		c,
+		server,
 		now,
+		issue,
 		newSessionKey,
 		serviceKey,
+		authz,
 	)
 	if err != nil {
 		return protocol.TGSRep{}, err
 	}
 
+	// The client may ask for the reply under a subkey of its own rather
+	// than the TGT session key.
+	replyKey, replyUsage := tgt.SessionKey(), crypto.KeyUsageTGSRepEncPart
+	if subkey, ok := auth.Subkey(); ok {
+		replyKey, replyUsage = subkey, crypto.KeyUsageTGSRepEncPartSub
+	}
+
 	encRepPart, err := e.encryptRepPart(
+		c,
 		req,
+		server,
 		now,
+		issue,
 		newSessionKey,
-		tgt.SessionKey(),
+		replyKey,
+		replyUsage,
 	)
 	if err != nil {
 		return protocol.TGSRep{}, err
 	}
 
-	return protocol.NewTGSRep(encTicket, encRepPart)
+	rep, err := protocol.NewTGSRep(encTicket, encRepPart)
+	if err != nil {
+		return protocol.TGSRep{}, err
+	}
+	return rep.WithClient(issue.client), nil
+}
+
+// limits combines the realm's ticket limits with those of the service and,
+// when it is a principal of this realm, the client.
+func (e *Exchange) limits(ctx context.Context, client protocol.Principal, service shared.PrincipalEntry) shared.Limits {
+	entries := []shared.PrincipalEntry{service}
+	if client.Realm() == e.cfg.Realm {
+		if entry, err := shared.FetchPrincipal(ctx, e.db, e.logger, client); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+
+	return shared.NewLimits(e.cfg.TicketLifetime, e.cfg.MaxRenewableLife, entries...)
 }
 
 func (e *Exchange) validateAuthenticator(tgt protocol.Ticket, auth protocol.Authenticator) error {
 	if tgt.Client().String() != auth.Client().String() {
-		return fmt.Errorf("client mismatch: ticket=%s, auth=%s", tgt.Client(), auth.Client())
+		return fmt.Errorf("%w: ticket=%s, auth=%s", shared.ErrClientMismatch, tgt.Client(), auth.Client())
 	}
 
 	// Verify timestamp freshness.
 	skew := e.clock.Now().Sub(auth.IssuedAt())
-	if skew < -5*time.Minute || skew > 5*time.Minute {
-		return fmt.Errorf("clock skew too great")
+	if skew < -e.maxSkew || skew > e.maxSkew {
+		return shared.ErrClockSkew
 	}
 
 	// Check for replay attack.
@@ -117,50 +252,86 @@ func (e *Exchange) validateAuthenticator(tgt protocol.Ticket, auth protocol.Auth
 
 	// Verify ticket validity period.
 	if tgt.IsExpired(e.clock.Now()) {
-		return fmt.Errorf("TGT expired")
+		return fmt.Errorf("%w: TGT expired", shared.ErrTicketExpired)
+	}
+
+	return nil
+}
+
+// checkBody verifies the checksum the authenticator carries over the clear
+// fields of req, encoded with c as the request was, so none of them can be
+// changed in transit.
+func checkBody(c codec.Codec, req protocol.TGSReq, key protocol.SessionKey, auth protocol.Authenticator) error {
+	cksum, ok := auth.Checksum()
+	if !ok {
+		return shared.ErrMissingChecksum
+	}
+
+	body, err := shared.TGSReqBody(c, req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", protocol.KRBErrGeneric, err)
 	}
 
+	if err := crypto.VerifyChecksum(key, crypto.KeyUsageTGSReqAuthCksum, body, cksum); err != nil {
+		return fmt.Errorf("%w: %w", shared.ErrModified, err)
+	}
 	return nil
 }
 
 func (e *Exchange) encryptTicket(
+	c codec.Codec,
 	server protocol.Principal,
-	tgt protocol.Ticket,
 	now time.Time,
+	issue issuance,
 	sessionKey protocol.SessionKey,
-	serviceKey protocol.SessionKey,
+	serviceKey shared.PrincipalKey,
+	authz protocol.AuthorizationData,
 ) (protocol.EncryptedData, error) {
 	ticket, err := protocol.NewTicket(
 		server,
-		tgt.Client(),
-		tgt.ClientAddr(),
+		issue.client,
+		issue.clientAddr,
 		now,
-		e.cfg.TicketLifetime,
+		issue.lifetime,
 		sessionKey,
 	)
 	if err != nil {
 		return protocol.EncryptedData{}, err
 	}
 
-	return shared.EncryptEntity(serviceKey, ticket)
+	ticket = ticket.
+		WithFlags(issue.flags).
+		WithStartTime(issue.startTime).
+		WithRenewTill(issue.renewTill).
+		WithTransited(issue.transited...).
+		WithAuthorizationData(authz)
+	return shared.EncryptTicket(c, serviceKey, ticket)
 }
 
 func (e *Exchange) encryptRepPart(
+	c codec.Codec,
 	req protocol.TGSReq,
+	server protocol.Principal,
 	now time.Time,
+	issue issuance,
 	sessionKey protocol.SessionKey,
 	key protocol.SessionKey,
+	usage crypto.KeyUsage,
 ) (protocol.EncryptedData, error) {
 	repPart, err := protocol.NewEncKDCRepPart(
 		sessionKey,
 		req.Nonce(),
 		now,
-		e.cfg.TicketLifetime,
-		req.Server(),
+		issue.lifetime,
+		server,
 	)
 	if err != nil {
 		return protocol.EncryptedData{}, err
 	}
 
-	return shared.EncryptEntity(key, repPart)
+	repPart = repPart.
+		WithFlags(issue.flags).
+		WithStartTime(issue.startTime).
+		WithRenewTill(issue.renewTill)
+	return shared.EncryptEntity(c, key, usage, repPart)
 }
@@ -1,12 +1,15 @@
 package tgs_test
 
 import (
+	"bytes"
+	"database/sql"
 	"encoding/hex"
 	"net"
 	"testing"
 	"time"
 
 	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/codec"
 	"github.com/rizesql/kerberos/internal/crypto"
 	"github.com/rizesql/kerberos/internal/kdb"
 	"github.com/rizesql/kerberos/internal/kdc"
@@ -37,21 +40,21 @@ func TestExchange(t *testing.T) {
 	clientAddr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
 
 	// Seed DB
-	h.CreatePrincipal(t.Context(), kdb.CreatePrincipalParams{
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
 		PrimaryName: "alice",
 		Instance:    "",
 		Realm:       "ATHENA.MIT.EDU",
 		KeyBytes:    clientKeyBytes,
 		Kvno:        1,
 	})
-	h.CreatePrincipal(t.Context(), kdb.CreatePrincipalParams{
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
 		PrimaryName: "krbtgt",
 		Instance:    "ATHENA.MIT.EDU",
 		Realm:       "ATHENA.MIT.EDU",
 		KeyBytes:    tgsKeyBytes,
 		Kvno:        1,
 	})
-	h.CreatePrincipal(t.Context(), kdb.CreatePrincipalParams{
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
 		PrimaryName: "http",
 		Instance:    "server.athena.mit.edu",
 		Realm:       "ATHENA.MIT.EDU",
@@ -64,8 +67,8 @@ func TestExchange(t *testing.T) {
 		TicketLifetime: 8 * time.Hour,
 	})
 
-	// Helper to create a valid TGT encrypted with TGS key.
-	createValidTGT := func(issuedAt time.Time, lifetime time.Duration) protocol.EncryptedData {
+	// Helper to create a TGT with the given flags encrypted with TGS key.
+	createTGTWithFlags := func(issuedAt time.Time, lifetime time.Duration, flags protocol.TicketFlags) protocol.EncryptedData {
 		tgt, _ := protocol.NewTicket(
 			tgsPrincipal,
 			client,
@@ -75,14 +78,19 @@ func TestExchange(t *testing.T) {
 			tgtSessionKey,
 		)
 		tgsKey, _ := protocol.NewSessionKey(tgsKeyBytes)
-		encTGT, _ := shared.EncryptEntity(tgsKey, tgt)
+		encTGT, _ := shared.EncryptEntity(codec.JSON, tgsKey, crypto.KeyUsageTicket, tgt.WithFlags(flags))
 		return encTGT
 	}
 
+	// Helper to create a valid TGT encrypted with TGS key.
+	createValidTGT := func(issuedAt time.Time, lifetime time.Duration) protocol.EncryptedData {
+		return createTGTWithFlags(issuedAt, lifetime, protocol.FlagInitial|protocol.FlagPreAuthent)
+	}
+
 	// Helper to create a valid authenticator encrypted with TGT session key.
 	createValidAuthenticator := func(issuedAt time.Time) protocol.EncryptedData {
 		auth, _ := protocol.NewAuthenticator(client, clientAddr, issuedAt)
-		encAuth, _ := shared.EncryptEntity(tgtSessionKey, auth)
+		encAuth, _ := shared.EncryptEntity(codec.JSON, tgtSessionKey, crypto.KeyUsageTGSReqAuth, auth)
 		return encAuth
 	}
 
@@ -95,11 +103,11 @@ func TestExchange(t *testing.T) {
 		nonce, _ := protocol.NewNonce(12345)
 
 		req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce)
-		rep, err := exchange.Handle(t.Context(), req)
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
 		assert.Err(t, err, nil)
 
 		// Verify Secret Part (encrypted with TGT session key)
-		secretPartBytes, err := crypto.Decrypt(tgtSessionKey, rep.SecretPart().Ciphertext())
+		secretPartBytes, err := crypto.Decrypt(tgtSessionKey, crypto.KeyUsageTGSRepEncPart, rep.SecretPart().Ciphertext())
 		assert.Err(t, err, nil)
 
 		var encPart protocol.EncKDCRepPart
@@ -113,7 +121,7 @@ func TestExchange(t *testing.T) {
 
 		// Verify Service Ticket (encrypted with Service Key)
 		serviceKey, _ := protocol.NewSessionKey(serviceKeyBytes)
-		ticketBytes, err := crypto.Decrypt(serviceKey, rep.Ticket().Ciphertext())
+		ticketBytes, err := crypto.Decrypt(serviceKey, crypto.KeyUsageTicket, rep.Ticket().Ciphertext())
 		assert.Err(t, err, nil)
 
 		var ticket protocol.Ticket
@@ -124,6 +132,8 @@ func TestExchange(t *testing.T) {
 		assert.Equal(t, ticket.Server().String(), servicePrincipal.String())
 		assert.Equal(t, ticket.IssuedAt().Equal(now), true)
 		assert.Equal(t, string(ticket.SessionKey().Expose()), string(expectedNewSessionKey.Expose()))
+		assert.Equal(t, ticket.Flags(), protocol.FlagPreAuthent)
+		assert.Equal(t, encPart.Flags(), protocol.FlagPreAuthent)
 	})
 
 	// --- 2. Invalid TGT (wrong encryption key) ---
@@ -141,18 +151,40 @@ func TestExchange(t *testing.T) {
 			tgtSessionKey,
 		)
 		wrongKey, _ := protocol.NewSessionKey(clientKeyBytes)
-		encTGT, _ := shared.EncryptEntity(wrongKey, tgt)
+		encTGT, _ := shared.EncryptEntity(codec.JSON, wrongKey, crypto.KeyUsageTicket, tgt)
 
 		encAuth := createValidAuthenticator(authTime)
 		nonce, _ := protocol.NewNonce(12346)
 
 		req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce)
-		_, err := exchange.Handle(t.Context(), req)
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
 
 		// Should fail with "invalid TGT" error
 		assert.Err(t, err, "invalid TGT")
 	})
 
+	t.Run("UnknownKeyVersion", func(t *testing.T) {
+		now := h.Clock.Now()
+		encTGT := createValidTGT(now, 8*time.Hour).WithKvno(7)
+		encAuth := createValidAuthenticator(now.Add(250 * time.Millisecond))
+		nonce, _ := protocol.NewNonce(12346)
+
+		req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce)
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, protocol.KRBAPErrBadKeyVer)
+	})
+
+	t.Run("NoSharedEncType", func(t *testing.T) {
+		now := h.Clock.Now()
+		encTGT := createValidTGT(now, 8*time.Hour)
+		encAuth := createValidAuthenticator(now.Add(260 * time.Millisecond))
+		nonce, _ := protocol.NewNonce(12346)
+
+		req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce)
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req.WithETypes(18), tgtSessionKey))
+		assert.Err(t, err, protocol.KDCErrETypeNoSupp)
+	})
+
 	// --- 3. Invalid Authenticator (wrong encryption key) ---
 	t.Run("InvalidAuthenticator", func(t *testing.T) {
 		now := h.Clock.Now()
@@ -162,17 +194,33 @@ func TestExchange(t *testing.T) {
 		// Encrypt authenticator with wrong key
 		auth, _ := protocol.NewAuthenticator(client, clientAddr, authTime)
 		wrongKey, _ := protocol.NewSessionKey(clientKeyBytes)
-		encAuth, _ := shared.EncryptEntity(wrongKey, auth)
+		encAuth, _ := shared.EncryptEntity(codec.JSON, wrongKey, crypto.KeyUsageTGSReqAuth, auth)
 
 		nonce, _ := protocol.NewNonce(12347)
 
 		req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce)
-		_, err := exchange.Handle(t.Context(), req)
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
 
 		// Should fail with "invalid authenticator" error
 		assert.Err(t, err, "invalid authenticator")
 	})
 
+	// An authenticator made for an AP-REQ under the same session key
+	t.Run("AuthenticatorForOtherUsage", func(t *testing.T) {
+		now := h.Clock.Now()
+		authTime := now.Add(350 * time.Millisecond) // Unique timestamp for this test
+		encTGT := createValidTGT(now, 8*time.Hour)
+
+		auth, _ := protocol.NewAuthenticator(client, clientAddr, authTime)
+		encAuth, _ := shared.EncryptEntity(codec.JSON, tgtSessionKey, crypto.KeyUsageAPReqAuth, auth)
+
+		nonce, _ := protocol.NewNonce(12350)
+
+		req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce)
+		_, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, "invalid authenticator")
+	})
+
 	// --- 4. Client Mismatch (TGT client != Authenticator client) ---
 	t.Run("ClientMismatch", func(t *testing.T) {
 		now := h.Clock.Now()
@@ -182,12 +230,12 @@ func TestExchange(t *testing.T) {
 		// Create authenticator with different client
 		differentClient, _ := protocol.NewPrincipal("bob", "", "ATHENA.MIT.EDU")
 		auth, _ := protocol.NewAuthenticator(differentClient, clientAddr, authTime)
-		encAuth, _ := shared.EncryptEntity(tgtSessionKey, auth)
+		encAuth, _ := shared.EncryptEntity(codec.JSON, tgtSessionKey, crypto.KeyUsageTGSReqAuth, auth)
 
 		nonce, _ := protocol.NewNonce(12348)
 
 		req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce)
-		_, err := exchange.Handle(t.Context(), req)
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
 
 		// Should fail with client mismatch
 		assert.Err(t, err, "client mismatch")
@@ -201,12 +249,12 @@ func TestExchange(t *testing.T) {
 		// Create authenticator with timestamp 10 minutes in the past
 		oldTime := now.Add(-10 * time.Minute)
 		auth, _ := protocol.NewAuthenticator(client, clientAddr, oldTime)
-		encAuth, _ := shared.EncryptEntity(tgtSessionKey, auth)
+		encAuth, _ := shared.EncryptEntity(codec.JSON, tgtSessionKey, crypto.KeyUsageTGSReqAuth, auth)
 
 		nonce, _ := protocol.NewNonce(12349)
 
 		req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce)
-		_, err := exchange.Handle(t.Context(), req)
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
 
 		assert.Err(t, err, "clock skew too great")
 	})
@@ -219,12 +267,12 @@ func TestExchange(t *testing.T) {
 		// Create authenticator with timestamp 10 minutes in the future
 		futureTime := now.Add(10 * time.Minute)
 		auth, _ := protocol.NewAuthenticator(client, clientAddr, futureTime)
-		encAuth, _ := shared.EncryptEntity(tgtSessionKey, auth)
+		encAuth, _ := shared.EncryptEntity(codec.JSON, tgtSessionKey, crypto.KeyUsageTGSReqAuth, auth)
 
 		nonce, _ := protocol.NewNonce(12350)
 
 		req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce)
-		_, err := exchange.Handle(t.Context(), req)
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
 
 		assert.Err(t, err, "clock skew too great")
 	})
@@ -242,7 +290,7 @@ func TestExchange(t *testing.T) {
 		nonce, _ := protocol.NewNonce(12351)
 
 		req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce)
-		_, err := exchange.Handle(t.Context(), req)
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
 
 		assert.Err(t, err, "TGT expired")
 	})
@@ -258,7 +306,7 @@ func TestExchange(t *testing.T) {
 		nonce, _ := protocol.NewNonce(12352)
 
 		req, _ := protocol.NewTGSReq(unknownService, encTGT, encAuth, nonce)
-		_, err := exchange.Handle(t.Context(), req)
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
 
 		assert.Err(t, err, shared.ErrPrincipalNotFound)
 	})
@@ -271,20 +319,20 @@ func TestExchange(t *testing.T) {
 		// Create authenticator with a specific timestamp
 		replayTime := now.Add(1 * time.Millisecond) // Unique timestamp for this test
 		auth, _ := protocol.NewAuthenticator(client, clientAddr, replayTime)
-		encAuth, _ := shared.EncryptEntity(tgtSessionKey, auth)
+		encAuth, _ := shared.EncryptEntity(codec.JSON, tgtSessionKey, crypto.KeyUsageTGSReqAuth, auth)
 
 		nonce1, _ := protocol.NewNonce(99001)
 		req1, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce1)
 
 		// First request should succeed
-		_, err := exchange.Handle(t.Context(), req1)
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req1, tgtSessionKey))
 		assert.Err(t, err, nil)
 
 		// Replay: same authenticator (same client + timestamp) should be rejected
 		nonce2, _ := protocol.NewNonce(99002)
 		req2, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce2)
 
-		_, err = exchange.Handle(t.Context(), req2)
+		_, err = exchange.Handle(t.Context(), testkit.SignTGSReq(t, req2, tgtSessionKey))
 		assert.Err(t, err, replay.ErrReplayDetected)
 	})
 
@@ -296,21 +344,1228 @@ func TestExchange(t *testing.T) {
 		// First request with timestamp T1
 		t1 := now.Add(2 * time.Millisecond)
 		auth1, _ := protocol.NewAuthenticator(client, clientAddr, t1)
-		encAuth1, _ := shared.EncryptEntity(tgtSessionKey, auth1)
+		encAuth1, _ := shared.EncryptEntity(codec.JSON, tgtSessionKey, crypto.KeyUsageTGSReqAuth, auth1)
 		nonce1, _ := protocol.NewNonce(99010)
 		req1, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth1, nonce1)
 
-		_, err := exchange.Handle(t.Context(), req1)
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req1, tgtSessionKey))
 		assert.Err(t, err, nil)
 
 		// Second request with different timestamp T2 should also succeed
 		t2 := now.Add(3 * time.Millisecond)
 		auth2, _ := protocol.NewAuthenticator(client, clientAddr, t2)
-		encAuth2, _ := shared.EncryptEntity(tgtSessionKey, auth2)
+		encAuth2, _ := shared.EncryptEntity(codec.JSON, tgtSessionKey, crypto.KeyUsageTGSReqAuth, auth2)
 		nonce2, _ := protocol.NewNonce(99011)
 		req2, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth2, nonce2)
 
-		_, err = exchange.Handle(t.Context(), req2)
+		_, err = exchange.Handle(t.Context(), testkit.SignTGSReq(t, req2, tgtSessionKey))
+		assert.Err(t, err, nil)
+	})
+
+	// --- 11. Requested Flags Granted From TGT ---
+	t.Run("FlagsFromTGT", func(t *testing.T) {
+		now := h.Clock.Now()
+		authTime := now.Add(1100 * time.Millisecond) // Unique timestamp for this test
+		tgtFlags := protocol.FlagInitial | protocol.FlagPreAuthent | protocol.FlagForwardable | protocol.FlagProxiable
+		encTGT := createTGTWithFlags(now, 8*time.Hour, tgtFlags)
+		encAuth := createValidAuthenticator(authTime)
+		nonce, _ := protocol.NewNonce(12353)
+
+		req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce)
+		req = req.WithOptions(protocol.OptForwardable)
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, nil)
+
+		serviceKey, _ := protocol.NewSessionKey(serviceKeyBytes)
+		ticket, err := shared.DecryptEntity[protocol.Ticket](serviceKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+
+		// INITIAL is never copied and PROXIABLE was not asked for.
+		assert.Equal(t, ticket.Flags(), protocol.FlagPreAuthent|protocol.FlagForwardable)
+	})
+
+	// --- 12. Forwarded TGT For Another Address ---
+	t.Run("Forwarded", func(t *testing.T) {
+		now := h.Clock.Now()
+		authTime := now.Add(1300 * time.Millisecond) // Unique timestamp for this test
+		tgtFlags := protocol.FlagInitial | protocol.FlagPreAuthent | protocol.FlagForwardable
+		encTGT := createTGTWithFlags(now, 8*time.Hour, tgtFlags)
+		encAuth := createValidAuthenticator(authTime)
+		nonce, _ := protocol.NewNonce(12355)
+		otherAddr, _ := protocol.NewAddress(net.IPv4(10, 0, 0, 7))
+
+		req, _ := protocol.NewTGSReq(tgsPrincipal, encTGT, encAuth, nonce)
+		req = req.WithOptions(protocol.OptForwarded | protocol.OptForwardable).WithClientAddr(otherAddr)
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, nil)
+
+		tgsKey, _ := protocol.NewSessionKey(tgsKeyBytes)
+		ticket, err := shared.DecryptEntity[protocol.Ticket](tgsKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+
+		assert.Equal(t, ticket.Flags(), protocol.FlagPreAuthent|protocol.FlagForwardable|protocol.FlagForwarded)
+		assert.Equal(t, ticket.ClientAddr().IP().String(), "10.0.0.7")
+	})
+
+	// --- 13. Forwarded From A Non-Forwardable TGT ---
+	t.Run("ForwardedNotForwardable", func(t *testing.T) {
+		now := h.Clock.Now()
+		authTime := now.Add(1400 * time.Millisecond) // Unique timestamp for this test
+		encTGT := createValidTGT(now, 8*time.Hour)
+		encAuth := createValidAuthenticator(authTime)
+		nonce, _ := protocol.NewNonce(12356)
+
+		req, _ := protocol.NewTGSReq(tgsPrincipal, encTGT, encAuth, nonce)
+		req = req.WithOptions(protocol.OptForwarded)
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+
+		assert.Err(t, err, protocol.KDCErrBadOption)
+	})
+
+	// --- 14. Address Change Without FORWARDED ---
+	t.Run("AddressIgnoredWithoutForwarded", func(t *testing.T) {
+		now := h.Clock.Now()
+		authTime := now.Add(1500 * time.Millisecond) // Unique timestamp for this test
+		encTGT := createValidTGT(now, 8*time.Hour)
+		encAuth := createValidAuthenticator(authTime)
+		nonce, _ := protocol.NewNonce(12357)
+		otherAddr, _ := protocol.NewAddress(net.IPv4(10, 0, 0, 7))
+
+		req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce)
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req.WithClientAddr(otherAddr), tgtSessionKey))
+		assert.Err(t, err, nil)
+
+		serviceKey, _ := protocol.NewSessionKey(serviceKeyBytes)
+		ticket, err := shared.DecryptEntity[protocol.Ticket](serviceKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.Equal(t, ticket.ClientAddr().IP().String(), clientAddr.IP().String())
+	})
+
+	// --- 15. Requested Flag Missing From TGT ---
+	t.Run("FlagNotInTGT", func(t *testing.T) {
+		now := h.Clock.Now()
+		authTime := now.Add(1200 * time.Millisecond) // Unique timestamp for this test
+		encTGT := createValidTGT(now, 8*time.Hour)
+		encAuth := createValidAuthenticator(authTime)
+		nonce, _ := protocol.NewNonce(12354)
+
+		req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce)
+		req = req.WithOptions(protocol.OptRenewable)
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+
+		assert.Err(t, err, protocol.KDCErrBadOption)
+	})
+
+	// --- 16. Authenticator Without a Body Checksum ---
+	t.Run("MissingChecksum", func(t *testing.T) {
+		now := h.Clock.Now()
+		authTime := now.Add(1600 * time.Millisecond) // Unique timestamp for this test
+		encTGT := createValidTGT(now, 8*time.Hour)
+		encAuth := createValidAuthenticator(authTime)
+		nonce, _ := protocol.NewNonce(12355)
+
+		req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce)
+		_, err := exchange.Handle(t.Context(), req)
+
+		assert.Err(t, err, shared.ErrMissingChecksum)
+	})
+
+	// --- 17. Requested Server Swapped After Signing ---
+	t.Run("ServerSwapped", func(t *testing.T) {
+		now := h.Clock.Now()
+		authTime := now.Add(1700 * time.Millisecond) // Unique timestamp for this test
+		encTGT := createValidTGT(now, 8*time.Hour)
+		encAuth := createValidAuthenticator(authTime)
+		nonce, _ := protocol.NewNonce(12356)
+
+		req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce)
+		signed := testkit.SignTGSReq(t, req, tgtSessionKey)
+
+		swapped, _ := protocol.NewTGSReq(tgsPrincipal, encTGT, signed.Authenticator(), nonce)
+		_, err := exchange.Handle(t.Context(), swapped)
+
+		assert.Err(t, err, shared.ErrModified)
+	})
+
+	// --- 18. Options Added After Signing ---
+	t.Run("OptionsChanged", func(t *testing.T) {
+		now := h.Clock.Now()
+		authTime := now.Add(1800 * time.Millisecond) // Unique timestamp for this test
+		encTGT := createValidTGT(now, 8*time.Hour)
+		encAuth := createValidAuthenticator(authTime)
+		nonce, _ := protocol.NewNonce(12357)
+
+		req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce)
+		signed := testkit.SignTGSReq(t, req, tgtSessionKey)
+		_, err := exchange.Handle(t.Context(), signed.WithOptions(protocol.OptForwardable))
+
+		assert.Err(t, err, shared.ErrModified)
+	})
+
+	// --- 19. Reply Under the Authenticator Subkey ---
+	t.Run("ReplyUnderSubkey", func(t *testing.T) {
+		now := h.Clock.Now()
+		authTime := now.Add(1900 * time.Millisecond) // Unique timestamp for this test
+		encTGT := createValidTGT(now, 8*time.Hour)
+		nonce, _ := protocol.NewNonce(12358)
+		subkey, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0x5a}, 32))
+
+		auth, _ := protocol.NewAuthenticator(client, clientAddr, authTime)
+		req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, protocol.EncryptedData{}, nonce)
+		req, err := shared.SealTGSAuthenticator(codec.JSON, req, tgtSessionKey, auth.WithSubkey(subkey))
+		assert.Err(t, err, nil)
+
+		rep, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, nil)
+
+		_, err = shared.DecryptEntity[protocol.EncKDCRepPart](tgtSessionKey, crypto.KeyUsageTGSRepEncPart, rep.SecretPart())
+		assert.True(t, err != nil)
+
+		repPart, err := shared.DecryptEntity[protocol.EncKDCRepPart](subkey, crypto.KeyUsageTGSRepEncPartSub, rep.SecretPart())
+		assert.Err(t, err, nil)
+		assert.Equal(t, repPart.Nonce(), nonce)
+	})
+}
+
+func TestExchange_Renew(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	tgsKeyBytes, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+	tgtSessionKeyBytes, _ := hex.DecodeString("1122334455667788990011223344556677889900112233445566778899001122")
+	tgsKey, _ := protocol.NewSessionKey(tgsKeyBytes)
+	tgtSessionKey, _ := protocol.NewSessionKey(tgtSessionKeyBytes)
+
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	tgsPrincipal, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	clientAddr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "krbtgt",
+		Instance:    "ATHENA.MIT.EDU",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    tgsKeyBytes,
+		Kvno:        1,
+	})
+
+	exchange := tgs.NewExchange(h.NewKDCPlatform(), kdc.Config{
+		Realm:            "ATHENA.MIT.EDU",
+		TicketLifetime:   8 * time.Hour,
+		MaxRenewableLife: 7 * 24 * time.Hour,
+	})
+
+	createTGT := func(issuedAt time.Time, flags protocol.TicketFlags, renewTill time.Time) protocol.EncryptedData {
+		tgt, _ := protocol.NewTicket(tgsPrincipal, client, clientAddr, issuedAt, 8*time.Hour, tgtSessionKey)
+		enc, _ := shared.EncryptEntity(codec.JSON, tgsKey, crypto.KeyUsageTicket, tgt.WithFlags(flags).WithRenewTill(renewTill))
+		return enc
+	}
+
+	renewReq := func(server protocol.Principal, tgt protocol.EncryptedData, authTime time.Time) protocol.TGSReq {
+		auth, _ := protocol.NewAuthenticator(client, clientAddr, authTime)
+		encAuth, _ := shared.EncryptEntity(codec.JSON, tgtSessionKey, crypto.KeyUsageTGSReqAuth, auth)
+		nonce, _ := protocol.NewNonce(424242)
+		req, _ := protocol.NewTGSReq(server, tgt, encAuth, nonce)
+		return req.WithOptions(protocol.OptRenew)
+	}
+
+	renewable := protocol.FlagInitial | protocol.FlagPreAuthent | protocol.FlagRenewable
+
+	t.Run("Success", func(t *testing.T) {
+		now := h.Clock.Now()
+		renewTill := now.Add(3 * 24 * time.Hour)
+		tgt := createTGT(now.Add(-6*time.Hour), renewable, renewTill)
+
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, renewReq(tgsPrincipal, tgt, now.Add(time.Millisecond)), tgtSessionKey))
+		assert.Err(t, err, nil)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](tgsKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.True(t, ticket.IssuedAt().Equal(now))
+		assert.True(t, ticket.EndTime().Equal(now.Add(8*time.Hour)))
+		assert.True(t, ticket.RenewTill().Equal(renewTill))
+		assert.Equal(t, ticket.Flags(), protocol.FlagPreAuthent|protocol.FlagRenewable)
+
+		repPart, err := shared.DecryptEntity[protocol.EncKDCRepPart](tgtSessionKey, crypto.KeyUsageTGSRepEncPart, rep.SecretPart())
+		assert.Err(t, err, nil)
+		assert.True(t, repPart.RenewTill().Equal(renewTill))
+	})
+
+	t.Run("CappedAtRenewTill", func(t *testing.T) {
+		now := h.Clock.Now()
+		renewTill := now.Add(2 * time.Hour)
+		tgt := createTGT(now.Add(-7*time.Hour), renewable, renewTill)
+
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, renewReq(tgsPrincipal, tgt, now.Add(2*time.Millisecond)), tgtSessionKey))
+		assert.Err(t, err, nil)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](tgsKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.True(t, ticket.EndTime().Equal(renewTill))
+	})
+
+	t.Run("NotRenewable", func(t *testing.T) {
+		now := h.Clock.Now()
+		tgt := createTGT(now, protocol.FlagInitial|protocol.FlagPreAuthent, time.Time{})
+
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, renewReq(tgsPrincipal, tgt, now.Add(3*time.Millisecond)), tgtSessionKey))
+		assert.Err(t, err, protocol.KDCErrBadOption)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		now := h.Clock.Now()
+		tgt := createTGT(now.Add(-9*time.Hour), renewable, now.Add(24*time.Hour))
+
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, renewReq(tgsPrincipal, tgt, now.Add(4*time.Millisecond)), tgtSessionKey))
+		assert.Err(t, err, shared.ErrTicketExpired)
+	})
+
+	t.Run("PastRenewTill", func(t *testing.T) {
+		now := h.Clock.Now()
+		tgt := createTGT(now.Add(-time.Hour), renewable, now.Add(-time.Minute))
+
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, renewReq(tgsPrincipal, tgt, now.Add(5*time.Millisecond)), tgtSessionKey))
+		assert.Err(t, err, "renew-till has passed")
+	})
+
+	t.Run("DifferentServer", func(t *testing.T) {
+		now := h.Clock.Now()
+		other, _ := protocol.NewPrincipal("http", "server.athena.mit.edu", "ATHENA.MIT.EDU")
+		h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+			PrimaryName: "http",
+			Instance:    "server.athena.mit.edu",
+			Realm:       "ATHENA.MIT.EDU",
+			KeyBytes:    tgsKeyBytes,
+			Kvno:        1,
+		})
+		tgt := createTGT(now, renewable, now.Add(24*time.Hour))
+
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, renewReq(other, tgt, now.Add(6*time.Millisecond)), tgtSessionKey))
+		assert.Err(t, err, protocol.KDCErrBadOption)
+	})
+}
+
+func TestExchange_Postdated(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	tgsKeyBytes, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+	serviceKeyBytes, _ := hex.DecodeString("aabbccddeeff00112233445566778899aabbccddeeff00112233445566778899")
+	tgtSessionKeyBytes, _ := hex.DecodeString("1122334455667788990011223344556677889900112233445566778899001122")
+	tgsKey, _ := protocol.NewSessionKey(tgsKeyBytes)
+	serviceKey, _ := protocol.NewSessionKey(serviceKeyBytes)
+	tgtSessionKey, _ := protocol.NewSessionKey(tgtSessionKeyBytes)
+
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	tgsPrincipal, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	servicePrincipal, _ := protocol.NewPrincipal("http", "server.athena.mit.edu", "ATHENA.MIT.EDU")
+	clientAddr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "krbtgt",
+		Instance:    "ATHENA.MIT.EDU",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    tgsKeyBytes,
+		Kvno:        1,
+	})
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "http",
+		Instance:    "server.athena.mit.edu",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    serviceKeyBytes,
+		Kvno:        1,
+	})
+
+	exchange := tgs.NewExchange(h.NewKDCPlatform(), kdc.Config{
+		Realm:          "ATHENA.MIT.EDU",
+		TicketLifetime: 8 * time.Hour,
+	})
+
+	createTGT := func(start time.Time, flags protocol.TicketFlags) protocol.EncryptedData {
+		tgt, _ := protocol.NewTicket(tgsPrincipal, client, clientAddr, h.Clock.Now().Add(-time.Hour), 8*time.Hour, tgtSessionKey)
+		enc, _ := shared.EncryptEntity(codec.JSON, tgsKey, crypto.KeyUsageTicket, tgt.WithFlags(flags).WithStartTime(start))
+		return enc
+	}
+
+	newReq := func(server protocol.Principal, tgt protocol.EncryptedData, offset time.Duration) protocol.TGSReq {
+		auth, _ := protocol.NewAuthenticator(client, clientAddr, h.Clock.Now().Add(offset))
+		encAuth, _ := shared.EncryptEntity(codec.JSON, tgtSessionKey, crypto.KeyUsageTGSReqAuth, auth)
+		nonce, _ := protocol.NewNonce(777)
+		req, _ := protocol.NewTGSReq(server, tgt, encAuth, nonce)
+		return req
+	}
+
+	postdated := protocol.FlagPreAuthent | protocol.FlagPostdated | protocol.FlagInvalid
+
+	t.Run("PostdatedFromTGT", func(t *testing.T) {
+		from := h.Clock.Now().Add(4 * time.Hour)
+		tgt := createTGT(time.Time{}, protocol.FlagInitial|protocol.FlagPreAuthent|protocol.FlagMayPostdate)
+		req := newReq(servicePrincipal, tgt, time.Millisecond).
+			WithOptions(protocol.OptPostdated).
+			WithTimes(from, time.Time{})
+
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, nil)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](serviceKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.Equal(t, ticket.Flags(), postdated)
+		assert.True(t, ticket.StartTime().Equal(from))
+	})
+
+	t.Run("PostdatedNotAllowed", func(t *testing.T) {
+		tgt := createTGT(time.Time{}, protocol.FlagInitial|protocol.FlagPreAuthent)
+		req := newReq(servicePrincipal, tgt, 2*time.Millisecond).
+			WithOptions(protocol.OptPostdated).
+			WithTimes(h.Clock.Now().Add(time.Hour), time.Time{})
+
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, protocol.KDCErrBadOption)
+	})
+
+	t.Run("InvalidTGTRejected", func(t *testing.T) {
+		tgt := createTGT(h.Clock.Now().Add(-time.Minute), postdated)
+
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, newReq(servicePrincipal, tgt, 3*time.Millisecond), tgtSessionKey))
+		assert.Err(t, err, protocol.KRBAPErrTktNYV)
+	})
+
+	t.Run("ValidateTooEarly", func(t *testing.T) {
+		tgt := createTGT(h.Clock.Now().Add(time.Hour), postdated)
+		req := newReq(tgsPrincipal, tgt, 4*time.Millisecond).WithOptions(protocol.OptValidate)
+
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, protocol.KRBAPErrTktNYV)
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		start := h.Clock.Now().Add(-time.Minute)
+		tgt := createTGT(start, postdated)
+		req := newReq(tgsPrincipal, tgt, 5*time.Millisecond).WithOptions(protocol.OptValidate)
+
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, nil)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](tgsKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.Equal(t, ticket.Flags(), protocol.FlagPreAuthent|protocol.FlagPostdated)
+		assert.True(t, ticket.StartTime().Equal(start))
+		assert.True(t, ticket.EndTime().Equal(start.Add(8*time.Hour)))
+		assert.True(t, !ticket.IsNotYetValid(h.Clock.Now()))
+	})
+
+	t.Run("ValidateValidTicket", func(t *testing.T) {
+		tgt := createTGT(time.Time{}, protocol.FlagInitial|protocol.FlagPreAuthent)
+		req := newReq(tgsPrincipal, tgt, 6*time.Millisecond).WithOptions(protocol.OptValidate)
+
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, protocol.KDCErrBadOption)
+	})
+}
+
+func TestExchange_Limits(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	tgsKeyBytes, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+	serviceKeyBytes, _ := hex.DecodeString("aabbccddeeff00112233445566778899aabbccddeeff00112233445566778899")
+	tgtSessionKeyBytes, _ := hex.DecodeString("1122334455667788990011223344556677889900112233445566778899001122")
+	tgsKey, _ := protocol.NewSessionKey(tgsKeyBytes)
+	serviceKey, _ := protocol.NewSessionKey(serviceKeyBytes)
+	tgtSessionKey, _ := protocol.NewSessionKey(tgtSessionKeyBytes)
+
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	tgsPrincipal, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	limited, _ := protocol.NewPrincipal("http", "limited.athena.mit.edu", "ATHENA.MIT.EDU")
+	unlimited, _ := protocol.NewPrincipal("http", "server.athena.mit.edu", "ATHENA.MIT.EDU")
+	clientAddr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "krbtgt",
+		Instance:    "ATHENA.MIT.EDU",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    tgsKeyBytes,
+		Kvno:        1,
+	})
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "http",
+		Instance:    "limited.athena.mit.edu",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    serviceKeyBytes,
+		Kvno:        1,
+		MaxLife:     sql.NullInt64{Int64: int64((2 * time.Hour).Seconds()), Valid: true},
+	})
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "http",
+		Instance:    "server.athena.mit.edu",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    serviceKeyBytes,
+		Kvno:        1,
+	})
+
+	exchange := tgs.NewExchange(h.NewKDCPlatform(), kdc.Config{
+		Realm:          "ATHENA.MIT.EDU",
+		TicketLifetime: 8 * time.Hour,
+	})
+
+	createTGT := func(lifetime time.Duration) protocol.EncryptedData {
+		tgt, _ := protocol.NewTicket(tgsPrincipal, client, clientAddr, h.Clock.Now(), lifetime, tgtSessionKey)
+		enc, _ := shared.EncryptEntity(codec.JSON, tgsKey, crypto.KeyUsageTicket, tgt.WithFlags(protocol.FlagInitial|protocol.FlagPreAuthent))
+		return enc
+	}
+
+	newReq := func(server protocol.Principal, tgt protocol.EncryptedData, offset time.Duration) protocol.TGSReq {
+		auth, _ := protocol.NewAuthenticator(client, clientAddr, h.Clock.Now().Add(offset))
+		encAuth, _ := shared.EncryptEntity(codec.JSON, tgtSessionKey, crypto.KeyUsageTGSReqAuth, auth)
+		nonce, _ := protocol.NewNonce(555)
+		req, _ := protocol.NewTGSReq(server, tgt, encAuth, nonce)
+		return req
+	}
+
+	t.Run("ServiceMaxLife", func(t *testing.T) {
+		now := h.Clock.Now()
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, newReq(limited, createTGT(8*time.Hour), time.Millisecond), tgtSessionKey))
+		assert.Err(t, err, nil)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](serviceKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.True(t, ticket.EndTime().Equal(now.Add(2*time.Hour)))
+	})
+
+	t.Run("CappedAtTGTEnd", func(t *testing.T) {
+		now := h.Clock.Now()
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, newReq(unlimited, createTGT(time.Hour), 2*time.Millisecond), tgtSessionKey))
+		assert.Err(t, err, nil)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](serviceKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.True(t, ticket.EndTime().Equal(now.Add(time.Hour)))
+	})
+
+	t.Run("RequestedTill", func(t *testing.T) {
+		till := h.Clock.Now().Add(30 * time.Minute)
+		req := newReq(unlimited, createTGT(8*time.Hour), 3*time.Millisecond).WithTimes(time.Time{}, till)
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, nil)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](serviceKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.True(t, ticket.EndTime().Equal(till))
+	})
+}
+
+func TestExchange_S4U(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	tgsKeyBytes, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+	frontendKeyBytes, _ := hex.DecodeString("aabbccddeeff00112233445566778899aabbccddeeff00112233445566778899")
+	backendKeyBytes, _ := hex.DecodeString("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	tgtSessionKeyBytes, _ := hex.DecodeString("1122334455667788990011223344556677889900112233445566778899001122")
+	tgsKey, _ := protocol.NewSessionKey(tgsKeyBytes)
+	frontendKey, _ := protocol.NewSessionKey(frontendKeyBytes)
+	backendKey, _ := protocol.NewSessionKey(backendKeyBytes)
+	tgtSessionKey, _ := protocol.NewSessionKey(tgtSessionKeyBytes)
+
+	user, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	frontend, _ := protocol.NewPrincipal("http", "frontend", "ATHENA.MIT.EDU")
+	backend, _ := protocol.NewPrincipal("postgres", "backend", "ATHENA.MIT.EDU")
+	lonely, _ := protocol.NewPrincipal("http", "lonely", "ATHENA.MIT.EDU")
+	tgsPrincipal, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+
+	for _, p := range []struct {
+		principal protocol.Principal
+		key       []byte
+	}{
+		{tgsPrincipal, tgsKeyBytes},
+		{user, []byte("alice-key")},
+		{frontend, frontendKeyBytes},
+		{backend, backendKeyBytes},
+		{lonely, frontendKeyBytes},
+	} {
+		h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+			PrimaryName: string(p.principal.Primary()),
+			Instance:    string(p.principal.Instance()),
+			Realm:       string(p.principal.Realm()),
+			KeyBytes:    p.key,
+			Kvno:        1,
+		})
+	}
+
+	err := kdb.Query.AddDelegation(t.Context(), h.DB, kdb.AddDelegationParams{
+		ServicePrimary:  "http",
+		ServiceInstance: "frontend",
+		ServiceRealm:    "ATHENA.MIT.EDU",
+		TargetPrimary:   "postgres",
+		TargetInstance:  "backend",
+		TargetRealm:     "ATHENA.MIT.EDU",
+	})
+	assert.Err(t, err, nil)
+
+	exchange := tgs.NewExchange(h.NewKDCPlatform(), kdc.Config{
+		Realm:          "ATHENA.MIT.EDU",
+		TicketLifetime: 8 * time.Hour,
+	})
+
+	// newReq builds a TGS-REQ sent by service with a fresh TGT.
+	newReq := func(service, server protocol.Principal, authTime time.Time) protocol.TGSReq {
+		tgt, _ := protocol.NewTicket(tgsPrincipal, service, addr, h.Clock.Now(), 8*time.Hour, tgtSessionKey)
+		encTGT, _ := shared.EncryptEntity(codec.JSON, tgsKey, crypto.KeyUsageTicket, tgt.WithFlags(protocol.FlagInitial|protocol.FlagPreAuthent))
+		auth, _ := protocol.NewAuthenticator(service, addr, authTime)
+		encAuth, _ := shared.EncryptEntity(codec.JSON, tgtSessionKey, crypto.KeyUsageTGSReqAuth, auth)
+		nonce, _ := protocol.NewNonce(777)
+		req, _ := protocol.NewTGSReq(server, encTGT, encAuth, nonce)
+		return req
+	}
+
+	forUser := func(p protocol.Principal) protocol.PAData {
+		pa, _ := shared.NewForUser(tgtSessionKey, p)
+		return pa
+	}
+
+	// evidence seals a ticket of alice to server, as issued by the KDC with
+	// the given flags.
+	evidence := func(server protocol.Principal, flags protocol.TicketFlags) protocol.EncryptedData {
+		claims, _ := protocol.NewClaims(nil, h.Clock.Now())
+		ad, _ := shared.SignClaims(user, claims, frontendKey, tgsKey)
+		ticket, _ := protocol.NewTicket(server, user, addr, h.Clock.Now(), time.Hour, tgtSessionKey)
+		enc, _ := shared.EncryptEntity(codec.JSON, frontendKey, crypto.KeyUsageTicket, ticket.WithFlags(flags).WithAuthorizationData(ad))
+		return enc
+	}
+
+	t.Run("Self", func(t *testing.T) {
+		now := h.Clock.Now()
+		req := newReq(frontend, frontend, now.Add(time.Millisecond)).WithPAData(forUser(user))
+
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, nil)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](frontendKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.Equal(t, ticket.Client(), user)
+		assert.Equal(t, ticket.Server(), frontend)
+		assert.Equal(t, ticket.Flags(), protocol.FlagForwardable)
+	})
+
+	t.Run("SelfNotForwardableWithoutDelegation", func(t *testing.T) {
+		now := h.Clock.Now()
+		req := newReq(lonely, lonely, now.Add(2*time.Millisecond)).WithPAData(forUser(user))
+
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, nil)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](frontendKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.Equal(t, ticket.Client(), user)
+		assert.Equal(t, ticket.Flags(), protocol.TicketFlags(0))
+	})
+
+	t.Run("SelfForOtherServer", func(t *testing.T) {
+		now := h.Clock.Now()
+		req := newReq(frontend, backend, now.Add(3*time.Millisecond)).WithPAData(forUser(user))
+
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, protocol.KDCErrBadOption)
+	})
+
+	t.Run("SelfUnknownUser", func(t *testing.T) {
+		now := h.Clock.Now()
+		ghost, _ := protocol.NewPrincipal("ghost", "", "ATHENA.MIT.EDU")
+		req := newReq(frontend, frontend, now.Add(4*time.Millisecond)).WithPAData(forUser(ghost))
+
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, shared.ErrPrincipalNotFound)
+	})
+
+	t.Run("SelfWrongKey", func(t *testing.T) {
+		now := h.Clock.Now()
+		pa, _ := shared.NewForUser(frontendKey, user)
+		req := newReq(frontend, frontend, now.Add(5*time.Millisecond)).WithPAData(pa)
+
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, protocol.KRBAPErrBadIntegrity)
+	})
+
+	t.Run("Proxy", func(t *testing.T) {
+		now := h.Clock.Now()
+		req := newReq(frontend, backend, now.Add(6*time.Millisecond)).
+			WithOptions(protocol.OptCNameInAddlTkt).
+			WithAdditionalTickets(evidence(frontend, protocol.FlagForwardable|protocol.FlagPreAuthent))
+
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, nil)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](backendKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.Equal(t, ticket.Client(), user)
+		assert.Equal(t, ticket.Server(), backend)
+		assert.Equal(t, ticket.Flags(), protocol.FlagForwardable|protocol.FlagPreAuthent)
+		assert.True(t, ticket.EndTime().Equal(now.Add(time.Hour)))
+	})
+
+	t.Run("ProxyNotAllowed", func(t *testing.T) {
+		now := h.Clock.Now()
+		req := newReq(frontend, lonely, now.Add(7*time.Millisecond)).
+			WithOptions(protocol.OptCNameInAddlTkt).
+			WithAdditionalTickets(evidence(frontend, protocol.FlagForwardable))
+
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, protocol.KDCErrPolicy)
+	})
+
+	t.Run("ProxyEvidenceNotForwardable", func(t *testing.T) {
+		now := h.Clock.Now()
+		req := newReq(frontend, backend, now.Add(8*time.Millisecond)).
+			WithOptions(protocol.OptCNameInAddlTkt).
+			WithAdditionalTickets(evidence(frontend, 0))
+
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, protocol.KDCErrBadOption)
+	})
+
+	t.Run("ProxyEvidenceForOtherService", func(t *testing.T) {
+		now := h.Clock.Now()
+		req := newReq(frontend, backend, now.Add(9*time.Millisecond)).
+			WithOptions(protocol.OptCNameInAddlTkt).
+			WithAdditionalTickets(evidence(lonely, protocol.FlagForwardable))
+
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, protocol.KDCErrBadOption)
+	})
+
+	t.Run("ProxyForgedEvidence", func(t *testing.T) {
+		// The frontend holds the key of its own tickets, but cannot sign
+		// the claims of one as the KDC.
+		now := h.Clock.Now()
+		ticket, _ := protocol.NewTicket(frontend, user, addr, now, time.Hour, tgtSessionKey)
+		forged, _ := shared.EncryptEntity(codec.JSON, frontendKey, crypto.KeyUsageTicket, ticket.WithFlags(protocol.FlagForwardable|protocol.FlagPreAuthent))
+		req := newReq(frontend, backend, now.Add(13*time.Millisecond)).
+			WithOptions(protocol.OptCNameInAddlTkt).
+			WithAdditionalTickets(forged)
+
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, protocol.KDCErrBadOption)
+	})
+
+	t.Run("ProxyWithoutEvidence", func(t *testing.T) {
+		now := h.Clock.Now()
+		req := newReq(frontend, backend, now.Add(10*time.Millisecond)).
+			WithOptions(protocol.OptCNameInAddlTkt)
+
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, protocol.KDCErrBadOption)
+	})
+
+	t.Run("SelfThenProxy", func(t *testing.T) {
+		now := h.Clock.Now()
+		self := newReq(frontend, frontend, now.Add(11*time.Millisecond)).WithPAData(forUser(user))
+		selfRep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, self, tgtSessionKey))
+		assert.Err(t, err, nil)
+
+		proxy := newReq(frontend, backend, now.Add(12*time.Millisecond)).
+			WithOptions(protocol.OptCNameInAddlTkt).
+			WithAdditionalTickets(selfRep.Ticket())
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, proxy, tgtSessionKey))
+		assert.Err(t, err, nil)
+
+		// The user never authenticated to the KDC, and delegation does not
+		// make it look as if they had.
+		ticket, err := shared.DecryptEntity[protocol.Ticket](backendKey, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.Equal(t, ticket.Client(), user)
+		assert.Equal(t, ticket.Flags(), protocol.FlagForwardable)
+		assert.True(t, !ticket.Flags().Has(protocol.FlagPreAuthent))
+		assert.True(t, !ticket.Flags().Has(protocol.FlagHWAuthent))
+	})
+}
+
+func TestExchange_CrossRealm(t *testing.T) {
+	athena := testkit.NewHarness(t)
+	sales := testkit.NewHarness(t)
+	ops := testkit.NewHarness(t)
+
+	key := func(b byte) protocol.SessionKey {
+		k, _ := protocol.NewSessionKey(bytes.Repeat([]byte{b}, 32))
+		return k
+	}
+	athenaKey, salesKey, opsKey := key(1), key(2), key(3)
+	athenaToSales, salesToOps, serviceKey := key(4), key(5), key(6)
+	tgtSessionKey := key(7)
+
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	salesApp, _ := protocol.NewPrincipal("http", "app", "SALES.EXAMPLE.COM")
+	opsApp, _ := protocol.NewPrincipal("http", "app", "OPS.EXAMPLE.COM")
+	athenaTGS, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	salesTGS, _ := protocol.NewKrbtgt("SALES.EXAMPLE.COM")
+	opsTGS, _ := protocol.NewKrbtgt("OPS.EXAMPLE.COM")
+	athenaToSalesTGS, _ := protocol.NewInterRealmKrbtgt("SALES.EXAMPLE.COM", "ATHENA.MIT.EDU")
+	salesToOpsTGS, _ := protocol.NewInterRealmKrbtgt("OPS.EXAMPLE.COM", "SALES.EXAMPLE.COM")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+
+	seed := func(h *testkit.Harness, p protocol.Principal, key protocol.SessionKey) {
+		h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+			PrimaryName: string(p.Primary()),
+			Instance:    string(p.Instance()),
+			Realm:       string(p.Realm()),
+			KeyBytes:    key.Expose(),
+			Kvno:        1,
+		})
+	}
+
+	// ATHENA trusts SALES directly and reaches OPS through SALES.
+	seed(athena, athenaTGS, athenaKey)
+	seed(athena, athenaToSalesTGS, athenaToSales)
+	seed(sales, salesTGS, salesKey)
+	seed(sales, athenaToSalesTGS, athenaToSales)
+	seed(sales, salesToOpsTGS, salesToOps)
+	seed(sales, salesApp, serviceKey)
+	seed(ops, opsTGS, opsKey)
+	seed(ops, salesToOpsTGS, salesToOps)
+	seed(ops, opsApp, serviceKey)
+
+	athenaKDC := tgs.NewExchange(athena.NewKDCPlatform(), kdc.Config{
+		Realm:          "ATHENA.MIT.EDU",
+		TicketLifetime: 8 * time.Hour,
+		NextHops:       map[protocol.Realm]protocol.Realm{"OPS.EXAMPLE.COM": "SALES.EXAMPLE.COM"},
+	})
+	salesKDC := tgs.NewExchange(sales.NewKDCPlatform(), kdc.Config{
+		Realm:          "SALES.EXAMPLE.COM",
+		TicketLifetime: 8 * time.Hour,
+	})
+	opsKDC := func(transit ...protocol.Realm) *tgs.Exchange {
+		return tgs.NewExchange(ops.NewKDCPlatform(), kdc.Config{
+			Realm:          "OPS.EXAMPLE.COM",
+			TicketLifetime: 8 * time.Hour,
+			TransitRealms:  transit,
+		})
+	}
+
+	tgsReq := func(server protocol.Principal, tgt protocol.EncryptedData, sessionKey protocol.SessionKey, authTime time.Time) protocol.TGSReq {
+		auth, _ := protocol.NewAuthenticator(client, addr, authTime)
+		encAuth, _ := shared.EncryptEntity(codec.JSON, sessionKey, crypto.KeyUsageTGSReqAuth, auth)
+		nonce, _ := protocol.NewNonce(31337)
+		req, _ := protocol.NewTGSReq(server, tgt, encAuth, nonce)
+		return req
+	}
+
+	localTGT := func() protocol.EncryptedData {
+		tgt, _ := protocol.NewTicket(athenaTGS, client, addr, athena.Clock.Now(), 8*time.Hour, tgtSessionKey)
+		enc, _ := shared.EncryptEntity(codec.JSON, athenaKey, crypto.KeyUsageTicket, tgt.WithFlags(protocol.FlagInitial|protocol.FlagPreAuthent))
+		return enc
+	}
+
+	// referral runs a request at exchange and returns the ticket and session key
+	// it answers with, checking it was issued for want.
+	referral := func(t *testing.T, exchange *tgs.Exchange, req protocol.TGSReq, key, sessionKey protocol.SessionKey, want protocol.Principal) (protocol.Ticket, protocol.EncryptedData, protocol.SessionKey) {
+		t.Helper()
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, sessionKey))
+		assert.Err(t, err, nil)
+
+		repPart, err := shared.DecryptEntity[protocol.EncKDCRepPart](sessionKey, crypto.KeyUsageTGSRepEncPart, rep.SecretPart())
+		assert.Err(t, err, nil)
+		assert.Equal(t, repPart.Server(), want)
+
+		ticket, err := shared.DecryptEntity[protocol.Ticket](key, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		assert.Equal(t, ticket.Server(), want)
+		assert.Equal(t, ticket.Client(), client)
+		return ticket, rep.Ticket(), repPart.SessionKey()
+	}
+
+	t.Run("DirectTrust", func(t *testing.T) {
+		now := athena.Clock.Now()
+
+		_, crossTGT, crossKey := referral(t, athenaKDC,
+			tgsReq(salesApp, localTGT(), tgtSessionKey, now.Add(time.Millisecond)),
+			athenaToSales, tgtSessionKey, athenaToSalesTGS)
+
+		req := tgsReq(salesApp, crossTGT, crossKey, now.Add(2*time.Millisecond)).WithTGTRealm("ATHENA.MIT.EDU")
+		ticket, _, _ := referral(t, salesKDC, req, serviceKey, crossKey, salesApp)
+		assert.Equal(t, len(ticket.Transited()), 0)
+		assert.True(t, ticket.Flags().Has(protocol.FlagTransitedPolicyChecked))
+	})
+
+	t.Run("TransitThroughIntermediate", func(t *testing.T) {
+		now := athena.Clock.Now()
+
+		_, crossTGT, crossKey := referral(t, athenaKDC,
+			tgsReq(opsApp, localTGT(), tgtSessionKey, now.Add(3*time.Millisecond)),
+			athenaToSales, tgtSessionKey, athenaToSalesTGS)
+
+		req := tgsReq(opsApp, crossTGT, crossKey, now.Add(4*time.Millisecond)).WithTGTRealm("ATHENA.MIT.EDU")
+		_, opsTGT, opsSessionKey := referral(t, salesKDC, req, salesToOps, crossKey, salesToOpsTGS)
+
+		req = tgsReq(opsApp, opsTGT, opsSessionKey, now.Add(5*time.Millisecond)).WithTGTRealm("SALES.EXAMPLE.COM")
+		ticket, _, _ := referral(t, opsKDC("SALES.EXAMPLE.COM"), req, serviceKey, opsSessionKey, opsApp)
+		assert.Equal(t, ticket.Transited(), []protocol.Realm{"SALES.EXAMPLE.COM"})
+		assert.True(t, ticket.Flags().Has(protocol.FlagTransitedPolicyChecked))
+
+		// Without trusting SALES for transit, OPS refuses the path.
+		req = tgsReq(opsApp, opsTGT, opsSessionKey, now.Add(6*time.Millisecond)).WithTGTRealm("SALES.EXAMPLE.COM")
+		_, err := opsKDC().Handle(t.Context(), testkit.SignTGSReq(t, req, opsSessionKey))
+		assert.Err(t, err, protocol.KDCErrPolicy)
+	})
+
+	t.Run("UntrustedRealm", func(t *testing.T) {
+		now := athena.Clock.Now()
+		req := tgsReq(salesApp, localTGT(), tgtSessionKey, now.Add(7*time.Millisecond)).WithTGTRealm("EVIL.EXAMPLE.COM")
+
+		_, err := salesKDC.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, protocol.KDCErrPolicy)
+	})
+
+	t.Run("NoPathToRealm", func(t *testing.T) {
+		now := athena.Clock.Now()
+		unknown, _ := protocol.NewPrincipal("http", "app", "UNKNOWN.EXAMPLE.COM")
+		req := tgsReq(unknown, localTGT(), tgtSessionKey, now.Add(8*time.Millisecond))
+
+		_, err := athenaKDC.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, protocol.KDCErrSPrincipalUnknown)
+	})
+
+	t.Run("ForeignTGTForLocalClient", func(t *testing.T) {
+		// ATHENA cannot vouch for the principals of SALES.
+		now := athena.Clock.Now()
+		admin, _ := protocol.NewPrincipal("admin", "", "SALES.EXAMPLE.COM")
+		ticket, _ := protocol.NewTicket(athenaToSalesTGS, admin, addr, now, 8*time.Hour, tgtSessionKey)
+		forged, _ := shared.EncryptEntity(codec.JSON, athenaToSales, crypto.KeyUsageTicket, ticket.WithFlags(protocol.FlagPreAuthent))
+
+		auth, _ := protocol.NewAuthenticator(admin, addr, now.Add(10*time.Millisecond))
+		encAuth, _ := shared.EncryptEntity(codec.JSON, tgtSessionKey, crypto.KeyUsageTGSReqAuth, auth)
+		nonce, _ := protocol.NewNonce(31337)
+		req, _ := protocol.NewTGSReq(salesApp, forged, encAuth, nonce)
+
+		_, err := salesKDC.Handle(t.Context(), testkit.SignTGSReq(t, req.WithTGTRealm("ATHENA.MIT.EDU"), tgtSessionKey))
+		assert.Err(t, err, protocol.KDCErrPolicy)
+	})
+
+	t.Run("TGTForOtherService", func(t *testing.T) {
+		now := athena.Clock.Now()
+		ticket, _ := protocol.NewTicket(salesApp, client, addr, now, 8*time.Hour, tgtSessionKey)
+		forged, _ := shared.EncryptEntity(codec.JSON, athenaToSales, crypto.KeyUsageTicket, ticket)
+		req := tgsReq(salesApp, forged, tgtSessionKey, now.Add(9*time.Millisecond)).WithTGTRealm("ATHENA.MIT.EDU")
+
+		_, err := salesKDC.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, shared.ErrInvalidTicket)
+	})
+}
+
+func TestExchange_Claims(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	tgsKeyBytes, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+	frontendKeyBytes, _ := hex.DecodeString("aabbccddeeff00112233445566778899aabbccddeeff00112233445566778899")
+	backendKeyBytes, _ := hex.DecodeString("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	tgtSessionKeyBytes, _ := hex.DecodeString("1122334455667788990011223344556677889900112233445566778899001122")
+	tgsKey, _ := protocol.NewSessionKey(tgsKeyBytes)
+	frontendKey, _ := protocol.NewSessionKey(frontendKeyBytes)
+	backendKey, _ := protocol.NewSessionKey(backendKeyBytes)
+	tgtSessionKey, _ := protocol.NewSessionKey(tgtSessionKeyBytes)
+
+	user, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	frontend, _ := protocol.NewPrincipal("http", "frontend", "ATHENA.MIT.EDU")
+	backend, _ := protocol.NewPrincipal("postgres", "backend", "ATHENA.MIT.EDU")
+	tgsPrincipal, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+
+	rows := map[protocol.Principal]kdb.Principal{}
+	for _, p := range []struct {
+		principal protocol.Principal
+		key       []byte
+	}{
+		{tgsPrincipal, tgsKeyBytes},
+		{user, []byte("alice-key")},
+		{frontend, frontendKeyBytes},
+		{backend, backendKeyBytes},
+	} {
+		rows[p.principal] = h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+			PrimaryName: string(p.principal.Primary()),
+			Instance:    string(p.principal.Instance()),
+			Realm:       string(p.principal.Realm()),
+			KeyBytes:    p.key,
+			Kvno:        1,
+		})
+	}
+	h.AddToGroup(t.Context(), "staff", rows[user])
+	h.AddToGroup(t.Context(), "services", rows[frontend])
+
+	err := kdb.Query.AddDelegation(t.Context(), h.DB, kdb.AddDelegationParams{
+		ServicePrimary:  "http",
+		ServiceInstance: "frontend",
+		ServiceRealm:    "ATHENA.MIT.EDU",
+		TargetPrimary:   "postgres",
+		TargetInstance:  "backend",
+		TargetRealm:     "ATHENA.MIT.EDU",
+	})
+	assert.Err(t, err, nil)
+
+	exchange := tgs.NewExchange(h.NewKDCPlatform(), kdc.Config{
+		Realm:          "ATHENA.MIT.EDU",
+		TicketLifetime: 8 * time.Hour,
+	})
+
+	signed := func(client protocol.Principal, groups []string, serverKey, kdcKey protocol.SessionKey) protocol.AuthorizationData {
+		claims, err := protocol.NewClaims(groups, h.Clock.Now())
+		assert.Err(t, err, nil)
+		ad, err := shared.SignClaims(client, claims, serverKey, kdcKey)
+		assert.Err(t, err, nil)
+		return ad
+	}
+
+	// newReq builds a TGS-REQ sent by client with a fresh TGT carrying ad.
+	newReq := func(client, server protocol.Principal, ad protocol.AuthorizationData, authTime time.Time) protocol.TGSReq {
+		tgt, _ := protocol.NewTicket(tgsPrincipal, client, addr, h.Clock.Now(), 8*time.Hour, tgtSessionKey)
+		tgt = tgt.WithFlags(protocol.FlagForwardable | protocol.FlagPreAuthent).WithAuthorizationData(ad)
+		encTGT, _ := shared.EncryptEntity(codec.JSON, tgsKey, crypto.KeyUsageTicket, tgt)
+		auth, _ := protocol.NewAuthenticator(client, addr, authTime)
+		encAuth, _ := shared.EncryptEntity(codec.JSON, tgtSessionKey, crypto.KeyUsageTGSReqAuth, auth)
+		nonce, _ := protocol.NewNonce(777)
+		req, _ := protocol.NewTGSReq(server, encTGT, encAuth, nonce)
+		return testkit.SignTGSReq(t, req, tgtSessionKey)
+	}
+
+	evidence := func(ad protocol.AuthorizationData) protocol.EncryptedData {
+		ticket, _ := protocol.NewTicket(frontend, user, addr, h.Clock.Now(), time.Hour, tgtSessionKey)
+		enc, _ := shared.EncryptEntity(codec.JSON, frontendKey, crypto.KeyUsageTicket, ticket.WithFlags(protocol.FlagForwardable).WithAuthorizationData(ad))
+		return enc
+	}
+
+	claimsOf := func(t *testing.T, key protocol.SessionKey, rep protocol.TGSRep) (protocol.AuthorizationData, bool) {
+		ticket, err := shared.DecryptEntity[protocol.Ticket](key, crypto.KeyUsageTicket, rep.Ticket())
+		assert.Err(t, err, nil)
+		return ticket.AuthorizationData()
+	}
+
+	t.Run("CarriedFromTGT", func(t *testing.T) {
+		now := h.Clock.Now()
+		req := newReq(user, backend, signed(user, []string{"staff"}, tgsKey, tgsKey), now.Add(time.Millisecond))
+
+		rep, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, nil)
+
+		ad, ok := claimsOf(t, backendKey, rep)
+		assert.True(t, ok)
+		assert.Equal(t, ad.Claims().Groups()[0], "staff")
+		assert.Err(t, shared.VerifyKDCChecksum(ad, user, backendKey, tgsKey), nil)
+	})
+
+	t.Run("TGTWithoutClaims", func(t *testing.T) {
+		now := h.Clock.Now()
+		req := newReq(user, backend, protocol.AuthorizationData{}, now.Add(2*time.Millisecond))
+
+		rep, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, nil)
+
+		_, ok := claimsOf(t, backendKey, rep)
+		assert.True(t, !ok)
+	})
+
+	t.Run("ForgedTGTClaims", func(t *testing.T) {
+		now := h.Clock.Now()
+		req := newReq(user, backend, signed(user, []string{"admins"}, tgsKey, frontendKey), now.Add(3*time.Millisecond))
+
+		_, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, shared.ErrInvalidAuthzData)
+	})
+
+	t.Run("ClaimsOfOtherClient", func(t *testing.T) {
+		// Claims the KDC signed for the frontend cannot be moved onto
+		// alice's TGT.
+		now := h.Clock.Now()
+		req := newReq(user, backend, signed(frontend, []string{"services"}, tgsKey, tgsKey), now.Add(7*time.Millisecond))
+
+		_, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, shared.ErrInvalidAuthzData)
+	})
+
+	t.Run("ForeignClientClaimsDropped", func(t *testing.T) {
+		// SALES may sign whatever claims it likes under the inter-realm
+		// key; they name its groups, not this realm's.
+		now := h.Clock.Now()
+		carol, _ := protocol.NewPrincipal("carol", "", "SALES.EXAMPLE.COM")
+		crossTGS, _ := protocol.NewInterRealmKrbtgt("ATHENA.MIT.EDU", "SALES.EXAMPLE.COM")
+		crossKey, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0x5a}, 32))
+		h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+			PrimaryName: string(crossTGS.Primary()),
+			Instance:    string(crossTGS.Instance()),
+			Realm:       string(crossTGS.Realm()),
+			KeyBytes:    crossKey.Expose(),
+			Kvno:        1,
+		})
+
+		tgt, _ := protocol.NewTicket(crossTGS, carol, addr, now, 8*time.Hour, tgtSessionKey)
+		tgt = tgt.WithFlags(protocol.FlagPreAuthent).WithAuthorizationData(signed(carol, []string{"admins"}, crossKey, crossKey))
+		encTGT, _ := shared.EncryptEntity(codec.JSON, crossKey, crypto.KeyUsageTicket, tgt)
+		auth, _ := protocol.NewAuthenticator(carol, addr, now.Add(8*time.Millisecond))
+		encAuth, _ := shared.EncryptEntity(codec.JSON, tgtSessionKey, crypto.KeyUsageTGSReqAuth, auth)
+		nonce, _ := protocol.NewNonce(778)
+		req, _ := protocol.NewTGSReq(backend, encTGT, encAuth, nonce)
+
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req.WithTGTRealm("SALES.EXAMPLE.COM"), tgtSessionKey))
+		assert.Err(t, err, nil)
+
+		_, ok := claimsOf(t, backendKey, rep)
+		assert.True(t, !ok)
+	})
+
+	t.Run("SelfGetsUserClaims", func(t *testing.T) {
+		now := h.Clock.Now()
+		pa, _ := shared.NewForUser(tgtSessionKey, user)
+		req := newReq(frontend, frontend, signed(frontend, []string{"services"}, tgsKey, tgsKey), now.Add(4*time.Millisecond))
+		req = testkit.SignTGSReq(t, req.WithPAData(pa), tgtSessionKey)
+
+		rep, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, nil)
+
+		ad, ok := claimsOf(t, frontendKey, rep)
+		assert.True(t, ok)
+		assert.True(t, ad.Claims().InGroup("staff"))
+		assert.True(t, !ad.Claims().InGroup("services"))
+	})
+
+	t.Run("ProxyCarriesEvidenceClaims", func(t *testing.T) {
+		now := h.Clock.Now()
+		req := newReq(frontend, backend, signed(frontend, []string{"services"}, tgsKey, tgsKey), now.Add(5*time.Millisecond))
+		req = req.
+			WithOptions(protocol.OptCNameInAddlTkt).
+			WithAdditionalTickets(evidence(signed(user, []string{"staff"}, frontendKey, tgsKey)))
+
+		rep, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, nil)
+
+		ad, ok := claimsOf(t, backendKey, rep)
+		assert.True(t, ok)
+		assert.True(t, ad.Claims().InGroup("staff"))
+		assert.True(t, !ad.Claims().InGroup("services"))
+	})
+
+	t.Run("ProxyRewrittenEvidence", func(t *testing.T) {
+		// The frontend holds the key of the evidence, so it can redo the
+		// server checksum but not the KDC checksum.
+		now := h.Clock.Now()
+		req := newReq(frontend, backend, signed(frontend, []string{"services"}, tgsKey, tgsKey), now.Add(6*time.Millisecond))
+		req = req.
+			WithOptions(protocol.OptCNameInAddlTkt).
+			WithAdditionalTickets(evidence(signed(user, []string{"admins"}, frontendKey, frontendKey)))
+
+		_, err := exchange.Handle(t.Context(), testkit.SignTGSReq(t, req, tgtSessionKey))
+		assert.Err(t, err, shared.ErrInvalidAuthzData)
+	})
+}
+
+func TestExchange_FAST(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	clientKeyBytes, _ := hex.DecodeString("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+	tgsKeyBytes, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+	serviceKeyBytes, _ := hex.DecodeString("aabbccddeeff00112233445566778899aabbccddeeff00112233445566778899")
+	tgsKey, _ := protocol.NewSessionKey(tgsKeyBytes)
+	tgtSessionKey, _ := protocol.NewSessionKey(bytes.Repeat([]byte{5}, 32))
+	subkey, _ := protocol.NewSessionKey(bytes.Repeat([]byte{6}, 32))
+
+	alice, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	bob, _ := protocol.NewPrincipal("bob", "", "ATHENA.MIT.EDU")
+	tgsPrincipal, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	service, _ := protocol.NewPrincipal("http", "server.athena.mit.edu", "ATHENA.MIT.EDU")
+	unknown, _ := protocol.NewPrincipal("http", "unknown.athena.mit.edu", "ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	nonce, _ := protocol.NewNonce(4242)
+
+	for _, p := range []testkit.PrincipalParams{
+		{PrimaryName: "alice", Realm: "ATHENA.MIT.EDU", KeyBytes: clientKeyBytes, Kvno: 1},
+		{PrimaryName: "bob", Realm: "ATHENA.MIT.EDU", KeyBytes: clientKeyBytes, Kvno: 1, Flags: kdb.FlagRequiresFAST},
+		{PrimaryName: "krbtgt", Instance: "ATHENA.MIT.EDU", Realm: "ATHENA.MIT.EDU", KeyBytes: tgsKeyBytes, Kvno: 1},
+		{PrimaryName: "http", Instance: "server.athena.mit.edu", Realm: "ATHENA.MIT.EDU", KeyBytes: serviceKeyBytes, Kvno: 1},
+	} {
+		h.CreatePrincipal(t.Context(), p)
+	}
+
+	exchange := tgs.NewExchange(h.NewKDCPlatform(), kdc.Config{
+		Realm:          "ATHENA.MIT.EDU",
+		TicketLifetime: 8 * time.Hour,
+	})
+
+	armorKey, err := shared.ArmorKey(subkey, tgtSessionKey)
+	assert.Err(t, err, nil)
+
+	// request builds a TGS-REQ from client for server whose authenticator,
+	// unique to the call, carries subkey unless it is zero.
+	tick := 0
+	request := func(t *testing.T, client, server protocol.Principal, subkey protocol.SessionKey) protocol.TGSReq {
+		t.Helper()
+		ticket, _ := protocol.NewTicket(tgsPrincipal, client, addr, h.Clock.Now(), 8*time.Hour, tgtSessionKey)
+		tgt, _ := shared.EncryptEntity(codec.JSON, tgsKey, crypto.KeyUsageTicket, ticket)
+
+		tick++
+		auth, _ := protocol.NewAuthenticator(client, addr, h.Clock.Now().Add(time.Duration(tick)*time.Millisecond))
+		if !subkey.IsZero() {
+			auth = auth.WithSubkey(subkey)
+		}
+
+		req, _ := protocol.NewTGSReq(server, tgt, protocol.EncryptedData{}, nonce)
+		req, err := shared.SealTGSAuthenticator(codec.JSON, req, tgtSessionKey, auth)
+		assert.Err(t, err, nil)
+		return req
+	}
+
+	armored := func(t *testing.T, client, server protocol.Principal) protocol.TGSReq {
+		t.Helper()
+		req, err := shared.ArmorTGSReq(codec.JSON, request(t, client, server, subkey), armorKey)
+		assert.Err(t, err, nil)
+		return req
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		rep, err := exchange.Handle(t.Context(), armored(t, alice, service))
+		assert.Err(t, err, nil)
+
+		res, err := shared.OpenFastReply(armorKey, rep, nonce)
+		assert.Err(t, err, nil)
+
+		finished, ok := res.Finished()
+		assert.True(t, ok)
+		assert.Equal(t, finished.Client(), alice)
+
+		// TGS replies are not strengthened; they come back under the subkey.
+		_, ok = res.StrengthenKey()
+		assert.True(t, !ok)
+
+		encPart, err := shared.DecryptEntity[protocol.EncKDCRepPart](subkey, crypto.KeyUsageTGSRepEncPartSub, rep.SecretPart())
+		assert.Err(t, err, nil)
+		assert.Equal(t, encPart.Server(), service)
+	})
+
+	t.Run("ProtectedError", func(t *testing.T) {
+		_, err := exchange.Handle(t.Context(), armored(t, alice, unknown))
+		assert.Err(t, err, protocol.KDCErrSPrincipalUnknown)
+
+		krbErr := shared.NewKRBError(codec.JSON, err, "ATHENA.MIT.EDU", h.Clock.Now())
+		assert.Equal(t, krbErr.Text(), "")
+
+		inner, err := shared.OpenFastError(armorKey, krbErr, nonce)
+		assert.Err(t, err, nil)
+		assert.Equal(t, inner.Code(), protocol.KDCErrSPrincipalUnknown)
+		assert.True(t, inner.Text() != "")
+	})
+
+	t.Run("NoSubkey", func(t *testing.T) {
+		req, err := shared.ArmorTGSReq(codec.JSON, request(t, alice, service, protocol.SessionKey{}), armorKey)
+		assert.Err(t, err, nil)
+
+		_, err = exchange.Handle(t.Context(), req)
+		assert.Err(t, err, shared.ErrInvalidArmor)
+	})
+
+	t.Run("Modified", func(t *testing.T) {
+		// A FastReq sealed for another request does not match this AP-REQ.
+		other := armored(t, alice, service)
+		req := request(t, alice, service, subkey).WithPAData(other.PAData()...)
+
+		_, err := exchange.Handle(t.Context(), req)
+		assert.Err(t, err, shared.ErrModified)
+	})
+
+	t.Run("RequiresFAST", func(t *testing.T) {
+		_, err := exchange.Handle(t.Context(), request(t, bob, service, protocol.SessionKey{}))
+		assert.Err(t, err, protocol.KDCErrPolicy)
+
+		_, err = exchange.Handle(t.Context(), armored(t, bob, service))
 		assert.Err(t, err, nil)
 	})
 }
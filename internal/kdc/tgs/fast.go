@@ -0,0 +1,73 @@
+package tgs
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/kdb"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// armor derives the armor of a FAST TGS-REQ. The TGT is its own armor: the
+// key comes from the subkey of the authenticator and the TGT session key
+// (RFC 6113 §5.4.1.1).
+func (e *Exchange) armor(req protocol.TGSReq, tgt protocol.Ticket, auth protocol.Authenticator) (shared.Armor, error) {
+	subkey, ok := auth.Subkey()
+	if !ok {
+		return shared.Armor{}, fmt.Errorf("%w: authenticator carries no subkey", shared.ErrInvalidArmor)
+	}
+
+	key, err := shared.ArmorKey(subkey, tgt.SessionKey())
+	if err != nil {
+		return shared.Armor{}, fmt.Errorf("%w: %w", protocol.KRBErrGeneric, err)
+	}
+	return shared.Armor{Key: key, Nonce: req.Nonce()}, nil
+}
+
+// openFastReq opens the FastReq carried in pa and returns the TGS-REQ it
+// seals, presenting the TGT and authenticator of req. The FAST checksum
+// covers the AP-REQ of req, whose authenticator already binds the body.
+func (e *Exchange) openFastReq(c codec.Codec, req protocol.TGSReq, pa protocol.PAData, armor shared.Armor) (protocol.TGSReq, error) {
+	var armored protocol.FastArmoredReq
+	if err := shared.DecodePAData(pa, &armored); err != nil {
+		return protocol.TGSReq{}, fmt.Errorf("%w: malformed PA-FX-FAST: %w", shared.ErrInvalidArmor, err)
+	}
+
+	apReq, err := shared.TGSReqAPReq(c, req)
+	if err != nil {
+		return protocol.TGSReq{}, fmt.Errorf("%w: %w", protocol.KRBErrGeneric, err)
+	}
+
+	fast, err := shared.OpenFastReq(armor.Key, armored, apReq)
+	if err != nil {
+		return protocol.TGSReq{}, err
+	}
+
+	inner, err := fast.TGSReq(req)
+	if err != nil {
+		return protocol.TGSReq{}, fmt.Errorf("%w: %w", shared.ErrInvalidArmor, err)
+	}
+	return inner, nil
+}
+
+// checkFASTPolicy refuses an unarmored request from a client of this realm
+// that must use FAST.
+func (e *Exchange) checkFASTPolicy(ctx context.Context, client protocol.Principal) error {
+	if client.Realm() != e.cfg.Realm {
+		return nil
+	}
+
+	entry, err := shared.FetchPrincipal(ctx, e.db, e.logger, client)
+	if err != nil {
+		// The rest of the exchange decides what becomes of a client
+		// missing from the database.
+		return nil
+	}
+
+	if entry.Flags.Has(kdb.FlagRequiresFAST) {
+		return fmt.Errorf("%w: %s must use FAST", protocol.KDCErrPolicy, client)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package tgs
+
+import (
+	"fmt"
+
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// grantable pairs each option a TGS-REQ may ask for with the TGT flag that
+// permits it and the flag it sets on the new ticket.
+var grantable = []struct {
+	option   protocol.KDCOptions
+	requires protocol.TicketFlags
+	grants   protocol.TicketFlags
+}{
+	{protocol.OptForwardable, protocol.FlagForwardable, protocol.FlagForwardable},
+	{protocol.OptForwarded, protocol.FlagForwardable, protocol.FlagForwarded},
+	{protocol.OptProxiable, protocol.FlagProxiable, protocol.FlagProxiable},
+	{protocol.OptAllowPostdate, protocol.FlagMayPostdate, protocol.FlagMayPostdate},
+	{protocol.OptRenewable, protocol.FlagRenewable, protocol.FlagRenewable},
+}
+
+// inherited are the TGT flags every ticket issued from it keeps.
+var inherited = []protocol.TicketFlags{
+	protocol.FlagForwarded,
+	protocol.FlagPreAuthent,
+	protocol.FlagHWAuthent,
+}
+
+// ticketFlags decides the flags of a ticket issued from tgt. A requested
+// option is only granted when the TGT carries the flag that permits it;
+// INITIAL is never set, and FORWARDED, PRE-AUTHENT and HW-AUTHENT are
+// inherited from the TGT.
+func ticketFlags(options protocol.KDCOptions, tgt protocol.Ticket) (protocol.TicketFlags, error) {
+	var flags protocol.TicketFlags
+
+	for _, g := range grantable {
+		if !options.Has(g.option) {
+			continue
+		}
+		if !tgt.Flags().Has(g.requires) {
+			return 0, fmt.Errorf("%w: TGT is not %s", protocol.KDCErrBadOption, g.requires)
+		}
+		flags = flags.With(g.grants)
+	}
+
+	for _, flag := range inherited {
+		if tgt.Flags().Has(flag) {
+			flags = flags.With(flag)
+		}
+	}
+
+	return flags, nil
+}
@@ -1,9 +1,11 @@
 package tgs
 
 import (
-	"errors"
+	"fmt"
 	"net/http"
 
+	"github.com/rizesql/kerberos/internal/clock"
+	"github.com/rizesql/kerberos/internal/codec"
 	"github.com/rizesql/kerberos/internal/kdc"
 	"github.com/rizesql/kerberos/internal/kdc/shared"
 	"github.com/rizesql/kerberos/internal/o11y/logging"
@@ -15,45 +17,50 @@ type Handler struct {
 	protocol.TGSEndpoint
 	exchange *Exchange
 	logger   *logging.Logger
+	clock    clock.Clock
+	realm    protocol.Realm
 }
 
 func NewHandler(platform *kdc.Platform, cfg kdc.Config) *Handler {
 	return &Handler{
 		exchange: NewExchange(platform, cfg),
 		logger:   platform.Logger,
+		clock:    platform.Clock,
+		realm:    cfg.Realm,
 	}
 }
 
 func (h *Handler) Handle() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		req, err := server.Decode[protocol.TGSReq](r)
+		c := codec.ForContentType(r.Header.Get("Content-Type"))
+
+		req, err := server.DecodeWith[protocol.TGSReq](r, c)
 		if err != nil {
 			h.logger.Error("failed to decode TGS request", "err", err)
-			server.EncodeError(w, http.StatusBadRequest, err)
+			h.handleError(w, c, fmt.Errorf("%w: %w", protocol.KRBAPErrMsgType, err))
 			return
 		}
 
-		res, err := h.exchange.Handle(r.Context(), req)
+		res, err := h.exchange.Handle(codec.NewContext(r.Context(), c), req)
 		if err != nil {
-			h.handleError(w, err)
+			h.handleError(w, c, err)
 			return
 		}
 
-		if err := server.Encode(w, http.StatusOK, res); err != nil {
+		if err := server.EncodeWith(w, http.StatusOK, c, res); err != nil {
 			server.EncodeError(w, http.StatusInternalServerError, err)
 			return
 		}
 	}
 }
 
-func (h *Handler) handleError(w http.ResponseWriter, err error) {
-	switch {
-	case errors.Is(err, shared.ErrWrongRealm):
-		server.EncodeError(w, http.StatusBadRequest, err)
-	case errors.Is(err, shared.ErrPrincipalNotFound):
-		server.EncodeError(w, http.StatusNotFound, err)
-	default:
+func (h *Handler) handleError(w http.ResponseWriter, c codec.Codec, err error) {
+	krbErr := shared.NewKRBError(c, err, h.realm, h.clock.Now())
+	if krbErr.Code() == protocol.KRBErrGeneric {
 		h.logger.Error("TGS exchange failed", "err", err)
+	}
+
+	if err := server.EncodeWith(w, shared.HTTPStatus(krbErr.Code()), c, krbErr); err != nil {
 		server.EncodeError(w, http.StatusInternalServerError, err)
 	}
 }
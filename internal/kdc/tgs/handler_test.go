@@ -8,8 +8,8 @@ import (
 	"time"
 
 	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/codec"
 	"github.com/rizesql/kerberos/internal/crypto"
-	"github.com/rizesql/kerberos/internal/kdb"
 	"github.com/rizesql/kerberos/internal/kdc"
 	"github.com/rizesql/kerberos/internal/kdc/shared"
 	"github.com/rizesql/kerberos/internal/kdc/tgs"
@@ -38,21 +38,21 @@ func TestHandler(t *testing.T) {
 	clientAddr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
 
 	// Seed DB
-	h.CreatePrincipal(t.Context(), kdb.CreatePrincipalParams{
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
 		PrimaryName: "alice",
 		Instance:    "",
 		Realm:       "ATHENA.MIT.EDU",
 		KeyBytes:    clientKeyBytes,
 		Kvno:        1,
 	})
-	h.CreatePrincipal(t.Context(), kdb.CreatePrincipalParams{
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
 		PrimaryName: "krbtgt",
 		Instance:    "ATHENA.MIT.EDU",
 		Realm:       "ATHENA.MIT.EDU",
 		KeyBytes:    tgsKeyBytes,
 		Kvno:        1,
 	})
-	h.CreatePrincipal(t.Context(), kdb.CreatePrincipalParams{
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
 		PrimaryName: "http",
 		Instance:    "server.athena.mit.edu",
 		Realm:       "ATHENA.MIT.EDU",
@@ -77,13 +77,13 @@ func TestHandler(t *testing.T) {
 			tgtSessionKey,
 		)
 		tgsKey, _ := protocol.NewSessionKey(tgsKeyBytes)
-		encTGT, _ := shared.EncryptEntity(tgsKey, tgt)
+		encTGT, _ := shared.EncryptEntity(codec.JSON, tgsKey, crypto.KeyUsageTicket, tgt)
 		return encTGT
 	}
 
 	createValidAuthenticator := func(issuedAt time.Time) protocol.EncryptedData {
 		auth, _ := protocol.NewAuthenticator(client, clientAddr, issuedAt)
-		encAuth, _ := shared.EncryptEntity(tgtSessionKey, auth)
+		encAuth, _ := shared.EncryptEntity(codec.JSON, tgtSessionKey, crypto.KeyUsageTGSReqAuth, auth)
 		return encAuth
 	}
 
@@ -93,6 +93,7 @@ func TestHandler(t *testing.T) {
 	nonce, _ := protocol.NewNonce(12345)
 
 	req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, encAuth, nonce)
+	req = testkit.SignTGSReq(t, req, tgtSessionKey)
 
 	// Call
 	res := testkit.Call[protocol.TGSReq, protocol.TGSRep](t, srv, tgs, nil, req)
@@ -101,7 +102,7 @@ func TestHandler(t *testing.T) {
 		t.Fatal("response body is nil")
 	}
 
-	secretPartBytes, err := crypto.Decrypt(tgtSessionKey, res.Body.SecretPart().Ciphertext())
+	secretPartBytes, err := crypto.Decrypt(tgtSessionKey, crypto.KeyUsageTGSRepEncPart, res.Body.SecretPart().Ciphertext())
 	assert.Err(t, err, nil)
 
 	var encPart protocol.EncKDCRepPart
@@ -114,7 +115,7 @@ func TestHandler(t *testing.T) {
 	assert.Equal(t, string(encPart.SessionKey().Expose()), string(expectedNewSessionKey.Expose()))
 
 	serviceKey, _ := protocol.NewSessionKey(serviceKeyBytes)
-	ticketBytes, err := crypto.Decrypt(serviceKey, res.Body.Ticket().Ciphertext())
+	ticketBytes, err := crypto.Decrypt(serviceKey, crypto.KeyUsageTicket, res.Body.Ticket().Ciphertext())
 	assert.Err(t, err, nil)
 
 	var ticket protocol.Ticket
@@ -126,3 +127,73 @@ func TestHandler(t *testing.T) {
 	assert.Equal(t, ticket.IssuedAt().Equal(now), true)
 	assert.Equal(t, string(ticket.SessionKey().Expose()), string(expectedNewSessionKey.Expose()))
 }
+
+func TestHandler_DER(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	tgsKeyBytes, _ := hex.DecodeString("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+	serviceKeyBytes, _ := hex.DecodeString("aabbccddeeff00112233445566778899aabbccddeeff00112233445566778899")
+	tgtSessionKeyBytes, _ := hex.DecodeString("1122334455667788990011223344556677889900112233445566778899001122")
+
+	tgsKey, _ := protocol.NewSessionKey(tgsKeyBytes)
+	serviceKey, _ := protocol.NewSessionKey(serviceKeyBytes)
+	tgtSessionKey, _ := protocol.NewSessionKey(tgtSessionKeyBytes)
+
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	tgsPrincipal, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	servicePrincipal, _ := protocol.NewPrincipal("http", "server.athena.mit.edu", "ATHENA.MIT.EDU")
+	clientAddr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "krbtgt",
+		Instance:    "ATHENA.MIT.EDU",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    tgsKeyBytes,
+		Kvno:        1,
+	})
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "http",
+		Instance:    "server.athena.mit.edu",
+		Realm:       "ATHENA.MIT.EDU",
+		KeyBytes:    serviceKeyBytes,
+		Kvno:        1,
+	})
+
+	srv := h.NewServer()
+	tgs := tgs.NewHandler(h.NewKDCPlatform(), kdc.Config{
+		Realm:          "ATHENA.MIT.EDU",
+		TicketLifetime: 8 * time.Hour,
+	})
+	srv.Register(tgs)
+
+	// KerberosTime has no fractional seconds
+	now := h.Clock.Now().Truncate(time.Second)
+	tgt, _ := protocol.NewTicket(tgsPrincipal, client, clientAddr, now, 8*time.Hour, tgtSessionKey)
+	encTGT, err := shared.EncryptTicket(codec.DER, shared.PrincipalKey{Key: tgsKey, Kvno: 1}, tgt)
+	assert.Err(t, err, nil)
+
+	auth, _ := protocol.NewAuthenticator(client, clientAddr, h.Clock.Now())
+	nonce, _ := protocol.NewNonce(12345)
+	req, _ := protocol.NewTGSReq(servicePrincipal, encTGT, protocol.EncryptedData{}, nonce)
+	req, err = shared.SealTGSAuthenticator(codec.DER, req, tgtSessionKey, auth)
+	assert.Err(t, err, nil)
+
+	headers := http.Header{"Content-Type": {codec.DERContentType}}
+	res := testkit.Call[protocol.TGSReq, protocol.TGSRep](t, srv, tgs, headers, req)
+	assert.Equal(t, res.Status, http.StatusOK)
+	if res.Body == nil {
+		t.Fatal("response body is nil")
+	}
+	assert.Equal(t, res.Body.Client(), client)
+
+	encPart, err := shared.DecryptEntity[protocol.EncKDCRepPart](tgtSessionKey, crypto.KeyUsageTGSRepEncPart, res.Body.SecretPart())
+	assert.Err(t, err, nil)
+	assert.Equal(t, encPart.Nonce(), nonce)
+	assert.Equal(t, encPart.Server(), servicePrincipal)
+
+	ticket, err := shared.DecryptTicket(serviceKey, res.Body.Ticket())
+	assert.Err(t, err, nil)
+	assert.Equal(t, ticket.Client(), client)
+	assert.Equal(t, ticket.Server(), servicePrincipal)
+	assert.Equal(t, string(ticket.SessionKey().Expose()), string(encPart.SessionKey().Expose()))
+}
@@ -0,0 +1,167 @@
+package tgs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// issuance holds the properties of the ticket a TGS request is answered with.
+type issuance struct {
+	client     protocol.Principal
+	flags      protocol.TicketFlags
+	clientAddr protocol.Address
+	startTime  time.Time
+	lifetime   time.Duration
+	renewTill  time.Time
+	transited  []protocol.Realm
+	// claims are the client's claims carried into the ticket, nil if it
+	// gets none.
+	claims *protocol.Claims
+}
+
+// issuance decides the flags and validity of the ticket issued for req.
+// An INVALID ticket can only be validated. A POSTDATED ticket needs a
+// MAY-POSTDATE TGT and is issued INVALID. The end time is the earliest of
+// the requested till, the TGT's end time, the lifetime limits of the realm,
+// client and service and, for renewable tickets, the renew-till.
+func (e *Exchange) issuance(
+	req protocol.TGSReq,
+	tgt protocol.Ticket,
+	now time.Time,
+	limits shared.Limits,
+) (issuance, error) {
+	switch {
+	case req.Options().Has(protocol.OptValidate):
+		return e.validation(req, tgt, now)
+	case tgt.Flags().Has(protocol.FlagInvalid):
+		return issuance{}, fmt.Errorf("%w: ticket must be validated first", protocol.KRBAPErrTktNYV)
+	case req.Options().Has(protocol.OptRenew):
+		return e.renewal(req, tgt, now, limits)
+	}
+
+	flags, err := ticketFlags(req.Options(), tgt)
+	if err != nil {
+		return issuance{}, err
+	}
+
+	issue := issuance{
+		client:     tgt.Client(),
+		flags:      flags,
+		clientAddr: tgt.ClientAddr(),
+	}
+
+	// Only a FORWARDED ticket may be used from another address.
+	if req.Options().Has(protocol.OptForwarded) && !req.ClientAddr().IsZero() {
+		issue.clientAddr = req.ClientAddr()
+	}
+
+	start := now
+	switch {
+	case req.Options().Has(protocol.OptPostdated):
+		if !tgt.Flags().Has(protocol.FlagMayPostdate) {
+			return issuance{}, fmt.Errorf("%w: TGT is not %s", protocol.KDCErrBadOption, protocol.FlagMayPostdate)
+		}
+		if req.From().IsZero() {
+			return issuance{}, fmt.Errorf("%w: POSTDATED requires a start time", protocol.KDCErrBadOption)
+		}
+		if req.From().After(now) {
+			start = req.From()
+			issue.startTime = start
+			issue.flags = issue.flags.With(protocol.FlagPostdated | protocol.FlagInvalid)
+		}
+	case req.From().After(now.Add(e.maxSkew)):
+		return issuance{}, fmt.Errorf("%w: start time is in the future", protocol.KDCErrCannotPostdate)
+	}
+
+	end := start.Add(limits.MaxLife)
+	if till := req.Till(); !till.IsZero() && till.Before(end) {
+		end = till
+	}
+	if tgt.EndTime().Before(end) {
+		end = tgt.EndTime()
+	}
+	if !end.After(start) {
+		return issuance{}, fmt.Errorf("%w: requested end time is before the start time", protocol.KDCErrNeverValid)
+	}
+
+	if issue.flags.Has(protocol.FlagRenewable) {
+		if limits.MaxRenewableLife <= 0 {
+			issue.flags = issue.flags.Without(protocol.FlagRenewable)
+		} else {
+			issue.renewTill = start.Add(limits.MaxRenewableLife)
+			if tgt.RenewTill().Before(issue.renewTill) {
+				issue.renewTill = tgt.RenewTill()
+			}
+			if issue.renewTill.Before(end) {
+				end = issue.renewTill
+			}
+		}
+	}
+
+	issue.lifetime = end.Sub(start)
+	return issue, nil
+}
+
+// validation answers a VALIDATE request. The presented ticket must be
+// INVALID and its start time must have arrived; the new ticket is the same
+// ticket with INVALID cleared.
+func (e *Exchange) validation(req protocol.TGSReq, tgt protocol.Ticket, now time.Time) (issuance, error) {
+	if !tgt.Flags().Has(protocol.FlagInvalid) {
+		return issuance{}, fmt.Errorf("%w: ticket is not %s", protocol.KDCErrBadOption, protocol.FlagInvalid)
+	}
+	if req.Server() != tgt.Server() {
+		return issuance{}, fmt.Errorf("%w: validated ticket is for %s, not %s",
+			protocol.KDCErrBadOption, tgt.Server(), req.Server())
+	}
+	if now.Before(tgt.StartTime()) {
+		return issuance{}, fmt.Errorf("%w: ticket starts at %s", protocol.KRBAPErrTktNYV, tgt.StartTime())
+	}
+
+	return issuance{
+		client:     tgt.Client(),
+		flags:      tgt.Flags().Without(protocol.FlagInvalid).Without(protocol.FlagInitial),
+		clientAddr: tgt.ClientAddr(),
+		startTime:  tgt.StartTime(),
+		lifetime:   tgt.Lifetime(),
+		renewTill:  tgt.RenewTill(),
+	}, nil
+}
+
+// renewal answers a RENEW request. The presented ticket must be renewable and
+// still before its renew-till; the new ticket keeps its server, flags and
+// renew-till and gets a fresh end time that never passes renew-till.
+func (e *Exchange) renewal(
+	req protocol.TGSReq,
+	tgt protocol.Ticket,
+	now time.Time,
+	limits shared.Limits,
+) (issuance, error) {
+	if !tgt.Flags().Has(protocol.FlagRenewable) {
+		return issuance{}, fmt.Errorf("%w: ticket is not renewable", protocol.KDCErrBadOption)
+	}
+	if req.Server() != tgt.Server() {
+		return issuance{}, fmt.Errorf("%w: renewed ticket is for %s, not %s",
+			protocol.KDCErrBadOption, tgt.Server(), req.Server())
+	}
+	if !now.Before(tgt.RenewTill()) {
+		return issuance{}, fmt.Errorf("%w: renew-till has passed", shared.ErrTicketExpired)
+	}
+
+	return issuance{
+		client:     tgt.Client(),
+		flags:      tgt.Flags().Without(protocol.FlagInitial),
+		clientAddr: tgt.ClientAddr(),
+		lifetime:   capLifetime(limits.MaxLife, now, tgt.RenewTill()),
+		renewTill:  tgt.RenewTill(),
+	}, nil
+}
+
+func capLifetime(lifetime time.Duration, now, limit time.Time) time.Duration {
+	if remaining := limit.Sub(now); remaining < lifetime {
+		return remaining
+	}
+	return lifetime
+}
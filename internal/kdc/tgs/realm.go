@@ -0,0 +1,78 @@
+package tgs
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// tgsPrincipal names the ticket-granting service the TGT of req was issued
+// for: krbtgt/THIS@THIS for a local TGT, krbtgt/THIS@OTHER for a
+// cross-realm TGT issued by OTHER.
+func (e *Exchange) tgsPrincipal(req protocol.TGSReq) (protocol.Principal, error) {
+	issuer := req.TGTRealm()
+	if issuer == "" {
+		issuer = e.cfg.Realm
+	}
+
+	return protocol.NewInterRealmKrbtgt(e.cfg.Realm, issuer)
+}
+
+// checkIssuer refuses a cross-realm TGT naming a client of this realm: a
+// foreign KDC can vouch only for clients that are not ours, or any realm
+// this one trusts could issue its tickets for its own principals.
+func (e *Exchange) checkIssuer(req protocol.TGSReq, tgt protocol.Ticket) error {
+	issuer := req.TGTRealm()
+	if issuer == "" || issuer == e.cfg.Realm {
+		return nil
+	}
+
+	if tgt.Client().Realm() == e.cfg.Realm {
+		return fmt.Errorf("%w: realm %s cannot vouch for %s", protocol.KDCErrPolicy, issuer, tgt.Client())
+	}
+	return nil
+}
+
+// route names the principal the ticket for server is issued to. Services of
+// this realm are served directly; for any other realm the client gets a
+// referral TGT to the next realm on the path, which is the target realm
+// itself unless a next hop is configured for it.
+func (e *Exchange) route(server protocol.Principal) (protocol.Principal, error) {
+	if server.Realm() == e.cfg.Realm {
+		return server, nil
+	}
+
+	next := server.Realm()
+	if hop, ok := e.cfg.NextHops[next]; ok {
+		next = hop
+	}
+
+	return protocol.NewInterRealmKrbtgt(next, e.cfg.Realm)
+}
+
+// transit records the realm that issued tgt in the transited field of the
+// new ticket and checks the resulting path against the transit policy. The
+// client's own realm and this realm are never recorded. Tickets of clients
+// from other realms are marked TRANSITED-POLICY-CHECKED.
+func (e *Exchange) transit(req protocol.TGSReq, tgt protocol.Ticket, issue issuance) (issuance, error) {
+	issue.transited = tgt.Transited()
+
+	clientRealm := tgt.Client().Realm()
+	if issuer := req.TGTRealm(); issuer != "" && issuer != e.cfg.Realm && issuer != clientRealm {
+		issue.transited = append(issue.transited, issuer)
+	}
+
+	if clientRealm == e.cfg.Realm {
+		return issue, nil
+	}
+
+	for _, realm := range issue.transited {
+		if !slices.Contains(e.cfg.TransitRealms, realm) {
+			return issuance{}, fmt.Errorf("%w: realm %s is not trusted for transit", protocol.KDCErrPolicy, realm)
+		}
+	}
+
+	issue.flags = issue.flags.With(protocol.FlagTransitedPolicyChecked)
+	return issue, nil
+}
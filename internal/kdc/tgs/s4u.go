@@ -0,0 +1,240 @@
+package tgs
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/kdb"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// s4u applies the Service-for-User extensions (MS-SFU) to issue. With a
+// PA-FOR-USER a service gets a ticket to itself on behalf of a user
+// (S4U2Self); with CNAME-IN-ADDL-TKT it exchanges such a ticket for one to a
+// backend it may delegate to (S4U2Proxy). Other requests are left as they
+// are.
+func (e *Exchange) s4u(
+	ctx context.Context,
+	req protocol.TGSReq,
+	tgt protocol.Ticket,
+	now time.Time,
+	issue issuance,
+) (issuance, error) {
+	forUser, self := req.PAData().Find(protocol.PATypeForUser)
+	proxy := req.Options().Has(protocol.OptCNameInAddlTkt)
+	if !self && !proxy {
+		return issue, nil
+	}
+
+	switch {
+	case self && proxy:
+		return issuance{}, fmt.Errorf("%w: PA-FOR-USER cannot be combined with %s",
+			protocol.KDCErrBadOption, "CNAME-IN-ADDL-TKT")
+	case req.Options().Has(protocol.OptRenew), req.Options().Has(protocol.OptValidate):
+		return issuance{}, fmt.Errorf("%w: S4U requests cannot renew or validate", protocol.KDCErrBadOption)
+	case self:
+		return e.protocolTransition(ctx, req, tgt, forUser, now, issue)
+	default:
+		return e.constrainedDelegation(ctx, req, tgt, now, issue)
+	}
+}
+
+// protocolTransition answers an S4U2Self request. The ticket is issued to the
+// requesting service itself, with the named user as its client. The user did
+// not authenticate to the KDC, so the service's authentication flags are not
+// carried over; the ticket is FORWARDABLE only if the service may delegate.
+// It carries the user's claims, not the service's.
+func (e *Exchange) protocolTransition(
+	ctx context.Context,
+	req protocol.TGSReq,
+	tgt protocol.Ticket,
+	pa protocol.PAData,
+	now time.Time,
+	issue issuance,
+) (issuance, error) {
+	var enc protocol.EncryptedData
+	if err := shared.DecodePAData(pa, &enc); err != nil {
+		return issuance{}, fmt.Errorf("%w: malformed PA-FOR-USER: %w", protocol.KRBAPErrBadIntegrity, err)
+	}
+
+	forUser, err := shared.DecryptEntity[protocol.PAForUser](tgt.SessionKey(), crypto.KeyUsagePAForUser, enc)
+	if err != nil {
+		e.logger.Warn("failed to decrypt PA-FOR-USER", "err", err)
+		return issuance{}, fmt.Errorf("%w: invalid PA-FOR-USER", protocol.KRBAPErrBadIntegrity)
+	}
+
+	if req.Server() != tgt.Client() {
+		return issuance{}, fmt.Errorf("%w: S4U2Self ticket must be for %s, not %s",
+			protocol.KDCErrBadOption, tgt.Client(), req.Server())
+	}
+
+	user := forUser.User()
+	if user.Realm() != e.cfg.Realm {
+		return issuance{}, fmt.Errorf("%w: user %s", shared.ErrWrongRealm, user)
+	}
+	if _, err := shared.FetchPrincipal(ctx, e.db, e.logger, user); err != nil {
+		return issuance{}, err
+	}
+
+	claims, err := shared.FetchClaims(ctx, e.db, user, now)
+	if err != nil {
+		return issuance{}, fmt.Errorf("%w: %w", protocol.KRBErrGeneric, err)
+	}
+
+	targets, err := e.delegationTargets(ctx, tgt.Client())
+	if err != nil {
+		return issuance{}, err
+	}
+
+	issue.client = user
+	issue.claims = &claims
+	issue.flags = issue.flags.Without(
+		protocol.FlagForwardable | protocol.FlagForwarded | protocol.FlagPreAuthent | protocol.FlagHWAuthent,
+	)
+	if len(targets) > 0 {
+		issue.flags = issue.flags.With(protocol.FlagForwardable)
+	}
+
+	return issue, nil
+}
+
+// constrainedDelegation answers an S4U2Proxy request. The additional ticket
+// is the evidence that the user authenticated to the requesting service: it
+// must be a FORWARDABLE ticket to that service. The backend must be on the
+// service's delegation list. The new ticket names the user as its client,
+// carries the claims of the evidence and never outlives it.
+func (e *Exchange) constrainedDelegation(
+	ctx context.Context,
+	req protocol.TGSReq,
+	tgt protocol.Ticket,
+	now time.Time,
+	issue issuance,
+) (issuance, error) {
+	tickets := req.AdditionalTickets()
+	if len(tickets) == 0 {
+		return issuance{}, fmt.Errorf("%w: CNAME-IN-ADDL-TKT requires an additional ticket", protocol.KDCErrBadOption)
+	}
+
+	service := tgt.Client()
+	serviceEntry, err := shared.FetchPrincipal(ctx, e.db, e.logger, service)
+	if err != nil {
+		return issuance{}, fmt.Errorf("%w: %w", protocol.KDCErrSPrincipalUnknown, err)
+	}
+
+	serviceKey, err := serviceEntry.KeyFor(tickets[0])
+	if err != nil {
+		return issuance{}, err
+	}
+
+	evidence, err := shared.DecryptTicket(serviceKey.Key, tickets[0])
+	if err != nil {
+		e.logger.Warn("failed to decrypt additional ticket", "err", err)
+		return issuance{}, fmt.Errorf("%w: invalid additional ticket", shared.ErrInvalidTicket)
+	}
+
+	switch {
+	case evidence.Server() != service:
+		return issuance{}, fmt.Errorf("%w: additional ticket is for %s, not %s",
+			protocol.KDCErrBadOption, evidence.Server(), service)
+	case evidence.IsExpired(now):
+		return issuance{}, fmt.Errorf("%w: additional ticket expired", shared.ErrTicketExpired)
+	case evidence.IsNotYetValid(now):
+		return issuance{}, fmt.Errorf("%w: additional ticket is not yet valid", protocol.KRBAPErrTktNYV)
+	case !evidence.Flags().Has(protocol.FlagForwardable):
+		return issuance{}, fmt.Errorf("%w: additional ticket is not %s",
+			protocol.KDCErrBadOption, protocol.FlagForwardable)
+	}
+
+	issue.claims, err = e.evidenceClaims(ctx, evidence, serviceKey.Key)
+	if err != nil {
+		return issuance{}, err
+	}
+
+	targets, err := e.delegationTargets(ctx, service)
+	if err != nil {
+		return issuance{}, err
+	}
+	if !slices.Contains(targets, req.Server()) {
+		return issuance{}, fmt.Errorf("%w: %s may not delegate to %s", protocol.KDCErrPolicy, service, req.Server())
+	}
+
+	issue.client = evidence.Client()
+	issue.clientAddr = evidence.ClientAddr()
+	issue.flags = issue.flags.
+		Without(protocol.FlagForwarded | protocol.FlagPreAuthent | protocol.FlagHWAuthent).
+		With(protocol.FlagForwardable | evidence.Flags()&(protocol.FlagPreAuthent|protocol.FlagHWAuthent))
+
+	start := now
+	if !issue.startTime.IsZero() {
+		start = issue.startTime
+	}
+	issue.lifetime = capLifetime(issue.lifetime, start, evidence.EndTime())
+	if issue.flags.Has(protocol.FlagRenewable) {
+		if !evidence.Flags().Has(protocol.FlagRenewable) {
+			issue.flags = issue.flags.Without(protocol.FlagRenewable)
+			issue.renewTill = time.Time{}
+		} else if evidence.RenewTill().Before(issue.renewTill) {
+			issue.renewTill = evidence.RenewTill()
+		}
+	}
+
+	return issue, nil
+}
+
+// evidenceClaims returns the claims of the evidence ticket of an S4U2Proxy
+// request. The service holds the key of that ticket and could seal one of
+// its own, so only the KDC checksum proves the KDC issued it: every ticket
+// the KDC issues carries signed claims, and one without them is refused.
+func (e *Exchange) evidenceClaims(
+	ctx context.Context,
+	evidence protocol.Ticket,
+	serviceKey protocol.SessionKey,
+) (*protocol.Claims, error) {
+	ad, ok := evidence.AuthorizationData()
+	if !ok {
+		return nil, fmt.Errorf("%w: additional ticket carries no KDC-signed authorization data", protocol.KDCErrBadOption)
+	}
+
+	kdcKey, err := shared.KDCKey(ctx, e.db, e.logger, e.cfg.Realm, evidence.Server(), serviceKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch KDC key: %w", protocol.KRBErrGeneric, err)
+	}
+	if err := shared.VerifyKDCChecksum(ad, evidence.Client(), serviceKey, kdcKey); err != nil {
+		return nil, fmt.Errorf("%w: additional ticket: %w", protocol.KDCErrBadOption, err)
+	}
+
+	claims := ad.Claims()
+	return &claims, nil
+}
+
+// delegationTargets lists the services service may obtain S4U2Proxy tickets
+// to, as kept in the delegations table.
+func (e *Exchange) delegationTargets(ctx context.Context, service protocol.Principal) ([]protocol.Principal, error) {
+	rows, err := kdb.Query.ListDelegations(ctx, e.db, kdb.ListDelegationsParams{
+		ServicePrimary:  string(service.Primary()),
+		ServiceInstance: string(service.Instance()),
+		ServiceRealm:    string(service.Realm()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list delegations: %w", protocol.KRBErrGeneric, err)
+	}
+
+	targets := make([]protocol.Principal, 0, len(rows))
+	for _, row := range rows {
+		target, err := protocol.NewPrincipal(
+			protocol.Primary(row.TargetPrimary),
+			protocol.Instance(row.TargetInstance),
+			protocol.Realm(row.TargetRealm),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid delegation target: %w", protocol.KRBErrGeneric, err)
+		}
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// ServeTCP accepts connections on ln until the server is shut down. Each
+// connection may carry several requests, each prefixed by its length. At
+// most Config.MaxTCPConns are open at once; while they are, further
+// connections are closed as soon as they are accepted, so that idle clients
+// cannot hold an unbounded number of them.
+func (s *Server) ServeTCP(ctx context.Context, ln net.Listener) error {
+	if !s.track(ln) {
+		return nil
+	}
+
+	s.logger.Info("listening",
+		"srv", "kdc",
+		"network", "tcp",
+		"addr", ln.Addr().String(),
+	)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+
+		select {
+		case s.tcpSlots <- struct{}{}:
+		default:
+			s.logger.Warn("too many TCP connections", "addr", conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
+
+		if !s.begin(conn) {
+			<-s.tcpSlots
+			conn.Close()
+			return nil
+		}
+		go func() {
+			defer func() { <-s.tcpSlots }()
+			defer s.end(conn)
+			s.serveConn(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	var prefix [4]byte
+	for !s.isClosed() {
+		conn.SetReadDeadline(time.Now().Add(s.cfg.ReadTimeout))
+		if _, err := io.ReadFull(conn, prefix[:]); err != nil {
+			return
+		}
+
+		// The high bit of the length is reserved for extensions, none of
+		// which we support. Like an over-long request, it is refused and
+		// the connection closed (RFC 4120 §7.2.2).
+		n := binary.BigEndian.Uint32(prefix[:])
+		if n&(1<<31) != 0 || n > uint32(s.cfg.MaxMessageSize) {
+			s.writeMessage(conn, s.replyError(fmt.Errorf("%w: request of %d bytes", protocol.KRBErrFieldTooLong, n)))
+			return
+		}
+
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+
+		if err := s.writeMessage(conn, s.handle(ctx, msg)); err != nil {
+			s.logger.Warn("failed to write TCP reply", "addr", conn.RemoteAddr().String(), "err", err)
+			return
+		}
+	}
+}
+
+// writeMessage writes msg to conn behind its length.
+func (s *Server) writeMessage(conn net.Conn, msg []byte) error {
+	if msg == nil {
+		return nil
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(s.cfg.WriteTimeout))
+	_, err := conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(msg))), msg...))
+	return err
+}
@@ -0,0 +1,259 @@
+// Package transport serves the KDC exchanges over the native transports of
+// RFC 4120 §7.2: UDP datagrams, and TCP streams where each message is
+// prefixed by its 4-byte length. Messages are DER-encoded, as standard
+// Kerberos clients send them.
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/clock"
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/kdc"
+	"github.com/rizesql/kerberos/internal/kdc/as"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/kdc/tgs"
+	"github.com/rizesql/kerberos/internal/o11y/logging"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// Application tags of the requests a KDC answers (RFC 4120 §5.10).
+const (
+	tagASReq  = 10
+	tagTGSReq = 12
+)
+
+type Config struct {
+	// MaxMessageSize bounds the size of a request. Longer requests are
+	// refused with KRB_ERR_FIELD_TOOLONG.
+	MaxMessageSize int
+	// MaxUDPReplySize bounds the size of a reply sent over UDP. A longer
+	// reply is replaced by KRB_ERR_RESPONSE_TOO_BIG, which tells the client
+	// to retry over TCP.
+	MaxUDPReplySize int
+	// ReadTimeout bounds the wait for each request on a TCP connection; a
+	// connection idle for longer is closed.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds the writing of each reply.
+	WriteTimeout time.Duration
+	// MaxUDPRequests bounds the UDP requests answered at once. Further
+	// datagrams wait in the socket buffer until one is answered.
+	MaxUDPRequests int
+	// MaxTCPConns bounds the TCP connections open at once. Connections
+	// accepted beyond it are closed straight away.
+	MaxTCPConns int
+}
+
+// DefaultConfig is used for the fields of a Config left zero. The UDP reply
+// limit is the one MIT clients use to prefer TCP.
+var DefaultConfig = Config{
+	MaxMessageSize:  64 * 1024,
+	MaxUDPReplySize: 1465,
+	ReadTimeout:     10 * time.Second,
+	WriteTimeout:    20 * time.Second,
+	MaxUDPRequests:  256,
+	MaxTCPConns:     256,
+}
+
+func (c Config) withDefaults() Config {
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = DefaultConfig.MaxMessageSize
+	}
+	if c.MaxUDPReplySize <= 0 {
+		c.MaxUDPReplySize = DefaultConfig.MaxUDPReplySize
+	}
+	if c.ReadTimeout <= 0 {
+		c.ReadTimeout = DefaultConfig.ReadTimeout
+	}
+	if c.WriteTimeout <= 0 {
+		c.WriteTimeout = DefaultConfig.WriteTimeout
+	}
+	if c.MaxUDPRequests <= 0 {
+		c.MaxUDPRequests = DefaultConfig.MaxUDPRequests
+	}
+	if c.MaxTCPConns <= 0 {
+		c.MaxTCPConns = DefaultConfig.MaxTCPConns
+	}
+	return c
+}
+
+// Server answers AS and TGS requests read from any number of UDP sockets
+// and TCP listeners.
+type Server struct {
+	as     *as.Exchange
+	tgs    *tgs.Exchange
+	logger *logging.Logger
+	clock  clock.Clock
+	realm  protocol.Realm
+	cfg    Config
+
+	mu        sync.Mutex
+	closed    bool
+	listeners []io.Closer
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+
+	// udpSlots holds a token for each UDP request being answered.
+	udpSlots chan struct{}
+	// tcpSlots holds a token for each open TCP connection.
+	tcpSlots chan struct{}
+}
+
+func New(platform *kdc.Platform, kdcCfg kdc.Config, cfg Config) *Server {
+	cfg = cfg.withDefaults()
+	return &Server{
+		as:     as.NewExchange(platform, kdcCfg),
+		tgs:    tgs.NewExchange(platform, kdcCfg),
+		logger: platform.Logger,
+		clock:  platform.Clock,
+		realm:  kdcCfg.Realm,
+		cfg:    cfg,
+		conns:  map[net.Conn]struct{}{},
+
+		udpSlots: make(chan struct{}, cfg.MaxUDPRequests),
+		tcpSlots: make(chan struct{}, cfg.MaxTCPConns),
+	}
+}
+
+// track registers a listener or socket to be closed on Shutdown. It reports
+// false, closing l, if the server is already shut down.
+func (s *Server) track(l io.Closer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		l.Close()
+		return false
+	}
+	s.listeners = append(s.listeners, l)
+	return true
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// begin counts a request, or a TCP connection, as in flight so that Shutdown
+// waits for it. It reports false if the server is already shut down.
+func (s *Server) begin(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	if conn != nil {
+		s.conns[conn] = struct{}{}
+	}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) end(conn net.Conn) {
+	if conn != nil {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}
+	s.wg.Done()
+}
+
+// Shutdown stops accepting requests and waits for those in flight to be
+// answered. Connections still open when ctx is done are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	s.listeners = nil
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// handle answers a single DER-encoded request. Failures are answered with a
+// KRB-ERROR, so handle always has a reply.
+func (s *Server) handle(ctx context.Context, msg []byte) []byte {
+	ctx = codec.NewContext(ctx, codec.DER)
+
+	input := cryptobyte.String(msg)
+	var body cryptobyte.String
+	var tag asn1.Tag
+	if !input.ReadAnyASN1(&body, &tag) {
+		return s.replyError(fmt.Errorf("%w: %w", protocol.KRBAPErrMsgType, protocol.ErrMalformedDER))
+	}
+
+	switch tag {
+	case asn1.Tag(tagASReq | 0x40).Constructed():
+		var req protocol.ASReq
+		if err := req.UnmarshalDER(msg); err != nil {
+			s.logger.Error("failed to decode AS request", "err", err)
+			return s.replyError(fmt.Errorf("%w: %w", protocol.KRBAPErrMsgType, err))
+		}
+		return s.reply(s.as.Handle(ctx, req))
+
+	case asn1.Tag(tagTGSReq | 0x40).Constructed():
+		var req protocol.TGSReq
+		if err := req.UnmarshalDER(msg); err != nil {
+			s.logger.Error("failed to decode TGS request", "err", err)
+			return s.replyError(fmt.Errorf("%w: %w", protocol.KRBAPErrMsgType, err))
+		}
+		return s.reply(s.tgs.Handle(ctx, req))
+
+	default:
+		return s.replyError(fmt.Errorf("%w: application tag %d", protocol.KRBAPErrMsgType, tag&0x1f))
+	}
+}
+
+// reply encodes the outcome of an exchange: rep, or the KRB-ERROR for err.
+func (s *Server) reply(rep codec.DERMarshaler, err error) []byte {
+	if err == nil {
+		data, mErr := rep.MarshalDER()
+		if mErr == nil {
+			return data
+		}
+		err = mErr
+	}
+
+	return s.replyError(err)
+}
+
+func (s *Server) replyError(err error) []byte {
+	krbErr := shared.NewKRBError(codec.DER, err, s.realm, s.clock.Now())
+	if krbErr.Code() == protocol.KRBErrGeneric {
+		s.logger.Error("KDC exchange failed", "err", err)
+	}
+
+	data, mErr := krbErr.MarshalDER()
+	if mErr != nil {
+		s.logger.Error("failed to encode KRB-ERROR", "err", mErr)
+		return nil
+	}
+	return data
+}
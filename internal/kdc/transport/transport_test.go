@@ -0,0 +1,332 @@
+package transport_test
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/kdc"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/kdc/transport"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/rizesql/kerberos/internal/testkit"
+)
+
+var clientKeyBytes, _ = hex.DecodeString("00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff")
+
+// setup starts a KDC for TEST.REALM on loopback UDP and TCP sockets and
+// returns their addresses.
+func setup(t *testing.T, h *testkit.Harness, cfg transport.Config) (*transport.Server, net.Addr, net.Addr) {
+	t.Helper()
+
+	krbtgtKeyBytes, _ := hex.DecodeString("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "client",
+		Instance:    "user",
+		Realm:       "TEST.REALM",
+		KeyBytes:    clientKeyBytes,
+		Kvno:        1,
+	})
+	h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+		PrimaryName: "krbtgt",
+		Instance:    "TEST.REALM",
+		Realm:       "TEST.REALM",
+		KeyBytes:    krbtgtKeyBytes,
+		Kvno:        1,
+	})
+
+	ks := transport.New(h.NewKDCPlatform(), kdc.Config{
+		Realm:          "TEST.REALM",
+		TicketLifetime: 1 * time.Hour,
+	}, cfg)
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Err(t, err, nil)
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Err(t, err, nil)
+
+	go ks.ServeUDP(t.Context(), udp)
+	go ks.ServeTCP(t.Context(), tcp)
+	t.Cleanup(func() { ks.Shutdown(t.Context()) })
+
+	return ks, udp.LocalAddr(), tcp.Addr()
+}
+
+func newASReq(t *testing.T) protocol.ASReq {
+	t.Helper()
+
+	client, _ := protocol.NewPrincipal("client", "user", "TEST.REALM")
+	krbtgt, _ := protocol.NewKrbtgt("TEST.REALM")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	nonce, _ := protocol.NewNonce(123456)
+	req, err := protocol.NewASReq(client, krbtgt, addr, nonce)
+	assert.Err(t, err, nil)
+	return req
+}
+
+func marshal(t *testing.T, v codec.DERMarshaler) []byte {
+	t.Helper()
+	data, err := v.MarshalDER()
+	assert.Err(t, err, nil)
+	return data
+}
+
+func sendUDP(t *testing.T, addr net.Addr, msg []byte) []byte {
+	t.Helper()
+
+	conn, err := net.Dial("udp", addr.String())
+	assert.Err(t, err, nil)
+	defer conn.Close()
+
+	_, err = conn.Write(msg)
+	assert.Err(t, err, nil)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64*1024)
+	n, err := conn.Read(buf)
+	assert.Err(t, err, nil)
+	return buf[:n]
+}
+
+func writeTCP(t *testing.T, conn net.Conn, length uint32, msg []byte) {
+	t.Helper()
+	_, err := conn.Write(append(binary.BigEndian.AppendUint32(nil, length), msg...))
+	assert.Err(t, err, nil)
+}
+
+func readTCP(t *testing.T, conn net.Conn) []byte {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var prefix [4]byte
+	_, err := io.ReadFull(conn, prefix[:])
+	assert.Err(t, err, nil)
+	msg := make([]byte, binary.BigEndian.Uint32(prefix[:]))
+	_, err = io.ReadFull(conn, msg)
+	assert.Err(t, err, nil)
+	return msg
+}
+
+func decodeError(t *testing.T, data []byte) protocol.KRBError {
+	t.Helper()
+	var krbErr protocol.KRBError
+	assert.Err(t, krbErr.UnmarshalDER(data), nil)
+	return krbErr
+}
+
+func TestUDP(t *testing.T) {
+	h := testkit.NewHarness(t)
+	_, udpAddr, _ := setup(t, h, transport.Config{})
+
+	req := newASReq(t)
+
+	// Without pre-authentication the KDC asks for it
+	krbErr := decodeError(t, sendUDP(t, udpAddr, marshal(t, req)))
+	assert.Equal(t, krbErr.Code(), protocol.KDCErrPreauthRequired)
+
+	clientKey, _ := protocol.NewSessionKey(clientKeyBytes)
+	encTimestamp, err := shared.NewEncTimestamp(codec.DER, clientKey, h.Clock.Now())
+	assert.Err(t, err, nil)
+
+	var rep protocol.ASRep
+	assert.Err(t, rep.UnmarshalDER(sendUDP(t, udpAddr, marshal(t, req.WithPAData(encTimestamp)))), nil)
+
+	repPart, err := shared.DecryptEntity[protocol.EncKDCRepPart](clientKey, crypto.KeyUsageASRepEncPart, rep.SecretPart())
+	assert.Err(t, err, nil)
+	assert.Equal(t, repPart.Nonce(), req.Nonce())
+}
+
+func TestUDP_ResponseTooBig(t *testing.T) {
+	h := testkit.NewHarness(t)
+	_, udpAddr, tcpAddr := setup(t, h, transport.Config{MaxUDPReplySize: 100})
+
+	clientKey, _ := protocol.NewSessionKey(clientKeyBytes)
+	encTimestamp, err := shared.NewEncTimestamp(codec.DER, clientKey, h.Clock.Now())
+	assert.Err(t, err, nil)
+	krbErr := decodeError(t, sendUDP(t, udpAddr, marshal(t, newASReq(t).WithPAData(encTimestamp))))
+	assert.Equal(t, krbErr.Code(), protocol.KRBErrResponseTooBig)
+
+	// The client retries over TCP, which has no such limit, with a fresh
+	// timestamp
+	encTimestamp, err = shared.NewEncTimestamp(codec.DER, clientKey, h.Clock.Tick(time.Second))
+	assert.Err(t, err, nil)
+	msg := marshal(t, newASReq(t).WithPAData(encTimestamp))
+
+	conn, err := net.Dial("tcp", tcpAddr.String())
+	assert.Err(t, err, nil)
+	defer conn.Close()
+
+	writeTCP(t, conn, uint32(len(msg)), msg)
+	var rep protocol.ASRep
+	assert.Err(t, rep.UnmarshalDER(readTCP(t, conn)), nil)
+}
+
+func TestUDP_MaxRequests(t *testing.T) {
+	h := testkit.NewHarness(t)
+	_, udpAddr, _ := setup(t, h, transport.Config{MaxUDPRequests: 1})
+
+	// Datagrams beyond the limit wait their turn rather than being dropped.
+	msg := marshal(t, newASReq(t))
+	conns := make([]net.Conn, 8)
+	for i := range conns {
+		conn, err := net.Dial("udp", udpAddr.String())
+		assert.Err(t, err, nil)
+		defer conn.Close()
+
+		_, err = conn.Write(msg)
+		assert.Err(t, err, nil)
+		conns[i] = conn
+	}
+
+	for _, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 64*1024)
+		n, err := conn.Read(buf)
+		assert.Err(t, err, nil)
+		assert.Equal(t, decodeError(t, buf[:n]).Code(), protocol.KDCErrPreauthRequired)
+	}
+}
+
+func TestTCP(t *testing.T) {
+	h := testkit.NewHarness(t)
+	_, _, tcpAddr := setup(t, h, transport.Config{})
+
+	conn, err := net.Dial("tcp", tcpAddr.String())
+	assert.Err(t, err, nil)
+	defer conn.Close()
+
+	// Several requests share a connection
+	msg := marshal(t, newASReq(t))
+	for range 2 {
+		writeTCP(t, conn, uint32(len(msg)), msg)
+		krbErr := decodeError(t, readTCP(t, conn))
+		assert.Equal(t, krbErr.Code(), protocol.KDCErrPreauthRequired)
+	}
+
+	// A message the KDC does not answer
+	ap, _ := protocol.NewEncryptedData([]byte("ticket"))
+	krbtgt, _ := protocol.NewKrbtgt("TEST.REALM")
+	apReq, _ := protocol.NewAPReq(ap.WithServer(krbtgt), ap)
+	msg = marshal(t, apReq)
+	writeTCP(t, conn, uint32(len(msg)), msg)
+	krbErr := decodeError(t, readTCP(t, conn))
+	assert.Equal(t, krbErr.Code(), protocol.KRBAPErrMsgType)
+}
+
+func TestTCP_FieldTooLong(t *testing.T) {
+	h := testkit.NewHarness(t)
+	_, _, tcpAddr := setup(t, h, transport.Config{MaxMessageSize: 1024})
+
+	tests := []struct {
+		name   string
+		length uint32
+	}{
+		{"over limit", 1025},
+		{"reserved bit", 1<<31 | 16},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", tcpAddr.String())
+			assert.Err(t, err, nil)
+			defer conn.Close()
+
+			writeTCP(t, conn, tt.length, nil)
+			krbErr := decodeError(t, readTCP(t, conn))
+			assert.Equal(t, krbErr.Code(), protocol.KRBErrFieldTooLong)
+
+			// The KDC closes the connection
+			_, err = conn.Read(make([]byte, 1))
+			assert.Err(t, err, io.EOF)
+		})
+	}
+}
+
+func TestTCP_ReadTimeout(t *testing.T) {
+	h := testkit.NewHarness(t)
+	_, _, tcpAddr := setup(t, h, transport.Config{ReadTimeout: 50 * time.Millisecond})
+
+	conn, err := net.Dial("tcp", tcpAddr.String())
+	assert.Err(t, err, nil)
+	defer conn.Close()
+
+	// An idle connection is closed
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Err(t, err, io.EOF)
+}
+
+func TestTCP_MaxConns(t *testing.T) {
+	h := testkit.NewHarness(t)
+	_, _, tcpAddr := setup(t, h, transport.Config{MaxTCPConns: 1})
+	msg := marshal(t, newASReq(t))
+
+	// The first connection is served and holds the only slot.
+	held, err := net.Dial("tcp", tcpAddr.String())
+	assert.Err(t, err, nil)
+	defer held.Close()
+	writeTCP(t, held, uint32(len(msg)), msg)
+	assert.Equal(t, decodeError(t, readTCP(t, held)).Code(), protocol.KDCErrPreauthRequired)
+
+	// Another one is closed without an answer.
+	refused, err := net.Dial("tcp", tcpAddr.String())
+	assert.Err(t, err, nil)
+	defer refused.Close()
+	refused.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = refused.Read(make([]byte, 1))
+	assert.Err(t, err, io.EOF)
+
+	// Once the first is closed, its slot serves new connections.
+	held.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", tcpAddr.String())
+		assert.Err(t, err, nil)
+
+		// A connection refused while the slot is still held may already
+		// be reset, so the write is not checked.
+		_, _ = conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(msg))), msg...))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		var prefix [4]byte
+		_, err = io.ReadFull(conn, prefix[:])
+		conn.Close()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("slot of the closed connection was never released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	h := testkit.NewHarness(t)
+	ks, _, tcpAddr := setup(t, h, transport.Config{})
+
+	conn, err := net.Dial("tcp", tcpAddr.String())
+	assert.Err(t, err, nil)
+	defer conn.Close()
+
+	msg := marshal(t, newASReq(t))
+	writeTCP(t, conn, uint32(len(msg)), msg)
+	readTCP(t, conn)
+
+	// Open connections are closed once the shutdown deadline passes
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	assert.Err(t, ks.Shutdown(ctx), context.DeadlineExceeded)
+
+	_, err = net.Dial("tcp", tcpAddr.String())
+	if err == nil {
+		t.Fatal("listener still accepts connections after shutdown")
+	}
+}
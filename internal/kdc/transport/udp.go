@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// ServeUDP answers the requests arriving on conn, one per datagram, until
+// the server is shut down. At most Config.MaxUDPRequests are answered at
+// once; no further datagram is read until one of them is.
+func (s *Server) ServeUDP(ctx context.Context, conn net.PacketConn) error {
+	if !s.track(conn) {
+		return nil
+	}
+
+	s.logger.Info("listening",
+		"srv", "kdc",
+		"network", "udp",
+		"addr", conn.LocalAddr().String(),
+	)
+
+	for {
+		s.udpSlots <- struct{}{}
+
+		// One byte more than allowed tells an over-long request apart.
+		buf := make([]byte, s.cfg.MaxMessageSize+1)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			<-s.udpSlots
+			if s.isClosed() {
+				return nil
+			}
+			return err
+		}
+
+		if !s.begin(nil) {
+			<-s.udpSlots
+			return nil
+		}
+		go func() {
+			defer func() { <-s.udpSlots }()
+			defer s.end(nil)
+			s.serveDatagram(ctx, conn, addr, buf[:n])
+		}()
+	}
+}
+
+func (s *Server) serveDatagram(ctx context.Context, conn net.PacketConn, addr net.Addr, msg []byte) {
+	var rep []byte
+	if len(msg) > s.cfg.MaxMessageSize {
+		rep = s.replyError(fmt.Errorf("%w: request exceeds %d bytes", protocol.KRBErrFieldTooLong, s.cfg.MaxMessageSize))
+	} else {
+		rep = s.handle(ctx, msg)
+	}
+
+	// A reply that may not fit a datagram is replaced by a hint to retry
+	// over TCP (RFC 4120 §7.2.1).
+	if len(rep) > s.cfg.MaxUDPReplySize {
+		rep = s.replyError(fmt.Errorf("%w: reply of %d bytes", protocol.KRBErrResponseTooBig, len(rep)))
+	}
+	if rep == nil {
+		return
+	}
+
+	if _, err := conn.WriteTo(rep, addr); err != nil {
+		s.logger.Warn("failed to write UDP reply", "addr", addr.String(), "err", err)
+	}
+}
@@ -0,0 +1,329 @@
+// Package keytab reads and writes keytabs in the MIT binary format, version
+// 0x502, which keeps the long-term keys of services outside the KDC.
+package keytab
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+var (
+	ErrMalformed   = errors.New("malformed keytab")
+	ErrUnsupported = errors.New("unsupported keytab")
+)
+
+// version is the 0x502 of MIT keytabs, which record the name type of each
+// principal and count its components without the realm.
+const version = 0x0502
+
+const (
+	nameTypePrincipal = 1
+	nameTypeSrvInst   = 2
+)
+
+// Entry is one key of a keytab.
+type Entry struct {
+	Principal protocol.Principal
+	// Timestamp is when the key was written to the keytab.
+	Timestamp time.Time
+	Kvno      uint32
+	Key       protocol.SessionKey
+}
+
+// Keytab holds the keys of one or more principals, any number of versions
+// and encryption types each.
+type Keytab struct {
+	entries []Entry
+}
+
+func New(entries ...Entry) *Keytab {
+	k := &Keytab{}
+	for _, e := range entries {
+		k.Add(e)
+	}
+	return k
+}
+
+// Add adds e, replacing the key of the same principal, version and
+// encryption type if k holds one.
+func (k *Keytab) Add(e Entry) {
+	k.entries = slices.DeleteFunc(k.entries, func(old Entry) bool {
+		return old.Principal == e.Principal && old.Kvno == e.Kvno && old.Key.EncType() == e.Key.EncType()
+	})
+	k.entries = append(k.entries, e)
+}
+
+func (k *Keytab) Entries() []Entry {
+	return slices.Clone(k.entries)
+}
+
+// Keys returns the keys of server of encryption type etype, newest version
+// first, or only version kvno if hasKvno. A zero server matches any
+// principal, for tickets whose server is not known before they are opened.
+func (k *Keytab) Keys(server protocol.Principal, kvno uint32, hasKvno bool, etype protocol.EncType) []Entry {
+	var keys []Entry
+	for _, e := range k.entries {
+		if server != (protocol.Principal{}) && e.Principal != server {
+			continue
+		}
+		if hasKvno && e.Kvno != kvno {
+			continue
+		}
+		if e.Key.EncType() != etype {
+			continue
+		}
+		keys = append(keys, e)
+	}
+	slices.SortStableFunc(keys, func(a, b Entry) int { return cmp.Compare(b.Kvno, a.Kvno) })
+	return keys
+}
+
+// Load reads the keytab at path.
+func Load(path string) (*Keytab, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	k := &Keytab{}
+	if err := k.UnmarshalBinary(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return k, nil
+}
+
+// Write writes k to path, readable only by its owner. The file is replaced
+// whole, through a temporary file renamed over it.
+func (k *Keytab) Write(path string) error {
+	data, err := k.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := tmp.Chmod(0o600); err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// MarshalBinary encodes k in the MIT keytab format: the version, then each
+// entry prefixed with its 32-bit length.
+func (k *Keytab) MarshalBinary() ([]byte, error) {
+	b := binary.BigEndian.AppendUint16(nil, version)
+	for _, e := range k.entries {
+		entry, err := e.marshal()
+		if err != nil {
+			return nil, err
+		}
+		b = binary.BigEndian.AppendUint32(b, uint32(len(entry)))
+		b = append(b, entry...)
+	}
+	return b, nil
+}
+
+func (e Entry) marshal() ([]byte, error) {
+	components := []string{string(e.Principal.Primary())}
+	if e.Principal.Instance() != "" {
+		components = append(components, string(e.Principal.Instance()))
+	}
+	nameType := uint32(nameTypePrincipal)
+	if e.Principal.IsKrbtgt() {
+		nameType = nameTypeSrvInst
+	}
+
+	var b []byte
+	b = binary.BigEndian.AppendUint16(b, uint16(len(components)))
+	b, err := appendString(b, string(e.Principal.Realm()))
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range components {
+		if b, err = appendString(b, c); err != nil {
+			return nil, err
+		}
+	}
+	b = binary.BigEndian.AppendUint32(b, nameType)
+	b = binary.BigEndian.AppendUint32(b, uint32(e.Timestamp.Unix()))
+
+	// The 8-bit version is kept for older readers; the 32-bit one that
+	// follows the key is the one that counts.
+	b = append(b, uint8(min(e.Kvno, math.MaxUint8)))
+
+	b = binary.BigEndian.AppendUint16(b, uint16(int16(e.Key.EncType())))
+	if b, err = appendString(b, string(e.Key.Expose())); err != nil {
+		return nil, err
+	}
+	return binary.BigEndian.AppendUint32(b, e.Kvno), nil
+}
+
+func appendString(b []byte, s string) ([]byte, error) {
+	if len(s) > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: field of %d bytes", ErrUnsupported, len(s))
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...), nil
+}
+
+// UnmarshalBinary decodes a keytab in the MIT format. Holes, the negative
+// lengths left where entries were deleted, are skipped.
+func (k *Keytab) UnmarshalBinary(data []byte) error {
+	r := reader(data)
+	v, ok := r.uint16()
+	if !ok {
+		return ErrMalformed
+	}
+	if v != version {
+		return fmt.Errorf("%w: version %#x", ErrUnsupported, v)
+	}
+
+	var entries []Entry
+	for len(r) > 0 {
+		size, ok := r.uint32()
+		if !ok {
+			return ErrMalformed
+		}
+		n := int32(size)
+		if n < 0 {
+			n = -n
+		}
+		raw, ok := r.bytes(int(n))
+		if !ok {
+			return ErrMalformed
+		}
+		if int32(size) <= 0 {
+			continue
+		}
+
+		e, err := unmarshalEntry(raw)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+
+	k.entries = entries
+	return nil
+}
+
+func unmarshalEntry(data []byte) (Entry, error) {
+	r := reader(data)
+	count, ok1 := r.uint16()
+	realm, ok2 := r.string()
+	if !ok1 || !ok2 {
+		return Entry{}, ErrMalformed
+	}
+	if count < 1 || count > 2 {
+		return Entry{}, fmt.Errorf("%w: principal of %d components", ErrUnsupported, count)
+	}
+	components := make([]string, count)
+	for i := range components {
+		if components[i], ok1 = r.string(); !ok1 {
+			return Entry{}, ErrMalformed
+		}
+	}
+	_, ok1 = r.uint32() // name type
+	timestamp, ok2 := r.uint32()
+	kvno8, ok3 := r.uint8()
+	etype, ok4 := r.uint16()
+	key, ok5 := r.string()
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 {
+		return Entry{}, ErrMalformed
+	}
+
+	kvno := uint32(kvno8)
+	if len(r) >= 4 {
+		if kvno32, _ := r.uint32(); kvno32 != 0 {
+			kvno = kvno32
+		}
+	}
+
+	var instance protocol.Instance
+	if count == 2 {
+		instance = protocol.Instance(components[1])
+	}
+	p, err := protocol.NewPrincipal(protocol.Primary(components[0]), instance, protocol.Realm(realm))
+	if err != nil {
+		return Entry{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	sk, err := protocol.NewSessionKey([]byte(key))
+	if err != nil {
+		return Entry{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	return Entry{
+		Principal: p,
+		Timestamp: time.Unix(int64(timestamp), 0).UTC(),
+		Kvno:      kvno,
+		Key:       sk.WithEncType(protocol.EncType(int16(etype))),
+	}, nil
+}
+
+// reader consumes big-endian fields from the front of a keytab.
+type reader []byte
+
+func (r *reader) bytes(n int) ([]byte, bool) {
+	if n < 0 || len(*r) < n {
+		return nil, false
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b, true
+}
+
+func (r *reader) uint8() (uint8, bool) {
+	b, ok := r.bytes(1)
+	if !ok {
+		return 0, false
+	}
+	return b[0], true
+}
+
+func (r *reader) uint16() (uint16, bool) {
+	b, ok := r.bytes(2)
+	if !ok {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(b), true
+}
+
+func (r *reader) uint32() (uint32, bool) {
+	b, ok := r.bytes(4)
+	if !ok {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(b), true
+}
+
+func (r *reader) string() (string, bool) {
+	n, ok := r.uint16()
+	if !ok {
+		return "", false
+	}
+	b, ok := r.bytes(int(n))
+	return string(b), ok
+}
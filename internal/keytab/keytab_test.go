@@ -0,0 +1,127 @@
+package keytab_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/keytab"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	service, _ := protocol.NewPrincipal("http", "api", "R")
+	key, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0xaa}, 16))
+	kt := keytab.New(keytab.Entry{
+		Principal: service,
+		Timestamp: time.Unix(0x01020304, 0),
+		Kvno:      3,
+		Key:       key.WithEncType(protocol.EncTypeAES128CTSHMACSHA196),
+	})
+
+	// The layout MIT krb5 writes: version 0x502, then the entry with its
+	// length, component count, realm, components, name type, timestamp,
+	// 8-bit kvno, keyblock and 32-bit kvno.
+	want, _ := hex.DecodeString("0502" +
+		"00000031" +
+		"0002" + "000152" + "000468747470" + "0003617069" +
+		"00000001" + "01020304" + "03" +
+		"0011" + "0010" + "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa" +
+		"00000003")
+
+	got, err := kt.MarshalBinary()
+	assert.Err(t, err, nil)
+	assert.Equal(t, got, want)
+
+	var back keytab.Keytab
+	assert.Err(t, back.UnmarshalBinary(got), nil)
+	entries := back.Entries()
+	assert.Equal(t, len(entries), 1)
+	assert.Equal(t, entries[0].Principal, service)
+	assert.Equal(t, entries[0].Kvno, uint32(3))
+	assert.Equal(t, entries[0].Timestamp.Unix(), int64(0x01020304))
+	assert.Equal(t, entries[0].Key.EncType(), protocol.EncTypeAES128CTSHMACSHA196)
+	assert.Equal(t, entries[0].Key.Expose(), key.Expose())
+}
+
+func TestUnmarshalBinary(t *testing.T) {
+	entry := "0002" + "000152" + "000468747470" + "0003617069" +
+		"00000001" + "01020304" + "07" +
+		"ffff" + "0020" + "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+
+	// Holes left by deleted entries are skipped, and an entry without a
+	// 32-bit kvno keeps its 8-bit one. Enctype 0xffff is AES-256-GCM.
+	data, _ := hex.DecodeString("0502" + "fffffffa" + "000000000000" + "0000003d" + entry)
+	var kt keytab.Keytab
+	assert.Err(t, kt.UnmarshalBinary(data), nil)
+	entries := kt.Entries()
+	assert.Equal(t, len(entries), 1)
+	assert.Equal(t, entries[0].Kvno, uint32(7))
+	assert.Equal(t, entries[0].Key.EncType(), protocol.EncTypeAES256GCM)
+
+	for name, tc := range map[string]struct {
+		input string
+		want  error
+	}{
+		"Empty":      {"", keytab.ErrMalformed},
+		"Version":    {"0501", keytab.ErrUnsupported},
+		"Truncated":  {"0502" + "0000003d" + entry[:20], keytab.ErrMalformed},
+		"NoRealm":    {"0502" + "00000002" + "0001", keytab.ErrMalformed},
+		"Components": {"0502" + "00000005" + "0003" + "000152", keytab.ErrUnsupported},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, _ := hex.DecodeString(tc.input)
+			assert.Err(t, kt.UnmarshalBinary(data), tc.want)
+		})
+	}
+}
+
+func TestKeys(t *testing.T) {
+	server, _ := protocol.NewPrincipal("http", "api", "R")
+	other, _ := protocol.NewPrincipal("host", "api", "R")
+	key, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0x01}, 32))
+	newer, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0x02}, 32))
+
+	kt := keytab.New(
+		keytab.Entry{Principal: server, Kvno: 1, Key: key},
+		keytab.Entry{Principal: server, Kvno: 2, Key: newer},
+		keytab.Entry{Principal: other, Kvno: 1, Key: key},
+	)
+
+	keys := kt.Keys(server, 0, false, protocol.EncTypeAES256GCM)
+	assert.Equal(t, len(keys), 2)
+	assert.Equal(t, keys[0].Kvno, uint32(2))
+
+	keys = kt.Keys(server, 1, true, protocol.EncTypeAES256GCM)
+	assert.Equal(t, len(keys), 1)
+	assert.Equal(t, keys[0].Key.Expose(), key.Expose())
+
+	assert.Equal(t, len(kt.Keys(protocol.Principal{}, 1, true, protocol.EncTypeAES256GCM)), 2)
+	assert.Equal(t, len(kt.Keys(server, 0, false, protocol.EncTypeAES256CTSHMACSHA196)), 0)
+
+	// Adding a key of the same version and type replaces it.
+	kt.Add(keytab.Entry{Principal: server, Kvno: 2, Key: key})
+	keys = kt.Keys(server, 2, true, protocol.EncTypeAES256GCM)
+	assert.Equal(t, len(keys), 1)
+	assert.Equal(t, keys[0].Key.Expose(), key.Expose())
+}
+
+func TestWrite(t *testing.T) {
+	server, _ := protocol.NewPrincipal("http", "api", "R")
+	key, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0x01}, 32))
+	path := filepath.Join(t.TempDir(), "krb5.keytab")
+
+	assert.Err(t, keytab.New(keytab.Entry{Principal: server, Kvno: 1, Key: key}).Write(path), nil)
+	info, err := os.Stat(path)
+	assert.Err(t, err, nil)
+	assert.Equal(t, info.Mode().Perm(), os.FileMode(0o600))
+
+	kt, err := keytab.Load(path)
+	assert.Err(t, err, nil)
+	assert.Equal(t, len(kt.Entries()), 1)
+	assert.Equal(t, kt.Entries()[0].Principal, server)
+}
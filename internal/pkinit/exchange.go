@@ -0,0 +1,239 @@
+package pkinit
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+var (
+	ErrMalformed     = errors.New("malformed PKINIT message")
+	ErrKeyParameters = errors.New("unsupported key agreement parameters")
+	ErrModified      = errors.New("pk-authenticator does not checksum the request")
+	ErrWrongKDC      = errors.New("reply is not signed by the KDC of the realm")
+	ErrNonceMismatch = errors.New("reply does not answer the request")
+)
+
+// Checksum is the paChecksum of a PKAuthenticator over body, the encoded
+// body of the AS-REQ it travels in.
+func Checksum(body []byte) []byte {
+	sum := sha256.Sum256(body)
+	return sum[:]
+}
+
+// NewRequest builds the PA-PK-AS-REQ of an AS-REQ with the given nonce,
+// whose body encodes as body: a PKAuthenticator and the public half of a
+// fresh P-256 key, signed with cert and encoded with c. It returns the key,
+// which opens the reply.
+func NewRequest(
+	c codec.Codec,
+	cert tls.Certificate,
+	body []byte,
+	nonce protocol.Nonce,
+	now time.Time,
+) (protocol.PAData, *ecdh.PrivateKey, error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return protocol.PAData{}, nil, err
+	}
+
+	spki, err := x509.MarshalPKIXPublicKey(key.PublicKey())
+	if err != nil {
+		return protocol.PAData{}, nil, err
+	}
+
+	auth, err := protocol.NewPKAuthenticator(now, nonce, Checksum(body))
+	if err != nil {
+		return protocol.PAData{}, nil, err
+	}
+
+	pack, err := protocol.NewAuthPack(auth, spki)
+	if err != nil {
+		return protocol.PAData{}, nil, err
+	}
+
+	pa, err := signedPAData(c, cert, protocol.PATypePKASReq, pack)
+	if err != nil {
+		return protocol.PAData{}, nil, err
+	}
+	return pa, key, nil
+}
+
+// OpenRequest verifies the PA-PK-AS-REQ pa against roots and checks that it
+// binds body, the encoded body of the AS-REQ it arrived in. It returns the
+// AuthPack and the client's certificate; the caller checks the freshness
+// of the PKAuthenticator and whom the certificate names.
+func OpenRequest(
+	pa protocol.PAData,
+	roots *x509.CertPool,
+	body []byte,
+	now time.Time,
+) (protocol.AuthPack, *x509.Certificate, error) {
+	var sd protocol.PKSignedData
+	if err := decode(pa.Value(), &sd); err != nil {
+		return protocol.AuthPack{}, nil, fmt.Errorf("%w: PA-PK-AS-REQ: %w", ErrMalformed, err)
+	}
+
+	cert, err := Verify(sd, roots, PurposeClient, now)
+	if err != nil {
+		return protocol.AuthPack{}, nil, err
+	}
+
+	var pack protocol.AuthPack
+	if err := decode(sd.Content(), &pack); err != nil {
+		return protocol.AuthPack{}, nil, fmt.Errorf("%w: AuthPack: %w", ErrMalformed, err)
+	}
+
+	if !bytes.Equal(pack.PKAuthenticator().PAChecksum(), Checksum(body)) {
+		return protocol.AuthPack{}, nil, ErrModified
+	}
+	return pack, cert, nil
+}
+
+// NewReply agrees on the reply key with the client that sent pack: it
+// generates a key on the curve of the client's and derives the reply key of
+// etype from the secret they share. It returns the key with the
+// PA-PK-AS-REP that gives the client the public half, signed with cert and
+// encoded with c.
+func NewReply(
+	c codec.Codec,
+	cert tls.Certificate,
+	pack protocol.AuthPack,
+	etype protocol.EncType,
+) (protocol.PAData, protocol.SessionKey, error) {
+	clientKey, err := parsePublicValue(pack.ClientPublicValue())
+	if err != nil {
+		return protocol.PAData{}, protocol.SessionKey{}, err
+	}
+
+	key, err := clientKey.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return protocol.PAData{}, protocol.SessionKey{}, err
+	}
+
+	secret, err := key.ECDH(clientKey)
+	if err != nil {
+		return protocol.PAData{}, protocol.SessionKey{}, fmt.Errorf("%w: %w", ErrKeyParameters, err)
+	}
+
+	replyKey, err := crypto.OctetStringToKey(etype, secret)
+	if err != nil {
+		return protocol.PAData{}, protocol.SessionKey{}, err
+	}
+
+	info, err := protocol.NewKDCDHKeyInfo(key.PublicKey().Bytes(), pack.PKAuthenticator().Nonce())
+	if err != nil {
+		return protocol.PAData{}, protocol.SessionKey{}, err
+	}
+
+	pa, err := signedPAData(c, cert, protocol.PATypePKASRep, info)
+	if err != nil {
+		return protocol.PAData{}, protocol.SessionKey{}, err
+	}
+	return pa, replyKey, nil
+}
+
+// OpenReply verifies the PA-PK-AS-REP pa against roots, checks that it
+// comes from the KDC of realm and answers the PKAuthenticator with nonce,
+// and derives the reply key of etype with key, the one NewRequest returned.
+func OpenReply(
+	pa protocol.PAData,
+	roots *x509.CertPool,
+	realm protocol.Realm,
+	key *ecdh.PrivateKey,
+	nonce protocol.Nonce,
+	etype protocol.EncType,
+	now time.Time,
+) (protocol.SessionKey, error) {
+	var sd protocol.PKSignedData
+	if err := decode(pa.Value(), &sd); err != nil {
+		return protocol.SessionKey{}, fmt.Errorf("%w: PA-PK-AS-REP: %w", ErrMalformed, err)
+	}
+
+	cert, err := Verify(sd, roots, PurposeKDC, now)
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+
+	tgs, err := protocol.NewKrbtgt(realm)
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+	kdc, ok, err := sanPrincipal(cert)
+	if err != nil {
+		return protocol.SessionKey{}, err
+	}
+	if !ok || kdc != tgs {
+		return protocol.SessionKey{}, fmt.Errorf("%w: %s", ErrWrongKDC, cert.Subject)
+	}
+
+	var info protocol.KDCDHKeyInfo
+	if err := decode(sd.Content(), &info); err != nil {
+		return protocol.SessionKey{}, fmt.Errorf("%w: KDCDHKeyInfo: %w", ErrMalformed, err)
+	}
+	if info.Nonce() != nonce {
+		return protocol.SessionKey{}, ErrNonceMismatch
+	}
+
+	kdcKey, err := key.Curve().NewPublicKey(info.SubjectPublicKey())
+	if err != nil {
+		return protocol.SessionKey{}, fmt.Errorf("%w: %w", ErrKeyParameters, err)
+	}
+
+	secret, err := key.ECDH(kdcKey)
+	if err != nil {
+		return protocol.SessionKey{}, fmt.Errorf("%w: %w", ErrKeyParameters, err)
+	}
+	return crypto.OctetStringToKey(etype, secret)
+}
+
+// parsePublicValue decodes the SubjectPublicKeyInfo of a client's (EC)DH
+// key. The NIST curves and X25519 are accepted.
+func parsePublicValue(spki []byte) (*ecdh.PublicKey, error) {
+	pub, err := x509.ParsePKIXPublicKey(spki)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrKeyParameters, err)
+	}
+
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		key, err := pub.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrKeyParameters, err)
+		}
+		return key, nil
+	case *ecdh.PublicKey:
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrKeyParameters, pub)
+	}
+}
+
+func signedPAData(c codec.Codec, cert tls.Certificate, typ protocol.PADataType, v any) (protocol.PAData, error) {
+	sd, err := Sign(c, cert, v)
+	if err != nil {
+		return protocol.PAData{}, err
+	}
+
+	value, err := c.Marshal(sd)
+	if err != nil {
+		return protocol.PAData{}, err
+	}
+	return protocol.NewPAData(typ, value)
+}
+
+// decode decodes data in whichever encoding it was sent in.
+func decode(data []byte, v any) error {
+	return codec.Detect(data).Unmarshal(data, v)
+}
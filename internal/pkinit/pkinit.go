@@ -0,0 +1,309 @@
+// Package pkinit implements the certificate side of PKINIT (RFC 4556):
+// signing and verifying the signed data of the exchange, mapping a
+// certificate to the principal it names and agreeing on the reply key with
+// ephemeral (EC)DH.
+package pkinit
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var (
+	ErrUntrusted          = errors.New("certificate does not chain to a trusted CA")
+	ErrInvalidCertificate = errors.New("invalid certificate")
+	ErrInvalidSignature   = errors.New("invalid signature")
+	ErrNoPrincipal        = errors.New("certificate names no principal")
+	ErrUnsupportedKey     = errors.New("unsupported signing key")
+)
+
+var (
+	// OIDPKINITSan is the type of the otherName holding the
+	// KRB5PrincipalName a certificate is issued to.
+	OIDPKINITSan = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 2, 2}
+	// OIDKPClientAuth is the extended key usage of a certificate a client
+	// pre-authenticates with.
+	OIDKPClientAuth = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 2, 3, 4}
+	// OIDKPKdc is the extended key usage of a KDC's certificate.
+	OIDKPKdc = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 2, 3, 5}
+	// OIDSmartcardLogon is Microsoft's extended key usage for smart-card
+	// logon, which RFC 4556 §3.2.2 lets a KDC accept for clients.
+	OIDSmartcardLogon = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 2}
+
+	oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+)
+
+// Purpose is what a certificate of the exchange must have been issued for.
+type Purpose int
+
+const (
+	// PurposeClient is a client's certificate.
+	PurposeClient Purpose = iota
+	// PurposeKDC is a KDC's certificate.
+	PurposeKDC
+)
+
+func (p Purpose) usages() []asn1.ObjectIdentifier {
+	if p == PurposeKDC {
+		return []asn1.ObjectIdentifier{OIDKPKdc}
+	}
+	return []asn1.ObjectIdentifier{OIDKPClientAuth, OIDSmartcardLogon}
+}
+
+// Config is one end's side of PKINIT: the certificate it signs with, its
+// own first, and the CAs it trusts the certificates of the other end from.
+type Config struct {
+	Certificate tls.Certificate
+	Roots       *x509.CertPool
+}
+
+// LoadRoots reads a PEM bundle of CA certificates.
+func LoadRoots(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	var found bool
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		pool.AddCert(cert)
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("%s: no certificates", path)
+	}
+	return pool, nil
+}
+
+// LoadCertificate reads a PEM certificate chain and the PEM private key of
+// its first certificate.
+func LoadCertificate(certPath, keyPath string) (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if _, err := signatureAlgorithm(cert.Leaf); err != nil {
+		return tls.Certificate{}, err
+	}
+	return cert, nil
+}
+
+// Sign encodes v with c and signs it with the key of cert.
+func Sign(c codec.Codec, cert tls.Certificate, v any) (protocol.PKSignedData, error) {
+	content, err := c.Marshal(v)
+	if err != nil {
+		return protocol.PKSignedData{}, err
+	}
+
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return protocol.PKSignedData{}, fmt.Errorf("%w: %T", ErrUnsupportedKey, cert.PrivateKey)
+	}
+
+	var sig []byte
+	switch signer.Public().(type) {
+	case ed25519.PublicKey:
+		sig, err = signer.Sign(rand.Reader, content, crypto.Hash(0))
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		digest := sha256.Sum256(content)
+		sig, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	default:
+		return protocol.PKSignedData{}, fmt.Errorf("%w: %T", ErrUnsupportedKey, signer.Public())
+	}
+	if err != nil {
+		return protocol.PKSignedData{}, err
+	}
+
+	return protocol.NewPKSignedData(content, cert.Certificate, sig)
+}
+
+// Verify checks that the signer of sd chains, through the certificates sd
+// carries, to one of roots at now, was issued for purpose and signed its
+// content. It returns the signer's certificate.
+func Verify(sd protocol.PKSignedData, roots *x509.CertPool, purpose Purpose, now time.Time) (*x509.Certificate, error) {
+	chain := sd.Certificates()
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCertificate, err)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, der := range chain[1:] {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidCertificate, err)
+		}
+		intermediates.AddCert(cert)
+	}
+
+	// The PKINIT usages are unknown to crypto/x509, which checks only that
+	// the chain is sound; the signer's own usages are checked below.
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUntrusted, err)
+	}
+
+	if !slices.ContainsFunc(purpose.usages(), func(oid asn1.ObjectIdentifier) bool {
+		return slices.ContainsFunc(leaf.UnknownExtKeyUsage, oid.Equal)
+	}) {
+		return nil, fmt.Errorf("%w: %s is not issued for PKINIT", ErrInvalidCertificate, leaf.Subject)
+	}
+
+	algo, err := signatureAlgorithm(leaf)
+	if err != nil {
+		return nil, err
+	}
+	if err := leaf.CheckSignature(algo, sd.Content(), sd.Signature()); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+	return leaf, nil
+}
+
+// signatureAlgorithm is the algorithm Sign uses with the key of cert.
+func signatureAlgorithm(cert *x509.Certificate) (x509.SignatureAlgorithm, error) {
+	switch cert.PublicKeyAlgorithm {
+	case x509.ECDSA:
+		return x509.ECDSAWithSHA256, nil
+	case x509.RSA:
+		return x509.SHA256WithRSA, nil
+	case x509.Ed25519:
+		return x509.PureEd25519, nil
+	default:
+		return x509.UnknownSignatureAlgorithm, fmt.Errorf("%w: %s", ErrUnsupportedKey, cert.PublicKeyAlgorithm)
+	}
+}
+
+// PrincipalOf is the principal cert is issued to: the one its id-pkinit-san
+// names or, failing that, its subject common name, taken as a principal of
+// realm unless it names its own.
+func PrincipalOf(cert *x509.Certificate, realm protocol.Realm) (protocol.Principal, error) {
+	p, ok, err := sanPrincipal(cert)
+	if err != nil {
+		return protocol.Principal{}, err
+	}
+	if ok {
+		return p, nil
+	}
+
+	if cert.Subject.CommonName == "" {
+		return protocol.Principal{}, fmt.Errorf("%w: %s", ErrNoPrincipal, cert.Subject)
+	}
+
+	primary, instance, cnRealm, err := protocol.Parse(cert.Subject.CommonName)
+	if err != nil {
+		return protocol.Principal{}, fmt.Errorf("%w: %w", ErrNoPrincipal, err)
+	}
+	if cnRealm == "" {
+		cnRealm = realm
+	}
+	return protocol.NewPrincipal(primary, instance, cnRealm)
+}
+
+// sanPrincipal finds the id-pkinit-san otherName in the subject alternative
+// names of cert.
+func sanPrincipal(cert *x509.Certificate) (protocol.Principal, bool, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+
+		input := cryptobyte.String(ext.Value)
+		var names cryptobyte.String
+		if !input.ReadASN1(&names, cbasn1.SEQUENCE) || !input.Empty() {
+			return protocol.Principal{}, false, fmt.Errorf("%w: malformed subject alternative name", ErrInvalidCertificate)
+		}
+
+		for !names.Empty() {
+			var name cryptobyte.String
+			var tag cbasn1.Tag
+			if !names.ReadAnyASN1(&name, &tag) {
+				return protocol.Principal{}, false, fmt.Errorf("%w: malformed subject alternative name", ErrInvalidCertificate)
+			}
+			if tag != otherNameTag {
+				continue
+			}
+
+			var typeID asn1.ObjectIdentifier
+			var value cryptobyte.String
+			if !name.ReadASN1ObjectIdentifier(&typeID) ||
+				!name.ReadASN1(&value, otherNameTag) || !name.Empty() {
+				return protocol.Principal{}, false, fmt.Errorf("%w: malformed otherName", ErrInvalidCertificate)
+			}
+			if !typeID.Equal(OIDPKINITSan) {
+				continue
+			}
+
+			p, err := protocol.ParseKRB5PrincipalName(value)
+			if err != nil {
+				return protocol.Principal{}, false, fmt.Errorf("%w: %w", ErrInvalidCertificate, err)
+			}
+			return p, true, nil
+		}
+	}
+	return protocol.Principal{}, false, nil
+}
+
+// otherNameTag is the [0] tag of an otherName GeneralName, which also
+// wraps the value inside it.
+var otherNameTag = cbasn1.Tag(0).ContextSpecific().Constructed()
+
+// SANExtension is the subject alternative name extension that issues a
+// certificate to p, for CAs that mint PKINIT certificates.
+func SANExtension(p protocol.Principal) (pkix.Extension, error) {
+	name, err := protocol.MarshalKRB5PrincipalName(p)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+
+	b := cryptobyte.NewBuilder(nil)
+	b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1(otherNameTag, func(b *cryptobyte.Builder) {
+			b.AddASN1ObjectIdentifier(OIDPKINITSan)
+			b.AddASN1(otherNameTag, func(b *cryptobyte.Builder) {
+				b.AddBytes(name)
+			})
+		})
+	})
+	value, err := b.Bytes()
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidSubjectAltName, Value: value}, nil
+}
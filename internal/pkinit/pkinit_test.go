@@ -0,0 +1,160 @@
+package pkinit_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/pkinit"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/rizesql/kerberos/internal/testkit"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	pki := testkit.NewPKI(t, "Athena CA", now)
+	alice, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	nonce, _ := protocol.NewNonce(42)
+	info, _ := protocol.NewKDCDHKeyInfo([]byte("public key"), nonce)
+
+	for name, c := range map[string]codec.Codec{"JSON": codec.JSON, "DER": codec.DER} {
+		t.Run("Success/"+name, func(t *testing.T) {
+			sd, err := pkinit.Sign(c, pki.Issue("alice", alice, pkinit.OIDKPClientAuth), info)
+			assert.Err(t, err, nil)
+
+			cert, err := pkinit.Verify(sd, pki.Roots, pkinit.PurposeClient, now)
+			assert.Err(t, err, nil)
+
+			owner, err := pkinit.PrincipalOf(cert, "ATHENA.MIT.EDU")
+			assert.Err(t, err, nil)
+			assert.Equal(t, owner, alice)
+		})
+	}
+
+	t.Run("UntrustedCA", func(t *testing.T) {
+		other := testkit.NewPKI(t, "Other CA", now)
+		sd, err := pkinit.Sign(codec.JSON, other.Issue("alice", alice, pkinit.OIDKPClientAuth), info)
+		assert.Err(t, err, nil)
+
+		_, err = pkinit.Verify(sd, pki.Roots, pkinit.PurposeClient, now)
+		assert.Err(t, err, pkinit.ErrUntrusted)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		sd, err := pkinit.Sign(codec.JSON, pki.Issue("alice", alice, pkinit.OIDKPClientAuth), info)
+		assert.Err(t, err, nil)
+
+		_, err = pkinit.Verify(sd, pki.Roots, pkinit.PurposeClient, now.Add(48*time.Hour))
+		assert.Err(t, err, pkinit.ErrUntrusted)
+	})
+
+	t.Run("WrongUsage", func(t *testing.T) {
+		sd, err := pkinit.Sign(codec.JSON, pki.Issue("alice", alice, pkinit.OIDKPClientAuth), info)
+		assert.Err(t, err, nil)
+
+		_, err = pkinit.Verify(sd, pki.Roots, pkinit.PurposeKDC, now)
+		assert.Err(t, err, pkinit.ErrInvalidCertificate)
+	})
+
+	t.Run("BadSignature", func(t *testing.T) {
+		cert := pki.Issue("alice", alice, pkinit.OIDKPClientAuth)
+		sd, err := pkinit.Sign(codec.JSON, cert, info)
+		assert.Err(t, err, nil)
+
+		// Content signed by another key of the same CA.
+		forged, err := pkinit.Sign(codec.JSON, pki.Issue("mallory", protocol.Principal{}, pkinit.OIDKPClientAuth), info)
+		assert.Err(t, err, nil)
+		tampered, err := protocol.NewPKSignedData(sd.Content(), sd.Certificates(), forged.Signature())
+		assert.Err(t, err, nil)
+
+		_, err = pkinit.Verify(tampered, pki.Roots, pkinit.PurposeClient, now)
+		assert.Err(t, err, pkinit.ErrInvalidSignature)
+	})
+}
+
+func TestPrincipalOf(t *testing.T) {
+	now := time.Now()
+	pki := testkit.NewPKI(t, "Athena CA", now)
+
+	t.Run("SAN", func(t *testing.T) {
+		agent, _ := protocol.NewPrincipal("host", "build-01", "ATHENA.MIT.EDU")
+		cert := pki.Issue("Build Agent 01", agent, pkinit.OIDKPClientAuth)
+
+		owner, err := pkinit.PrincipalOf(cert.Leaf, "OTHER.REALM")
+		assert.Err(t, err, nil)
+		assert.Equal(t, owner, agent)
+	})
+
+	t.Run("CommonName", func(t *testing.T) {
+		cert := pki.Issue("bob", protocol.Principal{}, pkinit.OIDKPClientAuth)
+
+		owner, err := pkinit.PrincipalOf(cert.Leaf, "ATHENA.MIT.EDU")
+		assert.Err(t, err, nil)
+		assert.Equal(t, owner.String(), "bob@ATHENA.MIT.EDU")
+	})
+
+	t.Run("NoName", func(t *testing.T) {
+		cert := pki.Issue("", protocol.Principal{}, pkinit.OIDKPClientAuth)
+
+		_, err := pkinit.PrincipalOf(cert.Leaf, "ATHENA.MIT.EDU")
+		assert.Err(t, err, pkinit.ErrNoPrincipal)
+	})
+}
+
+func TestExchange(t *testing.T) {
+	now := time.Now()
+	pki := testkit.NewPKI(t, "Athena CA", now)
+	alice, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	tgs, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	clientCert := pki.Issue("alice", alice, pkinit.OIDKPClientAuth)
+	kdcCert := pki.Issue("kdc.athena.mit.edu", tgs, pkinit.OIDKPKdc)
+	nonce, _ := protocol.NewNonce(42)
+	body := []byte("as-req body")
+
+	for name, c := range map[string]codec.Codec{"JSON": codec.JSON, "DER": codec.DER} {
+		t.Run("Success/"+name, func(t *testing.T) {
+			pa, dhKey, err := pkinit.NewRequest(c, clientCert, body, nonce, now)
+			assert.Err(t, err, nil)
+			assert.Equal(t, pa.Type(), protocol.PATypePKASReq)
+
+			pack, cert, err := pkinit.OpenRequest(pa, pki.Roots, body, now)
+			assert.Err(t, err, nil)
+			assert.Equal(t, pack.PKAuthenticator().Nonce(), nonce)
+			assert.Equal(t, cert.Subject.CommonName, "alice")
+
+			rep, kdcKey, err := pkinit.NewReply(c, kdcCert, pack, protocol.EncTypeAES256CTSHMACSHA196)
+			assert.Err(t, err, nil)
+			assert.Equal(t, rep.Type(), protocol.PATypePKASRep)
+
+			clientKey, err := pkinit.OpenReply(rep, pki.Roots, "ATHENA.MIT.EDU", dhKey, nonce, protocol.EncTypeAES256CTSHMACSHA196, now)
+			assert.Err(t, err, nil)
+			assert.Equal(t, clientKey.EncType(), protocol.EncTypeAES256CTSHMACSHA196)
+			assert.True(t, bytes.Equal(clientKey.Expose(), kdcKey.Expose()))
+		})
+	}
+
+	t.Run("Modified", func(t *testing.T) {
+		pa, _, err := pkinit.NewRequest(codec.JSON, clientCert, body, nonce, now)
+		assert.Err(t, err, nil)
+
+		_, _, err = pkinit.OpenRequest(pa, pki.Roots, []byte("another body"), now)
+		assert.Err(t, err, pkinit.ErrModified)
+	})
+
+	t.Run("WrongKDC", func(t *testing.T) {
+		pa, dhKey, err := pkinit.NewRequest(codec.JSON, clientCert, body, nonce, now)
+		assert.Err(t, err, nil)
+		pack, _, err := pkinit.OpenRequest(pa, pki.Roots, body, now)
+		assert.Err(t, err, nil)
+
+		// A KDC certificate for another realm does not speak for this one.
+		otherTGS, _ := protocol.NewKrbtgt("OTHER.REALM")
+		rep, _, err := pkinit.NewReply(codec.JSON, pki.Issue("kdc.other.realm", otherTGS, pkinit.OIDKPKdc), pack, protocol.EncTypeAES256CTSHMACSHA196)
+		assert.Err(t, err, nil)
+
+		_, err = pkinit.OpenReply(rep, pki.Roots, "ATHENA.MIT.EDU", dhKey, nonce, protocol.EncTypeAES256CTSHMACSHA196, now)
+		assert.Err(t, err, pkinit.ErrWrongKDC)
+	})
+}
@@ -1,10 +1,23 @@
 package protocol
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var ErrAPRepInvalidTime = errors.New("ap-rep timestamp cannot be empty")
 
 type APReq struct {
+	options       APOptions
 	ticket        EncryptedData
 	authenticator EncryptedData
+	cred          *KRBCred
 }
 
 func NewAPReq(ticket, authenticator EncryptedData) (APReq, error) {
@@ -14,18 +27,44 @@ func NewAPReq(ticket, authenticator EncryptedData) (APReq, error) {
 	}, nil
 }
 
+func (r APReq) Options() APOptions           { return r.options }
 func (r APReq) Ticket() EncryptedData        { return r.ticket }
 func (r APReq) Authenticator() EncryptedData { return r.authenticator }
 
+// WithOptions returns a copy of the request carrying the given AP options.
+func (r APReq) WithOptions(options APOptions) APReq {
+	r.options = options
+	return r
+}
+
+// Cred returns the credentials the client delegated alongside the request.
+func (r APReq) Cred() (KRBCred, bool) {
+	if r.cred == nil {
+		return KRBCred{}, false
+	}
+	return *r.cred, true
+}
+
+// WithCred returns a copy of the request that forwards cred to the server.
+// The KRB-CRED must be sealed under the session key of the ticket.
+func (r APReq) WithCred(cred KRBCred) APReq {
+	r.cred = &cred
+	return r
+}
+
 type apReq struct {
+	Options       APOptions     `json:"ap_options,omitempty"`
 	Ticket        EncryptedData `json:"ticket"`
 	Authenticator EncryptedData `json:"authenticator"`
+	Cred          *KRBCred      `json:"krb_cred,omitempty"`
 }
 
 func (r APReq) MarshalJSON() ([]byte, error) {
 	return json.Marshal(apReq{
+		Options:       r.options,
 		Ticket:        r.ticket,
 		Authenticator: r.authenticator,
+		Cred:          r.cred,
 	})
 }
 
@@ -40,6 +79,254 @@ func (r *APReq) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
-	*r = req
+	if tmp.Cred != nil {
+		req = req.WithCred(*tmp.Cred)
+	}
+
+	*r = req.WithOptions(tmp.Options)
+	return nil
+}
+
+// MarshalDER encodes r as the AP-REQ of RFC 4120 §5.5.1. Its ticket must
+// name its server. Delegated credentials have no place in the RFC message.
+func (r APReq) MarshalDER() ([]byte, error) {
+	if r.cred != nil {
+		return nil, fmt.Errorf("%w: AP-REQ krb_cred", ErrDERUnsupported)
+	}
+
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(application(tagAPReq), func(b *cryptobyte.Builder) {
+			b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				addInt(b, 0, pvno)
+				addInt(b, 1, msgTypeAPReq)
+				addFlags(b, 2, uint32(r.options))
+				b.AddASN1(field(3), func(b *cryptobyte.Builder) {
+					addTicket(b, r.ticket)
+				})
+				addEncryptedData(b, 4, r.authenticator)
+			})
+		})
+	})
+}
+
+func (r *APReq) UnmarshalDER(data []byte) error {
+	var seq cryptobyte.String
+	var options uint32
+	var ticket, authenticator EncryptedData
+	if !unmarshalApplication(data, tagAPReq, &seq) ||
+		!readVersion(&seq, 0, msgTypeAPReq) ||
+		!readFlags(&seq, 2, &options) ||
+		!readTicketField(&seq, 3, &ticket) ||
+		!readEncryptedData(&seq, 4, &authenticator) || !seq.Empty() {
+		return malformed("AP-REQ")
+	}
+
+	req, err := NewAPReq(ticket, authenticator)
+	if err != nil {
+		return err
+	}
+
+	*r = req.WithOptions(APOptions(options))
+	return nil
+}
+
+// EncAPRepPart is the encrypted part of an AP-REP. Echoing the timestamp of
+// the client's authenticator proves the server could open its ticket
+// (RFC 4120 §5.5.2).
+type EncAPRepPart struct {
+	issuedAt  time.Time
+	subkey    SessionKey
+	seqNumber *uint32
+}
+
+func NewEncAPRepPart(issuedAt time.Time) (EncAPRepPart, error) {
+	if issuedAt.IsZero() {
+		return EncAPRepPart{}, ErrAPRepInvalidTime
+	}
+
+	return EncAPRepPart{issuedAt: issuedAt}, nil
+}
+
+func (p EncAPRepPart) IssuedAt() time.Time { return p.issuedAt }
+
+// Subkey returns the key the server chose for the rest of the session, if it
+// chose one.
+func (p EncAPRepPart) Subkey() (SessionKey, bool) { return p.subkey, !p.subkey.IsZero() }
+
+// SeqNumber returns the initial sequence number of the server's messages, if
+// it set one.
+func (p EncAPRepPart) SeqNumber() (uint32, bool) {
+	if p.seqNumber == nil {
+		return 0, false
+	}
+	return *p.seqNumber, true
+}
+
+// WithSubkey returns a copy of the part carrying subkey.
+func (p EncAPRepPart) WithSubkey(subkey SessionKey) EncAPRepPart {
+	p.subkey = subkey
+	return p
+}
+
+// WithSeqNumber returns a copy of the part carrying an initial sequence
+// number.
+func (p EncAPRepPart) WithSeqNumber(seq uint32) EncAPRepPart {
+	p.seqNumber = &seq
+	return p
+}
+
+type encAPRepPart struct {
+	IssuedAt  time.Time   `json:"issued_at"`
+	Subkey    *SessionKey `json:"subkey,omitempty"`
+	SeqNumber *uint32     `json:"seq_number,omitempty"`
+}
+
+func (p EncAPRepPart) MarshalJSON() ([]byte, error) {
+	tmp := encAPRepPart{IssuedAt: p.issuedAt, SeqNumber: p.seqNumber}
+	if subkey, ok := p.Subkey(); ok {
+		tmp.Subkey = &subkey
+	}
+	return json.Marshal(tmp)
+}
+
+func (p *EncAPRepPart) UnmarshalJSON(data []byte) error {
+	var tmp encAPRepPart
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	part, err := NewEncAPRepPart(tmp.IssuedAt)
+	if err != nil {
+		return err
+	}
+
+	if tmp.Subkey != nil {
+		part = part.WithSubkey(*tmp.Subkey)
+	}
+	if tmp.SeqNumber != nil {
+		part = part.WithSeqNumber(*tmp.SeqNumber)
+	}
+
+	*p = part
+	return nil
+}
+
+// MarshalDER encodes p as the EncAPRepPart of RFC 4120 §5.5.2.
+func (p EncAPRepPart) MarshalDER() ([]byte, error) {
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(application(tagEncAPRepPart), func(b *cryptobyte.Builder) {
+			b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				addTime(b, 0, p.issuedAt.Truncate(time.Second))
+				addInt(b, 1, int64(p.issuedAt.Nanosecond()/int(time.Microsecond)))
+				if subkey, ok := p.Subkey(); ok {
+					addEncryptionKey(b, 2, subkey)
+				}
+				if seq, ok := p.SeqNumber(); ok {
+					addInt(b, 3, int64(seq))
+				}
+			})
+		})
+	})
+}
+
+func (p *EncAPRepPart) UnmarshalDER(data []byte) error {
+	var seq cryptobyte.String
+	var ctime time.Time
+	var cusec, seqNumber int64
+	var hasSeqNumber bool
+	var subkey SessionKey
+	if !unmarshalApplication(data, tagEncAPRepPart, &seq) ||
+		!readTime(&seq, 0, &ctime) ||
+		!readInt(&seq, 1, &cusec) ||
+		!readOptionalEncryptionKey(&seq, 2, &subkey) ||
+		!readOptionalInt(&seq, 3, &seqNumber, &hasSeqNumber) || !seq.Empty() {
+		return malformed("EncAPRepPart")
+	}
+	if cusec < 0 || cusec > 999999 {
+		return ErrAuthenticatorInvalidCusec
+	}
+
+	part, err := NewEncAPRepPart(ctime.Add(time.Duration(cusec) * time.Microsecond))
+	if err != nil {
+		return err
+	}
+
+	if !subkey.IsZero() {
+		part = part.WithSubkey(subkey)
+	}
+	if hasSeqNumber {
+		if seqNumber < 0 || seqNumber > math.MaxUint32 {
+			return malformed("EncAPRepPart")
+		}
+		part = part.WithSeqNumber(uint32(seqNumber))
+	}
+
+	*p = part
+	return nil
+}
+
+// APRep is the server's answer to an AP-REQ that asked for mutual
+// authentication. Its part is sealed under the ticket's session key.
+type APRep struct {
+	encPart EncryptedData
+}
+
+func NewAPRep(encPart EncryptedData) (APRep, error) {
+	return APRep{encPart: encPart}, nil
+}
+
+func (r APRep) EncPart() EncryptedData { return r.encPart }
+
+type apRep struct {
+	EncPart EncryptedData `json:"enc_part"`
+}
+
+func (r APRep) MarshalJSON() ([]byte, error) {
+	return json.Marshal(apRep{EncPart: r.encPart})
+}
+
+func (r *APRep) UnmarshalJSON(data []byte) error {
+	var tmp apRep
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	rep, err := NewAPRep(tmp.EncPart)
+	if err != nil {
+		return err
+	}
+
+	*r = rep
+	return nil
+}
+
+// MarshalDER encodes r as the AP-REP of RFC 4120 §5.5.2.
+func (r APRep) MarshalDER() ([]byte, error) {
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(application(tagAPRep), func(b *cryptobyte.Builder) {
+			b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				addInt(b, 0, pvno)
+				addInt(b, 1, msgTypeAPRep)
+				addEncryptedData(b, 2, r.encPart)
+			})
+		})
+	})
+}
+
+func (r *APRep) UnmarshalDER(data []byte) error {
+	var seq cryptobyte.String
+	var encPart EncryptedData
+	if !unmarshalApplication(data, tagAPRep, &seq) ||
+		!readVersion(&seq, 0, msgTypeAPRep) ||
+		!readEncryptedData(&seq, 2, &encPart) || !seq.Empty() {
+		return malformed("AP-REP")
+	}
+
+	rep, err := NewAPRep(encPart)
+	if err != nil {
+		return err
+	}
+
+	*r = rep
 	return nil
 }
@@ -0,0 +1,65 @@
+package protocol_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+func TestAPReqOptions(t *testing.T) {
+	ticket, _ := protocol.NewEncryptedData([]byte("ticket"))
+	auth, _ := protocol.NewEncryptedData([]byte("authenticator"))
+	req, _ := protocol.NewAPReq(ticket, auth)
+
+	data, err := json.Marshal(req.WithOptions(protocol.APOptMutualRequired))
+	assert.Err(t, err, nil)
+
+	var loaded protocol.APReq
+	assert.Err(t, json.Unmarshal(data, &loaded), nil)
+	assert.True(t, loaded.Options().Has(protocol.APOptMutualRequired))
+	assert.True(t, !req.Options().Has(protocol.APOptMutualRequired))
+}
+
+func TestEncAPRepPart(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	subkey, _ := protocol.NewSessionKey(make([]byte, 32))
+
+	_, err := protocol.NewEncAPRepPart(time.Time{})
+	assert.Err(t, err, protocol.ErrAPRepInvalidTime)
+
+	part, err := protocol.NewEncAPRepPart(now)
+	assert.Err(t, err, nil)
+
+	t.Run("Plain", func(t *testing.T) {
+		data, err := json.Marshal(part)
+		assert.Err(t, err, nil)
+
+		var loaded protocol.EncAPRepPart
+		assert.Err(t, json.Unmarshal(data, &loaded), nil)
+		assert.True(t, loaded.IssuedAt().Equal(now))
+
+		_, ok := loaded.Subkey()
+		assert.True(t, !ok)
+		_, ok = loaded.SeqNumber()
+		assert.True(t, !ok)
+	})
+
+	t.Run("SubkeyAndSeqNumber", func(t *testing.T) {
+		data, err := json.Marshal(part.WithSubkey(subkey).WithSeqNumber(42))
+		assert.Err(t, err, nil)
+
+		var loaded protocol.EncAPRepPart
+		assert.Err(t, json.Unmarshal(data, &loaded), nil)
+
+		key, ok := loaded.Subkey()
+		assert.True(t, ok)
+		assert.Equal(t, key.Expose(), subkey.Expose())
+
+		seq, ok := loaded.SeqNumber()
+		assert.True(t, ok)
+		assert.Equal(t, seq, uint32(42))
+	})
+}
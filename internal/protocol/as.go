@@ -3,6 +3,10 @@ package protocol
 import (
 	"encoding/json"
 	"net/http"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
 )
 
 type ASEndpoint struct{}
@@ -15,6 +19,14 @@ type ASReq struct {
 	service    Principal
 	clientAddr Address
 	nonce      Nonce
+	padata     []PAData
+	options    KDCOptions
+	from       time.Time
+	till       time.Time
+	etypes     []EncType
+	// body is the KDC-REQ-BODY a DER request arrived with, which a FAST
+	// request checksums as sent.
+	body []byte
 }
 
 func NewASReq(client, service Principal, addr Address, nonce Nonce) (ASReq, error) {
@@ -38,12 +50,86 @@ func (r ASReq) Client() Principal   { return r.client }
 func (r ASReq) Service() Principal  { return r.service }
 func (r ASReq) ClientAddr() Address { return r.clientAddr }
 func (r ASReq) Nonce() Nonce        { return r.nonce }
+func (r ASReq) PAData() MethodData  { return r.padata }
+func (r ASReq) Options() KDCOptions { return r.options }
+func (r ASReq) From() time.Time     { return r.from }
+func (r ASReq) Till() time.Time     { return r.till }
+
+// ETypes lists the encryption types the client supports, in its order of
+// preference. A request naming none supports only AES-256-GCM.
+func (r ASReq) ETypes() []EncType { return defaultETypes(r.etypes) }
+
+// WithPAData returns a copy of the request carrying the given
+// pre-authentication data.
+func (r ASReq) WithPAData(padata ...PAData) ASReq {
+	r.padata = append([]PAData(nil), padata...)
+	return r
+}
+
+// WithOptions returns a copy of the request asking for the given ticket
+// options.
+func (r ASReq) WithOptions(options KDCOptions) ASReq {
+	r.options = options
+	r.body = nil
+	return r
+}
+
+// WithTimes returns a copy of the request asking for a ticket valid from
+// from until till. A zero from means now; a zero till leaves the end time to
+// the KDC.
+func (r ASReq) WithTimes(from, till time.Time) ASReq {
+	r.from, r.till = from, till
+	r.body = nil
+	return r
+}
+
+// WithETypes returns a copy of the request listing the encryption types the
+// client supports, most preferred first.
+func (r ASReq) WithETypes(etypes ...EncType) ASReq {
+	r.etypes = append([]EncType(nil), etypes...)
+	r.body = nil
+	return r
+}
+
+// Body encodes the fields of the request that travel in the clear besides
+// its pre-authentication data, the KDC-REQ-BODY of RFC 4120 §5.4.1. A FAST
+// request checksums it under the armor key.
+func (r ASReq) Body() ([]byte, error) {
+	return json.Marshal(r.WithPAData())
+}
+
+// BodyDER is the DER form of Body. For a request decoded from DER it is the
+// body exactly as received.
+func (r ASReq) BodyDER() ([]byte, error) {
+	if r.body != nil {
+		return append([]byte(nil), r.body...), nil
+	}
+	return marshalDER(r.reqBody().addDER)
+}
+
+func (r ASReq) reqBody() kdcReqBody {
+	return kdcReqBody{
+		options:    r.options,
+		client:     r.client,
+		server:     r.service,
+		from:       r.from,
+		till:       r.till,
+		nonce:      r.nonce,
+		etypes:     r.ETypes(),
+		clientAddr: r.clientAddr,
+	}
+}
 
 type asReq struct {
-	Client     Principal `json:"client"`
-	Service    Principal `json:"service"`
-	ClientAddr Address   `json:"client_addr"`
-	Nonce      Nonce     `json:"nonce"`
+	Client     Principal  `json:"client"`
+	Service    Principal  `json:"service"`
+	ClientAddr Address    `json:"client_addr"`
+	Nonce      Nonce      `json:"nonce"`
+	PAData     []PAData   `json:"padata,omitempty"`
+	Options    KDCOptions `json:"kdc_options,omitempty"`
+	From       *time.Time `json:"from,omitempty"`
+	Till       *time.Time `json:"till,omitempty"`
+	ETypes     []EncType  `json:"etypes,omitempty"`
 }
 
 func (r ASReq) MarshalJSON() ([]byte, error) {
@@ -52,6 +138,11 @@ func (r ASReq) MarshalJSON() ([]byte, error) {
 		Service:    r.service,
 		ClientAddr: r.clientAddr,
 		Nonce:      r.nonce,
+		PAData:     r.padata,
+		Options:    r.options,
+		From:       optionalTime(r.from),
+		Till:       optionalTime(r.till),
+		ETypes:     r.etypes,
 	})
 }
 
@@ -66,6 +157,64 @@ func (r *ASReq) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	*r = req.
+		WithPAData(tmp.PAData...).
+		WithOptions(tmp.Options).
+		WithTimes(fromOptional(tmp.From), fromOptional(tmp.Till)).
+		WithETypes(tmp.ETypes...)
+	return nil
+}
+
+// MarshalDER encodes r as the AS-REQ of RFC 4120 §5.4.1. The client and
+// the service share the realm of the request body.
+func (r ASReq) MarshalDER() ([]byte, error) {
+	body, err := r.BodyDER()
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(application(tagASReq), func(b *cryptobyte.Builder) {
+			b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				addInt(b, 1, pvno)
+				addInt(b, 2, msgTypeASReq)
+				if len(r.padata) > 0 {
+					addPAData(b, 3, r.padata)
+				}
+				b.AddASN1(field(4), func(b *cryptobyte.Builder) {
+					b.AddBytes(body)
+				})
+			})
+		})
+	})
+}
+
+func (r *ASReq) UnmarshalDER(data []byte) error {
+	var seq, f cryptobyte.String
+	var padata []PAData
+	var body kdcReqBody
+	if !unmarshalApplication(data, tagASReq, &seq) ||
+		!readVersion(&seq, 1, msgTypeASReq) ||
+		!readOptionalPAData(&seq, 3, &padata) ||
+		!seq.ReadASN1(&f, field(4)) || !seq.Empty() {
+		return malformed("AS-REQ")
+	}
+	raw := []byte(f)
+	if !body.readDER(&f) || !f.Empty() {
+		return malformed("AS-REQ")
+	}
+
+	req, err := NewASReq(body.client, body.server, body.clientAddr, body.nonce)
+	if err != nil {
+		return err
+	}
+
+	req = req.
+		WithPAData(padata...).
+		WithOptions(body.options).
+		WithTimes(body.from, body.till).
+		WithETypes(body.etypes...)
+	req.body = append([]byte(nil), raw...)
 	*r = req
 	return nil
 }
@@ -73,6 +222,9 @@ func (r *ASReq) UnmarshalJSON(data []byte) error {
 type ASRep struct {
 	ticket     EncryptedData
 	secretPart EncryptedData
+	client     Principal
+	padata     []PAData
+	tgs        bool
 }
 
 func NewASRep(ticket, secretPart EncryptedData) (ASRep, error) {
@@ -85,13 +237,35 @@ func NewASRep(ticket, secretPart EncryptedData) (ASRep, error) {
 func (r ASRep) Ticket() EncryptedData     { return r.ticket }
 func (r ASRep) SecretPart() EncryptedData { return r.secretPart }
 
+// Client is the client the ticket was issued to, if the reply names it.
+func (r ASRep) Client() Principal { return r.client }
+
+// PAData is the pre-authentication data the KDC returned with the reply.
+func (r ASRep) PAData() MethodData { return r.padata }
+
+// WithClient returns a copy of the reply naming the client of its ticket,
+// which the DER encoding carries in the clear.
+func (r ASRep) WithClient(client Principal) ASRep {
+	r.client = client
+	return r
+}
+
+// WithPAData returns a copy of the reply carrying the given
+// pre-authentication data.
+func (r ASRep) WithPAData(padata ...PAData) ASRep {
+	r.padata = append([]PAData(nil), padata...)
+	return r
+}
+
 type asRep struct {
+	PAData     []PAData      `json:"padata,omitempty"`
 	Ticket     EncryptedData `json:"ticket"`
 	SecretPart EncryptedData `json:"secret_part"`
 }
 
 func (r ASRep) MarshalJSON() ([]byte, error) {
 	return json.Marshal(asRep{
+		PAData:     r.padata,
 		Ticket:     r.ticket,
 		SecretPart: r.secretPart,
 	})
@@ -108,6 +282,69 @@ func (r *ASRep) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
-	*r = rep
+	*r = rep.WithPAData(tmp.PAData...)
+	return nil
+}
+
+// MarshalDER encodes r as the AS-REP, or the TGS-REP, of RFC 4120 §5.4.2.
+// The ticket must name its server and the reply its client.
+func (r ASRep) MarshalDER() ([]byte, error) {
+	if r.client == (Principal{}) {
+		return nil, ErrInvalidPrincipal
+	}
+
+	tag, msgType := uint8(tagASRep), int64(msgTypeASRep)
+	if r.tgs {
+		tag, msgType = tagTGSRep, msgTypeTGSRep
+	}
+
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(application(tag), func(b *cryptobyte.Builder) {
+			b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				addInt(b, 0, pvno)
+				addInt(b, 1, msgType)
+				if len(r.padata) > 0 {
+					addPAData(b, 2, r.padata)
+				}
+				addString(b, 3, string(r.client.realm))
+				addPrincipalName(b, 4, r.client)
+				b.AddASN1(field(5), func(b *cryptobyte.Builder) {
+					addTicket(b, r.ticket)
+				})
+				addEncryptedData(b, 6, r.secretPart)
+			})
+		})
+	})
+}
+
+// UnmarshalDER decodes an AS-REP or a TGS-REP.
+func (r *ASRep) UnmarshalDER(data []byte) error {
+	tag, msgType, tgs := uint8(tagASRep), int64(msgTypeASRep), false
+	if input := cryptobyte.String(data); input.PeekASN1Tag(application(tagTGSRep)) {
+		tag, msgType, tgs = tagTGSRep, msgTypeTGSRep, true
+	}
+
+	var seq cryptobyte.String
+	var padata []PAData
+	var crealm string
+	var client Principal
+	var ticket, secretPart EncryptedData
+	if !unmarshalApplication(data, tag, &seq) ||
+		!readVersion(&seq, 0, msgType) ||
+		!readOptionalPAData(&seq, 2, &padata) ||
+		!readString(&seq, 3, &crealm) ||
+		!readPrincipalName(&seq, 4, Realm(crealm), &client) ||
+		!readTicketField(&seq, 5, &ticket) ||
+		!readEncryptedData(&seq, 6, &secretPart) || !seq.Empty() {
+		return malformed("KDC-REP")
+	}
+
+	rep, err := NewASRep(ticket, secretPart)
+	if err != nil {
+		return err
+	}
+	rep.tgs = tgs
+
+	*r = rep.WithClient(client).WithPAData(padata...)
 	return nil
 }
@@ -66,5 +66,22 @@ func TestASRep(t *testing.T) {
 
 		assert.Equal(t, string(decoded.Ticket().Ciphertext()), string(original.Ticket().Ciphertext()))
 		assert.Equal(t, string(decoded.SecretPart().Ciphertext()), string(original.SecretPart().Ciphertext()))
+		assert.Equal(t, len(decoded.PAData()), 0)
+	})
+
+	t.Run("PAData", func(t *testing.T) {
+		pa, _ := protocol.NewPAData(protocol.PATypeETypeInfo2, []byte("info"))
+		original, _ := protocol.NewASRep(ticket, secretPart)
+		original = original.WithPAData(pa)
+
+		data, err := json.Marshal(original)
+		assert.Err(t, err, nil)
+
+		var decoded protocol.ASRep
+		assert.Err(t, json.Unmarshal(data, &decoded), nil)
+
+		got, ok := decoded.PAData().Find(protocol.PATypeETypeInfo2)
+		assert.True(t, ok)
+		assert.Equal(t, string(got.Value()), "info")
 	})
 }
@@ -3,18 +3,28 @@ package protocol
 import (
 	"encoding/json"
 	"errors"
+	"math"
 	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
 )
 
 var (
 	ErrAuthenticatorInvalidClient  = errors.New("authenticator client principal cannot be empty")
 	ErrAuthenticatorInvalidAddress = errors.New("authenticator client address cannot be empty")
+	ErrAuthenticatorInvalidCusec   = errors.New("authenticator cusec must be in [0, 999999]")
 )
 
+// Authenticator proves that its sender holds the session key of a ticket
+// (RFC 4120 §5.5.1). Its timestamp has the RFC's microsecond precision.
 type Authenticator struct {
 	client     Principal
 	clientAddr Address
 	issuedAt   time.Time
+	cksum      Checksum
+	subkey     SessionKey
+	seqNumber  *uint32
 }
 
 func NewAuthenticator(
@@ -32,7 +42,7 @@ func NewAuthenticator(
 	return Authenticator{
 		client:     client,
 		clientAddr: clientAddr,
-		issuedAt:   issuedAt,
+		issuedAt:   issuedAt.Truncate(time.Microsecond),
 	}, nil
 }
 
@@ -40,18 +50,73 @@ func (a Authenticator) Client() Principal   { return a.client }
 func (a Authenticator) ClientAddr() Address { return a.clientAddr }
 func (a Authenticator) IssuedAt() time.Time { return a.issuedAt }
 
+// CTime is the timestamp of the authenticator to the second.
+func (a Authenticator) CTime() time.Time { return a.issuedAt.Truncate(time.Second) }
+
+// Cusec is the microsecond part of the timestamp.
+func (a Authenticator) Cusec() int { return a.issuedAt.Nanosecond() / int(time.Microsecond) }
+
+// Checksum returns the checksum the authenticator binds to its message, if
+// it carries one. For a TGS-REQ it covers the request body.
+func (a Authenticator) Checksum() (Checksum, bool) { return a.cksum, !a.cksum.IsZero() }
+
+// Subkey returns the key the client proposes for the session instead of the
+// ticket's, if it proposes one.
+func (a Authenticator) Subkey() (SessionKey, bool) { return a.subkey, !a.subkey.IsZero() }
+
+// SeqNumber returns the initial sequence number of the client's messages, if
+// it set one.
+func (a Authenticator) SeqNumber() (uint32, bool) {
+	if a.seqNumber == nil {
+		return 0, false
+	}
+	return *a.seqNumber, true
+}
+
+// WithChecksum returns a copy of the authenticator carrying cksum.
+func (a Authenticator) WithChecksum(cksum Checksum) Authenticator {
+	a.cksum = cksum
+	return a
+}
+
+// WithSubkey returns a copy of the authenticator proposing subkey.
+func (a Authenticator) WithSubkey(subkey SessionKey) Authenticator {
+	a.subkey = subkey
+	return a
+}
+
+// WithSeqNumber returns a copy of the authenticator carrying an initial
+// sequence number.
+func (a Authenticator) WithSeqNumber(seq uint32) Authenticator {
+	a.seqNumber = &seq
+	return a
+}
+
 type authenticator struct {
-	Client     Principal `json:"client"`
-	ClientAddr Address   `json:"client_addr"`
-	IssuedAt   time.Time `json:"issued_at"`
+	Client     Principal   `json:"client"`
+	ClientAddr Address     `json:"client_addr"`
+	CTime      time.Time   `json:"ctime"`
+	Cusec      int         `json:"cusec"`
+	Checksum   *Checksum   `json:"cksum,omitempty"`
+	Subkey     *SessionKey `json:"subkey,omitempty"`
+	SeqNumber  *uint32     `json:"seq_number,omitempty"`
 }
 
 func (a Authenticator) MarshalJSON() ([]byte, error) {
-	return json.Marshal(authenticator{
+	tmp := authenticator{
 		Client:     a.client,
 		ClientAddr: a.clientAddr,
-		IssuedAt:   a.issuedAt,
-	})
+		CTime:      a.CTime(),
+		Cusec:      a.Cusec(),
+		SeqNumber:  a.seqNumber,
+	}
+	if cksum, ok := a.Checksum(); ok {
+		tmp.Checksum = &cksum
+	}
+	if subkey, ok := a.Subkey(); ok {
+		tmp.Subkey = &subkey
+	}
+	return json.Marshal(tmp)
 }
 
 func (a *Authenticator) UnmarshalJSON(data []byte) error {
@@ -60,15 +125,119 @@ func (a *Authenticator) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	if tmp.Cusec < 0 || tmp.Cusec > 999999 {
+		return ErrAuthenticatorInvalidCusec
+	}
+
 	au, err := NewAuthenticator(
 		tmp.Client,
 		tmp.ClientAddr,
-		tmp.IssuedAt,
+		tmp.CTime.Truncate(time.Second).Add(time.Duration(tmp.Cusec)*time.Microsecond),
 	)
 	if err != nil {
 		return err
 	}
 
+	if tmp.Checksum != nil {
+		au = au.WithChecksum(*tmp.Checksum)
+	}
+	if tmp.Subkey != nil {
+		au = au.WithSubkey(*tmp.Subkey)
+	}
+	if tmp.SeqNumber != nil {
+		au = au.WithSeqNumber(*tmp.SeqNumber)
+	}
+
+	*a = au
+	return nil
+}
+
+// MarshalDER encodes a as the Authenticator of RFC 4120 §5.5.1. The RFC
+// message has no client address, so it travels as authorization data of our
+// own.
+func (a Authenticator) MarshalDER() ([]byte, error) {
+	addr, err := marshalDER(func(b *cryptobyte.Builder) {
+		addHostAddress(b, a.clientAddr)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(application(tagAuthenticator), func(b *cryptobyte.Builder) {
+			b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				addInt(b, 0, pvno)
+				addString(b, 1, string(a.client.realm))
+				addPrincipalName(b, 2, a.client)
+				if cksum, ok := a.Checksum(); ok {
+					addChecksum(b, 3, cksum)
+				}
+				addInt(b, 4, int64(a.Cusec()))
+				addTime(b, 5, a.CTime())
+				if subkey, ok := a.Subkey(); ok {
+					addEncryptionKey(b, 6, subkey)
+				}
+				if seq, ok := a.SeqNumber(); ok {
+					addInt(b, 7, int64(seq))
+				}
+				addAuthorizationData(b, 8, map[int64][]byte{adTypeClientAddr: addr})
+			})
+		})
+	})
+}
+
+func (a *Authenticator) UnmarshalDER(data []byte) error {
+	var seq cryptobyte.String
+	var crealm string
+	var client Principal
+	var cksum Checksum
+	var cusec, seqNumber int64
+	var hasSeqNumber bool
+	var ctime time.Time
+	var subkey SessionKey
+	authz := map[int64][]byte{}
+	if !unmarshalApplication(data, tagAuthenticator, &seq) ||
+		!readVersion(&seq, 0, 0) ||
+		!readString(&seq, 1, &crealm) ||
+		!readPrincipalName(&seq, 2, Realm(crealm), &client) ||
+		!readOptionalChecksum(&seq, 3, &cksum) ||
+		!readInt(&seq, 4, &cusec) ||
+		!readTime(&seq, 5, &ctime) ||
+		!readOptionalEncryptionKey(&seq, 6, &subkey) ||
+		!readOptionalInt(&seq, 7, &seqNumber, &hasSeqNumber) ||
+		!readAuthorizationData(&seq, 8, authz) || !seq.Empty() {
+		return malformed("Authenticator")
+	}
+	if cusec < 0 || cusec > 999999 {
+		return ErrAuthenticatorInvalidCusec
+	}
+
+	var addr Address
+	if data, ok := authz[adTypeClientAddr]; ok {
+		input := cryptobyte.String(data)
+		if !readHostAddress(&input, &addr) || !input.Empty() {
+			return malformed("Authenticator")
+		}
+	}
+
+	au, err := NewAuthenticator(client, addr, ctime.Add(time.Duration(cusec)*time.Microsecond))
+	if err != nil {
+		return err
+	}
+
+	if !cksum.IsZero() {
+		au = au.WithChecksum(cksum)
+	}
+	if !subkey.IsZero() {
+		au = au.WithSubkey(subkey)
+	}
+	if hasSeqNumber {
+		if seqNumber < 0 || seqNumber > math.MaxUint32 {
+			return malformed("Authenticator")
+		}
+		au = au.WithSeqNumber(uint32(seqNumber))
+	}
+
 	*a = au
 	return nil
 }
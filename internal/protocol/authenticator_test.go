@@ -49,3 +49,63 @@ func TestAuthenticatorValidation(t *testing.T) {
 	_, err = protocol.NewAuthenticator(client, protocol.Address{}, now)
 	assert.Err(t, err, protocol.ErrAuthenticatorInvalidAddress)
 }
+
+func TestAuthenticatorRFCFields(t *testing.T) {
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	subkey, _ := protocol.NewSessionKey(make([]byte, 32))
+	cksum, _ := protocol.NewChecksum(protocol.ChecksumHMACSHA256, []byte{0xde, 0xad})
+
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	auth, err := protocol.NewAuthenticator(client, addr, ts)
+	assert.Err(t, err, nil)
+
+	assert.True(t, auth.CTime().Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+	assert.Equal(t, auth.Cusec(), 123456)
+	assert.True(t, auth.IssuedAt().Equal(ts.Truncate(time.Microsecond)))
+
+	t.Run("Optional fields absent", func(t *testing.T) {
+		_, ok := auth.Checksum()
+		assert.True(t, !ok)
+		_, ok = auth.Subkey()
+		assert.True(t, !ok)
+		_, ok = auth.SeqNumber()
+		assert.True(t, !ok)
+	})
+
+	t.Run("Round trip", func(t *testing.T) {
+		data, err := json.Marshal(auth.WithChecksum(cksum).WithSubkey(subkey).WithSeqNumber(9))
+		assert.Err(t, err, nil)
+
+		var loaded protocol.Authenticator
+		assert.Err(t, json.Unmarshal(data, &loaded), nil)
+		assert.True(t, loaded.IssuedAt().Equal(auth.IssuedAt()))
+		assert.Equal(t, loaded.Cusec(), 123456)
+
+		gotCksum, ok := loaded.Checksum()
+		assert.True(t, ok)
+		assert.Equal(t, gotCksum.Value(), cksum.Value())
+
+		gotSubkey, ok := loaded.Subkey()
+		assert.True(t, ok)
+		assert.Equal(t, gotSubkey.Expose(), subkey.Expose())
+
+		seq, ok := loaded.SeqNumber()
+		assert.True(t, ok)
+		assert.Equal(t, seq, uint32(9))
+	})
+
+	t.Run("Invalid cusec", func(t *testing.T) {
+		data, err := json.Marshal(auth)
+		assert.Err(t, err, nil)
+
+		var raw map[string]any
+		assert.Err(t, json.Unmarshal(data, &raw), nil)
+		raw["cusec"] = 1000000
+		data, err = json.Marshal(raw)
+		assert.Err(t, err, nil)
+
+		var loaded protocol.Authenticator
+		assert.Err(t, json.Unmarshal(data, &loaded), protocol.ErrAuthenticatorInvalidCusec)
+	})
+}
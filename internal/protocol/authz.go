@@ -0,0 +1,192 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var (
+	ErrClaimsInvalidAuthTime = errors.New("claims auth time cannot be empty")
+	ErrAuthzDataNoChecksum   = errors.New("authorization data must carry a server and a KDC checksum")
+)
+
+// Claims is what the KDC asserts about the client of a ticket beyond its
+// name: the groups it belongs to and when it authenticated to the AS. The
+// auth time has the second precision of a KerberosTime, so that the claims
+// checksum the same in either encoding.
+type Claims struct {
+	groups   []string
+	authTime time.Time
+}
+
+func NewClaims(groups []string, authTime time.Time) (Claims, error) {
+	if authTime.IsZero() {
+		return Claims{}, ErrClaimsInvalidAuthTime
+	}
+
+	groups = slices.Clone(groups)
+	slices.Sort(groups)
+	return Claims{
+		groups:   slices.Compact(groups),
+		authTime: authTime.UTC().Truncate(time.Second),
+	}, nil
+}
+
+// Groups lists the client's groups in name order.
+func (c Claims) Groups() []string    { return slices.Clone(c.groups) }
+func (c Claims) AuthTime() time.Time { return c.authTime }
+
+// InGroup reports whether the client is a member of group.
+func (c Claims) InGroup(group string) bool {
+	_, found := slices.BinarySearch(c.groups, group)
+	return found
+}
+
+type claims struct {
+	Groups   []string  `json:"groups"`
+	AuthTime time.Time `json:"auth_time"`
+}
+
+func (c Claims) MarshalJSON() ([]byte, error) {
+	groups := c.groups
+	if groups == nil {
+		groups = []string{}
+	}
+	return json.Marshal(claims{Groups: groups, AuthTime: c.authTime})
+}
+
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	var tmp claims
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	cl, err := NewClaims(tmp.Groups, tmp.AuthTime)
+	if err != nil {
+		return err
+	}
+
+	*c = cl
+	return nil
+}
+
+// AuthorizationData carries the claims the KDC issued for a ticket, as an
+// AD-KDC-ISSUED element does (RFC 4120 §5.2.6.2). Like a Windows PAC it is
+// signed twice: the server checksum, under the key of the ticket's server,
+// lets the service check the claims; the KDC checksum, under a key only the
+// KDC holds, covers the server checksum so that a service cannot rewrite the
+// claims of a ticket it holds and present it back to the KDC.
+type AuthorizationData struct {
+	claims      Claims
+	serverCksum Checksum
+	kdcCksum    Checksum
+}
+
+func NewAuthorizationData(claims Claims, serverCksum, kdcCksum Checksum) (AuthorizationData, error) {
+	if serverCksum.IsZero() || kdcCksum.IsZero() {
+		return AuthorizationData{}, ErrAuthzDataNoChecksum
+	}
+
+	return AuthorizationData{
+		claims:      claims,
+		serverCksum: serverCksum,
+		kdcCksum:    kdcCksum,
+	}, nil
+}
+
+func (a AuthorizationData) Claims() Claims           { return a.claims }
+func (a AuthorizationData) ServerChecksum() Checksum { return a.serverCksum }
+func (a AuthorizationData) KDCChecksum() Checksum    { return a.kdcCksum }
+func (a AuthorizationData) IsZero() bool             { return a.serverCksum.IsZero() }
+
+type authorizationData struct {
+	Claims      Claims   `json:"claims"`
+	ServerCksum Checksum `json:"server_cksum"`
+	KDCCksum    Checksum `json:"kdc_cksum"`
+}
+
+func (a AuthorizationData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(authorizationData{
+		Claims:      a.claims,
+		ServerCksum: a.serverCksum,
+		KDCCksum:    a.kdcCksum,
+	})
+}
+
+func (a *AuthorizationData) UnmarshalJSON(data []byte) error {
+	var tmp authorizationData
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	ad, err := NewAuthorizationData(tmp.Claims, tmp.ServerCksum, tmp.KDCCksum)
+	if err != nil {
+		return err
+	}
+
+	*a = ad
+	return nil
+}
+
+// marshalDER encodes a as the ad-data of our claims authorization element:
+//
+//	KDCClaims ::= SEQUENCE {
+//		groups       [0] SEQUENCE OF KerberosString,
+//		auth-time    [1] KerberosTime,
+//		server-cksum [2] Checksum,
+//		kdc-cksum    [3] Checksum
+//	}
+func (a AuthorizationData) marshalDER() ([]byte, error) {
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			addSequence(b, 0, func(b *cryptobyte.Builder) {
+				for _, g := range a.claims.groups {
+					addKerberosString(b, g)
+				}
+			})
+			addTime(b, 1, a.claims.authTime)
+			addChecksum(b, 2, a.serverCksum)
+			addChecksum(b, 3, a.kdcCksum)
+		})
+	})
+}
+
+func (a *AuthorizationData) unmarshalDER(data []byte) error {
+	input := cryptobyte.String(data)
+	var seq, f, groups cryptobyte.String
+	var authTime time.Time
+	var serverCksum, kdcCksum Checksum
+	if !input.ReadASN1(&seq, asn1.SEQUENCE) || !input.Empty() ||
+		!seq.ReadASN1(&f, field(0)) || !f.ReadASN1(&groups, asn1.SEQUENCE) || !f.Empty() ||
+		!readTime(&seq, 1, &authTime) ||
+		!readChecksumField(&seq, 2, &serverCksum) ||
+		!readChecksumField(&seq, 3, &kdcCksum) || !seq.Empty() {
+		return malformed("claims")
+	}
+
+	var names []string
+	for !groups.Empty() {
+		var g string
+		if !readKerberosString(&groups, &g) {
+			return malformed("claims")
+		}
+		names = append(names, g)
+	}
+
+	claims, err := NewClaims(names, authTime)
+	if err != nil {
+		return err
+	}
+	ad, err := NewAuthorizationData(claims, serverCksum, kdcCksum)
+	if err != nil {
+		return err
+	}
+
+	*a = ad
+	return nil
+}
@@ -0,0 +1,78 @@
+package protocol_test
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+func TestClaims(t *testing.T) {
+	now := time.Now().UTC()
+
+	_, err := protocol.NewClaims([]string{"staff"}, time.Time{})
+	assert.Err(t, err, protocol.ErrClaimsInvalidAuthTime)
+
+	claims, err := protocol.NewClaims([]string{"staff", "admins", "staff"}, now)
+	assert.Err(t, err, nil)
+
+	groups := claims.Groups()
+	assert.Equal(t, len(groups), 2)
+	assert.Equal(t, groups[0], "admins")
+	assert.Equal(t, groups[1], "staff")
+	assert.True(t, claims.InGroup("staff"))
+	assert.True(t, !claims.InGroup("ops"))
+	// The auth time has the precision of a KerberosTime.
+	assert.True(t, claims.AuthTime().Equal(now.Truncate(time.Second)))
+
+	data, err := json.Marshal(claims)
+	assert.Err(t, err, nil)
+
+	var loaded protocol.Claims
+	assert.Err(t, json.Unmarshal(data, &loaded), nil)
+	assert.Equal(t, len(loaded.Groups()), 2)
+	assert.True(t, loaded.AuthTime().Equal(claims.AuthTime()))
+
+	// The claims are signed over their encoding, so it must not change
+	// across a round trip.
+	again, err := json.Marshal(loaded)
+	assert.Err(t, err, nil)
+	assert.Equal(t, string(again), string(data))
+}
+
+func TestAuthorizationData(t *testing.T) {
+	now := time.Now().UTC()
+	claims, _ := protocol.NewClaims([]string{"staff"}, now)
+	serverCksum, _ := protocol.NewChecksum(protocol.ChecksumHMACSHA256, []byte{1, 2, 3})
+	kdcCksum, _ := protocol.NewChecksum(protocol.ChecksumHMACSHA256, []byte{4, 5, 6})
+
+	_, err := protocol.NewAuthorizationData(claims, serverCksum, protocol.Checksum{})
+	assert.Err(t, err, protocol.ErrAuthzDataNoChecksum)
+
+	ad, err := protocol.NewAuthorizationData(claims, serverCksum, kdcCksum)
+	assert.Err(t, err, nil)
+
+	server, _ := protocol.NewPrincipal("http", "api", "ATHENA.MIT.EDU")
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	key, _ := protocol.NewSessionKey(make([]byte, 32))
+	ticket, _ := protocol.NewTicket(server, client, addr, now, time.Hour, key)
+
+	_, ok := ticket.AuthorizationData()
+	assert.True(t, !ok)
+
+	data, err := json.Marshal(ticket.WithAuthorizationData(ad))
+	assert.Err(t, err, nil)
+
+	var loaded protocol.Ticket
+	assert.Err(t, json.Unmarshal(data, &loaded), nil)
+
+	got, ok := loaded.AuthorizationData()
+	assert.True(t, ok)
+	assert.True(t, got.Claims().InGroup("staff"))
+	assert.Equal(t, string(got.ServerChecksum().Value()), string(serverCksum.Value()))
+	assert.Equal(t, string(got.KDCChecksum().Value()), string(kdcCksum.Value()))
+}
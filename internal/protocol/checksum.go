@@ -0,0 +1,66 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var ErrChecksumEmpty = errors.New("checksum cannot be empty")
+
+// ChecksumType identifies the algorithm of a Checksum (RFC 3961 §10).
+type ChecksumType int32
+
+// ChecksumHMACSHA256 is a full-length HMAC-SHA256 keyed directly with the
+// session key. It has no RFC 3961 number, so it takes one from the range
+// reserved for local use.
+const ChecksumHMACSHA256 ChecksumType = -1
+
+// The keyed checksums of the RFC 3962 and RFC 8009 encryption types.
+const (
+	ChecksumHMACSHA196AES128    ChecksumType = 15
+	ChecksumHMACSHA196AES256    ChecksumType = 16
+	ChecksumHMACSHA256128AES128 ChecksumType = 19
+	ChecksumHMACSHA384192AES256 ChecksumType = 20
+)
+
+// Checksum is a keyed checksum over some message (RFC 4120 §5.2.9).
+type Checksum struct {
+	ctype ChecksumType
+	value []byte
+}
+
+func NewChecksum(ctype ChecksumType, value []byte) (Checksum, error) {
+	if len(value) == 0 {
+		return Checksum{}, ErrChecksumEmpty
+	}
+
+	return Checksum{ctype: ctype, value: append([]byte(nil), value...)}, nil
+}
+
+func (c Checksum) Type() ChecksumType { return c.ctype }
+func (c Checksum) Value() []byte      { return append([]byte(nil), c.value...) }
+func (c Checksum) IsZero() bool       { return len(c.value) == 0 }
+
+type checksum struct {
+	Type  ChecksumType `json:"cksumtype"`
+	Value []byte       `json:"checksum"`
+}
+
+func (c Checksum) MarshalJSON() ([]byte, error) {
+	return json.Marshal(checksum{Type: c.ctype, Value: c.value})
+}
+
+func (c *Checksum) UnmarshalJSON(data []byte) error {
+	var tmp checksum
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	sum, err := NewChecksum(tmp.Type, tmp.Value)
+	if err != nil {
+		return err
+	}
+
+	*c = sum
+	return nil
+}
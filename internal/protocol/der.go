@@ -0,0 +1,738 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// This file holds the building blocks of the ASN.1 DER encoding of RFC 4120
+// §5. Each message type encodes itself with them in MarshalDER and
+// UnmarshalDER, next to its JSON form.
+
+var (
+	ErrMalformedDER   = errors.New("malformed DER encoding")
+	ErrTicketNoServer = errors.New("DER ticket must name its server")
+	ErrDERUnsupported = errors.New("field has no DER encoding")
+)
+
+const pvno = 5
+
+// Application tags of the messages (RFC 4120 §5.10).
+const (
+	tagTicket        = 1
+	tagAuthenticator = 2
+	tagEncTicketPart = 3
+	tagASReq         = 10
+	tagASRep         = 11
+	tagTGSReq        = 12
+	tagTGSRep        = 13
+	tagAPReq         = 14
+	tagAPRep         = 15
+	tagEncASRepPart  = 25
+	tagEncTGSRepPart = 26
+	tagEncAPRepPart  = 27
+	tagKRBError      = 30
+)
+
+// Assigned numbers used by the encoding (RFC 4120 §6.2, §7.5).
+const (
+	nameTypePrincipal    = 1
+	nameTypeSrvInst      = 2
+	addrTypeIPv4         = 2
+	addrTypeIPv6         = 24
+	trTypeDomainX500     = 1
+	adTypeIfRelevant     = 1
+	msgTypeASReq         = 10
+	msgTypeASRep         = 11
+	msgTypeTGSReq        = 12
+	msgTypeTGSRep        = 13
+	msgTypeAPReq         = 14
+	msgTypeAPRep         = 15
+	msgTypeKRBError      = 30
+	kerberosTimeFormat   = "20060102150405Z"
+	maxKerberosFlagsBits = 32
+)
+
+// Authorization data types of our own, from the negative range RFC 4120
+// §7.5.4 reserves for local use. They travel inside AD-IF-RELEVANT so that
+// other implementations may ignore them.
+const (
+	adTypeClaims     = -1
+	adTypeClientAddr = -2
+)
+
+func malformed(what string) error {
+	return fmt.Errorf("%w: %s", ErrMalformedDER, what)
+}
+
+func application(n uint8) asn1.Tag { return asn1.Tag(n | 0x40).Constructed() }
+
+func field(n uint8) asn1.Tag { return asn1.Tag(n).ContextSpecific().Constructed() }
+
+// kerberosTime maps a zero time to the epoch, for fields RFC 4120 requires
+// but the message leaves unset.
+func kerberosTime(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Unix(0, 0)
+	}
+	return t
+}
+
+func fromKerberosTime(t time.Time) time.Time {
+	if t.Unix() == 0 {
+		return time.Time{}
+	}
+	return t
+}
+
+func addSequence(b *cryptobyte.Builder, n uint8, f cryptobyte.BuilderContinuation) {
+	b.AddASN1(field(n), func(b *cryptobyte.Builder) {
+		b.AddASN1(asn1.SEQUENCE, f)
+	})
+}
+
+func addInt(b *cryptobyte.Builder, n uint8, v int64) {
+	b.AddASN1(field(n), func(b *cryptobyte.Builder) {
+		b.AddASN1Int64(v)
+	})
+}
+
+func addOctets(b *cryptobyte.Builder, n uint8, v []byte) {
+	b.AddASN1(field(n), func(b *cryptobyte.Builder) {
+		b.AddASN1OctetString(v)
+	})
+}
+
+func addKerberosString(b *cryptobyte.Builder, s string) {
+	b.AddASN1(asn1.GeneralString, func(b *cryptobyte.Builder) {
+		b.AddBytes([]byte(s))
+	})
+}
+
+func addString(b *cryptobyte.Builder, n uint8, s string) {
+	b.AddASN1(field(n), func(b *cryptobyte.Builder) {
+		addKerberosString(b, s)
+	})
+}
+
+// addTime encodes a KerberosTime, which has no fractional seconds.
+func addTime(b *cryptobyte.Builder, n uint8, t time.Time) {
+	b.AddASN1(field(n), func(b *cryptobyte.Builder) {
+		b.AddASN1(asn1.GeneralizedTime, func(b *cryptobyte.Builder) {
+			b.AddBytes([]byte(t.UTC().Format(kerberosTimeFormat)))
+		})
+	})
+}
+
+// addFlags encodes a KerberosFlags, a 32-bit BIT STRING whose bit 0 is the
+// most significant.
+func addFlags(b *cryptobyte.Builder, n uint8, flags uint32) {
+	b.AddASN1(field(n), func(b *cryptobyte.Builder) {
+		b.AddASN1BitString(binary.BigEndian.AppendUint32(nil, flags))
+	})
+}
+
+// principalName returns the name-string of p. The instance, if any, is its
+// second component.
+func principalName(p Principal) (nameType int64, components []string) {
+	components = []string{string(p.primary)}
+	if p.instance != "" {
+		components = append(components, string(p.instance))
+	}
+	if p.IsKrbtgt() {
+		return nameTypeSrvInst, components
+	}
+	return nameTypePrincipal, components
+}
+
+func addPrincipalName(b *cryptobyte.Builder, n uint8, p Principal) {
+	nameType, components := principalName(p)
+	addSequence(b, n, func(b *cryptobyte.Builder) {
+		addInt(b, 0, nameType)
+		addSequence(b, 1, func(b *cryptobyte.Builder) {
+			for _, c := range components {
+				addKerberosString(b, c)
+			}
+		})
+	})
+}
+
+func addHostAddress(b *cryptobyte.Builder, addr Address) {
+	addrType, ip := int64(addrTypeIPv6), addr.value.To16()
+	if v4 := addr.value.To4(); v4 != nil {
+		addrType, ip = addrTypeIPv4, v4
+	}
+	if ip == nil {
+		b.SetError(ErrAddressInvalid)
+		return
+	}
+
+	b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		addInt(b, 0, addrType)
+		addOctets(b, 1, ip)
+	})
+}
+
+// addHostAddresses encodes addr as the only entry of a HostAddresses.
+func addHostAddresses(b *cryptobyte.Builder, n uint8, addr Address) {
+	addSequence(b, n, func(b *cryptobyte.Builder) {
+		addHostAddress(b, addr)
+	})
+}
+
+func addEncryptionKey(b *cryptobyte.Builder, n uint8, key SessionKey) {
+	addSequence(b, n, func(b *cryptobyte.Builder) {
+		addInt(b, 0, int64(key.etype))
+		addOctets(b, 1, key.value)
+	})
+}
+
+func addChecksum(b *cryptobyte.Builder, n uint8, c Checksum) {
+	addSequence(b, n, func(b *cryptobyte.Builder) {
+		addInt(b, 0, int64(c.ctype))
+		addOctets(b, 1, c.value)
+	})
+}
+
+func addEncryptedData(b *cryptobyte.Builder, n uint8, e EncryptedData) {
+	b.AddASN1(field(n), e.addDER)
+}
+
+// addTicket encodes e as the Ticket it seals, which names its server in the
+// clear.
+func addTicket(b *cryptobyte.Builder, e EncryptedData) {
+	server, ok := e.Server()
+	if !ok {
+		b.SetError(ErrTicketNoServer)
+		return
+	}
+
+	b.AddASN1(application(tagTicket), func(b *cryptobyte.Builder) {
+		b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			addInt(b, 0, pvno)
+			addString(b, 1, string(server.realm))
+			addPrincipalName(b, 2, server)
+			addEncryptedData(b, 3, e)
+		})
+	})
+}
+
+func addTickets(b *cryptobyte.Builder, n uint8, tickets []EncryptedData) {
+	addSequence(b, n, func(b *cryptobyte.Builder) {
+		for _, t := range tickets {
+			addTicket(b, t)
+		}
+	})
+}
+
+func addPAData(b *cryptobyte.Builder, n uint8, padata []PAData) {
+	addSequence(b, n, func(b *cryptobyte.Builder) {
+		for _, pa := range padata {
+			pa.addDER(b)
+		}
+	})
+}
+
+// addAuthorizationData encodes elements, each an ad-type and its ad-data,
+// wrapped in a single AD-IF-RELEVANT.
+func addAuthorizationData(b *cryptobyte.Builder, n uint8, elements map[int64][]byte) {
+	addElements := func(b *cryptobyte.Builder, elements map[int64][]byte, order []int64) {
+		for _, adType := range order {
+			data, ok := elements[adType]
+			if !ok {
+				continue
+			}
+			b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				addInt(b, 0, adType)
+				addOctets(b, 1, data)
+			})
+		}
+	}
+
+	inner, err := marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			addElements(b, elements, []int64{adTypeClaims, adTypeClientAddr})
+		})
+	})
+	if err != nil {
+		b.SetError(err)
+		return
+	}
+
+	addSequence(b, n, func(b *cryptobyte.Builder) {
+		addElements(b, map[int64][]byte{adTypeIfRelevant: inner}, []int64{adTypeIfRelevant})
+	})
+}
+
+func marshalDER(f cryptobyte.BuilderContinuation) ([]byte, error) {
+	b := cryptobyte.NewBuilder(nil)
+	f(b)
+	return b.Bytes()
+}
+
+// unmarshalApplication opens the application-tagged SEQUENCE of a message,
+// rejecting trailing data.
+func unmarshalApplication(data []byte, tag uint8, out *cryptobyte.String) bool {
+	input := cryptobyte.String(data)
+	var app cryptobyte.String
+	return input.ReadASN1(&app, application(tag)) && input.Empty() &&
+		app.ReadASN1(out, asn1.SEQUENCE) && app.Empty()
+}
+
+func readInt(s *cryptobyte.String, n uint8, out *int64) bool {
+	var f cryptobyte.String
+	return s.ReadASN1(&f, field(n)) && f.ReadASN1Integer(out) && f.Empty()
+}
+
+func readOptionalInt(s *cryptobyte.String, n uint8, out *int64, present *bool) bool {
+	var f cryptobyte.String
+	if !s.ReadOptionalASN1(&f, present, field(n)) {
+		return false
+	}
+	return !*present || f.ReadASN1Integer(out) && f.Empty()
+}
+
+// readVersion reads a pvno or vno field and a msg-type field, if msgType is
+// non-zero, checking both.
+func readVersion(s *cryptobyte.String, n uint8, msgType int64) bool {
+	var v, m int64
+	if !readInt(s, n, &v) || v != pvno {
+		return false
+	}
+	return msgType == 0 || readInt(s, n+1, &m) && m == msgType
+}
+
+func readOctets(s *cryptobyte.String, n uint8, out *[]byte) bool {
+	var f cryptobyte.String
+	return s.ReadASN1(&f, field(n)) && f.ReadASN1Bytes(out, asn1.OCTET_STRING) && f.Empty()
+}
+
+func readOptionalOctets(s *cryptobyte.String, n uint8, out *[]byte) bool {
+	var f cryptobyte.String
+	var present bool
+	if !s.ReadOptionalASN1(&f, &present, field(n)) {
+		return false
+	}
+	return !present || f.ReadASN1Bytes(out, asn1.OCTET_STRING) && f.Empty()
+}
+
+func readKerberosString(s *cryptobyte.String, out *string) bool {
+	var str cryptobyte.String
+	if !s.ReadASN1(&str, asn1.GeneralString) {
+		return false
+	}
+	*out = string(str)
+	return true
+}
+
+func readString(s *cryptobyte.String, n uint8, out *string) bool {
+	var f cryptobyte.String
+	return s.ReadASN1(&f, field(n)) && readKerberosString(&f, out) && f.Empty()
+}
+
+func readOptionalString(s *cryptobyte.String, n uint8, out *string) bool {
+	var f cryptobyte.String
+	var present bool
+	if !s.ReadOptionalASN1(&f, &present, field(n)) {
+		return false
+	}
+	return !present || readKerberosString(&f, out) && f.Empty()
+}
+
+func readTime(s *cryptobyte.String, n uint8, out *time.Time) bool {
+	var f, str cryptobyte.String
+	if !s.ReadASN1(&f, field(n)) || !f.ReadASN1(&str, asn1.GeneralizedTime) || !f.Empty() {
+		return false
+	}
+	t, err := time.Parse(kerberosTimeFormat, string(str))
+	if err != nil {
+		return false
+	}
+	*out = t
+	return true
+}
+
+func readOptionalTime(s *cryptobyte.String, n uint8, out *time.Time) bool {
+	if !s.PeekASN1Tag(field(n)) {
+		return true
+	}
+	return readTime(s, n, out)
+}
+
+// readFlags decodes a KerberosFlags. Senders may drop trailing zero bits, so
+// a shorter BIT STRING is padded; a longer one keeps its first 32 bits.
+func readFlags(s *cryptobyte.String, n uint8, out *uint32) bool {
+	var f cryptobyte.String
+	var bits []byte
+	if !s.ReadASN1(&f, field(n)) || !f.ReadASN1Bytes(&bits, asn1.BIT_STRING) || !f.Empty() || len(bits) == 0 {
+		return false
+	}
+	var buf [maxKerberosFlagsBits / 8]byte
+	copy(buf[:], bits[1:])
+	*out = binary.BigEndian.Uint32(buf[:])
+	return true
+}
+
+func readPrincipalName(s *cryptobyte.String, n uint8, realm Realm, out *Principal) bool {
+	var f cryptobyte.String
+	return s.ReadASN1(&f, field(n)) && parsePrincipalName(&f, realm, out) && f.Empty()
+}
+
+// parsePrincipalName decodes a PrincipalName of at most two components, the
+// primary name and the instance, in realm.
+func parsePrincipalName(s *cryptobyte.String, realm Realm, out *Principal) bool {
+	var seq, f, names cryptobyte.String
+	var nameType int64
+	if !s.ReadASN1(&seq, asn1.SEQUENCE) ||
+		!readInt(&seq, 0, &nameType) ||
+		!seq.ReadASN1(&f, field(1)) || !f.ReadASN1(&names, asn1.SEQUENCE) || !f.Empty() || !seq.Empty() {
+		return false
+	}
+
+	var components []string
+	for !names.Empty() {
+		var c string
+		if !readKerberosString(&names, &c) {
+			return false
+		}
+		components = append(components, c)
+	}
+	if len(components) == 0 || len(components) > 2 {
+		return false
+	}
+
+	var instance Instance
+	if len(components) == 2 {
+		instance = Instance(components[1])
+	}
+	p, err := NewPrincipal(Primary(components[0]), instance, realm)
+	if err != nil {
+		return false
+	}
+	*out = p
+	return true
+}
+
+func readOptionalPrincipalName(s *cryptobyte.String, n uint8, realm Realm, out *Principal) bool {
+	if !s.PeekASN1Tag(field(n)) {
+		return true
+	}
+	return readPrincipalName(s, n, realm, out)
+}
+
+// readHostAddresses keeps the first IPv4 or IPv6 address of a HostAddresses,
+// the only kinds the protocol package represents.
+func readHostAddresses(s *cryptobyte.String, n uint8, out *Address) bool {
+	var seq cryptobyte.String
+	var present bool
+	if !s.ReadOptionalASN1(&seq, &present, field(n)) {
+		return false
+	}
+	if !present {
+		return true
+	}
+	if !seq.ReadASN1(&seq, asn1.SEQUENCE) {
+		return false
+	}
+
+	for !seq.Empty() {
+		var addr Address
+		if !readHostAddress(&seq, &addr) {
+			return false
+		}
+		if out.IsZero() {
+			*out = addr
+		}
+	}
+	return true
+}
+
+func readHostAddress(s *cryptobyte.String, out *Address) bool {
+	var seq cryptobyte.String
+	var addrType int64
+	var ip []byte
+	if !s.ReadASN1(&seq, asn1.SEQUENCE) || !readInt(&seq, 0, &addrType) || !readOctets(&seq, 1, &ip) {
+		return false
+	}
+
+	switch {
+	case addrType == addrTypeIPv4 && len(ip) == net.IPv4len,
+		addrType == addrTypeIPv6 && len(ip) == net.IPv6len:
+		addr, err := NewAddress(ip)
+		if err != nil {
+			return false
+		}
+		*out = addr
+	}
+	return true
+}
+
+func readEncryptionKey(s *cryptobyte.String, n uint8, out *SessionKey) bool {
+	var seq cryptobyte.String
+	var keyType int64
+	var value []byte
+	if !s.ReadASN1(&seq, field(n)) || !seq.ReadASN1(&seq, asn1.SEQUENCE) ||
+		!readInt(&seq, 0, &keyType) || !readOctets(&seq, 1, &value) ||
+		keyType < math.MinInt32 || keyType > math.MaxInt32 {
+		return false
+	}
+	key, err := NewSessionKey(value)
+	if err != nil {
+		return false
+	}
+	*out = key.WithEncType(EncType(keyType))
+	return true
+}
+
+func readOptionalEncryptionKey(s *cryptobyte.String, n uint8, out *SessionKey) bool {
+	if !s.PeekASN1Tag(field(n)) {
+		return true
+	}
+	return readEncryptionKey(s, n, out)
+}
+
+// readETypes decodes the SEQUENCE OF Int32 listing the encryption types a
+// client supports.
+func readETypes(s *cryptobyte.String, n uint8, out *[]EncType) bool {
+	var seq cryptobyte.String
+	if !s.ReadASN1(&seq, field(n)) || !seq.ReadASN1(&seq, asn1.SEQUENCE) {
+		return false
+	}
+
+	var etypes []EncType
+	for !seq.Empty() {
+		var etype int64
+		if !seq.ReadASN1Int64WithTag(&etype, asn1.INTEGER) || etype < math.MinInt32 || etype > math.MaxInt32 {
+			return false
+		}
+		etypes = append(etypes, EncType(etype))
+	}
+	*out = etypes
+	return true
+}
+
+func readChecksum(s *cryptobyte.String, out *Checksum) bool {
+	var seq cryptobyte.String
+	var ctype int64
+	var value []byte
+	if !s.ReadASN1(&seq, asn1.SEQUENCE) || !readInt(&seq, 0, &ctype) || !readOctets(&seq, 1, &value) {
+		return false
+	}
+	c, err := NewChecksum(ChecksumType(ctype), value)
+	if err != nil {
+		return false
+	}
+	*out = c
+	return true
+}
+
+func readChecksumField(s *cryptobyte.String, n uint8, out *Checksum) bool {
+	var f cryptobyte.String
+	return s.ReadASN1(&f, field(n)) && readChecksum(&f, out) && f.Empty()
+}
+
+func readOptionalChecksum(s *cryptobyte.String, n uint8, out *Checksum) bool {
+	var f cryptobyte.String
+	var present bool
+	if !s.ReadOptionalASN1(&f, &present, field(n)) {
+		return false
+	}
+	return !present || readChecksum(&f, out) && f.Empty()
+}
+
+func readEncryptedData(s *cryptobyte.String, n uint8, out *EncryptedData) bool {
+	var f cryptobyte.String
+	return s.ReadASN1(&f, field(n)) && out.readDER(&f) && f.Empty()
+}
+
+// readTicket decodes a Ticket into the EncryptedData it carries, keeping the
+// server it names.
+func readTicket(s *cryptobyte.String, out *EncryptedData) bool {
+	var app, seq cryptobyte.String
+	var realm string
+	var server Principal
+	var enc EncryptedData
+	if !s.ReadASN1(&app, application(tagTicket)) || !app.ReadASN1(&seq, asn1.SEQUENCE) || !app.Empty() ||
+		!readVersion(&seq, 0, 0) ||
+		!readString(&seq, 1, &realm) ||
+		!readPrincipalName(&seq, 2, Realm(realm), &server) ||
+		!readEncryptedData(&seq, 3, &enc) {
+		return false
+	}
+	*out = enc.WithServer(server)
+	return true
+}
+
+func readTicketField(s *cryptobyte.String, n uint8, out *EncryptedData) bool {
+	var f cryptobyte.String
+	return s.ReadASN1(&f, field(n)) && readTicket(&f, out) && f.Empty()
+}
+
+func readOptionalTickets(s *cryptobyte.String, n uint8, out *[]EncryptedData) bool {
+	var seq cryptobyte.String
+	var present bool
+	if !s.ReadOptionalASN1(&seq, &present, field(n)) {
+		return false
+	}
+	if !present {
+		return true
+	}
+	if !seq.ReadASN1(&seq, asn1.SEQUENCE) {
+		return false
+	}
+	for !seq.Empty() {
+		var t EncryptedData
+		if !readTicket(&seq, &t) {
+			return false
+		}
+		*out = append(*out, t)
+	}
+	return true
+}
+
+func readOptionalPAData(s *cryptobyte.String, n uint8, out *[]PAData) bool {
+	var seq cryptobyte.String
+	var present bool
+	if !s.ReadOptionalASN1(&seq, &present, field(n)) {
+		return false
+	}
+	if !present {
+		return true
+	}
+	if !seq.ReadASN1(&seq, asn1.SEQUENCE) {
+		return false
+	}
+	for !seq.Empty() {
+		var pa PAData
+		if !pa.readDER(&seq) {
+			return false
+		}
+		*out = append(*out, pa)
+	}
+	return true
+}
+
+// readAuthorizationData collects the elements of our own ad-types, looking
+// inside AD-IF-RELEVANT. Elements of other types are skipped.
+func readAuthorizationData(s *cryptobyte.String, n uint8, out map[int64][]byte) bool {
+	var seq cryptobyte.String
+	var present bool
+	if !s.ReadOptionalASN1(&seq, &present, field(n)) {
+		return false
+	}
+	if !present {
+		return true
+	}
+	if !seq.ReadASN1(&seq, asn1.SEQUENCE) {
+		return false
+	}
+	return readADElements(seq, out)
+}
+
+func readADElements(seq cryptobyte.String, out map[int64][]byte) bool {
+	for !seq.Empty() {
+		var elem cryptobyte.String
+		var adType int64
+		var data []byte
+		if !seq.ReadASN1(&elem, asn1.SEQUENCE) || !readInt(&elem, 0, &adType) || !readOctets(&elem, 1, &data) {
+			return false
+		}
+
+		switch adType {
+		case adTypeIfRelevant:
+			var inner cryptobyte.String
+			input := cryptobyte.String(data)
+			if !input.ReadASN1(&inner, asn1.SEQUENCE) || !input.Empty() || !readADElements(inner, out) {
+				return false
+			}
+		case adTypeClaims, adTypeClientAddr:
+			out[adType] = data
+		}
+	}
+	return true
+}
+
+// kdcReqBody is the KDC-REQ-BODY of RFC 4120 §5.4.1, shared by the AS-REQ
+// and the TGS-REQ. Only an AS-REQ names its client.
+type kdcReqBody struct {
+	options    KDCOptions
+	client     Principal
+	server     Principal
+	from       time.Time
+	till       time.Time
+	nonce      Nonce
+	etypes     []EncType
+	clientAddr Address
+	additional []EncryptedData
+}
+
+func (r kdcReqBody) addDER(b *cryptobyte.Builder) {
+	b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		addFlags(b, 0, uint32(r.options))
+		if r.client != (Principal{}) {
+			addPrincipalName(b, 1, r.client)
+		}
+		addString(b, 2, string(r.server.realm))
+		addPrincipalName(b, 3, r.server)
+		if !r.from.IsZero() {
+			addTime(b, 4, r.from)
+		}
+		addTime(b, 5, kerberosTime(r.till))
+		addInt(b, 7, int64(uint32(r.nonce.val)))
+		addSequence(b, 8, func(b *cryptobyte.Builder) {
+			for _, etype := range r.etypes {
+				b.AddASN1Int64(int64(etype))
+			}
+		})
+		if !r.clientAddr.IsZero() {
+			addHostAddresses(b, 9, r.clientAddr)
+		}
+		if len(r.additional) > 0 {
+			addTickets(b, 11, r.additional)
+		}
+	})
+}
+
+// readDER decodes a KDC-REQ-BODY. The rtime and enc-authorization-data have
+// no counterpart in the request types and are skipped.
+func (r *kdcReqBody) readDER(s *cryptobyte.String) bool {
+	var seq, cname cryptobyte.String
+	var options uint32
+	var hasCname bool
+	var realm string
+	var rtime time.Time
+	var nonce int64
+	var body kdcReqBody
+	if !s.ReadASN1(&seq, asn1.SEQUENCE) ||
+		!readFlags(&seq, 0, &options) ||
+		!seq.ReadOptionalASN1(&cname, &hasCname, field(1)) ||
+		!readString(&seq, 2, &realm) ||
+		!readOptionalPrincipalName(&seq, 3, Realm(realm), &body.server) ||
+		!readOptionalTime(&seq, 4, &body.from) ||
+		!readTime(&seq, 5, &body.till) ||
+		!readOptionalTime(&seq, 6, &rtime) ||
+		!readInt(&seq, 7, &nonce) ||
+		!readETypes(&seq, 8, &body.etypes) ||
+		!readHostAddresses(&seq, 9, &body.clientAddr) ||
+		!seq.SkipOptionalASN1(field(10)) ||
+		!readOptionalTickets(&seq, 11, &body.additional) || !seq.Empty() ||
+		nonce < 0 || nonce > math.MaxUint32 {
+		return false
+	}
+	if hasCname && (!parsePrincipalName(&cname, Realm(realm), &body.client) || !cname.Empty()) {
+		return false
+	}
+
+	body.options = KDCOptions(options)
+	body.till = fromKerberosTime(body.till)
+	body.nonce = Nonce{val: int32(uint32(nonce))}
+	*r = body
+	return true
+}
@@ -0,0 +1,414 @@
+package protocol_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// The vectors below follow the sample values of MIT krb5's ASN.1 reference
+// encodings (src/tests/asn.1): the principal hftsai/extra@ATHENA.MIT.EDU,
+// the time 19940610060317Z and the message "krbASN.1 test message". Where
+// our messages differ from MIT's samples (the etype, a single address), the
+// expected bytes follow our encoding.
+
+var (
+	derTime    = time.Date(1994, time.June, 10, 6, 3, 17, 0, time.UTC)
+	derMessage = []byte("krbASN.1 test message")
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	assert.Err(t, err, nil)
+	return b
+}
+
+func derPrincipal(t *testing.T) protocol.Principal {
+	t.Helper()
+	p, err := protocol.NewPrincipal("hftsai", "extra", "ATHENA.MIT.EDU")
+	assert.Err(t, err, nil)
+	return p
+}
+
+func derAddress(t *testing.T) protocol.Address {
+	t.Helper()
+	addr, err := protocol.NewAddress(net.IPv4(12, 4, 0, 0))
+	assert.Err(t, err, nil)
+	return addr
+}
+
+func derEncryptedData(t *testing.T) protocol.EncryptedData {
+	t.Helper()
+	enc, err := protocol.NewEncryptedData(derMessage)
+	assert.Err(t, err, nil)
+	return enc
+}
+
+func assertDER(t *testing.T, got []byte, want string) {
+	t.Helper()
+	if !bytes.Equal(got, mustHex(t, want)) {
+		t.Fatalf("encoding mismatch\ngot:  %x\nwant: %s", got, want)
+	}
+}
+
+func TestEncryptedDataDER(t *testing.T) {
+	const want = "301ea0030201ffa21704156b726241534e2e312074657374206d657373616765"
+
+	data, err := derEncryptedData(t).MarshalDER()
+	assert.Err(t, err, nil)
+	assertDER(t, data, want)
+
+	var loaded protocol.EncryptedData
+	assert.Err(t, loaded.UnmarshalDER(data), nil)
+	assert.Equal(t, string(loaded.Ciphertext()), string(derMessage))
+}
+
+func TestEncryptedDataDER_Kvno(t *testing.T) {
+	// MIT's sample, sealed with aes256-cts-hmac-sha1-96 under key version 5
+	const want = "3023a003020112a103020105a21704156b726241534e2e312074657374206d657373616765"
+
+	data, err := derEncryptedData(t).WithEncType(18).WithKvno(5).MarshalDER()
+	assert.Err(t, err, nil)
+	assertDER(t, data, want)
+
+	var loaded protocol.EncryptedData
+	assert.Err(t, loaded.UnmarshalDER(data), nil)
+	assert.Equal(t, loaded.EncType(), protocol.EncType(18))
+	kvno, ok := loaded.Kvno()
+	assert.True(t, ok)
+	assert.Equal(t, kvno, uint32(5))
+}
+
+func TestPAEncTSEncDER(t *testing.T) {
+	const want = "301aa011180f31393934303631303036303331375aa105020301e240"
+
+	ts, err := protocol.NewPAEncTSEnc(derTime.Add(123456 * time.Microsecond))
+	assert.Err(t, err, nil)
+
+	data, err := ts.MarshalDER()
+	assert.Err(t, err, nil)
+	assertDER(t, data, want)
+
+	var loaded protocol.PAEncTSEnc
+	assert.Err(t, loaded.UnmarshalDER(data), nil)
+	assert.True(t, loaded.Timestamp().Equal(ts.Timestamp()))
+}
+
+func TestAuthenticatorDER(t *testing.T) {
+	const want = "6281be3081bba003020105a1101b0e415448454e412e4d49542e454455a21a3018a0" +
+		"03020101a111300f1b066866747361691b056578747261a30f300da0030201ffa10604" +
+		"0431323334a405020301e240a511180f31393934303631303036303331375aa62b3029" +
+		"a0030201ffa1220420313233343536373831323334353637383132333435363738313233" +
+		"3435363738a703020111a82930273025a003020101a11e041c301a3018a0030201fea1" +
+		"11040f300da003020102a10604040c040000"
+
+	cksum, err := protocol.NewChecksum(protocol.ChecksumHMACSHA256, []byte("1234"))
+	assert.Err(t, err, nil)
+	subkey, err := protocol.NewSessionKey(bytes.Repeat([]byte("12345678"), 4))
+	assert.Err(t, err, nil)
+
+	auth, err := protocol.NewAuthenticator(derPrincipal(t), derAddress(t), derTime.Add(123456*time.Microsecond))
+	assert.Err(t, err, nil)
+	auth = auth.WithChecksum(cksum).WithSubkey(subkey).WithSeqNumber(17)
+
+	data, err := auth.MarshalDER()
+	assert.Err(t, err, nil)
+	assertDER(t, data, want)
+
+	var loaded protocol.Authenticator
+	assert.Err(t, loaded.UnmarshalDER(data), nil)
+	assert.Equal(t, loaded.Client(), auth.Client())
+	assert.True(t, loaded.ClientAddr().IP().Equal(auth.ClientAddr().IP()))
+	assert.True(t, loaded.IssuedAt().Equal(auth.IssuedAt()))
+	loadedCksum, ok := loaded.Checksum()
+	assert.True(t, ok)
+	assert.Equal(t, string(loadedCksum.Value()), "1234")
+	seq, ok := loaded.SeqNumber()
+	assert.True(t, ok)
+	assert.Equal(t, seq, uint32(17))
+
+	again, err := loaded.MarshalDER()
+	assert.Err(t, err, nil)
+	assertDER(t, again, want)
+}
+
+func TestAPReqDER(t *testing.T) {
+	const want = "6e8193308190a003020105a10302010ea20703050020000000a35961573055a00302" +
+		"0105a1101b0e415448454e412e4d49542e454455a21a3018a003020101a111300f1b06" +
+		"6866747361691b056578747261a320301ea0030201ffa21704156b726241534e2e3120" +
+		"74657374206d657373616765a420301ea0030201ffa21704156b726241534e2e312074" +
+		"657374206d657373616765"
+
+	ticket := derEncryptedData(t).WithServer(derPrincipal(t))
+	req, err := protocol.NewAPReq(ticket, derEncryptedData(t))
+	assert.Err(t, err, nil)
+	req = req.WithOptions(protocol.APOptMutualRequired)
+
+	data, err := req.MarshalDER()
+	assert.Err(t, err, nil)
+	assertDER(t, data, want)
+
+	var loaded protocol.APReq
+	assert.Err(t, loaded.UnmarshalDER(data), nil)
+	assert.True(t, loaded.Options().Has(protocol.APOptMutualRequired))
+	server, ok := loaded.Ticket().Server()
+	assert.True(t, ok)
+	assert.Equal(t, server, derPrincipal(t))
+}
+
+func TestEncAPRepPartDER(t *testing.T) {
+	const want = "7b4e304ca011180f31393934303631303036303331375aa105020301e240a22b3029" +
+		"a0030201ffa12204203132333435363738313233343536373831323334353637383132" +
+		"333435363738a303020111"
+
+	subkey, err := protocol.NewSessionKey(bytes.Repeat([]byte("12345678"), 4))
+	assert.Err(t, err, nil)
+	part, err := protocol.NewEncAPRepPart(derTime.Add(123456 * time.Microsecond))
+	assert.Err(t, err, nil)
+
+	data, err := part.WithSubkey(subkey).WithSeqNumber(17).MarshalDER()
+	assert.Err(t, err, nil)
+	assertDER(t, data, want)
+
+	var loaded protocol.EncAPRepPart
+	assert.Err(t, loaded.UnmarshalDER(data), nil)
+	assert.True(t, loaded.IssuedAt().Equal(derTime.Add(123456*time.Microsecond)))
+	loadedKey, ok := loaded.Subkey()
+	assert.True(t, ok)
+	assert.Equal(t, loadedKey.Expose(), subkey.Expose())
+	seq, ok := loaded.SeqNumber()
+	assert.True(t, ok)
+	assert.Equal(t, seq, 17)
+}
+
+func TestAPRepDER(t *testing.T) {
+	const want = "6f2e302ca003020105a10302010fa220301ea0030201ffa21704156b726241534e2e" +
+		"312074657374206d657373616765"
+
+	rep, err := protocol.NewAPRep(derEncryptedData(t))
+	assert.Err(t, err, nil)
+
+	data, err := rep.MarshalDER()
+	assert.Err(t, err, nil)
+	assertDER(t, data, want)
+
+	var loaded protocol.APRep
+	assert.Err(t, loaded.UnmarshalDER(data), nil)
+	assert.Equal(t, loaded.EncPart().Ciphertext(), derEncryptedData(t).Ciphertext())
+}
+
+func TestASReqDER(t *testing.T) {
+	const want = "6a81b73081b4a103020105a20302010aa31430123010a103020102a209040770612d" +
+		"64617461a4819130818ea00703050040800000a11a3018a003020101a111300f1b0668" +
+		"66747361691b056578747261a2101b0e415448454e412e4d49542e454455a3233021a0" +
+		"03020102a11a30181b066b72627467741b0e415448454e412e4d49542e454455a51118" +
+		"0f31393934303631303036303331375aa70302012aa80530030201ffa911300f300da0" +
+		"03020102a10604040c040000"
+
+	krbtgt, err := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	assert.Err(t, err, nil)
+	nonce, err := protocol.NewNonce(42)
+	assert.Err(t, err, nil)
+	pa, err := protocol.NewPAData(protocol.PATypeEncTimestamp, []byte("pa-data"))
+	assert.Err(t, err, nil)
+
+	req, err := protocol.NewASReq(derPrincipal(t), krbtgt, derAddress(t), nonce)
+	assert.Err(t, err, nil)
+	req = req.WithPAData(pa).
+		WithOptions(protocol.OptForwardable|protocol.OptRenewable).
+		WithTimes(time.Time{}, derTime)
+
+	data, err := req.MarshalDER()
+	assert.Err(t, err, nil)
+	assertDER(t, data, want)
+
+	var loaded protocol.ASReq
+	assert.Err(t, loaded.UnmarshalDER(data), nil)
+	assert.Equal(t, loaded.Client(), req.Client())
+	assert.Equal(t, loaded.Service(), req.Service())
+	assert.Equal(t, loaded.Nonce(), req.Nonce())
+	assert.Equal(t, loaded.Options(), req.Options())
+	assert.True(t, loaded.From().IsZero())
+	assert.True(t, loaded.Till().Equal(derTime))
+	assert.Equal(t, len(loaded.PAData()), 1)
+
+	again, err := loaded.MarshalDER()
+	assert.Err(t, err, nil)
+	assertDER(t, again, want)
+}
+
+func TestTGSReqDER(t *testing.T) {
+	krbtgt, err := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	assert.Err(t, err, nil)
+	nonce, err := protocol.NewNonce(-7)
+	assert.Err(t, err, nil)
+
+	tgt := derEncryptedData(t).WithServer(krbtgt)
+	req, err := protocol.NewTGSReq(derPrincipal(t), tgt, derEncryptedData(t), nonce)
+	assert.Err(t, err, nil)
+	req = req.WithClientAddr(derAddress(t)).
+		WithTimes(derTime, derTime.Add(time.Hour)).
+		WithETypes(18, protocol.EncTypeAES256GCM)
+
+	data, err := req.MarshalDER()
+	assert.Err(t, err, nil)
+
+	var loaded protocol.TGSReq
+	assert.Err(t, loaded.UnmarshalDER(data), nil)
+	assert.Equal(t, loaded.Server(), req.Server())
+	assert.Equal(t, loaded.Nonce(), req.Nonce())
+	assert.True(t, loaded.From().Equal(derTime))
+	assert.True(t, loaded.ClientAddr().IP().Equal(derAddress(t).IP()))
+	assert.Equal(t, loaded.ETypes(), []protocol.EncType{18, protocol.EncTypeAES256GCM})
+	server, ok := loaded.TGT().Server()
+	assert.True(t, ok)
+	assert.Equal(t, server, krbtgt)
+
+	// The checksummed body is the one received, not a re-encoding of it.
+	want, err := req.BodyDER()
+	assert.Err(t, err, nil)
+	got, err := loaded.BodyDER()
+	assert.Err(t, err, nil)
+	assert.True(t, bytes.Equal(got, want))
+}
+
+func TestTicketDER(t *testing.T) {
+	key, err := protocol.NewSessionKey(bytes.Repeat([]byte("12345678"), 4))
+	assert.Err(t, err, nil)
+	krbtgt, err := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	assert.Err(t, err, nil)
+
+	ticket, err := protocol.NewTicket(krbtgt, derPrincipal(t), derAddress(t), derTime, 8*time.Hour, key)
+	assert.Err(t, err, nil)
+	ticket = ticket.
+		WithFlags(protocol.FlagForwardable).
+		WithRenewTill(derTime.Add(24*time.Hour)).
+		WithTransited("EXAMPLE.COM", "EXAMPLE.ORG")
+
+	data, err := ticket.MarshalDER()
+	assert.Err(t, err, nil)
+
+	var loaded protocol.Ticket
+	assert.Err(t, loaded.UnmarshalDER(data), nil)
+	loaded = loaded.WithServer(krbtgt)
+
+	assert.Equal(t, loaded.Server(), ticket.Server())
+	assert.Equal(t, loaded.Client(), ticket.Client())
+	assert.True(t, loaded.IssuedAt().Equal(ticket.IssuedAt()))
+	assert.Equal(t, loaded.Lifetime(), ticket.Lifetime())
+	assert.Equal(t, loaded.Flags(), ticket.Flags())
+	assert.True(t, loaded.RenewTill().Equal(ticket.RenewTill()))
+	assert.Equal(t, len(loaded.Transited()), 2)
+	assert.True(t, bytes.Equal(loaded.SessionKey().Expose(), key.Expose()))
+}
+
+func TestKRBErrorDER(t *testing.T) {
+	e, err := protocol.NewKRBError(protocol.KDCErrPreauthRequired, derTime, "ATHENA.MIT.EDU", "pre-auth")
+	assert.Err(t, err, nil)
+	info, err := protocol.ETypeInfo2{
+		protocol.NewETypeInfo2Entry(protocol.EncTypeAES256CTSHMACSHA196).
+			WithSalt("ATHENA.MIT.EDUhftsai").
+			WithS2KParams([]byte{0, 0, 0x10, 0}),
+	}.MarshalDER()
+	assert.Err(t, err, nil)
+	pa, err := protocol.NewPAData(protocol.PATypeETypeInfo2, info)
+	assert.Err(t, err, nil)
+	eData, err := protocol.MethodData{pa}.MarshalDER()
+	assert.Err(t, err, nil)
+	e = e.WithClient(derPrincipal(t)).WithEData(eData)
+
+	data, err := e.MarshalDER()
+	assert.Err(t, err, nil)
+
+	var loaded protocol.KRBError
+	assert.Err(t, loaded.UnmarshalDER(data), nil)
+	assert.Equal(t, loaded.Code(), protocol.KDCErrPreauthRequired)
+	assert.Equal(t, loaded.Client(), derPrincipal(t))
+	assert.Equal(t, loaded.Text(), "pre-auth")
+	assert.True(t, loaded.ServerTime().Equal(derTime))
+
+	methodData, err := loaded.MethodData()
+	assert.Err(t, err, nil)
+	got, ok, err := methodData.ETypeInfo2()
+	assert.Err(t, err, nil)
+	assert.True(t, ok)
+	assert.Equal(t, len(got), 1)
+	assert.Equal(t, got[0].EncType(), protocol.EncTypeAES256CTSHMACSHA196)
+	salt, ok := got[0].Salt()
+	assert.True(t, ok)
+	assert.Equal(t, salt, "ATHENA.MIT.EDUhftsai")
+	assert.Equal(t, got[0].S2KParams(), []byte{0, 0, 0x10, 0})
+}
+
+func TestKDCRepDER(t *testing.T) {
+	key, err := protocol.NewSessionKey(bytes.Repeat([]byte("12345678"), 4))
+	assert.Err(t, err, nil)
+	nonce, err := protocol.NewNonce(42)
+	assert.Err(t, err, nil)
+	krbtgt, err := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	assert.Err(t, err, nil)
+
+	part, err := protocol.NewEncKDCRepPart(key, nonce, derTime, 8*time.Hour, krbtgt)
+	assert.Err(t, err, nil)
+
+	data, err := part.MarshalDER()
+	assert.Err(t, err, nil)
+
+	var loadedPart protocol.EncKDCRepPart
+	assert.Err(t, loadedPart.UnmarshalDER(data), nil)
+	assert.Equal(t, loadedPart.Nonce(), nonce)
+	assert.Equal(t, loadedPart.Server(), krbtgt)
+	assert.Equal(t, loadedPart.Lifetime(), 8*time.Hour)
+	assert.True(t, loadedPart.IssuedAt().Equal(derTime))
+
+	rep, err := protocol.NewASRep(derEncryptedData(t).WithServer(krbtgt), derEncryptedData(t))
+	assert.Err(t, err, nil)
+
+	// A reply names its client.
+	_, err = rep.MarshalDER()
+	assert.Err(t, err, protocol.ErrInvalidPrincipal)
+
+	pa, err := protocol.NewPAData(protocol.PATypeETypeInfo2, []byte{0x30, 0x00})
+	assert.Err(t, err, nil)
+	data, err = rep.WithClient(derPrincipal(t)).WithPAData(pa).MarshalDER()
+	assert.Err(t, err, nil)
+
+	var loaded protocol.ASRep
+	assert.Err(t, loaded.UnmarshalDER(data), nil)
+	assert.Equal(t, loaded.Client(), derPrincipal(t))
+	_, ok := loaded.PAData().Find(protocol.PATypeETypeInfo2)
+	assert.True(t, ok)
+	server, ok := loaded.Ticket().Server()
+	assert.True(t, ok)
+	assert.Equal(t, server, krbtgt)
+}
+
+func TestDERErrors(t *testing.T) {
+	// A ticket without a server cannot be encoded.
+	req, err := protocol.NewAPReq(derEncryptedData(t), derEncryptedData(t))
+	assert.Err(t, err, nil)
+	_, err = req.MarshalDER()
+	assert.Err(t, err, protocol.ErrTicketNoServer)
+
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"truncated": mustHex(t, "301ea0030201ffa217"),
+		"trailing":  mustHex(t, "301ea0030201ffa21704156b726241534e2e312074657374206d65737361676500"),
+		"wrong tag": mustHex(t, "6e00"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var enc protocol.EncryptedData
+			err := enc.UnmarshalDER(data)
+			if !errors.Is(err, protocol.ErrMalformedDER) {
+				t.Fatalf("got %v, want %v", err, protocol.ErrMalformedDER)
+			}
+		})
+	}
+}
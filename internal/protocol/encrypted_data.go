@@ -3,14 +3,66 @@ package protocol
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
 )
 
 var (
 	ErrInvalidCiphertext = errors.New("ciphertext cannot be empty")
 )
 
+// EncType identifies the encryption type of a key and of the data sealed
+// under it (RFC 3961 §8).
+type EncType int32
+
+const (
+	// EncTypeAES256GCM is AES-256 in GCM mode. It has no RFC 3961 number,
+	// so, like ChecksumHMACSHA256, it takes one from the range reserved for
+	// local use.
+	EncTypeAES256GCM EncType = -1
+
+	// The AES encryption types of RFC 3962 and RFC 8009.
+	EncTypeAES128CTSHMACSHA196    EncType = 17
+	EncTypeAES256CTSHMACSHA196    EncType = 18
+	EncTypeAES128CTSHMACSHA256128 EncType = 19
+	EncTypeAES256CTSHMACSHA384192 EncType = 20
+)
+
+func (e EncType) String() string {
+	switch e {
+	case EncTypeAES256GCM:
+		return "aes256-gcm"
+	case EncTypeAES128CTSHMACSHA196:
+		return "aes128-cts-hmac-sha1-96"
+	case EncTypeAES256CTSHMACSHA196:
+		return "aes256-cts-hmac-sha1-96"
+	case EncTypeAES128CTSHMACSHA256128:
+		return "aes128-cts-hmac-sha256-128"
+	case EncTypeAES256CTSHMACSHA384192:
+		return "aes256-cts-hmac-sha384-192"
+	default:
+		return fmt.Sprintf("etype(%d)", int32(e))
+	}
+}
+
+// defaultETypes is the list of encryption types a request supports when it
+// names none.
+func defaultETypes(etypes []EncType) []EncType {
+	if len(etypes) == 0 {
+		return []EncType{EncTypeAES256GCM}
+	}
+	return append([]EncType(nil), etypes...)
+}
+
 type EncryptedData struct {
+	etype      EncType
+	kvno       uint32
+	hasKvno    bool
 	ciphertext []byte
+	server     Principal
 }
 
 func NewEncryptedData(ciphertext []byte) (EncryptedData, error) {
@@ -20,25 +72,65 @@ func NewEncryptedData(ciphertext []byte) (EncryptedData, error) {
 
 	c := make([]byte, len(ciphertext))
 	copy(c, ciphertext)
-	return EncryptedData{ciphertext: c}, nil
+
+	return EncryptedData{etype: EncTypeAES256GCM, ciphertext: c}, nil
 }
 
+// EncType is the encryption type e was sealed with.
+func (e EncryptedData) EncType() EncType { return e.etype }
+
+// Kvno is the version of the long-term key e was sealed under. Data sealed
+// under a session key has none.
+func (e EncryptedData) Kvno() (uint32, bool) { return e.kvno, e.hasKvno }
+
 func (e EncryptedData) Ciphertext() []byte {
 	c := make([]byte, len(e.ciphertext))
 	copy(c, e.ciphertext)
 	return c
 }
 
+// Server returns the server of the ticket e seals, if it is known. A DER
+// Ticket names its server in the clear, since the encrypted part does not.
+func (e EncryptedData) Server() (Principal, bool) {
+	return e.server, e.server != (Principal{})
+}
+
+// WithEncType returns a copy of e sealed with etype.
+func (e EncryptedData) WithEncType(etype EncType) EncryptedData {
+	e.etype = etype
+	return e
+}
+
+// WithKvno returns a copy of e sealed under version kvno of a long-term key.
+func (e EncryptedData) WithKvno(kvno uint32) EncryptedData {
+	e.kvno, e.hasKvno = kvno, true
+	return e
+}
+
+// WithServer returns a copy of e sealing a ticket for server.
+func (e EncryptedData) WithServer(server Principal) EncryptedData {
+	e.server = server
+	return e
+}
+
 type encryptedData struct {
-	Ciphertext []byte `json:"ciphertext"`
+	EType      EncType `json:"etype"`
+	Kvno       *uint32 `json:"kvno,omitempty"`
+	Ciphertext []byte  `json:"ciphertext"`
 }
 
 func (e EncryptedData) MarshalJSON() ([]byte, error) {
-	return json.Marshal(encryptedData{Ciphertext: e.ciphertext})
+	tmp := encryptedData{EType: e.etype, Ciphertext: e.ciphertext}
+	if e.hasKvno {
+		tmp.Kvno = &e.kvno
+	}
+	return json.Marshal(tmp)
 }
 
+// UnmarshalJSON decodes EncryptedData. Data without an etype was sealed
+// before it was recorded, when AES-256-GCM was the only one.
 func (e *EncryptedData) UnmarshalJSON(data []byte) error {
-	var tmp encryptedData
+	tmp := encryptedData{EType: EncTypeAES256GCM}
 	if err := json.Unmarshal(data, &tmp); err != nil {
 		return err
 	}
@@ -48,6 +140,73 @@ func (e *EncryptedData) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	enc = enc.WithEncType(tmp.EType)
+	if tmp.Kvno != nil {
+		enc = enc.WithKvno(*tmp.Kvno)
+	}
+
+	*e = enc
+	return nil
+}
+
+func (e EncryptedData) addDER(b *cryptobyte.Builder) {
+	b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		addInt(b, 0, int64(e.etype))
+		if e.hasKvno {
+			addInt(b, 1, int64(e.kvno))
+		}
+		addOctets(b, 2, e.ciphertext)
+	})
+}
+
+func (e *EncryptedData) readDER(s *cryptobyte.String) bool {
+	var seq cryptobyte.String
+	var etype, kvno int64
+	var hasKvno bool
+	var ciphertext []byte
+	if !s.ReadASN1(&seq, asn1.SEQUENCE) ||
+		!readInt(&seq, 0, &etype) ||
+		!readOptionalInt(&seq, 1, &kvno, &hasKvno) ||
+		!readOctets(&seq, 2, &ciphertext) || !seq.Empty() ||
+		etype < math.MinInt32 || etype > math.MaxInt32 || kvno < 0 || kvno > math.MaxUint32 {
+		return false
+	}
+
+	enc, err := NewEncryptedData(ciphertext)
+	if err != nil {
+		return false
+	}
+	enc = enc.WithEncType(EncType(etype))
+	if hasKvno {
+		enc = enc.WithKvno(uint32(kvno))
+	}
 	*e = enc
+	return true
+}
+
+// MarshalDER encodes e as the EncryptedData of RFC 4120 §5.2.9.
+func (e EncryptedData) MarshalDER() ([]byte, error) {
+	return marshalDER(e.addDER)
+}
+
+func (e *EncryptedData) UnmarshalDER(data []byte) error {
+	s := cryptobyte.String(data)
+	if !e.readDER(&s) || !s.Empty() {
+		return malformed("EncryptedData")
+	}
+	return nil
+}
+
+// MarshalTicketDER encodes e as the Ticket it seals (RFC 4120 §5.3), which
+// names its server in the clear, as credential caches keep it.
+func (e EncryptedData) MarshalTicketDER() ([]byte, error) {
+	return marshalDER(func(b *cryptobyte.Builder) { addTicket(b, e) })
+}
+
+func (e *EncryptedData) UnmarshalTicketDER(data []byte) error {
+	s := cryptobyte.String(data)
+	if !readTicket(&s, e) || !s.Empty() {
+		return malformed("Ticket")
+	}
 	return nil
 }
@@ -32,5 +32,30 @@ func TestEncryptedData(t *testing.T) {
 		assert.Err(t, err, nil)
 
 		assert.Equal(t, string(decoded.Ciphertext()), string(original.Ciphertext()))
+		assert.Equal(t, decoded.EncType(), protocol.EncTypeAES256GCM)
+		_, ok := decoded.Kvno()
+		assert.True(t, !ok)
+	})
+
+	t.Run("EncType and Kvno", func(t *testing.T) {
+		original, err := protocol.NewEncryptedData([]byte("secret"))
+		assert.Err(t, err, nil)
+
+		data, err := json.Marshal(original.WithEncType(18).WithKvno(3))
+		assert.Err(t, err, nil)
+
+		var decoded protocol.EncryptedData
+		assert.Err(t, json.Unmarshal(data, &decoded), nil)
+		assert.Equal(t, decoded.EncType(), protocol.EncType(18))
+		kvno, ok := decoded.Kvno()
+		assert.True(t, ok)
+		assert.Equal(t, kvno, uint32(3))
+	})
+
+	t.Run("Without EncType", func(t *testing.T) {
+		// Sealed before the etype was recorded
+		var decoded protocol.EncryptedData
+		assert.Err(t, json.Unmarshal([]byte(`{"ciphertext":"c2VjcmV0"}`), &decoded), nil)
+		assert.Equal(t, decoded.EncType(), protocol.EncTypeAES256GCM)
 	})
 }
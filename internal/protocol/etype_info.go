@@ -0,0 +1,128 @@
+package protocol
+
+import (
+	"encoding/json"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// ETypeInfo2Entry tells the client how to derive one of its keys from its
+// password: the encryption type, the salt and the string-to-key parameters
+// (RFC 4120 §5.2.7.5). An entry without a salt uses the principal's default
+// salt; one without parameters uses the type's default.
+type ETypeInfo2Entry struct {
+	etype     EncType
+	salt      string
+	hasSalt   bool
+	s2kparams []byte
+}
+
+func NewETypeInfo2Entry(etype EncType) ETypeInfo2Entry {
+	return ETypeInfo2Entry{etype: etype}
+}
+
+func (e ETypeInfo2Entry) EncType() EncType { return e.etype }
+
+func (e ETypeInfo2Entry) Salt() (string, bool) { return e.salt, e.hasSalt }
+
+func (e ETypeInfo2Entry) S2KParams() []byte { return append([]byte(nil), e.s2kparams...) }
+
+// WithSalt returns a copy of e naming the salt.
+func (e ETypeInfo2Entry) WithSalt(salt string) ETypeInfo2Entry {
+	e.salt, e.hasSalt = salt, true
+	return e
+}
+
+// WithS2KParams returns a copy of e carrying string-to-key parameters.
+func (e ETypeInfo2Entry) WithS2KParams(params []byte) ETypeInfo2Entry {
+	e.s2kparams = append([]byte(nil), params...)
+	if len(params) == 0 {
+		e.s2kparams = nil
+	}
+	return e
+}
+
+type etypeInfo2Entry struct {
+	EType     EncType `json:"etype"`
+	Salt      *string `json:"salt,omitempty"`
+	S2KParams []byte  `json:"s2kparams,omitempty"`
+}
+
+func (e ETypeInfo2Entry) MarshalJSON() ([]byte, error) {
+	tmp := etypeInfo2Entry{EType: e.etype, S2KParams: e.s2kparams}
+	if e.hasSalt {
+		tmp.Salt = &e.salt
+	}
+	return json.Marshal(tmp)
+}
+
+func (e *ETypeInfo2Entry) UnmarshalJSON(data []byte) error {
+	var tmp etypeInfo2Entry
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	entry := NewETypeInfo2Entry(tmp.EType).WithS2KParams(tmp.S2KParams)
+	if tmp.Salt != nil {
+		entry = entry.WithSalt(*tmp.Salt)
+	}
+
+	*e = entry
+	return nil
+}
+
+// ETypeInfo2 is the value of a PA-ETYPE-INFO2, one entry per key the client
+// may use, preferred first.
+type ETypeInfo2 []ETypeInfo2Entry
+
+// MarshalDER encodes i as the ETYPE-INFO2 of RFC 4120 §5.2.7.5.
+func (i ETypeInfo2) MarshalDER() ([]byte, error) {
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			for _, e := range i {
+				b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					addInt(b, 0, int64(e.etype))
+					if e.hasSalt {
+						addString(b, 1, e.salt)
+					}
+					if len(e.s2kparams) > 0 {
+						addOctets(b, 2, e.s2kparams)
+					}
+				})
+			}
+		})
+	})
+}
+
+func (i *ETypeInfo2) UnmarshalDER(data []byte) error {
+	input := cryptobyte.String(data)
+	var seq cryptobyte.String
+	if !input.ReadASN1(&seq, asn1.SEQUENCE) || !input.Empty() {
+		return malformed("ETYPE-INFO2")
+	}
+
+	var info ETypeInfo2
+	for !seq.Empty() {
+		var entry cryptobyte.String
+		var etype int64
+		var salt string
+		var params []byte
+		if !seq.ReadASN1(&entry, asn1.SEQUENCE) || !readInt(&entry, 0, &etype) {
+			return malformed("ETYPE-INFO2-ENTRY")
+		}
+		hasSalt := entry.PeekASN1Tag(field(1))
+		if !readOptionalString(&entry, 1, &salt) || !readOptionalOctets(&entry, 2, &params) || !entry.Empty() {
+			return malformed("ETYPE-INFO2-ENTRY")
+		}
+
+		e := NewETypeInfo2Entry(EncType(etype)).WithS2KParams(params)
+		if hasSalt {
+			e = e.WithSalt(salt)
+		}
+		info = append(info, e)
+	}
+
+	*i = info
+	return nil
+}
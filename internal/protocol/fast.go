@@ -0,0 +1,653 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// This file holds the messages of FAST, the flexible authentication secure
+// tunneling of RFC 6113. The client wraps its request in an armor key it
+// shares with the KDC, from a ticket it already holds, and the KDC protects
+// its reply, or its error, under the same key.
+
+var ErrFastArmorInvalidType = errors.New("fast armor type must be non-zero")
+
+// FastArmorType identifies how a FastArmor establishes the armor key.
+type FastArmorType int32
+
+// FastArmorAPRequest armors with an AP-REQ, whose ticket session key and
+// authenticator subkey make the armor key (RFC 6113 §5.4.1.1).
+const FastArmorAPRequest FastArmorType = 1
+
+// FastOptions is the fast-options bit string of a KrbFastReq (RFC 6113
+// §5.4.2). Options 0 to 15 are critical: a KDC that does not know one must
+// refuse the request.
+type FastOptions uint32
+
+const (
+	FastOptHideClientNames    FastOptions = 1 << (31 - 1)
+	FastOptKDCFollowReferrals FastOptions = 1 << (31 - 16)
+)
+
+// fastOptCritical masks the critical options.
+const fastOptCritical FastOptions = 0xffff0000
+
+func (o FastOptions) Has(opt FastOptions) bool { return o&opt == opt }
+
+// Critical is the subset of o the KDC must refuse the request over unless
+// it supports them.
+func (o FastOptions) Critical() FastOptions { return o & fastOptCritical }
+
+// FastArmor is the KrbFastArmor of a request: the message that establishes
+// the armor key, encoded as the request is.
+type FastArmor struct {
+	typ   FastArmorType
+	value []byte
+}
+
+func NewFastArmor(typ FastArmorType, value []byte) (FastArmor, error) {
+	if typ == 0 {
+		return FastArmor{}, ErrFastArmorInvalidType
+	}
+
+	return FastArmor{typ: typ, value: append([]byte(nil), value...)}, nil
+}
+
+func (a FastArmor) Type() FastArmorType { return a.typ }
+func (a FastArmor) Value() []byte       { return append([]byte(nil), a.value...) }
+
+type fastArmor struct {
+	Type  FastArmorType `json:"armor_type"`
+	Value []byte        `json:"armor_value"`
+}
+
+func (a FastArmor) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fastArmor{Type: a.typ, Value: a.value})
+}
+
+func (a *FastArmor) UnmarshalJSON(data []byte) error {
+	var tmp fastArmor
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	armor, err := NewFastArmor(tmp.Type, tmp.Value)
+	if err != nil {
+		return err
+	}
+
+	*a = armor
+	return nil
+}
+
+func (a FastArmor) addDER(b *cryptobyte.Builder) {
+	b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		addInt(b, 0, int64(a.typ))
+		addOctets(b, 1, a.value)
+	})
+}
+
+func (a *FastArmor) readDER(s *cryptobyte.String) bool {
+	var seq cryptobyte.String
+	var typ int64
+	var value []byte
+	if !s.ReadASN1(&seq, asn1.SEQUENCE) || !readInt(&seq, 0, &typ) || !readOctets(&seq, 1, &value) || !seq.Empty() ||
+		typ < math.MinInt32 || typ > math.MaxInt32 {
+		return false
+	}
+
+	armor, err := NewFastArmor(FastArmorType(typ), value)
+	if err != nil {
+		return false
+	}
+	*a = armor
+	return true
+}
+
+// FastArmoredReq is the value of a PA-FX-FAST in a request: the armor, a
+// checksum under the armor key binding the clear part of the request, and
+// the sealed FastReq. A TGS-REQ carries no armor, as its TGT provides the
+// armor key.
+type FastArmoredReq struct {
+	armor      *FastArmor
+	checksum   Checksum
+	encFastReq EncryptedData
+}
+
+func NewFastArmoredReq(checksum Checksum, encFastReq EncryptedData) (FastArmoredReq, error) {
+	if checksum.IsZero() {
+		return FastArmoredReq{}, ErrChecksumEmpty
+	}
+
+	return FastArmoredReq{checksum: checksum, encFastReq: encFastReq}, nil
+}
+
+// Armor is the armor the request carries, if any.
+func (r FastArmoredReq) Armor() (FastArmor, bool) {
+	if r.armor == nil {
+		return FastArmor{}, false
+	}
+	return *r.armor, true
+}
+
+func (r FastArmoredReq) Checksum() Checksum        { return r.checksum }
+func (r FastArmoredReq) EncFastReq() EncryptedData { return r.encFastReq }
+
+// WithArmor returns a copy of the request carrying armor.
+func (r FastArmoredReq) WithArmor(armor FastArmor) FastArmoredReq {
+	r.armor = &armor
+	return r
+}
+
+type fastArmoredReq struct {
+	Armor      *FastArmor    `json:"armor,omitempty"`
+	Checksum   Checksum      `json:"req_checksum"`
+	EncFastReq EncryptedData `json:"enc_fast_req"`
+}
+
+func (r FastArmoredReq) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fastArmoredReq{Armor: r.armor, Checksum: r.checksum, EncFastReq: r.encFastReq})
+}
+
+func (r *FastArmoredReq) UnmarshalJSON(data []byte) error {
+	var tmp fastArmoredReq
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	req, err := NewFastArmoredReq(tmp.Checksum, tmp.EncFastReq)
+	if err != nil {
+		return err
+	}
+	if tmp.Armor != nil {
+		req = req.WithArmor(*tmp.Armor)
+	}
+
+	*r = req
+	return nil
+}
+
+// MarshalDER encodes r as the PA-FX-FAST-REQUEST of RFC 6113 §5.4.2, whose
+// only choice is the armored data.
+func (r FastArmoredReq) MarshalDER() ([]byte, error) {
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(field(0), func(b *cryptobyte.Builder) {
+			b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				if r.armor != nil {
+					b.AddASN1(field(0), r.armor.addDER)
+				}
+				addChecksum(b, 1, r.checksum)
+				addEncryptedData(b, 2, r.encFastReq)
+			})
+		})
+	})
+}
+
+func (r *FastArmoredReq) UnmarshalDER(data []byte) error {
+	input := cryptobyte.String(data)
+	var choice, seq, f cryptobyte.String
+	var hasArmor bool
+	var armor FastArmor
+	var checksum Checksum
+	var enc EncryptedData
+	if !input.ReadASN1(&choice, field(0)) || !input.Empty() ||
+		!choice.ReadASN1(&seq, asn1.SEQUENCE) || !choice.Empty() ||
+		!seq.ReadOptionalASN1(&f, &hasArmor, field(0)) ||
+		hasArmor && (!armor.readDER(&f) || !f.Empty()) ||
+		!readChecksumField(&seq, 1, &checksum) ||
+		!readEncryptedData(&seq, 2, &enc) || !seq.Empty() {
+		return malformed("PA-FX-FAST-REQUEST")
+	}
+
+	req, err := NewFastArmoredReq(checksum, enc)
+	if err != nil {
+		return err
+	}
+	if hasArmor {
+		req = req.WithArmor(armor)
+	}
+
+	*r = req
+	return nil
+}
+
+// FastReq is the KrbFastReq sealed in a FastArmoredReq: the request's
+// pre-authentication data and body, which the KDC uses in place of those
+// sent in the clear.
+type FastReq struct {
+	options FastOptions
+	padata  []PAData
+	body    kdcReqBody
+}
+
+// NewASFastReq is the FastReq that carries req.
+func NewASFastReq(req ASReq) FastReq {
+	return FastReq{padata: append([]PAData(nil), req.padata...), body: req.reqBody()}
+}
+
+// NewTGSFastReq is the FastReq that carries req. The TGT and authenticator
+// stay in the clear, as the armor key is made from them.
+func NewTGSFastReq(req TGSReq) FastReq {
+	return FastReq{padata: append([]PAData(nil), req.padata...), body: req.reqBody()}
+}
+
+func (r FastReq) Options() FastOptions { return r.options }
+func (r FastReq) PAData() MethodData   { return r.padata }
+
+// WithOptions returns a copy of the request asking for the given FAST
+// options.
+func (r FastReq) WithOptions(options FastOptions) FastReq {
+	r.options = options
+	return r
+}
+
+// ASReq is the AS-REQ the FastReq carries.
+func (r FastReq) ASReq() (ASReq, error) {
+	req, err := NewASReq(r.body.client, r.body.server, r.body.clientAddr, r.body.nonce)
+	if err != nil {
+		return ASReq{}, err
+	}
+
+	return req.
+		WithPAData(r.padata...).
+		WithOptions(r.body.options).
+		WithTimes(r.body.from, r.body.till).
+		WithETypes(r.body.etypes...), nil
+}
+
+// TGSReq is the TGS-REQ the FastReq carries, presenting the TGT and
+// authenticator of outer, the request it arrived in.
+func (r FastReq) TGSReq(outer TGSReq) (TGSReq, error) {
+	req, err := NewTGSReq(r.body.server, outer.tgt, outer.authenticator, r.body.nonce)
+	if err != nil {
+		return TGSReq{}, err
+	}
+
+	req = req.
+		WithOptions(r.body.options).
+		WithTimes(r.body.from, r.body.till).
+		WithPAData(r.padata...).
+		WithAdditionalTickets(r.body.additional...).
+		WithTGTRealm(outer.tgtRealm).
+		WithETypes(r.body.etypes...)
+	if !r.body.clientAddr.IsZero() {
+		req = req.WithClientAddr(r.body.clientAddr)
+	}
+	return req, nil
+}
+
+type fastReqBody struct {
+	Options    KDCOptions      `json:"kdc_options,omitempty"`
+	Client     *Principal      `json:"client,omitempty"`
+	Server     Principal       `json:"server"`
+	From       *time.Time      `json:"from,omitempty"`
+	Till       *time.Time      `json:"till,omitempty"`
+	Nonce      Nonce           `json:"nonce"`
+	ETypes     []EncType       `json:"etypes,omitempty"`
+	ClientAddr *Address        `json:"client_addr,omitempty"`
+	Additional []EncryptedData `json:"additional_tickets,omitempty"`
+}
+
+type fastReq struct {
+	Options FastOptions `json:"fast_options,omitempty"`
+	PAData  []PAData    `json:"padata,omitempty"`
+	Body    fastReqBody `json:"req_body"`
+}
+
+func (r FastReq) MarshalJSON() ([]byte, error) {
+	body := fastReqBody{
+		Options:    r.body.options,
+		Server:     r.body.server,
+		From:       optionalTime(r.body.from),
+		Till:       optionalTime(r.body.till),
+		Nonce:      r.body.nonce,
+		ETypes:     r.body.etypes,
+		Additional: r.body.additional,
+	}
+	if r.body.client != (Principal{}) {
+		body.Client = &r.body.client
+	}
+	if !r.body.clientAddr.IsZero() {
+		body.ClientAddr = &r.body.clientAddr
+	}
+
+	return json.Marshal(fastReq{Options: r.options, PAData: r.padata, Body: body})
+}
+
+func (r *FastReq) UnmarshalJSON(data []byte) error {
+	var tmp fastReq
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	body := kdcReqBody{
+		options:    tmp.Body.Options,
+		server:     tmp.Body.Server,
+		from:       fromOptional(tmp.Body.From),
+		till:       fromOptional(tmp.Body.Till),
+		nonce:      tmp.Body.Nonce,
+		etypes:     tmp.Body.ETypes,
+		additional: tmp.Body.Additional,
+	}
+	if tmp.Body.Client != nil {
+		body.client = *tmp.Body.Client
+	}
+	if tmp.Body.ClientAddr != nil {
+		body.clientAddr = *tmp.Body.ClientAddr
+	}
+
+	*r = FastReq{options: tmp.Options, padata: tmp.PAData, body: body}
+	return nil
+}
+
+// MarshalDER encodes r as the KrbFastReq of RFC 6113 §5.4.2.
+func (r FastReq) MarshalDER() ([]byte, error) {
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			addFlags(b, 0, uint32(r.options))
+			addPAData(b, 1, r.padata)
+			b.AddASN1(field(2), r.body.addDER)
+		})
+	})
+}
+
+func (r *FastReq) UnmarshalDER(data []byte) error {
+	input := cryptobyte.String(data)
+	var seq, f cryptobyte.String
+	var options uint32
+	var padata []PAData
+	var body kdcReqBody
+	if !input.ReadASN1(&seq, asn1.SEQUENCE) || !input.Empty() ||
+		!readFlags(&seq, 0, &options) ||
+		!readOptionalPAData(&seq, 1, &padata) ||
+		!seq.ReadASN1(&f, field(2)) || !body.readDER(&f) || !f.Empty() || !seq.Empty() {
+		return malformed("KrbFastReq")
+	}
+
+	*r = FastReq{options: FastOptions(options), padata: padata, body: body}
+	return nil
+}
+
+// FastArmoredRep is the value of a PA-FX-FAST in a reply or an error: the
+// FastResponse sealed under the armor key.
+type FastArmoredRep struct {
+	encFastRep EncryptedData
+}
+
+func NewFastArmoredRep(encFastRep EncryptedData) FastArmoredRep {
+	return FastArmoredRep{encFastRep: encFastRep}
+}
+
+func (r FastArmoredRep) EncFastRep() EncryptedData { return r.encFastRep }
+
+type fastArmoredRep struct {
+	EncFastRep EncryptedData `json:"enc_fast_rep"`
+}
+
+func (r FastArmoredRep) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fastArmoredRep{EncFastRep: r.encFastRep})
+}
+
+func (r *FastArmoredRep) UnmarshalJSON(data []byte) error {
+	var tmp fastArmoredRep
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	*r = NewFastArmoredRep(tmp.EncFastRep)
+	return nil
+}
+
+// MarshalDER encodes r as the PA-FX-FAST-REPLY of RFC 6113 §5.4.3, whose
+// only choice is the armored data.
+func (r FastArmoredRep) MarshalDER() ([]byte, error) {
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(field(0), func(b *cryptobyte.Builder) {
+			b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				addEncryptedData(b, 0, r.encFastRep)
+			})
+		})
+	})
+}
+
+func (r *FastArmoredRep) UnmarshalDER(data []byte) error {
+	input := cryptobyte.String(data)
+	var choice, seq cryptobyte.String
+	var enc EncryptedData
+	if !input.ReadASN1(&choice, field(0)) || !input.Empty() ||
+		!choice.ReadASN1(&seq, asn1.SEQUENCE) || !choice.Empty() ||
+		!readEncryptedData(&seq, 0, &enc) || !seq.Empty() {
+		return malformed("PA-FX-FAST-REPLY")
+	}
+
+	*r = NewFastArmoredRep(enc)
+	return nil
+}
+
+// FastResponse is the KrbFastResponse sealed in a FastArmoredRep: the
+// pre-authentication data of the reply, the key that strengthens the reply
+// key of an AS exchange and, for a ticket, the proof that the clear part of
+// the reply was not changed. Its nonce is that of the request it answers.
+type FastResponse struct {
+	padata        []PAData
+	strengthenKey SessionKey
+	finished      *FastFinished
+	nonce         Nonce
+}
+
+func NewFastResponse(nonce Nonce) (FastResponse, error) {
+	if nonce == (Nonce{}) {
+		return FastResponse{}, ErrNonceInvalid
+	}
+
+	return FastResponse{nonce: nonce}, nil
+}
+
+func (r FastResponse) PAData() MethodData { return r.padata }
+func (r FastResponse) Nonce() Nonce       { return r.nonce }
+
+// StrengthenKey is the key the KDC combined the reply key with, if it did.
+func (r FastResponse) StrengthenKey() (SessionKey, bool) {
+	return r.strengthenKey, !r.strengthenKey.IsZero()
+}
+
+// Finished is the proof over the clear part of a reply that issued a
+// ticket.
+func (r FastResponse) Finished() (FastFinished, bool) {
+	if r.finished == nil {
+		return FastFinished{}, false
+	}
+	return *r.finished, true
+}
+
+// WithPAData returns a copy of the response carrying the given
+// pre-authentication data.
+func (r FastResponse) WithPAData(padata ...PAData) FastResponse {
+	r.padata = append([]PAData(nil), padata...)
+	return r
+}
+
+// WithStrengthenKey returns a copy of the response carrying key.
+func (r FastResponse) WithStrengthenKey(key SessionKey) FastResponse {
+	r.strengthenKey = key
+	return r
+}
+
+// WithFinished returns a copy of the response carrying finished.
+func (r FastResponse) WithFinished(finished FastFinished) FastResponse {
+	r.finished = &finished
+	return r
+}
+
+type fastResponse struct {
+	PAData        []PAData      `json:"padata,omitempty"`
+	StrengthenKey *SessionKey   `json:"strengthen_key,omitempty"`
+	Finished      *FastFinished `json:"finished,omitempty"`
+	Nonce         Nonce         `json:"nonce"`
+}
+
+func (r FastResponse) MarshalJSON() ([]byte, error) {
+	tmp := fastResponse{PAData: r.padata, Finished: r.finished, Nonce: r.nonce}
+	if key, ok := r.StrengthenKey(); ok {
+		tmp.StrengthenKey = &key
+	}
+	return json.Marshal(tmp)
+}
+
+func (r *FastResponse) UnmarshalJSON(data []byte) error {
+	var tmp fastResponse
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	rep, err := NewFastResponse(tmp.Nonce)
+	if err != nil {
+		return err
+	}
+	rep = rep.WithPAData(tmp.PAData...)
+	if tmp.StrengthenKey != nil {
+		rep = rep.WithStrengthenKey(*tmp.StrengthenKey)
+	}
+	if tmp.Finished != nil {
+		rep = rep.WithFinished(*tmp.Finished)
+	}
+
+	*r = rep
+	return nil
+}
+
+// MarshalDER encodes r as the KrbFastResponse of RFC 6113 §5.4.3.
+func (r FastResponse) MarshalDER() ([]byte, error) {
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			addPAData(b, 0, r.padata)
+			if key, ok := r.StrengthenKey(); ok {
+				addEncryptionKey(b, 1, key)
+			}
+			if r.finished != nil {
+				b.AddASN1(field(2), r.finished.addDER)
+			}
+			addInt(b, 3, int64(uint32(r.nonce.val)))
+		})
+	})
+}
+
+func (r *FastResponse) UnmarshalDER(data []byte) error {
+	input := cryptobyte.String(data)
+	var seq, f cryptobyte.String
+	var padata []PAData
+	var key SessionKey
+	var hasFinished bool
+	var finished FastFinished
+	var nonce int64
+	if !input.ReadASN1(&seq, asn1.SEQUENCE) || !input.Empty() ||
+		!readOptionalPAData(&seq, 0, &padata) ||
+		!readOptionalEncryptionKey(&seq, 1, &key) ||
+		!seq.ReadOptionalASN1(&f, &hasFinished, field(2)) ||
+		hasFinished && (!finished.readDER(&f) || !f.Empty()) ||
+		!readInt(&seq, 3, &nonce) || !seq.Empty() ||
+		nonce < 0 || nonce > math.MaxUint32 {
+		return malformed("KrbFastResponse")
+	}
+
+	rep, err := NewFastResponse(Nonce{val: int32(uint32(nonce))})
+	if err != nil {
+		return err
+	}
+	rep = rep.WithPAData(padata...).WithStrengthenKey(key)
+	if hasFinished {
+		rep = rep.WithFinished(finished)
+	}
+
+	*r = rep
+	return nil
+}
+
+// FastFinished is the KrbFastFinished of a reply that issued a ticket: the
+// client the ticket names and a checksum of the ticket under the armor key,
+// which the clear part of the reply is checked against.
+type FastFinished struct {
+	timestamp      time.Time
+	client         Principal
+	ticketChecksum Checksum
+}
+
+func NewFastFinished(timestamp time.Time, client Principal, ticketChecksum Checksum) (FastFinished, error) {
+	if client == (Principal{}) {
+		return FastFinished{}, ErrInvalidPrincipal
+	}
+	if ticketChecksum.IsZero() {
+		return FastFinished{}, ErrChecksumEmpty
+	}
+
+	return FastFinished{timestamp: timestamp, client: client, ticketChecksum: ticketChecksum}, nil
+}
+
+func (f FastFinished) Timestamp() time.Time     { return f.timestamp }
+func (f FastFinished) Client() Principal        { return f.client }
+func (f FastFinished) TicketChecksum() Checksum { return f.ticketChecksum }
+
+type fastFinished struct {
+	Timestamp      time.Time `json:"timestamp"`
+	Client         Principal `json:"client"`
+	TicketChecksum Checksum  `json:"ticket_checksum"`
+}
+
+func (f FastFinished) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fastFinished{Timestamp: f.timestamp, Client: f.client, TicketChecksum: f.ticketChecksum})
+}
+
+func (f *FastFinished) UnmarshalJSON(data []byte) error {
+	var tmp fastFinished
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	finished, err := NewFastFinished(tmp.Timestamp, tmp.Client, tmp.TicketChecksum)
+	if err != nil {
+		return err
+	}
+
+	*f = finished
+	return nil
+}
+
+func (f FastFinished) addDER(b *cryptobyte.Builder) {
+	b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		addTime(b, 0, f.timestamp)
+		addInt(b, 1, int64(f.timestamp.Nanosecond()/int(time.Microsecond)))
+		addString(b, 2, string(f.client.realm))
+		addPrincipalName(b, 3, f.client)
+		addChecksum(b, 4, f.ticketChecksum)
+	})
+}
+
+func (f *FastFinished) readDER(s *cryptobyte.String) bool {
+	var seq cryptobyte.String
+	var timestamp time.Time
+	var usec int64
+	var crealm string
+	var client Principal
+	var checksum Checksum
+	if !s.ReadASN1(&seq, asn1.SEQUENCE) ||
+		!readTime(&seq, 0, &timestamp) ||
+		!readInt(&seq, 1, &usec) ||
+		!readString(&seq, 2, &crealm) ||
+		!readPrincipalName(&seq, 3, Realm(crealm), &client) ||
+		!readChecksumField(&seq, 4, &checksum) || !seq.Empty() ||
+		usec < 0 || usec > 999999 {
+		return false
+	}
+
+	finished, err := NewFastFinished(timestamp.Add(time.Duration(usec)*time.Microsecond), client, checksum)
+	if err != nil {
+		return false
+	}
+	*f = finished
+	return true
+}
@@ -0,0 +1,205 @@
+package protocol_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+func TestFastArmoredReqSerialization(t *testing.T) {
+	cksum, _ := protocol.NewChecksum(protocol.ChecksumHMACSHA196AES256, []byte("checksum"))
+	enc, _ := protocol.NewEncryptedData([]byte("fast-req"))
+	armor, err := protocol.NewFastArmor(protocol.FastArmorAPRequest, []byte("ap-req"))
+	assert.Err(t, err, nil)
+
+	req, err := protocol.NewFastArmoredReq(cksum, enc.WithEncType(18))
+	assert.Err(t, err, nil)
+	req = req.WithArmor(armor)
+
+	check := func(t *testing.T, loaded protocol.FastArmoredReq) {
+		t.Helper()
+		got, ok := loaded.Armor()
+		assert.True(t, ok)
+		assert.Equal(t, got.Type(), protocol.FastArmorAPRequest)
+		assert.Equal(t, string(got.Value()), "ap-req")
+		assert.Equal(t, loaded.Checksum().Type(), protocol.ChecksumHMACSHA196AES256)
+		assert.Equal(t, string(loaded.Checksum().Value()), "checksum")
+		assert.Equal(t, string(loaded.EncFastReq().Ciphertext()), "fast-req")
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		data, err := json.Marshal(req)
+		assert.Err(t, err, nil)
+
+		var loaded protocol.FastArmoredReq
+		assert.Err(t, json.Unmarshal(data, &loaded), nil)
+		check(t, loaded)
+	})
+
+	t.Run("DER", func(t *testing.T) {
+		data, err := req.MarshalDER()
+		assert.Err(t, err, nil)
+
+		var loaded protocol.FastArmoredReq
+		assert.Err(t, loaded.UnmarshalDER(data), nil)
+		check(t, loaded)
+
+		again, err := loaded.MarshalDER()
+		assert.Err(t, err, nil)
+		assert.True(t, bytes.Equal(again, data))
+	})
+
+	t.Run("NoArmor", func(t *testing.T) {
+		bare, _ := protocol.NewFastArmoredReq(cksum, enc)
+		data, err := bare.MarshalDER()
+		assert.Err(t, err, nil)
+
+		var loaded protocol.FastArmoredReq
+		assert.Err(t, loaded.UnmarshalDER(data), nil)
+		_, ok := loaded.Armor()
+		assert.True(t, !ok)
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		_, err := protocol.NewFastArmoredReq(protocol.Checksum{}, enc)
+		assert.Err(t, err, protocol.ErrChecksumEmpty)
+
+		_, err = protocol.NewFastArmor(0, []byte("ap-req"))
+		assert.Err(t, err, protocol.ErrFastArmorInvalidType)
+	})
+}
+
+func TestFastReqSerialization(t *testing.T) {
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	tgs, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	nonce, _ := protocol.NewNonce(42)
+	encTS, _ := protocol.NewPAData(protocol.PATypeEncTimestamp, []byte("timestamp"))
+	till := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+
+	asReq, _ := protocol.NewASReq(client, tgs, addr, nonce)
+	asReq = asReq.
+		WithPAData(encTS).
+		WithOptions(protocol.OptRenewable).
+		WithTimes(time.Time{}, till).
+		WithETypes(18, 17)
+
+	fast := protocol.NewASFastReq(asReq).WithOptions(protocol.FastOptKDCFollowReferrals)
+
+	check := func(t *testing.T, loaded protocol.FastReq) {
+		t.Helper()
+		assert.True(t, loaded.Options().Has(protocol.FastOptKDCFollowReferrals))
+		assert.Equal(t, loaded.Options().Critical(), protocol.FastOptions(0))
+
+		inner, err := loaded.ASReq()
+		assert.Err(t, err, nil)
+		assert.Equal(t, inner.Client(), client)
+		assert.Equal(t, inner.Service(), tgs)
+		assert.Equal(t, inner.Nonce(), nonce)
+		assert.Equal(t, inner.Options(), protocol.OptRenewable)
+		assert.True(t, inner.Till().Equal(till))
+		assert.Equal(t, len(inner.ETypes()), 2)
+
+		pa, ok := inner.PAData().Find(protocol.PATypeEncTimestamp)
+		assert.True(t, ok)
+		assert.Equal(t, string(pa.Value()), "timestamp")
+	}
+
+	// The first 16 options are critical: a KDC must refuse those it does not
+	// know.
+	assert.Equal(t, protocol.FastOptHideClientNames.Critical(), protocol.FastOptHideClientNames)
+
+	t.Run("JSON", func(t *testing.T) {
+		data, err := json.Marshal(fast)
+		assert.Err(t, err, nil)
+
+		var loaded protocol.FastReq
+		assert.Err(t, json.Unmarshal(data, &loaded), nil)
+		check(t, loaded)
+	})
+
+	t.Run("DER", func(t *testing.T) {
+		data, err := fast.MarshalDER()
+		assert.Err(t, err, nil)
+
+		var loaded protocol.FastReq
+		assert.Err(t, loaded.UnmarshalDER(data), nil)
+		check(t, loaded)
+	})
+}
+
+func TestFastResponseSerialization(t *testing.T) {
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	nonce, _ := protocol.NewNonce(-7)
+	key, _ := protocol.NewSessionKey(bytes.Repeat([]byte{3}, 32))
+	cksum, _ := protocol.NewChecksum(protocol.ChecksumHMACSHA196AES256, []byte("ticket-checksum"))
+	fxError, _ := protocol.NewPAData(protocol.PATypeFXError, []byte("krb-error"))
+	now := time.Now().UTC().Truncate(time.Microsecond)
+
+	finished, err := protocol.NewFastFinished(now, client, cksum)
+	assert.Err(t, err, nil)
+
+	res, err := protocol.NewFastResponse(nonce)
+	assert.Err(t, err, nil)
+	res = res.WithPAData(fxError).WithStrengthenKey(key).WithFinished(finished)
+
+	check := func(t *testing.T, loaded protocol.FastResponse) {
+		t.Helper()
+		assert.Equal(t, loaded.Nonce(), nonce)
+
+		pa, ok := loaded.PAData().Find(protocol.PATypeFXError)
+		assert.True(t, ok)
+		assert.Equal(t, string(pa.Value()), "krb-error")
+
+		got, ok := loaded.StrengthenKey()
+		assert.True(t, ok)
+		assert.True(t, bytes.Equal(got.Expose(), key.Expose()))
+
+		f, ok := loaded.Finished()
+		assert.True(t, ok)
+		assert.True(t, f.Timestamp().Equal(now))
+		assert.Equal(t, f.Client(), client)
+		assert.Equal(t, string(f.TicketChecksum().Value()), "ticket-checksum")
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		data, err := json.Marshal(res)
+		assert.Err(t, err, nil)
+
+		var loaded protocol.FastResponse
+		assert.Err(t, json.Unmarshal(data, &loaded), nil)
+		check(t, loaded)
+	})
+
+	t.Run("DER", func(t *testing.T) {
+		data, err := res.MarshalDER()
+		assert.Err(t, err, nil)
+
+		var loaded protocol.FastResponse
+		assert.Err(t, loaded.UnmarshalDER(data), nil)
+		check(t, loaded)
+	})
+
+	t.Run("ArmoredRep", func(t *testing.T) {
+		enc, _ := protocol.NewEncryptedData([]byte("fast-rep"))
+		data, err := protocol.NewFastArmoredRep(enc.WithEncType(18)).MarshalDER()
+		assert.Err(t, err, nil)
+
+		var loaded protocol.FastArmoredRep
+		assert.Err(t, loaded.UnmarshalDER(data), nil)
+		assert.Equal(t, string(loaded.EncFastRep().Ciphertext()), "fast-rep")
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		_, err := protocol.NewFastResponse(protocol.Nonce{})
+		assert.True(t, err != nil)
+
+		_, err = protocol.NewFastFinished(now, protocol.Principal{}, cksum)
+		assert.True(t, err != nil)
+	})
+}
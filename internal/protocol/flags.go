@@ -0,0 +1,92 @@
+package protocol
+
+import "strings"
+
+// TicketFlags is the TicketFlags bit string of a ticket (RFC 4120 §5.3).
+// RFC bit n is the mask 1 << (31 - n), bit 0 being the most significant.
+type TicketFlags uint32
+
+const (
+	FlagForwardable            TicketFlags = 1 << (31 - 1)
+	FlagForwarded              TicketFlags = 1 << (31 - 2)
+	FlagProxiable              TicketFlags = 1 << (31 - 3)
+	FlagProxy                  TicketFlags = 1 << (31 - 4)
+	FlagMayPostdate            TicketFlags = 1 << (31 - 5)
+	FlagPostdated              TicketFlags = 1 << (31 - 6)
+	FlagInvalid                TicketFlags = 1 << (31 - 7)
+	FlagRenewable              TicketFlags = 1 << (31 - 8)
+	FlagInitial                TicketFlags = 1 << (31 - 9)
+	FlagPreAuthent             TicketFlags = 1 << (31 - 10)
+	FlagHWAuthent              TicketFlags = 1 << (31 - 11)
+	FlagTransitedPolicyChecked TicketFlags = 1 << (31 - 12)
+	FlagOkAsDelegate           TicketFlags = 1 << (31 - 13)
+)
+
+var ticketFlagNames = []struct {
+	flag TicketFlags
+	name string
+}{
+	{FlagForwardable, "forwardable"},
+	{FlagForwarded, "forwarded"},
+	{FlagProxiable, "proxiable"},
+	{FlagProxy, "proxy"},
+	{FlagMayPostdate, "may-postdate"},
+	{FlagPostdated, "postdated"},
+	{FlagInvalid, "invalid"},
+	{FlagRenewable, "renewable"},
+	{FlagInitial, "initial"},
+	{FlagPreAuthent, "pre-authent"},
+	{FlagHWAuthent, "hw-authent"},
+	{FlagTransitedPolicyChecked, "transited-policy-checked"},
+	{FlagOkAsDelegate, "ok-as-delegate"},
+}
+
+func (f TicketFlags) Has(flag TicketFlags) bool { return f&flag == flag }
+
+func (f TicketFlags) With(flag TicketFlags) TicketFlags { return f | flag }
+
+func (f TicketFlags) Without(flag TicketFlags) TicketFlags { return f &^ flag }
+
+func (f TicketFlags) String() string {
+	var names []string
+	for _, n := range ticketFlagNames {
+		if f.Has(n.flag) {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// KDCOptions is the kdc-options bit string of a KDC-REQ-BODY, asking the
+// KDC for particular ticket properties (RFC 4120 §5.4.1).
+type KDCOptions uint32
+
+const (
+	OptForwardable   KDCOptions = 1 << (31 - 1)
+	OptForwarded     KDCOptions = 1 << (31 - 2)
+	OptProxiable     KDCOptions = 1 << (31 - 3)
+	OptAllowPostdate KDCOptions = 1 << (31 - 5)
+	OptPostdated     KDCOptions = 1 << (31 - 6)
+	OptRenewable     KDCOptions = 1 << (31 - 8)
+	// OptCNameInAddlTkt asks for a ticket on behalf of the client of the
+	// additional ticket (S4U2Proxy, MS-SFU §2.2.3).
+	OptCNameInAddlTkt KDCOptions = 1 << (31 - 14)
+	OptRenew          KDCOptions = 1 << (31 - 30)
+	OptValidate       KDCOptions = 1 << (31 - 31)
+)
+
+func (o KDCOptions) Has(opt KDCOptions) bool { return o&opt == opt }
+
+func (o KDCOptions) With(opt KDCOptions) KDCOptions { return o | opt }
+
+// APOptions is the ap-options bit string of an AP-REQ (RFC 4120 §5.5.1).
+type APOptions uint32
+
+const (
+	APOptUseSessionKey  APOptions = 1 << (31 - 1)
+	APOptMutualRequired APOptions = 1 << (31 - 2)
+)
+
+func (o APOptions) Has(opt APOptions) bool { return o&opt == opt }
+
+func (o APOptions) With(opt APOptions) APOptions { return o | opt }
@@ -0,0 +1,76 @@
+package protocol_test
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+func TestTicketFlags(t *testing.T) {
+	// RFC 4120 numbers bits from the most significant end: forwardable is
+	// bit 1, initial bit 9.
+	assert.Equal(t, uint32(protocol.FlagForwardable), uint32(0x40000000))
+	assert.Equal(t, uint32(protocol.FlagInitial), uint32(0x00400000))
+
+	flags := protocol.FlagInitial.With(protocol.FlagPreAuthent)
+	assert.True(t, flags.Has(protocol.FlagInitial))
+	assert.True(t, flags.Has(protocol.FlagPreAuthent))
+	assert.True(t, !flags.Has(protocol.FlagForwardable))
+	assert.Equal(t, flags.String(), "initial,pre-authent")
+
+	flags = flags.Without(protocol.FlagInitial)
+	assert.True(t, !flags.Has(protocol.FlagInitial))
+	assert.Equal(t, flags.String(), "pre-authent")
+}
+
+func TestTicketFlagsSerialization(t *testing.T) {
+	server, _ := protocol.NewPrincipal("krbtgt", "ATHENA.MIT.EDU", "ATHENA.MIT.EDU")
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	key, _ := protocol.NewSessionKey(make([]byte, 32))
+	nonce, _ := protocol.NewNonce(42)
+	now := time.Now().UTC().Truncate(time.Second)
+	flags := protocol.FlagInitial | protocol.FlagForwardable
+
+	ticket, err := protocol.NewTicket(server, client, addr, now, time.Hour, key)
+	assert.Err(t, err, nil)
+
+	data, err := json.Marshal(ticket.WithFlags(flags).WithRenewTill(now.Add(24 * time.Hour)))
+	assert.Err(t, err, nil)
+
+	var loadedTicket protocol.Ticket
+	assert.Err(t, json.Unmarshal(data, &loadedTicket), nil)
+	assert.Equal(t, loadedTicket.Flags(), flags)
+	assert.True(t, loadedTicket.RenewTill().Equal(now.Add(24*time.Hour)))
+
+	// A ticket that is not renewable carries no renew-till.
+	data, err = json.Marshal(ticket)
+	assert.Err(t, err, nil)
+	assert.True(t, !strings.Contains(string(data), "renew_till"))
+
+	repPart, err := protocol.NewEncKDCRepPart(key, nonce, now, time.Hour, server)
+	assert.Err(t, err, nil)
+
+	data, err = json.Marshal(repPart.WithFlags(flags))
+	assert.Err(t, err, nil)
+
+	var loadedRepPart protocol.EncKDCRepPart
+	assert.Err(t, json.Unmarshal(data, &loadedRepPart), nil)
+	assert.Equal(t, loadedRepPart.Flags(), flags)
+
+	req, err := protocol.NewASReq(client, server, addr, nonce)
+	assert.Err(t, err, nil)
+
+	data, err = json.Marshal(req.WithOptions(protocol.OptForwardable))
+	assert.Err(t, err, nil)
+
+	var loadedReq protocol.ASReq
+	assert.Err(t, json.Unmarshal(data, &loadedReq), nil)
+	assert.True(t, loadedReq.Options().Has(protocol.OptForwardable))
+	assert.True(t, !loadedReq.Options().Has(protocol.OptRenewable))
+}
@@ -2,7 +2,11 @@ package protocol
 
 import (
 	"encoding/json"
+	"math"
 	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
 )
 
 type EncKDCRepPart struct {
@@ -11,6 +15,9 @@ type EncKDCRepPart struct {
 	issuedAt   time.Time
 	lifetime   time.Duration
 	server     Principal
+	flags      TicketFlags
+	renewTill  time.Time
+	startTime  time.Time
 }
 
 func NewEncKDCRepPart(
@@ -42,6 +49,43 @@ func (e EncKDCRepPart) Nonce() Nonce            { return e.nonce }
 func (e EncKDCRepPart) IssuedAt() time.Time     { return e.issuedAt }
 func (e EncKDCRepPart) Lifetime() time.Duration { return e.lifetime }
 func (e EncKDCRepPart) Server() Principal       { return e.server }
+func (e EncKDCRepPart) Flags() TicketFlags      { return e.flags }
+func (e EncKDCRepPart) RenewTill() time.Time    { return e.renewTill }
+
+// StartTime is the time the ticket becomes valid. It is the issue time
+// unless the ticket was postdated.
+func (e EncKDCRepPart) StartTime() time.Time {
+	if e.startTime.IsZero() {
+		return e.issuedAt
+	}
+	return e.startTime
+}
+
+// EndTime is the time after which the ticket is no longer valid.
+func (e EncKDCRepPart) EndTime() time.Time {
+	return e.StartTime().Add(e.lifetime)
+}
+
+// WithFlags returns a copy of the reply part carrying the flags of the
+// ticket it accompanies.
+func (e EncKDCRepPart) WithFlags(flags TicketFlags) EncKDCRepPart {
+	e.flags = flags
+	return e
+}
+
+// WithRenewTill returns a copy of the reply part carrying the renew-till
+// time of a renewable ticket.
+func (e EncKDCRepPart) WithRenewTill(renewTill time.Time) EncKDCRepPart {
+	e.renewTill = renewTill
+	return e
+}
+
+// WithStartTime returns a copy of the reply part carrying the start time of
+// a postdated ticket.
+func (e EncKDCRepPart) WithStartTime(startTime time.Time) EncKDCRepPart {
+	e.startTime = startTime
+	return e
+}
 
 type encKDCRepPart struct {
 	SessionKey SessionKey    `json:"session_key"`
@@ -49,16 +93,24 @@ type encKDCRepPart struct {
 	IssuedAt   time.Time     `json:"issued_at"`
 	Lifetime   time.Duration `json:"lifetime"`
 	Server     Principal     `json:"server"`
+	Flags      TicketFlags   `json:"flags,omitempty"`
+	RenewTill  *time.Time    `json:"renew_till,omitempty"`
+	StartTime  *time.Time    `json:"start_time,omitempty"`
 }
 
 func (e EncKDCRepPart) MarshalJSON() ([]byte, error) {
-	return json.Marshal(encKDCRepPart{
+	tmp := encKDCRepPart{
 		SessionKey: e.sessionKey,
 		Nonce:      e.nonce,
 		IssuedAt:   e.issuedAt,
 		Lifetime:   e.lifetime,
 		Server:     e.server,
-	})
+		Flags:      e.flags,
+		RenewTill:  optionalTime(e.renewTill),
+		StartTime:  optionalTime(e.startTime),
+	}
+
+	return json.Marshal(tmp)
 }
 
 func (e *EncKDCRepPart) UnmarshalJSON(data []byte) error {
@@ -78,6 +130,89 @@ func (e *EncKDCRepPart) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
-	*e = enc
+	*e = enc.
+		WithFlags(tmp.Flags).
+		WithRenewTill(fromOptional(tmp.RenewTill)).
+		WithStartTime(fromOptional(tmp.StartTime))
+	return nil
+}
+
+// MarshalDER encodes e as the EncASRepPart of RFC 4120 §5.4.2, which
+// implementations accept for TGS replies too. The last-req sequence is left
+// empty.
+func (e EncKDCRepPart) MarshalDER() ([]byte, error) {
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(application(tagEncASRepPart), func(b *cryptobyte.Builder) {
+			b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				addEncryptionKey(b, 0, e.sessionKey)
+				addSequence(b, 1, func(*cryptobyte.Builder) {})
+				addInt(b, 2, int64(uint32(e.nonce.val)))
+				addFlags(b, 4, uint32(e.flags))
+				addTime(b, 5, e.issuedAt)
+				if !e.startTime.IsZero() {
+					addTime(b, 6, e.startTime)
+				}
+				addTime(b, 7, e.EndTime())
+				if !e.renewTill.IsZero() {
+					addTime(b, 8, e.renewTill)
+				}
+				addString(b, 9, string(e.server.realm))
+				addPrincipalName(b, 10, e.server)
+			})
+		})
+	})
+}
+
+// UnmarshalDER decodes an EncASRepPart or an EncTGSRepPart.
+func (e *EncKDCRepPart) UnmarshalDER(data []byte) error {
+	tag := uint8(tagEncASRepPart)
+	if input := cryptobyte.String(data); input.PeekASN1Tag(application(tagEncTGSRepPart)) {
+		tag = tagEncTGSRepPart
+	}
+
+	var seq cryptobyte.String
+	var key SessionKey
+	var nonce int64
+	var flags uint32
+	var issuedAt, startTime, endTime, renewTill time.Time
+	var keyExpiration time.Time
+	var srealm string
+	var server Principal
+	var addr Address
+	if !unmarshalApplication(data, tag, &seq) ||
+		!readEncryptionKey(&seq, 0, &key) ||
+		!seq.SkipASN1(field(1)) ||
+		!readInt(&seq, 2, &nonce) ||
+		!readOptionalTime(&seq, 3, &keyExpiration) ||
+		!readFlags(&seq, 4, &flags) ||
+		!readTime(&seq, 5, &issuedAt) ||
+		!readOptionalTime(&seq, 6, &startTime) ||
+		!readTime(&seq, 7, &endTime) ||
+		!readOptionalTime(&seq, 8, &renewTill) ||
+		!readString(&seq, 9, &srealm) ||
+		!readPrincipalName(&seq, 10, Realm(srealm), &server) ||
+		!readHostAddresses(&seq, 11, &addr) ||
+		!seq.SkipOptionalASN1(field(12)) || !seq.Empty() ||
+		nonce < 0 || nonce > math.MaxUint32 {
+		return malformed("EncKDCRepPart")
+	}
+
+	start := issuedAt
+	if !startTime.IsZero() {
+		start = startTime
+	}
+	n, err := NewNonce(int32(uint32(nonce)))
+	if err != nil {
+		return err
+	}
+	enc, err := NewEncKDCRepPart(key, n, issuedAt, endTime.Sub(start), server)
+	if err != nil {
+		return err
+	}
+
+	*e = enc.
+		WithFlags(TicketFlags(flags)).
+		WithRenewTill(renewTill).
+		WithStartTime(startTime)
 	return nil
 }
@@ -0,0 +1,196 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	ErrKRBCredNoTickets    = errors.New("krb-cred must carry at least one ticket")
+	ErrKRBCredInfoMismatch = errors.New("krb-cred ticket info does not match its tickets")
+	ErrKRBCredInvalidKey   = errors.New("krb-cred session key cannot be empty")
+)
+
+// KRBCredInfo describes one forwarded ticket: the session key that goes with
+// it and the ticket fields its receiver needs to use it (RFC 4120 §5.8.1).
+type KRBCredInfo struct {
+	key       SessionKey
+	client    Principal
+	server    Principal
+	flags     TicketFlags
+	issuedAt  time.Time
+	lifetime  time.Duration
+	renewTill time.Time
+}
+
+func NewKRBCredInfo(
+	key SessionKey,
+	client Principal,
+	server Principal,
+	issuedAt time.Time,
+	lifetime time.Duration,
+) (KRBCredInfo, error) {
+	if key.IsZero() {
+		return KRBCredInfo{}, ErrKRBCredInvalidKey
+	}
+	if client == (Principal{}) || server == (Principal{}) {
+		return KRBCredInfo{}, ErrInvalidPrincipal
+	}
+
+	return KRBCredInfo{
+		key:      key,
+		client:   client,
+		server:   server,
+		issuedAt: issuedAt,
+		lifetime: lifetime,
+	}, nil
+}
+
+func (i KRBCredInfo) Key() SessionKey         { return i.key }
+func (i KRBCredInfo) Client() Principal       { return i.client }
+func (i KRBCredInfo) Server() Principal       { return i.server }
+func (i KRBCredInfo) Flags() TicketFlags      { return i.flags }
+func (i KRBCredInfo) IssuedAt() time.Time     { return i.issuedAt }
+func (i KRBCredInfo) Lifetime() time.Duration { return i.lifetime }
+func (i KRBCredInfo) RenewTill() time.Time    { return i.renewTill }
+
+// WithFlags returns a copy of the info carrying the flags of its ticket.
+func (i KRBCredInfo) WithFlags(flags TicketFlags) KRBCredInfo {
+	i.flags = flags
+	return i
+}
+
+// WithRenewTill returns a copy of the info carrying the renew-till time of
+// its ticket.
+func (i KRBCredInfo) WithRenewTill(renewTill time.Time) KRBCredInfo {
+	i.renewTill = renewTill
+	return i
+}
+
+type krbCredInfo struct {
+	Key       SessionKey    `json:"key"`
+	Client    Principal     `json:"client"`
+	Server    Principal     `json:"server"`
+	Flags     TicketFlags   `json:"flags,omitempty"`
+	IssuedAt  time.Time     `json:"issued_at"`
+	Lifetime  time.Duration `json:"lifetime"`
+	RenewTill *time.Time    `json:"renew_till,omitempty"`
+}
+
+func (i KRBCredInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(krbCredInfo{
+		Key:       i.key,
+		Client:    i.client,
+		Server:    i.server,
+		Flags:     i.flags,
+		IssuedAt:  i.issuedAt,
+		Lifetime:  i.lifetime,
+		RenewTill: optionalTime(i.renewTill),
+	})
+}
+
+func (i *KRBCredInfo) UnmarshalJSON(data []byte) error {
+	var tmp krbCredInfo
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	info, err := NewKRBCredInfo(tmp.Key, tmp.Client, tmp.Server, tmp.IssuedAt, tmp.Lifetime)
+	if err != nil {
+		return err
+	}
+
+	*i = info.WithFlags(tmp.Flags).WithRenewTill(fromOptional(tmp.RenewTill))
+	return nil
+}
+
+// EncKRBCredPart is the encrypted part of a KRB-CRED. Its ticket info lines
+// up one-to-one with the tickets of the message.
+type EncKRBCredPart struct {
+	ticketInfo []KRBCredInfo
+}
+
+func NewEncKRBCredPart(ticketInfo ...KRBCredInfo) (EncKRBCredPart, error) {
+	if len(ticketInfo) == 0 {
+		return EncKRBCredPart{}, ErrKRBCredNoTickets
+	}
+
+	return EncKRBCredPart{ticketInfo: append([]KRBCredInfo(nil), ticketInfo...)}, nil
+}
+
+func (p EncKRBCredPart) TicketInfo() []KRBCredInfo {
+	return append([]KRBCredInfo(nil), p.ticketInfo...)
+}
+
+type encKRBCredPart struct {
+	TicketInfo []KRBCredInfo `json:"ticket_info"`
+}
+
+func (p EncKRBCredPart) MarshalJSON() ([]byte, error) {
+	return json.Marshal(encKRBCredPart{TicketInfo: p.ticketInfo})
+}
+
+func (p *EncKRBCredPart) UnmarshalJSON(data []byte) error {
+	var tmp encKRBCredPart
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	part, err := NewEncKRBCredPart(tmp.TicketInfo...)
+	if err != nil {
+		return err
+	}
+
+	*p = part
+	return nil
+}
+
+// KRBCred forwards tickets to another party (RFC 4120 §5.8). The tickets stay
+// encrypted for their servers; their session keys travel in the encrypted
+// part, sealed under a key shared with the receiver.
+type KRBCred struct {
+	tickets []EncryptedData
+	encPart EncryptedData
+}
+
+func NewKRBCred(tickets []EncryptedData, encPart EncryptedData) (KRBCred, error) {
+	if len(tickets) == 0 {
+		return KRBCred{}, ErrKRBCredNoTickets
+	}
+
+	return KRBCred{
+		tickets: append([]EncryptedData(nil), tickets...),
+		encPart: encPart,
+	}, nil
+}
+
+func (c KRBCred) Tickets() []EncryptedData {
+	return append([]EncryptedData(nil), c.tickets...)
+}
+
+func (c KRBCred) EncPart() EncryptedData { return c.encPart }
+
+type krbCred struct {
+	Tickets []EncryptedData `json:"tickets"`
+	EncPart EncryptedData   `json:"enc_part"`
+}
+
+func (c KRBCred) MarshalJSON() ([]byte, error) {
+	return json.Marshal(krbCred{Tickets: c.tickets, EncPart: c.encPart})
+}
+
+func (c *KRBCred) UnmarshalJSON(data []byte) error {
+	var tmp krbCred
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	cred, err := NewKRBCred(tmp.Tickets, tmp.EncPart)
+	if err != nil {
+		return err
+	}
+
+	*c = cred
+	return nil
+}
@@ -0,0 +1,93 @@
+package protocol_test
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+func TestKRBCredSerialization(t *testing.T) {
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	tgs, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	key, _ := protocol.NewSessionKey(make([]byte, 32))
+	now := time.Now().UTC().Truncate(time.Second)
+
+	info, err := protocol.NewKRBCredInfo(key, client, tgs, now, 8*time.Hour)
+	assert.Err(t, err, nil)
+	info = info.WithFlags(protocol.FlagForwarded).WithRenewTill(now.Add(24 * time.Hour))
+
+	encPart, err := protocol.NewEncKRBCredPart(info)
+	assert.Err(t, err, nil)
+
+	data, err := json.Marshal(encPart)
+	assert.Err(t, err, nil)
+
+	var loadedPart protocol.EncKRBCredPart
+	assert.Err(t, json.Unmarshal(data, &loadedPart), nil)
+	assert.Equal(t, len(loadedPart.TicketInfo()), 1)
+
+	loadedInfo := loadedPart.TicketInfo()[0]
+	assert.Equal(t, loadedInfo.Client(), client)
+	assert.Equal(t, loadedInfo.Server(), tgs)
+	assert.Equal(t, loadedInfo.Flags(), protocol.FlagForwarded)
+	assert.True(t, loadedInfo.RenewTill().Equal(now.Add(24*time.Hour)))
+	assert.Equal(t, loadedInfo.Key().Expose(), key.Expose())
+
+	ticket, _ := protocol.NewEncryptedData([]byte("ticket"))
+	sealed, _ := protocol.NewEncryptedData([]byte("sealed"))
+	cred, err := protocol.NewKRBCred([]protocol.EncryptedData{ticket}, sealed)
+	assert.Err(t, err, nil)
+
+	auth, _ := protocol.NewEncryptedData([]byte("authenticator"))
+	apReq, _ := protocol.NewAPReq(ticket, auth)
+
+	data, err = json.Marshal(apReq.WithCred(cred))
+	assert.Err(t, err, nil)
+
+	var loadedReq protocol.APReq
+	assert.Err(t, json.Unmarshal(data, &loadedReq), nil)
+
+	loadedCred, ok := loadedReq.Cred()
+	assert.True(t, ok)
+	assert.Equal(t, len(loadedCred.Tickets()), 1)
+	assert.Equal(t, string(loadedCred.EncPart().Ciphertext()), "sealed")
+
+	_, ok = apReq.Cred()
+	assert.True(t, !ok)
+}
+
+func TestKRBCredValidation(t *testing.T) {
+	sealed, _ := protocol.NewEncryptedData([]byte("sealed"))
+	_, err := protocol.NewKRBCred(nil, sealed)
+	assert.Err(t, err, protocol.ErrKRBCredNoTickets)
+
+	_, err = protocol.NewEncKRBCredPart()
+	assert.Err(t, err, protocol.ErrKRBCredNoTickets)
+
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	_, err = protocol.NewKRBCredInfo(protocol.SessionKey{}, client, client, time.Now(), time.Hour)
+	assert.Err(t, err, protocol.ErrKRBCredInvalidKey)
+}
+
+func TestTGSReqClientAddr(t *testing.T) {
+	server, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	tgt, _ := protocol.NewEncryptedData([]byte("tgt"))
+	auth, _ := protocol.NewEncryptedData([]byte("auth"))
+	nonce, _ := protocol.NewNonce(7)
+	addr, _ := protocol.NewAddress(net.IPv4(10, 0, 0, 7))
+
+	req, err := protocol.NewTGSReq(server, tgt, auth, nonce)
+	assert.Err(t, err, nil)
+
+	data, err := json.Marshal(req.WithOptions(protocol.OptForwarded).WithClientAddr(addr))
+	assert.Err(t, err, nil)
+
+	var loaded protocol.TGSReq
+	assert.Err(t, json.Unmarshal(data, &loaded), nil)
+	assert.True(t, loaded.Options().Has(protocol.OptForwarded))
+	assert.Equal(t, loaded.ClientAddr().IP().String(), "10.0.0.7")
+}
@@ -0,0 +1,339 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/codec"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var ErrKRBErrorInvalidCode = errors.New("krb-error code must be non-zero")
+
+// ErrorCode is a KRB-ERROR error-code (RFC 4120 §7.5.9). Codes are errors
+// themselves, so a decoded KRBError matches them with errors.Is.
+type ErrorCode int32
+
+const (
+	KDCErrNameExp            ErrorCode = 1
+	KDCErrServiceExp         ErrorCode = 2
+	KDCErrBadPvno            ErrorCode = 3
+	KDCErrCPrincipalUnknown  ErrorCode = 6
+	KDCErrSPrincipalUnknown  ErrorCode = 7
+	KDCErrPrincipalNotUnique ErrorCode = 8
+	KDCErrNullKey            ErrorCode = 9
+	KDCErrCannotPostdate     ErrorCode = 10
+	KDCErrNeverValid         ErrorCode = 11
+	KDCErrPolicy             ErrorCode = 12
+	KDCErrBadOption          ErrorCode = 13
+	KDCErrETypeNoSupp        ErrorCode = 14
+	KDCErrSumTypeNoSupp      ErrorCode = 15
+	KDCErrPreauthFailed      ErrorCode = 24
+	KDCErrPreauthRequired    ErrorCode = 25
+	KDCErrServerNoMatch      ErrorCode = 26
+	KDCErrPathNotAccepted    ErrorCode = 28
+	KDCErrSvcUnavailable     ErrorCode = 29
+	KRBAPErrBadIntegrity     ErrorCode = 31
+	KRBAPErrTktExpired       ErrorCode = 32
+	KRBAPErrTktNYV           ErrorCode = 33
+	KRBAPErrRepeat           ErrorCode = 34
+	KRBAPErrNotUs            ErrorCode = 35
+	KRBAPErrBadMatch         ErrorCode = 36
+	KRBAPErrSkew             ErrorCode = 37
+	KRBAPErrBadAddr          ErrorCode = 38
+	KRBAPErrBadVersion       ErrorCode = 39
+	KRBAPErrMsgType          ErrorCode = 40
+	KRBAPErrModified         ErrorCode = 41
+	KRBAPErrBadOrder         ErrorCode = 42
+	KRBAPErrBadKeyVer        ErrorCode = 44
+	KRBAPErrNoKey            ErrorCode = 45
+	KRBAPErrMutFail          ErrorCode = 46
+	KRBAPErrBadDirection     ErrorCode = 47
+	KRBAPErrMethod           ErrorCode = 48
+	KRBAPErrBadSeq           ErrorCode = 49
+	KRBAPErrInappCksum       ErrorCode = 50
+	KRBErrResponseTooBig     ErrorCode = 52
+	KRBErrGeneric            ErrorCode = 60
+	KRBErrFieldTooLong       ErrorCode = 61
+	KDCErrWrongRealm         ErrorCode = 68
+	// The errors of PKINIT (RFC 4556 §3.1.3).
+	KDCErrClientNotTrusted           ErrorCode = 62
+	KDCErrInvalidSig                 ErrorCode = 64
+	KDCErrDHKeyParametersNotAccepted ErrorCode = 65
+	KDCErrCantVerifyCertificate      ErrorCode = 70
+	KDCErrInvalidCertificate         ErrorCode = 71
+	KDCErrClientNameMismatch         ErrorCode = 75
+	KDCErrPADataTypeNoSupp           ErrorCode = 79
+	// KDCErrUnknownCriticalFastOptions refuses a FAST request setting a
+	// critical option the KDC does not support (RFC 6113 §5.4.2).
+	KDCErrUnknownCriticalFastOptions ErrorCode = 93
+)
+
+var errorCodeNames = map[ErrorCode]string{
+	KDCErrNameExp:            "KDC_ERR_NAME_EXP",
+	KDCErrServiceExp:         "KDC_ERR_SERVICE_EXP",
+	KDCErrBadPvno:            "KDC_ERR_BAD_PVNO",
+	KDCErrCPrincipalUnknown:  "KDC_ERR_C_PRINCIPAL_UNKNOWN",
+	KDCErrSPrincipalUnknown:  "KDC_ERR_S_PRINCIPAL_UNKNOWN",
+	KDCErrPrincipalNotUnique: "KDC_ERR_PRINCIPAL_NOT_UNIQUE",
+	KDCErrNullKey:            "KDC_ERR_NULL_KEY",
+	KDCErrCannotPostdate:     "KDC_ERR_CANNOT_POSTDATE",
+	KDCErrNeverValid:         "KDC_ERR_NEVER_VALID",
+	KDCErrPolicy:             "KDC_ERR_POLICY",
+	KDCErrBadOption:          "KDC_ERR_BADOPTION",
+	KDCErrETypeNoSupp:        "KDC_ERR_ETYPE_NOSUPP",
+	KDCErrSumTypeNoSupp:      "KDC_ERR_SUMTYPE_NOSUPP",
+	KDCErrPreauthFailed:      "KDC_ERR_PREAUTH_FAILED",
+	KDCErrPreauthRequired:    "KDC_ERR_PREAUTH_REQUIRED",
+	KDCErrServerNoMatch:      "KDC_ERR_SERVER_NOMATCH",
+	KDCErrPathNotAccepted:    "KDC_ERR_PATH_NOT_ACCEPTED",
+	KDCErrSvcUnavailable:     "KDC_ERR_SVC_UNAVAILABLE",
+	KRBAPErrBadIntegrity:     "KRB_AP_ERR_BAD_INTEGRITY",
+	KRBAPErrTktExpired:       "KRB_AP_ERR_TKT_EXPIRED",
+	KRBAPErrTktNYV:           "KRB_AP_ERR_TKT_NYV",
+	KRBAPErrRepeat:           "KRB_AP_ERR_REPEAT",
+	KRBAPErrNotUs:            "KRB_AP_ERR_NOT_US",
+	KRBAPErrBadMatch:         "KRB_AP_ERR_BADMATCH",
+	KRBAPErrSkew:             "KRB_AP_ERR_SKEW",
+	KRBAPErrBadAddr:          "KRB_AP_ERR_BADADDR",
+	KRBAPErrBadVersion:       "KRB_AP_ERR_BADVERSION",
+	KRBAPErrMsgType:          "KRB_AP_ERR_MSG_TYPE",
+	KRBAPErrModified:         "KRB_AP_ERR_MODIFIED",
+	KRBAPErrBadOrder:         "KRB_AP_ERR_BADORDER",
+	KRBAPErrBadKeyVer:        "KRB_AP_ERR_BADKEYVER",
+	KRBAPErrNoKey:            "KRB_AP_ERR_NOKEY",
+	KRBAPErrMutFail:          "KRB_AP_ERR_MUT_FAIL",
+	KRBAPErrBadDirection:     "KRB_AP_ERR_BADDIRECTION",
+	KRBAPErrMethod:           "KRB_AP_ERR_METHOD",
+	KRBAPErrBadSeq:           "KRB_AP_ERR_BADSEQ",
+	KRBAPErrInappCksum:       "KRB_AP_ERR_INAPP_CKSUM",
+	KRBErrResponseTooBig:     "KRB_ERR_RESPONSE_TOO_BIG",
+	KRBErrGeneric:            "KRB_ERR_GENERIC",
+	KRBErrFieldTooLong:       "KRB_ERR_FIELD_TOOLONG",
+	KDCErrWrongRealm:         "KDC_ERR_WRONG_REALM",
+
+	KDCErrClientNotTrusted:           "KDC_ERR_CLIENT_NOT_TRUSTED",
+	KDCErrInvalidSig:                 "KDC_ERR_INVALID_SIG",
+	KDCErrDHKeyParametersNotAccepted: "KDC_ERR_DH_KEY_PARAMETERS_NOT_ACCEPTED",
+	KDCErrCantVerifyCertificate:      "KDC_ERR_CANT_VERIFY_CERTIFICATE",
+	KDCErrInvalidCertificate:         "KDC_ERR_INVALID_CERTIFICATE",
+	KDCErrClientNameMismatch:         "KDC_ERR_CLIENT_NAME_MISMATCH",
+	KDCErrPADataTypeNoSupp:           "KDC_ERR_PADATA_TYPE_NOSUPP",
+
+	KDCErrUnknownCriticalFastOptions: "KDC_ERR_UNKNOWN_CRITICAL_FAST_OPTIONS",
+}
+
+func (c ErrorCode) Error() string {
+	if name, ok := errorCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("KRB_ERR(%d)", int32(c))
+}
+
+// KRBError is the error reply of every Kerberos exchange.
+type KRBError struct {
+	code       ErrorCode
+	serverTime time.Time
+	realm      Realm
+	client     Principal
+	server     Principal
+	text       string
+	eData      []byte
+}
+
+func NewKRBError(code ErrorCode, serverTime time.Time, realm Realm, text string) (KRBError, error) {
+	if code == 0 {
+		return KRBError{}, ErrKRBErrorInvalidCode
+	}
+
+	return KRBError{
+		code:       code,
+		serverTime: serverTime,
+		realm:      realm,
+		text:       text,
+	}, nil
+}
+
+func (e KRBError) Code() ErrorCode       { return e.code }
+func (e KRBError) ServerTime() time.Time { return e.serverTime }
+func (e KRBError) Realm() Realm          { return e.realm }
+func (e KRBError) Client() Principal     { return e.client }
+func (e KRBError) Server() Principal     { return e.server }
+func (e KRBError) Text() string          { return e.text }
+
+func (e KRBError) EData() []byte {
+	d := make([]byte, len(e.eData))
+	copy(d, e.eData)
+	return d
+}
+
+// WithClient returns a copy of the error naming the client it concerns.
+func (e KRBError) WithClient(client Principal) KRBError {
+	e.client = client
+	return e
+}
+
+// WithServer returns a copy of the error naming the server it concerns.
+func (e KRBError) WithServer(server Principal) KRBError {
+	e.server = server
+	return e
+}
+
+// WithEData returns a copy of the error carrying method-specific e-data.
+func (e KRBError) WithEData(eData []byte) KRBError {
+	e.eData = append([]byte(nil), eData...)
+	return e
+}
+
+// MethodData decodes the e-data of a KDC_ERR_PREAUTH_REQUIRED reply, in
+// whichever encoding the KDC used.
+func (e KRBError) MethodData() (MethodData, error) {
+	if len(e.eData) == 0 {
+		return nil, fmt.Errorf("%w: no e-data", e.code)
+	}
+
+	var md MethodData
+	if err := codec.Detect(e.eData).Unmarshal(e.eData, &md); err != nil {
+		return nil, fmt.Errorf("decode method-data: %w", err)
+	}
+	return md, nil
+}
+
+func (e KRBError) Error() string {
+	if e.text == "" {
+		return e.code.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.code, e.text)
+}
+
+// Is reports whether target is the error code carried by e.
+func (e KRBError) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && code == e.code
+}
+
+type krbError struct {
+	Code       ErrorCode  `json:"error_code"`
+	ServerTime time.Time  `json:"server_time"`
+	Realm      Realm      `json:"realm"`
+	Client     *Principal `json:"client,omitempty"`
+	Server     *Principal `json:"server,omitempty"`
+	Text       string     `json:"e_text,omitempty"`
+	EData      []byte     `json:"e_data,omitempty"`
+}
+
+func (e KRBError) MarshalJSON() ([]byte, error) {
+	tmp := krbError{
+		Code:       e.code,
+		ServerTime: e.serverTime,
+		Realm:      e.realm,
+		Text:       e.text,
+		EData:      e.eData,
+	}
+	if e.client != (Principal{}) {
+		tmp.Client = &e.client
+	}
+	if e.server != (Principal{}) {
+		tmp.Server = &e.server
+	}
+
+	return json.Marshal(tmp)
+}
+
+func (e *KRBError) UnmarshalJSON(data []byte) error {
+	var tmp krbError
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	ke, err := NewKRBError(tmp.Code, tmp.ServerTime, tmp.Realm, tmp.Text)
+	if err != nil {
+		return err
+	}
+	if tmp.Client != nil {
+		ke = ke.WithClient(*tmp.Client)
+	}
+	if tmp.Server != nil {
+		ke = ke.WithServer(*tmp.Server)
+	}
+
+	*e = ke.WithEData(tmp.EData)
+	return nil
+}
+
+// MarshalDER encodes e as the KRB-ERROR of RFC 4120 §5.9.1. The sname the
+// RFC requires defaults to the realm's krbtgt.
+func (e KRBError) MarshalDER() ([]byte, error) {
+	server := e.server
+	if server == (Principal{}) {
+		krbtgt, err := NewKrbtgt(e.realm)
+		if err != nil {
+			return nil, err
+		}
+		server = krbtgt
+	}
+
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(application(tagKRBError), func(b *cryptobyte.Builder) {
+			b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				addInt(b, 0, pvno)
+				addInt(b, 1, msgTypeKRBError)
+				addTime(b, 4, e.serverTime)
+				addInt(b, 5, int64(e.serverTime.Nanosecond()/int(time.Microsecond)))
+				addInt(b, 6, int64(e.code))
+				if e.client != (Principal{}) {
+					addString(b, 7, string(e.client.realm))
+					addPrincipalName(b, 8, e.client)
+				}
+				addString(b, 9, string(e.realm))
+				addPrincipalName(b, 10, server)
+				if e.text != "" {
+					addString(b, 11, e.text)
+				}
+				if len(e.eData) > 0 {
+					addOctets(b, 12, e.eData)
+				}
+			})
+		})
+	})
+}
+
+func (e *KRBError) UnmarshalDER(data []byte) error {
+	var seq cryptobyte.String
+	var ctime, stime time.Time
+	var cusec, susec, code int64
+	var hasCusec bool
+	var crealm, realm, text string
+	var client, server Principal
+	var eData []byte
+	if !unmarshalApplication(data, tagKRBError, &seq) ||
+		!readVersion(&seq, 0, msgTypeKRBError) ||
+		!readOptionalTime(&seq, 2, &ctime) ||
+		!readOptionalInt(&seq, 3, &cusec, &hasCusec) ||
+		!readTime(&seq, 4, &stime) ||
+		!readInt(&seq, 5, &susec) ||
+		!readInt(&seq, 6, &code) ||
+		!readOptionalString(&seq, 7, &crealm) ||
+		!readOptionalPrincipalName(&seq, 8, Realm(crealm), &client) ||
+		!readString(&seq, 9, &realm) ||
+		!readPrincipalName(&seq, 10, Realm(realm), &server) ||
+		!readOptionalString(&seq, 11, &text) ||
+		!readOptionalOctets(&seq, 12, &eData) || !seq.Empty() ||
+		susec < 0 || susec > 999999 {
+		return malformed("KRB-ERROR")
+	}
+
+	ke, err := NewKRBError(ErrorCode(code), stime.Add(time.Duration(susec)*time.Microsecond), Realm(realm), text)
+	if err != nil {
+		return err
+	}
+	if client != (Principal{}) {
+		ke = ke.WithClient(client)
+	}
+
+	*e = ke.WithServer(server).WithEData(eData)
+	return nil
+}
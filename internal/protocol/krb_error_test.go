@@ -0,0 +1,71 @@
+package protocol_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+func TestKRBErrorSerialization(t *testing.T) {
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	info, _ := json.Marshal(protocol.ETypeInfo2{
+		protocol.NewETypeInfo2Entry(protocol.EncTypeAES256CTSHMACSHA196).WithSalt("ATHENA.MIT.EDUalice"),
+	})
+	etypeInfo, _ := protocol.NewPAData(protocol.PATypeETypeInfo2, info)
+	eData, _ := json.Marshal(protocol.MethodData{etypeInfo})
+	now := time.Now().UTC().Truncate(time.Second)
+
+	krbErr, err := protocol.NewKRBError(protocol.KDCErrPreauthRequired, now, "ATHENA.MIT.EDU", "need preauth")
+	assert.Err(t, err, nil)
+	krbErr = krbErr.WithClient(client).WithEData(eData)
+
+	data, err := json.Marshal(krbErr)
+	assert.Err(t, err, nil)
+
+	var loaded protocol.KRBError
+	err = json.Unmarshal(data, &loaded)
+	assert.Err(t, err, nil)
+
+	assert.Equal(t, loaded.Code(), protocol.KDCErrPreauthRequired)
+	assert.True(t, loaded.ServerTime().Equal(now))
+	assert.Equal(t, loaded.Realm(), protocol.Realm("ATHENA.MIT.EDU"))
+	assert.Equal(t, loaded.Client(), client)
+	assert.Equal(t, loaded.Server(), protocol.Principal{})
+	assert.Equal(t, loaded.Text(), "need preauth")
+
+	md, err := loaded.MethodData()
+	assert.Err(t, err, nil)
+	got, ok, err := md.ETypeInfo2()
+	assert.Err(t, err, nil)
+	assert.True(t, ok)
+	assert.Equal(t, len(got), 1)
+	salt, _ := got[0].Salt()
+	assert.Equal(t, salt, "ATHENA.MIT.EDUalice")
+
+	_, err = protocol.NewKRBError(0, now, "R", "")
+	assert.Err(t, err, protocol.ErrKRBErrorInvalidCode)
+}
+
+func TestKRBErrorMatching(t *testing.T) {
+	krbErr, _ := protocol.NewKRBError(protocol.KRBAPErrSkew, time.Now(), "R", "clock skew too great")
+
+	var err error = fmt.Errorf("call failed: %w", krbErr)
+	assert.Err(t, err, protocol.KRBAPErrSkew)
+	assert.Equal(t, errors.Is(err, protocol.KRBAPErrRepeat), false)
+	assert.Equal(t, krbErr.Error(), "KRB_AP_ERR_SKEW: clock skew too great")
+
+	var target protocol.KRBError
+	assert.True(t, errors.As(err, &target))
+	assert.Equal(t, target.Code(), protocol.KRBAPErrSkew)
+
+	// Wrapped codes survive errors.As on the code type itself.
+	var code protocol.ErrorCode
+	assert.True(t, errors.As(fmt.Errorf("%w: lookup", protocol.KDCErrCPrincipalUnknown), &code))
+	assert.Equal(t, code, protocol.KDCErrCPrincipalUnknown)
+	assert.Equal(t, protocol.ErrorCode(999).Error(), "KRB_ERR(999)")
+}
@@ -0,0 +1,186 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	ErrAppDataInvalidTime    = errors.New("application message timestamp cannot be empty")
+	ErrAppDataInvalidAddress = errors.New("application message sender address cannot be empty")
+)
+
+// AppData is the protected content of a KRB-SAFE or KRB-PRIV: the user data
+// together with what the receiver needs to detect replays and reflections.
+// It is the KRB-SAFE-BODY and EncKrbPrivPart of RFC 4120 §5.6.1 and §5.7.1,
+// which carry the same fields.
+type AppData struct {
+	userData  []byte
+	timestamp time.Time
+	seqNumber *uint32
+	sender    Address
+	recipient Address
+}
+
+func NewAppData(userData []byte, timestamp time.Time, sender Address) (AppData, error) {
+	if timestamp.IsZero() {
+		return AppData{}, ErrAppDataInvalidTime
+	}
+	if sender.IsZero() {
+		return AppData{}, ErrAppDataInvalidAddress
+	}
+
+	return AppData{
+		userData:  append([]byte(nil), userData...),
+		timestamp: timestamp,
+		sender:    sender,
+	}, nil
+}
+
+func (d AppData) UserData() []byte     { return append([]byte(nil), d.userData...) }
+func (d AppData) Timestamp() time.Time { return d.timestamp }
+func (d AppData) Sender() Address      { return d.sender }
+
+// SeqNumber returns the sequence number of the message, if it has one.
+func (d AppData) SeqNumber() (uint32, bool) {
+	if d.seqNumber == nil {
+		return 0, false
+	}
+	return *d.seqNumber, true
+}
+
+// Recipient returns the address the message is meant for, if it names one.
+func (d AppData) Recipient() (Address, bool) { return d.recipient, !d.recipient.IsZero() }
+
+// WithSeqNumber returns a copy of the message carrying a sequence number.
+func (d AppData) WithSeqNumber(seq uint32) AppData {
+	d.seqNumber = &seq
+	return d
+}
+
+// WithRecipient returns a copy of the message addressed to recipient.
+func (d AppData) WithRecipient(recipient Address) AppData {
+	d.recipient = recipient
+	return d
+}
+
+type appData struct {
+	UserData  []byte    `json:"user_data"`
+	Timestamp time.Time `json:"timestamp"`
+	SeqNumber *uint32   `json:"seq_number,omitempty"`
+	Sender    Address   `json:"s_address"`
+	Recipient *Address  `json:"r_address,omitempty"`
+}
+
+func (d AppData) MarshalJSON() ([]byte, error) {
+	tmp := appData{
+		UserData:  d.userData,
+		Timestamp: d.timestamp,
+		SeqNumber: d.seqNumber,
+		Sender:    d.sender,
+	}
+	if recipient, ok := d.Recipient(); ok {
+		tmp.Recipient = &recipient
+	}
+	return json.Marshal(tmp)
+}
+
+func (d *AppData) UnmarshalJSON(data []byte) error {
+	var tmp appData
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	msg, err := NewAppData(tmp.UserData, tmp.Timestamp, tmp.Sender)
+	if err != nil {
+		return err
+	}
+
+	if tmp.SeqNumber != nil {
+		msg = msg.WithSeqNumber(*tmp.SeqNumber)
+	}
+	if tmp.Recipient != nil {
+		msg = msg.WithRecipient(*tmp.Recipient)
+	}
+
+	*d = msg
+	return nil
+}
+
+// KRBSafe carries application data in the clear, protected against
+// modification by a checksum under the session key (RFC 4120 §5.6).
+type KRBSafe struct {
+	body  AppData
+	cksum Checksum
+}
+
+func NewKRBSafe(body AppData, cksum Checksum) (KRBSafe, error) {
+	if cksum.IsZero() {
+		return KRBSafe{}, ErrChecksumEmpty
+	}
+
+	return KRBSafe{body: body, cksum: cksum}, nil
+}
+
+func (s KRBSafe) Body() AppData      { return s.body }
+func (s KRBSafe) Checksum() Checksum { return s.cksum }
+
+type krbSafe struct {
+	Body     AppData  `json:"safe_body"`
+	Checksum Checksum `json:"cksum"`
+}
+
+func (s KRBSafe) MarshalJSON() ([]byte, error) {
+	return json.Marshal(krbSafe{Body: s.body, Checksum: s.cksum})
+}
+
+func (s *KRBSafe) UnmarshalJSON(data []byte) error {
+	var tmp krbSafe
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	safe, err := NewKRBSafe(tmp.Body, tmp.Checksum)
+	if err != nil {
+		return err
+	}
+
+	*s = safe
+	return nil
+}
+
+// KRBPriv carries application data encrypted under the session key
+// (RFC 4120 §5.7). Its encrypted part is an AppData.
+type KRBPriv struct {
+	encPart EncryptedData
+}
+
+func NewKRBPriv(encPart EncryptedData) (KRBPriv, error) {
+	return KRBPriv{encPart: encPart}, nil
+}
+
+func (p KRBPriv) EncPart() EncryptedData { return p.encPart }
+
+type krbPriv struct {
+	EncPart EncryptedData `json:"enc_part"`
+}
+
+func (p KRBPriv) MarshalJSON() ([]byte, error) {
+	return json.Marshal(krbPriv{EncPart: p.encPart})
+}
+
+func (p *KRBPriv) UnmarshalJSON(data []byte) error {
+	var tmp krbPriv
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	priv, err := NewKRBPriv(tmp.EncPart)
+	if err != nil {
+		return err
+	}
+
+	*p = priv
+	return nil
+}
@@ -0,0 +1,69 @@
+package protocol_test
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+func TestAppData(t *testing.T) {
+	now := time.Now().UTC()
+	sender, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	recipient, _ := protocol.NewAddress(net.IPv4(10, 0, 0, 1))
+
+	_, err := protocol.NewAppData([]byte("hi"), time.Time{}, sender)
+	assert.Err(t, err, protocol.ErrAppDataInvalidTime)
+
+	_, err = protocol.NewAppData([]byte("hi"), now, protocol.Address{})
+	assert.Err(t, err, protocol.ErrAppDataInvalidAddress)
+
+	data, err := protocol.NewAppData([]byte("hi"), now, sender)
+	assert.Err(t, err, nil)
+
+	raw, err := json.Marshal(data.WithSeqNumber(7).WithRecipient(recipient))
+	assert.Err(t, err, nil)
+
+	var loaded protocol.AppData
+	assert.Err(t, json.Unmarshal(raw, &loaded), nil)
+	assert.Equal(t, string(loaded.UserData()), "hi")
+	assert.True(t, loaded.Timestamp().Equal(now))
+	assert.Equal(t, loaded.Sender().IP().String(), sender.IP().String())
+
+	seq, ok := loaded.SeqNumber()
+	assert.True(t, ok)
+	assert.Equal(t, seq, uint32(7))
+
+	r, ok := loaded.Recipient()
+	assert.True(t, ok)
+	assert.Equal(t, r.IP().String(), recipient.IP().String())
+
+	_, ok = data.SeqNumber()
+	assert.True(t, !ok)
+	_, ok = data.Recipient()
+	assert.True(t, !ok)
+}
+
+func TestKRBSafeSerialization(t *testing.T) {
+	sender, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	data, _ := protocol.NewAppData([]byte("hi"), time.Now().UTC(), sender)
+
+	_, err := protocol.NewKRBSafe(data, protocol.Checksum{})
+	assert.Err(t, err, protocol.ErrChecksumEmpty)
+
+	cksum, _ := protocol.NewChecksum(protocol.ChecksumHMACSHA256, []byte{1, 2, 3})
+	safe, err := protocol.NewKRBSafe(data, cksum)
+	assert.Err(t, err, nil)
+
+	raw, err := json.Marshal(safe)
+	assert.Err(t, err, nil)
+
+	var loaded protocol.KRBSafe
+	assert.Err(t, json.Unmarshal(raw, &loaded), nil)
+	assert.Equal(t, string(loaded.Body().UserData()), "hi")
+	assert.Equal(t, loaded.Checksum().Value(), []byte{1, 2, 3})
+	assert.Equal(t, loaded.Checksum().Type(), protocol.ChecksumHMACSHA256)
+}
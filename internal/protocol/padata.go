@@ -0,0 +1,334 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/codec"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var (
+	ErrPADataInvalidType  = errors.New("padata type must be non-zero")
+	ErrPreauthRequired    = errors.New("additional pre-authentication required")
+	ErrPreauthFailed      = errors.New("pre-authentication failed")
+	ErrPreauthInvalidTime = errors.New("pre-authentication timestamp cannot be empty")
+)
+
+// PADataType identifies the content of a PAData element (RFC 4120 §7.5.2).
+type PADataType int32
+
+const (
+	// PATypeTGSReq carries the AP-REQ of a DER-encoded TGS-REQ.
+	PATypeTGSReq       PADataType = 1
+	PATypeEncTimestamp PADataType = 2
+	PATypePWSalt       PADataType = 3
+	// PATypePKASReq carries the signed AuthPack of a PKINIT request (RFC
+	// 4556).
+	PATypePKASReq PADataType = 16
+	// PATypePKASRep carries the KDC's signed KDCDHKeyInfo in reply to it.
+	PATypePKASRep    PADataType = 17
+	PATypeETypeInfo2 PADataType = 19
+	PATypeForUser    PADataType = 129
+	// PATypeFXFast carries a FAST armored request or reply (RFC 6113).
+	PATypeFXFast PADataType = 136
+	// PATypeFXError carries the KRB-ERROR a FAST response protects.
+	PATypeFXError PADataType = 137
+)
+
+func (t PADataType) String() string {
+	switch t {
+	case PATypeTGSReq:
+		return "PA-TGS-REQ"
+	case PATypeEncTimestamp:
+		return "PA-ENC-TIMESTAMP"
+	case PATypePWSalt:
+		return "PA-PW-SALT"
+	case PATypePKASReq:
+		return "PA-PK-AS-REQ"
+	case PATypePKASRep:
+		return "PA-PK-AS-REP"
+	case PATypeETypeInfo2:
+		return "PA-ETYPE-INFO2"
+	case PATypeForUser:
+		return "PA-FOR-USER"
+	case PATypeFXFast:
+		return "PA-FX-FAST"
+	case PATypeFXError:
+		return "PA-FX-ERROR"
+	default:
+		return fmt.Sprintf("PA-DATA(%d)", int32(t))
+	}
+}
+
+type PAData struct {
+	typ   PADataType
+	value []byte
+}
+
+func NewPAData(typ PADataType, value []byte) (PAData, error) {
+	if typ == 0 {
+		return PAData{}, ErrPADataInvalidType
+	}
+
+	v := make([]byte, len(value))
+	copy(v, value)
+
+	return PAData{typ: typ, value: v}, nil
+}
+
+func (p PAData) Type() PADataType { return p.typ }
+
+func (p PAData) Value() []byte {
+	v := make([]byte, len(p.value))
+	copy(v, p.value)
+	return v
+}
+
+type paData struct {
+	Type  PADataType `json:"type"`
+	Value []byte     `json:"value"`
+}
+
+func (p PAData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(paData{Type: p.typ, Value: p.value})
+}
+
+func (p *PAData) UnmarshalJSON(data []byte) error {
+	var tmp paData
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	pa, err := NewPAData(tmp.Type, tmp.Value)
+	if err != nil {
+		return err
+	}
+
+	*p = pa
+	return nil
+}
+
+func (p PAData) addDER(b *cryptobyte.Builder) {
+	b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		addInt(b, 1, int64(p.typ))
+		addOctets(b, 2, p.value)
+	})
+}
+
+func (p *PAData) readDER(s *cryptobyte.String) bool {
+	var seq cryptobyte.String
+	var typ int64
+	var value []byte
+	if !s.ReadASN1(&seq, asn1.SEQUENCE) || !readInt(&seq, 1, &typ) || !readOctets(&seq, 2, &value) || !seq.Empty() {
+		return false
+	}
+
+	pa, err := NewPAData(PADataType(typ), value)
+	if err != nil {
+		return false
+	}
+	*p = pa
+	return true
+}
+
+// MethodData is the sequence of pre-authentication hints a KDC sends back
+// when it refuses an AS-REQ.
+type MethodData []PAData
+
+func (m MethodData) Find(typ PADataType) (PAData, bool) {
+	for _, pa := range m {
+		if pa.Type() == typ {
+			return pa, true
+		}
+	}
+	return PAData{}, false
+}
+
+// ETypeInfo2 decodes the PA-ETYPE-INFO2 hint, if the KDC sent one, in
+// whichever encoding the KDC used.
+func (m MethodData) ETypeInfo2() (ETypeInfo2, bool, error) {
+	pa, ok := m.Find(PATypeETypeInfo2)
+	if !ok {
+		return nil, false, nil
+	}
+
+	var info ETypeInfo2
+	value := pa.Value()
+	if err := codec.Detect(value).Unmarshal(value, &info); err != nil {
+		return nil, true, fmt.Errorf("decode etype-info2: %w", err)
+	}
+	return info, true, nil
+}
+
+// MarshalDER encodes m as the METHOD-DATA of RFC 4120 §5.9.1.
+func (m MethodData) MarshalDER() ([]byte, error) {
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			for _, pa := range m {
+				pa.addDER(b)
+			}
+		})
+	})
+}
+
+func (m *MethodData) UnmarshalDER(data []byte) error {
+	input := cryptobyte.String(data)
+	var seq cryptobyte.String
+	if !input.ReadASN1(&seq, asn1.SEQUENCE) || !input.Empty() {
+		return malformed("METHOD-DATA")
+	}
+
+	var md MethodData
+	for !seq.Empty() {
+		var pa PAData
+		if !pa.readDER(&seq) {
+			return malformed("METHOD-DATA")
+		}
+		md = append(md, pa)
+	}
+
+	*m = md
+	return nil
+}
+
+// PAEncTSEnc is the plaintext of a PA-ENC-TIMESTAMP, encrypted under the
+// client's long-term key to prove knowledge of it.
+type PAEncTSEnc struct {
+	timestamp time.Time
+}
+
+func NewPAEncTSEnc(timestamp time.Time) (PAEncTSEnc, error) {
+	if timestamp.IsZero() {
+		return PAEncTSEnc{}, ErrPreauthInvalidTime
+	}
+
+	return PAEncTSEnc{timestamp: timestamp}, nil
+}
+
+func (p PAEncTSEnc) Timestamp() time.Time { return p.timestamp }
+
+type paEncTSEnc struct {
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func (p PAEncTSEnc) MarshalJSON() ([]byte, error) {
+	return json.Marshal(paEncTSEnc{Timestamp: p.timestamp})
+}
+
+func (p *PAEncTSEnc) UnmarshalJSON(data []byte) error {
+	var tmp paEncTSEnc
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	ts, err := NewPAEncTSEnc(tmp.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	*p = ts
+	return nil
+}
+
+// MarshalDER encodes p as the PA-ENC-TS-ENC of RFC 4120 §5.2.7.2.
+func (p PAEncTSEnc) MarshalDER() ([]byte, error) {
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			addTime(b, 0, p.timestamp)
+			addInt(b, 1, int64(p.timestamp.Nanosecond()/int(time.Microsecond)))
+		})
+	})
+}
+
+func (p *PAEncTSEnc) UnmarshalDER(data []byte) error {
+	input := cryptobyte.String(data)
+	var seq cryptobyte.String
+	var timestamp time.Time
+	var usec int64
+	var hasUsec bool
+	if !input.ReadASN1(&seq, asn1.SEQUENCE) || !input.Empty() ||
+		!readTime(&seq, 0, &timestamp) ||
+		!readOptionalInt(&seq, 1, &usec, &hasUsec) || !seq.Empty() ||
+		usec < 0 || usec > 999999 {
+		return malformed("PA-ENC-TS-ENC")
+	}
+
+	ts, err := NewPAEncTSEnc(timestamp.Add(time.Duration(usec) * time.Microsecond))
+	if err != nil {
+		return err
+	}
+
+	*p = ts
+	return nil
+}
+
+// PAForUser is the plaintext of a PA-FOR-USER (MS-SFU §2.2.1): the user a
+// service asks the TGS for a ticket to itself on behalf of. It travels
+// encrypted under the TGT session key, which binds it to the requesting
+// service.
+type PAForUser struct {
+	user Principal
+}
+
+func NewPAForUser(user Principal) (PAForUser, error) {
+	if user == (Principal{}) {
+		return PAForUser{}, ErrInvalidPrincipal
+	}
+
+	return PAForUser{user: user}, nil
+}
+
+func (p PAForUser) User() Principal { return p.user }
+
+type paForUser struct {
+	User Principal `json:"user"`
+}
+
+func (p PAForUser) MarshalJSON() ([]byte, error) {
+	return json.Marshal(paForUser{User: p.user})
+}
+
+func (p *PAForUser) UnmarshalJSON(data []byte) error {
+	var tmp paForUser
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	forUser, err := NewPAForUser(tmp.User)
+	if err != nil {
+		return err
+	}
+
+	*p = forUser
+	return nil
+}
+
+// PreauthRequiredError is returned by the AS exchange when the request did
+// not carry acceptable pre-authentication. It tells the client which methods
+// the KDC accepts and how to derive the client's key.
+type PreauthRequiredError struct {
+	methodData MethodData
+}
+
+func NewPreauthRequiredError(methodData MethodData) *PreauthRequiredError {
+	return &PreauthRequiredError{methodData: methodData}
+}
+
+func (e *PreauthRequiredError) MethodData() MethodData { return e.methodData }
+
+func (e *PreauthRequiredError) Error() string {
+	methods := make([]string, 0, len(e.methodData))
+	for _, pa := range e.methodData {
+		methods = append(methods, pa.Type().String())
+	}
+	return fmt.Sprintf("%s (methods: %s)", ErrPreauthRequired, strings.Join(methods, ", "))
+}
+
+func (e *PreauthRequiredError) Is(target error) bool {
+	return target == ErrPreauthRequired
+}
@@ -0,0 +1,174 @@
+package protocol_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+func TestPADataSerialization(t *testing.T) {
+	pa, err := protocol.NewPAData(protocol.PATypePWSalt, []byte("ATHENA.MIT.EDUalice"))
+	assert.Err(t, err, nil)
+
+	data, err := json.Marshal(pa)
+	assert.Err(t, err, nil)
+
+	var loaded protocol.PAData
+	err = json.Unmarshal(data, &loaded)
+	assert.Err(t, err, nil)
+
+	assert.Equal(t, loaded.Type(), protocol.PATypePWSalt)
+	assert.Equal(t, loaded.Value(), pa.Value())
+
+	_, err = protocol.NewPAData(0, nil)
+	assert.Err(t, err, protocol.ErrPADataInvalidType)
+}
+
+func TestASReqPAData(t *testing.T) {
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	service, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	nonce, _ := protocol.NewNonce(42)
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	salt, _ := protocol.NewPAData(protocol.PATypePWSalt, []byte("salt"))
+
+	req, err := protocol.NewASReq(client, service, addr, nonce)
+	assert.Err(t, err, nil)
+	req = req.WithPAData(salt)
+
+	data, err := json.Marshal(req)
+	assert.Err(t, err, nil)
+
+	var loaded protocol.ASReq
+	err = json.Unmarshal(data, &loaded)
+	assert.Err(t, err, nil)
+
+	got, ok := loaded.PAData().Find(protocol.PATypePWSalt)
+	assert.True(t, ok)
+	assert.Equal(t, string(got.Value()), "salt")
+
+	_, ok = loaded.PAData().Find(protocol.PATypeEncTimestamp)
+	assert.Equal(t, ok, false)
+}
+
+func TestPreauthRequiredError(t *testing.T) {
+	encTS, _ := protocol.NewPAData(protocol.PATypeEncTimestamp, nil)
+	info, _ := json.Marshal(protocol.ETypeInfo2{protocol.NewETypeInfo2Entry(protocol.EncTypeAES256GCM)})
+	etypeInfo, _ := protocol.NewPAData(protocol.PATypeETypeInfo2, info)
+
+	var err error = protocol.NewPreauthRequiredError(protocol.MethodData{encTS, etypeInfo})
+	assert.Err(t, err, protocol.ErrPreauthRequired)
+	assert.Err(t, err, "PA-ENC-TIMESTAMP")
+
+	var preauthErr *protocol.PreauthRequiredError
+	assert.True(t, errors.As(err, &preauthErr))
+
+	got, ok, err := preauthErr.MethodData().ETypeInfo2()
+	assert.Err(t, err, nil)
+	assert.True(t, ok)
+	assert.Equal(t, got[0].EncType(), protocol.EncTypeAES256GCM)
+	_, ok = got[0].Salt()
+	assert.Equal(t, ok, false)
+
+	_, err = protocol.NewPAEncTSEnc(time.Time{})
+	assert.Err(t, err, protocol.ErrPreauthInvalidTime)
+}
+
+func TestTGSReqS4U(t *testing.T) {
+	service, _ := protocol.NewPrincipal("http", "frontend", "ATHENA.MIT.EDU")
+	user, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	nonce, _ := protocol.NewNonce(42)
+	evidence, _ := protocol.NewEncryptedData([]byte("evidence"))
+
+	forUser, err := protocol.NewPAForUser(user)
+	assert.Err(t, err, nil)
+	forUserData, _ := json.Marshal(forUser)
+	pa, _ := protocol.NewPAData(protocol.PATypeForUser, forUserData)
+
+	req, err := protocol.NewTGSReq(service, evidence, evidence, nonce)
+	assert.Err(t, err, nil)
+	req = req.
+		WithOptions(protocol.OptCNameInAddlTkt).
+		WithPAData(pa).
+		WithAdditionalTickets(evidence)
+
+	data, err := json.Marshal(req)
+	assert.Err(t, err, nil)
+
+	var loaded protocol.TGSReq
+	err = json.Unmarshal(data, &loaded)
+	assert.Err(t, err, nil)
+
+	assert.True(t, loaded.Options().Has(protocol.OptCNameInAddlTkt))
+	assert.Equal(t, len(loaded.AdditionalTickets()), 1)
+	assert.Equal(t, loaded.AdditionalTickets()[0].Ciphertext(), evidence.Ciphertext())
+
+	got, ok := loaded.PAData().Find(protocol.PATypeForUser)
+	assert.True(t, ok)
+
+	var loadedForUser protocol.PAForUser
+	err = json.Unmarshal(got.Value(), &loadedForUser)
+	assert.Err(t, err, nil)
+	assert.Equal(t, loadedForUser.User(), user)
+
+	_, err = protocol.NewPAForUser(protocol.Principal{})
+	assert.Err(t, err, protocol.ErrInvalidPrincipal)
+}
+
+func TestETypeInfo2(t *testing.T) {
+	info := protocol.ETypeInfo2{
+		protocol.NewETypeInfo2Entry(protocol.EncTypeAES256CTSHMACSHA384192).
+			WithSalt("ATHENA.MIT.EDUalice").
+			WithS2KParams([]byte{0, 0, 0x80, 0}),
+		protocol.NewETypeInfo2Entry(protocol.EncTypeAES256GCM).WithSalt(""),
+		protocol.NewETypeInfo2Entry(protocol.EncTypeAES128CTSHMACSHA196),
+	}
+
+	check := func(t *testing.T, loaded protocol.ETypeInfo2) {
+		assert.Equal(t, len(loaded), 3)
+
+		salt, ok := loaded[0].Salt()
+		assert.True(t, ok)
+		assert.Equal(t, salt, "ATHENA.MIT.EDUalice")
+		assert.Equal(t, loaded[0].S2KParams(), []byte{0, 0, 0x80, 0})
+
+		salt, ok = loaded[1].Salt()
+		assert.True(t, ok)
+		assert.Equal(t, salt, "")
+		assert.Equal(t, loaded[1].EncType(), protocol.EncTypeAES256GCM)
+
+		_, ok = loaded[2].Salt()
+		assert.Equal(t, ok, false)
+		assert.Equal(t, len(loaded[2].S2KParams()), 0)
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		data, err := json.Marshal(info)
+		assert.Err(t, err, nil)
+
+		var loaded protocol.ETypeInfo2
+		assert.Err(t, json.Unmarshal(data, &loaded), nil)
+		check(t, loaded)
+	})
+
+	t.Run("DER", func(t *testing.T) {
+		data, err := info.MarshalDER()
+		assert.Err(t, err, nil)
+
+		var loaded protocol.ETypeInfo2
+		assert.Err(t, loaded.UnmarshalDER(data), nil)
+		check(t, loaded)
+
+		assert.Err(t, loaded.UnmarshalDER(data[:len(data)-1]), protocol.ErrMalformedDER)
+	})
+
+	t.Run("Absent", func(t *testing.T) {
+		_, ok, err := protocol.MethodData{}.ETypeInfo2()
+		assert.Err(t, err, nil)
+		assert.Equal(t, ok, false)
+	})
+}
@@ -0,0 +1,414 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var (
+	ErrPKChecksumEmpty     = errors.New("pk-authenticator must checksum the request body")
+	ErrPKPublicValueEmpty  = errors.New("public value cannot be empty")
+	ErrPKPublicValueFormat = errors.New("client public value must be a DER SubjectPublicKeyInfo")
+	ErrPKSignedDataEmpty   = errors.New("signed data must carry its content, a signature and the signer's certificate")
+)
+
+// PKAuthenticator binds a PKINIT request to the AS-REQ it travels in: the
+// time it was made, the nonce of the request and a checksum of its body
+// (RFC 4556 §3.2.1). The checksum is a SHA-256 digest where RFC 4556 uses
+// SHA-1.
+type PKAuthenticator struct {
+	issuedAt   time.Time
+	nonce      Nonce
+	paChecksum []byte
+}
+
+func NewPKAuthenticator(issuedAt time.Time, nonce Nonce, paChecksum []byte) (PKAuthenticator, error) {
+	if nonce == (Nonce{}) {
+		return PKAuthenticator{}, ErrNonceInvalid
+	}
+	if len(paChecksum) == 0 {
+		return PKAuthenticator{}, ErrPKChecksumEmpty
+	}
+
+	return PKAuthenticator{
+		issuedAt:   issuedAt,
+		nonce:      nonce,
+		paChecksum: append([]byte(nil), paChecksum...),
+	}, nil
+}
+
+func (a PKAuthenticator) IssuedAt() time.Time { return a.issuedAt }
+func (a PKAuthenticator) Nonce() Nonce        { return a.nonce }
+func (a PKAuthenticator) PAChecksum() []byte  { return append([]byte(nil), a.paChecksum...) }
+
+type pkAuthenticator struct {
+	IssuedAt   time.Time `json:"issued_at"`
+	Nonce      Nonce     `json:"nonce"`
+	PAChecksum []byte    `json:"pa_checksum"`
+}
+
+func (a PKAuthenticator) MarshalJSON() ([]byte, error) {
+	return json.Marshal(pkAuthenticator{IssuedAt: a.issuedAt, Nonce: a.nonce, PAChecksum: a.paChecksum})
+}
+
+func (a *PKAuthenticator) UnmarshalJSON(data []byte) error {
+	var tmp pkAuthenticator
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	auth, err := NewPKAuthenticator(tmp.IssuedAt, tmp.Nonce, tmp.PAChecksum)
+	if err != nil {
+		return err
+	}
+
+	*a = auth
+	return nil
+}
+
+func (a PKAuthenticator) addDER(b *cryptobyte.Builder) {
+	b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		addInt(b, 0, int64(a.issuedAt.Nanosecond()/int(time.Microsecond)))
+		addTime(b, 1, a.issuedAt)
+		addInt(b, 2, int64(uint32(a.nonce.val)))
+		addOctets(b, 3, a.paChecksum)
+	})
+}
+
+func (a *PKAuthenticator) readDER(s *cryptobyte.String) bool {
+	var seq cryptobyte.String
+	var usec, nonce int64
+	var issuedAt time.Time
+	var paChecksum []byte
+	if !s.ReadASN1(&seq, asn1.SEQUENCE) ||
+		!readInt(&seq, 0, &usec) ||
+		!readTime(&seq, 1, &issuedAt) ||
+		!readInt(&seq, 2, &nonce) ||
+		!readOctets(&seq, 3, &paChecksum) || !seq.Empty() ||
+		usec < 0 || usec > 999999 || nonce < 0 || nonce > math.MaxUint32 {
+		return false
+	}
+
+	auth, err := NewPKAuthenticator(
+		issuedAt.Add(time.Duration(usec)*time.Microsecond),
+		Nonce{val: int32(uint32(nonce))},
+		paChecksum,
+	)
+	if err != nil {
+		return false
+	}
+	*a = auth
+	return true
+}
+
+// AuthPack is what a client signs to pre-authenticate with its certificate:
+// its PKAuthenticator and the public half of the ephemeral (EC)DH key the
+// reply key is agreed with, as a DER SubjectPublicKeyInfo (RFC 4556
+// §3.2.1).
+type AuthPack struct {
+	pkAuthenticator   PKAuthenticator
+	clientPublicValue []byte
+}
+
+func NewAuthPack(pkAuthenticator PKAuthenticator, clientPublicValue []byte) (AuthPack, error) {
+	if len(clientPublicValue) == 0 {
+		return AuthPack{}, ErrPKPublicValueEmpty
+	}
+
+	// The value is embedded in the DER encoding as is, so it must be one
+	// element.
+	var spki cryptobyte.String
+	input := cryptobyte.String(clientPublicValue)
+	if !input.ReadASN1Element(&spki, asn1.SEQUENCE) || !input.Empty() {
+		return AuthPack{}, ErrPKPublicValueFormat
+	}
+
+	return AuthPack{
+		pkAuthenticator:   pkAuthenticator,
+		clientPublicValue: append([]byte(nil), clientPublicValue...),
+	}, nil
+}
+
+func (p AuthPack) PKAuthenticator() PKAuthenticator { return p.pkAuthenticator }
+func (p AuthPack) ClientPublicValue() []byte {
+	return append([]byte(nil), p.clientPublicValue...)
+}
+
+type authPack struct {
+	PKAuthenticator   PKAuthenticator `json:"pk_authenticator"`
+	ClientPublicValue []byte          `json:"client_public_value"`
+}
+
+func (p AuthPack) MarshalJSON() ([]byte, error) {
+	return json.Marshal(authPack{PKAuthenticator: p.pkAuthenticator, ClientPublicValue: p.clientPublicValue})
+}
+
+func (p *AuthPack) UnmarshalJSON(data []byte) error {
+	var tmp authPack
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	pack, err := NewAuthPack(tmp.PKAuthenticator, tmp.ClientPublicValue)
+	if err != nil {
+		return err
+	}
+
+	*p = pack
+	return nil
+}
+
+// MarshalDER encodes p as the AuthPack of RFC 4556 §3.2.1.
+func (p AuthPack) MarshalDER() ([]byte, error) {
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1(field(0), p.pkAuthenticator.addDER)
+			b.AddASN1(field(1), func(b *cryptobyte.Builder) {
+				b.AddBytes(p.clientPublicValue)
+			})
+		})
+	})
+}
+
+func (p *AuthPack) UnmarshalDER(data []byte) error {
+	input := cryptobyte.String(data)
+	var seq, f, spki cryptobyte.String
+	var auth PKAuthenticator
+	if !input.ReadASN1(&seq, asn1.SEQUENCE) || !input.Empty() ||
+		!seq.ReadASN1(&f, field(0)) || !auth.readDER(&f) || !f.Empty() ||
+		!seq.ReadASN1(&f, field(1)) || !f.ReadASN1Element(&spki, asn1.SEQUENCE) || !f.Empty() ||
+		!seq.Empty() {
+		return malformed("AuthPack")
+	}
+
+	pack, err := NewAuthPack(auth, spki)
+	if err != nil {
+		return err
+	}
+
+	*p = pack
+	return nil
+}
+
+// KDCDHKeyInfo is what the KDC signs in reply to an AuthPack: the public
+// half of its own ephemeral (EC)DH key, on the curve the client chose, and
+// the nonce of the client's PKAuthenticator (RFC 4556 §3.2.3.1).
+type KDCDHKeyInfo struct {
+	subjectPublicKey []byte
+	nonce            Nonce
+}
+
+func NewKDCDHKeyInfo(subjectPublicKey []byte, nonce Nonce) (KDCDHKeyInfo, error) {
+	if len(subjectPublicKey) == 0 {
+		return KDCDHKeyInfo{}, ErrPKPublicValueEmpty
+	}
+	if nonce == (Nonce{}) {
+		return KDCDHKeyInfo{}, ErrNonceInvalid
+	}
+
+	return KDCDHKeyInfo{subjectPublicKey: append([]byte(nil), subjectPublicKey...), nonce: nonce}, nil
+}
+
+func (i KDCDHKeyInfo) SubjectPublicKey() []byte { return append([]byte(nil), i.subjectPublicKey...) }
+func (i KDCDHKeyInfo) Nonce() Nonce             { return i.nonce }
+
+type kdcDHKeyInfo struct {
+	SubjectPublicKey []byte `json:"subject_public_key"`
+	Nonce            Nonce  `json:"nonce"`
+}
+
+func (i KDCDHKeyInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(kdcDHKeyInfo{SubjectPublicKey: i.subjectPublicKey, Nonce: i.nonce})
+}
+
+func (i *KDCDHKeyInfo) UnmarshalJSON(data []byte) error {
+	var tmp kdcDHKeyInfo
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	info, err := NewKDCDHKeyInfo(tmp.SubjectPublicKey, tmp.Nonce)
+	if err != nil {
+		return err
+	}
+
+	*i = info
+	return nil
+}
+
+// MarshalDER encodes i as the KDCDHKeyInfo of RFC 4556 §3.2.3.1.
+func (i KDCDHKeyInfo) MarshalDER() ([]byte, error) {
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1(field(0), func(b *cryptobyte.Builder) {
+				b.AddASN1BitString(i.subjectPublicKey)
+			})
+			addInt(b, 1, int64(uint32(i.nonce.val)))
+		})
+	})
+}
+
+func (i *KDCDHKeyInfo) UnmarshalDER(data []byte) error {
+	input := cryptobyte.String(data)
+	var seq, f cryptobyte.String
+	var key []byte
+	var nonce int64
+	if !input.ReadASN1(&seq, asn1.SEQUENCE) || !input.Empty() ||
+		!seq.ReadASN1(&f, field(0)) || !f.ReadASN1BitStringAsBytes(&key) || !f.Empty() ||
+		!readInt(&seq, 1, &nonce) || !seq.Empty() ||
+		nonce < 0 || nonce > math.MaxUint32 {
+		return malformed("KDCDHKeyInfo")
+	}
+
+	info, err := NewKDCDHKeyInfo(key, Nonce{val: int32(uint32(nonce))})
+	if err != nil {
+		return err
+	}
+
+	*i = info
+	return nil
+}
+
+// PKSignedData is the value of a PA-PK-AS-REQ or PA-PK-AS-REP: content, an
+// encoded AuthPack or KDCDHKeyInfo, signed by the key of the first of
+// certificates, which the rest chain to a trusted CA. It stands in for the
+// CMS SignedData of RFC 4556 §3.2.1: the signature algorithm is implied by
+// the signer's key, and there are no signed attributes.
+type PKSignedData struct {
+	content      []byte
+	certificates [][]byte
+	signature    []byte
+}
+
+func NewPKSignedData(content []byte, certificates [][]byte, signature []byte) (PKSignedData, error) {
+	if len(content) == 0 || len(certificates) == 0 || len(signature) == 0 {
+		return PKSignedData{}, ErrPKSignedDataEmpty
+	}
+
+	certs := make([][]byte, len(certificates))
+	for i, cert := range certificates {
+		if len(cert) == 0 {
+			return PKSignedData{}, ErrPKSignedDataEmpty
+		}
+		certs[i] = append([]byte(nil), cert...)
+	}
+
+	return PKSignedData{
+		content:      append([]byte(nil), content...),
+		certificates: certs,
+		signature:    append([]byte(nil), signature...),
+	}, nil
+}
+
+func (d PKSignedData) Content() []byte   { return append([]byte(nil), d.content...) }
+func (d PKSignedData) Signature() []byte { return append([]byte(nil), d.signature...) }
+
+// Certificates are the DER certificates of the signer, its own first.
+func (d PKSignedData) Certificates() [][]byte {
+	certs := make([][]byte, len(d.certificates))
+	for i, cert := range d.certificates {
+		certs[i] = append([]byte(nil), cert...)
+	}
+	return certs
+}
+
+type pkSignedData struct {
+	Content      []byte   `json:"content"`
+	Certificates [][]byte `json:"certificates"`
+	Signature    []byte   `json:"signature"`
+}
+
+func (d PKSignedData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(pkSignedData{Content: d.content, Certificates: d.certificates, Signature: d.signature})
+}
+
+func (d *PKSignedData) UnmarshalJSON(data []byte) error {
+	var tmp pkSignedData
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+
+	sd, err := NewPKSignedData(tmp.Content, tmp.Certificates, tmp.Signature)
+	if err != nil {
+		return err
+	}
+
+	*d = sd
+	return nil
+}
+
+// MarshalDER encodes d as a SEQUENCE of the content, the certificates and
+// the signature, each under its context tag.
+func (d PKSignedData) MarshalDER() ([]byte, error) {
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			addOctets(b, 0, d.content)
+			addSequence(b, 1, func(b *cryptobyte.Builder) {
+				for _, cert := range d.certificates {
+					b.AddBytes(cert)
+				}
+			})
+			addOctets(b, 2, d.signature)
+		})
+	})
+}
+
+func (d *PKSignedData) UnmarshalDER(data []byte) error {
+	input := cryptobyte.String(data)
+	var seq, f, certSeq cryptobyte.String
+	var content, signature []byte
+	var certs [][]byte
+	if !input.ReadASN1(&seq, asn1.SEQUENCE) || !input.Empty() ||
+		!readOctets(&seq, 0, &content) ||
+		!seq.ReadASN1(&f, field(1)) || !f.ReadASN1(&certSeq, asn1.SEQUENCE) || !f.Empty() {
+		return malformed("PKSignedData")
+	}
+	for !certSeq.Empty() {
+		var cert cryptobyte.String
+		if !certSeq.ReadASN1Element(&cert, asn1.SEQUENCE) {
+			return malformed("PKSignedData")
+		}
+		certs = append(certs, cert)
+	}
+	if !readOctets(&seq, 2, &signature) || !seq.Empty() {
+		return malformed("PKSignedData")
+	}
+
+	sd, err := NewPKSignedData(content, certs, signature)
+	if err != nil {
+		return err
+	}
+
+	*d = sd
+	return nil
+}
+
+// MarshalKRB5PrincipalName encodes p as the KRB5PrincipalName that names it
+// in the id-pkinit-san otherName of a certificate (RFC 4556 §3.2.2).
+func MarshalKRB5PrincipalName(p Principal) ([]byte, error) {
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			addString(b, 0, string(p.realm))
+			addPrincipalName(b, 1, p)
+		})
+	})
+}
+
+// ParseKRB5PrincipalName decodes a KRB5PrincipalName.
+func ParseKRB5PrincipalName(data []byte) (Principal, error) {
+	input := cryptobyte.String(data)
+	var seq cryptobyte.String
+	var realm string
+	var p Principal
+	if !input.ReadASN1(&seq, asn1.SEQUENCE) || !input.Empty() ||
+		!readString(&seq, 0, &realm) ||
+		!readPrincipalName(&seq, 1, Realm(realm), &p) || !seq.Empty() {
+		return Principal{}, malformed("KRB5PrincipalName")
+	}
+	return p, nil
+}
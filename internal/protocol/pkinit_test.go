@@ -0,0 +1,149 @@
+package protocol_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// spki is a DER SubjectPublicKeyInfo, which AuthPack embeds as is.
+var spki = []byte{0x30, 0x03, 0x02, 0x01, 0x05}
+
+func TestAuthPackSerialization(t *testing.T) {
+	nonce, _ := protocol.NewNonce(-5)
+	issuedAt := time.Date(2026, 10, 18, 12, 0, 0, 123456000, time.UTC)
+	auth, err := protocol.NewPKAuthenticator(issuedAt, nonce, []byte("checksum"))
+	assert.Err(t, err, nil)
+
+	pack, err := protocol.NewAuthPack(auth, spki)
+	assert.Err(t, err, nil)
+
+	check := func(t *testing.T, loaded protocol.AuthPack) {
+		t.Helper()
+		got := loaded.PKAuthenticator()
+		assert.True(t, got.IssuedAt().Equal(issuedAt))
+		assert.Equal(t, got.Nonce(), nonce)
+		assert.Equal(t, string(got.PAChecksum()), "checksum")
+		assert.True(t, bytes.Equal(loaded.ClientPublicValue(), spki))
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		data, err := json.Marshal(pack)
+		assert.Err(t, err, nil)
+
+		var loaded protocol.AuthPack
+		assert.Err(t, json.Unmarshal(data, &loaded), nil)
+		check(t, loaded)
+	})
+
+	t.Run("DER", func(t *testing.T) {
+		data, err := pack.MarshalDER()
+		assert.Err(t, err, nil)
+
+		var loaded protocol.AuthPack
+		assert.Err(t, loaded.UnmarshalDER(data), nil)
+		check(t, loaded)
+
+		again, err := loaded.MarshalDER()
+		assert.Err(t, err, nil)
+		assert.True(t, bytes.Equal(again, data))
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		_, err := protocol.NewPKAuthenticator(issuedAt, protocol.Nonce{}, []byte("checksum"))
+		assert.Err(t, err, protocol.ErrNonceInvalid)
+
+		_, err = protocol.NewPKAuthenticator(issuedAt, nonce, nil)
+		assert.Err(t, err, protocol.ErrPKChecksumEmpty)
+
+		_, err = protocol.NewAuthPack(auth, nil)
+		assert.Err(t, err, protocol.ErrPKPublicValueEmpty)
+
+		_, err = protocol.NewAuthPack(auth, []byte("not DER"))
+		assert.Err(t, err, protocol.ErrPKPublicValueFormat)
+	})
+}
+
+func TestKDCDHKeyInfoSerialization(t *testing.T) {
+	nonce, _ := protocol.NewNonce(42)
+	info, err := protocol.NewKDCDHKeyInfo([]byte("public key"), nonce)
+	assert.Err(t, err, nil)
+
+	t.Run("JSON", func(t *testing.T) {
+		data, err := json.Marshal(info)
+		assert.Err(t, err, nil)
+
+		var loaded protocol.KDCDHKeyInfo
+		assert.Err(t, json.Unmarshal(data, &loaded), nil)
+		assert.Equal(t, string(loaded.SubjectPublicKey()), "public key")
+		assert.Equal(t, loaded.Nonce(), nonce)
+	})
+
+	t.Run("DER", func(t *testing.T) {
+		data, err := info.MarshalDER()
+		assert.Err(t, err, nil)
+
+		var loaded protocol.KDCDHKeyInfo
+		assert.Err(t, loaded.UnmarshalDER(data), nil)
+		assert.Equal(t, string(loaded.SubjectPublicKey()), "public key")
+		assert.Equal(t, loaded.Nonce(), nonce)
+	})
+}
+
+func TestPKSignedDataSerialization(t *testing.T) {
+	certs := [][]byte{spki, {0x30, 0x00}}
+	sd, err := protocol.NewPKSignedData([]byte("content"), certs, []byte("signature"))
+	assert.Err(t, err, nil)
+
+	check := func(t *testing.T, loaded protocol.PKSignedData) {
+		t.Helper()
+		assert.Equal(t, string(loaded.Content()), "content")
+		assert.Equal(t, string(loaded.Signature()), "signature")
+		assert.Equal(t, len(loaded.Certificates()), 2)
+		assert.True(t, bytes.Equal(loaded.Certificates()[0], spki))
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		data, err := json.Marshal(sd)
+		assert.Err(t, err, nil)
+
+		var loaded protocol.PKSignedData
+		assert.Err(t, json.Unmarshal(data, &loaded), nil)
+		check(t, loaded)
+	})
+
+	t.Run("DER", func(t *testing.T) {
+		data, err := sd.MarshalDER()
+		assert.Err(t, err, nil)
+
+		var loaded protocol.PKSignedData
+		assert.Err(t, loaded.UnmarshalDER(data), nil)
+		check(t, loaded)
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		_, err := protocol.NewPKSignedData([]byte("content"), nil, []byte("signature"))
+		assert.Err(t, err, protocol.ErrPKSignedDataEmpty)
+
+		_, err = protocol.NewPKSignedData([]byte("content"), certs, nil)
+		assert.Err(t, err, protocol.ErrPKSignedDataEmpty)
+	})
+}
+
+func TestKRB5PrincipalName(t *testing.T) {
+	agent, _ := protocol.NewPrincipal("host", "build-01", "ATHENA.MIT.EDU")
+
+	data, err := protocol.MarshalKRB5PrincipalName(agent)
+	assert.Err(t, err, nil)
+
+	loaded, err := protocol.ParseKRB5PrincipalName(data)
+	assert.Err(t, err, nil)
+	assert.Equal(t, loaded, agent)
+
+	_, err = protocol.ParseKRB5PrincipalName(data[:len(data)-1])
+	assert.Err(t, err, protocol.ErrMalformedDER)
+}
@@ -38,14 +38,21 @@ func NewPrincipal(primary Primary, instance Instance, realm Realm) (Principal, e
 }
 
 func NewKrbtgt(realm Realm) (Principal, error) {
-	if realm == "" {
+	return NewInterRealmKrbtgt(realm, realm)
+}
+
+// NewInterRealmKrbtgt builds krbtgt/remote@local, the principal whose key
+// local's KDC shares with remote's. Tickets to it are the TGTs local issues
+// for use in remote; remote accepts them under the same key.
+func NewInterRealmKrbtgt(remote, local Realm) (Principal, error) {
+	if remote == "" || local == "" {
 		return Principal{}, ErrPrincipalEmptyRealm
 	}
 
 	return Principal{
 		primary:  "krbtgt",
-		instance: Instance(realm),
-		realm:    realm,
+		instance: Instance(remote),
+		realm:    local,
 	}, nil
 }
 
@@ -53,6 +60,16 @@ func (p Principal) Primary() Primary   { return p.primary }
 func (p Principal) Instance() Instance { return p.instance }
 func (p Principal) Realm() Realm       { return p.realm }
 
+// IsKrbtgt reports whether p is a ticket-granting service, local or
+// inter-realm. Its instance names the realm its tickets are used in.
+func (p Principal) IsKrbtgt() bool { return p.primary == "krbtgt" && p.instance != "" }
+
+// DefaultSalt is the salt RFC 4120 §4 gives keys derived from p's
+// password: the realm followed by the name components.
+func (p Principal) DefaultSalt() string {
+	return string(p.realm) + string(p.primary) + string(p.instance)
+}
+
 func (p Principal) String() string {
 	if p.instance == "" {
 		return fmt.Sprintf("%s@%s", p.primary, p.realm)
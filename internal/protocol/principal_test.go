@@ -31,3 +31,23 @@ func TestPrincipalSerialization(t *testing.T) {
 	assert.Equal(t, loaded.Instance(), p.Instance())
 	assert.Equal(t, loaded.Realm(), p.Realm())
 }
+
+func TestKrbtgt(t *testing.T) {
+	local, err := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	assert.Err(t, err, nil)
+	assert.True(t, local.IsKrbtgt())
+	assert.Equal(t, local.Instance(), protocol.Instance("ATHENA.MIT.EDU"))
+	assert.Equal(t, local.Realm(), protocol.Realm("ATHENA.MIT.EDU"))
+
+	remote, err := protocol.NewInterRealmKrbtgt("SALES.EXAMPLE.COM", "ATHENA.MIT.EDU")
+	assert.Err(t, err, nil)
+	assert.True(t, remote.IsKrbtgt())
+	assert.Equal(t, remote.Instance(), protocol.Instance("SALES.EXAMPLE.COM"))
+	assert.Equal(t, remote.Realm(), protocol.Realm("ATHENA.MIT.EDU"))
+
+	_, err = protocol.NewInterRealmKrbtgt("", "ATHENA.MIT.EDU")
+	assert.Err(t, err, protocol.ErrPrincipalEmptyRealm)
+
+	user, _ := protocol.NewPrincipal("krbtgt", "", "ATHENA.MIT.EDU")
+	assert.True(t, !user.IsKrbtgt())
+}
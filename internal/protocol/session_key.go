@@ -9,10 +9,15 @@ var (
 	ErrSessionKeyInvalid = errors.New("session key cannot be empty")
 )
 
+// SessionKey is an encryption key together with its encryption type, the
+// EncryptionKey of RFC 4120 §5.2.9.
 type SessionKey struct {
+	etype EncType
 	value []byte
 }
 
+// NewSessionKey makes an AES-256-GCM key of key; WithEncType changes its
+// type.
 func NewSessionKey(key []byte) (SessionKey, error) {
 	if len(key) == 0 {
 		return SessionKey{}, ErrSessionKeyInvalid
@@ -21,30 +26,42 @@ func NewSessionKey(key []byte) (SessionKey, error) {
 	value := make([]byte, len(key))
 	copy(value, key)
 
-	return SessionKey{value}, nil
+	return SessionKey{etype: EncTypeAES256GCM, value: value}, nil
 }
 
-func (s SessionKey) Expose() []byte { return s.value }
+func (s SessionKey) Expose() []byte   { return s.value }
+func (s SessionKey) EncType() EncType { return s.etype }
+
+// WithEncType returns a copy of the key used with etype.
+func (s SessionKey) WithEncType(etype EncType) SessionKey {
+	s.etype = etype
+	return s
+}
 
 func (s SessionKey) IsZero() bool {
 	return len(s.value) == 0
 }
 
+type sessionKey struct {
+	KeyType  EncType `json:"keytype"`
+	KeyValue []byte  `json:"keyvalue"`
+}
+
 func (s SessionKey) MarshalJSON() ([]byte, error) {
-	return json.Marshal(s.value)
+	return json.Marshal(sessionKey{KeyType: s.etype, KeyValue: s.value})
 }
 
 func (s *SessionKey) UnmarshalJSON(data []byte) error {
-	var val []byte
-	if err := json.Unmarshal(data, &val); err != nil {
+	tmp := sessionKey{KeyType: EncTypeAES256GCM}
+	if err := json.Unmarshal(data, &tmp); err != nil {
 		return err
 	}
 
-	key, err := NewSessionKey(val)
+	key, err := NewSessionKey(tmp.KeyValue)
 	if err != nil {
 		return err
 	}
 
-	*s = key
+	*s = key.WithEncType(tmp.KeyType)
 	return nil
 }
@@ -1,6 +1,7 @@
 package protocol_test
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/rizesql/kerberos/internal/assert"
@@ -14,3 +15,17 @@ func TestSessionKeyValidation(t *testing.T) {
 	_, err = protocol.NewSessionKey([]byte{})
 	assert.Err(t, err, protocol.ErrSessionKeyInvalid)
 }
+
+func TestSessionKeyJSON(t *testing.T) {
+	key, err := protocol.NewSessionKey([]byte("0123456789abcdef"))
+	assert.Err(t, err, nil)
+	assert.Equal(t, key.EncType(), protocol.EncTypeAES256GCM)
+
+	data, err := json.Marshal(key.WithEncType(17))
+	assert.Err(t, err, nil)
+
+	var decoded protocol.SessionKey
+	assert.Err(t, json.Unmarshal(data, &decoded), nil)
+	assert.Equal(t, decoded.EncType(), protocol.EncType(17))
+	assert.Equal(t, string(decoded.Expose()), "0123456789abcdef")
+}
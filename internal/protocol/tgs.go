@@ -3,7 +3,12 @@ package protocol
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
 )
 
 var (
@@ -20,6 +25,17 @@ type TGSReq struct {
 	tgt           EncryptedData
 	authenticator EncryptedData
 	nonce         Nonce
+	options       KDCOptions
+	clientAddr    Address
+	from          time.Time
+	till          time.Time
+	padata        []PAData
+	additional    []EncryptedData
+	tgtRealm      Realm
+	etypes        []EncType
+	// body is the KDC-REQ-BODY a DER request arrived with, which its
+	// authenticator checksums as sent.
+	body []byte
 }
 
 func NewTGSReq(
@@ -48,21 +64,176 @@ func (r TGSReq) Server() Principal            { return r.server }
 func (r TGSReq) TGT() EncryptedData           { return r.tgt }
 func (r TGSReq) Authenticator() EncryptedData { return r.authenticator }
 func (r TGSReq) Nonce() Nonce                 { return r.nonce }
+func (r TGSReq) Options() KDCOptions          { return r.options }
+func (r TGSReq) ClientAddr() Address          { return r.clientAddr }
+func (r TGSReq) From() time.Time              { return r.from }
+func (r TGSReq) Till() time.Time              { return r.till }
+func (r TGSReq) PAData() MethodData           { return r.padata }
+
+// ETypes lists the encryption types the client supports, in its order of
+// preference. A request naming none supports only AES-256-GCM.
+func (r TGSReq) ETypes() []EncType { return defaultETypes(r.etypes) }
+
+// TGTRealm is the realm that issued the TGT, telling the KDC which
+// krbtgt key it is sealed under. It is empty for a TGT of the KDC's own
+// realm.
+func (r TGSReq) TGTRealm() Realm { return r.tgtRealm }
+
+func (r TGSReq) AdditionalTickets() []EncryptedData {
+	return append([]EncryptedData(nil), r.additional...)
+}
+
+// WithOptions returns a copy of the request asking for the given ticket
+// options.
+func (r TGSReq) WithOptions(options KDCOptions) TGSReq {
+	r.options = options
+	r.body = nil
+	return r
+}
+
+// WithClientAddr returns a copy of the request naming the address a
+// FORWARDED ticket is issued for.
+func (r TGSReq) WithClientAddr(addr Address) TGSReq {
+	r.clientAddr = addr
+	r.body = nil
+	return r
+}
+
+// WithTimes returns a copy of the request asking for a ticket valid from
+// from until till. A zero from means now; a zero till leaves the end time to
+// the KDC.
+func (r TGSReq) WithTimes(from, till time.Time) TGSReq {
+	r.from, r.till = from, till
+	r.body = nil
+	return r
+}
+
+// WithETypes returns a copy of the request listing the encryption types the
+// client supports, most preferred first.
+func (r TGSReq) WithETypes(etypes ...EncType) TGSReq {
+	r.etypes = append([]EncType(nil), etypes...)
+	r.body = nil
+	return r
+}
+
+// WithPAData returns a copy of the request carrying the given
+// pre-authentication data, such as a PA-FOR-USER.
+func (r TGSReq) WithPAData(padata ...PAData) TGSReq {
+	r.padata = append([]PAData(nil), padata...)
+	return r
+}
+
+// WithAdditionalTickets returns a copy of the request carrying tickets the
+// KDC needs besides the TGT, such as the evidence ticket of S4U2Proxy.
+func (r TGSReq) WithAdditionalTickets(tickets ...EncryptedData) TGSReq {
+	r.additional = append([]EncryptedData(nil), tickets...)
+	r.body = nil
+	return r
+}
+
+// WithTGTRealm returns a copy of the request presenting a TGT issued by
+// realm, such as a referral TGT for a realm that trusts it.
+func (r TGSReq) WithTGTRealm(realm Realm) TGSReq {
+	r.tgtRealm = realm
+	return r
+}
+
+// WithAuthenticator returns a copy of the request carrying authenticator.
+// A client sets it last, since the authenticator checksums the body.
+func (r TGSReq) WithAuthenticator(authenticator EncryptedData) TGSReq {
+	r.authenticator = authenticator
+	return r
+}
+
+// Body encodes the fields of the request that travel in the clear, the
+// KDC-REQ-BODY of RFC 4120 §5.4.1. The authenticator carries a checksum of
+// it, so a changed field, such as the requested server, is detected.
+func (r TGSReq) Body() ([]byte, error) {
+	tmp := tgsReqBody{
+		Server:     r.server,
+		Nonce:      r.nonce,
+		Options:    r.options,
+		From:       optionalTime(r.from),
+		Till:       optionalTime(r.till),
+		Additional: r.additional,
+		TGTRealm:   r.tgtRealm,
+		ETypes:     r.etypes,
+	}
+	if !r.clientAddr.IsZero() {
+		tmp.ClientAddr = &r.clientAddr
+	}
+
+	return json.Marshal(tmp)
+}
+
+// BodyDER is the DER form of Body. For a request decoded from DER it is the
+// body exactly as received.
+func (r TGSReq) BodyDER() ([]byte, error) {
+	if r.body != nil {
+		return append([]byte(nil), r.body...), nil
+	}
+	return marshalDER(r.reqBody().addDER)
+}
+
+func (r TGSReq) reqBody() kdcReqBody {
+	return kdcReqBody{
+		options:    r.options,
+		server:     r.server,
+		from:       r.from,
+		till:       r.till,
+		nonce:      r.nonce,
+		etypes:     r.ETypes(),
+		clientAddr: r.clientAddr,
+		additional: r.additional,
+	}
+}
+
+type tgsReqBody struct {
+	Server     Principal       `json:"server"`
+	Nonce      Nonce           `json:"nonce"`
+	Options    KDCOptions      `json:"kdc_options,omitempty"`
+	ClientAddr *Address        `json:"client_addr,omitempty"`
+	From       *time.Time      `json:"from,omitempty"`
+	Till       *time.Time      `json:"till,omitempty"`
+	Additional []EncryptedData `json:"additional_tickets,omitempty"`
+	TGTRealm   Realm           `json:"tgt_realm,omitempty"`
+	ETypes     []EncType       `json:"etypes,omitempty"`
+}
 
 type tgsReq struct {
-	Server        Principal     `json:"server"`
-	TGT           EncryptedData `json:"tgt"`
-	Authenticator EncryptedData `json:"authenticator"`
-	Nonce         Nonce         `json:"nonce"`
+	Server        Principal       `json:"server"`
+	TGT           EncryptedData   `json:"tgt"`
+	Authenticator EncryptedData   `json:"authenticator"`
+	Nonce         Nonce           `json:"nonce"`
+	Options       KDCOptions      `json:"kdc_options,omitempty"`
+	ClientAddr    *Address        `json:"client_addr,omitempty"`
+	From          *time.Time      `json:"from,omitempty"`
+	Till          *time.Time      `json:"till,omitempty"`
+	PAData        []PAData        `json:"padata,omitempty"`
+	Additional    []EncryptedData `json:"additional_tickets,omitempty"`
+	TGTRealm      Realm           `json:"tgt_realm,omitempty"`
+	ETypes        []EncType       `json:"etypes,omitempty"`
 }
 
 func (r TGSReq) MarshalJSON() ([]byte, error) {
-	return json.Marshal(tgsReq{
+	tmp := tgsReq{
 		Server:        r.server,
 		TGT:           r.tgt,
 		Authenticator: r.authenticator,
 		Nonce:         r.nonce,
-	})
+		Options:       r.options,
+		From:          optionalTime(r.from),
+		Till:          optionalTime(r.till),
+		PAData:        r.padata,
+		Additional:    r.additional,
+		TGTRealm:      r.tgtRealm,
+		ETypes:        r.etypes,
+	}
+	if !r.clientAddr.IsZero() {
+		tmp.ClientAddr = &r.clientAddr
+	}
+
+	return json.Marshal(tmp)
 }
 
 func (r *TGSReq) UnmarshalJSON(data []byte) error {
@@ -76,6 +247,109 @@ func (r *TGSReq) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	req = req.
+		WithOptions(tmp.Options).
+		WithTimes(fromOptional(tmp.From), fromOptional(tmp.Till)).
+		WithPAData(tmp.PAData...).
+		WithAdditionalTickets(tmp.Additional...).
+		WithTGTRealm(tmp.TGTRealm).
+		WithETypes(tmp.ETypes...)
+	if tmp.ClientAddr != nil {
+		req = req.WithClientAddr(*tmp.ClientAddr)
+	}
+
+	*r = req
+	return nil
+}
+
+// MarshalDER encodes r as the TGS-REQ of RFC 4120 §5.4.1. The TGT and the
+// authenticator travel as the AP-REQ of a PA-TGS-REQ, ahead of any other
+// pre-authentication data; the TGT must name its server.
+func (r TGSReq) MarshalDER() ([]byte, error) {
+	apReq, err := NewAPReq(r.tgt, r.authenticator)
+	if err != nil {
+		return nil, err
+	}
+	apReqDER, err := apReq.MarshalDER()
+	if err != nil {
+		return nil, err
+	}
+	paTGSReq, err := NewPAData(PATypeTGSReq, apReqDER)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := r.BodyDER()
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(application(tagTGSReq), func(b *cryptobyte.Builder) {
+			b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				addInt(b, 1, pvno)
+				addInt(b, 2, msgTypeTGSReq)
+				addPAData(b, 3, append([]PAData{paTGSReq}, r.padata...))
+				b.AddASN1(field(4), func(b *cryptobyte.Builder) {
+					b.AddBytes(body)
+				})
+			})
+		})
+	})
+}
+
+// UnmarshalDER decodes a TGS-REQ. A TGT whose server is the krbtgt of
+// another realm than the one that issued it is a referral TGT, so its issuing
+// realm becomes the TGTRealm of the request.
+func (r *TGSReq) UnmarshalDER(data []byte) error {
+	var seq, f cryptobyte.String
+	var padata []PAData
+	var body kdcReqBody
+	if !unmarshalApplication(data, tagTGSReq, &seq) ||
+		!readVersion(&seq, 1, msgTypeTGSReq) ||
+		!readOptionalPAData(&seq, 3, &padata) ||
+		!seq.ReadASN1(&f, field(4)) || !seq.Empty() {
+		return malformed("TGS-REQ")
+	}
+	raw := []byte(f)
+	if !body.readDER(&f) || !f.Empty() {
+		return malformed("TGS-REQ")
+	}
+
+	var apReq APReq
+	var rest []PAData
+	for _, pa := range padata {
+		if pa.Type() != PATypeTGSReq {
+			rest = append(rest, pa)
+			continue
+		}
+		if err := apReq.UnmarshalDER(pa.value); err != nil {
+			return err
+		}
+	}
+	tgtServer, ok := apReq.Ticket().Server()
+	if !ok {
+		return fmt.Errorf("%w: TGS-REQ without PA-TGS-REQ", ErrMalformedDER)
+	}
+
+	req, err := NewTGSReq(body.server, apReq.Ticket(), apReq.Authenticator(), body.nonce)
+	if err != nil {
+		return err
+	}
+	req = req.
+		WithOptions(body.options).
+		WithTimes(body.from, body.till).
+		WithPAData(rest...).
+		WithAdditionalTickets(body.additional...).
+		WithETypes(body.etypes...)
+	if !body.clientAddr.IsZero() {
+		req = req.WithClientAddr(body.clientAddr)
+	}
+	if tgtServer.Realm() != Realm(tgtServer.Instance()) {
+		req = req.WithTGTRealm(tgtServer.Realm())
+	}
+
+	req.body = append([]byte(nil), raw...)
 	*r = req
 	return nil
 }
@@ -83,5 +357,10 @@ func (r *TGSReq) UnmarshalJSON(data []byte) error {
 type TGSRep = ASRep
 
 func NewTGSRep(ticket, secretPart EncryptedData) (TGSRep, error) {
-	return NewASRep(ticket, secretPart)
+	rep, err := NewASRep(ticket, secretPart)
+	if err != nil {
+		return TGSRep{}, err
+	}
+	rep.tgs = true
+	return rep, nil
 }
@@ -3,7 +3,11 @@ package protocol
 import (
 	"encoding/json"
 	"errors"
+	"strings"
 	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
 )
 
 var (
@@ -20,6 +24,11 @@ type Ticket struct {
 	issuedAt   time.Time
 	lifetime   time.Duration
 	sessionKey SessionKey
+	flags      TicketFlags
+	renewTill  time.Time
+	startTime  time.Time
+	transited  []Realm
+	authz      AuthorizationData
 }
 
 func NewTicket(
@@ -59,30 +68,120 @@ func (t Ticket) ClientAddr() Address     { return t.clientAddr }
 func (t Ticket) IssuedAt() time.Time     { return t.issuedAt }
 func (t Ticket) Lifetime() time.Duration { return t.lifetime }
 func (t Ticket) SessionKey() SessionKey  { return t.sessionKey }
+func (t Ticket) Flags() TicketFlags      { return t.flags }
+func (t Ticket) RenewTill() time.Time    { return t.renewTill }
+
+// Transited lists the realms, other than the client's and the issuing
+// realm, whose KDCs took part in issuing the ticket.
+func (t Ticket) Transited() []Realm {
+	return append([]Realm(nil), t.transited...)
+}
+
+// WithServer returns a copy of the ticket issued to server. The encrypted
+// part of a DER ticket does not name its server, so decoding one leaves it to
+// the caller, who knows it from the clear part.
+func (t Ticket) WithServer(server Principal) Ticket {
+	t.server = server
+	return t
+}
+
+// WithFlags returns a copy of the ticket carrying the given flags.
+func (t Ticket) WithFlags(flags TicketFlags) Ticket {
+	t.flags = flags
+	return t
+}
+
+// WithRenewTill returns a copy of the ticket that may be renewed up to
+// renewTill. It only has effect together with the RENEWABLE flag.
+func (t Ticket) WithRenewTill(renewTill time.Time) Ticket {
+	t.renewTill = renewTill
+	return t
+}
+
+// WithStartTime returns a copy of the ticket that only becomes valid at
+// startTime, as a POSTDATED ticket does.
+func (t Ticket) WithStartTime(startTime time.Time) Ticket {
+	t.startTime = startTime
+	return t
+}
+
+// WithTransited returns a copy of the ticket recording the realms it
+// transited on its way from the client's realm.
+func (t Ticket) WithTransited(realms ...Realm) Ticket {
+	t.transited = append([]Realm(nil), realms...)
+	return t
+}
+
+// AuthorizationData returns the KDC-issued claims about the client, if the
+// ticket carries them.
+func (t Ticket) AuthorizationData() (AuthorizationData, bool) {
+	return t.authz, !t.authz.IsZero()
+}
+
+// WithAuthorizationData returns a copy of the ticket carrying ad.
+func (t Ticket) WithAuthorizationData(ad AuthorizationData) Ticket {
+	t.authz = ad
+	return t
+}
+
+// StartTime is the time the ticket becomes valid. It is the issue time
+// unless the ticket was postdated.
+func (t Ticket) StartTime() time.Time {
+	if t.startTime.IsZero() {
+		return t.issuedAt
+	}
+	return t.startTime
+}
+
+// EndTime is the time after which the ticket is no longer valid. The
+// lifetime counts from the start time.
+func (t Ticket) EndTime() time.Time {
+	return t.StartTime().Add(t.lifetime)
+}
+
+// IsNotYetValid reports whether the ticket cannot be used at now, either
+// because its start time has not arrived or because it still awaits
+// validation.
+func (t Ticket) IsNotYetValid(now time.Time) bool {
+	return t.flags.Has(FlagInvalid) || now.Before(t.StartTime())
+}
 
 func (t Ticket) IsExpired(now time.Time) bool {
-	expiry := t.issuedAt.Add(t.lifetime)
-	return now.After(expiry)
+	return now.After(t.EndTime())
 }
 
 type ticket struct {
-	Server     Principal     `json:"server"`
-	Client     Principal     `json:"client"`
-	ClientAddr Address       `json:"client_addr"`
-	IssuedAt   time.Time     `json:"issued_at"`
-	Lifetime   time.Duration `json:"lifetime"`
-	SessionKey SessionKey    `json:"session_key"`
+	Server     Principal          `json:"server"`
+	Client     Principal          `json:"client"`
+	ClientAddr Address            `json:"client_addr"`
+	IssuedAt   time.Time          `json:"issued_at"`
+	Lifetime   time.Duration      `json:"lifetime"`
+	SessionKey SessionKey         `json:"session_key"`
+	Flags      TicketFlags        `json:"flags,omitempty"`
+	RenewTill  *time.Time         `json:"renew_till,omitempty"`
+	StartTime  *time.Time         `json:"start_time,omitempty"`
+	Transited  []Realm            `json:"transited,omitempty"`
+	AuthzData  *AuthorizationData `json:"authorization_data,omitempty"`
 }
 
 func (t Ticket) MarshalJSON() ([]byte, error) {
-	return json.Marshal(&ticket{
+	tmp := ticket{
 		Server:     t.server,
 		Client:     t.client,
 		ClientAddr: t.clientAddr,
 		IssuedAt:   t.issuedAt,
 		Lifetime:   t.lifetime,
 		SessionKey: t.sessionKey,
-	})
+		Flags:      t.flags,
+		RenewTill:  optionalTime(t.renewTill),
+		StartTime:  optionalTime(t.startTime),
+		Transited:  t.transited,
+	}
+	if ad, ok := t.AuthorizationData(); ok {
+		tmp.AuthzData = &ad
+	}
+
+	return json.Marshal(&tmp)
 }
 
 func (t *Ticket) UnmarshalJSON(data []byte) error {
@@ -103,6 +202,136 @@ func (t *Ticket) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	ti = ti.
+		WithFlags(tmp.Flags).
+		WithRenewTill(fromOptional(tmp.RenewTill)).
+		WithStartTime(fromOptional(tmp.StartTime)).
+		WithTransited(tmp.Transited...)
+	if tmp.AuthzData != nil {
+		ti = ti.WithAuthorizationData(*tmp.AuthzData)
+	}
+
 	*t = ti
 	return nil
 }
+
+// MarshalDER encodes t as the EncTicketPart of RFC 4120 §5.3. The server is
+// not part of it: it travels in the clear part of the Ticket, see
+// EncryptedData.WithServer. The issue time is the authtime, and transited
+// realms use the DOMAIN-X500-COMPRESS encoding.
+func (t Ticket) MarshalDER() ([]byte, error) {
+	var authz []byte
+	if ad, ok := t.AuthorizationData(); ok {
+		var err error
+		if authz, err = ad.marshalDER(); err != nil {
+			return nil, err
+		}
+	}
+
+	return marshalDER(func(b *cryptobyte.Builder) {
+		b.AddASN1(application(tagEncTicketPart), func(b *cryptobyte.Builder) {
+			b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				addFlags(b, 0, uint32(t.flags))
+				addEncryptionKey(b, 1, t.sessionKey)
+				addString(b, 2, string(t.client.realm))
+				addPrincipalName(b, 3, t.client)
+				addSequence(b, 4, func(b *cryptobyte.Builder) {
+					addInt(b, 0, trTypeDomainX500)
+					addOctets(b, 1, []byte(joinRealms(t.transited)))
+				})
+				addTime(b, 5, t.issuedAt)
+				if !t.startTime.IsZero() {
+					addTime(b, 6, t.startTime)
+				}
+				addTime(b, 7, t.EndTime())
+				if !t.renewTill.IsZero() {
+					addTime(b, 8, t.renewTill)
+				}
+				addHostAddresses(b, 9, t.clientAddr)
+				if authz != nil {
+					addAuthorizationData(b, 10, map[int64][]byte{adTypeClaims: authz})
+				}
+			})
+		})
+	})
+}
+
+// UnmarshalDER decodes an EncTicketPart. The ticket it yields has no server
+// until WithServer gives it one.
+func (t *Ticket) UnmarshalDER(data []byte) error {
+	var seq, transited cryptobyte.String
+	var flags uint32
+	var key SessionKey
+	var crealm string
+	var client Principal
+	var trType int64
+	var contents []byte
+	var authTime, startTime, endTime, renewTill time.Time
+	var addr Address
+	authz := map[int64][]byte{}
+	if !unmarshalApplication(data, tagEncTicketPart, &seq) ||
+		!readFlags(&seq, 0, &flags) ||
+		!readEncryptionKey(&seq, 1, &key) ||
+		!readString(&seq, 2, &crealm) ||
+		!readPrincipalName(&seq, 3, Realm(crealm), &client) ||
+		!seq.ReadASN1(&transited, field(4)) || !transited.ReadASN1(&transited, asn1.SEQUENCE) ||
+		!readInt(&transited, 0, &trType) || !readOctets(&transited, 1, &contents) || !transited.Empty() ||
+		!readTime(&seq, 5, &authTime) ||
+		!readOptionalTime(&seq, 6, &startTime) ||
+		!readTime(&seq, 7, &endTime) ||
+		!readOptionalTime(&seq, 8, &renewTill) ||
+		!readHostAddresses(&seq, 9, &addr) ||
+		!readAuthorizationData(&seq, 10, authz) || !seq.Empty() {
+		return malformed("EncTicketPart")
+	}
+
+	switch {
+	case client == (Principal{}):
+		return ErrTicketInvalidClient
+	case addr.IsZero():
+		return ErrTicketInvalidAddress
+	}
+
+	start := authTime
+	if !startTime.IsZero() {
+		start = startTime
+	}
+	ti := Ticket{
+		client:     client,
+		clientAddr: addr,
+		issuedAt:   authTime,
+		lifetime:   endTime.Sub(start),
+		sessionKey: key,
+		flags:      TicketFlags(flags),
+		renewTill:  renewTill,
+		startTime:  startTime,
+		transited:  splitRealms(string(contents)),
+	}
+	if data, ok := authz[adTypeClaims]; ok {
+		if err := ti.authz.unmarshalDER(data); err != nil {
+			return err
+		}
+	}
+
+	*t = ti
+	return nil
+}
+
+func joinRealms(realms []Realm) string {
+	names := make([]string, len(realms))
+	for i, r := range realms {
+		names[i] = string(r)
+	}
+	return strings.Join(names, ",")
+}
+
+func splitRealms(s string) []Realm {
+	if s == "" {
+		return nil
+	}
+	var realms []Realm
+	for name := range strings.SplitSeq(s, ",") {
+		realms = append(realms, Realm(name))
+	}
+	return realms
+}
@@ -78,3 +78,50 @@ func TestTicketValidation(t *testing.T) {
 	_, err = protocol.NewTicket(server, client, addr, now, life, protocol.SessionKey{})
 	assert.Err(t, err, protocol.ErrTicketInvalidSessionKey)
 }
+
+func TestTicketStartTime(t *testing.T) {
+	server, _ := protocol.NewPrincipal("srv", "", "REALM")
+	client, _ := protocol.NewPrincipal("cli", "", "REALM")
+	addr, _ := protocol.NewAddress(net.IPv4(1, 2, 3, 4))
+	key, _ := protocol.NewSessionKey(make([]byte, 32))
+	now := time.Now().UTC().Truncate(time.Second)
+
+	ticket, err := protocol.NewTicket(server, client, addr, now, time.Hour, key)
+	assert.Err(t, err, nil)
+	assert.True(t, ticket.StartTime().Equal(now))
+	assert.True(t, ticket.EndTime().Equal(now.Add(time.Hour)))
+	assert.True(t, !ticket.IsNotYetValid(now))
+
+	start := now.Add(3 * time.Hour)
+	postdated := ticket.WithStartTime(start)
+	assert.True(t, postdated.EndTime().Equal(start.Add(time.Hour)))
+	assert.True(t, postdated.IsNotYetValid(now))
+	assert.True(t, !postdated.IsNotYetValid(start))
+	assert.True(t, postdated.WithFlags(protocol.FlagInvalid).IsNotYetValid(start))
+
+	data, err := json.Marshal(postdated)
+	assert.Err(t, err, nil)
+
+	var loaded protocol.Ticket
+	assert.Err(t, json.Unmarshal(data, &loaded), nil)
+	assert.True(t, loaded.StartTime().Equal(start))
+	assert.True(t, loaded.IssuedAt().Equal(now))
+}
+
+func TestTicketTransited(t *testing.T) {
+	server, _ := protocol.NewPrincipal("srv", "", "C.EXAMPLE")
+	client, _ := protocol.NewPrincipal("cli", "", "A.EXAMPLE")
+	addr, _ := protocol.NewAddress(net.IPv4(1, 2, 3, 4))
+	key, _ := protocol.NewSessionKey(make([]byte, 32))
+
+	ticket, _ := protocol.NewTicket(server, client, addr, time.Now(), time.Hour, key)
+	assert.Equal(t, len(ticket.Transited()), 0)
+
+	ticket = ticket.WithTransited("B.EXAMPLE")
+	data, err := json.Marshal(ticket)
+	assert.Err(t, err, nil)
+
+	var loaded protocol.Ticket
+	assert.Err(t, json.Unmarshal(data, &loaded), nil)
+	assert.Equal(t, loaded.Transited(), []protocol.Realm{"B.EXAMPLE"})
+}
@@ -0,0 +1,19 @@
+package protocol
+
+import "time"
+
+// optionalTime and fromOptional map optional timestamps to JSON fields that
+// are omitted when unset.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func fromOptional(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
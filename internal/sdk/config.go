@@ -3,12 +3,20 @@ package sdk
 import (
 	"net/http"
 	"time"
+
+	"github.com/rizesql/kerberos/internal/protocol"
 )
 
 type Configuration struct {
 	Client    *http.Client
 	ServerUrl string
 	Timeout   *time.Duration
+	// RealmUrls locates the KDCs of other realms, for following referrals.
+	// Realms without an entry are served by ServerUrl.
+	RealmUrls map[protocol.Realm]string
+	// FAST armors TGS-REQs with FAST, so that their replies and errors are
+	// protected by a key the request's TGT alone does not give.
+	FAST bool
 }
 
 type SdkOption func(*Sdk)
@@ -30,3 +38,20 @@ func WithTimeout(timeout time.Duration) SdkOption {
 		sdk.cfg.Timeout = &timeout
 	}
 }
+
+// WithRealmServerUrl sets the KDC that serves realm.
+func WithRealmServerUrl(realm protocol.Realm, serverUrl string) SdkOption {
+	return func(sdk *Sdk) {
+		if sdk.cfg.RealmUrls == nil {
+			sdk.cfg.RealmUrls = make(map[protocol.Realm]string)
+		}
+		sdk.cfg.RealmUrls[realm] = serverUrl
+	}
+}
+
+// WithFAST armors TGS-REQs with FAST.
+func WithFAST() SdkOption {
+	return func(sdk *Sdk) {
+		sdk.cfg.FAST = true
+	}
+}
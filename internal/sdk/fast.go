@@ -0,0 +1,86 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// PostFastAS sends req armored with armor, built with shared.NewFastArmor,
+// and returns the reply with the FastResponse that protected it. A
+// protected error is opened and returned as the KRB-ERROR it carries. The
+// caller opens the reply part under its reply key strengthened with the
+// response's strengthen key.
+func (kdc *Kdc) PostFastAS(
+	ctx context.Context,
+	req protocol.ASReq,
+	armor protocol.FastArmor,
+	armorKey protocol.SessionKey,
+) (*protocol.ASRep, protocol.FastResponse, error) {
+	armored, err := shared.ArmorASReq(codec.JSON, req, armor, armorKey)
+	if err != nil {
+		return nil, protocol.FastResponse{}, fmt.Errorf("failed to armor request: %w", err)
+	}
+
+	rep, err := kdc.PostAS(ctx, armored)
+	if err != nil {
+		return nil, protocol.FastResponse{}, openFastError(err, armorKey, req.Nonce())
+	}
+
+	res, err := shared.OpenFastReply(armorKey, *rep, req.Nonce())
+	if err != nil {
+		return nil, protocol.FastResponse{}, err
+	}
+	return rep, res, nil
+}
+
+// postFastTGS sends req to the KDC of realm armored with FAST. armorKey
+// comes from the subkey its authenticator carries.
+func (kdc *Kdc) postFastTGS(
+	ctx context.Context,
+	realm protocol.Realm,
+	req protocol.TGSReq,
+	armorKey protocol.SessionKey,
+) (*protocol.TGSRep, error) {
+	armored, err := shared.ArmorTGSReq(codec.JSON, req, armorKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to armor request: %w", err)
+	}
+
+	rep, err := kdc.PostTGSTo(ctx, realm, armored)
+	if err != nil {
+		return nil, openFastError(err, armorKey, req.Nonce())
+	}
+
+	if _, err := shared.OpenFastReply(armorKey, *rep, req.Nonce()); err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// openFastError opens err if it is a KRB-ERROR protected by the armor. Any
+// other error, including one the KDC sent in the clear, is returned as-is.
+func openFastError(err error, armorKey protocol.SessionKey, nonce protocol.Nonce) error {
+	var krbErr protocol.KRBError
+	if !errors.As(err, &krbErr) {
+		return err
+	}
+
+	md, mErr := krbErr.MethodData()
+	if mErr != nil {
+		return err
+	}
+	if _, ok := md.Find(protocol.PATypeFXFast); !ok {
+		return err
+	}
+
+	inner, oErr := shared.OpenFastError(armorKey, krbErr, nonce)
+	if oErr != nil {
+		return oErr
+	}
+	return inner
+}
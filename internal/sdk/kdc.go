@@ -24,8 +24,8 @@ func newKdc(root *Sdk, cfg Configuration) *Kdc {
 	}
 }
 
-func (kdc *Kdc) invoke(ctx context.Context, stub Endpoint, request any, response any) (err error) {
-	path, err := url.JoinPath(kdc.cfg.ServerUrl, stub.Path())
+func (kdc *Kdc) invoke(ctx context.Context, serverUrl string, stub Endpoint, request any, response any) (err error) {
+	path, err := url.JoinPath(serverUrl, stub.Path())
 	if err != nil {
 		return fmt.Errorf("error generating URL: %w", err)
 	}
@@ -65,6 +65,14 @@ func (kdc *Kdc) invoke(ctx context.Context, stub Endpoint, request any, response
 		if readErr != nil {
 			return fmt.Errorf("received non-200 status code (%d) and failed to read body: %w", rawRes.StatusCode, readErr)
 		}
+
+		// KDC failures arrive as KRB-ERRORs; hand them back as-is so callers
+		// can match the error code with errors.Is.
+		var krbErr protocol.KRBError
+		if json.Unmarshal(bodyBytes, &krbErr) == nil {
+			return krbErr
+		}
+
 		return fmt.Errorf("received non-200 status code (%d): %s", rawRes.StatusCode, string(bodyBytes))
 	}
 
@@ -77,16 +85,28 @@ func (kdc *Kdc) invoke(ctx context.Context, stub Endpoint, request any, response
 
 func (kdc *Kdc) PostAS(ctx context.Context, req protocol.ASReq) (*protocol.ASRep, error) {
 	var res protocol.ASRep
-	if err := kdc.invoke(ctx, &protocol.ASEndpoint{}, req, &res); err != nil {
+	if err := kdc.invoke(ctx, kdc.cfg.ServerUrl, &protocol.ASEndpoint{}, req, &res); err != nil {
 		return nil, err
 	}
 	return &res, nil
 }
 
 func (kdc *Kdc) PostTGS(ctx context.Context, req protocol.TGSReq) (*protocol.TGSRep, error) {
+	return kdc.PostTGSTo(ctx, "", req)
+}
+
+// PostTGSTo sends req to the KDC of realm.
+func (kdc *Kdc) PostTGSTo(ctx context.Context, realm protocol.Realm, req protocol.TGSReq) (*protocol.TGSRep, error) {
 	var res protocol.TGSRep
-	if err := kdc.invoke(ctx, &protocol.TGSEndpoint{}, req, &res); err != nil {
+	if err := kdc.invoke(ctx, kdc.serverUrl(realm), &protocol.TGSEndpoint{}, req, &res); err != nil {
 		return nil, err
 	}
 	return &res, nil
 }
+
+func (kdc *Kdc) serverUrl(realm protocol.Realm) string {
+	if u, ok := kdc.cfg.RealmUrls[realm]; ok {
+		return u
+	}
+	return kdc.cfg.ServerUrl
+}
@@ -0,0 +1,39 @@
+package sdk_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/rizesql/kerberos/internal/sdk"
+)
+
+func TestKdc_KRBError(t *testing.T) {
+	krbErr, _ := protocol.NewKRBError(protocol.KDCErrCPrincipalUnknown, time.Now(), "ATHENA.MIT.EDU", "principal not found")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(krbErr)
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _ := protocol.NewPrincipal("ghost", "", "ATHENA.MIT.EDU")
+	service, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+	nonce, _ := protocol.NewNonce(1)
+	req, _ := protocol.NewASReq(client, service, addr, nonce)
+
+	_, err := sdk.New(sdk.WithServerUrl(srv.URL)).Kdc.PostAS(t.Context(), req)
+	assert.Err(t, err, protocol.KDCErrCPrincipalUnknown)
+
+	var got protocol.KRBError
+	assert.True(t, errors.As(err, &got))
+	assert.Equal(t, got.Realm(), protocol.Realm("ATHENA.MIT.EDU"))
+}
@@ -0,0 +1,169 @@
+package sdk
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/ap"
+	"github.com/rizesql/kerberos/internal/clock"
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+var (
+	ErrResponseNotPrivate = errors.New("service response is not a KRB-PRIV")
+	ErrSeqNumberMismatch  = errors.New("KRB-PRIV response does not answer the request")
+)
+
+// maxSkew bounds how far the timestamp of a sealed response may be from the
+// client's clock.
+const maxSkew = 5 * time.Minute
+
+// PrivTransport is an http.RoundTripper that seals request bodies in KRB-PRIV
+// under the session key of a service ticket and opens the service's sealed
+// responses. It pairs with ap.PrivMiddleware; the caller still sends the
+// AP-REQ in the Authorization header.
+//
+// Every request, even one without a body, is sealed with the next sequence
+// number, and its response must echo that number: a response recorded for
+// one request cannot answer another. The numbers start at a random value so
+// they do not repeat across transports sharing a session key.
+type PrivTransport struct {
+	base  http.RoundTripper
+	key   protocol.SessionKey
+	local protocol.Address
+	clock clock.Clock
+	seq   atomic.Uint32
+}
+
+func NewPrivTransport(
+	base http.RoundTripper,
+	key protocol.SessionKey,
+	local protocol.Address,
+	clock clock.Clock,
+) *PrivTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &PrivTransport{base: base, key: key, local: local, clock: clock}
+
+	var initial [4]byte
+	_, _ = rand.Read(initial[:])
+	t.seq.Store(binary.BigEndian.Uint32(initial[:]))
+	return t
+}
+
+func (t *PrivTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept", ap.PrivContentType)
+
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error reading request body: %w", err)
+		}
+	}
+
+	seq := t.seq.Add(1)
+	sealed, err := t.seal(body, seq)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(sealed))
+	req.ContentLength = int64(len(sealed))
+	req.GetBody = nil
+	req.Header.Set("Content-Type", ap.PrivContentType)
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ap.IsPriv(res.Header.Get("Content-Type")) {
+		defer res.Body.Close()
+
+		// Only the AP layer answers in the clear, with the 401 KRB-ERROR of
+		// an authentication failure; everything the service says, its errors
+		// included, is sealed.
+		if res.StatusCode == http.StatusUnauthorized {
+			if krbErr, ok := decodeKRBError(res); ok {
+				return nil, krbErr
+			}
+		}
+		return nil, ErrResponseNotPrivate
+	}
+
+	body, err = t.open(res.Body, seq)
+	_ = res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Del("Content-Type")
+	res.Header.Del("Content-Length")
+	return res, nil
+}
+
+func (t *PrivTransport) seal(body []byte, seq uint32) ([]byte, error) {
+	data, err := protocol.NewAppData(body, t.clock.Now(), t.local)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := ap.SealPriv(t.key, data.WithSeqNumber(seq))
+	if err != nil {
+		return nil, fmt.Errorf("error sealing request: %w", err)
+	}
+
+	return json.Marshal(msg)
+}
+
+func (t *PrivTransport) open(body io.Reader, seq uint32) ([]byte, error) {
+	var msg protocol.KRBPriv
+	if err := json.NewDecoder(body).Decode(&msg); err != nil {
+		return nil, fmt.Errorf("%w: %v", ap.ErrInvalidPriv, err)
+	}
+
+	data, err := ap.OpenPriv(t.key, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ap.CheckSkew(t.clock.Now(), data.Timestamp(), maxSkew); err != nil {
+		return nil, err
+	}
+
+	if n, ok := data.SeqNumber(); !ok || n != seq {
+		return nil, ErrSeqNumberMismatch
+	}
+
+	return data.UserData(), nil
+}
+
+// decodeKRBError reads the KRB-ERROR the AP layer rejected a request with.
+func decodeKRBError(res *http.Response) (protocol.KRBError, bool) {
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return protocol.KRBError{}, false
+	}
+
+	var krbErr protocol.KRBError
+	if err := codec.ForContentType(res.Header.Get("Content-Type")).Unmarshal(data, &krbErr); err != nil {
+		return protocol.KRBError{}, false
+	}
+	return krbErr, true
+}
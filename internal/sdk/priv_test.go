@@ -0,0 +1,274 @@
+package sdk_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/ap"
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/rizesql/kerberos/internal/sdk"
+	"github.com/rizesql/kerberos/internal/testkit"
+)
+
+type echo struct{}
+
+func (*echo) Method() string { return http.MethodPost }
+func (*echo) Path() string   { return "/echo" }
+func (*echo) Handle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Echo", "yes")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write(bytes.ToUpper(body))
+	}
+}
+
+type whoami struct{}
+
+func (*whoami) Method() string { return http.MethodGet }
+func (*whoami) Path() string   { return "/whoami" }
+func (*whoami) Handle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, _ := ap.ClientFromContext(r.Context())
+		_, _ = w.Write([]byte(client.String()))
+	}
+}
+
+type missing struct{}
+
+func (*missing) Method() string { return http.MethodGet }
+func (*missing) Path() string   { return "/missing" }
+func (*missing) Handle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such resource", http.StatusNotFound)
+	}
+}
+
+func TestPrivTransport(t *testing.T) {
+	h := testkit.NewHarness(t)
+
+	serverKey, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0xaa}, 32))
+	sessionKey, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0xbb}, 32))
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	service, _ := protocol.NewPrincipal("http", "api-server", "ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+
+	verifier := ap.NewVerifier(serverKey, h.Clock, h.ReplayCache)
+	srv := h.NewServer()
+	srv.Register(&echo{}, ap.Middleware(verifier), ap.PrivMiddleware(verifier, addr))
+	srv.Register(&whoami{}, ap.Middleware(verifier), ap.PrivMiddleware(verifier, addr))
+	srv.Register(&missing{}, ap.Middleware(verifier), ap.PrivMiddleware(verifier, addr))
+
+	httpSrv := httptest.NewServer(srv.Mux())
+	t.Cleanup(httpSrv.Close)
+
+	ticket, _ := protocol.NewTicket(service, client, addr, h.Clock.Now(), 8*time.Hour, sessionKey)
+	encTicket, _ := shared.EncryptEntity(codec.JSON, serverKey, crypto.KeyUsageTicket, ticket)
+
+	authorization := func(offset time.Duration) string {
+		auth, _ := protocol.NewAuthenticator(client, addr, h.Clock.Now().Add(offset))
+		encAuth, _ := shared.EncryptEntity(codec.JSON, sessionKey, crypto.KeyUsageAPReqAuth, auth)
+		apReq, _ := protocol.NewAPReq(encTicket, encAuth)
+		data, _ := json.Marshal(apReq)
+		return "Kerberos " + base64.StdEncoding.EncodeToString(data)
+	}
+
+	newRequest := func(t *testing.T, offset time.Duration, body string) *http.Request {
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodPost, httpSrv.URL+"/echo", strings.NewReader(body))
+		assert.Err(t, err, nil)
+		req.Header.Set("Authorization", authorization(offset))
+		return req
+	}
+
+	newGet := func(t *testing.T, offset time.Duration) *http.Request {
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, httpSrv.URL+"/whoami", nil)
+		assert.Err(t, err, nil)
+		req.Header.Set("Authorization", authorization(offset))
+		return req
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		var seen []byte
+		spy := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			seen, _ = io.ReadAll(req.Body)
+			req.Body = io.NopCloser(bytes.NewReader(seen))
+			return http.DefaultTransport.RoundTrip(req)
+		})
+
+		httpClient := &http.Client{Transport: sdk.NewPrivTransport(spy, sessionKey, addr, h.Clock)}
+		res, err := httpClient.Do(newRequest(t, time.Millisecond, "secret payload"))
+		assert.Err(t, err, nil)
+		defer res.Body.Close()
+
+		body, _ := io.ReadAll(res.Body)
+		assert.Equal(t, res.StatusCode, http.StatusCreated)
+		assert.Equal(t, res.Header.Get("X-Echo"), "yes")
+		assert.Equal(t, string(body), "SECRET PAYLOAD")
+		assert.True(t, !bytes.Contains(seen, []byte("secret payload")))
+	})
+
+	t.Run("BodylessRequestSealed", func(t *testing.T) {
+		h.Clock.Tick(time.Second)
+		var contentType string
+		spy := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			contentType = req.Header.Get("Content-Type")
+			return http.DefaultTransport.RoundTrip(req)
+		})
+
+		httpClient := &http.Client{Transport: sdk.NewPrivTransport(spy, sessionKey, addr, h.Clock)}
+		res, err := httpClient.Do(newGet(t, 3*time.Millisecond))
+		assert.Err(t, err, nil)
+		defer res.Body.Close()
+
+		body, _ := io.ReadAll(res.Body)
+		assert.Equal(t, res.StatusCode, http.StatusOK)
+		assert.Equal(t, string(body), client.String())
+		assert.Equal(t, contentType, ap.PrivContentType)
+	})
+
+	t.Run("ReplayedResponseRejected", func(t *testing.T) {
+		h.Clock.Tick(time.Second)
+		// The attacker forwards the first request and answers every later
+		// one with the response it recorded.
+		var recorded *http.Response
+		var recordedBody []byte
+		mitm := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if recorded == nil {
+				res, err := http.DefaultTransport.RoundTrip(req)
+				if err != nil {
+					return nil, err
+				}
+				recordedBody, _ = io.ReadAll(res.Body)
+				_ = res.Body.Close()
+				recorded = res
+			}
+			replay := *recorded
+			replay.Header = recorded.Header.Clone()
+			replay.Body = io.NopCloser(bytes.NewReader(recordedBody))
+			return &replay, nil
+		})
+
+		transport := sdk.NewPrivTransport(mitm, sessionKey, addr, h.Clock)
+		httpClient := &http.Client{Transport: transport}
+
+		res, err := httpClient.Do(newGet(t, 4*time.Millisecond))
+		assert.Err(t, err, nil)
+		_ = res.Body.Close()
+
+		h.Clock.Tick(time.Second)
+		_, err = httpClient.Do(newRequest(t, 5*time.Millisecond, "other request"))
+		assert.Err(t, err, sdk.ErrSeqNumberMismatch)
+
+		// A fresh transport on the same session key does not restart the
+		// sequence where the recorded response expects it.
+		fresh := &http.Client{Transport: sdk.NewPrivTransport(mitm, sessionKey, addr, h.Clock)}
+		_, err = fresh.Do(newGet(t, 6*time.Millisecond))
+		assert.Err(t, err, sdk.ErrSeqNumberMismatch)
+	})
+
+	t.Run("UnsequencedRequestRejected", func(t *testing.T) {
+		h.Clock.Tick(time.Second)
+		data, _ := protocol.NewAppData([]byte("no seq"), h.Clock.Now(), addr)
+		msg, _ := ap.SealPriv(sessionKey, data)
+		sealed, _ := json.Marshal(msg)
+
+		req := newRequest(t, 7*time.Millisecond, string(sealed))
+		req.Header.Set("Content-Type", ap.PrivContentType)
+
+		res, err := http.DefaultTransport.RoundTrip(req)
+		assert.Err(t, err, nil)
+		defer res.Body.Close()
+
+		var krbErr protocol.KRBError
+		assert.Err(t, json.NewDecoder(res.Body).Decode(&krbErr), nil)
+		assert.Equal(t, res.StatusCode, http.StatusUnauthorized)
+		assert.Equal(t, krbErr.Code(), protocol.KRBAPErrBadOrder)
+	})
+
+	t.Run("ServiceErrorCarriesAPRep", func(t *testing.T) {
+		h.Clock.Tick(time.Second)
+		authTime := h.Clock.Now()
+		auth, _ := protocol.NewAuthenticator(client, addr, authTime)
+		encAuth, _ := shared.EncryptEntity(codec.JSON, sessionKey, crypto.KeyUsageAPReqAuth, auth)
+		apReq, _ := protocol.NewAPReq(encTicket, encAuth)
+		data, _ := json.Marshal(apReq.WithOptions(protocol.APOptMutualRequired))
+
+		req, _ := http.NewRequestWithContext(t.Context(), http.MethodGet, httpSrv.URL+"/missing", nil)
+		req.Header.Set("Authorization", "Kerberos "+base64.StdEncoding.EncodeToString(data))
+
+		httpClient := &http.Client{Transport: sdk.NewPrivTransport(nil, sessionKey, addr, h.Clock)}
+		res, err := httpClient.Do(req)
+		assert.Err(t, err, nil)
+		defer res.Body.Close()
+		assert.Equal(t, res.StatusCode, http.StatusNotFound)
+
+		rep, err := ap.DecodeReply(res.Header.Get(ap.ReplyHeader))
+		assert.Err(t, err, nil)
+		_, err = ap.VerifyReply(sessionKey, rep, authTime)
+		assert.Err(t, err, nil)
+	})
+
+	t.Run("WrongSessionKey", func(t *testing.T) {
+		wrongKey, _ := protocol.NewSessionKey(bytes.Repeat([]byte{0xcc}, 32))
+		httpClient := &http.Client{Transport: sdk.NewPrivTransport(nil, wrongKey, addr, h.Clock)}
+
+		_, err := httpClient.Do(newRequest(t, 2*time.Millisecond, "secret payload"))
+		assert.Err(t, err, protocol.KRBAPErrBadIntegrity)
+	})
+
+	t.Run("PlaintextResponseRejected", func(t *testing.T) {
+		plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("forged"))
+		}))
+		t.Cleanup(plain.Close)
+
+		req, _ := http.NewRequestWithContext(t.Context(), http.MethodGet, plain.URL, nil)
+		httpClient := &http.Client{Transport: sdk.NewPrivTransport(nil, sessionKey, addr, h.Clock)}
+
+		_, err := httpClient.Do(req)
+		assert.Err(t, err, sdk.ErrResponseNotPrivate)
+	})
+
+	t.Run("PlaintextErrorRejected", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			status int
+		}{
+			{"NotFound", http.StatusNotFound},
+			{"InternalServerError", http.StatusInternalServerError},
+			{"UnauthorizedWithoutKRBError", http.StatusUnauthorized},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(tt.status)
+					_, _ = w.Write([]byte("forged"))
+				}))
+				t.Cleanup(plain.Close)
+
+				req, _ := http.NewRequestWithContext(t.Context(), http.MethodGet, plain.URL, nil)
+				httpClient := &http.Client{Transport: sdk.NewPrivTransport(nil, sessionKey, addr, h.Clock)}
+
+				_, err := httpClient.Do(req)
+				assert.Err(t, err, sdk.ErrResponseNotPrivate)
+			})
+		}
+	})
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
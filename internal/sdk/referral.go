@@ -0,0 +1,181 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+var (
+	ErrNotATGT           = errors.New("credentials are not a ticket-granting ticket")
+	ErrTooManyReferrals  = errors.New("too many referrals")
+	ErrReferralLoop      = errors.New("referral loop")
+	ErrUnexpectedReply   = errors.New("unexpected KDC reply")
+	ErrNonceMismatch     = errors.New("KDC reply nonce does not match the request")
+	ErrInvalidReplyPart  = errors.New("failed to decrypt KDC reply part")
+	ErrMissingSessionKey = errors.New("credentials have no session key")
+)
+
+// maxReferrals bounds how many realms a single ticket request may cross.
+const maxReferrals = 10
+
+// Credentials is a ticket together with what its holder needs to use it.
+type Credentials struct {
+	Client     protocol.Principal
+	Server     protocol.Principal
+	Ticket     protocol.EncryptedData
+	SessionKey protocol.SessionKey
+	// RepPart is the reply part the ticket was issued with. It is zero for
+	// credentials that did not come from ServiceTicket.
+	RepPart protocol.EncKDCRepPart
+}
+
+// ServiceTicket asks for a ticket to server using tgt, a TGT for
+// krbtgt/R@I that is sent to the KDC of realm R. When the KDC answers with a
+// referral TGT to another realm's ticket-granting service, the request is
+// repeated there until a KDC issues the ticket itself.
+func (kdc *Kdc) ServiceTicket(
+	ctx context.Context,
+	tgt Credentials,
+	server protocol.Principal,
+	addr protocol.Address,
+	options protocol.KDCOptions,
+) (Credentials, error) {
+	creds := tgt
+	seen := map[protocol.Principal]bool{tgt.Server: true}
+
+	for range maxReferrals {
+		next, err := kdc.tgsExchange(ctx, creds, server, addr, options)
+		if err != nil {
+			return Credentials{}, err
+		}
+
+		switch {
+		case next.Server == server:
+			return next, nil
+		case !next.Server.IsKrbtgt():
+			return Credentials{}, fmt.Errorf("%w: ticket is for %s, not %s", ErrUnexpectedReply, next.Server, server)
+		case seen[next.Server]:
+			return Credentials{}, fmt.Errorf("%w: %s", ErrReferralLoop, next.Server)
+		}
+
+		seen[next.Server] = true
+		creds = next
+	}
+
+	return Credentials{}, fmt.Errorf("%w: gave up after %d realms", ErrTooManyReferrals, maxReferrals)
+}
+
+// tgsExchange sends one TGS-REQ for server to the KDC that tgt is for.
+func (kdc *Kdc) tgsExchange(
+	ctx context.Context,
+	tgt Credentials,
+	server protocol.Principal,
+	addr protocol.Address,
+	options protocol.KDCOptions,
+) (Credentials, error) {
+	nonce, err := protocol.NewNonce(rand.Int32N(math.MaxInt32) + 1)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	req, err := protocol.NewTGSReq(server, tgt.Ticket, protocol.EncryptedData{}, nonce)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	return kdc.TGSExchange(ctx, tgt, req.WithOptions(options), addr)
+}
+
+// TGSExchange sends req, a TGS-REQ presenting the TGT of tgt, to the KDC
+// that tgt is for and opens the reply. The authenticator, from tgt.Client
+// at addr, is sealed here, so every other field of req must be set. With
+// FAST configured the request is armored.
+func (kdc *Kdc) TGSExchange(
+	ctx context.Context,
+	tgt Credentials,
+	req protocol.TGSReq,
+	addr protocol.Address,
+) (Credentials, error) {
+	if !tgt.Server.IsKrbtgt() {
+		return Credentials{}, fmt.Errorf("%w: %s", ErrNotATGT, tgt.Server)
+	}
+	if tgt.SessionKey.IsZero() {
+		return Credentials{}, ErrMissingSessionKey
+	}
+
+	auth, err := protocol.NewAuthenticator(tgt.Client, addr, time.Now().UTC())
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	// The TGT is for krbtgt/R@I: it is redeemed at R, which must be told
+	// that I issued it.
+	realm := protocol.Realm(tgt.Server.Instance())
+	if issuer := tgt.Server.Realm(); issuer != realm {
+		req = req.WithTGTRealm(issuer)
+	}
+
+	// Under FAST the authenticator carries a subkey, which the armor key is
+	// derived from and the reply comes back under.
+	replyKey, replyUsage := tgt.SessionKey, crypto.KeyUsageTGSRepEncPart
+	var subkey protocol.SessionKey
+	if kdc.cfg.FAST {
+		subkey, err = crypto.NewKeyGenerator().Generate(tgt.SessionKey.EncType())
+		if err != nil {
+			return Credentials{}, err
+		}
+		auth = auth.WithSubkey(subkey)
+		replyKey, replyUsage = subkey, crypto.KeyUsageTGSRepEncPartSub
+	}
+
+	req, err = shared.SealTGSAuthenticator(codec.JSON, req, tgt.SessionKey, auth)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("failed to seal authenticator: %w", err)
+	}
+
+	var rep *protocol.TGSRep
+	if kdc.cfg.FAST {
+		armorKey, aErr := shared.ArmorKey(subkey, tgt.SessionKey)
+		if aErr != nil {
+			return Credentials{}, aErr
+		}
+		rep, err = kdc.postFastTGS(ctx, realm, req, armorKey)
+	} else {
+		rep, err = kdc.PostTGSTo(ctx, realm, req)
+	}
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	repBytes, err := crypto.Decrypt(replyKey, replyUsage, rep.SecretPart().Ciphertext())
+	if err != nil {
+		return Credentials{}, fmt.Errorf("%w: %w", ErrInvalidReplyPart, err)
+	}
+
+	var repPart protocol.EncKDCRepPart
+	if err := json.Unmarshal(repBytes, &repPart); err != nil {
+		return Credentials{}, fmt.Errorf("%w: %w", ErrInvalidReplyPart, err)
+	}
+
+	if repPart.Nonce() != req.Nonce() {
+		return Credentials{}, ErrNonceMismatch
+	}
+
+	return Credentials{
+		Client:     tgt.Client,
+		Server:     repPart.Server(),
+		Ticket:     rep.Ticket(),
+		SessionKey: repPart.SessionKey(),
+		RepPart:    repPart,
+	}, nil
+}
@@ -0,0 +1,129 @@
+package sdk_test
+
+import (
+	"bytes"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/clock"
+	"github.com/rizesql/kerberos/internal/codec"
+	"github.com/rizesql/kerberos/internal/crypto"
+	"github.com/rizesql/kerberos/internal/kdc"
+	kdc_http "github.com/rizesql/kerberos/internal/kdc/http"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
+	"github.com/rizesql/kerberos/internal/protocol"
+	"github.com/rizesql/kerberos/internal/sdk"
+	"github.com/rizesql/kerberos/internal/testkit"
+)
+
+func TestKdc_ServiceTicketFollowsReferrals(t *testing.T) {
+	key := func(b byte) protocol.SessionKey {
+		k, _ := protocol.NewSessionKey(bytes.Repeat([]byte{b}, 32))
+		return k
+	}
+	athenaKey, salesKey, interRealmKey, serviceKey, tgtSessionKey := key(1), key(2), key(3), key(4), key(5)
+
+	client, _ := protocol.NewPrincipal("alice", "", "ATHENA.MIT.EDU")
+	service, _ := protocol.NewPrincipal("http", "app", "SALES.EXAMPLE.COM")
+	athenaTGS, _ := protocol.NewKrbtgt("ATHENA.MIT.EDU")
+	salesTGS, _ := protocol.NewKrbtgt("SALES.EXAMPLE.COM")
+	interRealmTGS, _ := protocol.NewInterRealmKrbtgt("SALES.EXAMPLE.COM", "ATHENA.MIT.EDU")
+	athenaUnknown, _ := protocol.NewPrincipal("http", "unknown", "ATHENA.MIT.EDU")
+	addr, _ := protocol.NewAddress(net.IPv4(127, 0, 0, 1))
+
+	// newKDC serves realm from a fresh database seeded with keys. The test
+	// authenticators use the wall clock, so the KDCs do too.
+	newKDC := func(realm protocol.Realm, keys map[protocol.Principal]protocol.SessionKey) *httptest.Server {
+		h := testkit.NewHarness(t)
+		for p, k := range keys {
+			h.CreatePrincipal(t.Context(), testkit.PrincipalParams{
+				PrimaryName: string(p.Primary()),
+				Instance:    string(p.Instance()),
+				Realm:       string(p.Realm()),
+				KeyBytes:    k.Expose(),
+				Kvno:        1,
+			})
+		}
+
+		platform := h.NewKDCPlatform()
+		platform.Clock = clock.New()
+
+		srv := h.NewServer()
+		kdc_http.Register(srv, platform, kdc.Config{Realm: realm, TicketLifetime: time.Hour})
+
+		ts := httptest.NewServer(srv.Mux())
+		t.Cleanup(ts.Close)
+		return ts
+	}
+
+	athena := newKDC("ATHENA.MIT.EDU", map[protocol.Principal]protocol.SessionKey{
+		athenaTGS:     athenaKey,
+		interRealmTGS: interRealmKey,
+	})
+	sales := newKDC("SALES.EXAMPLE.COM", map[protocol.Principal]protocol.SessionKey{
+		salesTGS:      salesKey,
+		interRealmTGS: interRealmKey,
+		service:       serviceKey,
+	})
+
+	ticket, _ := protocol.NewTicket(athenaTGS, client, addr, time.Now(), time.Hour, tgtSessionKey)
+	encTGT, _ := shared.EncryptEntity(codec.JSON, athenaKey, crypto.KeyUsageTicket, ticket)
+
+	s := sdk.New(
+		sdk.WithServerUrl(athena.URL),
+		sdk.WithRealmServerUrl("SALES.EXAMPLE.COM", sales.URL),
+	)
+
+	creds, err := s.Kdc.ServiceTicket(t.Context(), sdk.Credentials{
+		Client:     client,
+		Server:     athenaTGS,
+		Ticket:     encTGT,
+		SessionKey: tgtSessionKey,
+	}, service, addr, 0)
+	assert.Err(t, err, nil)
+	assert.Equal(t, creds.Server, service)
+	assert.Equal(t, creds.RepPart.Server(), service)
+
+	serviceTicket, err := shared.DecryptEntity[protocol.Ticket](serviceKey, crypto.KeyUsageTicket, creds.Ticket)
+	assert.Err(t, err, nil)
+	assert.Equal(t, serviceTicket.Client(), client)
+	assert.Equal(t, string(serviceTicket.SessionKey().Expose()), string(creds.SessionKey.Expose()))
+
+	t.Run("FAST", func(t *testing.T) {
+		fast := sdk.New(
+			sdk.WithServerUrl(athena.URL),
+			sdk.WithRealmServerUrl("SALES.EXAMPLE.COM", sales.URL),
+			sdk.WithFAST(),
+		)
+
+		creds, err := fast.Kdc.ServiceTicket(t.Context(), sdk.Credentials{
+			Client:     client,
+			Server:     athenaTGS,
+			Ticket:     encTGT,
+			SessionKey: tgtSessionKey,
+		}, service, addr, 0)
+		assert.Err(t, err, nil)
+		assert.Equal(t, creds.Server, service)
+
+		_, err = fast.Kdc.ServiceTicket(t.Context(), sdk.Credentials{
+			Client:     client,
+			Server:     athenaTGS,
+			Ticket:     encTGT,
+			SessionKey: tgtSessionKey,
+		}, athenaUnknown, addr, 0)
+		assert.Err(t, err, protocol.KDCErrSPrincipalUnknown)
+	})
+
+	t.Run("NotATGT", func(t *testing.T) {
+		_, err := s.Kdc.ServiceTicket(t.Context(), sdk.Credentials{
+			Client:     client,
+			Server:     service,
+			Ticket:     creds.Ticket,
+			SessionKey: creds.SessionKey,
+		}, service, addr, 0)
+		assert.Err(t, err, sdk.ErrNotATGT)
+	})
+}
@@ -3,7 +3,10 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+
+	"github.com/rizesql/kerberos/internal/codec"
 )
 
 type ErrorResponse struct {
@@ -41,3 +44,32 @@ func Decode[T any](r *http.Request) (T, error) {
 
 	return v, nil
 }
+
+// EncodeWith writes v encoded with c, for endpoints that answer in the
+// encoding they were spoken to in.
+func EncodeWith(w http.ResponseWriter, status int, c codec.Codec, v any) error {
+	data, err := c.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", c.ContentType(), err)
+	}
+
+	w.Header().Set("Content-Type", c.ContentType())
+	w.WriteHeader(status)
+
+	_, err = w.Write(data)
+	return err
+}
+
+func DecodeWith[T any](r *http.Request, c codec.Codec) (T, error) {
+	var v T
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return v, fmt.Errorf("read body: %w", err)
+	}
+
+	if err := c.Unmarshal(data, &v); err != nil {
+		return v, fmt.Errorf("decode %s: %w", c.ContentType(), err)
+	}
+
+	return v, nil
+}
@@ -3,17 +3,21 @@ package testkit
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"database/sql"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"testing"
 
 	"github.com/rizesql/kerberos/internal/assert"
 	"github.com/rizesql/kerberos/internal/clock"
+	"github.com/rizesql/kerberos/internal/codec"
 	"github.com/rizesql/kerberos/internal/crypto"
 	"github.com/rizesql/kerberos/internal/kdb"
 	"github.com/rizesql/kerberos/internal/kdc"
+	"github.com/rizesql/kerberos/internal/kdc/shared"
 	"github.com/rizesql/kerberos/internal/o11y/logging"
+	"github.com/rizesql/kerberos/internal/protocol"
 	"github.com/rizesql/kerberos/internal/replay"
 	"github.com/rizesql/kerberos/internal/server"
 )
@@ -43,6 +47,12 @@ func NewHarness(t *testing.T) *Harness {
 	err = db.Migrate()
 	assert.Err(t, err, nil)
 
+	// Keys are sealed under a fixed master key, as `kdc setup` would make.
+	masterKey, err := crypto.NewTestKeyGenerator().Generate(kdb.MasterKeyEncType)
+	assert.Err(t, err, nil)
+	assert.Err(t, kdb.AddMasterKey(t.Context(), db, 1, masterKey, "", nil), nil)
+	assert.Err(t, db.Unlock(t.Context(), kdb.NewMasterKeys(1, masterKey)), nil)
+
 	t.Cleanup(func() {
 		if err := db.Close(); err != nil {
 			t.Error(err)
@@ -84,11 +94,15 @@ func Call[Req, Res any](t *testing.T, srv *server.Server, r server.Route, header
 
 	rr := httptest.NewRecorder()
 
-	body := new(bytes.Buffer)
-	err := json.NewEncoder(body).Encode(req)
+	// The request is encoded as its Content-Type header asks, JSON by default.
+	c := codec.JSON
+	if headers != nil {
+		c = codec.ForContentType(headers.Get("Content-Type"))
+	}
+	body, err := c.Marshal(req)
 	assert.Err(t, err, nil)
 
-	httpReq := httptest.NewRequest(r.Method(), r.Path(), body)
+	httpReq := httptest.NewRequest(r.Method(), r.Path(), bytes.NewReader(body))
 	if headers != nil {
 		httpReq.Header = headers
 	}
@@ -104,7 +118,8 @@ func Call[Req, Res any](t *testing.T, srv *server.Server, r server.Route, header
 
 	if len(rawBody) > 0 {
 		var responseBody Res
-		if err := json.Unmarshal(rawBody, &responseBody); err == nil {
+		rc := codec.ForContentType(rr.Header().Get("Content-Type"))
+		if err := rc.Unmarshal(rawBody, &responseBody); err == nil {
 			res.Body = &responseBody
 		}
 	}
@@ -121,9 +136,100 @@ type TestResponse[TBody any] struct {
 
 // --- Data Helpers ---
 
-func (h *Harness) CreatePrincipal(ctx context.Context, params kdb.CreatePrincipalParams) kdb.Principal {
+// PrincipalParams describes a principal with a single key. A zero EncType is
+// AES-256-GCM.
+type PrincipalParams struct {
+	PrimaryName      string
+	Instance         string
+	Realm            string
+	KeyBytes         []byte
+	EncType          protocol.EncType
+	Kvno             int64
+	MaxLife          sql.NullInt64
+	MaxRenewableLife sql.NullInt64
+	Flags            kdb.PrincipalFlag
+}
+
+// CreatePrincipal creates a principal and its key.
+func (h *Harness) CreatePrincipal(ctx context.Context, params PrincipalParams) kdb.Principal {
 	h.t.Helper()
-	p, err := kdb.Query.CreatePrincipal(ctx, h.DB, params)
+	p, err := kdb.Query.CreatePrincipal(ctx, h.DB, kdb.CreatePrincipalParams{
+		PrimaryName:      params.PrimaryName,
+		Instance:         params.Instance,
+		Realm:            params.Realm,
+		Kvno:             params.Kvno,
+		MaxLife:          params.MaxLife,
+		MaxRenewableLife: params.MaxRenewableLife,
+	})
 	assert.Err(h.t, err, nil)
+
+	if params.Flags != 0 {
+		_, err = kdb.Query.UpdatePrincipalFlags(ctx, h.DB, kdb.UpdatePrincipalFlagsParams{
+			Flags:       int64(params.Flags),
+			PrimaryName: params.PrimaryName,
+			Instance:    params.Instance,
+			Realm:       params.Realm,
+		})
+		assert.Err(h.t, err, nil)
+		p.Flags = int64(params.Flags)
+	}
+
+	etype := params.EncType
+	if etype == 0 {
+		etype = protocol.EncTypeAES256GCM
+	}
+	h.AddKey(ctx, p, p.Kvno, etype, params.KeyBytes)
 	return p
 }
+
+// AddKey gives p a key of version kvno, sealed under the master key.
+func (h *Harness) AddKey(ctx context.Context, p kdb.Principal, kvno int64, etype protocol.EncType, key []byte) {
+	h.t.Helper()
+	mkvno, sealed, err := h.DB.MasterKeys().Seal(key)
+	assert.Err(h.t, err, nil)
+
+	err = kdb.Query.AddKey(ctx, h.DB, kdb.AddKeyParams{
+		PrincipalID: p.ID,
+		Kvno:        kvno,
+		Enctype:     int64(etype),
+		Mkvno:       mkvno,
+		KeyBytes:    sealed,
+	})
+	assert.Err(h.t, err, nil)
+}
+
+// AddToGroup makes p a member of group, creating the group if needed.
+func (h *Harness) AddToGroup(ctx context.Context, group string, p kdb.Principal) {
+	h.t.Helper()
+	groups, err := kdb.Query.ListGroups(ctx, h.DB)
+	assert.Err(h.t, err, nil)
+	if !slices.Contains(groups, group) {
+		assert.Err(h.t, kdb.Query.CreateGroup(ctx, h.DB, group), nil)
+	}
+
+	added, err := kdb.Query.AddGroupMember(ctx, h.DB, kdb.AddGroupMemberParams{
+		Name:        group,
+		PrimaryName: p.PrimaryName,
+		Instance:    p.Instance,
+		Realm:       p.Realm,
+	})
+	assert.Err(h.t, err, nil)
+	assert.Equal(h.t, added, int64(1))
+}
+
+// SignTGSReq reseals the authenticator of req under key with a checksum of
+// the request body as it stands, the way a client does once every field is
+// set. Requests whose authenticator does not open under key are returned
+// unchanged, so tests of broken authenticators still see them.
+func SignTGSReq(t *testing.T, req protocol.TGSReq, key protocol.SessionKey) protocol.TGSReq {
+	t.Helper()
+
+	auth, err := shared.DecryptEntity[protocol.Authenticator](key, crypto.KeyUsageTGSReqAuth, req.Authenticator())
+	if err != nil {
+		return req
+	}
+
+	signed, err := shared.SealTGSAuthenticator(codec.JSON, req, key, auth)
+	assert.Err(t, err, nil)
+	return signed
+}
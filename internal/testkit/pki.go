@@ -0,0 +1,92 @@
+package testkit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/rizesql/kerberos/internal/assert"
+	"github.com/rizesql/kerberos/internal/pkinit"
+	"github.com/rizesql/kerberos/internal/protocol"
+)
+
+// PKI is a certificate authority minted at test time, which issues the
+// certificates of a PKINIT exchange. Its certificates are valid for a day
+// around the time it was made at.
+type PKI struct {
+	t      *testing.T
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	now    time.Time
+	serial int64
+
+	// Roots trusts the certificates the PKI issues.
+	Roots *x509.CertPool
+}
+
+func NewPKI(t *testing.T, name string, now time.Time) *PKI {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Err(t, err, nil)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	assert.Err(t, err, nil)
+
+	cert, err := x509.ParseCertificate(der)
+	assert.Err(t, err, nil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	return &PKI{t: t, cert: cert, key: key, now: now, serial: 1, Roots: roots}
+}
+
+// Issue issues a certificate for usage, an extended key usage such as
+// pkinit.OIDKPClientAuth, with the common name cn. A non-zero san is named
+// in its id-pkinit-san.
+func (pki *PKI) Issue(cn string, san protocol.Principal, usage asn1.ObjectIdentifier) tls.Certificate {
+	pki.t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Err(pki.t, err, nil)
+
+	pki.serial++
+	template := &x509.Certificate{
+		SerialNumber:       big.NewInt(pki.serial),
+		Subject:            pkix.Name{CommonName: cn},
+		NotBefore:          pki.now.Add(-time.Hour),
+		NotAfter:           pki.now.Add(24 * time.Hour),
+		KeyUsage:           x509.KeyUsageDigitalSignature,
+		UnknownExtKeyUsage: []asn1.ObjectIdentifier{usage},
+	}
+	if san != (protocol.Principal{}) {
+		ext, err := pkinit.SANExtension(san)
+		assert.Err(pki.t, err, nil)
+		template.ExtraExtensions = append(template.ExtraExtensions, ext)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, pki.cert, key.Public(), pki.key)
+	assert.Err(pki.t, err, nil)
+
+	leaf, err := x509.ParseCertificate(der)
+	assert.Err(pki.t, err, nil)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}